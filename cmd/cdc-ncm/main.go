@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 )
 
 func checkRoot() {
@@ -24,9 +25,11 @@ func checkLinux() {
 	}
 }
 
-// accepts one cmd line argument: --prometheusport=8080
+// accepts two cmd line arguments: --prometheusport=8080
 // if specified, prometheus metrics will be available at http://0.0.0.0:prometheusport/metrics
 // if not specified, the prometheus endpoint will not be started and not be available.
+// --usb=usbfs selects how usb devices are accessed, by default libusb is used if it was compiled in
+// with the libusb build tag and the pure go usbfs backend otherwise.
 func main() {
 	checkLinux()
 	checkUsbMux()
@@ -34,6 +37,7 @@ func main() {
 	// Define a string flag with a default value and a short description.
 	// This will read the command-line argument for --prometheusport.
 	prometheusPort := flag.Int("prometheusport", -1, "The port for Prometheus metrics")
+	usbBackend := flag.String("usb", ncm.BackendAuto, "The usb backend, one of "+ncm.BackendAuto+", "+strings.Join(ncm.Backends(), ", "))
 	// Parse the flags from the command-line arguments.
 	flag.Parse()
 	if *prometheusPort != -1 {
@@ -43,7 +47,8 @@ func main() {
 	}
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
-	err := ncm.Start(c)
+	slog.Info("usb backend", slog.String("backend", *usbBackend), slog.Any("available", ncm.Backends()))
+	err := ncm.StartWithBackend(c, *usbBackend)
 	if err != nil {
 		slog.Error("error looking for devices", slog.Any("error", err))
 		os.Exit(1)
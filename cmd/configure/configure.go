@@ -16,12 +16,16 @@ func main() {
 		fail("dpkg needs to be installed to check dependencies")
 	}
 
-	checkDep("libusb-1.0-0-dev")
-	checkDep("build-essential")
-	checkDep("pkg-config")
+	// libusb is only needed for 'make build-libusb', the default build uses the pure go usbfs backend
+	if len(os.Args) > 1 && os.Args[1] == "libusb" {
+		checkDep("libusb-1.0-0-dev")
+		checkDep("build-essential")
+		checkDep("pkg-config")
+		log.Println("good to go. run 'make build-libusb'")
+		return
+	}
 
 	log.Println("good to go. run 'make'")
-	//apt-get install -y libusb-1.0-0-dev
 
 }
 
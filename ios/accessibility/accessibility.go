@@ -13,7 +13,7 @@ func New(device ios.DeviceEntry) (ControlInterface, error) {
 	if err != nil {
 		return ControlInterface{}, err
 	}
-	control := ControlInterface{conn.GlobalChannel()}
+	control := ControlInterface{channel: conn.GlobalChannel(), conn: conn}
 	err = control.init()
 	return control, err
 }
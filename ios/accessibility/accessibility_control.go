@@ -12,6 +12,15 @@ import (
 // It only needs the global dtx channel as all AX methods are invoked on it.
 type ControlInterface struct {
 	channel *dtx.Channel
+	conn    *dtx.Connection
+}
+
+// Close closes the connection to the AX service
+func (a ControlInterface) Close() error {
+	if a.conn == nil {
+		return nil
+	}
+	return a.conn.Close()
 }
 
 func (a ControlInterface) readhostAppStateChanged() {
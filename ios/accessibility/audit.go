@@ -0,0 +1,202 @@
+package accessibility
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/nskeyedarchiver"
+)
+
+// the categories of AuditIssue
+const (
+	CategoryMissingLabel = "missing-label"
+	CategoryContrast     = "contrast"
+	CategoryTouchTarget  = "touch-target"
+	CategoryDynamicType  = "dynamic-type"
+	CategoryClippedText  = "clipped-text"
+	CategoryTrait        = "trait"
+	CategoryOther        = "other"
+)
+
+// auditCategories maps the audit types of the AX service to the categories of the issues they find
+var auditCategories = map[string]string{
+	"testTypeSufficientElementDescription": CategoryMissingLabel,
+	"testTypeElementDetection":             CategoryMissingLabel,
+	"testTypeContrast":                     CategoryContrast,
+	"testTypeHitRegion":                    CategoryTouchTarget,
+	"testTypeDynamicText":                  CategoryDynamicType,
+	"testTypeTextClipped":                  CategoryClippedText,
+	"testTypeTrait":                        CategoryTrait,
+}
+
+// Rect is the frame of an element in screen points
+type Rect struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// AuditIssue is an accessibility problem the audit found on the screen
+type AuditIssue struct {
+	// Type is the audit of the AX service that found the issue, f.ex. testTypeContrast
+	Type     string `json:"type"`
+	Category string `json:"category"`
+	// Element describes the element like VoiceOver reads it, it is empty for elements without label
+	Element string `json:"element,omitempty"`
+	// Rect is the frame of the element, the size of too small touch targets
+	Rect            *Rect   `json:"rect,omitempty"`
+	FontSize        float64 `json:"fontSize,omitempty"`
+	ForegroundColor string  `json:"foregroundColor,omitempty"`
+	BackgroundColor string  `json:"backgroundColor,omitempty"`
+	// Description is the explanation of the issue, a label suggested by the device if it has one
+	Description string `json:"description,omitempty"`
+}
+
+// SetAuditTarget restricts audits to the process with the given pid, 0 audits the whole screen
+func (a ControlInterface) SetAuditTarget(pid uint64) error {
+	return a.deviceSetAuditTargetPid(pid)
+}
+
+// Audit runs all audits the device supports on the current screen and returns the issues sorted by category.
+// It fails if the device does not finish the audit within timeout.
+func (a ControlInterface) Audit(timeout time.Duration) ([]AuditIssue, error) {
+	capabilities, err := a.deviceCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	// iOS 15 and later audit by types, older versions by case ids
+	listMethod, beginMethod, completedMethod := "deviceAllAuditCaseIDs", "deviceBeginAuditCaseIDs:", "hostDeviceDidCompleteAuditCaseIDsWithAuditIssues:"
+	for _, capability := range capabilities {
+		if capability == "deviceBeginAuditTypes:" {
+			listMethod, beginMethod, completedMethod = "deviceAllSupportedAuditTypes", "deviceBeginAuditTypes:", "hostDeviceDidCompleteAuditCategoriesWithAuditIssues:"
+			break
+		}
+	}
+	response, err := a.channel.MethodCall(listMethod)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Payload) == 0 {
+		return nil, fmt.Errorf("Audit: %s returned nothing", listMethod)
+	}
+	types, _ := unwrapAXValue(response.Payload[0]).([]interface{})
+	auditTypes := make([]interface{}, 0, len(types))
+	for _, t := range types {
+		if name, ok := t.(string); ok {
+			auditTypes = append(auditTypes, passthrough(name))
+		}
+	}
+
+	a.channel.RegisterMethodForRemote(completedMethod)
+	completed := make(chan []interface{}, 1)
+	go func() {
+		msg := a.channel.ReceiveMethodCall(completedMethod)
+		arguments := msg.Auxiliary.GetArguments()
+		if len(arguments) == 0 {
+			completed <- nil
+			return
+		}
+		b, _ := arguments[0].([]byte)
+		result, err := nskeyedarchiver.Unarchive(b)
+		if err != nil || len(result) == 0 {
+			completed <- nil
+			return
+		}
+		issues, _ := unwrapAXValue(result[0]).([]interface{})
+		completed <- issues
+	}()
+	err = a.channel.MethodCallAsync(beginMethod, auditTypes)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case issues := <-completed:
+		return parseAuditIssues(issues), nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("Audit: device did not finish the audit within %s", timeout)
+	}
+}
+
+func passthrough(value interface{}) interface{} {
+	return nskeyedarchiver.NewNSMutableDictionary(map[string]interface{}{"ObjectType": "passthrough", "Value": value})
+}
+
+// unwrapAXValue removes the ObjectType and Value wrappers the AX service puts around every value
+func unwrapAXValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if _, ok := v["ObjectType"]; ok {
+			return unwrapAXValue(v["Value"])
+		}
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = unwrapAXValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = unwrapAXValue(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+func parseAuditIssues(values []interface{}) []AuditIssue {
+	issues := []AuditIssue{}
+	for _, value := range values {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		issue := AuditIssue{}
+		issue.Type, _ = fields["AuditTestTypeValue_v1"].(string)
+		issue.Category = auditCategories[issue.Type]
+		if issue.Category == "" {
+			issue.Category = CategoryOther
+		}
+		issue.Element, _ = fields["ElementDescriptionValue_v1"].(string)
+		if rect, ok := fields["ElementRectValue_v1"].(string); ok {
+			issue.Rect = parseRect(rect)
+		}
+		issue.FontSize = toFloat(fields["FontSizeValue_v1"])
+		issue.ForegroundColor, _ = fields["ForegroundColorValue_v1"].(string)
+		issue.BackgroundColor, _ = fields["BackgroundColorValue_v1"].(string)
+		issue.Description, _ = fields["LongDescriptionExtraInfo_v1"].(string)
+		if suggested, ok := fields["MLGeneratedDescriptionValue_v1"].(string); ok && issue.Description == "" {
+			issue.Description = suggested
+		}
+		issues = append(issues, issue)
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Category < issues[j].Category })
+	return issues
+}
+
+// parseRect parses a CGRect string like {{10, 20}, {44, 30}}
+func parseRect(s string) *Rect {
+	var r Rect
+	_, err := fmt.Sscanf(s, "{{%g, %g}, {%g, %g}}", &r.X, &r.Y, &r.Width, &r.Height)
+	if err != nil {
+		return nil
+	}
+	return &r
+}
+
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,44 @@
+package accessibility
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func wrapped(value interface{}) map[string]interface{} {
+	return map[string]interface{}{"ObjectType": "passthrough", "Value": value}
+}
+
+func TestParseAuditIssues(t *testing.T) {
+	// the issues as the device sends them, every value is wrapped
+	raw := []interface{}{
+		map[string]interface{}{"ObjectType": "AXAuditIssue_v1", "Value": wrapped(map[string]interface{}{
+			"AuditTestTypeValue_v1":      wrapped("testTypeHitRegion"),
+			"ElementRectValue_v1":        wrapped("{{16, 52.5}, {24, 24}}"),
+			"ElementDescriptionValue_v1": wrapped("Close"),
+		})},
+		map[string]interface{}{"ObjectType": "AXAuditIssue_v1", "Value": wrapped(map[string]interface{}{
+			"AuditTestTypeValue_v1":          wrapped("testTypeSufficientElementDescription"),
+			"ElementRectValue_v1":            wrapped("not a rect"),
+			"MLGeneratedDescriptionValue_v1": wrapped("Share"),
+		})},
+		map[string]interface{}{"ObjectType": "AXAuditIssue_v1", "Value": wrapped(map[string]interface{}{
+			"AuditTestTypeValue_v1":       wrapped("testTypeContrast"),
+			"FontSizeValue_v1":            wrapped(float64(11)),
+			"LongDescriptionExtraInfo_v1": wrapped("Contrast ratio 2.1:1"),
+		})},
+		map[string]interface{}{"ObjectType": "AXAuditIssue_v1", "Value": wrapped(map[string]interface{}{
+			"AuditTestTypeValue_v1": wrapped("testTypeSomethingNew"),
+		})},
+		"garbage",
+	}
+	values, _ := unwrapAXValue(raw).([]interface{})
+	assert.Equal(t, []AuditIssue{
+		{Type: "testTypeContrast", Category: CategoryContrast, FontSize: 11, Description: "Contrast ratio 2.1:1"},
+		{Type: "testTypeSufficientElementDescription", Category: CategoryMissingLabel, Description: "Share"},
+		{Type: "testTypeSomethingNew", Category: CategoryOther},
+		{Type: "testTypeHitRegion", Category: CategoryTouchTarget, Element: "Close", Rect: &Rect{X: 16, Y: 52.5, Width: 24, Height: 24}},
+	}, parseAuditIssues(values))
+	assert.Equal(t, []AuditIssue{}, parseAuditIssues(nil))
+}
@@ -22,6 +22,11 @@ const (
 	Afc_operation_file_write               uint64 = 0x00000010
 	Afc_operation_file_open_result         uint64 = 0x0000000E
 	Afc_operation_file_read                uint64 = 0x0000000F
+	Afc_operation_file_seek                uint64 = 0x00000011
+	Afc_operation_file_tell                uint64 = 0x00000012
+	Afc_operation_file_tell_result         uint64 = 0x00000013
+	Afc_operation_file_set_size            uint64 = 0x00000015
+	Afc_operation_rename_path              uint64 = 0x00000018
 	Afc_operation_remove_path_and_contents uint64 = 0x00000022
 )
 
@@ -72,6 +77,9 @@ type AFCDeviceInfo struct {
 	BlockSize  uint64
 }
 
+// ErrObjectNotFound is returned if a path does not exist on the device
+var ErrObjectNotFound = errors.New("ObjectNotFound")
+
 func getError(errorCode uint64) error {
 	switch errorCode {
 	case Afc_Err_UnknownError:
@@ -89,7 +97,7 @@ func getError(errorCode uint64) error {
 	case Afc_Err_InvalidArgument:
 		return errors.New("InvalidArgument")
 	case Afc_Err_ObjectNotFound:
-		return errors.New("ObjectNotFound")
+		return ErrObjectNotFound
 	case Afc_Err_ObjectIsDir:
 		return errors.New("ObjectIsDir")
 	case Afc_Err_PermDenied:
@@ -0,0 +1,29 @@
+// Package afcfuse mounts afc file systems, like the media directory of a device or the container of an app,
+// as local file systems with FUSE. Shell tools and rsync can then work on device files directly.
+// Mounting needs FUSE on linux or macFUSE on macOS, other systems return ErrUnsupported.
+package afcfuse
+
+import (
+	"errors"
+)
+
+// ErrUnsupported is returned by Mount on systems without FUSE support
+var ErrUnsupported = errors.New("afcfuse: mounting is only supported on linux and macOS")
+
+// Options configures a mount
+type Options struct {
+	// Name is shown as source of the mount, f.ex. in the output of mount
+	Name string
+	// ReadOnly mounts the file system read only
+	ReadOnly bool
+	// Debug logs all FUSE requests
+	Debug bool
+}
+
+// Server is a mounted file system
+type Server interface {
+	// Unmount unmounts the file system, it fails while files are open
+	Unmount() error
+	// Wait blocks until the file system was unmounted
+	Wait()
+}
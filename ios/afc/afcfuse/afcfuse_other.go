@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package afcfuse
+
+import (
+	"github.com/danielpaulus/go-ios/ios/afc"
+)
+
+// Mount returns ErrUnsupported, FUSE is only available on linux and macOS
+func Mount(conn *afc.Connection, mountpoint string, options Options) (Server, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,357 @@
+//go:build linux || darwin
+
+package afcfuse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/afc"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+)
+
+// Mount mounts the file system of conn at mountpoint and serves it until it is unmounted.
+// conn must not be used by anything else until then, the caller closes it afterwards.
+func Mount(conn *afc.Connection, mountpoint string, options Options) (Server, error) {
+	root := &node{afs: &fileSystem{conn: conn, readOnly: options.ReadOnly}}
+	timeout := time.Second
+	mountOptions := fuse.MountOptions{FsName: options.Name, Name: "afc", Debug: options.Debug}
+	if options.ReadOnly {
+		mountOptions.Options = append(mountOptions.Options, "ro")
+	}
+	server, err := fs.Mount(mountpoint, root, &fs.Options{
+		MountOptions:    mountOptions,
+		EntryTimeout:    &timeout,
+		AttrTimeout:     &timeout,
+		NegativeTimeout: &timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// fileSystem serializes the requests of all nodes, an afc connection handles one request at a time
+type fileSystem struct {
+	mu       sync.Mutex
+	conn     *afc.Connection
+	readOnly bool
+}
+
+// stat fills out with the attributes of the file at p
+func (f *fileSystem) stat(p string, out *fuse.Attr) syscall.Errno {
+	f.mu.Lock()
+	info, err := f.conn.Stat(p)
+	f.mu.Unlock()
+	if err != nil {
+		return errno(err)
+	}
+	out.Mode = syscall.S_IFREG | 0o644
+	if info.IsDir() {
+		out.Mode = syscall.S_IFDIR | 0o755
+	}
+	out.Size = uint64(info.Size())
+	out.Blocks = (out.Size + 511) / 512
+	out.Nlink = 1
+	mtime := info.ModTime()
+	out.SetTimes(&mtime, &mtime, &mtime)
+	out.Owner = fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	return 0
+}
+
+// errno maps afc errors to the errors of file system calls
+func errno(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+	if errors.Is(err, afc.ErrObjectNotFound) {
+		return syscall.ENOENT
+	}
+	// afc status errors are not always wrapped
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "ObjectExists"):
+		return syscall.EEXIST
+	case strings.Contains(message, "DirNotEmpty"):
+		return syscall.ENOTEMPTY
+	case strings.Contains(message, "ObjectIsDir"):
+		return syscall.EISDIR
+	case strings.Contains(message, "PermDenied"):
+		return syscall.EACCES
+	case strings.Contains(message, "NoSpaceLeft"):
+		return syscall.ENOSPC
+	}
+	log.WithError(err).Debug("afc request failed")
+	return syscall.EIO
+}
+
+// node is a file or directory, it finds its afc path through its parents
+type node struct {
+	fs.Inode
+	afs *fileSystem
+}
+
+var (
+	_ fs.NodeGetattrer = (*node)(nil)
+	_ fs.NodeSetattrer = (*node)(nil)
+	_ fs.NodeLookuper  = (*node)(nil)
+	_ fs.NodeReaddirer = (*node)(nil)
+	_ fs.NodeMkdirer   = (*node)(nil)
+	_ fs.NodeCreater   = (*node)(nil)
+	_ fs.NodeOpener    = (*node)(nil)
+	_ fs.NodeUnlinker  = (*node)(nil)
+	_ fs.NodeRmdirer   = (*node)(nil)
+	_ fs.NodeRenamer   = (*node)(nil)
+	_ fs.NodeStatfser  = (*node)(nil)
+)
+
+// path is the afc path of the node, or of its child name
+func (n *node) path(name ...string) string {
+	return "/" + path.Join(append([]string{n.Path(n.Root())}, name...)...)
+}
+
+func (n *node) child(ctx context.Context, mode uint32) *fs.Inode {
+	return n.NewInode(ctx, &node{afs: n.afs}, fs.StableAttr{Mode: mode & syscall.S_IFMT})
+}
+
+func (n *node) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	return n.afs.stat(n.path(), &out.Attr)
+}
+
+// Setattr only changes the size, afc can't change modes, owners or times
+func (n *node) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if size, ok := in.GetSize(); ok {
+		if n.afs.readOnly {
+			return syscall.EROFS
+		}
+		if errno := n.truncate(f, int64(size)); errno != 0 {
+			return errno
+		}
+	}
+	return n.afs.stat(n.path(), &out.Attr)
+}
+
+func (n *node) truncate(f fs.FileHandle, size int64) syscall.Errno {
+	n.afs.mu.Lock()
+	defer n.afs.mu.Unlock()
+	if h, ok := f.(*handle); ok {
+		return errno(h.file.Truncate(size))
+	}
+	file, err := n.afs.conn.Open(n.path(), afc.Afc_Mode_RW)
+	if err != nil {
+		return errno(err)
+	}
+	defer file.Close()
+	return errno(file.Truncate(size))
+}
+
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if errno := n.afs.stat(n.path(name), &out.Attr); errno != 0 {
+		return nil, errno
+	}
+	return n.child(ctx, out.Attr.Mode), 0
+}
+
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	dir := n.path()
+	n.afs.mu.Lock()
+	names, err := n.afs.conn.ReadDir(dir)
+	n.afs.mu.Unlock()
+	if err != nil {
+		return nil, errno(err)
+	}
+	entries := make([]fuse.DirEntry, 0, len(names))
+	for _, name := range names {
+		var attr fuse.Attr
+		if n.afs.stat(path.Join(dir, name), &attr) != 0 {
+			// files can be removed by apps while listing
+			continue
+		}
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: attr.Mode})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (n *node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if n.afs.readOnly {
+		return nil, syscall.EROFS
+	}
+	p := n.path(name)
+	if n.afs.stat(p, &out.Attr) == 0 {
+		return nil, syscall.EEXIST
+	}
+	n.afs.mu.Lock()
+	err := n.afs.conn.MkDir(p)
+	n.afs.mu.Unlock()
+	if err != nil {
+		return nil, errno(err)
+	}
+	if errno := n.afs.stat(p, &out.Attr); errno != 0 {
+		return nil, errno
+	}
+	return n.child(ctx, out.Attr.Mode), 0
+}
+
+func (n *node) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	if n.afs.readOnly {
+		return nil, nil, 0, syscall.EROFS
+	}
+	p := n.path(name)
+	exists := n.afs.stat(p, &out.Attr) == 0
+	if exists && flags&syscall.O_EXCL != 0 {
+		return nil, nil, 0, syscall.EEXIST
+	}
+	h, errno := n.afs.open(p, int(flags)|os.O_CREATE, exists)
+	if errno != 0 {
+		return nil, nil, 0, errno
+	}
+	if errno := n.afs.stat(p, &out.Attr); errno != 0 {
+		h.Release(ctx)
+		return nil, nil, 0, errno
+	}
+	return n.child(ctx, out.Attr.Mode), h, 0, 0
+}
+
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	if n.afs.readOnly && flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	h, errno := n.afs.open(n.path(), int(flags), true)
+	if errno != 0 {
+		return nil, 0, errno
+	}
+	return h, 0, 0
+}
+
+func (f *fileSystem) open(p string, flag int, exists bool) (*handle, syscall.Errno) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := f.conn.Open(p, afc.OpenMode(flag, exists))
+	if err != nil {
+		return nil, errno(err)
+	}
+	return &handle{afs: f, file: file}, 0
+}
+
+func (n *node) Unlink(ctx context.Context, name string) syscall.Errno {
+	return n.remove(name)
+}
+
+func (n *node) Rmdir(ctx context.Context, name string) syscall.Errno {
+	return n.remove(name)
+}
+
+func (n *node) remove(name string) syscall.Errno {
+	if n.afs.readOnly {
+		return syscall.EROFS
+	}
+	n.afs.mu.Lock()
+	defer n.afs.mu.Unlock()
+	return errno(n.afs.conn.Remove(n.path(name)))
+}
+
+func (n *node) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	if n.afs.readOnly {
+		return syscall.EROFS
+	}
+	if flags != 0 {
+		// RENAME_EXCHANGE and RENAME_NOREPLACE have no afc counterpart
+		return syscall.ENOTSUP
+	}
+	dst := "/" + path.Join(newParent.EmbeddedInode().Path(n.Root()), newName)
+	n.afs.mu.Lock()
+	defer n.afs.mu.Unlock()
+	return errno(n.afs.conn.Rename(n.path(name), dst))
+}
+
+func (n *node) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	n.afs.mu.Lock()
+	info, err := n.afs.conn.GetSpaceInfo()
+	n.afs.mu.Unlock()
+	if err != nil {
+		return errno(err)
+	}
+	if info.BlockSize == 0 {
+		return syscall.EIO
+	}
+	out.Bsize = uint32(info.BlockSize)
+	out.Frsize = uint32(info.BlockSize)
+	out.Blocks = info.TotalBytes / info.BlockSize
+	out.Bfree = info.FreeBytes / info.BlockSize
+	out.Bavail = out.Bfree
+	out.NameLen = 255
+	return 0
+}
+
+// handle is an open file, it seeks only if reads or writes don't continue where the last one ended
+type handle struct {
+	afs    *fileSystem
+	file   *afc.File
+	offset int64
+}
+
+var (
+	_ fs.FileReader   = (*handle)(nil)
+	_ fs.FileWriter   = (*handle)(nil)
+	_ fs.FileFlusher  = (*handle)(nil)
+	_ fs.FileFsyncer  = (*handle)(nil)
+	_ fs.FileReleaser = (*handle)(nil)
+)
+
+func (h *handle) seek(off int64) error {
+	if off == h.offset {
+		return nil
+	}
+	offset, err := h.file.Seek(off, io.SeekStart)
+	h.offset = offset
+	return err
+}
+
+func (h *handle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h.afs.mu.Lock()
+	defer h.afs.mu.Unlock()
+	if err := h.seek(off); err != nil {
+		return nil, errno(err)
+	}
+	n, err := io.ReadFull(h.file, dest)
+	h.offset += int64(n)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, errno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+func (h *handle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	h.afs.mu.Lock()
+	defer h.afs.mu.Unlock()
+	if err := h.seek(off); err != nil {
+		return 0, errno(err)
+	}
+	n, err := h.file.Write(data)
+	h.offset += int64(n)
+	return uint32(n), errno(err)
+}
+
+// Flush has nothing to do, writes are sent to the device right away
+func (h *handle) Flush(ctx context.Context) syscall.Errno {
+	return 0
+}
+
+func (h *handle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	return 0
+}
+
+func (h *handle) Release(ctx context.Context) syscall.Errno {
+	h.afs.mu.Lock()
+	defer h.afs.mu.Unlock()
+	return errno(h.file.Close())
+}
@@ -0,0 +1,21 @@
+//go:build linux || darwin
+
+package afcfuse
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios/afc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrno(t *testing.T) {
+	assert.Equal(t, syscall.Errno(0), errno(nil))
+	assert.Equal(t, syscall.ENOENT, errno(fmt.Errorf("stat /DCIM: %w", afc.ErrObjectNotFound)))
+	assert.Equal(t, syscall.ENOTEMPTY, errno(errors.New("afc: operation failed: DirNotEmpty")))
+	assert.Equal(t, syscall.EEXIST, errno(errors.New("afc: operation failed: ObjectExists")))
+	assert.Equal(t, syscall.EIO, errno(errors.New("connection reset by peer")))
+}
@@ -0,0 +1,147 @@
+package afc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// maxChunkSize is the most data read or written with one afc packet
+const maxChunkSize = 64 * 1024
+
+// File is a file opened on the device. It reads and writes at its offset like an os.File.
+// The connection must not be used for anything else while a Read, Write or Seek is running.
+type File struct {
+	conn *Connection
+	fd   uint64
+	path string
+}
+
+// Open opens the file at path with one of the Afc_Mode flags
+func (conn *Connection) Open(path string, mode uint64) (*File, error) {
+	fd, err := conn.OpenFile(path, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &File{conn: conn, fd: fd, path: path}, nil
+}
+
+// OpenMode is the Afc_Mode for the flags of os.OpenFile. exists tells if the file exists already, afc has no
+// flag that creates missing files without truncating existing ones.
+func OpenMode(flag int, exists bool) uint64 {
+	readWrite := flag&os.O_RDWR != 0
+	switch {
+	case flag&os.O_APPEND != 0 && readWrite:
+		return Afc_Mode_RDAPPEND
+	case flag&os.O_APPEND != 0:
+		return Afc_Mode_APPEND
+	case flag&os.O_TRUNC != 0 || !exists:
+		if flag&os.O_WRONLY != 0 {
+			return Afc_Mode_WRONLY
+		}
+		return Afc_Mode_WR
+	case readWrite || flag&os.O_WRONLY != 0:
+		return Afc_Mode_RW
+	default:
+		return Afc_Mode_RDONLY
+	}
+}
+
+// Read reads up to len(p) bytes at the offset of the file. It returns io.EOF at the end of the file.
+func (f *File) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	size := len(p)
+	if size > maxChunkSize {
+		size = maxChunkSize
+	}
+	headerPayload := make([]byte, 16)
+	binary.LittleEndian.PutUint64(headerPayload, f.fd)
+	binary.LittleEndian.PutUint64(headerPayload[8:], uint64(size))
+	response, err := f.send(Afc_operation_file_read, headerPayload, nil)
+	if err != nil {
+		return 0, fmt.Errorf("read %s: %w", f.path, err)
+	}
+	if len(response.Payload) == 0 {
+		return 0, io.EOF
+	}
+	return copy(p, response.Payload), nil
+}
+
+// Write writes p at the offset of the file
+func (f *File) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > maxChunkSize {
+			chunk = chunk[:maxChunkSize]
+		}
+		headerPayload := make([]byte, 8)
+		binary.LittleEndian.PutUint64(headerPayload, f.fd)
+		_, err := f.send(Afc_operation_file_write, headerPayload, chunk)
+		if err != nil {
+			return written, fmt.Errorf("write %s: %w", f.path, err)
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// Seek sets the offset of the file like io.Seeker and returns the new offset
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	headerPayload := make([]byte, 24)
+	binary.LittleEndian.PutUint64(headerPayload, f.fd)
+	binary.LittleEndian.PutUint64(headerPayload[8:], uint64(whence))
+	binary.LittleEndian.PutUint64(headerPayload[16:], uint64(offset))
+	_, err := f.send(Afc_operation_file_seek, headerPayload, nil)
+	if err != nil {
+		return 0, fmt.Errorf("seek %s: %w", f.path, err)
+	}
+	headerPayload = make([]byte, 8)
+	binary.LittleEndian.PutUint64(headerPayload, f.fd)
+	response, err := f.send(Afc_operation_file_tell, headerPayload, nil)
+	if err != nil {
+		return 0, fmt.Errorf("tell %s: %w", f.path, err)
+	}
+	position := append(response.HeaderPayload, response.Payload...)
+	if response.Header.Operation != Afc_operation_file_tell_result || len(position) < 8 {
+		return 0, fmt.Errorf("tell %s: unexpected response operation %d", f.path, response.Header.Operation)
+	}
+	return int64(binary.LittleEndian.Uint64(position)), nil
+}
+
+// Truncate changes the size of the file, the offset stays where it is
+func (f *File) Truncate(size int64) error {
+	headerPayload := make([]byte, 16)
+	binary.LittleEndian.PutUint64(headerPayload, f.fd)
+	binary.LittleEndian.PutUint64(headerPayload[8:], uint64(size))
+	_, err := f.send(Afc_operation_file_set_size, headerPayload, nil)
+	if err != nil {
+		return fmt.Errorf("truncate %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// Close closes the file on the device
+func (f *File) Close() error {
+	return f.conn.CloseFile(f.fd)
+}
+
+func (f *File) send(operation uint64, headerPayload []byte, payload []byte) (AfcPacket, error) {
+	thisLength := Afc_header_size + uint64(len(headerPayload))
+	header := AfcPacketHeader{Magic: Afc_magic, Packet_num: f.conn.packageNumber, Operation: operation, This_length: thisLength, Entire_length: thisLength + uint64(len(payload))}
+	f.conn.packageNumber++
+	if payload == nil {
+		payload = make([]byte, 0)
+	}
+	response, err := f.conn.sendAfcPacketAndAwaitResponse(AfcPacket{Header: header, HeaderPayload: headerPayload, Payload: payload})
+	if err != nil {
+		return AfcPacket{}, err
+	}
+	if err = f.conn.checkOperationStatus(response); err != nil {
+		return AfcPacket{}, fmt.Errorf("unexpected afc status: %w", err)
+	}
+	return response, nil
+}
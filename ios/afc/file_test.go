@@ -0,0 +1,121 @@
+package afc
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveFile answers the file operations of one open file with the contents in data
+func serveFile(t *testing.T, conn net.Conn, data []byte) {
+	offset := int64(0)
+	status := func(packetNum uint64) AfcPacket {
+		headerPayload := make([]byte, 8)
+		return AfcPacket{Header: AfcPacketHeader{Magic: Afc_magic, Packet_num: packetNum, Operation: Afc_operation_status, This_length: Afc_header_size + 8, Entire_length: Afc_header_size + 8}, HeaderPayload: headerPayload}
+	}
+	for {
+		request, err := Decode(conn)
+		if err != nil {
+			return
+		}
+		response := status(request.Header.Packet_num)
+		switch request.Header.Operation {
+		case Afc_operation_file_open:
+			response.Header.Operation = Afc_operation_file_open_result
+			binary.LittleEndian.PutUint64(response.HeaderPayload, 1)
+		case Afc_operation_file_read:
+			size := int64(binary.LittleEndian.Uint64(request.HeaderPayload[8:]))
+			end := offset + size
+			if end > int64(len(data)) {
+				end = int64(len(data))
+			}
+			response.Header.Operation = Afc_operation_data
+			response.HeaderPayload = nil
+			response.Header.This_length = Afc_header_size
+			response.Payload = data[offset:end]
+			response.Header.Entire_length = Afc_header_size + uint64(len(response.Payload))
+			offset = end
+		case Afc_operation_file_write:
+			data = append(data[:offset], request.Payload...)
+			offset += int64(len(request.Payload))
+		case Afc_operation_file_seek:
+			whence := binary.LittleEndian.Uint64(request.HeaderPayload[8:])
+			delta := int64(binary.LittleEndian.Uint64(request.HeaderPayload[16:]))
+			switch whence {
+			case io.SeekStart:
+				offset = delta
+			case io.SeekCurrent:
+				offset += delta
+			case io.SeekEnd:
+				offset = int64(len(data)) + delta
+			}
+		case Afc_operation_file_tell:
+			response.Header.Operation = Afc_operation_file_tell_result
+			binary.LittleEndian.PutUint64(response.HeaderPayload, uint64(offset))
+		case Afc_operation_file_set_size:
+			size := binary.LittleEndian.Uint64(request.HeaderPayload[8:])
+			data = data[:size]
+		case Afc_operation_file_close:
+		default:
+			t.Errorf("unexpected operation %d", request.Header.Operation)
+		}
+		err = Encode(response, conn)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestFileReadWriteSeek(t *testing.T) {
+	// net.Pipe blocks on the empty writes of Encode
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+	go func() {
+		device, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer device.Close()
+		serveFile(t, device, []byte("hello world"))
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+	conn := NewFromConn(ios.NewDeviceConnectionWithRWC(client))
+
+	f, err := conn.Open("/Documents/test.txt", Afc_Mode_RW)
+	if !assert.NoError(t, err) {
+		return
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), size)
+
+	pos, err := f.Seek(6, io.SeekStart)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), pos)
+	n, err := f.Write([]byte("gopher"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+
+	_, err = f.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	b, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello gopher", string(b))
+
+	assert.NoError(t, f.Truncate(5))
+	size, err = f.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+	assert.NoError(t, f.Close())
+}
@@ -2,6 +2,7 @@ package afc
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -10,8 +11,10 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/ratelimit"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -36,10 +39,20 @@ func (s *statInfo) IsDir() bool {
 	return s.stIfmt == "S_IFDIR"
 }
 
+// ModTime is the time the file was last modified, afc reports it in nanoseconds since the epoch
+func (s *statInfo) ModTime() time.Time {
+	return time.Unix(0, s.stMtime)
+}
+
 func (s *statInfo) IsLink() bool {
 	return s.stIfmt == "S_IFLNK"
 }
 
+// Size is the size of the file in bytes
+func (s *statInfo) Size() int64 {
+	return s.stSize
+}
+
 func New(device ios.DeviceEntry) (*Connection, error) {
 	deviceConn, err := ios.ConnectToService(device, serviceName)
 	if err != nil {
@@ -84,7 +97,7 @@ func (conn *Connection) Remove(path string) error {
 		return err
 	}
 	if err = conn.checkOperationStatus(response); err != nil {
-		return fmt.Errorf("remove: unexpected afc status: %v", err)
+		return fmt.Errorf("remove: unexpected afc status: %w", err)
 	}
 	return nil
 }
@@ -103,7 +116,7 @@ func (conn *Connection) RemovePathAndContents(path string) error {
 		return err
 	}
 	if err = conn.checkOperationStatus(response); err != nil {
-		return fmt.Errorf("remove: unexpected afc status: %v", err)
+		return fmt.Errorf("remove: unexpected afc status: %w", err)
 	}
 	return nil
 }
@@ -129,6 +142,28 @@ func (conn *Connection) RemoveAll(srcPath string) error {
 	return conn.Remove(srcPath)
 }
 
+// Rename moves the file or directory at oldPath to newPath
+func (conn *Connection) Rename(oldPath, newPath string) error {
+	headerPayload := []byte(oldPath)
+	headerPayload = append(headerPayload, 0)
+	headerPayload = append(headerPayload, []byte(newPath)...)
+	headerPayload = append(headerPayload, 0)
+	headerLength := uint64(len(headerPayload))
+	thisLength := Afc_header_size + headerLength
+
+	header := AfcPacketHeader{Magic: Afc_magic, Packet_num: conn.packageNumber, Operation: Afc_operation_rename_path, This_length: thisLength, Entire_length: thisLength}
+	conn.packageNumber++
+	packet := AfcPacket{Header: header, HeaderPayload: headerPayload, Payload: make([]byte, 0)}
+	response, err := conn.sendAfcPacketAndAwaitResponse(packet)
+	if err != nil {
+		return err
+	}
+	if err = conn.checkOperationStatus(response); err != nil {
+		return fmt.Errorf("rename: unexpected afc status: %w", err)
+	}
+	return nil
+}
+
 func (conn *Connection) MkDir(path string) error {
 	headerPayload := []byte(path)
 	headerPayload = append(headerPayload, 0)
@@ -161,7 +196,7 @@ func (conn *Connection) Stat(path string) (*statInfo, error) {
 		return nil, err
 	}
 	if err = conn.checkOperationStatus(response); err != nil {
-		return nil, fmt.Errorf("stat: unexpected afc status: %v", err)
+		return nil, fmt.Errorf("stat: unexpected afc status: %w", err)
 	}
 	ret := bytes.Split(response.Payload, []byte{0})
 	retLen := len(ret)
@@ -186,6 +221,11 @@ func (conn *Connection) Stat(path string) (*statInfo, error) {
 	return &si, nil
 }
 
+// ReadDir returns the names of the entries of the directory at path
+func (conn *Connection) ReadDir(path string) ([]string, error) {
+	return conn.listDir(path)
+}
+
 func (conn *Connection) listDir(path string) ([]string, error) {
 	headerPayload := []byte(path)
 	headerLength := uint64(len(headerPayload))
@@ -199,7 +239,7 @@ func (conn *Connection) listDir(path string) ([]string, error) {
 		return nil, err
 	}
 	if err = conn.checkOperationStatus(response); err != nil {
-		return nil, fmt.Errorf("list dir: unexpected afc status: %v", err)
+		return nil, fmt.Errorf("list dir: unexpected afc status: %w", err)
 	}
 	ret := bytes.Split(response.Payload, []byte{0})
 	var fileList []string
@@ -344,7 +384,7 @@ func (conn *Connection) OpenFile(path string, mode uint64) (uint64, error) {
 		return 0, err
 	}
 	if err = conn.checkOperationStatus(response); err != nil {
-		return 0, fmt.Errorf("open file: unexpected afc status: %v", err)
+		return 0, fmt.Errorf("open file: unexpected afc status: %w", err)
 	}
 	fd := binary.LittleEndian.Uint64(response.HeaderPayload)
 	if fd == 0 {
@@ -372,6 +412,17 @@ func (conn *Connection) CloseFile(fd uint64) error {
 }
 
 func (conn *Connection) PullSingleFile(srcPath, dstPath string) error {
+	f, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return conn.ReadFile(srcPath, f)
+}
+
+// ReadFile copies the contents of the file at srcPath to w, symlinks are followed.
+// The copy is one transfer limited by the bandwidth limits of the ratelimit package.
+func (conn *Connection) ReadFile(srcPath string, w io.Writer) error {
 	fileInfo, err := conn.Stat(srcPath)
 	if err != nil {
 		return err
@@ -385,14 +436,9 @@ func (conn *Connection) PullSingleFile(srcPath, dstPath string) error {
 	}
 	defer conn.CloseFile(fd)
 
-	f, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	leftSize := fileInfo.stSize
 	maxReadSize := 64 * 1024
+	transfer := ratelimit.NewTransfer()
 	for leftSize > 0 {
 		headerPayload := make([]byte, 16)
 		binary.LittleEndian.PutUint64(headerPayload, fd)
@@ -408,8 +454,18 @@ func (conn *Connection) PullSingleFile(srcPath, dstPath string) error {
 		if err = conn.checkOperationStatus(response); err != nil {
 			return fmt.Errorf("read file: unexpected afc status: %v", err)
 		}
+		if len(response.Payload) == 0 {
+			return fmt.Errorf("read file: %s ended %d bytes early", srcPath, leftSize)
+		}
 		leftSize = leftSize - int64(len(response.Payload))
-		f.Write(response.Payload)
+		err = transfer.Wait(context.Background(), len(response.Payload))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(response.Payload)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -466,6 +522,8 @@ func (conn *Connection) Push(srcPath, dstPath string) error {
 	return conn.WriteToFile(f, dstPath)
 }
 
+// WriteToFile writes the contents of reader to the file at dstPath.
+// The copy is one transfer limited by the bandwidth limits of the ratelimit package.
 func (conn *Connection) WriteToFile(reader io.Reader, dstPath string) error {
 	if fileInfo, _ := conn.Stat(dstPath); fileInfo != nil {
 		if fileInfo.IsDir() {
@@ -481,6 +539,7 @@ func (conn *Connection) WriteToFile(reader io.Reader, dstPath string) error {
 
 	maxWriteSize := 64 * 1024
 	chunk := make([]byte, maxWriteSize)
+	reader = ratelimit.NewTransfer().Reader(context.Background(), reader)
 	for {
 		n, err := reader.Read(chunk)
 		if err != nil && err != io.EOF {
@@ -12,6 +12,9 @@ import (
 
 const serviceName string = "com.apple.amfi.lockdown"
 
+// errPasscodeSet is why arming is skipped on devices with a passcode, they don't have to be locked right now
+var errPasscodeSet = errors.New("developer mode can't be armed on a device with a passcode")
+
 type Connection struct {
 	deviceConn ios.DeviceConnectionInterface
 	plistCodec ios.PlistCodec
@@ -34,6 +37,57 @@ func (devModeConn *Connection) Close() error {
 	return devModeConn.deviceConn.Close()
 }
 
+// DevModeState describes the progress of enabling developer mode. Some of the states need the user
+// to do something on the device screen before the process can continue.
+type DevModeState string
+
+const (
+	// DevModeStateEnabled means developer mode is enabled, nothing else to do
+	DevModeStateEnabled = DevModeState("enabled")
+	// DevModeStateArming means developer mode was armed and the device is restarting
+	DevModeStateArming = DevModeState("arming")
+	// DevModeStateAwaitingConfirmation means the device restarted and shows a popup that needs to be confirmed on screen,
+	// either because automatic confirmation was not requested or because it failed
+	DevModeStateAwaitingConfirmation = DevModeState("awaiting_confirmation")
+	// DevModeStateRevealed means developer mode could not be armed, usually because the device has a passcode.
+	// The option was made visible in Settings > Privacy & Security and needs to be switched on manually.
+	DevModeStateRevealed = DevModeState("revealed_in_settings")
+)
+
+// RevealDevModeOption makes the developer mode toggle visible in the Settings app. This works on devices
+// with a passcode, where EnableDevMode fails.
+func (devModeConn *Connection) RevealDevModeOption() error {
+	reader := devModeConn.deviceConn.Reader()
+
+	request := map[string]interface{}{"action": 0}
+
+	bytes, err := devModeConn.plistCodec.Encode(request)
+	if err != nil {
+		return fmt.Errorf("RevealDevModeOption: failed encoding request to service with err: %w", err)
+	}
+
+	err = devModeConn.deviceConn.Send(bytes)
+	if err != nil {
+		return fmt.Errorf("RevealDevModeOption: failed sending request bytes to service with err: %w", err)
+	}
+
+	responseBytes, err := devModeConn.plistCodec.Decode(reader)
+	if err != nil {
+		return fmt.Errorf("RevealDevModeOption: failed decoding response from service with err: %w", err)
+	}
+
+	plist, err := ios.ParsePlist(responseBytes)
+	if err != nil {
+		return fmt.Errorf("RevealDevModeOption: failed parsing response plist with err: %w", err)
+	}
+
+	if _, ok := plist["success"]; ok {
+		return nil
+	}
+
+	return fmt.Errorf("RevealDevModeOption: could not reveal developer mode option through amfi service")
+}
+
 // Enable developer mode on a device, e.g. after content reset
 func (devModeConn *Connection) EnableDevMode() error {
 	reader := devModeConn.deviceConn.Reader()
@@ -107,27 +161,67 @@ func (devModeConn *Connection) EnableDevModePostRestart() error {
 }
 
 func EnableDeveloperMode(device ios.DeviceEntry, enablePostRestart bool) error {
+	state, err := EnableDeveloperModeWithState(device, enablePostRestart, func(state DevModeState) {
+		log.WithField("state", state).Debug("developer mode enablement progressed")
+	})
+	if err != nil {
+		return err
+	}
+	switch state {
+	case DevModeStateAwaitingConfirmation:
+		log.Info("Confirm the popup on the device to finish enabling developer mode")
+	case DevModeStateRevealed:
+		log.Info("Developer mode could not be enabled automatically, switch it on in Settings > Privacy & Security > Developer Mode")
+	}
+	return nil
+}
+
+// EnableDeveloperModeWithState enables developer mode and reports every state it goes through to onState.
+// It returns the final state, which is DevModeStateEnabled if no more user interaction is needed on the device.
+// DevModeStateAwaitingConfirmation and DevModeStateRevealed are returned without error when the remaining
+// steps have to be finished on the device screen.
+func EnableDeveloperModeWithState(device ios.DeviceEntry, enablePostRestart bool, onState func(DevModeState)) (DevModeState, error) {
 	// Don't try to enable if it already is
 	devModeEnabled, err := imagemounter.IsDevModeEnabled(device)
 	if err != nil {
-		return fmt.Errorf("EnableDeveloperMode: failed checking developer mode status with err: %w", err)
+		return "", fmt.Errorf("EnableDeveloperMode: failed checking developer mode status with err: %w", err)
 	}
 
 	if devModeEnabled {
 		log.Info("Developer mode is already enabled on the device")
-		return nil
+		onState(DevModeStateEnabled)
+		return DevModeStateEnabled, nil
 	}
 
 	// Perform the first step of developer mode enablement and wait for the device to restart
 	conn, err := New(device)
 	if err != nil {
-		return fmt.Errorf("EnableDeveloperMode: failed connecting to amfi service with err: %w", err)
+		return "", fmt.Errorf("EnableDeveloperMode: failed connecting to amfi service with err: %w", err)
 	}
 
-	err = conn.EnableDevMode()
+	// arming does not work on devices with a passcode, don't even try then
+	passcode, err := ios.GetPasscodeState(device)
 	if err != nil {
-		return fmt.Errorf("EnableDeveloperMode: failed enabling developer mode with err: %w", err)
+		log.WithError(err).Debug("could not check if device has a passcode")
 	}
+	if passcode.PasswordProtected {
+		err = errPasscodeSet
+	} else {
+		err = conn.EnableDevMode()
+	}
+	if err != nil {
+		// arming fails on devices with a passcode, the best we can do is to show the option in the settings
+		revealErr := conn.RevealDevModeOption()
+		conn.Close()
+		if revealErr != nil {
+			return "", fmt.Errorf("EnableDeveloperMode: failed enabling developer mode with err: %w", err)
+		}
+		log.WithError(err).Info("Could not arm developer mode, revealed the option in settings instead")
+		onState(DevModeStateRevealed)
+		return DevModeStateRevealed, nil
+	}
+	conn.Close()
+	onState(DevModeStateArming)
 	log.Infof("Successfully enabled developer mode on device `%s`, device will restart", device.Properties.SerialNumber)
 
 	udid := device.Properties.SerialNumber
@@ -149,26 +243,30 @@ WaitLoop:
 		case <-time.After(60 * time.Second):
 			ticker.Stop()
 			if err != nil {
-				return errors.New("Device was not restarted in 60 seconds")
+				return DevModeStateArming, errors.New("Device was not restarted in 60 seconds")
 			}
 		}
 	}
 	log.Info("Device was successfully restarted after enabling developer mode")
 
 	// Try to also enable dev mode after the device restarts - skips the system popup that asks you to finalize dev mode enablement
-	if enablePostRestart {
-		log.Info("Will attempt to enable developer mode post restart")
-		conn, err = New(device)
-		if err != nil {
-			return fmt.Errorf("EnableDeveloperMode: failed connecting to amfi service post restart with err: %w", err)
-		}
-		defer conn.Close()
-		err = conn.EnableDevModePostRestart()
-		if err != nil {
-			return fmt.Errorf("EnableDeveloperMode: failed enabling developer mode post restart, you need to finish the set up manually through the popup on the device, err: %w", err)
-		}
-		log.Info("Successfully enabled developer mode on device post restart")
+	if !enablePostRestart {
+		onState(DevModeStateAwaitingConfirmation)
+		return DevModeStateAwaitingConfirmation, nil
 	}
+	log.Info("Will attempt to enable developer mode post restart")
+	conn, err = New(device)
+	if err != nil {
+		return DevModeStateAwaitingConfirmation, fmt.Errorf("EnableDeveloperMode: failed connecting to amfi service post restart with err: %w", err)
+	}
+	defer conn.Close()
+	err = conn.EnableDevModePostRestart()
+	if err != nil {
+		onState(DevModeStateAwaitingConfirmation)
+		return DevModeStateAwaitingConfirmation, fmt.Errorf("EnableDeveloperMode: failed enabling developer mode post restart, you need to finish the set up manually through the popup on the device, err: %w", err)
+	}
+	log.Info("Successfully enabled developer mode on device post restart")
+	onState(DevModeStateEnabled)
 
-	return nil
+	return DevModeStateEnabled, nil
 }
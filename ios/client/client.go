@@ -0,0 +1,323 @@
+// Package client provides Client, a high level API for the most common things done with a device.
+// It wraps the service packages of go-ios, caches their connections and applies timeouts and retries
+// consistently, so simple use cases don't need to know which service provides what.
+//
+//	c := client.New(device, client.WithTimeout(30*time.Second), client.WithRetry(2, time.Second))
+//	defer c.Close()
+//	err := c.Install(ctx, "app.ipa")
+//	pid, err := c.Launch(ctx, "com.example.app")
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/danielpaulus/go-ios/ios/syslog"
+	"github.com/danielpaulus/go-ios/ios/testmanagerd"
+	"github.com/danielpaulus/go-ios/ios/zipconduit"
+	log "github.com/sirupsen/logrus"
+)
+
+// Client wraps a DeviceEntry and the connections to its services. It is safe for concurrent use.
+type Client struct {
+	device  ios.DeviceEntry
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+	log     *log.Entry
+
+	mu             sync.Mutex
+	processControl *instruments.ProcessControl
+	screenshots    *instruments.ScreenshotService
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithTimeout limits how long each call may take if the context passed to it has no earlier deadline.
+// Without it, calls only end when their context is done or the operation fails.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetry retries failed calls up to retries times, waiting backoff between attempts.
+// Retries are not used for RunTest and Syslog.
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithLogger makes the client log to logger instead of the standard logrus logger
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Client) {
+		c.log = logger.WithField("udid", c.device.Properties.SerialNumber)
+	}
+}
+
+// New creates a client for the device. Connections are opened when they are needed first.
+func New(device ios.DeviceEntry, opts ...Option) *Client {
+	c := &Client{device: device, log: log.WithField("udid", device.Properties.SerialNumber)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Device returns the DeviceEntry of the client
+func (c *Client) Device() ios.DeviceEntry {
+	return c.device
+}
+
+// Close closes all cached connections
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeProcessControl()
+	c.closeScreenshots()
+	return nil
+}
+
+// withTimeout applies the timeout of the client to ctx
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// call runs f with the timeout and retries of the client. Every attempt runs in its own goroutine, so the call returns
+// as soon as ctx is done even if f is blocked. f has to close the connections it uses when its context is done.
+func (c *Client) call(ctx context.Context, name string, f func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			c.log.WithFields(log.Fields{"call": name, "attempt": attempt, "error": err}).Debug("retrying")
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%s: %w", name, err)
+			case <-time.After(c.backoff):
+			}
+		}
+		err = c.attempt(ctx, f)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+func (c *Client) attempt(ctx context.Context, f func(ctx context.Context) error) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- f(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Install installs the app at appPath, which is either an ipa or an app directory
+func (c *Client) Install(ctx context.Context, appPath string) error {
+	return c.call(ctx, "Install", func(ctx context.Context) error {
+		conn, err := zipconduit.New(c.device)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+		return conn.SendFile(appPath)
+	})
+}
+
+// Launch starts the app with the bundle id and returns its pid
+func (c *Client) Launch(ctx context.Context, bundleID string) (uint64, error) {
+	var pid uint64
+	err := c.call(ctx, "Launch", func(ctx context.Context) error {
+		pc, err := c.cachedProcessControl()
+		if err != nil {
+			return err
+		}
+		stop := context.AfterFunc(ctx, c.dropProcessControl)
+		defer stop()
+		pid, err = pc.LaunchApp(bundleID, nil)
+		if err != nil {
+			c.dropProcessControl()
+		}
+		return err
+	})
+	return pid, err
+}
+
+// Kill stops the process with the pid
+func (c *Client) Kill(ctx context.Context, pid uint64) error {
+	return c.call(ctx, "Kill", func(ctx context.Context) error {
+		pc, err := c.cachedProcessControl()
+		if err != nil {
+			return err
+		}
+		stop := context.AfterFunc(ctx, c.dropProcessControl)
+		defer stop()
+		err = pc.KillProcess(pid)
+		if err != nil {
+			c.dropProcessControl()
+		}
+		return err
+	})
+}
+
+// Screenshot takes a png screenshot
+func (c *Client) Screenshot(ctx context.Context) ([]byte, error) {
+	var png []byte
+	err := c.call(ctx, "Screenshot", func(ctx context.Context) error {
+		s, err := c.cachedScreenshots()
+		if err != nil {
+			return err
+		}
+		stop := context.AfterFunc(ctx, c.dropScreenshots)
+		defer stop()
+		png, err = s.TakeScreenshot()
+		if err != nil {
+			c.dropScreenshots()
+		}
+		return err
+	})
+	return png, err
+}
+
+// TestRun configures RunTest. BundleID is the app under test and TestRunnerBundleID the test runner app,
+// XCTestConfig the name of the .xctestconfiguration file in the test runner.
+type TestRun struct {
+	BundleID           string
+	TestRunnerBundleID string
+	XCTestConfig       string
+	Args               []string
+	Env                []string
+	TestsToRun         []string
+	TestsToSkip        []string
+	IsXCTest           bool
+	// Shard runs only a part of TestsToRun, so a suite can be split across devices
+	Shard *testmanagerd.Shard
+	// Listener receives the test results and logs, if it is nil the log of the test run is discarded
+	Listener *testmanagerd.TestListener
+}
+
+// RunTest runs an XCTest or XCUITest and returns the results when it finished or ctx is done.
+// Timeout and retries of the client are not applied, tests usually run much longer than other calls.
+func (c *Client) RunTest(ctx context.Context, run TestRun) ([]testmanagerd.TestSuite, error) {
+	listener := run.Listener
+	if listener == nil {
+		listener = testmanagerd.NewTestListener(io.Discard, io.Discard, "")
+	}
+	selection := testmanagerd.TestSelection{TestsToRun: run.TestsToRun, TestsToSkip: run.TestsToSkip, Shard: run.Shard}
+	testsToRun, testsToSkip, err := selection.Resolve()
+	if err != nil {
+		return nil, fmt.Errorf("RunTest: %w", err)
+	}
+	if testsToRun != nil && len(testsToRun) == 0 {
+		return []testmanagerd.TestSuite{}, nil
+	}
+	suites, err := testmanagerd.RunXCUIWithBundleIdsCtx(ctx, run.BundleID, run.TestRunnerBundleID, run.XCTestConfig, c.device,
+		run.Args, run.Env, testsToRun, testsToSkip, listener, run.IsXCTest)
+	if err != nil {
+		return suites, fmt.Errorf("RunTest: %w", err)
+	}
+	return suites, nil
+}
+
+// Syslog streams the syslog of the device until ctx is done. The channel is closed when the stream ends.
+func (c *Client) Syslog(ctx context.Context) (<-chan string, error) {
+	conn, err := syslog.New(c.device)
+	if err != nil {
+		return nil, fmt.Errorf("Syslog: %w", err)
+	}
+	messages := make(chan string)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	go func() {
+		defer close(messages)
+		defer stop()
+		defer conn.Close()
+		for {
+			msg, err := conn.ReadLogMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return messages, nil
+}
+
+func (c *Client) cachedProcessControl() (*instruments.ProcessControl, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.processControl == nil {
+		pc, err := instruments.NewProcessControl(c.device)
+		if err != nil {
+			return nil, err
+		}
+		c.processControl = pc
+	}
+	return c.processControl, nil
+}
+
+// dropProcessControl closes the cached connection, it is opened again with the next call
+func (c *Client) dropProcessControl() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeProcessControl()
+}
+
+func (c *Client) closeProcessControl() {
+	if c.processControl != nil {
+		c.processControl.Close()
+		c.processControl = nil
+	}
+}
+
+func (c *Client) cachedScreenshots() (*instruments.ScreenshotService, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.screenshots == nil {
+		s, err := instruments.NewScreenshotService(c.device)
+		if err != nil {
+			return nil, err
+		}
+		c.screenshots = s
+	}
+	return c.screenshots, nil
+}
+
+func (c *Client) dropScreenshots() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeScreenshots()
+}
+
+func (c *Client) closeScreenshots() {
+	if c.screenshots != nil {
+		c.screenshots.Close()
+		c.screenshots = nil
+	}
+}
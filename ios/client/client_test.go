@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions(t *testing.T) {
+	logger := log.New()
+	c := New(ios.DeviceEntry{Properties: ios.DeviceProperties{SerialNumber: "udid"}}, WithTimeout(time.Second), WithRetry(2, time.Millisecond), WithLogger(logger))
+	assert.Equal(t, time.Second, c.timeout)
+	assert.Equal(t, 2, c.retries)
+	assert.Equal(t, time.Millisecond, c.backoff)
+	assert.Equal(t, logger, c.log.Logger)
+	assert.Equal(t, "udid", c.log.Data["udid"])
+}
+
+func TestCallRetries(t *testing.T) {
+	c := New(ios.DeviceEntry{}, WithRetry(2, time.Millisecond))
+	attempts := 0
+	err := c.call(context.Background(), "Test", func(ctx context.Context) error {
+		attempts++
+		return errors.New("failed")
+	})
+	assert.EqualError(t, err, "Test: failed")
+	assert.Equal(t, 3, attempts)
+}
+
+func TestCallTimesOutBlockedAttempts(t *testing.T) {
+	c := New(ios.DeviceEntry{}, WithTimeout(10*time.Millisecond))
+	unblock := make(chan struct{})
+	defer close(unblock)
+	err := c.call(context.Background(), "Test", func(ctx context.Context) error {
+		// a blocked read that does not look at ctx
+		<-unblock
+		return nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
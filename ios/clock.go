@@ -0,0 +1,85 @@
+package ios
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ClockMeasurement is the result of MeasureClock
+type ClockMeasurement struct {
+	// RoundTrip is the time a lockdown request through usbmuxd to the device and back took
+	RoundTrip time.Duration `json:"roundTrip"`
+	// Offset is how far the device clock is ahead of the host clock, it is negative if the device clock is behind
+	Offset time.Duration `json:"offset"`
+	// UTCOffset is the offset of the time zone of the device
+	UTCOffset  time.Duration `json:"utcOffset"`
+	MeasuredAt time.Time     `json:"measuredAt"`
+}
+
+// MeasureClock measures the round trip latency to the device and the offset of its clock to the host clock.
+// Like NTP, it takes the given number of samples and uses the one with the smallest round trip, assuming
+// requests and responses take the same time. The offset is only as accurate as half of the round trip.
+func MeasureClock(device DeviceEntry, samples int) (ClockMeasurement, error) {
+	if samples < 1 {
+		samples = 1
+	}
+	lockdown, err := ConnectLockdownWithSession(device)
+	if err != nil {
+		return ClockMeasurement{}, fmt.Errorf("MeasureClock: %w", err)
+	}
+	defer lockdown.Close()
+
+	best := ClockMeasurement{RoundTrip: time.Duration(math.MaxInt64)}
+	for i := 0; i < samples; i++ {
+		sent := time.Now()
+		value, err := lockdown.GetValue("TimeIntervalSince1970")
+		received := time.Now()
+		if err != nil {
+			return ClockMeasurement{}, fmt.Errorf("MeasureClock: failed reading device time: %w", err)
+		}
+		deviceTime, err := timeFromSecondsSince1970(value)
+		if err != nil {
+			return ClockMeasurement{}, fmt.Errorf("MeasureClock: %w", err)
+		}
+		roundTrip := received.Sub(sent)
+		if roundTrip < best.RoundTrip {
+			best.RoundTrip = roundTrip
+			best.Offset = deviceTime.Sub(sent.Add(roundTrip / 2))
+			best.MeasuredAt = received
+		}
+	}
+	if utcOffset, err := lockdown.GetValue("TimeZoneOffsetFromUTC"); err == nil {
+		if seconds, ok := utcOffset.(float64); ok {
+			best.UTCOffset = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return best, nil
+}
+
+func timeFromSecondsSince1970(value interface{}) (time.Time, error) {
+	var seconds float64
+	switch v := value.(type) {
+	case float64:
+		seconds = v
+	case uint64:
+		seconds = float64(v)
+	case int64:
+		seconds = float64(v)
+	default:
+		return time.Time{}, fmt.Errorf("unexpected device time %v", value)
+	}
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*1e9)), nil
+}
+
+// HostTime converts a timestamp taken with the device clock to the host clock
+func (m ClockMeasurement) HostTime(deviceTime time.Time) time.Time {
+	return deviceTime.Add(-m.Offset)
+}
+
+// DeviceLocation returns the time zone of the device as fixed zone, to parse timestamps the device
+// formats in local time like the ones in the syslog
+func (m ClockMeasurement) DeviceLocation() *time.Location {
+	return time.FixedZone("device", int(m.UTCOffset/time.Second))
+}
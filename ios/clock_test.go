@@ -0,0 +1,30 @@
+package ios
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeFromSecondsSince1970(t *testing.T) {
+	deviceTime, err := timeFromSecondsSince1970(1700000000.25)
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000000, 250000000), deviceTime)
+
+	deviceTime, err = timeFromSecondsSince1970(uint64(1700000000))
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000000, 0), deviceTime)
+
+	_, err = timeFromSecondsSince1970("now")
+	assert.Error(t, err)
+}
+
+func TestClockMeasurementHostTime(t *testing.T) {
+	m := ClockMeasurement{Offset: 3 * time.Second, UTCOffset: 2 * time.Hour}
+	deviceTime := time.Date(2024, 1, 1, 12, 0, 3, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), m.HostTime(deviceTime))
+	_, offset := time.Now().In(m.DeviceLocation()).Zone()
+	assert.Equal(t, 7200, offset)
+}
@@ -1,12 +1,15 @@
 package ios
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/danielpaulus/go-ios/ios/http"
@@ -49,6 +52,9 @@ func (muxConn *UsbMuxConnection) Connect(deviceID int, port uint16) error {
 	}
 	response := MuxResponsefromBytes(resp.Payload)
 	if response.IsSuccessFull() {
+		if tracked, ok := trackedConnOf(muxConn.deviceConn); ok {
+			tracked.connectedTo(ConnectionKindService, deviceID)
+		}
 		return nil
 	}
 	return fmt.Errorf("Failed connecting to service, error code:%d", response.Number)
@@ -81,13 +87,69 @@ func (muxConn *UsbMuxConnection) ConnectLockdown(deviceID int) (*LockDownConnect
 	}
 	response := MuxResponsefromBytes(resp.Payload)
 	if response.IsSuccessFull() {
+		if tracked, ok := trackedConnOf(muxConn.deviceConn); ok {
+			tracked.connectedTo(ConnectionKindLockdown, deviceID)
+		}
 		return &LockDownConnection{muxConn.deviceConn, "", NewPlistCodec()}, nil
 	}
 
 	return nil, fmt.Errorf("Failed connecting to Lockdown with error code:%d", response.Number)
 }
 
+// ConnectToService starts the service on the device and returns a connection to it.
+// Starting the service is retried according to the policy of OperationServiceStart if it failed with a transient
+// connection error. Errors the device reports, like an unknown service or a failed pairing, are not retried.
 func ConnectToService(device DeviceEntry, serviceName string) (DeviceConnectionInterface, error) {
+	var conn DeviceConnectionInterface
+	err := RetryOperation(context.Background(), OperationServiceStart, func(ctx context.Context) error {
+		var err error
+		conn, err = connectToServiceCtx(ctx, device, serviceName)
+		if err != nil && !isTransientConnError(err) {
+			return Permanent(err)
+		}
+		return err
+	})
+	return conn, err
+}
+
+// connectToServiceCtx is connectToService that gives up when ctx is done, a connection that is established after
+// that is closed again
+func connectToServiceCtx(ctx context.Context, device DeviceEntry, serviceName string) (DeviceConnectionInterface, error) {
+	type result struct {
+		conn DeviceConnectionInterface
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := connectToService(device, serviceName)
+		done <- result{conn, err}
+	}()
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("connectToServiceCtx: could not connect to %s: %w", serviceName, ctx.Err())
+	}
+}
+
+// isTransientConnError reports if err is a connection error that can go away when connecting again, like a timeout
+// or a connection that was reset while the device was busy
+func isTransientConnError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func connectToService(device DeviceEntry, serviceName string) (DeviceConnectionInterface, error) {
 	startServiceResponse, err := StartService(device, serviceName)
 	if err != nil {
 		return nil, err
@@ -103,6 +165,7 @@ func ConnectToService(device DeviceEntry, serviceName string) (DeviceConnectionI
 	}
 	err = muxConn.connectWithStartServiceResponse(device.DeviceID, startServiceResponse, pairRecord)
 	if err != nil {
+		muxConn.Close()
 		return nil, err
 	}
 	return muxConn.ReleaseDeviceConnection(), nil
@@ -186,9 +249,13 @@ func (muxConn *UsbMuxConnection) connectWithStartServiceResponse(deviceID int, s
 	if err != nil {
 		return err
 	}
+	if tracked, ok := trackedConnOf(muxConn.deviceConn); ok {
+		tracked.startedService(startServiceResponse.Service)
+	}
 
 	var sslerr error
 	if startServiceResponse.EnableServiceSSL {
+		defer SetOperationDeadline(muxConn.deviceConn, OperationServiceStart)()
 		if _, ok := serviceConfigurations[startServiceResponse.Service]; ok {
 			sslerr = muxConn.deviceConn.EnableSessionSslHandshakeOnly(pairRecord)
 		} else {
@@ -220,7 +287,7 @@ func ConnectLockdownWithSession(device DeviceEntry) (*LockDownConnection, error)
 	}
 	resp, err := lockdownConnection.StartSession(pairRecord)
 	if err != nil {
-		return nil, fmt.Errorf("StartSession failed: %+v error: %v", resp, err)
+		return nil, fmt.Errorf("StartSession failed: %+v error: %w", resp, err)
 	}
 	return lockdownConnection, nil
 }
@@ -0,0 +1,157 @@
+package ios
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionKind tells what a tracked connection is used for
+type ConnectionKind string
+
+const (
+	// ConnectionKindUsbmuxd is a connection that talks to usbmuxd itself, f.ex. to list devices or read pair records
+	ConnectionKindUsbmuxd = ConnectionKind("usbmuxd")
+	// ConnectionKindLockdown is a connection to the lockdown service of a device
+	ConnectionKindLockdown = ConnectionKind("lockdown")
+	// ConnectionKindService is a connection to a service on a device, like AFC or a DTX based service
+	ConnectionKindService = ConnectionKind("service")
+)
+
+// ConnectionInfo describes an open connection through usbmuxd
+type ConnectionInfo struct {
+	ID           uint64         `json:"id"`
+	Kind         ConnectionKind `json:"kind"`
+	DeviceID     int            `json:"deviceId,omitempty"`
+	Service      string         `json:"service,omitempty"`
+	Owner        string         `json:"owner,omitempty"`
+	Opened       time.Time      `json:"opened"`
+	BytesRead    int64          `json:"bytesRead"`
+	BytesWritten int64          `json:"bytesWritten"`
+}
+
+// trackedConn counts the bytes of a net.Conn and removes itself from the tracked connections when it is closed
+type trackedConn struct {
+	net.Conn
+	mu      sync.Mutex
+	info    ConnectionInfo
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+var (
+	trackedConnsMu  sync.Mutex
+	trackedConns    = map[uint64]*trackedConn{}
+	nextTrackedConn uint64
+	connectionOwner func(deviceID int) string
+)
+
+// SetConnectionOwnerFunc configures a function that is called whenever a connection is made to a device.
+// It returns a description of whoever owns the connection, like a job id, which is shown by ListConnections.
+func SetConnectionOwnerFunc(owner func(deviceID int) string) {
+	trackedConnsMu.Lock()
+	defer trackedConnsMu.Unlock()
+	connectionOwner = owner
+}
+
+func trackConn(c net.Conn) *trackedConn {
+	trackedConnsMu.Lock()
+	defer trackedConnsMu.Unlock()
+	nextTrackedConn++
+	conn := &trackedConn{Conn: c, info: ConnectionInfo{ID: nextTrackedConn, Kind: ConnectionKindUsbmuxd, Opened: time.Now()}}
+	trackedConns[conn.info.ID] = conn
+	return conn
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	trackedConnsMu.Lock()
+	delete(trackedConns, c.info.ID)
+	trackedConnsMu.Unlock()
+	return c.Conn.Close()
+}
+
+// connectedTo records that the connection was forwarded to a port on a device
+func (c *trackedConn) connectedTo(kind ConnectionKind, deviceID int) {
+	trackedConnsMu.Lock()
+	owner := connectionOwner
+	trackedConnsMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.info.Kind = kind
+	c.info.DeviceID = deviceID
+	if owner != nil {
+		c.info.Owner = owner(deviceID)
+	}
+}
+
+func (c *trackedConn) startedService(service string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.info.Service = service
+}
+
+func (c *trackedConn) snapshot() ConnectionInfo {
+	c.mu.Lock()
+	info := c.info
+	c.mu.Unlock()
+	info.BytesRead = c.read.Load()
+	info.BytesWritten = c.written.Load()
+	return info
+}
+
+// trackedConnOf returns the trackedConn of a DeviceConnection, if it has one
+func trackedConnOf(deviceConn DeviceConnectionInterface) (*trackedConn, bool) {
+	conn, ok := deviceConn.(*DeviceConnection)
+	if !ok {
+		return nil, false
+	}
+	c := conn.c
+	if conn.unencryptedConn != nil {
+		c = conn.unencryptedConn
+	}
+	tracked, ok := c.(*trackedConn)
+	return tracked, ok
+}
+
+// ListConnections returns all connections through usbmuxd that are currently open, sorted by id
+func ListConnections() []ConnectionInfo {
+	trackedConnsMu.Lock()
+	conns := make([]*trackedConn, 0, len(trackedConns))
+	for _, c := range trackedConns {
+		conns = append(conns, c)
+	}
+	trackedConnsMu.Unlock()
+	result := make([]ConnectionInfo, len(conns))
+	for i, c := range conns {
+		result[i] = c.snapshot()
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// CloseConnection closes the connection with the given id. Whoever uses the connection gets an error
+// on the next read or write.
+func CloseConnection(id uint64) error {
+	trackedConnsMu.Lock()
+	conn, ok := trackedConns[id]
+	trackedConnsMu.Unlock()
+	if !ok {
+		return fmt.Errorf("CloseConnection: connection %d not found", id)
+	}
+	return conn.Close()
+}
@@ -0,0 +1,53 @@
+package ios
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func findConnection(id uint64) (ConnectionInfo, bool) {
+	for _, info := range ListConnections() {
+		if info.ID == id {
+			return info, true
+		}
+	}
+	return ConnectionInfo{}, false
+}
+
+func TestConnectionTracking(t *testing.T) {
+	SetConnectionOwnerFunc(func(deviceID int) string { return "job-1" })
+	defer SetConnectionOwnerFunc(nil)
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := trackConn(client)
+	deviceConn := NewDeviceConnectionWithConn(conn)
+	tracked, ok := trackedConnOf(deviceConn)
+	assert.True(t, ok)
+	tracked.connectedTo(ConnectionKindService, 5)
+	tracked.startedService("com.apple.afc")
+
+	go server.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	_, err := deviceConn.Read(buf)
+	assert.NoError(t, err)
+	go server.Read(make([]byte, 3))
+	_, err = deviceConn.Write([]byte("abc"))
+	assert.NoError(t, err)
+
+	info, ok := findConnection(conn.info.ID)
+	if assert.True(t, ok) {
+		assert.Equal(t, ConnectionKindService, info.Kind)
+		assert.Equal(t, 5, info.DeviceID)
+		assert.Equal(t, "com.apple.afc", info.Service)
+		assert.Equal(t, "job-1", info.Owner)
+		assert.Equal(t, int64(5), info.BytesRead)
+		assert.Equal(t, int64(3), info.BytesWritten)
+	}
+
+	assert.NoError(t, CloseConnection(info.ID))
+	_, ok = findConnection(info.ID)
+	assert.False(t, ok)
+	assert.Error(t, CloseConnection(info.ID))
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
+	"time"
 
 	"github.com/danielpaulus/go-ios/ios"
 	"github.com/danielpaulus/go-ios/ios/afc"
@@ -81,6 +83,63 @@ func copyReports(afc *afc.Connection, cwd string, pattern string, targetDir stri
 	return nil
 }
 
+// DownloadReportsBetween writes all crashreports that were modified between from and to to targetDir, keeping
+// the directory structure of the device. It returns the paths of the downloaded reports relative to targetDir.
+func DownloadReportsBetween(device ios.DeviceEntry, from time.Time, to time.Time, targetDir string) ([]string, error) {
+	err := moveReports(device)
+	if err != nil {
+		return nil, err
+	}
+	deviceConn, err := ios.ConnectToService(device, crashReportCopyMobileService)
+	if err != nil {
+		return nil, err
+	}
+	afc := afc.NewFromConn(deviceConn)
+	defer afc.Close()
+	return copyReportsBetween(afc, ".", from, to, targetDir)
+}
+
+func copyReportsBetween(afc *afc.Connection, cwd string, from time.Time, to time.Time, targetDir string) ([]string, error) {
+	files, err := afc.ListFiles(cwd, "*")
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, f := range files {
+		if f == "." || f == ".." {
+			continue
+		}
+		devicePath := path.Join(cwd, f)
+		info, err := afc.Stat(devicePath)
+		if err != nil {
+			log.Warnf("failed getting info for file: %s, skipping", devicePath)
+			continue
+		}
+		if info.IsDir() {
+			reports, err := copyReportsBetween(afc, devicePath, from, to, targetDir)
+			if err != nil {
+				return result, err
+			}
+			result = append(result, reports...)
+			continue
+		}
+		if info.ModTime().Before(from) || info.ModTime().After(to) {
+			continue
+		}
+		targetFilePath := filepath.Join(targetDir, filepath.FromSlash(devicePath))
+		err = os.MkdirAll(filepath.Dir(targetFilePath), 0o755)
+		if err != nil {
+			return result, err
+		}
+		err = afc.PullSingleFile(devicePath, targetFilePath)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, path.Clean(devicePath))
+	}
+	return result, nil
+}
+
 func RemoveReports(device ios.DeviceEntry, cwd string, pattern string) error {
 	if pattern == "" {
 		return fmt.Errorf("empty pattern not ok, just use *")
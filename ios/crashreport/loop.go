@@ -0,0 +1,102 @@
+package crashreport
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	log "github.com/sirupsen/logrus"
+)
+
+// the default LoopThresholds and how often WatchForLoop lists the crash reports
+const (
+	DefaultLoopCrashes       = 3
+	DefaultLoopWindowSeconds = 60
+	LoopPollInterval         = 5 * time.Second
+)
+
+// LoopThresholds decide when repeated crashes of an app are a crash loop
+type LoopThresholds struct {
+	// Crashes is how many crash reports within the window are a crash loop
+	Crashes int `json:"crashes"`
+	// WindowSeconds is the time the crashes have to happen in
+	WindowSeconds int `json:"windowSeconds"`
+}
+
+// withDefaults fills in the thresholds that are not set
+func (t LoopThresholds) withDefaults() LoopThresholds {
+	if t.Crashes <= 0 {
+		t.Crashes = DefaultLoopCrashes
+	}
+	if t.WindowSeconds <= 0 {
+		t.WindowSeconds = DefaultLoopWindowSeconds
+	}
+	return t
+}
+
+// CrashLoop is an app that crashed repeatedly within a short time
+type CrashLoop struct {
+	BundleID string `json:"bundleId"`
+	// Reports are the crash reports within the window, newest first
+	Reports    []Report       `json:"reports"`
+	Verdict    string         `json:"verdict"`
+	Thresholds LoopThresholds `json:"thresholds"`
+}
+
+// findLoop returns the crash loop if thresholds.Crashes of the reports were written within the window
+func findLoop(bundleID string, reports []Report, thresholds LoopThresholds) *CrashLoop {
+	reports = append([]Report{}, reports...)
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Modified.After(reports[j].Modified) })
+	window := time.Duration(thresholds.WindowSeconds) * time.Second
+	for i := 0; i+thresholds.Crashes <= len(reports); i++ {
+		last := i + thresholds.Crashes - 1
+		if reports[i].Modified.Sub(reports[last].Modified) > window {
+			continue
+		}
+		for last+1 < len(reports) && reports[i].Modified.Sub(reports[last+1].Modified) <= window {
+			last++
+		}
+		return &CrashLoop{
+			BundleID:   bundleID,
+			Reports:    reports[i : last+1],
+			Verdict:    fmt.Sprintf("crash loop, %s crashed %d times within %ds", bundleID, last-i+1, thresholds.WindowSeconds),
+			Thresholds: thresholds,
+		}
+	}
+	return nil
+}
+
+// WatchForLoop lists the crash reports of the app written since the watch started every LoopPollInterval and
+// whenever wake receives, f.ex. because a process of the app terminated. It returns once the app crashed
+// thresholds.Crashes times within the window, or with ctx.Err() if ctx is done first. Only .ips reports name
+// the bundle id, so crash loops are detected on iOS 15 and later.
+func WatchForLoop(ctx context.Context, device ios.DeviceEntry, bundleID string, thresholds LoopThresholds, wake <-chan struct{}) (*CrashLoop, error) {
+	list := func(filter Filter) ([]Report, error) {
+		return Reports(device, filter)
+	}
+	return watchForLoop(ctx, list, bundleID, thresholds, wake, time.Now(), LoopPollInterval)
+}
+
+func watchForLoop(ctx context.Context, list func(Filter) ([]Report, error), bundleID string, thresholds LoopThresholds, wake <-chan struct{}, since time.Time, interval time.Duration) (*CrashLoop, error) {
+	thresholds = thresholds.withDefaults()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		case <-wake:
+		}
+		reports, err := list(Filter{BundleID: bundleID, From: since})
+		if err != nil {
+			log.WithField("bundleId", bundleID).WithError(err).Debug("failed listing crash reports")
+			continue
+		}
+		if loop := findLoop(bundleID, reports, thresholds); loop != nil {
+			return loop, nil
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package crashreport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func crashesAt(start time.Time, seconds ...int) []Report {
+	reports := make([]Report, len(seconds))
+	for i, s := range seconds {
+		reports[i] = Report{Name: fmt.Sprintf("Example-%d.ips", s), BundleID: "com.example.app", Modified: start.Add(time.Duration(s) * time.Second)}
+	}
+	return reports
+}
+
+func TestFindLoop(t *testing.T) {
+	start := time.Date(2024, 1, 16, 12, 0, 0, 0, time.UTC)
+	thresholds := LoopThresholds{}.withDefaults()
+
+	assert.Nil(t, findLoop("com.example.app", crashesAt(start, 0, 70, 140), thresholds), "crashes far apart")
+	assert.Nil(t, findLoop("com.example.app", crashesAt(start, 0, 5), thresholds))
+
+	loop := findLoop("com.example.app", crashesAt(start, 0, 200, 210, 220, 230), thresholds)
+	require.NotNil(t, loop)
+	assert.Len(t, loop.Reports, 4)
+	assert.Equal(t, start.Add(230*time.Second), loop.Reports[0].Modified, "newest first")
+	assert.Equal(t, "crash loop, com.example.app crashed 4 times within 60s", loop.Verdict)
+
+	loop = findLoop("com.example.app", crashesAt(start, 0, 10, 20), LoopThresholds{Crashes: 2, WindowSeconds: 10}.withDefaults())
+	require.NotNil(t, loop)
+	assert.Len(t, loop.Reports, 2)
+}
+
+func TestWatchForLoop(t *testing.T) {
+	start := time.Date(2024, 1, 16, 12, 0, 0, 0, time.UTC)
+	reports := make(chan []Report, 3)
+	reports <- nil
+	reports <- crashesAt(start, 0)
+	reports <- crashesAt(start, 0, 1, 2)
+	var filters []Filter
+	list := func(filter Filter) ([]Report, error) {
+		filters = append(filters, filter)
+		select {
+		case r := <-reports:
+			return r, nil
+		default:
+			return nil, errors.New("no more reports")
+		}
+	}
+	wake := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			wake <- struct{}{}
+		}
+	}()
+	loop, err := watchForLoop(context.Background(), list, "com.example.app", LoopThresholds{}, wake, start, time.Hour)
+	require.NoError(t, err)
+	assert.Len(t, loop.Reports, 3)
+	assert.Equal(t, Filter{BundleID: "com.example.app", From: start}, filters[0])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	loop, err = watchForLoop(ctx, list, "com.example.app", LoopThresholds{}, nil, start, time.Hour)
+	assert.Nil(t, loop)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,186 @@
+package crashreport
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/afc"
+	log "github.com/sirupsen/logrus"
+)
+
+// ipsHeaderSize is how much of a report is read to parse its header, the json header is the first line of .ips files
+const ipsHeaderSize = 4096
+
+// reportDatePattern matches the date crash reporter appends to the process name in report file names
+var reportDatePattern = regexp.MustCompile(`-\d{4}-\d{2}-\d{2}-\d{6}$`)
+
+// Report is a crash report on the device
+type Report struct {
+	// Name is the path of the report in the crash report directory, f.ex. Retired/Example-2024-01-16-153643.ips
+	Name    string `json:"name"`
+	Process string `json:"process"`
+	// BundleID and AppVersion are read from the header of .ips reports, they are empty for other reports
+	BundleID   string    `json:"bundleId,omitempty"`
+	AppVersion string    `json:"appVersion,omitempty"`
+	Size       int64     `json:"size"`
+	Modified   time.Time `json:"modified"`
+}
+
+// Filter selects crash reports, empty fields match all reports
+type Filter struct {
+	BundleID string
+	Process  string
+	// From and To limit the modification time of the reports
+	From time.Time
+	To   time.Time
+}
+
+func (f Filter) matchesFile(modified time.Time) bool {
+	if !f.From.IsZero() && modified.Before(f.From) {
+		return false
+	}
+	return f.To.IsZero() || !modified.After(f.To)
+}
+
+func (f Filter) matches(report Report) bool {
+	if f.BundleID != "" && report.BundleID != f.BundleID {
+		return false
+	}
+	return f.Process == "" || report.Process == f.Process
+}
+
+// ipsHeader is the first line of an .ips report
+type ipsHeader struct {
+	AppName    string `json:"app_name"`
+	Name       string `json:"name"`
+	BundleID   string `json:"bundleID"`
+	AppVersion string `json:"app_version"`
+}
+
+// parseIPSHeader reads the json header of an .ips report, reports of older iOS versions have none
+func parseIPSHeader(r io.Reader) (ipsHeader, bool) {
+	line, err := bufio.NewReaderSize(r, ipsHeaderSize).ReadSlice('\n')
+	if err != nil && err != io.EOF {
+		return ipsHeader{}, false
+	}
+	var header ipsHeader
+	if json.Unmarshal(line, &header) != nil {
+		return ipsHeader{}, false
+	}
+	return header, true
+}
+
+// processName derives the process from the file name of a report, f.ex. Example from Example-2024-01-16-153643.ips
+func processName(name string) string {
+	base := path.Base(name)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	return reportDatePattern.ReplaceAllString(base, "")
+}
+
+// validReportName rejects names that leave the crash report directory
+func validReportName(name string) (string, error) {
+	cleaned := path.Clean("/" + name)[1:]
+	if cleaned == "" || cleaned != strings.TrimPrefix(name, "/") {
+		return "", fmt.Errorf("invalid crash report name '%s'", name)
+	}
+	return cleaned, nil
+}
+
+// Reports moves new crash reports into the crash report directory and lists those matching the filter, newest first
+func Reports(device ios.DeviceEntry, filter Filter) ([]Report, error) {
+	err := moveReports(device)
+	if err != nil {
+		return nil, err
+	}
+	deviceConn, err := ios.ConnectToService(device, crashReportCopyMobileService)
+	if err != nil {
+		return nil, err
+	}
+	conn := afc.NewFromConn(deviceConn)
+	defer conn.Close()
+	reports, err := listReports(conn, ".", filter)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Modified.After(reports[j].Modified) })
+	return reports, nil
+}
+
+func listReports(conn *afc.Connection, cwd string, filter Filter) ([]Report, error) {
+	files, err := conn.ListFiles(cwd, "*")
+	if err != nil {
+		return nil, err
+	}
+	reports := []Report{}
+	for _, f := range files {
+		if f == "." || f == ".." {
+			continue
+		}
+		devicePath := path.Join(cwd, f)
+		info, err := conn.Stat(devicePath)
+		if err != nil {
+			log.Warnf("failed getting info for file: %s, skipping", devicePath)
+			continue
+		}
+		if info.IsDir() {
+			nested, err := listReports(conn, devicePath, filter)
+			if err != nil {
+				return reports, err
+			}
+			reports = append(reports, nested...)
+			continue
+		}
+		if !filter.matchesFile(info.ModTime()) {
+			continue
+		}
+		report := Report{Name: path.Clean(devicePath), Process: processName(f), Size: info.Size(), Modified: info.ModTime()}
+		if path.Ext(f) == ".ips" {
+			readHeader(conn, &report)
+		}
+		if filter.matches(report) {
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+func readHeader(conn *afc.Connection, report *Report) {
+	file, err := conn.Open(report.Name, afc.Afc_Mode_RDONLY)
+	if err != nil {
+		log.WithError(err).Debugf("failed opening crash report %s", report.Name)
+		return
+	}
+	defer file.Close()
+	header, ok := parseIPSHeader(io.LimitReader(file, ipsHeaderSize))
+	if !ok {
+		return
+	}
+	report.BundleID = header.BundleID
+	report.AppVersion = header.AppVersion
+	if header.AppName != "" {
+		report.Process = header.AppName
+	}
+}
+
+// ReadReport writes the crash report with the given name to w
+func ReadReport(device ios.DeviceEntry, name string, w io.Writer) error {
+	name, err := validReportName(name)
+	if err != nil {
+		return err
+	}
+	deviceConn, err := ios.ConnectToService(device, crashReportCopyMobileService)
+	if err != nil {
+		return err
+	}
+	conn := afc.NewFromConn(deviceConn)
+	defer conn.Close()
+	return conn.ReadFile(name, w)
+}
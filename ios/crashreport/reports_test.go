@@ -0,0 +1,50 @@
+package crashreport
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIPSHeader(t *testing.T) {
+	report := `{"app_name":"Example","timestamp":"2024-01-16 15:36:43.00 +0100","app_version":"1.2","bundleID":"com.example.app","bug_type":"309"}
+{
+  "uptime" : 1000,
+  "procName" : "Example"
+}`
+	header, ok := parseIPSHeader(strings.NewReader(report))
+	assert.True(t, ok)
+	assert.Equal(t, ipsHeader{AppName: "Example", BundleID: "com.example.app", AppVersion: "1.2"}, header)
+
+	_, ok = parseIPSHeader(strings.NewReader("Incident Identifier: 5F0C\nCrashReporter Key: abc\n"))
+	assert.False(t, ok, "old text reports have no json header")
+}
+
+func TestProcessName(t *testing.T) {
+	assert.Equal(t, "Example", processName("Retired/Example-2024-01-16-153643.ips"))
+	assert.Equal(t, "JetsamEvent", processName("JetsamEvent-2024-01-16-153643.ips"))
+	assert.Equal(t, "stacks+SpringBoard", processName("stacks+SpringBoard.ips"))
+}
+
+func TestValidReportName(t *testing.T) {
+	name, err := validReportName("Retired/Example-2024-01-16-153643.ips")
+	assert.NoError(t, err)
+	assert.Equal(t, "Retired/Example-2024-01-16-153643.ips", name)
+	for _, invalid := range []string{"", "../etc/passwd", "Retired/../../x", "."} {
+		_, err := validReportName(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	day := time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)
+	filter := Filter{BundleID: "com.example.app", From: day, To: day.Add(24 * time.Hour)}
+	assert.True(t, filter.matchesFile(day.Add(time.Hour)))
+	assert.False(t, filter.matchesFile(day.Add(-time.Hour)))
+	assert.False(t, filter.matchesFile(day.Add(25*time.Hour)))
+	assert.True(t, filter.matches(Report{BundleID: "com.example.app"}))
+	assert.False(t, filter.matches(Report{Process: "Example"}))
+	assert.True(t, Filter{}.matches(Report{Process: "Example"}))
+}
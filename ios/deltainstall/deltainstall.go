@@ -0,0 +1,303 @@
+// Package deltainstall installs new builds of an app by uploading only the files that changed since the last install.
+// The app bundle stays in the afc staging directory between installs together with a manifest of its file hashes,
+// installation_proxy installs it from there. If the staged bundle or its manifest is gone, f.ex. because installd
+// moved it or the device was erased, the complete bundle is uploaded again.
+package deltainstall
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/afc"
+	"github.com/danielpaulus/go-ios/ios/installationproxy"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// StagingDir is the afc directory the bundles of all apps are staged in, one sub directory per bundle id
+	StagingDir   = "/PublicStaging/go-ios"
+	manifestName = "manifest.json"
+)
+
+// Stats tells how much of an app bundle an install uploaded
+type Stats struct {
+	Files         int   `json:"files"`
+	Bytes         int64 `json:"bytes"`
+	UploadedFiles int   `json:"uploadedFiles"`
+	UploadedBytes int64 `json:"uploadedBytes"`
+	RemovedFiles  int   `json:"removedFiles"`
+}
+
+// manifest describes a staged app bundle, paths are relative to the bundle and use slashes
+type manifest struct {
+	App   string               `json:"app"`
+	Dirs  []string             `json:"dirs"`
+	Files map[string]fileEntry `json:"files"`
+}
+
+type fileEntry struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// stagingFS are the afc operations staging needs, tests replace them with a local directory
+type stagingFS interface {
+	MkDir(path string) error
+	ReadDir(path string) ([]string, error)
+	ReadFile(path string, w io.Writer) error
+	WriteToFile(reader io.Reader, path string) error
+	Remove(path string) error
+	RemovePathAndContents(path string) error
+}
+
+// Installer stages app bundles with afc and installs them with installation_proxy
+type Installer struct {
+	fs    *afc.Connection
+	proxy *installationproxy.Connection
+}
+
+// New connects to afc and installation_proxy of the device
+func New(device ios.DeviceEntry) (*Installer, error) {
+	fs, err := afc.New(device)
+	if err != nil {
+		return nil, fmt.Errorf("New: %w", err)
+	}
+	proxy, err := installationproxy.New(device)
+	if err != nil {
+		fs.Close()
+		return nil, fmt.Errorf("New: %w", err)
+	}
+	return &Installer{fs: fs, proxy: proxy}, nil
+}
+
+// Close closes the connections, a running Install fails
+func (i *Installer) Close() error {
+	i.fs.Close()
+	i.proxy.Close()
+	return nil
+}
+
+// Install uploads the files of the app bundle at appDir, f.ex. Payload/App.app of an extracted ipa, that changed since
+// the last Install of bundleID and installs the staged bundle. progress is called with every status update of the
+// installation if it is not nil.
+func (i *Installer) Install(appDir string, bundleID string, progress func(status string, percent int)) (Stats, error) {
+	stagedApp, stats, err := stage(i.fs, appDir, bundleID)
+	if err != nil {
+		return stats, fmt.Errorf("Install: %w", err)
+	}
+	log.WithFields(log.Fields{"bundleID": bundleID, "files": stats.Files, "uploadedFiles": stats.UploadedFiles, "uploadedBytes": stats.UploadedBytes}).Info("staged app")
+	err = i.proxy.Install(strings.TrimPrefix(stagedApp, "/"), map[string]interface{}{"PackageType": "Developer"}, progress)
+	if err != nil {
+		return stats, fmt.Errorf("Install: %w", err)
+	}
+	return stats, nil
+}
+
+// stage brings the staged copy of appDir up to date and returns its afc path
+func stage(fs stagingFS, appDir string, bundleID string) (string, Stats, error) {
+	if bundleID == "" || bundleID == "." || bundleID == ".." || strings.ContainsAny(bundleID, "/\\") {
+		return "", Stats{}, fmt.Errorf("stage: invalid bundle id '%s'", bundleID)
+	}
+	local, err := readLocalManifest(appDir)
+	if err != nil {
+		return "", Stats{}, err
+	}
+	stats := Stats{Files: len(local.Files)}
+	for _, f := range local.Files {
+		stats.Bytes += f.Size
+	}
+	base := path.Join(StagingDir, bundleID)
+	app := path.Join(base, local.App)
+	manifestPath := path.Join(base, manifestName)
+
+	staged := readStagedManifest(fs, manifestPath)
+	if staged != nil && staged.App == local.App {
+		if _, err := fs.ReadDir(app); err != nil {
+			staged = nil
+		}
+	} else {
+		staged = nil
+	}
+	if staged == nil {
+		log.WithField("bundleID", bundleID).Debug("no staged app, uploading all files")
+		fs.RemovePathAndContents(base)
+		staged = &manifest{App: local.App}
+	} else {
+		// the manifest only describes the staged app while nothing changes it
+		err = fs.Remove(manifestPath)
+		if err != nil {
+			return "", Stats{}, fmt.Errorf("stage: %w", err)
+		}
+	}
+
+	for _, dir := range []string{path.Dir(StagingDir), StagingDir, base, app} {
+		err = fs.MkDir(dir)
+		if err != nil {
+			return "", Stats{}, fmt.Errorf("stage: %w", err)
+		}
+	}
+	for _, name := range sortedKeys(staged.Files) {
+		if _, ok := local.Files[name]; ok {
+			continue
+		}
+		err = fs.Remove(path.Join(app, name))
+		if err != nil {
+			return "", Stats{}, fmt.Errorf("stage: %w", err)
+		}
+		stats.RemovedFiles++
+	}
+	localDirs := map[string]bool{}
+	for _, dir := range local.Dirs {
+		localDirs[dir] = true
+	}
+	stagedDirs := map[string]bool{}
+	for _, dir := range staged.Dirs {
+		stagedDirs[dir] = true
+	}
+	removed := map[string]bool{}
+	for _, dir := range staged.Dirs {
+		if localDirs[dir] {
+			continue
+		}
+		if removed[path.Dir(dir)] {
+			removed[dir] = true
+			continue
+		}
+		err = fs.RemovePathAndContents(path.Join(app, dir))
+		if err != nil {
+			return "", Stats{}, fmt.Errorf("stage: %w", err)
+		}
+		removed[dir] = true
+	}
+	for _, dir := range local.Dirs {
+		if stagedDirs[dir] {
+			continue
+		}
+		err = fs.MkDir(path.Join(app, dir))
+		if err != nil {
+			return "", Stats{}, fmt.Errorf("stage: %w", err)
+		}
+	}
+	for _, name := range sortedKeys(local.Files) {
+		entry := local.Files[name]
+		if old, ok := staged.Files[name]; ok && old == entry {
+			continue
+		}
+		err = uploadFile(fs, filepath.Join(appDir, filepath.FromSlash(name)), path.Join(app, name))
+		if err != nil {
+			return "", Stats{}, fmt.Errorf("stage: %w", err)
+		}
+		stats.UploadedFiles++
+		stats.UploadedBytes += entry.Size
+	}
+
+	b, err := json.Marshal(local)
+	if err != nil {
+		return "", Stats{}, fmt.Errorf("stage: %w", err)
+	}
+	err = fs.WriteToFile(bytes.NewReader(b), manifestPath)
+	if err != nil {
+		return "", Stats{}, fmt.Errorf("stage: %w", err)
+	}
+	return app, stats, nil
+}
+
+func uploadFile(fs stagingFS, src string, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fs.WriteToFile(f, dst)
+}
+
+// readStagedManifest returns nil if there is no valid manifest
+func readStagedManifest(fs stagingFS, manifestPath string) *manifest {
+	var b bytes.Buffer
+	err := fs.ReadFile(manifestPath, &b)
+	if err != nil {
+		return nil
+	}
+	var m manifest
+	err = json.Unmarshal(b.Bytes(), &m)
+	if err != nil || m.App == "" {
+		return nil
+	}
+	return &m
+}
+
+// readLocalManifest hashes all files of the app bundle at appDir, directories are sorted parents first
+func readLocalManifest(appDir string) (manifest, error) {
+	info, err := os.Stat(appDir)
+	if err != nil {
+		return manifest{}, fmt.Errorf("readLocalManifest: %w", err)
+	}
+	if !info.IsDir() {
+		return manifest{}, fmt.Errorf("readLocalManifest: %s is not an app bundle", appDir)
+	}
+	m := manifest{App: filepath.Base(appDir), Files: map[string]fileEntry{}}
+	err = filepath.WalkDir(appDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == appDir {
+			return nil
+		}
+		rel, err := filepath.Rel(appDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case d.IsDir():
+			m.Dirs = append(m.Dirs, rel)
+			return nil
+		case !d.Type().IsRegular():
+			return fmt.Errorf("%s is not a regular file", rel)
+		}
+		entry, err := hashFile(p)
+		if err != nil {
+			return err
+		}
+		m.Files[rel] = entry
+		return nil
+	})
+	if err != nil {
+		return manifest{}, fmt.Errorf("readLocalManifest: %w", err)
+	}
+	return m, nil
+}
+
+func hashFile(p string) (fileEntry, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return fileEntry{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return fileEntry{}, err
+	}
+	return fileEntry{Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func sortedKeys(m map[string]fileEntry) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
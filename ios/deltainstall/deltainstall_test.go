@@ -0,0 +1,147 @@
+package deltainstall
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dirFS stages into a local directory like afc does into the media directory of the device
+type dirFS struct {
+	root    string
+	written []string
+}
+
+func (d *dirFS) path(name string) string {
+	return filepath.Join(d.root, filepath.FromSlash(name))
+}
+
+func (d *dirFS) MkDir(name string) error {
+	return os.MkdirAll(d.path(name), 0o755)
+}
+
+func (d *dirFS) ReadDir(name string) ([]string, error) {
+	entries, err := os.ReadDir(d.path(name))
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, err
+}
+
+func (d *dirFS) ReadFile(name string, w io.Writer) error {
+	f, err := os.Open(d.path(name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (d *dirFS) WriteToFile(reader io.Reader, name string) error {
+	d.written = append(d.written, name)
+	f, err := os.Create(d.path(name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, reader)
+	return err
+}
+
+func (d *dirFS) Remove(name string) error {
+	return os.Remove(d.path(name))
+}
+
+func (d *dirFS) RemovePathAndContents(name string) error {
+	return os.RemoveAll(d.path(name))
+}
+
+func writeApp(t *testing.T, dir string, files map[string]string) {
+	require.NoError(t, os.RemoveAll(dir))
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+}
+
+func readTree(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		rel, _ := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = string(b)
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func TestStageUploadsChangedFiles(t *testing.T) {
+	appDir := filepath.Join(t.TempDir(), "Test.app")
+	fs := &dirFS{root: t.TempDir()}
+	stagedApp := StagingDir + "/com.example.test/Test.app"
+
+	v1 := map[string]string{
+		"Info.plist":                  "v1",
+		"Test":                        "binary v1",
+		"Frameworks/A.framework/A":    "framework",
+		"Frameworks/A.framework/Info": "framework info",
+		"Assets.car":                  "assets",
+	}
+	writeApp(t, appDir, v1)
+	app, stats, err := stage(fs, appDir, "com.example.test")
+	require.NoError(t, err)
+	assert.Equal(t, stagedApp, app)
+	assert.Equal(t, 5, stats.UploadedFiles)
+	assert.Equal(t, stats.Bytes, stats.UploadedBytes)
+	assert.Equal(t, v1, readTree(t, fs.path(app)))
+
+	fs.written = nil
+	_, stats, err = stage(fs, appDir, "com.example.test")
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Files: 5, Bytes: stats.Bytes}, stats)
+	assert.Equal(t, []string{StagingDir + "/com.example.test/manifest.json"}, fs.written)
+
+	v2 := map[string]string{
+		"Info.plist":        "v1",
+		"Test":              "binary v2",
+		"Assets.car":        "assets",
+		"PlugIns/W.appex/W": "extension",
+	}
+	writeApp(t, appDir, v2)
+	fs.written = nil
+	_, stats, err = stage(fs, appDir, "com.example.test")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.UploadedFiles)
+	assert.Equal(t, int64(len("binary v2")+len("extension")), stats.UploadedBytes)
+	assert.Equal(t, 2, stats.RemovedFiles)
+	assert.Equal(t, v2, readTree(t, fs.path(app)))
+	_, err = os.Stat(fs.path(app + "/Frameworks"))
+	assert.True(t, os.IsNotExist(err))
+
+	// installd moved the staged bundle, everything is uploaded again
+	require.NoError(t, os.RemoveAll(fs.path(app)))
+	_, stats, err = stage(fs, appDir, "com.example.test")
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.UploadedFiles)
+	assert.Equal(t, v2, readTree(t, fs.path(app)))
+}
+
+func TestStageRejectsInvalidBundleIDs(t *testing.T) {
+	appDir := filepath.Join(t.TempDir(), "Test.app")
+	writeApp(t, appDir, map[string]string{"Info.plist": "v1"})
+	for _, bundleID := range []string{"", "..", "a/b", `a\b`} {
+		_, _, err := stage(&dirFS{root: t.TempDir()}, appDir, bundleID)
+		assert.Error(t, err, bundleID)
+	}
+}
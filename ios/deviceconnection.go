@@ -142,7 +142,7 @@ func (conn *DeviceConnection) connectToSocketAddress(socketAddress string) error
 		return err
 	}
 	log.Tracef("Opening connection: %v", &c)
-	conn.c = c
+	conn.c = trackConn(c)
 	return nil
 }
 
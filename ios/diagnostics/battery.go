@@ -0,0 +1,115 @@
+package diagnostics
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMobileGestaltDeprecated is returned by MobileGestalt on iOS versions that no longer answer MobileGestalt queries
+var ErrMobileGestaltDeprecated = errors.New("MobileGestalt is deprecated on this iOS version")
+
+// Battery is the state of the battery as reported by the AppleSmartBattery IORegistry entry
+type Battery struct {
+	// CurrentCapacity is the charge in percent
+	CurrentCapacity int `json:"currentCapacity"`
+	CycleCount      int `json:"cycleCount"`
+	// DesignCapacity, NominalChargeCapacity and RawMaxCapacity are in mAh
+	DesignCapacity        int `json:"designCapacity"`
+	NominalChargeCapacity int `json:"nominalChargeCapacity"`
+	RawMaxCapacity        int `json:"rawMaxCapacity"`
+	// Health is the full charge capacity in percent of the design capacity
+	Health float64 `json:"health"`
+	// Temperature is in degrees celsius
+	Temperature       float64 `json:"temperature"`
+	Voltage           int     `json:"voltage"`
+	InstantAmperage   int     `json:"instantAmperage"`
+	IsCharging        bool    `json:"isCharging"`
+	ExternalConnected bool    `json:"externalConnected"`
+	FullyCharged      bool    `json:"fullyCharged"`
+}
+
+// Battery queries the AppleSmartBattery IORegistry entry
+func (diagnosticsConn *Connection) Battery() (Battery, error) {
+	resp, err := diagnosticsConn.IORegEntryQuery("AppleSmartBattery")
+	if err != nil {
+		return Battery{}, err
+	}
+	values, err := diagnosticsValues(resp, "IORegistry")
+	if err != nil {
+		return Battery{}, fmt.Errorf("Battery: %w", err)
+	}
+	return batteryFromIORegistry(values), nil
+}
+
+// MobileGestalt queries the MobileGestalt keys, keys unknown to the device are missing in the result
+func (diagnosticsConn *Connection) MobileGestalt(keys []string) (map[string]interface{}, error) {
+	resp, err := diagnosticsConn.MobileGestaltQuery(keys)
+	if err != nil {
+		return nil, err
+	}
+	values, err := diagnosticsValues(resp, "MobileGestalt")
+	if err != nil {
+		return nil, fmt.Errorf("MobileGestalt: %w", err)
+	}
+	if values["Status"] == "MobileGestaltDeprecated" {
+		return nil, ErrMobileGestaltDeprecated
+	}
+	delete(values, "Status")
+	return values, nil
+}
+
+// diagnosticsValues returns the values of a Diagnostics response
+func diagnosticsValues(resp interface{}, key string) (map[string]interface{}, error) {
+	response, ok := resp.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected response %+v", resp)
+	}
+	if status, _ := response["Status"].(string); status != "Success" {
+		return nil, fmt.Errorf("request failed with status '%v'", response["Status"])
+	}
+	diagnostics, _ := response["Diagnostics"].(map[string]interface{})
+	values, ok := diagnostics[key].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("response has no %s values: %+v", key, resp)
+	}
+	return values, nil
+}
+
+func batteryFromIORegistry(values map[string]interface{}) Battery {
+	battery := Battery{
+		CurrentCapacity:       intValue(values["CurrentCapacity"]),
+		CycleCount:            intValue(values["CycleCount"]),
+		DesignCapacity:        intValue(values["DesignCapacity"]),
+		NominalChargeCapacity: intValue(values["NominalChargeCapacity"]),
+		RawMaxCapacity:        intValue(values["AppleRawMaxCapacity"]),
+		Temperature:           float64(intValue(values["Temperature"])) / 100,
+		Voltage:               intValue(values["Voltage"]),
+		InstantAmperage:       intValue(values["InstantAmperage"]),
+	}
+	battery.IsCharging, _ = values["IsCharging"].(bool)
+	battery.ExternalConnected, _ = values["ExternalConnected"].(bool)
+	battery.FullyCharged, _ = values["FullyCharged"].(bool)
+	full := battery.NominalChargeCapacity
+	if full == 0 {
+		full = battery.RawMaxCapacity
+	}
+	if battery.DesignCapacity > 0 {
+		battery.Health = float64(full) * 100 / float64(battery.DesignCapacity)
+	}
+	return battery
+}
+
+// intValue converts the integer types plists are decoded to
+func intValue(value interface{}) int {
+	switch v := value.(type) {
+	case uint64:
+		return int(int64(v))
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+package diagnostics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatteryFromIORegistry(t *testing.T) {
+	resp := map[string]interface{}{
+		"Status": "Success",
+		"Diagnostics": map[string]interface{}{"IORegistry": map[string]interface{}{
+			"CurrentCapacity":       uint64(87),
+			"CycleCount":            uint64(312),
+			"DesignCapacity":        uint64(3000),
+			"NominalChargeCapacity": uint64(2700),
+			"Temperature":           uint64(3125),
+			"InstantAmperage":       uint64(0xFFFFFFFFFFFFFE0C),
+			"IsCharging":            false,
+			"ExternalConnected":     true,
+		}},
+	}
+	values, err := diagnosticsValues(resp, "IORegistry")
+	require.NoError(t, err)
+	battery := batteryFromIORegistry(values)
+	assert.Equal(t, 87, battery.CurrentCapacity)
+	assert.Equal(t, 312, battery.CycleCount)
+	assert.Equal(t, 90.0, battery.Health)
+	assert.Equal(t, 31.25, battery.Temperature)
+	assert.Equal(t, -500, battery.InstantAmperage, "the amperage is negative while discharging")
+	assert.True(t, battery.ExternalConnected)
+
+	_, err = diagnosticsValues(map[string]interface{}{"Status": "UnknownRequest"}, "IORegistry")
+	assert.Error(t, err)
+}
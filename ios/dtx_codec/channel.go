@@ -33,6 +33,15 @@ func WithTimeout(seconds uint32) ChannelOption {
 	}
 }
 
+// WithTimeoutDuration is like WithTimeout for timeouts that are not full seconds. A zero duration keeps the default timeout.
+func WithTimeoutDuration(timeout time.Duration) ChannelOption {
+	return func(h *Channel) {
+		if timeout > 0 {
+			h.timeout = timeout
+		}
+	}
+}
+
 func (d *Channel) RegisterMethodForRemote(selector string) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
@@ -115,11 +124,20 @@ func (d *Channel) SendAndAwaitReply(expectsReply bool, messageType MessageType,
 	if err != nil {
 		return Message{}, err
 	}
+	// a zero timeout waits for the response until the connection is closed
+	var timedOut <-chan time.Time
+	if d.timeout > 0 {
+		timer := time.NewTimer(d.timeout)
+		defer timer.Stop()
+		timedOut = timer.C
+	}
 	select {
 	case response := <-responseChannel:
 		return response, nil
-	case <-time.After(d.timeout):
+	case <-timedOut:
 		return Message{}, fmt.Errorf("Timed out waiting for response for message:%d channel:%d", identifier, d.channelCode)
+	case <-d.connection.Closed():
+		return Message{}, fmt.Errorf("Connection closed waiting for response for message:%d channel:%d: %w", identifier, d.channelCode, ErrConnectionClosed)
 	}
 }
 
@@ -2,12 +2,12 @@ package dtx
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
 	"math"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/danielpaulus/go-ios/ios"
 
@@ -33,6 +33,7 @@ type Connection struct {
 	closed    chan struct{}
 	err       error
 	closeOnce sync.Once
+	wireLog   ios.WireLogger
 }
 
 // Dispatcher is a simple interface containing a Dispatch func to receive dtx.Messages
@@ -141,7 +142,7 @@ func newDtxConnection(conn ios.DeviceConnectionInterface) (*Connection, error) {
 	requestChannelMessages := make(chan Message, 5)
 
 	// The global channel has channelCode 0, so we need to start with channelCodeCounter==1
-	dtxConnection := &Connection{deviceConnection: conn, channelCodeCounter: 1, requestChannelMessages: requestChannelMessages}
+	dtxConnection := &Connection{deviceConnection: conn, channelCodeCounter: 1, requestChannelMessages: requestChannelMessages, wireLog: ios.NewWireLogger(conn)}
 	dtxConnection.closed = make(chan struct{})
 
 	// The global channel is automatically present and used for requesting other channels and some other methods like notifyPublishedCapabilities
@@ -152,7 +153,7 @@ func newDtxConnection(conn ios.DeviceConnectionInterface) (*Connection, error) {
 		responseWaiters:   map[int]chan Message{},
 		registeredMethods: map[string]chan Message{},
 		defragmenters:     map[int]*FragmentDecoder{},
-		timeout:           5 * time.Second,
+		timeout:           ios.PolicyFor(ios.OperationDTXRequest).Timeout,
 	}
 	dtxConnection.globalChannel = &globalChannel
 	go reader(dtxConnection)
@@ -162,14 +163,45 @@ func newDtxConnection(conn ios.DeviceConnectionInterface) (*Connection, error) {
 
 // Send sends the byte slice directly to the device using the underlying DeviceConnectionInterface
 func (dtxConn *Connection) Send(message []byte) error {
+	if dtxConn.wireLog.Enabled(ios.WireLogMessages) {
+		if msg, err := ReadMessage(bytes.NewReader(message)); err == nil {
+			dtxConn.wireLog.Message("send", msg.StringDebug())
+		}
+		dtxConn.wireLog.Frame("send", message)
+	}
+	message, err := ios.InjectFault(ios.FaultTransportDTX, message)
+	if err != nil {
+		dtxConn.deviceConnection.Close()
+		return err
+	}
+	if message == nil {
+		return nil
+	}
 	return dtxConn.deviceConnection.Send(message)
 }
 
 // reader reads messages from the byte stream and dispatches them to the right channel when they are decoded.
 func reader(dtxConn *Connection) {
+	// frame records the bytes of the message that is read for the wire log and fault injection
+	frame := &bytes.Buffer{}
 	reader := bufio.NewReader(dtxConn.deviceConnection.Reader())
 	for {
-		msg, err := ReadMessage(reader)
+		frame.Reset()
+		var msg Message
+		var err error
+		faults := ios.FaultsEnabled()
+		if faults || dtxConn.wireLog.Enabled(ios.WireLogHexDump) {
+			msg, err = ReadMessage(io.TeeReader(reader, frame))
+		} else {
+			msg, err = ReadMessage(reader)
+		}
+		if err == nil && faults {
+			var dropped bool
+			msg, dropped, err = injectFault(msg, frame.Bytes())
+			if dropped {
+				continue
+			}
+		}
 		if err != nil {
 			defer dtxConn.close(err)
 			errText := err.Error()
@@ -180,6 +212,10 @@ func reader(dtxConn *Connection) {
 			log.Errorf("error reading dtx connection %+v", err)
 			return
 		}
+		if dtxConn.wireLog.Enabled(ios.WireLogMessages) {
+			dtxConn.wireLog.Message("receive", msg.StringDebug())
+			dtxConn.wireLog.Frame("receive", frame.Bytes())
+		}
 		if _channel, ok := dtxConn.activeChannels.Load(msg.ChannelCode); ok {
 			channel := _channel.(*Channel)
 			channel.Dispatch(msg)
@@ -189,6 +225,23 @@ func reader(dtxConn *Connection) {
 	}
 }
 
+// injectFault applies the configured faults to a received frame. Corrupted frames are decoded again, so they
+// fail like frames that were corrupted on the wire.
+func injectFault(msg Message, frame []byte) (Message, bool, error) {
+	injected, err := ios.InjectFault(ios.FaultTransportDTX, frame)
+	if err != nil {
+		return Message{}, false, err
+	}
+	if injected == nil {
+		return Message{}, true, nil
+	}
+	if &injected[0] == &frame[0] {
+		return msg, false, nil
+	}
+	msg, err = ReadMessage(bytes.NewReader(injected))
+	return msg, false, err
+}
+
 func SendAckIfNeeded(dtxConn *Connection, msg Message) {
 	if msg.ExpectsReply {
 		ack := BuildAckMessage(msg)
@@ -207,7 +260,7 @@ func (dtxConn *Connection) ForChannelRequest(messageDispatcher Dispatcher) *Chan
 	identifier, _ := nskeyedarchiver.Unarchive(msg.Auxiliary.GetArguments()[1].([]byte))
 	// TODO: Setting the channel code here manually to -1 for making testmanagerd work. For some reason it requests the TestDriver proxy channel with code 1 but sends messages on -1. Should probably be fixed somehow
 	// TODO: try to refactor testmanagerd/xcuitest code and use AddDefaultChannelReceiver instead of this function. The only code calling this is in testmanagerd right now.
-	channel := &Channel{channelCode: -1, channelName: identifier[0].(string), messageIdentifier: 1, connection: dtxConn, messageDispatcher: messageDispatcher, responseWaiters: map[int]chan Message{}, defragmenters: map[int]*FragmentDecoder{}, timeout: 5 * time.Second}
+	channel := &Channel{channelCode: -1, channelName: identifier[0].(string), messageIdentifier: 1, connection: dtxConn, messageDispatcher: messageDispatcher, responseWaiters: map[int]chan Message{}, defragmenters: map[int]*FragmentDecoder{}, timeout: ios.PolicyFor(ios.OperationDTXRequest).Timeout}
 	dtxConn.activeChannels.Store(-1, channel)
 	return channel
 }
@@ -217,7 +270,7 @@ func (dtxConn *Connection) ForChannelRequest(messageDispatcher Dispatcher) *Chan
 // If someone wants to do that and bring some clarity, please go ahead :-)
 // This channel seems to always be there without explicitly requesting it and sometimes it is used.
 func (dtxConn *Connection) AddDefaultChannelReceiver(messageDispatcher Dispatcher) *Channel {
-	channel := &Channel{channelCode: -1, channelName: "c -1/ 4294967295 receiver channel ", messageIdentifier: 1, connection: dtxConn, messageDispatcher: messageDispatcher, responseWaiters: map[int]chan Message{}, defragmenters: map[int]*FragmentDecoder{}, timeout: 5 * time.Second}
+	channel := &Channel{channelCode: -1, channelName: "c -1/ 4294967295 receiver channel ", messageIdentifier: 1, connection: dtxConn, messageDispatcher: messageDispatcher, responseWaiters: map[int]chan Message{}, defragmenters: map[int]*FragmentDecoder{}, timeout: ios.PolicyFor(ios.OperationDTXRequest).Timeout}
 	dtxConn.activeChannels.Store(uint32(math.MaxUint32), channel)
 	return channel
 }
@@ -243,7 +296,7 @@ func (dtxConn *Connection) RequestChannelIdentifier(identifier string, messageDi
 		log.WithFields(log.Fields{"channel_id": identifier, "error": err}).Error("failed requesting channel")
 	}
 	log.WithFields(log.Fields{"channel_id": identifier}).Debug("Channel open")
-	channel := &Channel{channelCode: code, channelName: identifier, messageIdentifier: 1, connection: dtxConn, messageDispatcher: messageDispatcher, responseWaiters: map[int]chan Message{}, defragmenters: map[int]*FragmentDecoder{}, timeout: 5 * time.Second}
+	channel := &Channel{channelCode: code, channelName: identifier, messageIdentifier: 1, connection: dtxConn, messageDispatcher: messageDispatcher, responseWaiters: map[int]chan Message{}, defragmenters: map[int]*FragmentDecoder{}, timeout: ios.PolicyFor(ios.OperationDTXRequest).Timeout}
 	dtxConn.activeChannels.Store(code, channel)
 	for _, opt := range opts {
 		opt(channel)
@@ -0,0 +1,113 @@
+package dtx
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMethodCallReturnsWhenConnectionIsClosed(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+	go io.Copy(io.Discard, device)
+	conn, err := newDtxConnection(ios.NewDeviceConnectionWithRWC(client))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.GlobalChannel().MethodCall("_notifyOfPublishedCapabilities:")
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrConnectionClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("MethodCall did not return after the connection was closed")
+	}
+}
+
+func TestMethodCallWithoutTimeoutWaitsForTheResponse(t *testing.T) {
+	assert.NoError(t, ios.SetPolicy(ios.OperationDTXRequest, ios.Policy{Timeout: 0}))
+	defer ios.ResetPolicies()
+	client, device := net.Pipe()
+	defer device.Close()
+	go io.Copy(io.Discard, device)
+	conn, err := newDtxConnection(ios.NewDeviceConnectionWithRWC(client))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.GlobalChannel().MethodCall("_notifyOfPublishedCapabilities:")
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		t.Fatalf("MethodCall returned without a response: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	conn.Close()
+	assert.ErrorIs(t, <-errs, ErrConnectionClosed)
+}
+
+func TestInjectedFaultsFailTheConnection(t *testing.T) {
+	defer ios.SetFaults(ios.FaultConfig{ErrorRate: 1, Transports: []ios.FaultTransport{ios.FaultTransportDTX}})()
+	client, device := net.Pipe()
+	defer device.Close()
+	conn, err := newDtxConnection(ios.NewDeviceConnectionWithRWC(client))
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = conn.GlobalChannel().MethodCall("_notifyOfPublishedCapabilities:")
+	assert.ErrorIs(t, err, ios.ErrInjectedFault)
+
+	client, device = net.Pipe()
+	defer device.Close()
+	conn, err = newDtxConnection(ios.NewDeviceConnectionWithRWC(client))
+	if !assert.NoError(t, err) {
+		return
+	}
+	go device.Write(BuildAckMessage(Message{Identifier: 1, ConversationIndex: 0}))
+	select {
+	case <-conn.Closed():
+		assert.ErrorIs(t, conn.Err(), ios.ErrInjectedFault)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not closed after a received frame failed")
+	}
+}
+
+func TestInjectedFaultsDropFrames(t *testing.T) {
+	client, device := net.Pipe()
+	defer device.Close()
+	conn, err := newDtxConnection(ios.NewDeviceConnectionWithRWC(client))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	restore := ios.SetFaults(ios.FaultConfig{DropRate: 1})
+	defer restore()
+	sent := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		n, _ := device.Read(buf)
+		sent <- buf[:n]
+	}()
+	assert.NoError(t, conn.Send(BuildAckMessage(Message{Identifier: 1})))
+	_, stats := ios.Faults()
+	assert.Equal(t, uint64(1), stats.Dropped)
+
+	restore()
+	ack := BuildAckMessage(Message{Identifier: 2})
+	assert.NoError(t, conn.Send(ack))
+	assert.Equal(t, ack, <-sent, "only the frame after the faults were removed arrives")
+}
@@ -0,0 +1,242 @@
+package ios
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// faultsEnvVar configures fault injection at startup, see ParseFaultConfig for the format
+const faultsEnvVar = "GO_IOS_FAULTS"
+
+// FaultTransport is a transport that faults can be injected into
+type FaultTransport string
+
+const (
+	// FaultTransportUsbmuxd are the messages exchanged with usbmuxd
+	FaultTransportUsbmuxd = FaultTransport("usbmuxd")
+	// FaultTransportDTX are the DTX messages exchanged with instruments and testmanagerd services
+	FaultTransportDTX = FaultTransport("dtx")
+)
+
+// ErrInjectedFault is returned when the fault injector fails a connection
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultConfig configures the faults injected into the frames that are sent and received on the usbmuxd and DTX
+// transports. Rates are probabilities between 0 and 1 that are checked for every frame in this order: a frame
+// is delayed, then dropped, corrupted or the connection is failed with ErrInjectedFault.
+type FaultConfig struct {
+	DropRate    float64       `json:"dropRate,omitempty"`
+	DelayRate   float64       `json:"delayRate,omitempty"`
+	Delay       time.Duration `json:"delay,omitempty"`
+	CorruptRate float64       `json:"corruptRate,omitempty"`
+	ErrorRate   float64       `json:"errorRate,omitempty"`
+	// Seed makes the injected faults reproducible for the same sequence of frames
+	Seed int64 `json:"seed,omitempty"`
+	// Transports limits the faults to these transports, all transports get faults if it is empty
+	Transports []FaultTransport `json:"transports,omitempty"`
+}
+
+// Enabled returns true if any fault can be injected
+func (c FaultConfig) Enabled() bool {
+	return c.DropRate > 0 || c.DelayRate > 0 || c.CorruptRate > 0 || c.ErrorRate > 0
+}
+
+func (c FaultConfig) validate() error {
+	for name, rate := range map[string]float64{"drop": c.DropRate, "delay": c.DelayRate, "corrupt": c.CorruptRate, "error": c.ErrorRate} {
+		if !(rate >= 0 && rate <= 1) {
+			return fmt.Errorf("%s rate %f is not between 0 and 1", name, rate)
+		}
+	}
+	if c.Delay < 0 {
+		return errors.New("delay must not be negative")
+	}
+	for _, transport := range c.Transports {
+		if transport != FaultTransportUsbmuxd && transport != FaultTransportDTX {
+			return fmt.Errorf("unknown transport '%s', use usbmuxd or dtx", transport)
+		}
+	}
+	return nil
+}
+
+func (c FaultConfig) applies(transport FaultTransport) bool {
+	if len(c.Transports) == 0 {
+		return true
+	}
+	for _, t := range c.Transports {
+		if t == transport {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFaultConfig parses a comma separated list like "drop=0.01,delay=0.1:200ms,corrupt=0.001,error=0.001,seed=42,transport=dtx".
+// The delay is the rate followed by the duration, transport can be repeated.
+func ParseFaultConfig(config string) (FaultConfig, error) {
+	var result FaultConfig
+	for _, option := range strings.Split(config, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(option, "=")
+		if !ok {
+			return FaultConfig{}, fmt.Errorf("ParseFaultConfig: '%s' is not key=value", option)
+		}
+		var err error
+		switch key {
+		case "drop":
+			result.DropRate, err = strconv.ParseFloat(value, 64)
+		case "corrupt":
+			result.CorruptRate, err = strconv.ParseFloat(value, 64)
+		case "error":
+			result.ErrorRate, err = strconv.ParseFloat(value, 64)
+		case "delay":
+			rate, duration, ok := strings.Cut(value, ":")
+			if !ok {
+				return FaultConfig{}, fmt.Errorf("ParseFaultConfig: delay '%s' is not rate:duration", value)
+			}
+			result.DelayRate, err = strconv.ParseFloat(rate, 64)
+			if err == nil {
+				result.Delay, err = time.ParseDuration(duration)
+			}
+		case "seed":
+			result.Seed, err = strconv.ParseInt(value, 10, 64)
+		case "transport":
+			result.Transports = append(result.Transports, FaultTransport(value))
+		default:
+			return FaultConfig{}, fmt.Errorf("ParseFaultConfig: unknown option '%s'", key)
+		}
+		if err != nil {
+			return FaultConfig{}, fmt.Errorf("ParseFaultConfig: %s: %w", key, err)
+		}
+	}
+	err := result.validate()
+	if err != nil {
+		return FaultConfig{}, fmt.Errorf("ParseFaultConfig: %w", err)
+	}
+	return result, nil
+}
+
+// FaultStats counts the injected faults
+type FaultStats struct {
+	Frames    uint64 `json:"frames"`
+	Dropped   uint64 `json:"dropped"`
+	Delayed   uint64 `json:"delayed"`
+	Corrupted uint64 `json:"corrupted"`
+	Failed    uint64 `json:"failed"`
+}
+
+var faults = struct {
+	mu     sync.Mutex
+	config FaultConfig
+	rand   *rand.Rand
+	stats  FaultStats
+	// enabled keeps the check cheap when no faults are configured
+	enabled atomic.Bool
+}{}
+
+func init() {
+	value := os.Getenv(faultsEnvVar)
+	if value == "" {
+		return
+	}
+	config, err := ParseFaultConfig(value)
+	if err != nil {
+		log.WithError(err).Errorf("ignoring invalid %s", faultsEnvVar)
+		return
+	}
+	log.WithField("faults", value).Warn("injecting faults into device connections")
+	SetFaults(config)
+}
+
+// SetFaults replaces the fault configuration of the process and resets the stats. It applies to open connections
+// as well and returns a function that restores the previous configuration, so tests can defer it.
+// Fault injection is meant for testing how reconnects, timeouts and retries cope with bad connections,
+// never enable it in production.
+func SetFaults(config FaultConfig) func() {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+	previous := faults.config
+	faults.config = config
+	faults.rand = rand.New(rand.NewSource(config.Seed))
+	faults.stats = FaultStats{}
+	faults.enabled.Store(config.Enabled())
+	return func() { SetFaults(previous) }
+}
+
+// Faults returns the fault configuration and how many faults were injected since it was set
+func Faults() (FaultConfig, FaultStats) {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+	return faults.config, faults.stats
+}
+
+// FaultsEnabled returns true if faults are injected into any transport
+func FaultsEnabled() bool {
+	return faults.enabled.Load()
+}
+
+// InjectFault applies the configured faults to a frame of the transport before it is sent or after it was
+// received. It returns the frame unchanged, a corrupted copy, nil if the frame is dropped or ErrInjectedFault
+// if the connection should fail. Delays block the caller.
+func InjectFault(transport FaultTransport, frame []byte) ([]byte, error) {
+	if !faults.enabled.Load() {
+		return frame, nil
+	}
+	faults.mu.Lock()
+	config := faults.config
+	if !config.applies(transport) {
+		faults.mu.Unlock()
+		return frame, nil
+	}
+	faults.stats.Frames++
+	delay := faults.rand.Float64() < config.DelayRate
+	drop := faults.rand.Float64() < config.DropRate
+	corrupt := faults.rand.Float64() < config.CorruptRate
+	fail := faults.rand.Float64() < config.ErrorRate
+	position := 0
+	if len(frame) > 0 {
+		position = faults.rand.Intn(len(frame))
+	}
+	if delay {
+		faults.stats.Delayed++
+	}
+	switch {
+	case drop:
+		faults.stats.Dropped++
+	case corrupt && len(frame) > 0:
+		faults.stats.Corrupted++
+	case fail:
+		faults.stats.Failed++
+	}
+	faults.mu.Unlock()
+
+	if delay {
+		time.Sleep(config.Delay)
+	}
+	switch {
+	case drop:
+		log.WithField("transport", transport).Debug("fault injection dropped a frame")
+		return nil, nil
+	case corrupt && len(frame) > 0:
+		log.WithField("transport", transport).Debug("fault injection corrupted a frame")
+		corrupted := make([]byte, len(frame))
+		copy(corrupted, frame)
+		corrupted[position] ^= 0xff
+		return corrupted, nil
+	case fail:
+		log.WithField("transport", transport).Debug("fault injection failed a connection")
+		return nil, fmt.Errorf("%s: %w", transport, ErrInjectedFault)
+	}
+	return frame, nil
+}
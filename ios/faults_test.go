@@ -0,0 +1,81 @@
+package ios
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFaultConfig(t *testing.T) {
+	config, err := ParseFaultConfig("drop=0.01, delay=0.1:200ms,corrupt=0.001,error=0.5,seed=42,transport=dtx,transport=usbmuxd")
+	require.NoError(t, err)
+	assert.Equal(t, FaultConfig{
+		DropRate:    0.01,
+		DelayRate:   0.1,
+		Delay:       200 * time.Millisecond,
+		CorruptRate: 0.001,
+		ErrorRate:   0.5,
+		Seed:        42,
+		Transports:  []FaultTransport{FaultTransportDTX, FaultTransportUsbmuxd},
+	}, config)
+
+	for _, invalid := range []string{"drop=2", "delay=0.1", "delay=0.1:soon", "seed=x", "transport=tcp", "explode=1", "drop"} {
+		_, err := ParseFaultConfig(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestInjectFaultIsReproducible(t *testing.T) {
+	frame := []byte("frame")
+	unchanged, err := InjectFault(FaultTransportDTX, frame)
+	require.NoError(t, err)
+	assert.Equal(t, frame, unchanged, "no faults are injected by default")
+
+	inject := func() []string {
+		defer SetFaults(FaultConfig{DropRate: 0.3, CorruptRate: 0.3, ErrorRate: 0.3, Seed: 7})()
+		var results []string
+		for i := 0; i < 50; i++ {
+			result, err := InjectFault(FaultTransportUsbmuxd, frame)
+			switch {
+			case err != nil:
+				assert.ErrorIs(t, err, ErrInjectedFault)
+				results = append(results, "error")
+			case result == nil:
+				results = append(results, "drop")
+			case !bytes.Equal(result, frame):
+				results = append(results, "corrupt")
+			default:
+				results = append(results, "ok")
+			}
+		}
+		_, stats := Faults()
+		assert.Equal(t, uint64(50), stats.Frames)
+		return results
+	}
+	first := inject()
+	assert.Equal(t, first, inject(), "the same seed injects the same faults")
+	assert.Contains(t, first, "drop")
+	assert.Contains(t, first, "corrupt")
+	assert.Contains(t, first, "error")
+	assert.Contains(t, first, "ok")
+	assert.Equal(t, []byte("frame"), frame, "corrupting copies the frame")
+	assert.False(t, FaultsEnabled())
+}
+
+func TestInjectFaultOnlyAffectsConfiguredTransports(t *testing.T) {
+	defer SetFaults(FaultConfig{DelayRate: 1, Delay: 20 * time.Millisecond, DropRate: 1, Transports: []FaultTransport{FaultTransportDTX}})()
+	start := time.Now()
+	frame, err := InjectFault(FaultTransportDTX, []byte("frame"))
+	assert.NoError(t, err)
+	assert.Nil(t, frame)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	frame, err = InjectFault(FaultTransportUsbmuxd, []byte("frame"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("frame"), frame)
+	_, stats := Faults()
+	assert.Equal(t, FaultStats{Frames: 1, Dropped: 1, Delayed: 1}, stats)
+}
@@ -0,0 +1,271 @@
+// Package healthcheck actively exercises the connections go-ios needs to use a device, like pairing, lockdown,
+// the developer image, instruments and afc, and reports which of them work and how long they took.
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/afc"
+	"github.com/danielpaulus/go-ios/ios/imagemounter"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+)
+
+// DefaultTimeout is how long a single check may take
+const DefaultTimeout = 20 * time.Second
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	Pass = Status("pass")
+	Fail = Status("fail")
+	// Skip means the check did not run, because a check it needs failed or it does not apply to the device
+	Skip = Status("skip")
+)
+
+// Check exercises one part of the device
+type Check struct {
+	Name string
+	// Needs are the names of checks that have to pass before this check can run
+	Needs []string
+	// Run returns details like versions or sizes, or ErrNotApplicable to skip the check
+	Run func(device ios.DeviceEntry) (string, error)
+}
+
+// ErrNotApplicable is returned by checks that don't apply to a device, f.ex. the tunnel check on iOS 16
+type ErrNotApplicable string
+
+func (e ErrNotApplicable) Error() string {
+	return string(e)
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Check      string `json:"check"`
+	Status     Status `json:"status"`
+	DurationMs int64  `json:"durationMs"`
+	Details    string `json:"details,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Report is the outcome of all checks of a device
+type Report struct {
+	UDID string `json:"udid"`
+	// Healthy is true if no check failed
+	Healthy    bool      `json:"healthy"`
+	Results    []Result  `json:"results"`
+	DurationMs int64     `json:"durationMs"`
+	CheckedAt  time.Time `json:"checkedAt"`
+}
+
+// Run runs the checks in order. A check is skipped if a check it needs did not pass. Checks that take longer
+// than timeout fail, they keep running in the background until the device connection times out.
+func Run(ctx context.Context, device ios.DeviceEntry, checks []Check, timeout time.Duration) Report {
+	start := time.Now()
+	report := Report{UDID: device.Properties.SerialNumber, Healthy: true, Results: make([]Result, 0, len(checks)), CheckedAt: start}
+	passed := map[string]bool{}
+	for _, check := range checks {
+		result := run(ctx, device, check, passed, timeout)
+		passed[check.Name] = result.Status == Pass
+		report.Healthy = report.Healthy && result.Status != Fail
+		report.Results = append(report.Results, result)
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+	return report
+}
+
+func run(ctx context.Context, device ios.DeviceEntry, check Check, passed map[string]bool, timeout time.Duration) Result {
+	result := Result{Check: check.Name}
+	for _, need := range check.Needs {
+		if !passed[need] {
+			result.Status = Skip
+			result.Details = fmt.Sprintf("needs %s", need)
+			return result
+		}
+	}
+	if ctx.Err() != nil {
+		result.Status = Skip
+		result.Details = ctx.Err().Error()
+		return result
+	}
+	type outcome struct {
+		details string
+		err     error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		details, err := check.Run(device)
+		done <- outcome{details: details, err: err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		result.DurationMs = time.Since(start).Milliseconds()
+		result.Details = o.details
+		switch err := o.err.(type) {
+		case nil:
+			result.Status = Pass
+		case ErrNotApplicable:
+			result.Status = Skip
+			result.Details = err.Error()
+		default:
+			result.Status = Fail
+			result.Error = err.Error()
+		}
+	case <-timer.C:
+		result.DurationMs = time.Since(start).Milliseconds()
+		result.Status = Fail
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	case <-ctx.Done():
+		result.DurationMs = time.Since(start).Milliseconds()
+		result.Status = Fail
+		result.Error = ctx.Err().Error()
+	}
+	return result
+}
+
+// DefaultChecks are pairing, lockdown, tunnel, ddi, instruments, afc and screenshot
+func DefaultChecks() []Check {
+	return []Check{
+		{Name: "pairing", Run: checkPairing},
+		{Name: "lockdown", Needs: []string{"pairing"}, Run: checkLockdown},
+		{Name: "tunnel", Needs: []string{"lockdown"}, Run: checkTunnel},
+		{Name: "ddi", Needs: []string{"lockdown"}, Run: checkDeveloperImage},
+		{Name: "instruments", Needs: []string{"ddi"}, Run: checkInstruments},
+		{Name: "afc", Needs: []string{"lockdown"}, Run: checkAFC},
+		{Name: "screenshot", Needs: []string{"ddi"}, Run: checkScreenshot},
+	}
+}
+
+// QuickChecks are the checks that need no developer services: the pair record is accepted by the device, lockdown
+// answers, a developer image is mounted, and the file system of dir on the host has at least minFree bytes free
+func QuickChecks(dir string, minFree uint64) []Check {
+	return []Check{
+		{Name: "pairing", Run: checkPairingValid},
+		{Name: "lockdown", Needs: []string{"pairing"}, Run: checkLockdown},
+		{Name: "ddi", Needs: []string{"lockdown"}, Run: checkDeveloperImage},
+		HostDisk(dir, minFree),
+	}
+}
+
+// HostDisk is a check that fails if the file system of dir on the host has less than minFree bytes free
+func HostDisk(dir string, minFree uint64) Check {
+	return Check{Name: "host-disk", Run: func(ios.DeviceEntry) (string, error) {
+		free, err := freeBytes(dir)
+		if err != nil {
+			return "", err
+		}
+		details := fmt.Sprintf("%s free in %s", ios.ByteCountDecimal(int64(free)), dir)
+		if free < minFree {
+			return details, fmt.Errorf("only %s free in %s, need %s", ios.ByteCountDecimal(int64(free)), dir, ios.ByteCountDecimal(int64(minFree)))
+		}
+		return details, nil
+	}}
+}
+
+func checkPairingValid(device ios.DeviceEntry) (string, error) {
+	expires, err := ios.ValidatePairing(device)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pair record accepted, expires %s", expires.Format(time.RFC3339)), nil
+}
+
+func checkPairing(device ios.DeviceEntry) (string, error) {
+	record, err := ios.ReadPairRecord(device.Properties.SerialNumber)
+	if err != nil {
+		return "", err
+	}
+	expires, err := ios.PairRecordExpiry(record)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pair record expires %s", expires.Format(time.RFC3339)), nil
+}
+
+func checkLockdown(device ios.DeviceEntry) (string, error) {
+	lockdown, err := ios.ConnectLockdownWithSession(device)
+	if err != nil {
+		return "", err
+	}
+	defer lockdown.Close()
+	version, err := lockdown.GetValue("ProductVersion")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("iOS %v", version), nil
+}
+
+func checkTunnel(device ios.DeviceEntry) (string, error) {
+	version, err := ios.GetProductVersion(device)
+	if err != nil {
+		return "", err
+	}
+	if version.Major() < 17 {
+		return "", ErrNotApplicable(fmt.Sprintf("iOS %s does not need a tunnel", version))
+	}
+	if !device.SupportsRsd() {
+		return "", fmt.Errorf("iOS %s needs a tunnel, start one with 'ios tunnel start'", version)
+	}
+	return fmt.Sprintf("%d rsd services", len(device.Rsd.GetServices())), nil
+}
+
+func checkDeveloperImage(device ios.DeviceEntry) (string, error) {
+	mounter, err := imagemounter.NewImageMounter(device)
+	if err != nil {
+		return "", err
+	}
+	defer mounter.Close()
+	images, err := mounter.ListImages()
+	if err != nil {
+		return "", err
+	}
+	if len(images) == 0 {
+		return "", fmt.Errorf("no developer image is mounted")
+	}
+	return fmt.Sprintf("%d images mounted", len(images)), nil
+}
+
+func checkInstruments(device ios.DeviceEntry) (string, error) {
+	service, err := instruments.NewDeviceInfoService(device)
+	if err != nil {
+		return "", err
+	}
+	defer service.Close()
+	processes, err := service.ProcessList()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d processes running", len(processes)), nil
+}
+
+func checkAFC(device ios.DeviceEntry) (string, error) {
+	conn, err := afc.New(device)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	info, err := conn.GetSpaceInfo()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s free", ios.ByteCountDecimal(int64(info.FreeBytes))), nil
+}
+
+func checkScreenshot(device ios.DeviceEntry) (string, error) {
+	service, err := instruments.NewScreenshotService(device)
+	if err != nil {
+		return "", err
+	}
+	defer service.Close()
+	png, err := service.TakeScreenshot()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s png", ios.ByteCountDecimal(int64(len(png)))), nil
+}
@@ -0,0 +1,58 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	pass := func(details string) func(ios.DeviceEntry) (string, error) {
+		return func(ios.DeviceEntry) (string, error) { return details, nil }
+	}
+	checks := []Check{
+		{Name: "pairing", Run: pass("ok")},
+		{Name: "tunnel", Needs: []string{"pairing"}, Run: func(ios.DeviceEntry) (string, error) {
+			return "", ErrNotApplicable("iOS 16 does not need a tunnel")
+		}},
+		{Name: "ddi", Needs: []string{"pairing"}, Run: func(ios.DeviceEntry) (string, error) {
+			return "", errors.New("no developer image is mounted")
+		}},
+		{Name: "instruments", Needs: []string{"ddi"}, Run: pass("unreachable")},
+		{Name: "afc", Needs: []string{"pairing"}, Run: func(ios.DeviceEntry) (string, error) {
+			time.Sleep(time.Second)
+			return "", nil
+		}},
+	}
+	device := ios.DeviceEntry{Properties: ios.DeviceProperties{SerialNumber: "health-a"}}
+
+	report := Run(context.Background(), device, checks, 50*time.Millisecond)
+	assert.Equal(t, "health-a", report.UDID)
+	assert.False(t, report.Healthy)
+	require.Len(t, report.Results, 5)
+	assert.Equal(t, Result{Check: "pairing", Status: Pass, Details: "ok", DurationMs: report.Results[0].DurationMs}, report.Results[0])
+	assert.Equal(t, Skip, report.Results[1].Status)
+	assert.Equal(t, "iOS 16 does not need a tunnel", report.Results[1].Details)
+	assert.Equal(t, Fail, report.Results[2].Status)
+	assert.Equal(t, "no developer image is mounted", report.Results[2].Error)
+	assert.Equal(t, Result{Check: "instruments", Status: Skip, Details: "needs ddi"}, report.Results[3])
+	assert.Equal(t, Fail, report.Results[4].Status)
+	assert.Contains(t, report.Results[4].Error, "timed out")
+
+	report = Run(context.Background(), device, checks[:2], time.Second)
+	assert.True(t, report.Healthy, "skipped checks don't make a device unhealthy")
+}
+
+func TestHostDisk(t *testing.T) {
+	details, err := HostDisk(t.TempDir(), 1).Run(ios.DeviceEntry{})
+	require.NoError(t, err)
+	assert.Contains(t, details, "free in")
+
+	_, err = HostDisk(t.TempDir(), 1<<62).Run(ios.DeviceEntry{})
+	assert.ErrorContains(t, err, "only")
+}
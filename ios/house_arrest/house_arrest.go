@@ -33,6 +33,21 @@ func New(device ios.DeviceEntry, bundleID string) (*Connection, error) {
 	return &Connection{deviceConn: deviceConn}, nil
 }
 
+// NewAFC vends the container of the app with bundleID and returns an AFC connection to it. Paths are relative to
+// the container, f.ex. /Documents. Only apps that are signed for development can be accessed.
+func NewAFC(device ios.DeviceEntry, bundleID string) (*afc.Connection, error) {
+	deviceConn, err := ios.ConnectToService(device, serviceName)
+	if err != nil {
+		return nil, fmt.Errorf("NewAFC: %w", err)
+	}
+	err = vendContainer(deviceConn, bundleID)
+	if err != nil {
+		deviceConn.Close()
+		return nil, fmt.Errorf("NewAFC: failed vending container of %s: %w", bundleID, err)
+	}
+	return afc.NewFromConn(deviceConn), nil
+}
+
 func vendContainer(deviceConn ios.DeviceConnectionInterface, bundleID string) error {
 	plistCodec := ios.NewPlistCodec()
 	vendContainer := map[string]interface{}{"Command": "VendContainer", "Identifier": bundleID}
@@ -0,0 +1,233 @@
+// Package imagediff compares screenshots with a baseline for visual regression checks. It has a pixel algorithm
+// that counts pixels whose color differs perceptibly and an ssim algorithm that compares the structure of blocks
+// of pixels, which tolerates anti-aliasing and compression noise better.
+package imagediff
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Algorithm selects how images are compared
+type Algorithm string
+
+const (
+	// Pixel compares every pixel by its perceptual color difference in the YIQ color space
+	Pixel = Algorithm("pixel")
+	// SSIM compares the structural similarity of 8x8 blocks of the luminance
+	SSIM = Algorithm("ssim")
+)
+
+// ssimBlockSize is the size of the blocks the ssim algorithm compares
+const ssimBlockSize = 8
+
+// maxYIQDelta is the YIQ difference of black and white
+const maxYIQDelta = 35215.0
+
+// ErrSizeMismatch is returned if the images don't have the same size
+var ErrSizeMismatch = errors.New("images have different sizes")
+
+// Options configure a comparison
+type Options struct {
+	Algorithm Algorithm
+	// Threshold is between 0 and 1. Pixels whose color difference or blocks whose dissimilarity is at most the
+	// threshold count as equal, 0 is the strictest.
+	Threshold float64
+	// Ignore are regions that are not compared, f.ex. the clock in the status bar
+	Ignore []image.Rectangle
+}
+
+// DefaultOptions uses the pixel algorithm with a threshold that ignores jpeg noise
+func DefaultOptions() Options {
+	return Options{Algorithm: Pixel, Threshold: 0.1}
+}
+
+// Validate checks the algorithm and the threshold
+func (o Options) Validate() error {
+	if o.Algorithm != Pixel && o.Algorithm != SSIM {
+		return fmt.Errorf("invalid algorithm '%s', use pixel or ssim", o.Algorithm)
+	}
+	if o.Threshold < 0 || o.Threshold > 1 {
+		return fmt.Errorf("threshold must be between 0 and 1")
+	}
+	return nil
+}
+
+// Result is the outcome of a comparison
+type Result struct {
+	Algorithm Algorithm `json:"algorithm"`
+	// Score is 0 for equal images and 1 for completely different images
+	Score float64 `json:"score"`
+	// DiffPixels is the number of pixels that differ
+	DiffPixels int `json:"diffPixels"`
+	// ComparedPixels is the number of pixels outside of ignored regions
+	ComparedPixels int `json:"comparedPixels"`
+	// Diff is the baseline faded to gray with differences in red and ignored regions in blue
+	Diff *image.RGBA `json:"-"`
+}
+
+// Compare compares actual with baseline
+func Compare(baseline image.Image, actual image.Image, options Options) (Result, error) {
+	if err := options.Validate(); err != nil {
+		return Result{}, err
+	}
+	if baseline.Bounds().Dx() != actual.Bounds().Dx() || baseline.Bounds().Dy() != actual.Bounds().Dy() {
+		return Result{}, fmt.Errorf("Compare: %w, baseline is %dx%d and the screenshot %dx%d", ErrSizeMismatch,
+			baseline.Bounds().Dx(), baseline.Bounds().Dy(), actual.Bounds().Dx(), actual.Bounds().Dy())
+	}
+	a := toRGBA(baseline)
+	b := toRGBA(actual)
+	result := Result{Algorithm: options.Algorithm, Diff: fade(a)}
+	ignored := func(x, y int) bool {
+		p := image.Pt(x, y)
+		for _, r := range options.Ignore {
+			if p.In(r) {
+				return true
+			}
+		}
+		return false
+	}
+	switch options.Algorithm {
+	case Pixel:
+		comparePixels(a, b, options.Threshold, ignored, &result)
+	case SSIM:
+		compareSSIM(a, b, options.Threshold, ignored, &result)
+	}
+	for _, r := range options.Ignore {
+		r = r.Intersect(a.Rect)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				result.Diff.Set(x, y, color.RGBA{R: 80, G: 80, B: 255, A: 255})
+			}
+		}
+	}
+	return result, nil
+}
+
+func comparePixels(a *image.RGBA, b *image.RGBA, threshold float64, ignored func(x, y int) bool, result *Result) {
+	maxDelta := maxYIQDelta * threshold * threshold
+	for y := 0; y < a.Rect.Dy(); y++ {
+		for x := 0; x < a.Rect.Dx(); x++ {
+			if ignored(x, y) {
+				continue
+			}
+			result.ComparedPixels++
+			if yiqDelta(a, b, a.PixOffset(x, y)) > maxDelta {
+				result.DiffPixels++
+				result.Diff.Set(x, y, color.RGBA{R: 255, A: 255})
+			}
+		}
+	}
+	if result.ComparedPixels > 0 {
+		result.Score = float64(result.DiffPixels) / float64(result.ComparedPixels)
+	}
+}
+
+// yiqDelta is the squared perceptual color difference of the pixels at offset i, see
+// "Measuring perceived color difference using YIQ NTSC transmission color space in mobile applications"
+func yiqDelta(a *image.RGBA, b *image.RGBA, i int) float64 {
+	r1, g1, b1 := blendWhite(a.Pix[i:i+4])
+	r2, g2, b2 := blendWhite(b.Pix[i:i+4])
+	y := rgbToY(r1, g1, b1) - rgbToY(r2, g2, b2)
+	in := 0.59597799*(r1-r2) - 0.27417610*(g1-g2) - 0.32180189*(b1-b2)
+	q := 0.21147017*(r1-r2) - 0.52261711*(g1-g2) + 0.31114694*(b1-b2)
+	return 0.5053*y*y + 0.299*in*in + 0.1957*q*q
+}
+
+func blendWhite(p []uint8) (float64, float64, float64) {
+	alpha := float64(p[3]) / 255
+	blend := func(c uint8) float64 { return 255 + (float64(c)-255)*alpha }
+	return blend(p[0]), blend(p[1]), blend(p[2])
+}
+
+func rgbToY(r, g, b float64) float64 {
+	return 0.29889531*r + 0.58662247*g + 0.11448223*b
+}
+
+// compareSSIM scores with 1 - the mean ssim of all blocks that are not completely ignored. Pixels of blocks with
+// a dissimilarity above the threshold are diff pixels.
+func compareSSIM(a *image.RGBA, b *image.RGBA, threshold float64, ignored func(x, y int) bool, result *Result) {
+	const c1 = (0.01 * 255) * (0.01 * 255)
+	const c2 = (0.03 * 255) * (0.03 * 255)
+	width, height := a.Rect.Dx(), a.Rect.Dy()
+	var total float64
+	var blocks int
+	for by := 0; by < height; by += ssimBlockSize {
+		for bx := 0; bx < width; bx += ssimBlockSize {
+			var la, lb []float64
+			var pixels []image.Point
+			for y := by; y < by+ssimBlockSize && y < height; y++ {
+				for x := bx; x < bx+ssimBlockSize && x < width; x++ {
+					if ignored(x, y) {
+						continue
+					}
+					i := a.PixOffset(x, y)
+					r1, g1, b1 := blendWhite(a.Pix[i : i+4])
+					r2, g2, b2 := blendWhite(b.Pix[i : i+4])
+					la = append(la, rgbToY(r1, g1, b1))
+					lb = append(lb, rgbToY(r2, g2, b2))
+					pixels = append(pixels, image.Pt(x, y))
+				}
+			}
+			if len(pixels) == 0 {
+				continue
+			}
+			meanA, meanB := mean(la), mean(lb)
+			var varA, varB, cov float64
+			for i := range la {
+				varA += (la[i] - meanA) * (la[i] - meanA)
+				varB += (lb[i] - meanB) * (lb[i] - meanB)
+				cov += (la[i] - meanA) * (lb[i] - meanB)
+			}
+			n := float64(len(la))
+			varA, varB, cov = varA/n, varB/n, cov/n
+			ssim := ((2*meanA*meanB + c1) * (2*cov + c2)) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			total += ssim
+			blocks++
+			result.ComparedPixels += len(pixels)
+			if 1-ssim > threshold {
+				result.DiffPixels += len(pixels)
+				for _, p := range pixels {
+					result.Diff.Set(p.X, p.Y, color.RGBA{R: 255, A: 255})
+				}
+			}
+		}
+	}
+	if blocks > 0 {
+		result.Score = math.Max(0, math.Min(1, 1-total/float64(blocks)))
+	}
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// toRGBA copies img into an RGBA image with bounds starting at 0,0
+func toRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	result := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			result.Set(x, y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return result
+}
+
+// fade returns a light gray copy of img, so the red differences stand out
+func fade(img *image.RGBA) *image.RGBA {
+	result := image.NewRGBA(img.Rect)
+	for i := 0; i < len(img.Pix); i += 4 {
+		r, g, b := blendWhite(img.Pix[i : i+4])
+		gray := uint8(255 - (255-rgbToY(r, g, b))*0.3)
+		result.Pix[i], result.Pix[i+1], result.Pix[i+2], result.Pix[i+3] = gray, gray, gray, 255
+	}
+	return result
+}
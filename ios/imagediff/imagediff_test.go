@@ -0,0 +1,72 @@
+package imagediff
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func filled(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestComparePixel(t *testing.T) {
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	baseline := filled(16, 16, white)
+	actual := filled(16, 16, white)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			actual.Set(x, y, color.Black)
+		}
+	}
+	actual.Set(10, 10, color.RGBA{R: 250, G: 250, B: 250, A: 255})
+
+	result, err := Compare(baseline, actual, DefaultOptions())
+	require.NoError(t, err)
+	assert.Equal(t, 16, result.DiffPixels, "the slightly different pixel is below the threshold")
+	assert.Equal(t, 256, result.ComparedPixels)
+	assert.InDelta(t, 16.0/256, result.Score, 0.0001)
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, result.Diff.At(0, 0))
+
+	options := DefaultOptions()
+	options.Ignore = []image.Rectangle{image.Rect(0, 0, 4, 4)}
+	result, err = Compare(baseline, actual, options)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.DiffPixels)
+	assert.Equal(t, 240, result.ComparedPixels)
+	assert.Equal(t, 0.0, result.Score)
+}
+
+func TestCompareSSIM(t *testing.T) {
+	baseline := filled(16, 16, color.White)
+	result, err := Compare(baseline, filled(16, 16, color.White), Options{Algorithm: SSIM, Threshold: 0.05})
+	require.NoError(t, err)
+	assert.InDelta(t, 0, result.Score, 0.0001)
+
+	actual := filled(16, 16, color.White)
+	for x := 0; x < 8; x++ {
+		actual.Set(x, x, color.Black)
+	}
+	result, err = Compare(baseline, actual, Options{Algorithm: SSIM, Threshold: 0.05})
+	require.NoError(t, err)
+	assert.Equal(t, 64, result.DiffPixels, "only the block with the line differs")
+	assert.Greater(t, result.Score, 0.0)
+}
+
+func TestCompareErrors(t *testing.T) {
+	_, err := Compare(filled(2, 2, color.White), filled(2, 3, color.White), DefaultOptions())
+	assert.ErrorIs(t, err, ErrSizeMismatch)
+	_, err = Compare(filled(2, 2, color.White), filled(2, 2, color.White), Options{Algorithm: "fuzzy"})
+	assert.Error(t, err)
+	_, err = Compare(filled(2, 2, color.White), filled(2, 2, color.White), Options{Algorithm: Pixel, Threshold: 2})
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+package imagemounter
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/danielpaulus/go-ios/ios"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultImageDir is where EnsureMounted caches images unless SetImageDir configured another directory
+const DefaultImageDir = "./devimages"
+
+var (
+	imageDir   = DefaultImageDir
+	imageDirMu sync.Mutex
+	// downloadMu serializes downloads, devices with the same iOS version must not download the same image twice
+	downloadMu sync.Mutex
+)
+
+// ErrDeveloperModeDisabled is returned by EnsureMounted for iOS 16+ devices without developer mode,
+// they don't accept developer disk images
+var ErrDeveloperModeDisabled = errors.New("developer mode is disabled, enable it in Settings > Privacy & Security or with 'ios devmode enable'")
+
+// SetImageDir configures the directory EnsureMounted caches images in
+func SetImageDir(dir string) {
+	imageDirMu.Lock()
+	defer imageDirMu.Unlock()
+	if dir == "" {
+		dir = DefaultImageDir
+	}
+	imageDir = dir
+}
+
+func currentImageDir() string {
+	imageDirMu.Lock()
+	defer imageDirMu.Unlock()
+	return imageDir
+}
+
+// EnsureResult describes what EnsureMounted did
+type EnsureResult struct {
+	// AlreadyMounted is true if an image was mounted before, nothing was downloaded or mounted then
+	AlreadyMounted bool `json:"alreadyMounted"`
+	// ProductVersion is the iOS version of the device
+	ProductVersion string `json:"productVersion"`
+	// Path is the image that was mounted
+	Path string `json:"path,omitempty"`
+	// Personalized is true for the personalized images of iOS 17+ devices
+	Personalized bool `json:"personalized"`
+}
+
+// EnsureMounted mounts the developer disk image instruments, testmanagerd and debugserver need, unless
+// an image is mounted already. The image matching the iOS version of the device, or the personalized image
+// for iOS 17+, is downloaded to the image directory first, if it is not cached there. Configure the directory
+// with SetImageDir and a mirror to download from with SetMirror.
+func EnsureMounted(device ios.DeviceEntry) (EnsureResult, error) {
+	return EnsureMountedIn(device, currentImageDir())
+}
+
+// EnsureMountedIn is EnsureMounted with images cached in baseDir
+func EnsureMountedIn(device ios.DeviceEntry, baseDir string) (EnsureResult, error) {
+	version, err := ios.GetProductVersion(device)
+	if err != nil {
+		return EnsureResult{}, fmt.Errorf("EnsureMounted: failed getting the iOS version: %w", err)
+	}
+	result := EnsureResult{ProductVersion: version.String(), Personalized: version.Major() >= 17}
+	conn, err := NewImageMounter(device)
+	if err != nil {
+		return result, fmt.Errorf("EnsureMounted: failed connecting to image mounter: %w", err)
+	}
+	defer conn.Close()
+	signatures, err := conn.ListImages()
+	if err != nil {
+		return result, fmt.Errorf("EnsureMounted: failed listing mounted images: %w", err)
+	}
+	if len(signatures) > 0 {
+		result.AlreadyMounted = true
+		return result, nil
+	}
+	if version.Major() >= 16 {
+		enabled, err := IsDevModeEnabled(device)
+		if err != nil {
+			return result, fmt.Errorf("EnsureMounted: %w", err)
+		}
+		if !enabled {
+			return result, fmt.Errorf("EnsureMounted: %w", ErrDeveloperModeDisabled)
+		}
+	}
+
+	downloadMu.Lock()
+	result.Path, err = DownloadImage(baseDir, version.String(), "")
+	downloadMu.Unlock()
+	if err != nil {
+		return result, fmt.Errorf("EnsureMounted: failed downloading image: %w", err)
+	}
+	log.WithFields(log.Fields{"udid": device.Properties.SerialNumber, "image": result.Path}).Info("mounting developer disk image")
+	err = conn.MountImage(result.Path)
+	if err != nil {
+		return result, fmt.Errorf("EnsureMounted: failed mounting %s: %w", result.Path, err)
+	}
+	return result, nil
+}
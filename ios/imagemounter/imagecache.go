@@ -0,0 +1,166 @@
+package imagemounter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// mirror replaces the default download locations of developer disk images if set
+var mirror string
+
+// SetMirror configures a base url developer disk images are downloaded from instead of the default locations.
+// The mirror needs to use the same layout as the local image cache: <mirror>/<version>/DeveloperDiskImage.dmg(.signature)
+// for images before iOS 17 and <mirror>/ddi-15F31d.zip for personalized images. Downloads are verified with the
+// <file>.sha256 checksums the mirror serves next to the files, if it does. Use an empty string to reset it.
+func SetMirror(baseURL string) {
+	mirror = strings.TrimSuffix(baseURL, "/")
+}
+
+func imageDownloadURL(version string, file string) string {
+	if mirror != "" {
+		return fmt.Sprintf("%s/%s/%s", mirror, strings.Split(version, " (")[0], file)
+	}
+	return versionMap[version] + "/" + file + "?raw=true"
+}
+
+func personalizedImageDownloadURL() string {
+	if mirror != "" {
+		return fmt.Sprintf("%s/%s.zip", mirror, xcode15_4_ddi)
+	}
+	return fmt.Sprintf("%s%s%s", devicebox, xcode15_4_ddi, ".zip")
+}
+
+// CachedImage is a developer disk image that was downloaded to the host
+type CachedImage struct {
+	Version      string `json:"version"`
+	Path         string `json:"path"`
+	Personalized bool   `json:"personalized"`
+	Size         int64  `json:"size"`
+}
+
+// ListCachedImages returns all developer disk images stored in baseDir. Images for iOS 17+ are
+// listed with the path of their Restore directory, so they can be passed to MountImage directly.
+func ListCachedImages(baseDir string) ([]CachedImage, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []CachedImage{}, nil
+		}
+		return nil, fmt.Errorf("ListCachedImages: failed reading %s: %w", baseDir, err)
+	}
+	result := []CachedImage{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(baseDir, entry.Name())
+		if info, err := os.Stat(filepath.Join(dir, imageFile)); err == nil {
+			result = append(result, CachedImage{Version: entry.Name(), Path: filepath.Join(dir, imageFile), Size: info.Size()})
+			continue
+		}
+		restore := filepath.Join(dir, "Restore")
+		if info, err := os.Stat(restore); err == nil && info.IsDir() {
+			size, _ := dirSize(restore)
+			result = append(result, CachedImage{Version: entry.Name(), Path: restore, Personalized: true, Size: size})
+		}
+	}
+	return result, nil
+}
+
+// RemoveCachedImage deletes the image for the given version as returned by ListCachedImages from baseDir
+func RemoveCachedImage(baseDir string, version string) error {
+	if version == "" || strings.ContainsAny(version, `/\`) || version == "." || version == ".." {
+		return fmt.Errorf("RemoveCachedImage: invalid version '%s'", version)
+	}
+	dir := filepath.Join(baseDir, version)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("RemoveCachedImage: image %s not found: %w", version, err)
+	}
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return fmt.Errorf("RemoveCachedImage: %w", err)
+	}
+	// personalized images leave their downloaded zip file next to the extracted directory
+	os.Remove(dir + ".zip")
+	return nil
+}
+
+// VerifyChecksum compares the sha256 checksum of the file at path with the expected hex encoded checksum
+func VerifyChecksum(path string, expectedSHA256 string) error {
+	actual, err := fileSHA256(path)
+	if err != nil {
+		return fmt.Errorf("VerifyChecksum: %w", err)
+	}
+	if !strings.EqualFold(actual, expectedSHA256) {
+		return fmt.Errorf("VerifyChecksum: checksum mismatch for %s, expected %s but got %s", path, expectedSHA256, actual)
+	}
+	return nil
+}
+
+// verifyDownload checks the checksum of a file downloaded from url. Without expectedSHA256, files from a mirror
+// are checked against the checksum the mirror serves as <url>.sha256 in the format of sha256sum, if it has one.
+func verifyDownload(path string, url string, expectedSHA256 string) error {
+	if expectedSHA256 != "" {
+		return VerifyChecksum(path, expectedSHA256)
+	}
+	if mirror == "" || !strings.HasPrefix(url, mirror+"/") {
+		return nil
+	}
+	resp, err := http.Get(url + ".sha256")
+	if err != nil {
+		return fmt.Errorf("verifyDownload: failed getting checksum of %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		log.WithField("url", url).Debug("the mirror has no checksum for the image")
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("verifyDownload: getting checksum of %s failed with status %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return fmt.Errorf("verifyDownload: failed reading checksum of %s: %w", url, err)
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return fmt.Errorf("verifyDownload: empty checksum for %s", url)
+	}
+	return VerifyChecksum(path, fields[0])
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,91 @@
+package imagemounter_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios/imagemounter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageCache(t *testing.T) {
+	baseDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(baseDir, "15.7"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "15.7", "DeveloperDiskImage.dmg"), []byte("image"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(baseDir, "ddi-15F31d", "Restore"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "ddi-15F31d", "Restore", "BuildManifest.plist"), []byte("manifest"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(baseDir, "empty"), 0o755))
+
+	images, err := imagemounter.ListCachedImages(baseDir)
+	require.NoError(t, err)
+	assert.Equal(t, []imagemounter.CachedImage{
+		{Version: "15.7", Path: filepath.Join(baseDir, "15.7", "DeveloperDiskImage.dmg"), Size: 5},
+		{Version: "ddi-15F31d", Path: filepath.Join(baseDir, "ddi-15F31d", "Restore"), Personalized: true, Size: 8},
+	}, images)
+
+	t.Run("verify checksum", func(t *testing.T) {
+		// sha256 of "image"
+		checksum := "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d"
+		assert.NoError(t, imagemounter.VerifyChecksum(images[0].Path, checksum))
+		assert.Error(t, imagemounter.VerifyChecksum(images[0].Path, "00"))
+	})
+
+	t.Run("remove image", func(t *testing.T) {
+		assert.Error(t, imagemounter.RemoveCachedImage(baseDir, "../15.7"))
+		assert.Error(t, imagemounter.RemoveCachedImage(baseDir, "16.0"))
+		assert.NoError(t, imagemounter.RemoveCachedImage(baseDir, "15.7"))
+		images, err := imagemounter.ListCachedImages(baseDir)
+		require.NoError(t, err)
+		assert.Len(t, images, 1)
+	})
+
+	t.Run("missing base dir", func(t *testing.T) {
+		images, err := imagemounter.ListCachedImages(filepath.Join(baseDir, "missing"))
+		assert.NoError(t, err)
+		assert.Empty(t, images)
+	})
+}
+
+func TestDownloadFromMirrorVerifiesChecksums(t *testing.T) {
+	// sha256 of "image"
+	checksum := "6105d6cc76af400325e94d588ce511be5bfdbb73b437dc51eca43917d7a43e3d"
+	served := checksum
+	mux := http.NewServeMux()
+	mux.HandleFunc("/15.7/DeveloperDiskImage.dmg", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("image")) })
+	mux.HandleFunc("/15.7/DeveloperDiskImage.dmg.sha256", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  DeveloperDiskImage.dmg\n", served)
+	})
+	// the signature has no checksum on the mirror, so it is not verified
+	mux.HandleFunc("/15.7/DeveloperDiskImage.dmg.signature", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("signature")) })
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	imagemounter.SetMirror(server.URL)
+	defer imagemounter.SetMirror("")
+
+	t.Run("matching checksum", func(t *testing.T) {
+		baseDir := t.TempDir()
+		path, err := imagemounter.DownloadImage(baseDir, "15.7.2", "")
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(baseDir, "15.7", "DeveloperDiskImage.dmg"), path)
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		served = "00"
+		baseDir := t.TempDir()
+		_, err := imagemounter.DownloadImage(baseDir, "15.7.2", "")
+		assert.Error(t, err)
+		_, err = os.Stat(filepath.Join(baseDir, "15.7"))
+		assert.True(t, os.IsNotExist(err), "a failed download must not stay in the cache")
+	})
+
+	t.Run("expected checksum wins", func(t *testing.T) {
+		served = checksum
+		_, err := imagemounter.DownloadImage(t.TempDir(), "15.7.2", "00")
+		assert.Error(t, err)
+	})
+}
@@ -122,7 +122,11 @@ func MatchAvailable(version string) string {
 }
 
 func Download17Plus(baseDir string, version *semver.Version) (string, error) {
-	downloadUrl := fmt.Sprintf("%s%s%s", devicebox, xcode15_4_ddi, ".zip")
+	return download17Plus(baseDir, version, "")
+}
+
+func download17Plus(baseDir string, version *semver.Version, expectedSHA256 string) (string, error) {
+	downloadUrl := personalizedImageDownloadURL()
 	log.Infof("device iOS version: %s, getting developer image: %s", version.String(), downloadUrl)
 
 	imageDownloaded, err := validateBaseDirAndLookForImage(baseDir, xcode15_4_ddi)
@@ -137,7 +141,11 @@ func Download17Plus(baseDir string, version *semver.Version) (string, error) {
 	extractedPath := path.Join(baseDir, xcode15_4_ddi)
 	log.Infof("downloading '%s' to path '%s'", downloadUrl, imageFileName)
 	err = downloadFile(imageFileName, downloadUrl)
+	if err == nil {
+		err = verifyDownload(imageFileName, downloadUrl, expectedSHA256)
+	}
 	if err != nil {
+		os.Remove(imageFileName)
 		return "", err
 	}
 	_, _, err = ios.Unzip(imageFileName, extractedPath)
@@ -153,15 +161,22 @@ func DownloadImageFor(device ios.DeviceEntry, baseDir string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	parsedVersion, err := semver.NewVersion(allValues.Value.ProductVersion)
+	return DownloadImage(baseDir, allValues.Value.ProductVersion, "")
+}
+
+// DownloadImage downloads the developer disk image matching the iOS productVersion into baseDir, if it
+// is not cached there already. If expectedSHA256 is not empty, the checksum of the downloaded image
+// (the zip file for iOS 17+) is verified and the download is removed again if it does not match.
+func DownloadImage(baseDir string, productVersion string, expectedSHA256 string) (string, error) {
+	parsedVersion, err := semver.NewVersion(productVersion)
 	if err != nil {
-		return "", fmt.Errorf("DownloadImageFor: failed parsing ios productversion: '%s' with %w", allValues.Value.ProductVersion, err)
+		return "", fmt.Errorf("DownloadImage: failed parsing ios productversion: '%s' with %w", productVersion, err)
 	}
 	if parsedVersion.GreaterThan(ios.IOS17()) || parsedVersion.Equal(ios.IOS17()) {
-		return Download17Plus(baseDir, parsedVersion)
+		return download17Plus(baseDir, parsedVersion, expectedSHA256)
 	}
-	version := MatchAvailable(allValues.Value.ProductVersion)
-	log.Infof("device iOS version: %s, getting developer image for iOS %s", allValues.Value.ProductVersion, version)
+	version := MatchAvailable(productVersion)
+	log.Infof("device iOS version: %s, getting developer image for iOS %s", productVersion, version)
 	var imageToFind string
 	switch runtime.GOOS {
 	case "windows":
@@ -181,10 +196,10 @@ func DownloadImageFor(device ios.DeviceEntry, baseDir string) (string, error) {
 	log.Infof("downloading from: %s", downloadUrl)
 	log.Info("thank you github.com/mspvirajpatel for making these images available :-)")
 	versionDir := strings.Split(version, " (")[0]
-	downloadUrl = versionMap[version] + "/" + imageFile + "?raw=true"
+	downloadUrl = imageDownloadURL(version, imageFile)
 	imageFileName := path.Join(baseDir, versionDir, imageFile)
 
-	signatureDownloadUrl := versionMap[version] + "/" + signatureFile + "?raw=true"
+	signatureDownloadUrl := imageDownloadURL(version, signatureFile)
 	signatureFileName := path.Join(baseDir, versionDir, signatureFile)
 	err = os.Mkdir(path.Join(baseDir, versionDir), 0o755)
 	if err != nil {
@@ -192,12 +207,18 @@ func DownloadImageFor(device ios.DeviceEntry, baseDir string) (string, error) {
 	}
 	log.Infof("downloading '%s' to path '%s'", downloadUrl, imageFileName)
 	err = downloadFile(imageFileName, downloadUrl)
-	if err != nil {
-		return "", err
+	if err == nil {
+		err = verifyDownload(imageFileName, downloadUrl, expectedSHA256)
+	}
+	if err == nil {
+		err = downloadFile(signatureFileName, signatureDownloadUrl)
+	}
+	if err == nil {
+		err = verifyDownload(signatureFileName, signatureDownloadUrl, "")
 	}
-
-	err = downloadFile(signatureFileName, signatureDownloadUrl)
 	if err != nil {
+		// a partial download would be found and used by the next call
+		os.RemoveAll(path.Join(baseDir, versionDir))
 		return "", err
 	}
 
@@ -258,6 +279,9 @@ func downloadFile(filepath string, url string) error {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s failed with status %s", url, resp.Status)
+	}
 
 	// Create the file
 	out, err := os.Create(filepath)
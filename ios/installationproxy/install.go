@@ -0,0 +1,68 @@
+package installationproxy
+
+import (
+	"fmt"
+
+	ios "github.com/danielpaulus/go-ios/ios"
+	log "github.com/sirupsen/logrus"
+)
+
+// Install installs the app at packagePath, a path relative to the afc root like "PublicStaging/app.app" that the app
+// was uploaded to before. Use PackageType "Developer" in the options for unpacked app bundles.
+// progress is called with every status update of the installation if it is not nil.
+func (c *Connection) Install(packagePath string, options map[string]interface{}, progress func(status string, percent int)) error {
+	defer ios.SetOperationDeadline(c.deviceConn, ios.OperationInstall)()
+	installCommand := map[string]interface{}{
+		"Command":       "Install",
+		"PackagePath":   packagePath,
+		"ClientOptions": options,
+	}
+	b, err := c.plistCodec.Encode(installCommand)
+	if err != nil {
+		return err
+	}
+	err = c.deviceConn.Send(b)
+	if err != nil {
+		return err
+	}
+	for {
+		response, err := c.plistCodec.Decode(c.deviceConn.Reader())
+		if err != nil {
+			return err
+		}
+		dict, err := ios.ParsePlist(response)
+		if err != nil {
+			return err
+		}
+		done, status, percent, err := installProgress(dict)
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(status, percent)
+		}
+		if done {
+			log.Info("done installing")
+			return nil
+		}
+		log.WithFields(log.Fields{"status": status, "percentComplete": percent}).Info("installing")
+	}
+}
+
+// installProgress reads a status update of an install command
+func installProgress(dict map[string]interface{}) (done bool, status string, percent int, err error) {
+	if val, ok := dict["Error"]; ok {
+		return true, "", 0, fmt.Errorf("installProgress: failed installing: '%v' errorDescription:'%v'", val, dict["ErrorDescription"])
+	}
+	status, ok := dict["Status"].(string)
+	if !ok {
+		return true, "", 0, fmt.Errorf("installProgress: unknown status update: %+v", dict)
+	}
+	if status == "Complete" {
+		return true, status, 100, nil
+	}
+	if p, ok := dict["PercentComplete"].(uint64); ok {
+		percent = int(p)
+	}
+	return false, status, percent, nil
+}
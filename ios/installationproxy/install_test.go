@@ -0,0 +1,24 @@
+package installationproxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallProgress(t *testing.T) {
+	done, status, percent, err := installProgress(map[string]interface{}{"Status": "CopyingFile", "PercentComplete": uint64(40)})
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, "CopyingFile", status)
+	assert.Equal(t, 40, percent)
+
+	done, _, percent, err = installProgress(map[string]interface{}{"Status": "Complete"})
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, 100, percent)
+
+	done, _, _, err = installProgress(map[string]interface{}{"Error": "ApplicationVerificationFailed", "ErrorDescription": "bad signature"})
+	assert.True(t, done)
+	assert.ErrorContains(t, err, "bad signature")
+}
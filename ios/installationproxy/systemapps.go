@@ -0,0 +1,65 @@
+package installationproxy
+
+import "sort"
+
+// removableSystemApps are the bundle ids of the built-in apps that users can delete since iOS 12
+// and that can be installed again from the App Store.
+var removableSystemApps = map[string]bool{
+	"com.apple.Bridge":            true,
+	"com.apple.calculator":        true,
+	"com.apple.mobilecal":         true,
+	"com.apple.compass":           true,
+	"com.apple.MobileAddressBook": true,
+	"com.apple.facetime":          true,
+	"com.apple.DocumentsApp":      true,
+	"com.apple.mobileme.fmf1":     true,
+	"com.apple.findmy":            true,
+	"com.apple.Home":              true,
+	"com.apple.iBooks":            true,
+	"com.apple.iCloudDriveApp":    true,
+	"com.apple.MobileStore":       true,
+	"com.apple.mobilemail":        true,
+	"com.apple.Maps":              true,
+	"com.apple.measure":           true,
+	"com.apple.Music":             true,
+	"com.apple.news":              true,
+	"com.apple.mobilenotes":       true,
+	"com.apple.podcasts":          true,
+	"com.apple.reminders":         true,
+	"com.apple.shortcuts":         true,
+	"com.apple.stocks":            true,
+	"com.apple.tips":              true,
+	"com.apple.Translate":         true,
+	"com.apple.tv":                true,
+	"com.apple.videos":            true,
+	"com.apple.VoiceMemos":        true,
+	"com.apple.weather":           true,
+	"com.apple.freeform":          true,
+	"com.apple.Magnifier":         true,
+	"com.apple.clips":             true,
+	"com.apple.mobilegarageband":  true,
+	"com.apple.Keynote":           true,
+	"com.apple.Numbers":           true,
+	"com.apple.Pages":             true,
+	"com.apple.iMovie":            true,
+	"com.apple.supportapp":        true,
+	"com.apple.store.Jolly":       true,
+	"com.apple.Fitness":           true,
+	"com.apple.journal":           true,
+}
+
+// IsRemovableSystemApp returns true if bundleID is a built-in app that can be removed from a device
+// with Uninstall and hidden with a restrictions payload
+func IsRemovableSystemApp(bundleID string) bool {
+	return removableSystemApps[bundleID]
+}
+
+// RemovableSystemApps returns the sorted bundle ids of all built-in apps that can be removed
+func RemovableSystemApps() []string {
+	result := make([]string, 0, len(removableSystemApps))
+	for bundleID := range removableSystemApps {
+		result = append(result, bundleID)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,143 @@
+package instruments
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const slowNetworkCondition = "SlowNetworkCondition"
+
+// ConditionStep is one condition of a ConditionPreset. Profile identifiers differ between iOS versions, so Profiles
+// lists alternatives of which the first one the device supports is enabled.
+type ConditionStep struct {
+	ProfileType string   `json:"profileType"`
+	Profiles    []string `json:"profiles"`
+}
+
+// ConditionPreset is a named set of conditions that are enabled together. A preset can contain one condition per
+// profile type, f.ex. a network condition and a thermal condition.
+type ConditionPreset struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Conditions  []ConditionStep `json:"conditions"`
+}
+
+// Condition is a profile type and the profile of it that is enabled
+type Condition struct {
+	ProfileType ProfileType
+	Profile     Profile
+}
+
+var builtinConditionPresets = []ConditionPreset{
+	{
+		Name:        "offline",
+		Description: "100% packet loss",
+		Conditions:  []ConditionStep{{ProfileType: slowNetworkCondition, Profiles: []string{"SlowNetwork100PctLoss"}}},
+	},
+	{
+		Name:        "edge",
+		Description: "EDGE with average signal",
+		Conditions:  []ConditionStep{{ProfileType: slowNetworkCondition, Profiles: []string{"SlowNetworkEdgeAverage", "SlowNetworkEdgeGood"}}},
+	},
+	{
+		Name:        "3g-poor",
+		Description: "3G with weak signal, falls back to good EDGE on devices without it",
+		Conditions:  []ConditionStep{{ProfileType: slowNetworkCondition, Profiles: []string{"SlowNetwork3GBad", "SlowNetwork3GAverage", "SlowNetworkEdgeGood"}}},
+	},
+	{
+		Name:        "3g-good",
+		Description: "3G with good signal",
+		Conditions:  []ConditionStep{{ProfileType: slowNetworkCondition, Profiles: []string{"SlowNetwork3GGood"}}},
+	},
+	{
+		Name:        "lte-average",
+		Description: "LTE with average signal",
+		Conditions:  []ConditionStep{{ProfileType: slowNetworkCondition, Profiles: []string{"SlowNetworkLTEAverage", "SlowNetworkLTE"}}},
+	},
+	{
+		Name:        "wifi-congested",
+		Description: "Wi-Fi shared with many clients, limited to DSL bandwidth and latency",
+		Conditions:  []ConditionStep{{ProfileType: slowNetworkCondition, Profiles: []string{"SlowNetworkDSL"}}},
+	},
+	{
+		Name:        "very-poor-network",
+		Description: "very low bandwidth with high packet loss and latency",
+		Conditions:  []ConditionStep{{ProfileType: slowNetworkCondition, Profiles: []string{"SlowNetworkVeryBadNetwork"}}},
+	},
+	{
+		Name:        "high-latency-dns",
+		Description: "slow DNS lookups with normal bandwidth",
+		Conditions:  []ConditionStep{{ProfileType: slowNetworkCondition, Profiles: []string{"SlowNetworkHighLatencyDNS"}}},
+	},
+}
+
+// BuiltinConditionPresets returns the presets go-ios ships with, sorted by name
+func BuiltinConditionPresets() []ConditionPreset {
+	result := make([]ConditionPreset, len(builtinConditionPresets))
+	copy(result, builtinConditionPresets)
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Validate checks that the preset has a name and at least one condition and that no profile type is used twice
+func (p ConditionPreset) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("Validate: preset name is missing")
+	}
+	if len(p.Conditions) == 0 {
+		return fmt.Errorf("Validate: preset '%s' has no conditions", p.Name)
+	}
+	types := map[string]bool{}
+	for _, step := range p.Conditions {
+		if step.ProfileType == "" || len(step.Profiles) == 0 {
+			return fmt.Errorf("Validate: preset '%s' has a condition without profile type or profiles", p.Name)
+		}
+		if types[step.ProfileType] {
+			return fmt.Errorf("Validate: preset '%s' uses profile type '%s' twice, only one profile per type can be active", p.Name, step.ProfileType)
+		}
+		types[step.ProfileType] = true
+	}
+	return nil
+}
+
+// ResolvePreset picks the profiles of the preset from the profile types a device supports, see DeviceStateControl.List
+func ResolvePreset(types []ProfileType, preset ConditionPreset) ([]Condition, error) {
+	err := preset.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("ResolvePreset: %w", err)
+	}
+	result := make([]Condition, 0, len(preset.Conditions))
+	for _, step := range preset.Conditions {
+		condition, err := resolveStep(types, step)
+		if err != nil {
+			return nil, fmt.Errorf("ResolvePreset: preset '%s': %w", preset.Name, err)
+		}
+		result = append(result, condition)
+	}
+	return result, nil
+}
+
+func resolveStep(types []ProfileType, step ConditionStep) (Condition, error) {
+	for _, profileID := range step.Profiles {
+		profileType, profile, err := VerifyProfileAndType(types, step.ProfileType, profileID)
+		if err == nil {
+			return Condition{ProfileType: profileType, Profile: profile}, nil
+		}
+	}
+	return Condition{}, fmt.Errorf("device supports none of the profiles %s of profile type '%s'", strings.Join(step.Profiles, ", "), step.ProfileType)
+}
+
+// EnableConditions enables all conditions. If one fails, the conditions enabled before are disabled again.
+func (d DeviceStateControl) EnableConditions(conditions []Condition) error {
+	for i, condition := range conditions {
+		err := d.Enable(condition.ProfileType, condition.Profile)
+		if err != nil {
+			for _, enabled := range conditions[:i] {
+				d.Disable(enabled.ProfileType)
+			}
+			return fmt.Errorf("EnableConditions: enabling %s %s: %w", condition.ProfileType.Identifier, condition.Profile.Identifier, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package instruments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testProfileTypes = []ProfileType{
+	{Identifier: "SlowNetworkCondition", Profiles: []Profile{{Identifier: "SlowNetwork3GGood"}, {Identifier: "SlowNetworkEdgeGood"}}},
+	{Identifier: "ThermalCondition", Profiles: []Profile{{Identifier: "ThermalFair"}}},
+}
+
+func TestResolvePresetUsesFirstSupportedProfile(t *testing.T) {
+	preset := ConditionPreset{Name: "hot-and-slow", Conditions: []ConditionStep{
+		{ProfileType: "SlowNetworkCondition", Profiles: []string{"SlowNetwork3GBad", "SlowNetworkEdgeGood"}},
+		{ProfileType: "ThermalCondition", Profiles: []string{"ThermalFair"}},
+	}}
+	conditions, err := ResolvePreset(testProfileTypes, preset)
+	require.NoError(t, err)
+	require.Len(t, conditions, 2)
+	assert.Equal(t, "SlowNetworkEdgeGood", conditions[0].Profile.Identifier)
+	assert.Equal(t, "ThermalCondition", conditions[1].ProfileType.Identifier)
+
+	_, err = ResolvePreset(testProfileTypes, ConditionPreset{Name: "lte", Conditions: []ConditionStep{
+		{ProfileType: "SlowNetworkCondition", Profiles: []string{"SlowNetworkLTE"}},
+	}})
+	assert.ErrorContains(t, err, "supports none of the profiles SlowNetworkLTE")
+}
+
+func TestConditionPresetValidate(t *testing.T) {
+	step := ConditionStep{ProfileType: "SlowNetworkCondition", Profiles: []string{"SlowNetwork3GGood"}}
+	assert.NoError(t, ConditionPreset{Name: "3g", Conditions: []ConditionStep{step}}.Validate())
+	assert.Error(t, ConditionPreset{Conditions: []ConditionStep{step}}.Validate())
+	assert.Error(t, ConditionPreset{Name: "empty"}.Validate())
+	assert.Error(t, ConditionPreset{Name: "twice", Conditions: []ConditionStep{step, step}}.Validate())
+}
+
+func TestBuiltinConditionPresetsAreValid(t *testing.T) {
+	for _, preset := range BuiltinConditionPresets() {
+		assert.NoError(t, preset.Validate(), preset.Name)
+	}
+}
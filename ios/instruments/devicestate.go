@@ -78,6 +78,11 @@ func VerifyProfileAndType(types []ProfileType, profileTypeIdentifier string, pro
 	return ProfileType{}, Profile{}, fmt.Errorf("ProfiletypeIdentifier '%s' valid: %v.  Profile identifier %s valid:%v", profileTypeIdentifier, foundProfileType, profileIdentifier, foundProfile)
 }
 
+// Close closes the instruments connection, the device deactivates the conditions that were enabled with it
+func (d DeviceStateControl) Close() {
+	d.conn.Close()
+}
+
 // List returns a list of all available profile types and profiles.
 func (d DeviceStateControl) List() ([]ProfileType, error) {
 	const methodName = "availableConditionInducers"
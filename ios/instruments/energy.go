@@ -0,0 +1,115 @@
+package instruments
+
+import (
+	"fmt"
+
+	"github.com/danielpaulus/go-ios/ios"
+	dtx "github.com/danielpaulus/go-ios/ios/dtx_codec"
+	log "github.com/sirupsen/logrus"
+)
+
+const energyChannel = "com.apple.xcode.debug-gauge-data-providers.Energy"
+
+// EnergySample is the energy impact of a process like the energy gauge of Xcode shows it. The costs are the impact
+// since the last sample, 0 is none and values above 10 are a very high impact. Cost is the total of the others.
+type EnergySample struct {
+	Cost           float64 `json:"cost"`
+	CPUCost        float64 `json:"cpuCost"`
+	GPUCost        float64 `json:"gpuCost"`
+	NetworkingCost float64 `json:"networkingCost"`
+	DisplayCost    float64 `json:"displayCost"`
+	LocationCost   float64 `json:"locationCost"`
+	AppStateCost   float64 `json:"appStateCost"`
+	OverheadCost   float64 `json:"overheadCost"`
+}
+
+// EnergyMonitor samples the energy impact of processes
+type EnergyMonitor struct {
+	channel *dtx.Channel
+	conn    *dtx.Connection
+}
+
+// NewEnergyMonitor connects to the energy gauge service
+func NewEnergyMonitor(device ios.DeviceEntry) (*EnergyMonitor, error) {
+	conn, err := connectInstruments(device)
+	if err != nil {
+		return nil, err
+	}
+	channel := conn.RequestChannelIdentifier(energyChannel, channelDispatcher{})
+	return &EnergyMonitor{channel: channel, conn: conn}, nil
+}
+
+// Start starts sampling the processes, it has to be called before they can be sampled
+func (e *EnergyMonitor) Start(pids []uint64) error {
+	_, err := e.channel.MethodCall("startSamplingForPIDs:", pidList(pids))
+	if err != nil {
+		return fmt.Errorf("Start: failed starting energy sampling: %w", err)
+	}
+	return nil
+}
+
+// Sample returns the energy impact of the processes by pid, processes the device has no sample of yet are missing
+func (e *EnergyMonitor) Sample(pids []uint64) (map[uint64]EnergySample, error) {
+	msg, err := e.channel.MethodCall("sampleAttributes:forPIDs:", map[string]interface{}{}, pidList(pids))
+	if err != nil {
+		return nil, fmt.Errorf("Sample: failed sampling energy: %w", err)
+	}
+	if len(msg.Payload) == 0 {
+		return nil, fmt.Errorf("Sample: empty energy sample")
+	}
+	return parseEnergySamples(msg.Payload[0]), nil
+}
+
+// Stop stops sampling the processes
+func (e *EnergyMonitor) Stop(pids []uint64) error {
+	_, err := e.channel.MethodCall("stopSamplingForPIDs:", pidList(pids))
+	if err != nil {
+		return fmt.Errorf("Stop: failed stopping energy sampling: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connection
+func (e *EnergyMonitor) Close() error {
+	return e.conn.Close()
+}
+
+// pidList converts the pids for the archiver, which only encodes []interface{}
+func pidList(pids []uint64) []interface{} {
+	list := make([]interface{}, len(pids))
+	for i, pid := range pids {
+		list[i] = pid
+	}
+	return list
+}
+
+// parseEnergySamples reads the samples keyed by pid, the unarchiver turns the numeric keys into "uint64{pid}"
+func parseEnergySamples(payload interface{}) map[uint64]EnergySample {
+	result := map[uint64]EnergySample{}
+	samples, ok := payload.(map[string]interface{})
+	if !ok {
+		return result
+	}
+	for key, value := range samples {
+		var pid uint64
+		if _, err := fmt.Sscanf(key, "uint64{%d}", &pid); err != nil {
+			log.WithField("key", key).Debug("ignoring energy sample with unknown key")
+			continue
+		}
+		attrs, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		result[pid] = EnergySample{
+			Cost:           toFloat64(attrs["energy.cost"]),
+			CPUCost:        toFloat64(attrs["energy.CPU.cost"]),
+			GPUCost:        toFloat64(attrs["energy.GPU.cost"]),
+			NetworkingCost: toFloat64(attrs["energy.networking.cost"]),
+			DisplayCost:    toFloat64(attrs["energy.display.cost"]),
+			LocationCost:   toFloat64(attrs["energy.location.cost"]),
+			AppStateCost:   toFloat64(attrs["energy.appstate.cost"]),
+			OverheadCost:   toFloat64(attrs["energy.overhead"]),
+		}
+	}
+	return result
+}
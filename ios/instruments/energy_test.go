@@ -0,0 +1,23 @@
+package instruments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEnergySamples(t *testing.T) {
+	samples := parseEnergySamples(map[string]interface{}{
+		"uint64{312}": map[string]interface{}{
+			"energy.cost":            float64(3.5),
+			"energy.CPU.cost":        float64(2),
+			"energy.GPU.cost":        uint64(1),
+			"energy.networking.cost": float64(0.5),
+			"energy.overhead":        int64(0),
+		},
+		"uint64{7}": "not a sample",
+		"pid":       map[string]interface{}{},
+	})
+	assert.Equal(t, map[uint64]EnergySample{312: {Cost: 3.5, CPUCost: 2, GPUCost: 1, NetworkingCost: 0.5}}, samples)
+	assert.Empty(t, parseEnergySamples(nil))
+}
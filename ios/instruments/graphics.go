@@ -0,0 +1,95 @@
+package instruments
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	dtx "github.com/danielpaulus/go-ios/ios/dtx_codec"
+	log "github.com/sirupsen/logrus"
+)
+
+const graphicsChannel = "com.apple.instruments.server.services.graphics.opengl"
+
+// GraphicsSample is the frame rate and gpu utilization of a device. The values are system wide, the device does
+// not sample them per process.
+type GraphicsSample struct {
+	// FPS are the frames per second core animation rendered
+	FPS float64 `json:"fps"`
+	// DeviceUtilization, RendererUtilization and TilerUtilization are the utilization of the gpu in percent
+	DeviceUtilization   float64 `json:"deviceUtilization"`
+	RendererUtilization float64 `json:"rendererUtilization"`
+	TilerUtilization    float64 `json:"tilerUtilization"`
+}
+
+// Graphics samples the frame rate and gpu utilization of a device in an interval
+type Graphics struct {
+	channel *dtx.Channel
+	conn    *dtx.Connection
+	samples chan dtx.Message
+}
+
+// NewGraphics starts sampling the graphics of the device every interval
+func NewGraphics(device ios.DeviceEntry, interval time.Duration) (*Graphics, error) {
+	conn, err := connectInstruments(device)
+	if err != nil {
+		return nil, err
+	}
+	samples := make(chan dtx.Message, 1)
+	// the dispatcher of sysmontap only keeps the newest message as well
+	channel := conn.RequestChannelIdentifier(graphicsChannel, sysmontapDispatcher{conn: conn, samples: samples})
+	// the sampling rate is in tenths of a second
+	_, err = channel.MethodCall("setSamplingRate:", interval.Seconds()*10)
+	if err == nil {
+		_, err = channel.MethodCall("startSamplingAtTimeInterval:", float64(0))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("NewGraphics: failed starting graphics sampling: %w", err)
+	}
+	return &Graphics{channel: channel, conn: conn, samples: samples}, nil
+}
+
+// ReceiveSample waits for the next sample
+func (g *Graphics) ReceiveSample(ctx context.Context) (GraphicsSample, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return GraphicsSample{}, ctx.Err()
+		case msg := <-g.samples:
+			if len(msg.Payload) == 0 {
+				continue
+			}
+			if sample, ok := parseGraphicsSample(msg.Payload[0]); ok {
+				return sample, nil
+			}
+		}
+	}
+}
+
+// Close stops sampling and closes the connection
+func (g *Graphics) Close() error {
+	err := g.channel.MethodCallAsync("stopSampling")
+	if err != nil {
+		log.WithError(err).Debug("failed stopping graphics sampling")
+	}
+	return g.conn.Close()
+}
+
+func parseGraphicsSample(payload interface{}) (GraphicsSample, bool) {
+	values, ok := payload.(map[string]interface{})
+	if !ok {
+		return GraphicsSample{}, false
+	}
+	fps, ok := values["CoreAnimationFramesPerSecond"]
+	if !ok {
+		return GraphicsSample{}, false
+	}
+	return GraphicsSample{
+		FPS:                 toFloat64(fps),
+		DeviceUtilization:   toFloat64(values["Device Utilization %"]),
+		RendererUtilization: toFloat64(values["Renderer Utilization %"]),
+		TilerUtilization:    toFloat64(values["Tiler Utilization %"]),
+	}, true
+}
@@ -0,0 +1,21 @@
+package instruments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGraphicsSample(t *testing.T) {
+	_, ok := parseGraphicsSample(map[string]interface{}{"XRVideoCardRunTimeStamp": uint64(1)})
+	assert.False(t, ok)
+
+	sample, ok := parseGraphicsSample(map[string]interface{}{
+		"CoreAnimationFramesPerSecond": uint64(59),
+		"Device Utilization %":         uint64(35),
+		"Renderer Utilization %":       int64(30),
+		"Tiler Utilization %":          float64(12.5),
+	})
+	assert.True(t, ok)
+	assert.Equal(t, GraphicsSample{FPS: 59, DeviceUtilization: 35, RendererUtilization: 30, TilerUtilization: 12.5}, sample)
+}
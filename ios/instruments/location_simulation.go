@@ -44,6 +44,11 @@ func NewLocationSimulationService(device ios.DeviceEntry) (*LocationSimulationSe
 	return &LocationSimulationService{channel: processControlChannel, conn: dtxConn}, nil
 }
 
+// Closed is closed when the DTX connection was closed, the device stops simulating the location then
+func (d *LocationSimulationService) Closed() <-chan struct{} {
+	return d.conn.Closed()
+}
+
 // Close closes up the DTX connection
 func (d *LocationSimulationService) Close() {
 	d.conn.Close()
@@ -0,0 +1,212 @@
+package perfmon
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+)
+
+// the default LeakThresholds
+const (
+	DefaultMaxGrowthPerMinute = 1 << 20
+	DefaultMinFit             = 0.8
+	DefaultMinLeakSamples     = 10
+)
+
+// LeakThresholds decide when the memory growth of a process is reported as a suspected leak
+type LeakThresholds struct {
+	// MaxGrowthPerMinute is the footprint growth in bytes per minute that is still fine
+	MaxGrowthPerMinute float64 `json:"maxGrowthPerMinute"`
+	// MinFit is the coefficient of determination the trend needs at least, so a single spike isn't reported as leak
+	MinFit float64 `json:"minFit"`
+	// MinSamples is how many samples of the process are needed for a verdict
+	MinSamples int `json:"minSamples"`
+}
+
+// withDefaults fills in the thresholds that are not set
+func (t LeakThresholds) withDefaults() LeakThresholds {
+	if t.MaxGrowthPerMinute <= 0 {
+		t.MaxGrowthPerMinute = DefaultMaxGrowthPerMinute
+	}
+	if t.MinFit <= 0 {
+		t.MinFit = DefaultMinFit
+	}
+	if t.MinSamples <= 0 {
+		t.MinSamples = DefaultMinLeakSamples
+	}
+	return t
+}
+
+// LeakReport is the trend of the memory footprint of a process over a scenario
+type LeakReport struct {
+	Process string `json:"process"`
+	// Pid is the process the trend was fitted for, the one sampled longest if the app was launched again
+	Pid      uint64        `json:"pid"`
+	Samples  int           `json:"samples"`
+	Duration time.Duration `json:"duration"`
+	// StartFootprint and EndFootprint are the physical footprints in bytes of the first and the last sample
+	StartFootprint uint64 `json:"startFootprint"`
+	EndFootprint   uint64 `json:"endFootprint"`
+	PeakFootprint  uint64 `json:"peakFootprint"`
+	// GrowthPerMinute is the slope of the least squares fit of the footprint in bytes per minute
+	GrowthPerMinute float64 `json:"growthPerMinute"`
+	// Fit is the coefficient of determination of the trend, close to 1 if the footprint grows steadily
+	Fit float64 `json:"fit"`
+	// Leaking is true if the footprint grows faster than the threshold with a good enough fit
+	Leaking    bool           `json:"leaking"`
+	Verdict    string         `json:"verdict"`
+	Thresholds LeakThresholds `json:"thresholds"`
+}
+
+// AnalyzeLeaks fits a linear trend to the physical footprint of the samples and reports a suspected leak if it
+// exceeds the thresholds. Samples while the process did not run are ignored. If it was launched again, only the
+// pid with the most samples is analyzed, because a new process starts with a smaller footprint.
+func AnalyzeLeaks(samples []Sample, thresholds LeakThresholds) LeakReport {
+	thresholds = thresholds.withDefaults()
+	report := LeakReport{Thresholds: thresholds}
+	byPid := map[uint64][]Sample{}
+	for _, sample := range samples {
+		if report.Process == "" {
+			report.Process = sample.Process
+		}
+		if sample.Pid == 0 {
+			continue
+		}
+		byPid[sample.Pid] = append(byPid[sample.Pid], sample)
+		if len(byPid[sample.Pid]) > len(byPid[report.Pid]) {
+			report.Pid = sample.Pid
+		}
+	}
+	process := byPid[report.Pid]
+	report.Samples = len(process)
+	if report.Samples < thresholds.MinSamples {
+		report.Verdict = fmt.Sprintf("not enough samples, got %d of %d", report.Samples, thresholds.MinSamples)
+		return report
+	}
+	first, last := process[0], process[len(process)-1]
+	report.Duration = last.Time.Sub(first.Time)
+	report.StartFootprint = first.PhysFootprint
+	report.EndFootprint = last.PhysFootprint
+	for _, sample := range process {
+		if sample.PhysFootprint > report.PeakFootprint {
+			report.PeakFootprint = sample.PhysFootprint
+		}
+	}
+	if report.Duration <= 0 {
+		report.Verdict = "all samples have the same time"
+		return report
+	}
+	report.GrowthPerMinute, report.Fit = fitTrend(process, first.Time)
+	report.Leaking = report.GrowthPerMinute > thresholds.MaxGrowthPerMinute && report.Fit >= thresholds.MinFit
+	switch {
+	case report.Leaking:
+		report.Verdict = fmt.Sprintf("suspected leak, the footprint grows %.0f bytes per minute", report.GrowthPerMinute)
+	case report.GrowthPerMinute > thresholds.MaxGrowthPerMinute:
+		report.Verdict = "the footprint grows but not steadily enough for a leak"
+	default:
+		report.Verdict = "no leak"
+	}
+	return report
+}
+
+// fitTrend returns the slope in bytes per minute and the coefficient of determination of the least squares fit
+func fitTrend(samples []Sample, start time.Time) (float64, float64) {
+	n := float64(len(samples))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range samples {
+		x := sample.Time.Sub(start).Minutes()
+		y := float64(sample.PhysFootprint)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, 0
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	intercept := (sumY - slope*sumX) / n
+	meanY := sumY / n
+	var residual, total float64
+	for _, sample := range samples {
+		x := sample.Time.Sub(start).Minutes()
+		y := float64(sample.PhysFootprint)
+		residual += math.Pow(y-(intercept+slope*x), 2)
+		total += math.Pow(y-meanY, 2)
+	}
+	if total == 0 {
+		// a constant footprint is fitted perfectly by a flat line
+		return slope, 1
+	}
+	return slope, 1 - residual/total
+}
+
+// LeakSampler samples the memory footprint of a process in the background while a scenario runs
+type LeakSampler struct {
+	monitor sampler
+	stop    context.CancelFunc
+	done    chan struct{}
+	mu      sync.Mutex
+	samples []Sample
+	err     error
+}
+
+// sampler is implemented by Monitor
+type sampler interface {
+	Next(ctx context.Context) (Sample, error)
+	Close() error
+}
+
+// StartLeakSampling starts sampling the process the config selects, graphics are never sampled.
+// Call Stop once the scenario is done to get the LeakReport.
+func StartLeakSampling(device ios.DeviceEntry, config Config) (*LeakSampler, error) {
+	config.NoGraphics = true
+	monitor, err := New(device, config)
+	if err != nil {
+		return nil, err
+	}
+	return newLeakSampler(monitor), nil
+}
+
+func newLeakSampler(monitor sampler) *LeakSampler {
+	ctx, stop := context.WithCancel(context.Background())
+	s := &LeakSampler{monitor: monitor, stop: stop, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		for {
+			sample, err := monitor.Next(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.mu.Lock()
+					s.err = err
+					s.mu.Unlock()
+				}
+				return
+			}
+			s.mu.Lock()
+			s.samples = append(s.samples, sample)
+			s.mu.Unlock()
+		}
+	}()
+	return s
+}
+
+// Stop ends sampling and analyzes the samples. The error is why sampling ended early, the report contains the
+// samples until then.
+func (s *LeakSampler) Stop(thresholds LeakThresholds) (LeakReport, error) {
+	s.stop()
+	<-s.done
+	closeErr := s.monitor.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.err
+	if err == nil {
+		err = closeErr
+	}
+	return AnalyzeLeaks(s.samples, thresholds), err
+}
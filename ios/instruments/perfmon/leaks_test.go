@@ -0,0 +1,110 @@
+package perfmon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func footprints(pid uint64, start time.Time, values ...uint64) []Sample {
+	samples := make([]Sample, len(values))
+	for i, value := range values {
+		samples[i] = Sample{Time: start.Add(time.Duration(i) * 10 * time.Second), Pid: pid, Process: "Example", PhysFootprint: value}
+	}
+	return samples
+}
+
+func TestAnalyzeLeaks(t *testing.T) {
+	start := time.Date(2024, 1, 16, 12, 0, 0, 0, time.UTC)
+	const mb = 1 << 20
+
+	// 1 MB every 10 seconds
+	leaking := footprints(312, start, 100*mb, 101*mb, 102*mb, 103*mb, 104*mb, 105*mb, 106*mb, 107*mb, 108*mb, 109*mb, 110*mb, 111*mb)
+	report := AnalyzeLeaks(leaking, LeakThresholds{})
+	assert.True(t, report.Leaking, report.Verdict)
+	assert.Equal(t, "Example", report.Process)
+	assert.Equal(t, uint64(312), report.Pid)
+	assert.Equal(t, 12, report.Samples)
+	assert.Equal(t, 110*time.Second, report.Duration)
+	assert.InDelta(t, 6*mb, report.GrowthPerMinute, 1)
+	assert.InDelta(t, 1, report.Fit, 0.0001)
+	assert.Equal(t, uint64(111*mb), report.PeakFootprint)
+	assert.Equal(t, LeakThresholds{MaxGrowthPerMinute: DefaultMaxGrowthPerMinute, MinFit: DefaultMinFit, MinSamples: DefaultMinLeakSamples}, report.Thresholds)
+
+	report = AnalyzeLeaks(leaking, LeakThresholds{MaxGrowthPerMinute: 10 * mb})
+	assert.False(t, report.Leaking, "growth below the threshold")
+	assert.Equal(t, "no leak", report.Verdict)
+
+	spiky := footprints(312, start, 100*mb, 100*mb, 100*mb, 100*mb, 100*mb, 100*mb, 100*mb, 100*mb, 100*mb, 160*mb, 100*mb, 140*mb)
+	report = AnalyzeLeaks(spiky, LeakThresholds{})
+	assert.Greater(t, report.GrowthPerMinute, float64(DefaultMaxGrowthPerMinute))
+	assert.False(t, report.Leaking, "spikes are no steady growth")
+
+	flat := footprints(312, start, 100*mb, 100*mb, 100*mb, 100*mb, 100*mb, 100*mb, 100*mb, 100*mb, 100*mb, 100*mb)
+	report = AnalyzeLeaks(flat, LeakThresholds{})
+	assert.False(t, report.Leaking)
+	assert.Equal(t, float64(0), report.GrowthPerMinute)
+
+	// the app was relaunched, the longer run is analyzed and samples without the process are ignored
+	relaunched := append(footprints(200, start, 300*mb, 300*mb), Sample{Time: start.Add(20 * time.Second), Process: "Example"})
+	relaunched = append(relaunched, leaking...)
+	report = AnalyzeLeaks(relaunched, LeakThresholds{})
+	assert.Equal(t, uint64(312), report.Pid)
+	assert.True(t, report.Leaking)
+
+	report = AnalyzeLeaks(leaking[:5], LeakThresholds{})
+	assert.False(t, report.Leaking)
+	assert.Equal(t, "not enough samples, got 5 of 10", report.Verdict)
+}
+
+type fakeSampler struct {
+	samples chan Sample
+	closed  bool
+}
+
+func (f *fakeSampler) Next(ctx context.Context) (Sample, error) {
+	select {
+	case <-ctx.Done():
+		return Sample{}, ctx.Err()
+	case sample := <-f.samples:
+		return sample, nil
+	}
+}
+
+func (f *fakeSampler) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestLeakSampler(t *testing.T) {
+	monitor := &fakeSampler{samples: make(chan Sample)}
+	sampler := newLeakSampler(monitor)
+	start := time.Date(2024, 1, 16, 12, 0, 0, 0, time.UTC)
+	for _, sample := range footprints(312, start, 1, 2, 3) {
+		monitor.samples <- sample
+	}
+	report, err := sampler.Stop(LeakThresholds{MinSamples: 3, MaxGrowthPerMinute: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Samples)
+	assert.True(t, report.Leaking)
+	assert.True(t, monitor.closed)
+}
+
+func TestLeakSamplerOnMonitor(t *testing.T) {
+	processes := &fakeProcesses{samples: make(chan []instruments.ProcessSample, 1)}
+	sampler := newLeakSampler(newMonitor("Example", processes, nil))
+	processes.samples <- []instruments.ProcessSample{{Pid: 312, Name: "Example", PhysFootprint: 1}}
+	require.Eventually(t, func() bool {
+		sampler.mu.Lock()
+		defer sampler.mu.Unlock()
+		return len(sampler.samples) == 1
+	}, time.Second, time.Millisecond)
+	report, err := sampler.Stop(LeakThresholds{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Samples)
+	assert.True(t, processes.closed)
+}
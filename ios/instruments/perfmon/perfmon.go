@@ -0,0 +1,179 @@
+// Package perfmon samples the cpu usage, memory, frame rate and gpu utilization of an app with instruments,
+// like the Activity Monitor and Core Animation instruments of Xcode. Processes are sampled with sysmontap and
+// graphics with the opengl service, both need a mounted developer disk image.
+package perfmon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/installationproxy"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultInterval is the interval between samples if the config has none
+const DefaultInterval = time.Second
+
+// Config selects what is sampled
+type Config struct {
+	// BundleID selects the app to sample
+	BundleID string
+	// Process selects the process by its name if BundleID is empty, f.ex. SpringBoard
+	Process string
+	// Interval is the time between samples, DefaultInterval if it is 0
+	Interval time.Duration
+	// NoGraphics skips sampling the frame rate and gpu utilization
+	NoGraphics bool
+}
+
+// Sample is the resource usage of the process at a point in time
+type Sample struct {
+	Time time.Time `json:"time"`
+	// Pid is 0 while the process is not running, the pid changes when the app is launched again
+	Pid     uint64 `json:"pid"`
+	Process string `json:"process"`
+	// CPUUsage is the cpu usage in percent of one core since the last sample
+	CPUUsage float64 `json:"cpuUsage"`
+	// MemResidentSize is the resident memory in bytes
+	MemResidentSize uint64 `json:"memResidentSize"`
+	// PhysFootprint is the memory in bytes the process is charged for, what Xcode shows as memory usage
+	PhysFootprint uint64 `json:"physFootprint"`
+	// Graphics are the system wide frame rate and gpu utilization, missing until the first graphics sample arrived
+	Graphics *instruments.GraphicsSample `json:"graphics,omitempty"`
+}
+
+// processSampler is implemented by instruments.Sysmontap
+type processSampler interface {
+	ReceiveProcesses(ctx context.Context) ([]instruments.ProcessSample, error)
+	Close() error
+}
+
+// graphicsSampler is implemented by instruments.Graphics
+type graphicsSampler interface {
+	ReceiveSample(ctx context.Context) (instruments.GraphicsSample, error)
+	Close() error
+}
+
+// Monitor samples a process every interval
+type Monitor struct {
+	process   string
+	processes processSampler
+	graphics  graphicsSampler
+	stop      context.CancelFunc
+	mu        sync.Mutex
+	last      *instruments.GraphicsSample
+	now       func() time.Time
+}
+
+// New starts sampling the process the config selects. If sampling graphics fails, f.ex. because the device
+// has no such service, only the process is sampled.
+func New(device ios.DeviceEntry, config Config) (*Monitor, error) {
+	process := config.Process
+	if config.BundleID != "" {
+		var err error
+		process, err = executable(device, config.BundleID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if process == "" {
+		return nil, fmt.Errorf("perfmon: no bundle id or process selected")
+	}
+	interval := config.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	processes, err := instruments.NewSysmontap(device, interval)
+	if err != nil {
+		return nil, err
+	}
+	var graphics graphicsSampler
+	if !config.NoGraphics {
+		g, err := instruments.NewGraphics(device, interval)
+		if err != nil {
+			log.WithField("udid", device.Properties.SerialNumber).WithError(err).Warn("perfmon: sampling graphics failed, only sampling the process")
+		} else {
+			graphics = g
+		}
+	}
+	return newMonitor(process, processes, graphics), nil
+}
+
+func newMonitor(process string, processes processSampler, graphics graphicsSampler) *Monitor {
+	ctx, stop := context.WithCancel(context.Background())
+	m := &Monitor{process: process, processes: processes, graphics: graphics, stop: stop, now: time.Now}
+	if graphics != nil {
+		go m.receiveGraphics(ctx)
+	}
+	return m
+}
+
+// receiveGraphics keeps the newest graphics sample, graphics are sampled independently of the processes
+func (m *Monitor) receiveGraphics(ctx context.Context) {
+	for {
+		sample, err := m.graphics.ReceiveSample(ctx)
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		m.last = &sample
+		m.mu.Unlock()
+	}
+}
+
+// Next waits for the next sample
+func (m *Monitor) Next(ctx context.Context) (Sample, error) {
+	processes, err := m.processes.ReceiveProcesses(ctx)
+	if err != nil {
+		return Sample{}, err
+	}
+	sample := Sample{Time: m.now(), Process: m.process}
+	for _, p := range processes {
+		if p.Name == m.process {
+			sample.Pid = p.Pid
+			sample.CPUUsage = p.CPUUsage
+			sample.MemResidentSize = p.MemResidentSize
+			sample.PhysFootprint = p.PhysFootprint
+			break
+		}
+	}
+	m.mu.Lock()
+	sample.Graphics = m.last
+	m.mu.Unlock()
+	return sample, nil
+}
+
+// Close stops sampling
+func (m *Monitor) Close() error {
+	m.stop()
+	if m.graphics != nil {
+		err := m.graphics.Close()
+		if err != nil {
+			log.WithError(err).Debug("perfmon: failed closing graphics")
+		}
+	}
+	return m.processes.Close()
+}
+
+// executable returns the name of the process of the app with bundleID
+func executable(device ios.DeviceEntry, bundleID string) (string, error) {
+	svc, err := installationproxy.New(device)
+	if err != nil {
+		return "", err
+	}
+	defer svc.Close()
+	apps, err := svc.BrowseAllApps()
+	if err != nil {
+		return "", err
+	}
+	for _, app := range apps {
+		if app.CFBundleIdentifier == bundleID {
+			return app.CFBundleExecutable, nil
+		}
+	}
+	return "", fmt.Errorf("perfmon: app %s is not installed", bundleID)
+}
@@ -0,0 +1,89 @@
+package perfmon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProcesses struct {
+	samples chan []instruments.ProcessSample
+	closed  bool
+}
+
+func (f *fakeProcesses) ReceiveProcesses(ctx context.Context) ([]instruments.ProcessSample, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case sample := <-f.samples:
+		return sample, nil
+	}
+}
+
+func (f *fakeProcesses) Close() error {
+	f.closed = true
+	return nil
+}
+
+type fakeGraphics struct {
+	samples chan instruments.GraphicsSample
+}
+
+func (f *fakeGraphics) ReceiveSample(ctx context.Context) (instruments.GraphicsSample, error) {
+	select {
+	case <-ctx.Done():
+		return instruments.GraphicsSample{}, ctx.Err()
+	case sample := <-f.samples:
+		return sample, nil
+	}
+}
+
+func (f *fakeGraphics) Close() error {
+	return nil
+}
+
+func TestMonitor(t *testing.T) {
+	processes := &fakeProcesses{samples: make(chan []instruments.ProcessSample, 3)}
+	graphics := &fakeGraphics{samples: make(chan instruments.GraphicsSample)}
+	monitor := newMonitor("Example", processes, graphics)
+	now := time.Date(2024, 1, 16, 12, 0, 0, 0, time.UTC)
+	monitor.now = func() time.Time { return now }
+
+	processes.samples <- []instruments.ProcessSample{{Pid: 1, Name: "launchd"}}
+	sample, err := monitor.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Sample{Time: now, Process: "Example"}, sample, "the app is not running yet")
+
+	graphics.samples <- instruments.GraphicsSample{FPS: 58, DeviceUtilization: 25}
+	require.Eventually(t, func() bool {
+		monitor.mu.Lock()
+		defer monitor.mu.Unlock()
+		return monitor.last != nil
+	}, time.Second, time.Millisecond)
+	processes.samples <- []instruments.ProcessSample{
+		{Pid: 1, Name: "launchd"},
+		{Pid: 312, Name: "Example", CPUUsage: 45.5, MemResidentSize: 104857600, PhysFootprint: 52428800},
+	}
+	sample, err = monitor.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Sample{
+		Time:            now,
+		Pid:             312,
+		Process:         "Example",
+		CPUUsage:        45.5,
+		MemResidentSize: 104857600,
+		PhysFootprint:   52428800,
+		Graphics:        &instruments.GraphicsSample{FPS: 58, DeviceUtilization: 25},
+	}, sample)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = monitor.Next(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	require.NoError(t, monitor.Close())
+	assert.True(t, processes.closed)
+}
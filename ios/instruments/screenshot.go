@@ -27,7 +27,7 @@ func NewScreenshotService(device ios.DeviceEntry) (*ScreenshotService, error) {
 	if err != nil {
 		return nil, err
 	}
-	processControlChannel := dtxConn.RequestChannelIdentifier(screenshotServiceName, loggingDispatcher{dtxConn})
+	processControlChannel := dtxConn.RequestChannelIdentifier(screenshotServiceName, loggingDispatcher{dtxConn}, dtx.WithTimeoutDuration(ios.PolicyFor(ios.OperationScreenshot).Timeout))
 	return &ScreenshotService{channel: processControlChannel, conn: dtxConn}, nil
 }
 
@@ -0,0 +1,183 @@
+package instruments
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	dtx "github.com/danielpaulus/go-ios/ios/dtx_codec"
+	log "github.com/sirupsen/logrus"
+)
+
+const sysmontapChannel = "com.apple.instruments.server.services.sysmontap"
+
+// sysmontapProcAttrs are the attributes sysmontap samples of every process, in the order of the sampled values
+var sysmontapProcAttrs = []string{"pid", "name", "cpuUsage", "memResidentSize", "physFootprint"}
+
+// ProcessSample is the resource usage of a process sampled by sysmontap
+type ProcessSample struct {
+	Pid  uint64 `json:"pid"`
+	Name string `json:"name"`
+	// CPUUsage is the cpu usage in percent of one core since the last sample
+	CPUUsage float64 `json:"cpuUsage"`
+	// MemResidentSize is the resident memory in bytes
+	MemResidentSize uint64 `json:"memResidentSize"`
+	// PhysFootprint is the memory in bytes the process is charged for, what Xcode shows as memory usage
+	PhysFootprint uint64 `json:"physFootprint"`
+}
+
+// Sysmontap samples the resource usage of all processes of a device in an interval
+type Sysmontap struct {
+	channel *dtx.Channel
+	conn    *dtx.Connection
+	samples chan dtx.Message
+}
+
+type sysmontapDispatcher struct {
+	conn    *dtx.Connection
+	samples chan dtx.Message
+}
+
+func (d sysmontapDispatcher) Dispatch(m dtx.Message) {
+	dtx.SendAckIfNeeded(d.conn, m)
+	select {
+	case d.samples <- m:
+	default:
+		// nobody receives samples right now, newer ones will follow
+	}
+}
+
+// NewSysmontap starts sampling the processes of the device every interval
+func NewSysmontap(device ios.DeviceEntry, interval time.Duration) (*Sysmontap, error) {
+	conn, err := connectInstruments(device)
+	if err != nil {
+		return nil, err
+	}
+	samples := make(chan dtx.Message, 1)
+	channel := conn.RequestChannelIdentifier(sysmontapChannel, sysmontapDispatcher{conn: conn, samples: samples})
+	// the archiver only encodes []interface{}
+	procAttrs := make([]interface{}, len(sysmontapProcAttrs))
+	for i, attr := range sysmontapProcAttrs {
+		procAttrs[i] = attr
+	}
+	config := map[string]interface{}{
+		"ur":             uint64(interval.Milliseconds()),
+		"bm":             uint64(0),
+		"cpuUsage":       true,
+		"sampleInterval": uint64(interval.Nanoseconds()),
+		"procAttrs":      procAttrs,
+		"sysAttrs":       []interface{}{},
+	}
+	_, err = channel.MethodCall("setConfig:", config)
+	if err == nil {
+		_, err = channel.MethodCall("start")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("NewSysmontap: failed starting sysmontap: %w", err)
+	}
+	return &Sysmontap{channel: channel, conn: conn, samples: samples}, nil
+}
+
+// ReceiveProcesses waits for the next sample of all processes
+func (s *Sysmontap) ReceiveProcesses(ctx context.Context) ([]ProcessSample, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case msg := <-s.samples:
+			if len(msg.Payload) == 0 {
+				continue
+			}
+			// the first messages may only contain system attributes
+			if processes, ok := parseProcessSamples(msg.Payload[0]); ok {
+				return processes, nil
+			}
+		}
+	}
+}
+
+// Close stops sampling and closes the connection
+func (s *Sysmontap) Close() error {
+	err := s.channel.MethodCallAsync("stop")
+	if err != nil {
+		log.WithError(err).Debug("failed stopping sysmontap")
+	}
+	return s.conn.Close()
+}
+
+// SampleProcesses samples the resource usage of all processes once
+func SampleProcesses(ctx context.Context, device ios.DeviceEntry) ([]ProcessSample, error) {
+	sysmontap, err := NewSysmontap(device, 500*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	defer sysmontap.Close()
+	return sysmontap.ReceiveProcesses(ctx)
+}
+
+// parseProcessSamples extracts the processes from a sysmontap message. Its payload is a list of samples, the
+// processes are a map from the pid to the values of sysmontapProcAttrs.
+func parseProcessSamples(payload interface{}) ([]ProcessSample, bool) {
+	samples, ok := payload.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	for _, sample := range samples {
+		sampleMap, ok := sample.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		processes, ok := sampleMap["Processes"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		result := make([]ProcessSample, 0, len(processes))
+		for _, values := range processes {
+			attrs, ok := values.([]interface{})
+			if !ok || len(attrs) < len(sysmontapProcAttrs) {
+				continue
+			}
+			name, _ := attrs[1].(string)
+			result = append(result, ProcessSample{
+				Pid:             toUint64(attrs[0]),
+				Name:            name,
+				CPUUsage:        toFloat64(attrs[2]),
+				MemResidentSize: toUint64(attrs[3]),
+				PhysFootprint:   toUint64(attrs[4]),
+			})
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Pid < result[j].Pid })
+		return result, true
+	}
+	return nil, false
+}
+
+func toUint64(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		if n < 0 {
+			return 0
+		}
+		return uint64(n)
+	case float64:
+		return uint64(n)
+	}
+	return 0
+}
+
+func toFloat64(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case uint64:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return 0
+}
@@ -0,0 +1,26 @@
+package instruments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProcessSamples(t *testing.T) {
+	_, ok := parseProcessSamples([]interface{}{map[string]interface{}{"CPUCount": uint64(6)}})
+	assert.False(t, ok, "samples without processes are skipped")
+
+	processes, ok := parseProcessSamples([]interface{}{
+		map[string]interface{}{"CPUCount": uint64(6)},
+		map[string]interface{}{"Processes": map[string]interface{}{
+			"uint64{312}": []interface{}{uint64(312), "SpringBoard", 1.5, uint64(104857600), uint64(52428800)},
+			"uint64{1}":   []interface{}{uint64(1), "launchd", float64(0), uint64(8388608), int64(4194304)},
+			"uint64{7}":   []interface{}{uint64(7)},
+		}},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, []ProcessSample{
+		{Pid: 1, Name: "launchd", MemResidentSize: 8388608, PhysFootprint: 4194304},
+		{Pid: 312, Name: "SpringBoard", CPUUsage: 1.5, MemResidentSize: 104857600, PhysFootprint: 52428800},
+	}, processes)
+}
@@ -0,0 +1,230 @@
+package ios
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LifecycleState describes what a device is currently doing from an orchestration point of view.
+// Only devices in LifecycleStateNormal should be used for running jobs.
+type LifecycleState string
+
+const (
+	LifecycleStateNormal          = LifecycleState("normal")
+	LifecycleStateSetupAssistant  = LifecycleState("setup_assistant")
+	LifecycleStateUpdating        = LifecycleState("updating")
+	LifecycleStateRestoring       = LifecycleState("restoring")
+	LifecycleStateRecovery        = LifecycleState("recovery")
+	LifecycleStateDetached        = LifecycleState("detached")
+	LifecycleStateUnknown         = LifecycleState("unknown")
+	lockdownTypeRestoreMode       = "com.apple.mobile.restored"
+	defaultLifecycleProbeInterval = 30 * time.Second
+)
+
+// LifecycleEvent is sent whenever the LifecycleState of a device changes
+type LifecycleEvent struct {
+	UDID     string
+	DeviceID int
+	State    LifecycleState
+	Previous LifecycleState
+	// ECID is set for devices in recovery or DFU mode, those have no DeviceID and older ones no UDID either
+	ECID string `json:",omitempty"`
+}
+
+// ProbeLifecycleState derives the LifecycleState of a device usbmuxd lists by asking lockdown.
+// A device that runs in restore mode is reported as updating if it has a pair record,
+// because then it was in use before, and as restoring otherwise. Devices in recovery or DFU mode are not
+// listed by usbmuxd, use ListRecoveryDevices for them.
+func ProbeLifecycleState(device DeviceEntry) LifecycleState {
+	muxConn, err := NewUsbMuxConnectionSimple()
+	if err != nil {
+		return LifecycleStateUnknown
+	}
+	lockdown, err := muxConn.ConnectLockdown(device.DeviceID)
+	if err != nil {
+		return LifecycleStateUnknown
+	}
+	lockdownType, err := lockdown.QueryType()
+	lockdown.Close()
+	if err != nil {
+		return LifecycleStateUnknown
+	}
+	if lockdownType == lockdownTypeRestoreMode {
+		if _, err := ReadPairRecord(device.Properties.SerialNumber); err == nil {
+			return LifecycleStateUpdating
+		}
+		return LifecycleStateRestoring
+	}
+
+	session, err := ConnectLockdownWithSession(device)
+	if err != nil {
+		// unpaired devices can't tell us if they finished the setup
+		return LifecycleStateNormal
+	}
+	defer session.Close()
+	setupDone, err := session.GetValueForDomain("SetupDone", "com.apple.purplebuddy")
+	if err == nil {
+		if done, ok := setupDone.(bool); ok && !done {
+			return LifecycleStateSetupAssistant
+		}
+	}
+	return LifecycleStateNormal
+}
+
+// QueryType returns the type of the lockdown service, which is "com.apple.mobile.lockdown" normally
+// and "com.apple.mobile.restored" while the device is restoring or updating.
+func (lockDownConn *LockDownConnection) QueryType() (string, error) {
+	err := lockDownConn.Send(map[string]interface{}{"Label": "go-ios", "Request": "QueryType"})
+	if err != nil {
+		return "", err
+	}
+	resp, err := lockDownConn.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+	response, err := ParsePlist(resp)
+	if err != nil {
+		return "", err
+	}
+	lockdownType, ok := response["Type"].(string)
+	if !ok {
+		return "", fmt.Errorf("QueryType: unexpected response %+v", response)
+	}
+	return lockdownType, nil
+}
+
+// ListenLifecycle sends a LifecycleEvent for every device that gets attached or detached and whenever
+// the LifecycleState of an attached device changes. Attached devices are probed every probeInterval,
+// the default of 30 seconds is used if probeInterval is 0. Devices in recovery or DFU mode are found by
+// enumerating usb devices every probeInterval as well, on platforms without usb enumeration they are not reported.
+// The channel is closed when ctx is done or the connection to usbmuxd is lost.
+func ListenLifecycle(ctx context.Context, probeInterval time.Duration) (<-chan LifecycleEvent, error) {
+	if probeInterval == 0 {
+		probeInterval = defaultLifecycleProbeInterval
+	}
+	receive, closeListener, err := Listen()
+	if err != nil {
+		return nil, err
+	}
+	attached := make(chan AttachedMessage)
+	go func() {
+		defer close(attached)
+		for {
+			msg, err := receive()
+			if err != nil {
+				log.WithError(err).Debug("ListenLifecycle: usbmuxd listener stopped")
+				return
+			}
+			select {
+			case attached <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	events := make(chan LifecycleEvent)
+	go func() {
+		defer close(events)
+		defer closeListener()
+		devices := map[int]DeviceEntry{}
+		states := map[int]LifecycleState{}
+		send := func(device DeviceEntry, state LifecycleState) bool {
+			previous, ok := states[device.DeviceID]
+			if ok && previous == state {
+				return true
+			}
+			if !ok {
+				previous = LifecycleStateDetached
+			}
+			states[device.DeviceID] = state
+			select {
+			case events <- LifecycleEvent{UDID: device.Properties.SerialNumber, DeviceID: device.DeviceID, State: state, Previous: previous}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		recovery := map[string]RecoveryDevice{}
+		sendRecovery := func() bool {
+			found, err := ListRecoveryDevices()
+			if err != nil {
+				if !errors.Is(err, ErrUSBEnumerationUnsupported) {
+					log.WithError(err).Debug("ListenLifecycle: failed listing recovery devices")
+				}
+				return true
+			}
+			current := map[string]RecoveryDevice{}
+			for _, device := range found {
+				current[device.ECID] = device
+			}
+			var changed []LifecycleEvent
+			for ecid, device := range current {
+				if _, ok := recovery[ecid]; !ok {
+					changed = append(changed, LifecycleEvent{UDID: device.UDID, ECID: ecid, State: LifecycleStateRecovery, Previous: LifecycleStateDetached})
+				}
+			}
+			for ecid, device := range recovery {
+				if _, ok := current[ecid]; !ok {
+					changed = append(changed, LifecycleEvent{UDID: device.UDID, ECID: ecid, State: LifecycleStateDetached, Previous: LifecycleStateRecovery})
+				}
+			}
+			recovery = current
+			for _, event := range changed {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		}
+		if !sendRecovery() {
+			return
+		}
+		ticker := time.NewTicker(probeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-attached:
+				if !ok {
+					return
+				}
+				if msg.DeviceAttached() {
+					device := msg.DeviceEntry()
+					devices[device.DeviceID] = device
+					if !send(device, ProbeLifecycleState(device)) {
+						return
+					}
+				}
+				if msg.DeviceDetached() {
+					device, ok := devices[msg.DeviceID]
+					if !ok {
+						continue
+					}
+					if !send(device, LifecycleStateDetached) {
+						return
+					}
+					delete(devices, msg.DeviceID)
+					delete(states, msg.DeviceID)
+				}
+			case <-ticker.C:
+				for _, device := range devices {
+					if !send(device, ProbeLifecycleState(device)) {
+						return
+					}
+				}
+				if !sendRecovery() {
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
@@ -50,6 +50,11 @@ func (lockDownConn LockDownConnection) Send(msg interface{}) error {
 		log.Error("failed lockdown send")
 		return err
 	}
+	if wireLog.enabled.Load() {
+		wireLogger := NewWireLogger(lockDownConn.deviceConnection)
+		wireLogger.Message("send", msg)
+		wireLogger.Frame("send", bytes)
+	}
 	return lockDownConn.deviceConnection.Send(bytes)
 }
 
@@ -61,6 +66,14 @@ func (lockDownConn *LockDownConnection) ReadMessage() ([]byte, error) {
 	if err != nil {
 		return make([]byte, 0), err
 	}
+	if wireLog.enabled.Load() {
+		wireLogger := NewWireLogger(lockDownConn.deviceConnection)
+		if wireLogger.Enabled(WireLogMessages) {
+			parsed, _ := ParsePlist(resp)
+			wireLogger.Message("receive", parsed)
+			wireLogger.Frame("receive", resp)
+		}
+	}
 	return resp, err
 }
 
@@ -0,0 +1,75 @@
+package mcinstall
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// CertificateProfilePrefix starts the identifiers of the profiles installing a root certificate, it is followed
+// by the fingerprint of the certificate
+const CertificateProfilePrefix = "Go-iOS.Certificate."
+
+// CertificatePayload is a com.apple.security.root payload that installs a root certificate. Root certificates
+// installed with a profile on a supervised device are trusted for TLS without enabling full trust in the settings app.
+type CertificatePayload struct {
+	PayloadHeader
+	PayloadCertificateFileName string
+	// PayloadContent is the DER encoded certificate
+	PayloadContent []byte
+}
+
+// NewRootCertificatePayload creates a payload installing the certificate as trusted root
+func NewRootCertificatePayload(cert *x509.Certificate) CertificatePayload {
+	name := CertificateName(cert)
+	return CertificatePayload{
+		PayloadHeader:              NewPayloadHeader("com.apple.security.root", name),
+		PayloadCertificateFileName: name + ".cer",
+		PayloadContent:             cert.Raw,
+	}
+}
+
+// ParseCertificate parses a PEM or DER encoded certificate
+func ParseCertificate(data []byte) (*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("ParseCertificate: expected a CERTIFICATE pem block instead of %s", block.Type)
+		}
+		data = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("ParseCertificate: %w", err)
+	}
+	return cert, nil
+}
+
+// CertificateFingerprint is the lowercase hex SHA-256 fingerprint of the certificate
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// CertificateName is the common name of the certificate, or its subject if it has none
+func CertificateName(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
+
+// CertificateProfile creates the profile that installs the root certificate. Its identifier is the
+// CertificateProfilePrefix followed by the fingerprint, so installing the certificate again replaces the profile.
+func CertificateProfile(cert *x509.Certificate) ConfigurationProfile {
+	return NewConfigurationProfile(CertificateProfilePrefix+CertificateFingerprint(cert), CertificateName(cert), NewRootCertificatePayload(cert))
+}
+
+// IsCertificateProfile reports whether the profile identifier belongs to a profile created by CertificateProfile
+// and returns the fingerprint of its certificate
+func IsCertificateProfile(identifier string) (string, bool) {
+	fingerprint, ok := strings.CutPrefix(identifier, CertificateProfilePrefix)
+	return fingerprint, ok && fingerprint != ""
+}
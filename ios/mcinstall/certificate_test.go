@@ -0,0 +1,56 @@
+package mcinstall
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateProfile(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mitmproxy"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := ParseCertificate(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	require.NoError(t, err)
+	fromDER, err := ParseCertificate(der)
+	require.NoError(t, err)
+	assert.Equal(t, cert.Raw, fromDER.Raw)
+	_, err = ParseCertificate(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1}}))
+	assert.Error(t, err)
+
+	profile := CertificateProfile(cert)
+	fingerprint, ok := IsCertificateProfile(profile.PayloadIdentifier)
+	require.True(t, ok)
+	assert.Equal(t, CertificateFingerprint(cert), fingerprint)
+	assert.Len(t, fingerprint, 64)
+	_, ok = IsCertificateProfile(HTTPProxyProfileIdentifier)
+	assert.False(t, ok)
+
+	parsed, err := ios.ParsePlist(profile.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "mitmproxy", parsed["PayloadDisplayName"])
+	payload := parsed["PayloadContent"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "com.apple.security.root", payload["PayloadType"])
+	assert.Equal(t, "mitmproxy.cer", payload["PayloadCertificateFileName"])
+	assert.Equal(t, der, payload["PayloadContent"])
+}
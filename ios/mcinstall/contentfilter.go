@@ -0,0 +1,68 @@
+package mcinstall
+
+const contentFilterPayloadType = "com.apple.webcontent-filter"
+
+// WebContentFilterPayload is a com.apple.webcontent-filter payload. It either uses the built-in filter of iOS
+// or the content filter network extension of an app. Content filters need a supervised device.
+type WebContentFilterPayload struct {
+	PayloadHeader
+	FilterType string
+	// AutoFilterEnabled limits adult content with the built-in filter
+	AutoFilterEnabled    bool                    `plist:",omitempty"`
+	PermittedURLs        []string                `plist:",omitempty"`
+	BlacklistedURLs      []string                `plist:",omitempty"`
+	WhitelistedBookmarks []ContentFilterBookmark `plist:",omitempty"`
+	UserDefinedName      string                  `plist:",omitempty"`
+	PluginBundleID       string                  `plist:",omitempty"`
+	FilterBrowsers       bool                    `plist:",omitempty"`
+	FilterSockets        bool                    `plist:",omitempty"`
+}
+
+// ContentFilterBookmark is a website that can be visited when only allowed websites are permitted
+type ContentFilterBookmark struct {
+	URL   string
+	Title string
+}
+
+// NewBuiltInContentFilterPayload creates a payload for the built-in content filter that does not filter anything,
+// use its methods to configure it
+func NewBuiltInContentFilterPayload() WebContentFilterPayload {
+	return WebContentFilterPayload{PayloadHeader: NewPayloadHeader(contentFilterPayloadType, "Web Content Filter"), FilterType: "BuiltIn"}
+}
+
+// NewPluginContentFilterPayload creates a payload that enables the content filter network extension of the app pluginBundleID
+// for browsers and network sockets
+func NewPluginContentFilterPayload(name string, pluginBundleID string) WebContentFilterPayload {
+	return WebContentFilterPayload{
+		PayloadHeader:   NewPayloadHeader(contentFilterPayloadType, name),
+		FilterType:      "Plugin",
+		UserDefinedName: name,
+		PluginBundleID:  pluginBundleID,
+		FilterBrowsers:  true,
+		FilterSockets:   true,
+	}
+}
+
+// LimitAdultContent enables the automatic adult content filter, urls can still be permitted or blocked explicitly
+func (f WebContentFilterPayload) LimitAdultContent() WebContentFilterPayload {
+	f.AutoFilterEnabled = true
+	return f
+}
+
+// PermitURLs allows the given urls even if the automatic filter would block them
+func (f WebContentFilterPayload) PermitURLs(urls ...string) WebContentFilterPayload {
+	f.PermittedURLs = append(f.PermittedURLs, urls...)
+	return f
+}
+
+// BlockURLs blocks the given urls
+func (f WebContentFilterPayload) BlockURLs(urls ...string) WebContentFilterPayload {
+	f.BlacklistedURLs = append(f.BlacklistedURLs, urls...)
+	return f
+}
+
+// AllowOnly only allows visiting the given websites
+func (f WebContentFilterPayload) AllowOnly(bookmarks ...ContentFilterBookmark) WebContentFilterPayload {
+	f.WhitelistedBookmarks = append(f.WhitelistedBookmarks, bookmarks...)
+	return f
+}
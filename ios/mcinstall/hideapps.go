@@ -0,0 +1,36 @@
+package mcinstall
+
+import (
+	"fmt"
+
+	"github.com/danielpaulus/go-ios/ios"
+)
+
+// hiddenAppsProfileIdentifier is the identifier of the profile go-ios installs to hide apps
+const hiddenAppsProfileIdentifier = "Go-iOS.HiddenApps.0E4F3A8C-3B5E-4F0B-9A4D-7C2B7A1D5E61"
+
+// HideApps hides the apps with the given bundle ids on a supervised device by installing a restrictions
+// profile that blocks them. The profile replaces the one installed by previous calls, so apps hidden before
+// and not contained in bundleIDs are shown again. Calling it with no bundle ids removes the profile.
+func HideApps(device ios.DeviceEntry, bundleIDs []string, p12file []byte, p12password string) error {
+	if len(bundleIDs) == 0 {
+		err := RemoveRestrictions(device, hiddenAppsProfileIdentifier)
+		if err != nil {
+			return fmt.Errorf("HideApps: %w", err)
+		}
+		return nil
+	}
+	err := InstallProfileSilent(device, p12file, p12password, hiddenAppsProfile(bundleIDs))
+	if err != nil {
+		return fmt.Errorf("HideApps: failed installing profile: %w", err)
+	}
+	return nil
+}
+
+// hiddenAppsProfile creates a configuration profile with a restrictions payload that blocks the given
+// bundle ids. Blocked apps are not shown on the home screen and can't be launched.
+func hiddenAppsProfile(bundleIDs []string) []byte {
+	restrictions := NewRestrictionsPayload().BlockApps(bundleIDs...)
+	restrictions.PayloadDisplayName = "Hidden Apps"
+	return NewConfigurationProfile(hiddenAppsProfileIdentifier, "Go-iOS Hidden Apps", restrictions).Bytes()
+}
@@ -0,0 +1,22 @@
+package mcinstall
+
+import (
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHiddenAppsProfile(t *testing.T) {
+	profile, err := ios.ParsePlist(hiddenAppsProfile([]string{"com.apple.stocks", "com.apple.tips"}))
+	require.NoError(t, err)
+	assert.Equal(t, hiddenAppsProfileIdentifier, profile["PayloadIdentifier"])
+	assert.Equal(t, "Configuration", profile["PayloadType"])
+
+	content := profile["PayloadContent"].([]interface{})
+	require.Len(t, content, 1)
+	restrictions := content[0].(map[string]interface{})
+	assert.Equal(t, "com.apple.applicationaccess", restrictions["PayloadType"])
+	assert.Equal(t, []interface{}{"com.apple.stocks", "com.apple.tips"}, restrictions["blockedAppBundleIDs"])
+}
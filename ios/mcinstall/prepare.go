@@ -8,6 +8,7 @@ import (
 	"github.com/danielpaulus/go-ios/ios/afc"
 	"github.com/danielpaulus/go-ios/ios/mobileactivation"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/pkcs12"
 )
 
 const (
@@ -68,6 +69,15 @@ func GetAllSetupSkipOptions() []string {
 	return skipAllSetup
 }
 
+// SupervisionCertificate returns the DER encoded certificate of a supervision identity p12 file, as Prepare expects it
+func SupervisionCertificate(p12bytes []byte, p12Password string) ([]byte, error) {
+	_, cert, err := pkcs12.Decode(p12bytes, p12Password)
+	if err != nil {
+		return nil, fmt.Errorf("SupervisionCertificate: %w", err)
+	}
+	return cert.Raw, nil
+}
+
 // Prepare prepares an activated device and supervises it if desired. skip is the list of setup options to skip, use GetAllSetupSkipOptions()
 // to get a list of all available options. certBytes is the DER encoded supervision certificate. If it is nil then the device won't be supervised.
 // ios.CreateDERFormattedSupervisionCert() provides an example how to generate these certificates. Orgname can be any string, it will show up as the
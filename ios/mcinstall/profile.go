@@ -0,0 +1,101 @@
+package mcinstall
+
+import (
+	"fmt"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/google/uuid"
+)
+
+// PayloadHeader contains the keys every payload of a configuration profile needs
+type PayloadHeader struct {
+	PayloadDisplayName string
+	PayloadIdentifier  string
+	PayloadType        string
+	PayloadUUID        string
+	PayloadVersion     int
+}
+
+// NewPayloadHeader creates the header of a payload with the given type and a random uuid
+func NewPayloadHeader(payloadType string, displayName string) PayloadHeader {
+	payloadUUID := uuid.New().String()
+	return PayloadHeader{
+		PayloadDisplayName: displayName,
+		PayloadIdentifier:  payloadType + "." + payloadUUID,
+		PayloadType:        payloadType,
+		PayloadUUID:        payloadUUID,
+		PayloadVersion:     1,
+	}
+}
+
+// ConfigurationProfile is a mobileconfig profile containing payloads. Installing a profile replaces
+// the installed profile with the same PayloadIdentifier.
+type ConfigurationProfile struct {
+	PayloadContent           []interface{}
+	PayloadDisplayName       string
+	PayloadIdentifier        string
+	PayloadRemovalDisallowed bool
+	PayloadType              string
+	PayloadUUID              string
+	PayloadVersion           int
+}
+
+// NewConfigurationProfile creates a profile with the given identifier containing the payloads.
+// Payloads have to be passed as values, the plist encoder does not marshal pointers in interfaces.
+func NewConfigurationProfile(identifier string, displayName string, payloads ...interface{}) ConfigurationProfile {
+	return ConfigurationProfile{
+		PayloadContent:     payloads,
+		PayloadDisplayName: displayName,
+		PayloadIdentifier:  identifier,
+		PayloadType:        "Configuration",
+		PayloadUUID:        uuid.New().String(),
+		PayloadVersion:     1,
+	}
+}
+
+// Bytes returns the profile as xml plist, ready to be installed with AddProfile or InstallProfileSilent
+func (p ConfigurationProfile) Bytes() []byte {
+	return ios.ToPlistBytes(p)
+}
+
+// RemoveProfileFromDevice removes the profile with the given identifier from the device
+func RemoveProfileFromDevice(device ios.DeviceEntry, identifier string) error {
+	profileService, err := New(device)
+	if err != nil {
+		return err
+	}
+	defer profileService.Close()
+	err = profileService.RemoveProfile(identifier)
+	if err != nil {
+		return fmt.Errorf("RemoveProfileFromDevice: failed removing profile %s: %w", identifier, err)
+	}
+	return nil
+}
+
+// ListProfiles lists the profiles installed on the device
+func ListProfiles(device ios.DeviceEntry) ([]ProfileInfo, error) {
+	profileService, err := New(device)
+	if err != nil {
+		return nil, err
+	}
+	defer profileService.Close()
+	profiles, err := profileService.HandleList()
+	if err != nil {
+		return nil, fmt.Errorf("ListProfiles: %w", err)
+	}
+	return profiles, nil
+}
+
+// InstallProfile installs a configuration profile without supervision, the user has to confirm it in the settings app
+func InstallProfile(device ios.DeviceEntry, profileBytes []byte) error {
+	profileService, err := New(device)
+	if err != nil {
+		return err
+	}
+	defer profileService.Close()
+	err = profileService.AddProfile(profileBytes)
+	if err != nil {
+		return fmt.Errorf("InstallProfile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package mcinstall
+
+import (
+	"fmt"
+
+	"github.com/danielpaulus/go-ios/ios"
+)
+
+const restrictionsPayloadType = "com.apple.applicationaccess"
+
+// RestrictionsPayload is a com.apple.applicationaccess payload. Only restrictions that are set are
+// contained in the payload, everything else keeps the device default. Most restrictions need a
+// supervised device.
+type RestrictionsPayload struct {
+	PayloadHeader
+	AllowCamera          *bool    `plist:"allowCamera,omitempty"`
+	AllowScreenShot      *bool    `plist:"allowScreenShot,omitempty"`
+	AllowAppInstallation *bool    `plist:"allowAppInstallation,omitempty"`
+	AllowAppRemoval      *bool    `plist:"allowAppRemoval,omitempty"`
+	ForceWiFiPowerOn     *bool    `plist:"forceWiFiPowerOn,omitempty"`
+	BlockedAppBundleIDs  []string `plist:"blockedAppBundleIDs,omitempty"`
+	AllowExplicitContent *bool    `plist:"allowExplicitContent,omitempty"`
+	RatingRegion         string   `plist:"ratingRegion,omitempty"`
+	RatingApps           *int     `plist:"ratingApps,omitempty"`
+}
+
+// App ratings that can be used with LimitAppRating
+const (
+	AppRatingNone  = 0
+	AppRating4     = 100
+	AppRating9     = 200
+	AppRating12    = 300
+	AppRating17    = 600
+	AppRatingAllow = 1000
+)
+
+// NewRestrictionsPayload creates an empty restrictions payload, use its methods to add restrictions
+func NewRestrictionsPayload() RestrictionsPayload {
+	return RestrictionsPayload{PayloadHeader: NewPayloadHeader(restrictionsPayloadType, "Restrictions")}
+}
+
+// DisableCamera disables the camera and removes the camera app from the home screen
+func (r RestrictionsPayload) DisableCamera() RestrictionsPayload {
+	r.AllowCamera = boolPtr(false)
+	return r
+}
+
+// DisableScreenShots prevents taking screenshots and screen recordings on the device
+func (r RestrictionsPayload) DisableScreenShots() RestrictionsPayload {
+	r.AllowScreenShot = boolPtr(false)
+	return r
+}
+
+// DisallowAppInstallation hides the App Store and prevents users from installing apps
+func (r RestrictionsPayload) DisallowAppInstallation() RestrictionsPayload {
+	r.AllowAppInstallation = boolPtr(false)
+	return r
+}
+
+// DisallowAppRemoval prevents users from deleting apps
+func (r RestrictionsPayload) DisallowAppRemoval() RestrictionsPayload {
+	r.AllowAppRemoval = boolPtr(false)
+	return r
+}
+
+// ForceWiFiOn prevents turning off Wi-Fi, also in airplane mode
+func (r RestrictionsPayload) ForceWiFiOn() RestrictionsPayload {
+	r.ForceWiFiPowerOn = boolPtr(true)
+	return r
+}
+
+// BlockApps hides the apps with the given bundle ids and prevents launching them
+func (r RestrictionsPayload) BlockApps(bundleIDs ...string) RestrictionsPayload {
+	r.BlockedAppBundleIDs = append(r.BlockedAppBundleIDs, bundleIDs...)
+	return r
+}
+
+// DisallowExplicitContent hides explicit music, podcasts and books
+func (r RestrictionsPayload) DisallowExplicitContent() RestrictionsPayload {
+	r.AllowExplicitContent = boolPtr(false)
+	return r
+}
+
+// LimitAppRating only allows apps with at most the given age rating, like AppRating12, using the ratings of region
+// (f.ex. "us" or "de"). Like Screen Time, apps with a higher rating are hidden.
+func (r RestrictionsPayload) LimitAppRating(region string, rating int) RestrictionsPayload {
+	r.RatingRegion = region
+	r.RatingApps = &rating
+	return r
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// InstallRestrictions installs a profile with the given identifier containing the restrictions on a supervised device.
+// A profile installed before with the same identifier is replaced.
+func InstallRestrictions(device ios.DeviceEntry, identifier string, restrictions RestrictionsPayload, p12file []byte, p12password string) error {
+	profile := NewConfigurationProfile(identifier, "Go-iOS Restrictions", restrictions)
+	err := InstallProfileSilent(device, p12file, p12password, profile.Bytes())
+	if err != nil {
+		return fmt.Errorf("InstallRestrictions: failed installing profile %s: %w", identifier, err)
+	}
+	return nil
+}
+
+// RemoveRestrictions removes the restrictions profile with the given identifier
+func RemoveRestrictions(device ios.DeviceEntry, identifier string) error {
+	err := RemoveProfileFromDevice(device, identifier)
+	if err != nil {
+		return fmt.Errorf("RemoveRestrictions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package mcinstall
+
+import (
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestrictionsProfile(t *testing.T) {
+	restrictions := NewRestrictionsPayload().DisableCamera().DisallowAppRemoval().ForceWiFiOn()
+	profile, err := ios.ParsePlist(NewConfigurationProfile("test.restrictions", "Test", restrictions).Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "test.restrictions", profile["PayloadIdentifier"])
+
+	content := profile["PayloadContent"].([]interface{})
+	require.Len(t, content, 1)
+	payload := content[0].(map[string]interface{})
+	assert.Equal(t, "com.apple.applicationaccess", payload["PayloadType"])
+	assert.Equal(t, restrictions.PayloadUUID, payload["PayloadUUID"])
+	assert.Equal(t, false, payload["allowCamera"])
+	assert.Equal(t, false, payload["allowAppRemoval"])
+	assert.Equal(t, true, payload["forceWiFiPowerOn"])
+	assert.NotContains(t, payload, "allowScreenShot")
+	assert.NotContains(t, payload, "blockedAppBundleIDs")
+}
+
+func TestContentFilterProfile(t *testing.T) {
+	filter := NewBuiltInContentFilterPayload().LimitAdultContent().BlockURLs("https://blocked.example.com")
+	ratings := NewRestrictionsPayload().LimitAppRating("us", AppRating12).DisallowExplicitContent()
+	profile, err := ios.ParsePlist(NewConfigurationProfile("test.filter", "Test", filter, ratings).Bytes())
+	require.NoError(t, err)
+
+	content := profile["PayloadContent"].([]interface{})
+	require.Len(t, content, 2)
+	filterPayload := content[0].(map[string]interface{})
+	assert.Equal(t, "com.apple.webcontent-filter", filterPayload["PayloadType"])
+	assert.Equal(t, "BuiltIn", filterPayload["FilterType"])
+	assert.Equal(t, true, filterPayload["AutoFilterEnabled"])
+	assert.Equal(t, []interface{}{"https://blocked.example.com"}, filterPayload["BlacklistedURLs"])
+	assert.NotContains(t, filterPayload, "PluginBundleID")
+
+	ratingsPayload := content[1].(map[string]interface{})
+	assert.Equal(t, "us", ratingsPayload["ratingRegion"])
+	assert.Equal(t, uint64(AppRating12), ratingsPayload["ratingApps"])
+	assert.Equal(t, false, ratingsPayload["allowExplicitContent"])
+}
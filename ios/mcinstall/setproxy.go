@@ -6,16 +6,69 @@ import (
 	"github.com/danielpaulus/go-ios/ios"
 )
 
+// HTTPProxyProfileIdentifier is the identifier of the global HTTP proxy profile installed by go-ios
+const HTTPProxyProfileIdentifier = "Go-iOS.CD15976B-E205-4213-9B8E-FDAA5FAB1C22"
+
+const httpProxyPayloadType = "com.apple.proxy.http.global"
+
 // RemoveProxy unsets the global HTTP proxy config again by deleting the global config profile
-// installed by go-ios using the identifier
-// I hardcoded 'Go-iOS.CD15976B-E205-4213-9B8E-FDAA5FAB1C22'
+// installed by go-ios using the identifier HTTPProxyProfileIdentifier
 func RemoveProxy(device ios.DeviceEntry) error {
 	profileService, err := New(device)
 	if err != nil {
 		return err
 	}
 	defer profileService.Close()
-	return profileService.RemoveProfile("Go-iOS.CD15976B-E205-4213-9B8E-FDAA5FAB1C22")
+	return profileService.RemoveProfile(HTTPProxyProfileIdentifier)
+}
+
+// GlobalHTTPProxyPayload is a com.apple.proxy.http.global payload. The proxy is either configured manually with
+// server and port or automatically with a PAC file. Global proxies need a supervised device.
+type GlobalHTTPProxyPayload struct {
+	PayloadHeader
+	ProxyType       string
+	ProxyServer     string `plist:",omitempty"`
+	ProxyServerPort int    `plist:",omitempty"`
+	ProxyUsername   string `plist:",omitempty"`
+	ProxyPassword   string `plist:",omitempty"`
+	ProxyPACURL     string `plist:",omitempty"`
+	// ProxyPACFallbackAllowed connects directly if the PAC file can't be loaded
+	ProxyPACFallbackAllowed bool `plist:",omitempty"`
+	// ProxyCaptiveLoginAllowed bypasses the proxy to log in to captive networks
+	ProxyCaptiveLoginAllowed bool
+}
+
+// NewManualHTTPProxyPayload creates a payload that sends all http traffic through the proxy at host and port
+func NewManualHTTPProxyPayload(host string, port int) GlobalHTTPProxyPayload {
+	return GlobalHTTPProxyPayload{
+		PayloadHeader:            NewPayloadHeader(httpProxyPayloadType, "Global HTTP Proxy"),
+		ProxyType:                "Manual",
+		ProxyServer:              host,
+		ProxyServerPort:          port,
+		ProxyCaptiveLoginAllowed: true,
+	}
+}
+
+// NewAutoHTTPProxyPayload creates a payload that picks the proxy with the PAC file at pacURL
+func NewAutoHTTPProxyPayload(pacURL string) GlobalHTTPProxyPayload {
+	return GlobalHTTPProxyPayload{
+		PayloadHeader:            NewPayloadHeader(httpProxyPayloadType, "Global HTTP Proxy"),
+		ProxyType:                "Auto",
+		ProxyPACURL:              pacURL,
+		ProxyCaptiveLoginAllowed: true,
+	}
+}
+
+// WithCredentials sets the user and password the device authenticates with at a manual proxy
+func (p GlobalHTTPProxyPayload) WithCredentials(user string, password string) GlobalHTTPProxyPayload {
+	p.ProxyUsername, p.ProxyPassword = user, password
+	return p
+}
+
+// AllowPACFallback lets the device connect directly if the PAC file can't be loaded
+func (p GlobalHTTPProxyPayload) AllowPACFallback() GlobalHTTPProxyPayload {
+	p.ProxyPACFallbackAllowed = true
+	return p
 }
 
 // SetHttpProxy generates the config profile "Go-iOS.CD15976B-E205-4213-9B8E-FDAA5FAB1C22" that will set a global
@@ -0,0 +1,32 @@
+package mcinstall
+
+import (
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobalHTTPProxyProfile(t *testing.T) {
+	manual := NewManualHTTPProxyPayload("10.0.0.2", 8080).WithCredentials("lab", "secret")
+	auto := NewAutoHTTPProxyPayload("http://10.0.0.2/proxy.pac").AllowPACFallback()
+	profile, err := ios.ParsePlist(NewConfigurationProfile(HTTPProxyProfileIdentifier, "Proxy", manual, auto).Bytes())
+	require.NoError(t, err)
+
+	content := profile["PayloadContent"].([]interface{})
+	manualPayload := content[0].(map[string]interface{})
+	assert.Equal(t, "com.apple.proxy.http.global", manualPayload["PayloadType"])
+	assert.Equal(t, "Manual", manualPayload["ProxyType"])
+	assert.Equal(t, "10.0.0.2", manualPayload["ProxyServer"])
+	assert.EqualValues(t, 8080, manualPayload["ProxyServerPort"])
+	assert.Equal(t, "lab", manualPayload["ProxyUsername"])
+	assert.Equal(t, true, manualPayload["ProxyCaptiveLoginAllowed"])
+	assert.NotContains(t, manualPayload, "ProxyPACURL")
+
+	autoPayload := content[1].(map[string]interface{})
+	assert.Equal(t, "Auto", autoPayload["ProxyType"])
+	assert.Equal(t, "http://10.0.0.2/proxy.pac", autoPayload["ProxyPACURL"])
+	assert.Equal(t, true, autoPayload["ProxyPACFallbackAllowed"])
+	assert.NotContains(t, autoPayload, "ProxyServer")
+}
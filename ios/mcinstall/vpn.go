@@ -0,0 +1,134 @@
+package mcinstall
+
+import (
+	"fmt"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/google/uuid"
+)
+
+// VPNConfig is the VPN dictionary of a VPN payload
+type VPNConfig struct {
+	RemoteAddress            string
+	AuthenticationMethod     string
+	ProviderBundleIdentifier string `plist:",omitempty"`
+}
+
+// IKEv2Config is the IKEv2 dictionary of a VPN payload
+type IKEv2Config struct {
+	RemoteAddress        string
+	RemoteIdentifier     string
+	LocalIdentifier      string `plist:",omitempty"`
+	AuthenticationMethod string
+	SharedSecret         string `plist:",omitempty"`
+}
+
+// PerAppVPNPayload is a com.apple.vpn.managed.applayer payload. It creates a VPN that only carries the
+// traffic of the apps that are mapped to its VPNUUID with an AppMappingPayload.
+type PerAppVPNPayload struct {
+	PayloadHeader
+	UserDefinedName         string
+	VPNType                 string
+	VPNSubType              string `plist:",omitempty"`
+	VPNUUID                 string
+	OnDemandMatchAppEnabled bool
+	VPN                     *VPNConfig             `plist:",omitempty"`
+	IKEv2                   *IKEv2Config           `plist:",omitempty"`
+	VendorConfig            map[string]interface{} `plist:",omitempty"`
+}
+
+// NewIKEv2PerAppVPNPayload creates a per-app VPN to an IKEv2 gateway authenticating with a shared secret
+func NewIKEv2PerAppVPNPayload(name string, remoteAddress string, remoteIdentifier string, sharedSecret string) PerAppVPNPayload {
+	return PerAppVPNPayload{
+		PayloadHeader:           NewPayloadHeader("com.apple.vpn.managed.applayer", name),
+		UserDefinedName:         name,
+		VPNType:                 "IKEv2",
+		VPNUUID:                 uuid.New().String(),
+		OnDemandMatchAppEnabled: true,
+		IKEv2: &IKEv2Config{
+			RemoteAddress:        remoteAddress,
+			RemoteIdentifier:     remoteIdentifier,
+			LocalIdentifier:      name,
+			AuthenticationMethod: "SharedSecret",
+			SharedSecret:         sharedSecret,
+		},
+	}
+}
+
+// NewProviderPerAppVPNPayload creates a per-app VPN that is provided by the network extension of an app,
+// vendorConfig is passed to the provider as is
+func NewProviderPerAppVPNPayload(name string, providerBundleID string, remoteAddress string, vendorConfig map[string]interface{}) PerAppVPNPayload {
+	return PerAppVPNPayload{
+		PayloadHeader:           NewPayloadHeader("com.apple.vpn.managed.applayer", name),
+		UserDefinedName:         name,
+		VPNType:                 "VPN",
+		VPNSubType:              providerBundleID,
+		VPNUUID:                 uuid.New().String(),
+		OnDemandMatchAppEnabled: true,
+		VPN: &VPNConfig{
+			RemoteAddress:            remoteAddress,
+			AuthenticationMethod:     "Password",
+			ProviderBundleIdentifier: providerBundleID,
+		},
+		VendorConfig: vendorConfig,
+	}
+}
+
+// AppLayerVPNMapping binds the app with the bundle id Identifier to the per-app VPN with VPNUUID
+type AppLayerVPNMapping struct {
+	Identifier string
+	VPNUUID    string
+}
+
+// AppMappingPayload is a com.apple.vpn.managed.appmapping payload
+type AppMappingPayload struct {
+	PayloadHeader
+	AppLayerVPNMapping []AppLayerVPNMapping
+}
+
+// NewAppMappingPayload routes the traffic of the apps with the given bundle ids through the per-app VPN
+func NewAppMappingPayload(vpn PerAppVPNPayload, bundleIDs ...string) AppMappingPayload {
+	mapping := AppMappingPayload{PayloadHeader: NewPayloadHeader("com.apple.vpn.managed.appmapping", "App to Per-App VPN Mapping")}
+	for _, bundleID := range bundleIDs {
+		mapping.AppLayerVPNMapping = append(mapping.AppLayerVPNMapping, AppLayerVPNMapping{Identifier: bundleID, VPNUUID: vpn.VPNUUID})
+	}
+	return mapping
+}
+
+// DNSProxyPayload is a com.apple.dnsProxy.managed payload, it sends all DNS traffic through the DNS proxy
+// network extension of an app
+type DNSProxyPayload struct {
+	PayloadHeader
+	AppBundleIdentifier      string
+	ProviderBundleIdentifier string
+	ProviderConfiguration    map[string]interface{} `plist:",omitempty"`
+}
+
+// NewDNSProxyPayload creates a DNS proxy payload for the app with appBundleID and its extension providerBundleID
+func NewDNSProxyPayload(appBundleID string, providerBundleID string, providerConfiguration map[string]interface{}) DNSProxyPayload {
+	return DNSProxyPayload{
+		PayloadHeader:            NewPayloadHeader("com.apple.dnsProxy.managed", "DNS Proxy"),
+		AppBundleIdentifier:      appBundleID,
+		ProviderBundleIdentifier: providerBundleID,
+		ProviderConfiguration:    providerConfiguration,
+	}
+}
+
+// ProfileStatus looks up the installed profile with the given identifier. It returns false if the profile is not installed.
+func ProfileStatus(device ios.DeviceEntry, identifier string) (ProfileInfo, bool, error) {
+	profileService, err := New(device)
+	if err != nil {
+		return ProfileInfo{}, false, err
+	}
+	defer profileService.Close()
+	profiles, err := profileService.HandleList()
+	if err != nil {
+		return ProfileInfo{}, false, fmt.Errorf("ProfileStatus: failed listing profiles: %w", err)
+	}
+	for _, profile := range profiles {
+		if profile.Identifier == identifier {
+			return profile, true, nil
+		}
+	}
+	return ProfileInfo{}, false, nil
+}
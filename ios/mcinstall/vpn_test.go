@@ -0,0 +1,31 @@
+package mcinstall
+
+import (
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerAppVPNProfile(t *testing.T) {
+	vpn := NewIKEv2PerAppVPNPayload("lab", "vpn.lab.local", "gateway", "secret")
+	mapping := NewAppMappingPayload(vpn, "com.example.app")
+	profile, err := ios.ParsePlist(NewConfigurationProfile("test.vpn", "VPN", vpn, mapping).Bytes())
+	require.NoError(t, err)
+
+	content := profile["PayloadContent"].([]interface{})
+	require.Len(t, content, 2)
+	vpnPayload := content[0].(map[string]interface{})
+	assert.Equal(t, "com.apple.vpn.managed.applayer", vpnPayload["PayloadType"])
+	assert.Equal(t, "IKEv2", vpnPayload["VPNType"])
+	assert.NotContains(t, vpnPayload, "VPN")
+	assert.NotContains(t, vpnPayload, "VPNSubType")
+	ikev2 := vpnPayload["IKEv2"].(map[string]interface{})
+	assert.Equal(t, "vpn.lab.local", ikev2["RemoteAddress"])
+	assert.Equal(t, "SharedSecret", ikev2["AuthenticationMethod"])
+
+	mappingPayload := content[1].(map[string]interface{})
+	assert.Equal(t, "com.apple.vpn.managed.appmapping", mappingPayload["PayloadType"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"Identifier": "com.example.app", "VPNUUID": vpn.VPNUUID}}, mappingPayload["AppLayerVPNMapping"])
+}
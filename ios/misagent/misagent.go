@@ -2,8 +2,12 @@ package misagent
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/danielpaulus/go-ios/ios"
+	"go.mozilla.org/pkcs7"
+	"howett.net/plist"
 )
 
 const serviceName string = "com.apple.misagent"
@@ -27,6 +31,73 @@ func New(device ios.DeviceEntry) (*Connection, error) {
 }
 
 func (c *Connection) CopyAll() error {
+	_, err := c.copyAll()
+	return err
+}
+
+// ProvisioningProfile contains the relevant fields of a provisioning profile installed on a device
+type ProvisioningProfile struct {
+	Name                 string
+	UUID                 string
+	AppIDName            string
+	TeamName             string
+	TeamIdentifier       []string
+	CreationDate         time.Time
+	ExpirationDate       time.Time
+	Entitlements         map[string]interface{}
+	ProvisionedDevices   []string
+	ProvisionsAllDevices bool
+}
+
+// ApplicationIdentifier returns the application-identifier entitlement of the profile, f.ex. "TEAMID.com.example.*"
+func (p ProvisioningProfile) ApplicationIdentifier() string {
+	id, _ := p.Entitlements["application-identifier"].(string)
+	return id
+}
+
+// Matches returns true if the profile can be used for the given application identifier ("TEAMID.bundleid"),
+// taking wildcard profiles into account.
+func (p ProvisioningProfile) Matches(applicationIdentifier string) bool {
+	profileID := p.ApplicationIdentifier()
+	if prefix, ok := strings.CutSuffix(profileID, "*"); ok {
+		return strings.HasPrefix(applicationIdentifier, prefix)
+	}
+	return profileID == applicationIdentifier
+}
+
+// ProvisioningProfiles returns all provisioning profiles installed on the device
+func (c *Connection) ProvisioningProfiles() ([]ProvisioningProfile, error) {
+	payloads, err := c.copyAll()
+	if err != nil {
+		return nil, err
+	}
+	profiles := make([]ProvisioningProfile, 0, len(payloads))
+	for _, payload := range payloads {
+		profile, err := ParseProvisioningProfile(payload)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// ParseProvisioningProfile parses the CMS signed plist of a provisioning profile, like an embedded.mobileprovision file.
+// The signature is not verified.
+func ParseProvisioningProfile(data []byte) (ProvisioningProfile, error) {
+	signed, err := pkcs7.Parse(data)
+	if err != nil {
+		return ProvisioningProfile{}, fmt.Errorf("ParseProvisioningProfile: %w", err)
+	}
+	var profile ProvisioningProfile
+	_, err = plist.Unmarshal(signed.Content, &profile)
+	if err != nil {
+		return ProvisioningProfile{}, fmt.Errorf("ParseProvisioningProfile: %w", err)
+	}
+	return profile, nil
+}
+
+func (c *Connection) copyAll() ([][]byte, error) {
 	msg := map[string]interface{}{
 		"MessageType": "CopyAll",
 		"ProfileType": "Provisioning",
@@ -34,31 +105,35 @@ func (c *Connection) CopyAll() error {
 	reader := c.deviceConn.Reader()
 	requestBytes, err := c.plistCodec.Encode(msg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = c.deviceConn.Send(requestBytes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	responseBytes, err := c.plistCodec.Decode(reader)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	resp, err := ios.ParsePlist(responseBytes)
-	if err != nil {
-		return err
+	var resp struct {
+		Status  *int
+		Payload [][]byte
 	}
-	t, ok := resp["Status"]
-	if !ok {
-		return fmt.Errorf("misagent invalid response %v", resp)
+	_, err = plist.Unmarshal(responseBytes, &resp)
+	if err != nil {
+		return nil, err
 	}
-	i, ok := t.(int)
-	if !ok {
-		return fmt.Errorf("misagent invalid status in response %v", resp)
+	if resp.Status == nil {
+		return nil, fmt.Errorf("misagent invalid response %x", responseBytes)
 	}
-	if i == 0 {
-		return nil
+	if *resp.Status != 0 {
+		return nil, fmt.Errorf("misagent returned error code %d", *resp.Status)
 	}
-	return fmt.Errorf("misagent returned error code %d in response %v", i, resp)
+	return resp.Payload, nil
+}
+
+// Close closes the connection to misagent
+func (c *Connection) Close() error {
+	return c.deviceConn.Close()
 }
@@ -0,0 +1,55 @@
+package misagent_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/misagent"
+	"github.com/stretchr/testify/assert"
+	"go.mozilla.org/pkcs7"
+)
+
+const profilePlist = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict>
+<key>Name</key><string>Test Profile</string>
+<key>UUID</key><string>8a0d4e2c-5f0f-4f3e-9d4c-3f2a8f0b1c2d</string>
+<key>TeamIdentifier</key><array><string>ABCDE12345</string></array>
+<key>ExpirationDate</key><date>2030-01-02T03:04:05Z</date>
+<key>Entitlements</key><dict>
+<key>application-identifier</key><string>ABCDE12345.com.example.*</string>
+</dict>
+</dict></plist>`
+
+func signedProfile(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test"}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	sd, err := pkcs7.NewSignedData([]byte(profilePlist))
+	assert.NoError(t, err)
+	assert.NoError(t, sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{}))
+	signed, err := sd.Finish()
+	assert.NoError(t, err)
+	return signed
+}
+
+func TestParseProvisioningProfile(t *testing.T) {
+	profile, err := misagent.ParseProvisioningProfile(signedProfile(t))
+	assert.NoError(t, err)
+	assert.Equal(t, "Test Profile", profile.Name)
+	assert.Equal(t, []string{"ABCDE12345"}, profile.TeamIdentifier)
+	assert.Equal(t, time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), profile.ExpirationDate.UTC())
+	assert.True(t, profile.Matches("ABCDE12345.com.example.app"))
+	assert.False(t, profile.Matches("OTHER12345.com.example.app"))
+
+	_, err = misagent.ParseProvisioningProfile([]byte("not a profile"))
+	assert.Error(t, err)
+}
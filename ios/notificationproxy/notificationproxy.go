@@ -143,3 +143,23 @@ type notificationProxyRequest struct {
 	Command string
 	Name    string `plist:"Name,omitempty"`
 }
+
+// Post posts a darwin notification on the device
+func (c *Connection) Post(notification string) error {
+	request := notificationProxyRequest{Command: "PostNotification", Name: notification}
+	bytes, err := c.plistCodec.Encode(request)
+	if err != nil {
+		return err
+	}
+	return c.deviceConn.Send(bytes)
+}
+
+// PostNotification connects to notification_proxy, posts the notification and disconnects
+func PostNotification(device ios.DeviceEntry, notification string) error {
+	c, err := New(device)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Post(notification)
+}
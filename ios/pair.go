@@ -41,6 +41,7 @@ func PairSupervised(device DeviceEntry, p12bytes []byte, p12Password string) err
 	if err != nil {
 		return err
 	}
+	defer SetOperationDeadline(lockdown.deviceConnection, OperationPairing)()
 	publicKey, err := lockdown.GetValue("DevicePublicKey")
 	if err != nil {
 		return err
@@ -121,6 +122,9 @@ func extractPairingChallenge(resp []byte) ([]byte, error) {
 	if !ok {
 		return []byte{}, fmt.Errorf("error should have been a string: %+v", respPlist)
 	}
+	if errormsg == lockdownErrorPasswordProtected {
+		return []byte{}, ErrDeviceLocked
+	}
 	if "MCChallengeRequired" != errormsg {
 		return []byte{},
 			fmt.Errorf("received wrong error message '%s' error message should have been 'McChallengeRequired' : %+v", errormsg, respPlist)
@@ -165,6 +169,7 @@ func Pair(device DeviceEntry) error {
 	if err != nil {
 		return err
 	}
+	defer SetOperationDeadline(lockdown.deviceConnection, OperationPairing)()
 	publicKey, err := lockdown.GetValue("DevicePublicKey")
 	if err != nil {
 		return err
@@ -194,7 +199,7 @@ func Pair(device DeviceEntry) error {
 		return fmt.Errorf("Please accept the PairingDialog on the device and run pairing again!")
 	}
 	if response.Error != "" {
-		return fmt.Errorf("Lockdown error: %s", response.Error)
+		return lockdownError(response.Error)
 	}
 	usbmuxConn, err = NewUsbMuxConnectionSimple()
 	defer usbmuxConn.Close()
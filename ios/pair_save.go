@@ -1,5 +1,7 @@
 package ios
 
+import "fmt"
+
 type SavePair struct {
 	BundleID            string
 	ClientVersionString string
@@ -72,3 +74,22 @@ func (muxConn *UsbMuxConnection) savePair(udid string, DeviceCertificate []byte,
 	muxresponse := MuxResponsefromBytes(resp.Payload)
 	return muxresponse.IsSuccessFull(), nil
 }
+
+// SavePairRecord stores the PairRecord for the given udid in usbmuxd. This allows using a pair record
+// that was created on another host, f.ex. for devices that are only reachable over the network.
+func SavePairRecord(udid string, record PairRecord) error {
+	muxConnection, err := NewUsbMuxConnectionSimple()
+	if err != nil {
+		return fmt.Errorf("SavePairRecord: could not connect to usbmuxd: %w", err)
+	}
+	defer muxConnection.Close()
+	success, err := muxConnection.savePair(udid, record.DeviceCertificate, record.HostPrivateKey, record.HostCertificate,
+		record.RootPrivateKey, record.RootCertificate, record.EscrowBag, record.WiFiMACAddress, record.HostID, record.SystemBUID)
+	if err != nil {
+		return fmt.Errorf("SavePairRecord: %w", err)
+	}
+	if !success {
+		return fmt.Errorf("SavePairRecord: usbmuxd rejected the pair record for %s", udid)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package ios
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPairingInvalid is returned if the host has no pair record for a device or the device does not accept it
+// anymore, f.ex. because it was erased, the user reset the trust settings or an os update invalidated it.
+// The device has to be paired again.
+var ErrPairingInvalid = errors.New("the device does not accept the pair record of the host, pair again")
+
+// lockdownErrorInvalidHostID is the error StartSession responds with if the device does not know the host id
+const lockdownErrorInvalidHostID = "InvalidHostID"
+
+// ValidatePairing checks that the pair record of the host is still accepted by the device by starting a lockdown
+// session. It returns when the certificates of the pair record expire.
+func ValidatePairing(device DeviceEntry) (time.Time, error) {
+	record, err := ReadPairRecord(device.Properties.SerialNumber)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ValidatePairing: %w: %v", ErrPairingInvalid, err)
+	}
+	lockdown, err := ConnectLockdownWithSession(device)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ValidatePairing: %w", err)
+	}
+	lockdown.Close()
+	expires, err := PairRecordExpiry(record)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ValidatePairing: %w", err)
+	}
+	return expires, nil
+}
+
+// PairRecordExpiry returns the earliest expiry of the certificates in a pair record
+func PairRecordExpiry(record PairRecord) (time.Time, error) {
+	var expires time.Time
+	for _, certificate := range [][]byte{record.RootCertificate, record.HostCertificate, record.DeviceCertificate} {
+		if len(certificate) == 0 {
+			continue
+		}
+		block, _ := pem.Decode(certificate)
+		if block == nil {
+			return time.Time{}, fmt.Errorf("PairRecordExpiry: pair record contains a certificate that is not PEM encoded")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("PairRecordExpiry: %w", err)
+		}
+		if expires.IsZero() || cert.NotAfter.Before(expires) {
+			expires = cert.NotAfter
+		}
+	}
+	if expires.IsZero() {
+		return time.Time{}, fmt.Errorf("PairRecordExpiry: pair record contains no certificates")
+	}
+	return expires, nil
+}
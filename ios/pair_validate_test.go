@@ -0,0 +1,40 @@
+package ios
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCertificate(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now().Add(-time.Hour), NotAfter: notAfter}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return certBytesToPEM(der)
+}
+
+func TestPairRecordExpiry(t *testing.T) {
+	soon := time.Now().Add(24 * time.Hour).Truncate(time.Second).UTC()
+	record := PairRecord{
+		RootCertificate:   testCertificate(t, soon.AddDate(10, 0, 0)),
+		HostCertificate:   testCertificate(t, soon),
+		DeviceCertificate: testCertificate(t, soon.AddDate(1, 0, 0)),
+	}
+	expires, err := PairRecordExpiry(record)
+	require.NoError(t, err)
+	assert.Equal(t, soon, expires)
+
+	_, err = PairRecordExpiry(PairRecord{})
+	assert.Error(t, err)
+	_, err = PairRecordExpiry(PairRecord{HostCertificate: []byte("garbage")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+package ios
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDeviceLocked is returned if lockdown refused a request with a PasswordProtected error because the device
+// is locked right now. Unlock the device and try again. Use errors.Is to check for it.
+var ErrDeviceLocked = errors.New("device locked - unlock required")
+
+// lockdownErrorPasswordProtected is the error lockdown responds with if a request needs an unlocked device
+const lockdownErrorPasswordProtected = "PasswordProtected"
+
+// PasscodeState contains information about the passcode of a device
+type PasscodeState struct {
+	// PasswordProtected is true if a passcode is set on the device, it does not tell if the device is locked right now
+	PasswordProtected bool `json:"passwordProtected"`
+}
+
+// GetPasscodeState checks if a passcode is set on the device. It does not need a pair record,
+// so it can be used before pairing to warn users that they might have to unlock the device.
+func GetPasscodeState(device DeviceEntry) (PasscodeState, error) {
+	muxConn, err := NewUsbMuxConnectionSimple()
+	if err != nil {
+		return PasscodeState{}, fmt.Errorf("GetPasscodeState: could not connect to usbmuxd: %w", err)
+	}
+	lockdown, err := muxConn.ConnectLockdown(device.DeviceID)
+	if err != nil {
+		return PasscodeState{}, fmt.Errorf("GetPasscodeState: %w", err)
+	}
+	defer lockdown.Close()
+	value, err := lockdown.GetValue("PasswordProtected")
+	if err != nil {
+		return PasscodeState{}, fmt.Errorf("GetPasscodeState: %w", err)
+	}
+	protected, ok := value.(bool)
+	if !ok {
+		return PasscodeState{}, fmt.Errorf("GetPasscodeState: unexpected value for PasswordProtected: %v", value)
+	}
+	return PasscodeState{PasswordProtected: protected}, nil
+}
+
+// lockdownError converts an error returned by lockdown into a go error, mapping
+// the errors caused by a locked device to ErrDeviceLocked.
+func lockdownError(lockdownErr string) error {
+	if lockdownErr == lockdownErrorPasswordProtected {
+		return ErrDeviceLocked
+	}
+	return fmt.Errorf("Lockdown error: %s", lockdownErr)
+}
@@ -0,0 +1,21 @@
+package ios
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockdownError(t *testing.T) {
+	assert.True(t, errors.Is(lockdownError("PasswordProtected"), ErrDeviceLocked))
+	err := lockdownError("InvalidHostID")
+	assert.False(t, errors.Is(err, ErrDeviceLocked))
+	assert.Equal(t, "Lockdown error: InvalidHostID", err.Error())
+}
+
+func TestPairingChallengeOnLockedDevice(t *testing.T) {
+	resp := ToPlistBytes(map[string]interface{}{"Error": "PasswordProtected", "Request": "Pair"})
+	_, err := extractPairingChallenge(resp)
+	assert.ErrorIs(t, err, ErrDeviceLocked)
+}
@@ -0,0 +1,312 @@
+package pcap
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Filter selects packets with an expression in the syntax of tcpdump filters. Captures can't be filtered on the
+// device, so this is evaluated for every packet on the host. It supports the primitives host, net, port and
+// portrange, optionally with src or dst, and the protocols ip, ip6, arp, tcp, udp, icmp and icmp6. They are
+// combined with and, or, not and parentheses, primitives without operator in between with and, so
+// "tcp port 443 and not host 10.0.0.1" works like in tcpdump.
+type Filter struct {
+	expression string
+	root       filterNode
+}
+
+// ParseFilter parses a tcpdump style filter expression. An empty expression matches all packets.
+func ParseFilter(expression string) (*Filter, error) {
+	f := &Filter{expression: strings.TrimSpace(expression)}
+	p := &filterParser{tokens: tokenizeFilter(f.expression)}
+	if len(p.tokens) == 0 {
+		return f, nil
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("ParseFilter: %w", err)
+	}
+	if tok := p.peek(); tok != "" {
+		return nil, fmt.Errorf("ParseFilter: unexpected '%s'", tok)
+	}
+	f.root = root
+	return f, nil
+}
+
+// String returns the expression of the filter
+func (f *Filter) String() string {
+	return f.expression
+}
+
+// Match reports whether the ethernet frame of a packet matches the filter
+func (f *Filter) Match(frame []byte) bool {
+	if f == nil || f.root == nil {
+		return true
+	}
+	return f.root.match(decodeFrame(frame))
+}
+
+// decodedFrame has the parts of a packet the filter primitives look at
+type decodedFrame struct {
+	protocols map[string]bool
+	src, dst  net.IP
+	// ports are only set for tcp and udp packets
+	hasPorts         bool
+	srcPort, dstPort int
+}
+
+func decodeFrame(frame []byte) decodedFrame {
+	d := decodedFrame{protocols: map[string]bool{}}
+	first := layers.LayerTypeEthernet
+	// the ethernet header added to packets without one always says ipv4, ipv6 packets are recognized by their version
+	if len(frame) > 14 && frame[12] == 0x08 && frame[13] == 0x00 && frame[14]>>4 == 6 {
+		frame, first = frame[14:], layers.LayerTypeIPv6
+	}
+	packet := gopacket.NewPacket(frame, first, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	for _, layer := range packet.Layers() {
+		switch l := layer.(type) {
+		case *layers.IPv4:
+			d.protocols["ip"] = true
+			d.src, d.dst = l.SrcIP, l.DstIP
+		case *layers.IPv6:
+			d.protocols["ip6"] = true
+			d.src, d.dst = l.SrcIP, l.DstIP
+		case *layers.ARP:
+			d.protocols["arp"] = true
+		case *layers.TCP:
+			d.protocols["tcp"] = true
+			d.hasPorts, d.srcPort, d.dstPort = true, int(l.SrcPort), int(l.DstPort)
+		case *layers.UDP:
+			d.protocols["udp"] = true
+			d.hasPorts, d.srcPort, d.dstPort = true, int(l.SrcPort), int(l.DstPort)
+		case *layers.ICMPv4:
+			d.protocols["icmp"] = true
+		case *layers.ICMPv6:
+			d.protocols["icmp6"] = true
+		}
+	}
+	return d
+}
+
+type filterNode interface {
+	match(d decodedFrame) bool
+}
+
+type andNode struct{ left, right filterNode }
+
+func (n andNode) match(d decodedFrame) bool { return n.left.match(d) && n.right.match(d) }
+
+type orNode struct{ left, right filterNode }
+
+func (n orNode) match(d decodedFrame) bool { return n.left.match(d) || n.right.match(d) }
+
+type notNode struct{ node filterNode }
+
+func (n notNode) match(d decodedFrame) bool { return !n.node.match(d) }
+
+type protocolNode struct{ protocol string }
+
+func (n protocolNode) match(d decodedFrame) bool { return d.protocols[n.protocol] }
+
+// netNode matches the source or destination address, dir is src, dst or empty for both
+type netNode struct {
+	dir     string
+	network *net.IPNet
+}
+
+func (n netNode) match(d decodedFrame) bool {
+	src := d.src != nil && n.network.Contains(d.src)
+	dst := d.dst != nil && n.network.Contains(d.dst)
+	return matchDir(n.dir, src, dst)
+}
+
+// portNode matches tcp and udp ports from first to last
+type portNode struct {
+	dir         string
+	first, last int
+}
+
+func (n portNode) match(d decodedFrame) bool {
+	if !d.hasPorts {
+		return false
+	}
+	src := d.srcPort >= n.first && d.srcPort <= n.last
+	dst := d.dstPort >= n.first && d.dstPort <= n.last
+	return matchDir(n.dir, src, dst)
+}
+
+func matchDir(dir string, src bool, dst bool) bool {
+	switch dir {
+	case "src":
+		return src
+	case "dst":
+		return dst
+	}
+	return src || dst
+}
+
+var filterProtocols = map[string]bool{"ip": true, "ip6": true, "arp": true, "tcp": true, "udp": true, "icmp": true, "icmp6": true}
+
+func tokenizeFilter(expression string) []string {
+	replacer := strings.NewReplacer("(", " ( ", ")", " ) ", "&&", " and ", "||", " or ", "!", " not ")
+	return strings.Fields(strings.ToLower(replacer.Replace(expression)))
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case "", "or", ")":
+			return left, nil
+		case "and":
+			p.next()
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	switch p.peek() {
+	case "not":
+		p.next()
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node}, nil
+	case "(":
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		return node, nil
+	}
+	return p.parsePrimitive()
+}
+
+func (p *filterParser) parsePrimitive() (filterNode, error) {
+	dir := ""
+	if tok := p.peek(); tok == "src" || tok == "dst" {
+		dir = p.next()
+	}
+	keyword := p.next()
+	if filterProtocols[keyword] && dir == "" {
+		return protocolNode{keyword}, nil
+	}
+	switch keyword {
+	case "host", "net", "port", "portrange":
+	case "":
+		return nil, fmt.Errorf("unexpected end of filter")
+	default:
+		return nil, fmt.Errorf("unknown primitive '%s'", keyword)
+	}
+	value := p.next()
+	if value == "" {
+		return nil, fmt.Errorf("%s needs a value", keyword)
+	}
+	switch keyword {
+	case "host":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid host '%s', only ip addresses are supported", value)
+		}
+		return netNode{dir: dir, network: hostNetwork(ip)}, nil
+	case "net":
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid net '%s'", value)
+			}
+			network = hostNetwork(ip)
+		}
+		return netNode{dir: dir, network: network}, nil
+	case "port":
+		port, err := parsePort(value)
+		if err != nil {
+			return nil, err
+		}
+		return portNode{dir: dir, first: port, last: port}, nil
+	}
+	first, last, ok := strings.Cut(value, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid portrange '%s', expected first-last", value)
+	}
+	from, err := parsePort(first)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parsePort(last)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, fmt.Errorf("invalid portrange '%s'", value)
+	}
+	return portNode{dir: dir, first: from, last: to}, nil
+}
+
+func hostNetwork(ip net.IP) *net.IPNet {
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port '%s'", value)
+	}
+	return port, nil
+}
@@ -0,0 +1,114 @@
+package pcap
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func frame(t *testing.T, src string, dst string, transport gopacket.SerializableLayer) []byte {
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{1, 2, 3, 4, 5, 6}, DstMAC: net.HardwareAddr{6, 5, 4, 3, 2, 1}}
+	var network gopacket.NetworkLayer
+	var ip gopacket.SerializableLayer
+	if srcIP.To4() != nil {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		v4 := &layers.IPv4{Version: 4, TTL: 64, SrcIP: srcIP, DstIP: dstIP}
+		network, ip = v4, v4
+		switch transport.(type) {
+		case *layers.TCP:
+			v4.Protocol = layers.IPProtocolTCP
+		case *layers.UDP:
+			v4.Protocol = layers.IPProtocolUDP
+		case *layers.ICMPv4:
+			v4.Protocol = layers.IPProtocolICMPv4
+		}
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		v6 := &layers.IPv6{Version: 6, HopLimit: 64, SrcIP: srcIP, DstIP: dstIP}
+		network, ip = v6, v6
+		switch transport.(type) {
+		case *layers.TCP:
+			v6.NextHeader = layers.IPProtocolTCP
+		case *layers.UDP:
+			v6.NextHeader = layers.IPProtocolUDP
+		}
+	}
+	switch l := transport.(type) {
+	case *layers.TCP:
+		require.NoError(t, l.SetNetworkLayerForChecksum(network))
+	case *layers.UDP:
+		require.NoError(t, l.SetNetworkLayerForChecksum(network))
+	}
+	buf := gopacket.NewSerializeBuffer()
+	require.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, eth, ip, transport, gopacket.Payload("hello")))
+	return buf.Bytes()
+}
+
+func TestFilter(t *testing.T) {
+	https := frame(t, "10.0.0.5", "17.253.144.10", &layers.TCP{SrcPort: 50123, DstPort: 443, SYN: true})
+	dns := frame(t, "10.0.0.5", "10.0.0.1", &layers.UDP{SrcPort: 53000, DstPort: 53})
+	ping := frame(t, "10.0.0.5", "10.0.0.1", &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0)})
+	v6 := frame(t, "fe80::1", "fe80::2", &layers.TCP{SrcPort: 62078, DstPort: 50000})
+
+	tests := []struct {
+		expression string
+		matches    [][]byte
+	}{
+		{"", [][]byte{https, dns, ping, v6}},
+		{"tcp", [][]byte{https, v6}},
+		{"tcp port 443", [][]byte{https}},
+		{"udp and dst port 53", [][]byte{dns}},
+		{"src port 53", nil},
+		{"host 10.0.0.1", [][]byte{dns, ping}},
+		{"dst host 10.0.0.5", nil},
+		{"net 17.0.0.0/8 or icmp", [][]byte{https, ping}},
+		{"not (tcp or udp)", [][]byte{ping}},
+		{"!ip6 && portrange 400-500", [][]byte{https}},
+		{"ip6 and src host fe80::1", [][]byte{v6}},
+		{"TCP PORT 62078", [][]byte{v6}},
+	}
+	all := [][]byte{https, dns, ping, v6}
+	for _, test := range tests {
+		filter, err := ParseFilter(test.expression)
+		require.NoError(t, err, test.expression)
+		assert.Equal(t, test.expression, filter.String())
+		var matched [][]byte
+		for _, f := range all {
+			if filter.Match(f) {
+				matched = append(matched, f)
+			}
+		}
+		assert.Equal(t, test.matches, matched, test.expression)
+	}
+}
+
+func TestFilterWithoutLinkLayer(t *testing.T) {
+	v6 := frame(t, "fe80::1", "fe80::2", &layers.UDP{SrcPort: 5353, DstPort: 5353})
+	// pcapd delivers packets of tunnel interfaces without ethernet header, an ipv4 ethernet header is added to them
+	fake := append([]byte{0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0xbe, 0xfe, 0x08, 0x00}, v6[14:]...)
+	filter, err := ParseFilter("ip6 and udp port 5353")
+	require.NoError(t, err)
+	assert.True(t, filter.Match(fake))
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expression := range []string{
+		"tcp and",
+		"(tcp or udp",
+		"port https",
+		"port 70000",
+		"host example.com",
+		"portrange 500-400",
+		"src tcp",
+		"ether host 01:02:03:04:05:06",
+		"tcp)",
+	} {
+		_, err := ParseFilter(expression)
+		assert.Error(t, err, expression)
+	}
+}
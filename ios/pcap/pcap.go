@@ -54,45 +54,107 @@ func (iph *IOSPacketHeader) ToString() string {
 }
 
 func Start(device ios.DeviceEntry) error {
-	intf, err := ios.ConnectToService(device, "com.apple.pcapd")
+	capture, err := NewCapture(device)
 	if err != nil {
 		return err
 	}
-	plistCodec := ios.NewPlistCodec()
+	defer capture.Close()
 	fname := fmt.Sprintf("dump-%d.pcap", time.Now().Unix())
 	if Pid > 0 {
 		fname = fmt.Sprintf("dump-%d-%d.pcap", Pid, time.Now().Unix())
 	} else if ProcName != "" {
 		fname = fmt.Sprintf("dump-%s-%d.pcap", ProcName, time.Now().Unix())
 	}
-	f, err := createPcap(fname)
+	f, err := os.Create(fname)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
+	w, err := NewWriter(f)
+	if err != nil {
+		return err
+	}
 	log.Info("Create pcap file: ", fname)
 	for {
-		b, err := plistCodec.Decode(intf.Reader())
+		packet, err := capture.Next()
 		if err != nil {
 			return err
 		}
-		decodedBytes, err := fromBytes(b)
+		if !packet.MatchesProcess(Pid, ProcName) {
+			continue
+		}
+		_, err = w.WritePacket(packet)
 		if err != nil {
 			return err
 		}
-		iph, packet, err := getPacket(decodedBytes)
+	}
+}
+
+// Packet is a packet pcapd captured
+type Packet struct {
+	Header IOSPacketHeader
+	// Data is the ethernet frame, pcapd delivers some packets without link layer header and a fake one is added
+	Data []byte
+}
+
+// Time is when the packet was captured
+func (p Packet) Time() time.Time {
+	return time.Unix(int64(p.Header.TsSec), int64(p.Header.TsUsec)*int64(time.Microsecond))
+}
+
+// MatchesProcess reports whether the process with the pid or a name starting with procName sent or received the
+// packet. A pid of 0 or less and an empty procName match all packets.
+func (p Packet) MatchesProcess(pid int32, procName string) bool {
+	if pid > 0 && p.Header.Pid != pid && p.Header.Pid2 != pid {
+		return false
+	}
+	if procName != "" && !strings.HasPrefix(p.Header.ProcName, procName) && !strings.HasPrefix(p.Header.ProcName2, procName) {
+		return false
+	}
+	return true
+}
+
+// Capture reads the packets pcapd captures on all interfaces of the device
+type Capture struct {
+	conn  ios.DeviceConnectionInterface
+	codec ios.PlistCodec
+}
+
+// NewCapture connects to pcapd, it starts capturing right away
+func NewCapture(device ios.DeviceEntry) (*Capture, error) {
+	conn, err := ios.ConnectToService(device, "com.apple.pcapd")
+	if err != nil {
+		return nil, err
+	}
+	return &Capture{conn: conn, codec: ios.NewPlistCodec()}, nil
+}
+
+// Next blocks until pcapd captured the next packet
+func (c *Capture) Next() (Packet, error) {
+	for {
+		b, err := c.codec.Decode(c.conn.Reader())
 		if err != nil {
-			return err
+			return Packet{}, err
 		}
-		if len(packet) > 0 {
-			err = writePacket(f, iph, packet)
-			if err != nil {
-				return err
-			}
+		decodedBytes, err := fromBytes(b)
+		if err != nil {
+			return Packet{}, err
+		}
+		iph, data, err := parsePacket(decodedBytes)
+		if err != nil {
+			return Packet{}, err
+		}
+		if len(data) > 0 {
+			return Packet{Header: iph, Data: data}, nil
 		}
 	}
 }
 
+// Close stops capturing
+func (c *Capture) Close() error {
+	return c.conn.Close()
+}
+
 func fromBytes(data []byte) ([]byte, error) {
 	var result []byte
 	_, err := plist.Unmarshal(data, &result)
@@ -124,38 +186,57 @@ type PcaprecHdrS struct {
 	OrigLen int `struc:"uint32,little"` /* actual length of packet */
 }
 
-func createPcap(name string) (*os.File, error) {
-	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o755)
+// FileHeaderSize is the size of the header every pcap file starts with, the packet records follow it
+const FileHeaderSize = 24
+
+// fileHeader is the little endian pcap_hdr_s of ethernet captures
+var fileHeader = []byte{
+	0xd4, 0xc3, 0xb2, 0xa1, 0x02, 0x00, 0x04, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+}
+
+// Writer writes packets in the pcap file format Wireshark and tcpdump read
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the pcap file header to w
+func NewWriter(w io.Writer) (*Writer, error) {
+	_, err := w.Write(fileHeader)
 	if err != nil {
 		return nil, err
 	}
-	// Write `pcap_hdr_s` with little endin to file.
-	f.Write([]byte{
-		0xd4, 0xc3, 0xb2, 0xa1, 0x02, 0x00, 0x04, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0xff, 0xff, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
-	})
-	return f, nil
+	return &Writer{w: w}, nil
 }
 
-func writePacket(f *os.File, iph IOSPacketHeader, packet []byte) error {
+// WritePacket appends the packet and returns how many bytes were written
+func (w *Writer) WritePacket(p Packet) (int, error) {
 	phs := &PcaprecHdrS{
-		iph.TsSec,
-		iph.TsUsec,
-		len(packet),
-		len(packet),
+		p.Header.TsSec,
+		p.Header.TsUsec,
+		len(p.Data),
+		len(p.Data),
 	}
 	var buf bytes.Buffer
 	err := struc.Pack(&buf, phs)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	buf.Write(p.Data)
+	return w.w.Write(buf.Bytes())
+}
+
+// getPacket parses the packet and drops it unless it belongs to the process selected with Pid or ProcName
+func getPacket(buf []byte) (IOSPacketHeader, []byte, error) {
+	iph, packet, err := parsePacket(buf)
+	if err != nil || !(Packet{Header: iph}).MatchesProcess(Pid, ProcName) {
+		return iph, []byte{}, err
 	}
-	f.Write(buf.Bytes())
-	f.Write(packet)
-	return nil
+	return iph, packet, nil
 }
 
-func getPacket(buf []byte) (iph IOSPacketHeader, packet []byte, err error) {
+func parsePacket(buf []byte) (iph IOSPacketHeader, packet []byte, err error) {
 	iph = IOSPacketHeader{}
 	preader := bytes.NewReader(buf)
 	struc.Unpack(preader, &iph)
@@ -169,19 +250,6 @@ func getPacket(buf []byte) (iph IOSPacketHeader, packet []byte, err error) {
 		}
 	}
 
-	// Only return specific packet
-	if Pid > 0 {
-		if iph.Pid != Pid && iph.Pid2 != Pid {
-			return iph, []byte{}, nil
-		}
-	}
-
-	if ProcName != "" {
-		if !strings.HasPrefix(iph.ProcName, ProcName) && !strings.HasPrefix(iph.ProcName2, ProcName) {
-			return iph, []byte{}, nil
-		}
-	}
-
 	// log.Info("IOSPacketHeader: ", iph.ToString())
 	packet, err = io.ReadAll(preader)
 	if err != nil {
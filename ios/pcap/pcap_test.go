@@ -0,0 +1,38 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, FileHeaderSize, buf.Len())
+
+	packet := Packet{Header: IOSPacketHeader{TsSec: 1700000000, TsUsec: 250}, Data: []byte{1, 2, 3}}
+	n, err := w.WritePacket(packet)
+	require.NoError(t, err)
+	assert.Equal(t, 16+3, n)
+	record := buf.Bytes()[FileHeaderSize:]
+	assert.Equal(t, uint32(1700000000), binary.LittleEndian.Uint32(record[0:]))
+	assert.Equal(t, uint32(250), binary.LittleEndian.Uint32(record[4:]))
+	assert.Equal(t, uint32(3), binary.LittleEndian.Uint32(record[8:]))
+	assert.Equal(t, []byte{1, 2, 3}, record[16:])
+	assert.Equal(t, time.Unix(1700000000, 250000), packet.Time())
+}
+
+func TestMatchesProcess(t *testing.T) {
+	packet := Packet{Header: IOSPacketHeader{Pid: 312, ProcName: "Example\x00\x00", Pid2: -1}}
+	assert.True(t, packet.MatchesProcess(0, ""))
+	assert.True(t, packet.MatchesProcess(312, ""))
+	assert.True(t, packet.MatchesProcess(-2, "Exam"))
+	assert.False(t, packet.MatchesProcess(313, ""))
+	assert.False(t, packet.MatchesProcess(0, "SpringBoard"))
+}
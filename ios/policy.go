@@ -0,0 +1,192 @@
+package ios
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Operation is a class of operations that share a timeout and retry policy
+type Operation string
+
+const (
+	// OperationPairing is pairing a device, including waiting for the response to the pair request
+	OperationPairing = Operation("pairing")
+	// OperationInstall is transferring and installing an app
+	OperationInstall = Operation("install")
+	// OperationServiceStart is starting a lockdown service and connecting to it
+	OperationServiceStart = Operation("service-start")
+	// OperationDTXRequest is waiting for the response of a DTX method call
+	OperationDTXRequest = Operation("dtx-request")
+	// OperationScreenshot is taking a screenshot
+	OperationScreenshot = Operation("screenshot")
+)
+
+// Policy configures how long an operation may take and how often it is retried if it fails.
+// A Timeout of zero means the operation never times out.
+type Policy struct {
+	Timeout time.Duration
+	Retries int
+	Backoff time.Duration
+}
+
+// defaultPolicies has no timeout for installs, installing a large app can take very long
+var defaultPolicies = map[Operation]Policy{
+	OperationPairing:      {Timeout: 30 * time.Second},
+	OperationInstall:      {},
+	OperationServiceStart: {Timeout: 15 * time.Second, Retries: 1, Backoff: time.Second},
+	OperationDTXRequest:   {Timeout: 5 * time.Second},
+	OperationScreenshot:   {Timeout: 10 * time.Second},
+}
+
+var (
+	policiesMu sync.RWMutex
+	policies   = copyPolicies(defaultPolicies)
+)
+
+func copyPolicies(p map[Operation]Policy) map[Operation]Policy {
+	result := make(map[Operation]Policy, len(p))
+	for op, policy := range p {
+		result[op] = policy
+	}
+	return result
+}
+
+// Operations returns all operations that have a policy, sorted by name
+func Operations() []Operation {
+	result := make([]Operation, 0, len(defaultPolicies))
+	for op := range defaultPolicies {
+		result = append(result, op)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// PolicyFor returns the timeout and retry policy of an operation
+func PolicyFor(op Operation) Policy {
+	policiesMu.RLock()
+	defer policiesMu.RUnlock()
+	return policies[op]
+}
+
+// Policies returns the policies of all operations
+func Policies() map[Operation]Policy {
+	policiesMu.RLock()
+	defer policiesMu.RUnlock()
+	return copyPolicies(policies)
+}
+
+// SetPolicy overrides the policy of an operation for the whole library, f.ex. to allow longer timeouts for
+// devices behind congested USB hubs
+func SetPolicy(op Operation, policy Policy) error {
+	if _, ok := defaultPolicies[op]; !ok {
+		return fmt.Errorf("SetPolicy: unknown operation '%s'", op)
+	}
+	if policy.Timeout < 0 || policy.Retries < 0 || policy.Backoff < 0 {
+		return fmt.Errorf("SetPolicy: timeout, retries and backoff of '%s' must not be negative", op)
+	}
+	policiesMu.Lock()
+	defer policiesMu.Unlock()
+	policies[op] = policy
+	return nil
+}
+
+// ResetPolicies restores the default policies of all operations
+func ResetPolicies() {
+	policiesMu.Lock()
+	defer policiesMu.Unlock()
+	policies = copyPolicies(defaultPolicies)
+}
+
+// WithOperationTimeout returns a context that is done when the timeout of the operation expires
+func WithOperationTimeout(ctx context.Context, op Operation) (context.Context, context.CancelFunc) {
+	timeout := PolicyFor(op).Timeout
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// permanentError is an error that retrying the operation can't fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as an error that retrying can't fix, RetryOperation returns it without retrying
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// RetryOperation calls f until it succeeds, at most Retries+1 times. Every attempt gets a context with the timeout
+// of the operation. It stops early when ctx is done or f returns an error marked with Permanent.
+func RetryOperation(ctx context.Context, op Operation, f func(ctx context.Context) error) error {
+	policy := PolicyFor(op)
+	var err error
+	for attempt := 0; attempt <= policy.Retries; attempt++ {
+		if attempt > 0 {
+			log.WithFields(log.Fields{"operation": op, "attempt": attempt, "error": err}).Debug("retrying operation")
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(policy.Backoff):
+			}
+		}
+		attemptCtx, cancel := WithOperationTimeout(ctx, op)
+		err = f(attemptCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// SetOperationDeadline makes reads and writes on conn fail once the timeout of the operation expired.
+// It works with DeviceConnections and net.Conns, other connections don't support deadlines and are left as is.
+// Call the returned function to remove the deadline again when the operation is done.
+func SetOperationDeadline(conn io.ReadWriteCloser, op Operation) func() {
+	timeout := PolicyFor(op).Timeout
+	if timeout <= 0 {
+		return func() {}
+	}
+	var c net.Conn
+	switch v := conn.(type) {
+	case *DeviceConnection:
+		c = v.Conn()
+	case net.Conn:
+		c = v
+	}
+	if c == nil {
+		return func() {}
+	}
+	err := c.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		log.WithError(err).Debug("failed setting deadline")
+		return func() {}
+	}
+	return func() {
+		c.SetDeadline(time.Time{})
+	}
+}
@@ -0,0 +1,97 @@
+package ios
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetPolicy(t *testing.T) {
+	defer ResetPolicies()
+	require.NoError(t, SetPolicy(OperationInstall, Policy{Timeout: time.Hour}))
+	assert.Equal(t, time.Hour, PolicyFor(OperationInstall).Timeout)
+	assert.Error(t, SetPolicy(Operation("unknown"), Policy{}))
+	assert.Error(t, SetPolicy(OperationInstall, Policy{Retries: -1}))
+
+	ResetPolicies()
+	assert.Equal(t, defaultPolicies[OperationInstall], PolicyFor(OperationInstall))
+	assert.Len(t, Operations(), len(defaultPolicies))
+}
+
+func TestRetryOperation(t *testing.T) {
+	defer ResetPolicies()
+	require.NoError(t, SetPolicy(OperationServiceStart, Policy{Timeout: 50 * time.Millisecond, Retries: 2}))
+
+	attempts := 0
+	err := RetryOperation(context.Background(), OperationServiceStart, func(ctx context.Context) error {
+		attempts++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = RetryOperation(context.Background(), OperationServiceStart, func(ctx context.Context) error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	attempts = 0
+	invalidService := errors.New("InvalidService")
+	err = RetryOperation(context.Background(), OperationServiceStart, func(ctx context.Context) error {
+		attempts++
+		return Permanent(invalidService)
+	})
+	assert.Equal(t, invalidService, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestIsTransientConnError(t *testing.T) {
+	assert.True(t, isTransientConnError(fmt.Errorf("connect: %w", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED})))
+	assert.True(t, isTransientConnError(fmt.Errorf("read: %w", io.EOF)))
+	assert.True(t, isTransientConnError(context.DeadlineExceeded))
+	assert.False(t, isTransientConnError(errors.New("Could not start service:com.example with reason:'InvalidService'")))
+	assert.False(t, isTransientConnError(fmt.Errorf("StartSession failed: %w", ErrDeviceLocked)))
+}
+
+func TestInstallsHaveNoTimeoutByDefault(t *testing.T) {
+	ResetPolicies()
+	assert.Zero(t, PolicyFor(OperationInstall).Timeout)
+	ctx, cancel := WithOperationTimeout(context.Background(), OperationInstall)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.False(t, ok)
+}
+
+func TestSetOperationDeadline(t *testing.T) {
+	defer ResetPolicies()
+	require.NoError(t, SetPolicy(OperationDTXRequest, Policy{Timeout: 20 * time.Millisecond}))
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := NewDeviceConnectionWithConn(client)
+
+	reset := SetOperationDeadline(conn, OperationDTXRequest)
+	_, err := conn.Read(make([]byte, 1))
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+
+	reset()
+	go server.Write([]byte{1})
+	_, err = conn.Read(make([]byte, 1))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,151 @@
+// Package ratelimit limits the bandwidth of file transfers, so bulk transfers like backups don't saturate the
+// uplink of a device lab and starve interactive sessions. Limits apply to every transfer and to all transfers together.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits are the bandwidth limits in bytes per second, zero means unlimited
+type Limits struct {
+	// Global limits all transfers together
+	Global int64 `json:"global"`
+	// PerTransfer limits each transfer, f.ex. one file pulled from a device or one artifact download
+	PerTransfer int64 `json:"perTransfer"`
+}
+
+// Validate returns an error if a limit is negative
+func (l Limits) Validate() error {
+	if l.Global < 0 || l.PerTransfer < 0 {
+		return fmt.Errorf("invalid bandwidth limits %+v, limits must not be negative", l)
+	}
+	return nil
+}
+
+var (
+	limitsMu sync.RWMutex
+	limits   Limits
+	// global is shared by all transfers, so changing the global limit also slows down running transfers
+	global = rate.NewLimiter(rate.Inf, 0)
+)
+
+// SetLimits configures the bandwidth limits of transfers. The global limit applies to running transfers right away,
+// the per transfer limit to transfers started afterwards.
+func SetLimits(l Limits) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	limitsMu.Lock()
+	defer limitsMu.Unlock()
+	limits = l
+	global.SetLimit(bytesPerSecond(l.Global))
+	global.SetBurst(burst(l.Global))
+	return nil
+}
+
+// CurrentLimits returns the configured bandwidth limits
+func CurrentLimits() Limits {
+	limitsMu.RLock()
+	defer limitsMu.RUnlock()
+	return limits
+}
+
+func bytesPerSecond(limit int64) rate.Limit {
+	if limit <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(limit)
+}
+
+// burst allows transfers to send one second worth of bytes at once, so chunks don't have to be split up
+func burst(limit int64) int {
+	if limit <= 0 {
+		return 0
+	}
+	return int(limit)
+}
+
+// Transfer limits the bandwidth of one transfer to its own limit and the global limit.
+// A nil Transfer does not limit anything.
+type Transfer struct {
+	limiter *rate.Limiter
+}
+
+// NewTransfer starts a transfer with the current per transfer limit
+func NewTransfer() *Transfer {
+	perTransfer := CurrentLimits().PerTransfer
+	return &Transfer{limiter: rate.NewLimiter(bytesPerSecond(perTransfer), burst(perTransfer))}
+}
+
+// Wait blocks until n bytes may be transferred or ctx is done
+func (t *Transfer) Wait(ctx context.Context, n int) error {
+	if t == nil {
+		return nil
+	}
+	if err := waitN(ctx, t.limiter, n); err != nil {
+		return err
+	}
+	return waitN(ctx, global, n)
+}
+
+// waitN splits n into parts of the burst size, WaitN fails for more bytes than the burst
+func waitN(ctx context.Context, limiter *rate.Limiter, n int) error {
+	for n > 0 {
+		if limiter.Limit() == rate.Inf {
+			return ctx.Err()
+		}
+		part := n
+		if b := limiter.Burst(); b > 0 && part > b {
+			part = b
+		}
+		if err := limiter.WaitN(ctx, part); err != nil {
+			return err
+		}
+		n -= part
+	}
+	return nil
+}
+
+// Reader limits reading from r
+func (t *Transfer) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r, t: t}
+}
+
+// Writer limits writing to w
+func (t *Transfer) Writer(ctx context.Context, w io.Writer) io.Writer {
+	return &writer{ctx: ctx, w: w, t: t}
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	t   *Transfer
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.t.Wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	ctx context.Context
+	w   io.Writer
+	t   *Transfer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if err := w.t.Wait(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerTransferLimit(t *testing.T) {
+	defer SetLimits(Limits{})
+	require.NoError(t, SetLimits(Limits{PerTransfer: 10000}))
+
+	start := time.Now()
+	var out bytes.Buffer
+	// the first second worth of bytes passes right away, the other 5000 bytes take half a second
+	n, err := io.Copy(NewTransfer().Writer(context.Background(), &out), bytes.NewReader(make([]byte, 15000)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(15000), n)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestGlobalLimitIsShared(t *testing.T) {
+	defer SetLimits(Limits{})
+	// both transfers together send more than one second worth of bytes, so they take at least half a second
+	require.NoError(t, SetLimits(Limits{Global: 10000}))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := io.Copy(io.Discard, NewTransfer().Reader(context.Background(), bytes.NewReader(make([]byte, 7500))))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestWaitIsCancelled(t *testing.T) {
+	defer SetLimits(Limits{})
+	require.NoError(t, SetLimits(Limits{PerTransfer: 1000}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, NewTransfer().Wait(ctx, 5000))
+}
+
+func TestUnlimited(t *testing.T) {
+	assert.Equal(t, Limits{}, CurrentLimits())
+	assert.NoError(t, NewTransfer().Wait(context.Background(), 1<<30))
+	var transfer *Transfer
+	assert.NoError(t, transfer.Wait(context.Background(), 1<<30))
+	assert.Error(t, SetLimits(Limits{Global: -1}))
+}
@@ -0,0 +1,91 @@
+package ios
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	appleVendorID = 0x05ac
+	dfuProductID  = 0x1227
+	// a12CPID is the chip id of the A12, devices since then have a UDID made of chip id and ECID
+	a12CPID = 0x8020
+)
+
+// USB product ids Apple devices use while they are in recovery or DFU mode
+var recoveryProductIDs = map[int]bool{
+	dfuProductID: true,
+	0x1280:       true,
+	0x1281:       true,
+	0x1282:       true,
+	0x1283:       true,
+}
+
+// ErrUSBEnumerationUnsupported is returned by ListRecoveryDevices on platforms without a usb enumeration,
+// build with -tags libusb to enumerate devices with libusb there.
+var ErrUSBEnumerationUnsupported = errors.New("usb enumeration is not supported on this platform, build with -tags libusb")
+
+// RecoveryDevice is a device in recovery or DFU mode. usbmuxd does not list those devices, they are found by
+// enumerating the usb devices of the host.
+type RecoveryDevice struct {
+	// UDID is derived from chip id and ECID, it is empty for devices older than the A12 which have a hashed UDID
+	UDID string `json:"udid,omitempty"`
+	ECID string `json:"ecid"`
+	// DFU is true in DFU mode and false in recovery mode
+	DFU       bool `json:"dfu"`
+	ProductID int  `json:"productId"`
+	// Serial is the usb serial number, f.ex. "CPID:8030 CPRV:11 ... ECID:001A2D8E0E41802E IBFL:3C"
+	Serial string `json:"serial"`
+}
+
+// usbDeviceInfo is what the usb enumeration of the platform reports about a device
+type usbDeviceInfo struct {
+	vendorID  int
+	productID int
+	serial    string
+}
+
+// listUSBDevices enumerates the usb devices of the host, it is set by the usb enumeration of the platform
+var listUSBDevices func() ([]usbDeviceInfo, error)
+
+// ListRecoveryDevices returns the Apple devices attached to the host that are in recovery or DFU mode
+func ListRecoveryDevices() ([]RecoveryDevice, error) {
+	if listUSBDevices == nil {
+		return nil, ErrUSBEnumerationUnsupported
+	}
+	devices, err := listUSBDevices()
+	if err != nil {
+		return nil, fmt.Errorf("ListRecoveryDevices: %w", err)
+	}
+	var result []RecoveryDevice
+	for _, d := range devices {
+		if d.vendorID != appleVendorID || !recoveryProductIDs[d.productID] {
+			continue
+		}
+		result = append(result, newRecoveryDevice(d.productID, d.serial))
+	}
+	return result, nil
+}
+
+// newRecoveryDevice reads ECID and chip id from the usb serial number of a device in recovery or DFU mode
+func newRecoveryDevice(productID int, serial string) RecoveryDevice {
+	device := RecoveryDevice{DFU: productID == dfuProductID, ProductID: productID, Serial: serial}
+	fields := map[string]string{}
+	for _, field := range strings.Fields(serial) {
+		if key, value, ok := strings.Cut(field, ":"); ok {
+			fields[key] = value
+		}
+	}
+	ecid, err := strconv.ParseUint(fields["ECID"], 16, 64)
+	if err != nil {
+		return device
+	}
+	device.ECID = fmt.Sprintf("%016X", ecid)
+	cpid, err := strconv.ParseUint(fields["CPID"], 16, 32)
+	if err == nil && cpid >= a12CPID {
+		device.UDID = fmt.Sprintf("%08X-%s", cpid, device.ECID)
+	}
+	return device
+}
@@ -0,0 +1,31 @@
+//go:build libusb
+
+package ios
+
+import (
+	"github.com/google/gousb"
+)
+
+func init() {
+	listUSBDevices = listLibusbDevices
+}
+
+// listLibusbDevices enumerates usb devices with libusb, it needs cgo. Only Apple devices in recovery or DFU mode
+// are opened to read their serial number.
+func listLibusbDevices() ([]usbDeviceInfo, error) {
+	ctx := gousb.NewContext()
+	defer ctx.Close()
+	opened, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		return int(desc.Vendor) == appleVendorID && recoveryProductIDs[int(desc.Product)]
+	})
+	var devices []usbDeviceInfo
+	for _, d := range opened {
+		serial, _ := d.SerialNumber()
+		devices = append(devices, usbDeviceInfo{vendorID: int(d.Desc.Vendor), productID: int(d.Desc.Product), serial: serial})
+		d.Close()
+	}
+	if err != nil && len(devices) == 0 {
+		return nil, err
+	}
+	return devices, nil
+}
@@ -0,0 +1,55 @@
+//go:build linux && !libusb
+
+package ios
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sysfsUSBDevices lists the usb devices of the linux kernel, reading it needs no cgo or permissions
+var sysfsUSBDevices = "/sys/bus/usb/devices"
+
+func init() {
+	listUSBDevices = func() ([]usbDeviceInfo, error) {
+		return listSysfsUSBDevices(sysfsUSBDevices)
+	}
+}
+
+func listSysfsUSBDevices(sysfs string) ([]usbDeviceInfo, error) {
+	entries, err := os.ReadDir(sysfs)
+	if err != nil {
+		return nil, err
+	}
+	var devices []usbDeviceInfo
+	for _, entry := range entries {
+		// interfaces are listed as well, they contain a ':'
+		if strings.Contains(entry.Name(), ":") {
+			continue
+		}
+		dir := filepath.Join(sysfs, entry.Name())
+		vendorID, err := readSysfsHex(dir, "idVendor")
+		if err != nil {
+			continue
+		}
+		productID, err := readSysfsHex(dir, "idProduct")
+		if err != nil {
+			continue
+		}
+		// devices without a serial number have no serial file
+		serial, _ := os.ReadFile(filepath.Join(dir, "serial"))
+		devices = append(devices, usbDeviceInfo{vendorID: vendorID, productID: productID, serial: strings.TrimSpace(string(serial))})
+	}
+	return devices, nil
+}
+
+func readSysfsHex(dir string, name string) (int, error) {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 16, 16)
+	return int(v), err
+}
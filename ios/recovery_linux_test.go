@@ -0,0 +1,33 @@
+//go:build linux && !libusb
+
+package ios
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListSysfsUSBDevices(t *testing.T) {
+	sysfs := t.TempDir()
+	write := func(device string, name string, value string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(sysfs, device), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(sysfs, device, name), []byte(value+"\n"), 0o644))
+	}
+	write("1-1", "idVendor", "05ac")
+	write("1-1", "idProduct", "1281")
+	write("1-1", "serial", "CPID:8030 ECID:001A2D8E0E41802E")
+	write("1-1:1.0", "bInterfaceClass", "ff")
+	write("usb1", "idVendor", "1d6b")
+	write("usb1", "idProduct", "0002")
+
+	devices, err := listSysfsUSBDevices(sysfs)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []usbDeviceInfo{
+		{vendorID: appleVendorID, productID: 0x1281, serial: "CPID:8030 ECID:001A2D8E0E41802E"},
+		{vendorID: 0x1d6b, productID: 0x0002},
+	}, devices)
+}
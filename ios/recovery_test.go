@@ -0,0 +1,40 @@
+package ios
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRecoveryDevice(t *testing.T) {
+	device := newRecoveryDevice(0x1281, "CPID:8030 CPRV:11 CPFM:03 SCEP:01 BDID:0C ECID:001A2D8E0E41802E IBFL:3C SRTG:[iBoot-7429.0.0]")
+	assert.Equal(t, RecoveryDevice{UDID: "00008030-001A2D8E0E41802E", ECID: "001A2D8E0E41802E", ProductID: 0x1281,
+		Serial: "CPID:8030 CPRV:11 CPFM:03 SCEP:01 BDID:0C ECID:001A2D8E0E41802E IBFL:3C SRTG:[iBoot-7429.0.0]"}, device)
+
+	device = newRecoveryDevice(dfuProductID, "CPID:8015 CPRV:11 CPFM:03 SCEP:01 BDID:06 ECID:1A2D8E0E41802 IBFL:3C")
+	assert.True(t, device.DFU)
+	assert.Equal(t, "0001A2D8E0E41802", device.ECID)
+	assert.Empty(t, device.UDID, "devices before the A12 have a hashed udid")
+
+	assert.Empty(t, newRecoveryDevice(0x1280, "").ECID)
+}
+
+func TestListRecoveryDevices(t *testing.T) {
+	defer func(list func() ([]usbDeviceInfo, error)) { listUSBDevices = list }(listUSBDevices)
+	listUSBDevices = func() ([]usbDeviceInfo, error) {
+		return []usbDeviceInfo{
+			{vendorID: appleVendorID, productID: 0x12a8, serial: "00008030001A2D8E0E41802E"},
+			{vendorID: appleVendorID, productID: 0x1281, serial: "CPID:8030 ECID:001A2D8E0E41802E"},
+			{vendorID: 0x1234, productID: 0x1281, serial: "CPID:8030 ECID:1"},
+		}, nil
+	}
+	devices, err := ListRecoveryDevices()
+	require.NoError(t, err)
+	require.Len(t, devices, 1)
+	assert.Equal(t, "00008030-001A2D8E0E41802E", devices[0].UDID)
+
+	listUSBDevices = nil
+	_, err = ListRecoveryDevices()
+	assert.ErrorIs(t, err, ErrUSBEnumerationUnsupported)
+}
@@ -0,0 +1,152 @@
+// Package scenarios composes the other go-ios packages into the end-to-end steps most programs embedding go-ios
+// need: installing and launching an app, running XCUITests and collecting everything they produced, and recording
+// a video of the screen while something happens on the device. Use them as building blocks instead of copying
+// the code of the ios command.
+package scenarios
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/danielpaulus/go-ios/ios/zipconduit"
+	log "github.com/sirupsen/logrus"
+	"howett.net/plist"
+)
+
+// LaunchOptions configure how InstallAndLaunch starts the app
+type LaunchOptions struct {
+	Args []string
+	Env  map[string]string
+	// KillExisting stops a running instance of the app, so the new version starts fresh
+	KillExisting bool
+}
+
+// App is an app InstallAndLaunch started
+type App struct {
+	BundleID string `json:"bundleId"`
+	Pid      uint64 `json:"pid"`
+}
+
+// installApp and launchApp access the device, tests replace them
+var (
+	installApp = func(device ios.DeviceEntry, appPath string) error {
+		conn, err := zipconduit.New(device)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return conn.SendFile(appPath)
+	}
+	launchApp = func(device ios.DeviceEntry, bundleID string, options LaunchOptions) (uint64, error) {
+		pControl, err := instruments.NewProcessControl(device)
+		if err != nil {
+			return 0, err
+		}
+		defer pControl.Close()
+		return pControl.StartProcess(bundleID, launchEnv(options.Env), launchArgs(options.Args), launchOpts(options))
+	}
+)
+
+// InstallAndLaunch installs the .app directory or .ipa file at appPath and launches it. The app needs to be signed
+// for the device and launching needs the developer image.
+func InstallAndLaunch(device ios.DeviceEntry, appPath string, options LaunchOptions) (App, error) {
+	bundleID, err := BundleID(appPath)
+	if err != nil {
+		return App{}, fmt.Errorf("InstallAndLaunch: %w", err)
+	}
+	logger := log.WithFields(log.Fields{"udid": device.Properties.SerialNumber, "bundleId": bundleID})
+	logger.Info("installing app")
+	err = installApp(device, appPath)
+	if err != nil {
+		return App{}, fmt.Errorf("InstallAndLaunch: failed installing %s: %w", bundleID, err)
+	}
+	pid, err := launchApp(device, bundleID, options)
+	if err != nil {
+		return App{}, fmt.Errorf("InstallAndLaunch: failed launching %s: %w", bundleID, err)
+	}
+	logger.WithField("pid", pid).Info("app launched")
+	return App{BundleID: bundleID, Pid: pid}, nil
+}
+
+// BundleID reads the bundle id from the Info.plist of a .app directory or an .ipa file
+func BundleID(appPath string) (string, error) {
+	info, err := os.Stat(appPath)
+	if err != nil {
+		return "", err
+	}
+	var content []byte
+	if info.IsDir() {
+		content, err = os.ReadFile(filepath.Join(appPath, "Info.plist"))
+	} else {
+		content, err = ipaInfoPlist(appPath)
+	}
+	if err != nil {
+		return "", fmt.Errorf("BundleID: failed reading Info.plist of %s: %w", appPath, err)
+	}
+	var values struct {
+		CFBundleIdentifier string
+	}
+	_, err = plist.Unmarshal(content, &values)
+	if err != nil {
+		return "", fmt.Errorf("BundleID: invalid Info.plist in %s: %w", appPath, err)
+	}
+	if values.CFBundleIdentifier == "" {
+		return "", fmt.Errorf("BundleID: no CFBundleIdentifier in Info.plist of %s", appPath)
+	}
+	return values.CFBundleIdentifier, nil
+}
+
+// ipaInfoPlist returns the content of Payload/<name>.app/Info.plist
+func ipaInfoPlist(ipaPath string) ([]byte, error) {
+	archive, err := zip.OpenReader(ipaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	for _, file := range archive.File {
+		dir, name := path.Split(file.Name)
+		if name != "Info.plist" || !strings.HasPrefix(dir, "Payload/") || strings.Count(dir, "/") != 2 || !strings.HasSuffix(dir, ".app/") {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	return nil, errors.New("no Payload/*.app/Info.plist in the ipa")
+}
+
+func launchEnv(env map[string]string) map[string]interface{} {
+	// makes the app send its logs to instruments
+	result := map[string]interface{}{"NSUnbufferedIO": "YES"}
+	for k, v := range env {
+		result[k] = v
+	}
+	return result
+}
+
+func launchArgs(args []string) []interface{} {
+	result := make([]interface{}, len(args))
+	for i, arg := range args {
+		result[i] = arg
+	}
+	return result
+}
+
+func launchOpts(options LaunchOptions) map[string]interface{} {
+	opts := map[string]interface{}{"StartSuspendedKey": uint64(0), "KillExisting": uint64(0)}
+	if options.KillExisting {
+		opts["KillExisting"] = uint64(1)
+	}
+	return opts
+}
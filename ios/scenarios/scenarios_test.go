@@ -0,0 +1,217 @@
+package scenarios
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/crashreport"
+	"github.com/danielpaulus/go-ios/ios/screenstream"
+	"github.com/danielpaulus/go-ios/ios/testmanagerd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"howett.net/plist"
+)
+
+func testDevice() ios.DeviceEntry {
+	return ios.DeviceEntry{Properties: ios.DeviceProperties{SerialNumber: "scenario-udid"}}
+}
+
+func infoPlist(t *testing.T, bundleID string) []byte {
+	b, err := plist.Marshal(map[string]interface{}{"CFBundleIdentifier": bundleID, "CFBundleExecutable": "Example"}, plist.BinaryFormat)
+	require.NoError(t, err)
+	return b
+}
+
+func TestBundleID(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "Example.app")
+	require.NoError(t, os.Mkdir(app, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(app, "Info.plist"), infoPlist(t, "com.example.app"), 0o644))
+	bundleID, err := BundleID(app)
+	require.NoError(t, err)
+	assert.Equal(t, "com.example.app", bundleID)
+
+	ipa := filepath.Join(dir, "Example.ipa")
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range map[string][]byte{
+		"Payload/Example.app/Frameworks/Kit.framework/Info.plist": infoPlist(t, "com.example.kit"),
+		"Payload/Example.app/Info.plist":                          infoPlist(t, "com.example.ipa"),
+	} {
+		w, err := archive.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, archive.Close())
+	require.NoError(t, os.WriteFile(ipa, buf.Bytes(), 0o644))
+	bundleID, err = BundleID(ipa)
+	require.NoError(t, err)
+	assert.Equal(t, "com.example.ipa", bundleID)
+
+	_, err = BundleID(dir)
+	assert.Error(t, err)
+}
+
+func TestInstallAndLaunch(t *testing.T) {
+	originalInstall, originalLaunch := installApp, launchApp
+	defer func() { installApp, launchApp = originalInstall, originalLaunch }()
+	app := filepath.Join(t.TempDir(), "Example.app")
+	require.NoError(t, os.Mkdir(app, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(app, "Info.plist"), infoPlist(t, "com.example.app"), 0o644))
+
+	var installed string
+	installApp = func(device ios.DeviceEntry, appPath string) error {
+		installed = appPath
+		return nil
+	}
+	var launched string
+	launchApp = func(device ios.DeviceEntry, bundleID string, options LaunchOptions) (uint64, error) {
+		launched = bundleID
+		assert.True(t, options.KillExisting)
+		return 312, nil
+	}
+	result, err := InstallAndLaunch(testDevice(), app, LaunchOptions{KillExisting: true})
+	require.NoError(t, err)
+	assert.Equal(t, App{BundleID: "com.example.app", Pid: 312}, result)
+	assert.Equal(t, app, installed)
+	assert.Equal(t, "com.example.app", launched)
+
+	installApp = func(device ios.DeviceEntry, appPath string) error {
+		return errors.New("ApplicationVerificationFailed")
+	}
+	launched = ""
+	_, err = InstallAndLaunch(testDevice(), app, LaunchOptions{})
+	assert.ErrorContains(t, err, "ApplicationVerificationFailed")
+	assert.Empty(t, launched, "a failed install must not launch the app")
+}
+
+func TestLaunchParameters(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{"NSUnbufferedIO": "YES", "DEBUG": "1"}, launchEnv(map[string]string{"DEBUG": "1"}))
+	assert.Equal(t, []interface{}{"-AppleLanguages", "(de)"}, launchArgs([]string{"-AppleLanguages", "(de)"}))
+	assert.Equal(t, uint64(1), launchOpts(LaunchOptions{KillExisting: true})["KillExisting"])
+	assert.Equal(t, uint64(0), launchOpts(LaunchOptions{})["KillExisting"])
+}
+
+func TestRunTestsAndCollect(t *testing.T) {
+	originalRun, originalList, originalRead := runTests, listCrashReports, readCrashReport
+	defer func() { runTests, listCrashReports, readCrashReport = originalRun, originalList, originalRead }()
+
+	runTests = func(ctx context.Context, device ios.DeviceEntry, run TestRun, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		assert.Equal(t, "com.example.app", run.BundleID)
+		return []testmanagerd.TestSuite{{Name: "ExampleUITests", TestCases: []testmanagerd.TestCase{
+			{ClassName: "ExampleUITests", MethodName: "testLogin", Status: testmanagerd.StatusPassed},
+			{ClassName: "ExampleUITests", MethodName: "testCheckout", Status: testmanagerd.StatusFailed},
+		}}}, nil
+	}
+	var filter crashreport.Filter
+	listCrashReports = func(device ios.DeviceEntry, f crashreport.Filter) ([]crashreport.Report, error) {
+		filter = f
+		return []crashreport.Report{{Name: "Example-2024-01-16-153643.ips", Process: "Example"}, {Name: "Retired/Example-2024-01-16-150000.ips"}}, nil
+	}
+	readCrashReport = func(device ios.DeviceEntry, name string, w io.Writer) error {
+		if name == "Retired/Example-2024-01-16-150000.ips" {
+			return errors.New("afc read failed")
+		}
+		_, err := w.Write([]byte("crash"))
+		return err
+	}
+
+	dir := t.TempDir()
+	results, err := RunTestsAndCollect(context.Background(), testDevice(), TestRun{BundleID: "com.example.app", CollectCrashes: true}, dir)
+	require.NoError(t, err)
+	assert.Equal(t, 2, results.Report.Summary.Total)
+	assert.Equal(t, 1, results.Report.Summary.Failed)
+	assert.Equal(t, "com.example.app", filter.BundleID)
+	assert.False(t, filter.From.IsZero())
+
+	crash := filepath.Join(dir, "crashes", "Example-2024-01-16-153643.ips")
+	assert.Equal(t, []string{crash}, results.Crashes)
+	content, err := os.ReadFile(crash)
+	require.NoError(t, err)
+	assert.Equal(t, "crash", string(content))
+	assert.NoFileExists(t, filepath.Join(dir, "crashes", "Retired", "Example-2024-01-16-150000.ips"))
+
+	var report testmanagerd.TestReport
+	b, err := os.ReadFile(results.ReportPath)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &report))
+	assert.Equal(t, results.Report.Summary, report.Summary)
+	require.Len(t, report.Crashes, 1)
+	assert.FileExists(t, results.JUnitPath)
+	assert.FileExists(t, results.LogPath)
+
+	runTests = func(ctx context.Context, device ios.DeviceEntry, run TestRun, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		return nil, errors.New("test runner did not start")
+	}
+	results, err = RunTestsAndCollect(context.Background(), testDevice(), TestRun{BundleID: "com.example.app"}, dir)
+	assert.ErrorContains(t, err, "test runner did not start")
+	assert.Equal(t, "test runner did not start", results.Report.Summary.Error)
+	assert.Empty(t, results.Crashes)
+}
+
+type fakeFrameSource struct {
+	png      []byte
+	captures atomic.Int32
+	failAt   int32
+}
+
+func (s *fakeFrameSource) Capture() ([]byte, error) {
+	if n := s.captures.Add(1); s.failAt > 0 && n >= s.failAt {
+		return nil, errors.New("device gone")
+	}
+	return s.png, nil
+}
+
+func (s *fakeFrameSource) Close() {}
+
+func TestRecordScenarioVideo(t *testing.T) {
+	original := newFrameSource
+	defer func() { newFrameSource = original }()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 20, 40))))
+	source := &fakeFrameSource{png: buf.Bytes()}
+	newFrameSource = func(device ios.DeviceEntry) (screenstream.FrameSource, error) {
+		return source, nil
+	}
+
+	path := filepath.Join(t.TempDir(), "video.mjpeg")
+	video, err := RecordScenarioVideo(context.Background(), testDevice(), path, &screenstream.Options{Scale: 1, Quality: 50, MaxFPS: 30}, func(ctx context.Context) error {
+		require.Eventually(t, func() bool { return source.captures.Load() >= 3 }, 5*time.Second, time.Millisecond)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, video.Frames, 2)
+	assert.Greater(t, video.FPS(), 0.0)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	frame, err := jpeg.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.Equal(t, 20, frame.Bounds().Dx())
+
+	scenarioErr := errors.New("login button not found")
+	_, err = RecordScenarioVideo(context.Background(), testDevice(), path, nil, func(ctx context.Context) error {
+		return scenarioErr
+	})
+	assert.Equal(t, scenarioErr, err)
+
+	source = &fakeFrameSource{png: buf.Bytes(), failAt: 2}
+	_, err = RecordScenarioVideo(context.Background(), testDevice(), path, nil, func(ctx context.Context) error {
+		time.Sleep(300 * time.Millisecond)
+		return nil
+	})
+	assert.ErrorContains(t, err, "device gone")
+}
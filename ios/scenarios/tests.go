@@ -0,0 +1,147 @@
+package scenarios
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/crashreport"
+	"github.com/danielpaulus/go-ios/ios/testmanagerd"
+	log "github.com/sirupsen/logrus"
+)
+
+// TestRun is the XCUITest run of RunTestsAndCollect
+type TestRun struct {
+	// BundleID is the app under test, TestRunnerBundleID the installed test runner and XCTestConfig the name of
+	// its .xctest bundle, like MyAppUITests.xctest
+	BundleID           string
+	TestRunnerBundleID string
+	XCTestConfig       string
+	Args               []string
+	Env                []string
+	// Options select the tests, shards, retries and energy profiling
+	Options []testmanagerd.TestOption
+	// CollectCrashes downloads the crash reports the app under test wrote during the run
+	CollectCrashes bool
+}
+
+// TestResults is what RunTestsAndCollect wrote to its directory
+type TestResults struct {
+	Report testmanagerd.TestReport
+	// ReportPath is report.json, JUnitPath junit.xml and LogPath the log of the test runner
+	ReportPath string
+	JUnitPath  string
+	LogPath    string
+	// Crashes are the paths of the collected crash reports
+	Crashes []string
+}
+
+// runTests, listCrashReports and readCrashReport access the device, tests replace them
+var (
+	runTests = func(ctx context.Context, device ios.DeviceEntry, run TestRun, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		return testmanagerd.RunXCUITestWithOptions(ctx, run.BundleID, run.TestRunnerBundleID, run.XCTestConfig, device, run.Args, run.Env, listener, false, run.Options...)
+	}
+	listCrashReports = crashreport.Reports
+	readCrashReport  = crashreport.ReadReport
+)
+
+// RunTestsAndCollect runs the XCUITests and writes report.json, junit.xml, the log of the test runner, the
+// attachments and, if requested, the crash reports of the app into dir. Failing tests are no error, they are in the
+// report. The returned error is why the run failed, the results have what was collected until then.
+func RunTestsAndCollect(ctx context.Context, device ios.DeviceEntry, run TestRun, dir string) (TestResults, error) {
+	err := os.MkdirAll(filepath.Join(dir, "attachments"), 0o755)
+	if err != nil {
+		return TestResults{}, fmt.Errorf("RunTestsAndCollect: %w", err)
+	}
+	results := TestResults{
+		ReportPath: filepath.Join(dir, "report.json"),
+		JUnitPath:  filepath.Join(dir, "junit.xml"),
+		LogPath:    filepath.Join(dir, "testrunner.log"),
+	}
+	logFile, err := os.Create(results.LogPath)
+	if err != nil {
+		return TestResults{}, fmt.Errorf("RunTestsAndCollect: %w", err)
+	}
+	defer logFile.Close()
+	junit, err := os.Create(results.JUnitPath)
+	if err != nil {
+		return TestResults{}, fmt.Errorf("RunTestsAndCollect: %w", err)
+	}
+	defer junit.Close()
+
+	listener := testmanagerd.NewTestListener(logFile, logFile, filepath.Join(dir, "attachments"))
+	listener.AddReporter(testmanagerd.NewJUnitReporter(junit, run.BundleID))
+	started := time.Now()
+	suites, runErr := runTests(ctx, device, run, listener)
+
+	results.Report = testmanagerd.NewTestReport(suites, runErr)
+	results.Report.Energy = listener.Energy
+	if run.CollectCrashes {
+		crashes := collectCrashes(device, run.BundleID, started, filepath.Join(dir, "crashes"))
+		for _, crash := range crashes {
+			results.Crashes = append(results.Crashes, crash.Path)
+		}
+		results.Report.Crashes = crashes
+	}
+	b, err := json.MarshalIndent(results.Report, "", "  ")
+	if err == nil {
+		err = os.WriteFile(results.ReportPath, b, 0o644)
+	}
+	if err != nil {
+		return results, fmt.Errorf("RunTestsAndCollect: failed writing the report: %w", err)
+	}
+	if runErr != nil {
+		return results, fmt.Errorf("RunTestsAndCollect: %w", runErr)
+	}
+	return results, nil
+}
+
+// collectCrashes downloads the crash reports of the app written since the run started. Crash reports are extra
+// information, failing to collect them is only logged.
+func collectCrashes(device ios.DeviceEntry, bundleID string, since time.Time, dir string) []testmanagerd.TestAttachment {
+	logger := log.WithFields(log.Fields{"udid": device.Properties.SerialNumber, "bundleId": bundleID})
+	reports, err := listCrashReports(device, crashreport.Filter{BundleID: bundleID, From: since})
+	if err != nil {
+		logger.WithError(err).Warn("failed listing the crash reports of the test run")
+		return nil
+	}
+	var attachments []testmanagerd.TestAttachment
+	for _, report := range reports {
+		target := filepath.Join(dir, filepath.FromSlash(report.Name))
+		err := os.MkdirAll(filepath.Dir(target), 0o755)
+		if err != nil {
+			logger.WithError(err).Warn("failed collecting crash report")
+			return attachments
+		}
+		err = writeCrashReport(device, report.Name, target)
+		if err != nil {
+			logger.WithError(err).WithField("report", report.Name).Warn("failed collecting crash report")
+			continue
+		}
+		attachments = append(attachments, testmanagerd.TestAttachment{
+			Name:      report.Name,
+			Path:      target,
+			Type:      "crash report",
+			Timestamp: float64(report.Modified.Unix()),
+		})
+	}
+	return attachments
+}
+
+func writeCrashReport(device ios.DeviceEntry, name string, target string) error {
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	err = readCrashReport(device, name, file)
+	closeErr := file.Close()
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+	return closeErr
+}
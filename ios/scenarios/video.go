@@ -0,0 +1,86 @@
+package scenarios
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/screenstream"
+)
+
+// Video is a recording of RecordScenarioVideo
+type Video struct {
+	Path     string        `json:"path"`
+	Frames   int           `json:"frames"`
+	Duration time.Duration `json:"duration"`
+}
+
+// FPS is the average frame rate of the video, players need it because motion jpeg has no timestamps
+func (v Video) FPS() float64 {
+	if v.Duration <= 0 {
+		return 0
+	}
+	return float64(v.Frames) / v.Duration.Seconds()
+}
+
+// newFrameSource captures the screen, tests replace it
+var newFrameSource = screenstream.NewInstrumentsSource
+
+// RecordScenarioVideo records the screen while scenario runs and writes it as motion jpeg to path, the frames are
+// jpeg images one after the other. Convert it with 'ffmpeg -framerate <Video.FPS> -i video.mjpeg video.mp4'.
+// options are screenstream.DefaultOptions if they are nil. It returns the error of the scenario, or why recording
+// failed if the scenario succeeded. The video has all frames until then either way. Needs the developer image.
+func RecordScenarioVideo(ctx context.Context, device ios.DeviceEntry, path string, options *screenstream.Options, scenario func(ctx context.Context) error) (Video, error) {
+	streamOptions := screenstream.DefaultOptions()
+	if options != nil {
+		streamOptions = *options
+	}
+	err := streamOptions.Validate()
+	if err != nil {
+		return Video{}, fmt.Errorf("RecordScenarioVideo: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return Video{}, fmt.Errorf("RecordScenarioVideo: %w", err)
+	}
+	defer file.Close()
+	source, err := newFrameSource(device)
+	if err != nil {
+		return Video{}, fmt.Errorf("RecordScenarioVideo: failed starting screen capture: %w", err)
+	}
+	stream := screenstream.New(source, streamOptions)
+	frames, unsubscribe := stream.Subscribe()
+	defer unsubscribe()
+
+	video := Video{Path: path}
+	written := make(chan error, 1)
+	go func() {
+		var writeErr error
+		for frame := range frames {
+			if writeErr != nil {
+				continue
+			}
+			_, writeErr = file.Write(frame)
+			if writeErr == nil {
+				video.Frames++
+			}
+		}
+		written <- writeErr
+	}()
+	started := time.Now()
+	scenarioErr := scenario(ctx)
+	video.Duration = time.Since(started)
+	stream.Close()
+	writeErr := <-written
+	switch {
+	case scenarioErr != nil:
+		return video, scenarioErr
+	case stream.Err() != nil:
+		return video, fmt.Errorf("RecordScenarioVideo: %w", stream.Err())
+	case writeErr != nil:
+		return video, fmt.Errorf("RecordScenarioVideo: %w", writeErr)
+	}
+	return video, file.Close()
+}
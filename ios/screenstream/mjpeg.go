@@ -0,0 +1,66 @@
+package screenstream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+)
+
+const mjpegBoundary = "go-ios-frame"
+
+// ServeMJPEG writes frames as multipart/x-mixed-replace stream until ctx is done, frames is closed or writing
+// fails because the client went away
+func ServeMJPEG(ctx context.Context, w http.ResponseWriter, frames <-chan []byte) error {
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache, private")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case frame, ok := <-frames:
+			if !ok {
+				return nil
+			}
+			_, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(frame))
+			if err == nil {
+				_, err = w.Write(frame)
+			}
+			if err == nil {
+				_, err = w.Write([]byte("\r\n"))
+			}
+			if err != nil {
+				return fmt.Errorf("ServeMJPEG: %w", err)
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+type instrumentsSource struct {
+	service *instruments.ScreenshotService
+}
+
+// NewInstrumentsSource captures frames with the instruments screenshot service, it needs the developer image
+func NewInstrumentsSource(device ios.DeviceEntry) (FrameSource, error) {
+	service, err := instruments.NewScreenshotService(device)
+	if err != nil {
+		return nil, fmt.Errorf("NewInstrumentsSource: %w", err)
+	}
+	return instrumentsSource{service: service}, nil
+}
+
+func (s instrumentsSource) Capture() ([]byte, error) {
+	return s.service.TakeScreenshot()
+}
+
+func (s instrumentsSource) Close() {
+	s.service.Close()
+}
@@ -0,0 +1,169 @@
+package screenstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// rtpPayloadTypeJPEG is the static payload type of RFC 2435
+	rtpPayloadTypeJPEG = 26
+	// rtpClockRate is the clock of RTP timestamps for video
+	rtpClockRate = 90000
+	// MaxRTPSide is the largest width and height RFC 2435 can describe, it counts in blocks of 8 pixels in one byte
+	MaxRTPSide = 255 * 8
+	// defaultRTPPayloadSize keeps packets below the usual MTU of 1500 bytes
+	defaultRTPPayloadSize = 1400
+)
+
+// jpegFrame is what RFC 2435 transfers of a baseline jpeg, the decoder rebuilds the headers from it
+type jpegFrame struct {
+	// typ is 0 for 4:2:2 and 1 for 4:2:0 subsampling
+	typ uint8
+	// width and height in blocks of 8 pixels
+	width  uint8
+	height uint8
+	// quant are the luma and chroma quantization tables in zigzag order like in the DQT segment
+	quant []byte
+	// scan is the entropy coded data
+	scan []byte
+}
+
+// parseJPEG extracts the parts RFC 2435 needs from a baseline jpeg with three components like image/jpeg encodes them.
+// The huffman tables are not transferred, RFC 2435 decoders use the standard tables image/jpeg uses as well.
+func parseJPEG(b []byte) (jpegFrame, error) {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return jpegFrame{}, fmt.Errorf("parseJPEG: not a jpeg")
+	}
+	var frame jpegFrame
+	tables := map[byte][]byte{}
+	pos := 2
+	for {
+		if pos+4 > len(b) || b[pos] != 0xFF {
+			return jpegFrame{}, fmt.Errorf("parseJPEG: invalid marker at %d", pos)
+		}
+		marker := b[pos+1]
+		length := int(binary.BigEndian.Uint16(b[pos+2:]))
+		segment := pos + 4
+		end := pos + 2 + length
+		if length < 2 || end > len(b) {
+			return jpegFrame{}, fmt.Errorf("parseJPEG: truncated segment %X", marker)
+		}
+		switch {
+		case marker == 0xDB:
+			for i := segment; i < end; i += 65 {
+				if b[i]>>4 != 0 || i+65 > end {
+					return jpegFrame{}, fmt.Errorf("parseJPEG: only 8 bit quantization tables are supported")
+				}
+				tables[b[i]&0x0F] = b[i+1 : i+65]
+			}
+		case marker == 0xC0:
+			err := frame.parseSOF(b[segment:end])
+			if err != nil {
+				return jpegFrame{}, err
+			}
+		case marker == 0xDD:
+			if binary.BigEndian.Uint16(b[segment:]) != 0 {
+				return jpegFrame{}, fmt.Errorf("parseJPEG: restart markers are not supported")
+			}
+		case marker >= 0xC1 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC:
+			return jpegFrame{}, fmt.Errorf("parseJPEG: only baseline jpegs are supported")
+		case marker == 0xDA:
+			if frame.width == 0 {
+				return jpegFrame{}, fmt.Errorf("parseJPEG: scan before the frame header")
+			}
+			if tables[0] == nil || tables[1] == nil {
+				return jpegFrame{}, fmt.Errorf("parseJPEG: quantization tables are missing")
+			}
+			eoi := bytes.LastIndex(b, []byte{0xFF, 0xD9})
+			if eoi < end {
+				return jpegFrame{}, fmt.Errorf("parseJPEG: end of image is missing")
+			}
+			frame.quant = append(append([]byte{}, tables[0]...), tables[1]...)
+			frame.scan = b[end:eoi]
+			return frame, nil
+		}
+		pos = end
+	}
+}
+
+func (f *jpegFrame) parseSOF(sof []byte) error {
+	if len(sof) < 15 || sof[0] != 8 || sof[5] != 3 {
+		return fmt.Errorf("parseJPEG: only 8 bit jpegs with three components are supported")
+	}
+	height := int(binary.BigEndian.Uint16(sof[1:]))
+	width := int(binary.BigEndian.Uint16(sof[3:]))
+	if width > MaxRTPSide || height > MaxRTPSide {
+		return fmt.Errorf("parseJPEG: %dx%d is larger than %d pixels", width, height, MaxRTPSide)
+	}
+	switch sof[7] {
+	case 0x21:
+		f.typ = 0
+	case 0x22:
+		f.typ = 1
+	default:
+		return fmt.Errorf("parseJPEG: only 4:2:2 and 4:2:0 subsampling are supported")
+	}
+	// luma uses table 0, both chroma components table 1 and are not subsampled further
+	if sof[8] != 0 || sof[10] != 0x11 || sof[11] != 1 || sof[13] != 0x11 || sof[14] != 1 {
+		return fmt.Errorf("parseJPEG: unsupported component layout")
+	}
+	f.width = uint8((width + 7) / 8)
+	f.height = uint8((height + 7) / 8)
+	return nil
+}
+
+// RTPPacketizer splits jpeg frames into RTP packets with the JPEG payload format of RFC 2435
+type RTPPacketizer struct {
+	ssrc        uint32
+	seq         uint16
+	payloadSize int
+}
+
+// NewRTPPacketizer creates a packetizer for the RTP stream ssrc starting with sequence number seq
+func NewRTPPacketizer(ssrc uint32, seq uint16) *RTPPacketizer {
+	return &RTPPacketizer{ssrc: ssrc, seq: seq, payloadSize: defaultRTPPayloadSize}
+}
+
+// Seq is the sequence number of the next packet
+func (p *RTPPacketizer) Seq() uint16 {
+	return p.seq
+}
+
+// Packetize returns the RTP packets of a jpeg frame, timestamp uses the 90kHz clock of video. The quantization
+// tables are sent in the first packet of every frame, the jpegs of a Stream can change their quality any time.
+func (p *RTPPacketizer) Packetize(jpeg []byte, timestamp uint32) ([][]byte, error) {
+	frame, err := parseJPEG(jpeg)
+	if err != nil {
+		return nil, err
+	}
+	var packets [][]byte
+	for offset := 0; offset < len(frame.scan) || offset == 0; {
+		header := make([]byte, 12, p.payloadSize+12)
+		header[0] = 2 << 6
+		header[1] = rtpPayloadTypeJPEG
+		binary.BigEndian.PutUint16(header[2:], p.seq)
+		binary.BigEndian.PutUint32(header[4:], timestamp)
+		binary.BigEndian.PutUint32(header[8:], p.ssrc)
+		p.seq++
+
+		// type specific, 24 bit fragment offset, type, Q 255 means the tables are in the packet, size
+		packet := append(header, 0, byte(offset>>16), byte(offset>>8), byte(offset), frame.typ, 255, frame.width, frame.height)
+		if offset == 0 {
+			packet = append(packet, 0, 0, 0, byte(len(frame.quant)))
+			packet = append(packet, frame.quant...)
+		}
+		n := min(cap(packet)-len(packet), len(frame.scan)-offset)
+		packet = append(packet, frame.scan[offset:offset+n]...)
+		offset += n
+		if offset == len(frame.scan) {
+			packet[1] |= 0x80
+		}
+		packets = append(packets, packet)
+		if n == 0 {
+			break
+		}
+	}
+	return packets, nil
+}
@@ -0,0 +1,61 @@
+package screenstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testJPEG(t *testing.T, width int, height int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), &jpeg.Options{Quality: 80}))
+	return buf.Bytes()
+}
+
+func TestPacketize(t *testing.T) {
+	frame := testJPEG(t, 1170, 2000)
+	parsed, err := parseJPEG(frame)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(1), parsed.typ, "image/jpeg uses 4:2:0 subsampling")
+	assert.Equal(t, uint8(147), parsed.width)
+	assert.Equal(t, uint8(250), parsed.height)
+	assert.Len(t, parsed.quant, 128)
+
+	p := NewRTPPacketizer(0xCAFE, 65535)
+	packets, err := p.Packetize(frame, 90000)
+	require.NoError(t, err)
+	require.NotEmpty(t, packets)
+	var scan []byte
+	for i, packet := range packets {
+		assert.LessOrEqual(t, len(packet), defaultRTPPayloadSize+12)
+		assert.Equal(t, byte(0x80), packet[0], "rtp version 2")
+		assert.Equal(t, i == len(packets)-1, packet[1]&0x80 != 0, "the marker is set on the last packet of a frame")
+		assert.Equal(t, byte(rtpPayloadTypeJPEG), packet[1]&0x7F)
+		assert.Equal(t, uint16(65535+i), binary.BigEndian.Uint16(packet[2:]), "sequence numbers wrap")
+		assert.Equal(t, uint32(90000), binary.BigEndian.Uint32(packet[4:]))
+		assert.Equal(t, uint32(0xCAFE), binary.BigEndian.Uint32(packet[8:]))
+		jpegHeader := packet[12:20]
+		offset := int(jpegHeader[1])<<16 | int(jpegHeader[2])<<8 | int(jpegHeader[3])
+		assert.Equal(t, len(scan), offset)
+		assert.Equal(t, []byte{1, 255, 147, 250}, jpegHeader[4:])
+		payload := packet[20:]
+		if i == 0 {
+			assert.Equal(t, []byte{0, 0, 0, 128}, payload[:4])
+			assert.Equal(t, parsed.quant, payload[4:132])
+			payload = payload[132:]
+		}
+		scan = append(scan, payload...)
+	}
+	assert.Equal(t, parsed.scan, scan)
+	assert.Equal(t, uint16(65535+len(packets)), p.Seq())
+
+	_, err = p.Packetize(testJPEG(t, 100, 2048), 0)
+	assert.ErrorContains(t, err, "larger than")
+	_, err = p.Packetize([]byte("not a jpeg"), 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,493 @@
+package screenstream
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RTSPHandler decides who can watch which stream of an RTSPServer
+type RTSPHandler interface {
+	// Authorize checks the credentials and the stream of every request but OPTIONS, f.ex. that the path names a
+	// known device. Return an *RTSPError to answer with a status like 401 or 404, other errors are answered with 500.
+	Authorize(req *http.Request) error
+	// Open subscribes to the jpeg frames of the stream a PLAY request asks for, stop unsubscribes again and closes
+	// frames. The stream ends for the player if frames is closed before.
+	Open(req *http.Request) (frames <-chan []byte, stop func(), err error)
+}
+
+// RTSPError answers an RTSP request with Status
+type RTSPError struct {
+	Status  int
+	Message string
+}
+
+func (e *RTSPError) Error() string {
+	return e.Message
+}
+
+const (
+	rtspPublic       = "OPTIONS, DESCRIBE, SETUP, PLAY, PAUSE, TEARDOWN, GET_PARAMETER, SET_PARAMETER"
+	rtspWriteTimeout = 10 * time.Second
+)
+
+var rtspStatusTexts = map[int]string{
+	454: "Session Not Found",
+	455: "Method Not Valid in This State",
+	461: "Unsupported Transport",
+}
+
+// RTSPServer publishes the streams of an RTSPHandler with RTSP (RFC 2326) as RTP/JPEG (RFC 2435), interleaved in the
+// RTSP connection or over UDP. Latency is the time it takes to capture and encode one frame, there is no buffering.
+// Every connection has at most one session with one video track.
+type RTSPServer struct {
+	handler RTSPHandler
+	// SessionTimeout is announced to players, connections that send nothing for twice as long are closed
+	SessionTimeout time.Duration
+}
+
+// NewRTSPServer creates a server for the streams of handler
+func NewRTSPServer(handler RTSPHandler) *RTSPServer {
+	return &RTSPServer{handler: handler, SessionTimeout: 60 * time.Second}
+}
+
+// ListenAndServe serves RTSP on the tcp address addr, f.ex. :8554
+func (s *RTSPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("ListenAndServe: %w", err)
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts RTSP connections on l until it is closed
+func (s *RTSPServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("Serve: %w", err)
+		}
+		c := &rtspConn{server: s, conn: conn, reader: bufio.NewReader(conn)}
+		go c.serve()
+	}
+}
+
+// rtspConn is one RTSP connection and its session
+type rtspConn struct {
+	server *RTSPServer
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMu sync.Mutex
+
+	session    string
+	transport  *rtspTransport
+	udp        *net.UDPConn
+	packetizer *RTPPacketizer
+	clockStart time.Time
+	clockBase  uint32
+	playback   *rtspPlayback
+}
+
+type rtspTransport struct {
+	tcp bool
+	// channel of RTP packets in the connection, RTCP would be the next one
+	channel    int
+	clientAddr *net.UDPAddr
+}
+
+type rtspPlayback struct {
+	stop    func()
+	stopped atomic.Bool
+	done    chan struct{}
+}
+
+// end stops the playback and waits until no more packets are sent
+func (p *rtspPlayback) end() {
+	p.stopped.Store(true)
+	p.stop()
+	<-p.done
+}
+
+func (c *rtspConn) serve() {
+	defer c.conn.Close()
+	defer c.teardown()
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(2 * c.server.SessionTimeout))
+		req, err := c.readRequest()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.WithError(err).WithField("client", c.conn.RemoteAddr().String()).Debug("rtsp connection closed")
+			}
+			return
+		}
+		if req == nil {
+			// an interleaved RTCP report of the player, it only matters as keep alive
+			continue
+		}
+		status, header, body, then := c.handle(req)
+		err = c.respond(req, status, header, body)
+		// then runs also if writing failed, a playback that was set up has to start to be stopped again
+		keep := then == nil || then()
+		if err != nil || !keep {
+			return
+		}
+	}
+}
+
+// readRequest reads the next request, it returns nil for interleaved binary data
+func (c *rtspConn) readRequest() (*http.Request, error) {
+	first, err := c.reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] == '$' {
+		var frameHeader [4]byte
+		_, err = io.ReadFull(c.reader, frameHeader[:])
+		if err != nil {
+			return nil, err
+		}
+		_, err = c.reader.Discard(int(binary.BigEndian.Uint16(frameHeader[2:])))
+		return nil, err
+	}
+	reader := textproto.NewReader(c.reader)
+	line, err := reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "RTSP/1.") {
+		return nil, fmt.Errorf("readRequest: invalid request line '%s'", line)
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("readRequest: %w", err)
+	}
+	if length, _ := strconv.Atoi(header.Get("Content-Length")); length > 0 {
+		_, err = c.reader.Discard(length)
+		if err != nil {
+			return nil, err
+		}
+	}
+	u, err := url.Parse(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("readRequest: invalid url '%s': %w", parts[1], err)
+	}
+	return &http.Request{
+		Method:     parts[0],
+		URL:        u,
+		Proto:      parts[2],
+		Header:     http.Header(header),
+		Host:       u.Host,
+		RemoteAddr: c.conn.RemoteAddr().String(),
+		RequestURI: parts[1],
+	}, nil
+}
+
+// handle answers req, then runs after the response was written and closes the connection if it returns false
+func (c *rtspConn) handle(req *http.Request) (status int, header http.Header, body []byte, then func() bool) {
+	header = http.Header{}
+	if req.Header.Get("CSeq") == "" {
+		return http.StatusBadRequest, header, nil, func() bool { return false }
+	}
+	if req.Method == "OPTIONS" {
+		header.Set("Public", rtspPublic)
+		return http.StatusOK, header, nil, nil
+	}
+	err := c.server.handler.Authorize(req)
+	if err != nil {
+		return c.errorStatus(err, header), header, nil, nil
+	}
+	if session := req.Header.Get("Session"); session != "" {
+		id, _, _ := strings.Cut(session, ";")
+		if c.session == "" || strings.TrimSpace(id) != c.session {
+			return 454, header, nil, nil
+		}
+	}
+	switch req.Method {
+	case "DESCRIBE":
+		header.Set("Content-Type", "application/sdp")
+		header.Set("Content-Base", contentBase(req.URL))
+		return http.StatusOK, header, c.sdp(), nil
+	case "SETUP":
+		return c.setup(req, header)
+	case "PLAY":
+		return c.play(req, header)
+	case "PAUSE":
+		if c.transport == nil {
+			return 455, header, nil, nil
+		}
+		c.stopPlaying()
+		c.sessionHeader(header)
+		return http.StatusOK, header, nil, nil
+	case "TEARDOWN":
+		c.teardown()
+		return http.StatusOK, header, nil, func() bool { return false }
+	case "GET_PARAMETER", "SET_PARAMETER":
+		c.sessionHeader(header)
+		return http.StatusOK, header, nil, nil
+	default:
+		header.Set("Public", rtspPublic)
+		return http.StatusNotImplemented, header, nil, nil
+	}
+}
+
+func (c *rtspConn) errorStatus(err error, header http.Header) int {
+	var rtspErr *RTSPError
+	if !errors.As(err, &rtspErr) {
+		log.WithError(err).Warn("rtsp request failed")
+		return http.StatusInternalServerError
+	}
+	if rtspErr.Status == http.StatusUnauthorized {
+		header.Set("WWW-Authenticate", `Basic realm="go-ios"`)
+	}
+	return rtspErr.Status
+}
+
+func (c *rtspConn) setup(req *http.Request, header http.Header) (int, http.Header, []byte, func() bool) {
+	if c.playback != nil {
+		return 455, header, nil, nil
+	}
+	transport, ok := parseTransport(req.Header.Get("Transport"))
+	if !ok {
+		return 461, header, nil, nil
+	}
+	if c.session == "" {
+		c.session = randomHex(8)
+		var ssrc [4]byte
+		var base [6]byte
+		rand.Read(ssrc[:])
+		rand.Read(base[:])
+		c.packetizer = NewRTPPacketizer(binary.BigEndian.Uint32(ssrc[:]), binary.BigEndian.Uint16(base[:]))
+		c.clockBase = binary.BigEndian.Uint32(base[2:])
+		c.clockStart = time.Now()
+	}
+	if c.udp != nil {
+		c.udp.Close()
+		c.udp = nil
+	}
+	ssrc := fmt.Sprintf("%08X", c.packetizer.ssrc)
+	if transport.tcp {
+		header.Set("Transport", fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d;ssrc=%s", transport.channel, transport.channel+1, ssrc))
+	} else {
+		remote, _ := c.conn.RemoteAddr().(*net.TCPAddr)
+		local, _ := c.conn.LocalAddr().(*net.TCPAddr)
+		if remote == nil || local == nil {
+			return 461, header, nil, nil
+		}
+		udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+		if err != nil {
+			log.WithError(err).Warn("rtsp: failed opening udp port")
+			return http.StatusInternalServerError, header, nil, nil
+		}
+		c.udp = udp
+		transport.clientAddr.IP = remote.IP
+		serverPort := udp.LocalAddr().(*net.UDPAddr).Port
+		header.Set("Transport", fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=%d-%d;ssrc=%s",
+			transport.clientAddr.Port, transport.clientAddr.Port+1, serverPort, serverPort+1, ssrc))
+	}
+	c.transport = &transport
+	c.sessionHeader(header)
+	return http.StatusOK, header, nil, nil
+}
+
+func (c *rtspConn) play(req *http.Request, header http.Header) (int, http.Header, []byte, func() bool) {
+	if c.transport == nil {
+		return 455, header, nil, nil
+	}
+	c.sessionHeader(header)
+	if c.playback != nil {
+		return http.StatusOK, header, nil, nil
+	}
+	header.Set("RTP-Info", fmt.Sprintf("url=%strackID=0;seq=%d;rtptime=%d", contentBase(req.URL), c.packetizer.Seq(), c.timestamp()))
+	frames, stop, err := c.server.handler.Open(req)
+	if err != nil {
+		return c.errorStatus(err, header), header, nil, nil
+	}
+	playback := &rtspPlayback{stop: stop, done: make(chan struct{})}
+	c.playback = playback
+	return http.StatusOK, header, nil, func() bool {
+		go c.send(playback, frames)
+		return true
+	}
+}
+
+// send packetizes frames until the playback stops. The connection is closed if the stream ends by itself,
+// f.ex. because the device was disconnected, so the player notices it.
+func (c *rtspConn) send(playback *rtspPlayback, frames <-chan []byte) {
+	defer close(playback.done)
+	for frame := range frames {
+		packets, err := c.packetizer.Packetize(frame, c.timestamp())
+		if err != nil {
+			log.WithError(err).Warn("rtsp: dropping frame")
+			continue
+		}
+		for _, packet := range packets {
+			err = c.sendRTP(packet)
+			if err != nil {
+				log.WithError(err).Debug("rtsp: failed sending frame")
+				c.conn.Close()
+				return
+			}
+		}
+	}
+	if !playback.stopped.Load() {
+		c.conn.Close()
+	}
+}
+
+func (c *rtspConn) sendRTP(packet []byte) error {
+	if !c.transport.tcp {
+		_, err := c.udp.WriteToUDP(packet, c.transport.clientAddr)
+		return err
+	}
+	frame := make([]byte, 4, len(packet)+4)
+	frame[0] = '$'
+	frame[1] = byte(c.transport.channel)
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(packet)))
+	return c.write(append(frame, packet...))
+}
+
+// timestamp is the RTP time of now, it keeps running while the playback is paused
+func (c *rtspConn) timestamp() uint32 {
+	return c.clockBase + uint32(time.Since(c.clockStart)*rtpClockRate/time.Second)
+}
+
+func (c *rtspConn) stopPlaying() {
+	if c.playback != nil {
+		c.playback.end()
+		c.playback = nil
+	}
+}
+
+func (c *rtspConn) teardown() {
+	c.stopPlaying()
+	if c.udp != nil {
+		c.udp.Close()
+		c.udp = nil
+	}
+	c.transport = nil
+	c.session = ""
+}
+
+func (c *rtspConn) sessionHeader(header http.Header) {
+	if c.session != "" {
+		header.Set("Session", fmt.Sprintf("%s;timeout=%d", c.session, int(c.server.SessionTimeout.Seconds())))
+	}
+}
+
+func (c *rtspConn) sdp() []byte {
+	addrType, addr := "IP4", "0.0.0.0"
+	if local, ok := c.conn.LocalAddr().(*net.TCPAddr); ok {
+		addr = local.IP.String()
+		if local.IP.To4() == nil {
+			addrType = "IP6"
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "v=0\r\n")
+	fmt.Fprintf(&b, "o=- %d 1 IN %s %s\r\n", time.Now().Unix(), addrType, addr)
+	fmt.Fprintf(&b, "s=go-ios\r\n")
+	fmt.Fprintf(&b, "c=IN %s %s\r\n", addrType, addr)
+	fmt.Fprintf(&b, "t=0 0\r\n")
+	fmt.Fprintf(&b, "m=video 0 RTP/AVP %d\r\n", rtpPayloadTypeJPEG)
+	fmt.Fprintf(&b, "a=control:trackID=0\r\n")
+	return b.Bytes()
+}
+
+func (c *rtspConn) respond(req *http.Request, status int, header http.Header, body []byte) error {
+	text, ok := rtspStatusTexts[status]
+	if !ok {
+		text = http.StatusText(status)
+	}
+	header.Set("CSeq", req.Header.Get("CSeq"))
+	header.Set("Server", "go-ios")
+	if len(body) > 0 {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "RTSP/1.0 %d %s\r\n", status, text)
+	header.Write(&b)
+	b.WriteString("\r\n")
+	b.Write(body)
+	return c.write(b.Bytes())
+}
+
+// write sends b to the player, responses and interleaved packets must not mix
+func (c *rtspConn) write(b []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(rtspWriteTimeout))
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// parseTransport picks the first unicast transport of a Transport header that the server supports
+func parseTransport(header string) (rtspTransport, bool) {
+	for _, spec := range strings.Split(header, ",") {
+		parts := strings.Split(strings.TrimSpace(spec), ";")
+		var transport rtspTransport
+		switch parts[0] {
+		case "RTP/AVP/TCP":
+			transport.tcp = true
+		case "RTP/AVP", "RTP/AVP/UDP":
+		default:
+			continue
+		}
+		supported := true
+		for _, parameter := range parts[1:] {
+			key, value, _ := strings.Cut(parameter, "=")
+			switch key {
+			case "multicast":
+				supported = false
+			case "interleaved":
+				first, _, _ := strings.Cut(value, "-")
+				channel, err := strconv.Atoi(first)
+				supported = supported && err == nil && channel >= 0 && channel < 255
+				transport.channel = channel
+			case "client_port":
+				first, _, _ := strings.Cut(value, "-")
+				port, err := strconv.Atoi(first)
+				if err == nil && port > 0 && port < 65535 {
+					transport.clientAddr = &net.UDPAddr{Port: port}
+				}
+			}
+		}
+		if supported && (transport.tcp || transport.clientAddr != nil) {
+			return transport, true
+		}
+	}
+	return rtspTransport{}, false
+}
+
+// contentBase is the url of a stream without credentials, the control urls of its tracks are relative to it
+func contentBase(u *url.URL) string {
+	base := *u
+	base.User = nil
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	return base.String()
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
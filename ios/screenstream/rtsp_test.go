@@ -0,0 +1,201 @@
+package screenstream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRTSPHandler struct {
+	frame   []byte
+	opened  atomic.Int32
+	stopped atomic.Int32
+}
+
+func (h *fakeRTSPHandler) Authorize(req *http.Request) error {
+	if _, password, _ := req.BasicAuth(); password != "token" {
+		return &RTSPError{Status: http.StatusUnauthorized, Message: "invalid credentials"}
+	}
+	if !strings.HasPrefix(req.URL.Path, "/device") {
+		return &RTSPError{Status: http.StatusNotFound, Message: "device not found"}
+	}
+	return nil
+}
+
+func (h *fakeRTSPHandler) Open(req *http.Request) (<-chan []byte, func(), error) {
+	h.opened.Add(1)
+	frames := make(chan []byte)
+	done := make(chan struct{})
+	go func() {
+		defer close(frames)
+		for {
+			select {
+			case frames <- h.frame:
+			case <-done:
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	return frames, func() {
+		h.stopped.Add(1)
+		close(done)
+	}, nil
+}
+
+type rtspClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	cseq   int
+}
+
+type rtspResponse struct {
+	status int
+	header textproto.MIMEHeader
+	body   string
+}
+
+func (c *rtspClient) request(method string, url string, header ...string) rtspResponse {
+	c.cseq++
+	request := fmt.Sprintf("%s %s RTSP/1.0\r\nCSeq: %d\r\nAuthorization: Basic dXNlcjp0b2tlbg==\r\n", method, url, c.cseq)
+	for _, h := range header {
+		request += h + "\r\n"
+	}
+	_, err := c.conn.Write([]byte(request + "\r\n"))
+	require.NoError(c.t, err)
+	for {
+		// skip interleaved packets that were sent before the response
+		first, err := c.reader.Peek(1)
+		require.NoError(c.t, err)
+		if first[0] != '$' {
+			break
+		}
+		c.readPacket()
+	}
+	reader := textproto.NewReader(c.reader)
+	line, err := reader.ReadLine()
+	require.NoError(c.t, err)
+	parts := strings.SplitN(line, " ", 3)
+	require.Equal(c.t, "RTSP/1.0", parts[0])
+	status, err := strconv.Atoi(parts[1])
+	require.NoError(c.t, err)
+	responseHeader, err := reader.ReadMIMEHeader()
+	require.NoError(c.t, err)
+	assert.Equal(c.t, strconv.Itoa(c.cseq), responseHeader.Get("CSeq"))
+	body := make([]byte, 0)
+	if length, _ := strconv.Atoi(responseHeader.Get("Content-Length")); length > 0 {
+		body = make([]byte, length)
+		_, err = io.ReadFull(c.reader, body)
+		require.NoError(c.t, err)
+	}
+	return rtspResponse{status: status, header: responseHeader, body: string(body)}
+}
+
+func (c *rtspClient) readPacket() (int, []byte) {
+	var header [4]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	require.NoError(c.t, err)
+	require.Equal(c.t, byte('$'), header[0])
+	packet := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	_, err = io.ReadFull(c.reader, packet)
+	require.NoError(c.t, err)
+	return int(header[1]), packet
+}
+
+func TestRTSPServer(t *testing.T) {
+	handler := &fakeRTSPHandler{frame: testJPEG(t, 64, 128)}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go NewRTSPServer(handler).Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	client := &rtspClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	url := "rtsp://" + l.Addr().String() + "/device"
+
+	response := client.request("OPTIONS", url)
+	assert.Equal(t, http.StatusOK, response.status)
+	assert.Contains(t, response.header.Get("Public"), "DESCRIBE")
+
+	response = client.request("DESCRIBE", "rtsp://"+l.Addr().String()+"/unknown")
+	assert.Equal(t, http.StatusNotFound, response.status)
+	response = client.request("DESCRIBE", url)
+	require.Equal(t, http.StatusOK, response.status)
+	assert.Equal(t, "application/sdp", response.header.Get("Content-Type"))
+	assert.Equal(t, url+"/", response.header.Get("Content-Base"))
+	assert.Contains(t, response.body, "m=video 0 RTP/AVP 26\r\n")
+	assert.Contains(t, response.body, "a=control:trackID=0\r\n")
+
+	assert.Equal(t, 455, client.request("PLAY", url).status, "play needs a setup")
+	assert.Equal(t, 461, client.request("SETUP", url+"/trackID=0", "Transport: RTP/AVP;multicast").status)
+	response = client.request("SETUP", url+"/trackID=0", "Transport: RTP/AVP;multicast,RTP/AVP/TCP;unicast;interleaved=2-3")
+	require.Equal(t, http.StatusOK, response.status)
+	assert.True(t, strings.HasPrefix(response.header.Get("Transport"), "RTP/AVP/TCP;unicast;interleaved=2-3;ssrc="))
+	session, timeout, _ := strings.Cut(response.header.Get("Session"), ";")
+	assert.Equal(t, "timeout=60", timeout)
+	assert.Equal(t, 454, client.request("PLAY", url, "Session: other").status)
+
+	response = client.request("PLAY", url, "Session: "+session)
+	require.Equal(t, http.StatusOK, response.status)
+	assert.Contains(t, response.header.Get("RTP-Info"), "url="+url+"/trackID=0;seq=")
+	channel, packet := client.readPacket()
+	assert.Equal(t, 2, channel)
+	assert.Equal(t, byte(rtpPayloadTypeJPEG), packet[1]&0x7F)
+	// an RTCP receiver report of the player is ignored
+	_, err = conn.Write([]byte{'$', 3, 0, 4, 0x81, 201, 0, 1})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, client.request("PAUSE", url, "Session: "+session).status)
+	assert.Equal(t, int32(1), handler.stopped.Load())
+	assert.Equal(t, http.StatusOK, client.request("PLAY", url, "Session: "+session).status)
+	assert.Equal(t, int32(2), handler.opened.Load())
+	assert.Equal(t, http.StatusOK, client.request("GET_PARAMETER", url, "Session: "+session).status)
+	assert.Equal(t, http.StatusOK, client.request("TEARDOWN", url, "Session: "+session).status)
+	assert.Equal(t, int32(2), handler.stopped.Load())
+	_, err = client.reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF, "the connection is closed after the teardown")
+}
+
+func TestRTSPServerUnauthorized(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go NewRTSPServer(&fakeRTSPHandler{}).Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("DESCRIBE rtsp://" + l.Addr().String() + "/device RTSP/1.0\r\nCSeq: 1\r\n\r\n"))
+	require.NoError(t, err)
+	response, err := textproto.NewReader(bufio.NewReader(conn)).ReadLine()
+	require.NoError(t, err)
+	assert.Equal(t, "RTSP/1.0 401 Unauthorized", response)
+}
+
+func TestParseTransport(t *testing.T) {
+	transport, ok := parseTransport("RTP/AVP;unicast;client_port=5000-5001")
+	require.True(t, ok)
+	assert.False(t, transport.tcp)
+	assert.Equal(t, 5000, transport.clientAddr.Port)
+	transport, ok = parseTransport("RTP/AVP/TCP;unicast;interleaved=0-1")
+	require.True(t, ok)
+	assert.True(t, transport.tcp)
+	assert.Equal(t, 0, transport.channel)
+	for _, header := range []string{"", "RTP/AVP;unicast", "RTP/AVP;multicast;client_port=5000-5001", "RTP/SAVP;unicast;client_port=5000-5001"} {
+		_, ok = parseTransport(header)
+		assert.False(t, ok, header)
+	}
+}
@@ -0,0 +1,249 @@
+// Package screenstream captures the screen of a device continuously and serves it as MJPEG, the format browsers
+// show in a plain img tag, or over RTSP for players like VLC and ffplay. Frames are png screenshots of a FrameSource,
+// scaled and encoded as jpeg once and shared by all viewers of a Stream, so more viewers do not mean more load on the device.
+package screenstream
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FrameSource delivers screenshots of a device, f.ex. the instruments screenshot service
+type FrameSource interface {
+	// Capture returns the current screen as png
+	Capture() ([]byte, error)
+	Close()
+}
+
+// Options configure how frames of a Stream are encoded
+type Options struct {
+	// Scale is between 0 and 1, frames are only scaled down
+	Scale float64
+	// Quality of the jpeg frames from 1 to 100
+	Quality int
+	// MaxFPS limits how often frames are captured, the screenshot services deliver 5-15 frames per second
+	// depending on the device, so it mostly matters for lower rates
+	MaxFPS int
+	// MaxSide scales frames down further until neither side is larger, 0 means no limit. RTSP needs it,
+	// RFC 2435 can't describe frames larger than MaxRTPSide.
+	MaxSide int
+}
+
+// DefaultOptions are full size frames with a quality that is good enough for watching a device
+func DefaultOptions() Options {
+	return Options{Scale: 1, Quality: 80, MaxFPS: 10}
+}
+
+// Validate checks the ranges of the options
+func (o Options) Validate() error {
+	if o.Scale <= 0 || o.Scale > 1 {
+		return fmt.Errorf("scale must be greater than 0 and at most 1")
+	}
+	if o.Quality < 1 || o.Quality > 100 {
+		return fmt.Errorf("quality must be from 1 to 100")
+	}
+	if o.MaxFPS < 1 || o.MaxFPS > 30 {
+		return fmt.Errorf("fps must be from 1 to 30")
+	}
+	if o.MaxSide < 0 {
+		return fmt.Errorf("max side must not be negative")
+	}
+	return nil
+}
+
+// Stream captures frames from a FrameSource until it is closed or capturing fails and hands the latest frame
+// to every subscriber. Slow subscribers skip frames instead of slowing down the others.
+type Stream struct {
+	source  FrameSource
+	options Options
+
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+	err         error
+	closed      bool
+	done        chan struct{}
+	stop        chan struct{}
+}
+
+// New starts capturing frames from source. The source is closed when the stream ends.
+func New(source FrameSource, options Options) *Stream {
+	s := &Stream{
+		source:      source,
+		options:     options,
+		subscribers: map[chan []byte]struct{}{},
+		done:        make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *Stream) run() {
+	defer close(s.done)
+	defer s.source.Close()
+	interval := time.Second / time.Duration(s.options.MaxFPS)
+	for {
+		start := time.Now()
+		frame, err := s.capture()
+		if err != nil {
+			s.finish(err)
+			return
+		}
+		s.broadcast(frame)
+		select {
+		case <-s.stop:
+			s.finish(nil)
+			return
+		case <-time.After(interval - time.Since(start)):
+		}
+	}
+}
+
+func (s *Stream) capture() ([]byte, error) {
+	png, err := s.source.Capture()
+	if err != nil {
+		return nil, fmt.Errorf("capture: failed taking screenshot: %w", err)
+	}
+	return encodeJPEG(png, s.options.Scale, s.options.MaxSide, s.options.Quality)
+}
+
+func (s *Stream) broadcast(frame []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.subscribers {
+		select {
+		case c <- frame:
+		default:
+			// the subscriber did not take the previous frame yet, replace it with the new one
+			select {
+			case <-c:
+			default:
+			}
+			c <- frame
+		}
+	}
+}
+
+func (s *Stream) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	s.closed = true
+	for c := range s.subscribers {
+		close(c)
+	}
+	s.subscribers = map[chan []byte]struct{}{}
+	if err != nil {
+		log.WithError(err).Warn("screen stream stopped")
+	}
+}
+
+// Subscribe returns a channel with the jpeg frames of the stream, it is closed when the stream ends.
+// Call the returned func to unsubscribe.
+func (s *Stream) Subscribe() (<-chan []byte, func()) {
+	c := make(chan []byte, 1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(c)
+		return c, func() {}
+	}
+	s.subscribers[c] = struct{}{}
+	return c, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscribers[c]; ok {
+			delete(s.subscribers, c)
+			close(c)
+		}
+	}
+}
+
+// Close stops capturing and waits until the source is closed
+func (s *Stream) Close() {
+	s.mu.Lock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+// Done is closed when the stream ended
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the stream ended, it is nil while the stream runs or if it was closed
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// EncodeJPEG decodes a png or jpeg screenshot, scales it down by scale and encodes it as jpeg
+func EncodeJPEG(screenshot []byte, scale float64, quality int) ([]byte, error) {
+	return encodeJPEG(screenshot, scale, 0, quality)
+}
+
+func encodeJPEG(screenshot []byte, scale float64, maxSide int, quality int) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(screenshot))
+	if err != nil {
+		return nil, fmt.Errorf("EncodeJPEG: failed decoding screenshot: %w", err)
+	}
+	if maxSide > 0 {
+		longest := math.Max(float64(img.Bounds().Dx()), float64(img.Bounds().Dy()))
+		// scaled sides are rounded, stay a little below the limit
+		scale = math.Min(scale, (float64(maxSide)-0.5)/longest)
+	}
+	if scale < 1 {
+		img = Downscale(img, scale)
+	}
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return nil, fmt.Errorf("EncodeJPEG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Downscale scales img by scale with a box filter, every target pixel is the average of the source pixels it covers.
+// That keeps text readable, nearest neighbour scaling drops whole lines of pixels.
+func Downscale(img image.Image, scale float64) *image.RGBA {
+	bounds := img.Bounds()
+	width := int(math.Max(1, math.Round(float64(bounds.Dx())*scale)))
+	height := int(math.Max(1, math.Round(float64(bounds.Dy())*scale)))
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			i := result.PixOffset(x, y)
+			result.Pix[i] = uint8(r / n >> 8)
+			result.Pix[i+1] = uint8(g / n >> 8)
+			result.Pix[i+2] = uint8(b / n >> 8)
+			result.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return result
+}
@@ -0,0 +1,130 @@
+package screenstream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSource struct {
+	png      []byte
+	captures atomic.Int32
+	failAt   int32
+	closed   atomic.Bool
+}
+
+func (s *fakeSource) Capture() ([]byte, error) {
+	n := s.captures.Add(1)
+	if s.failAt > 0 && n >= s.failAt {
+		return nil, errors.New("device gone")
+	}
+	return s.png, nil
+}
+
+func (s *fakeSource) Close() {
+	s.closed.Store(true)
+}
+
+func testPNG(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 40; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 6), G: uint8(y * 3), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestStream(t *testing.T) {
+	source := &fakeSource{png: testPNG(t)}
+	stream := New(source, Options{Scale: 0.5, Quality: 70, MaxFPS: 30})
+	frames, unsubscribe := stream.Subscribe()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case frame := <-frames:
+			img, err := jpeg.Decode(bytes.NewReader(frame))
+			require.NoError(t, err)
+			assert.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds())
+		case <-time.After(5 * time.Second):
+			t.Fatal("no frame")
+		}
+	}
+	unsubscribe()
+	unsubscribe()
+	stream.Close()
+	assert.True(t, source.closed.Load())
+	assert.NoError(t, stream.Err())
+
+	_, ok := <-frames
+	assert.False(t, ok)
+	late, _ := stream.Subscribe()
+	_, ok = <-late
+	assert.False(t, ok, "subscribing to a closed stream returns a closed channel")
+}
+
+func TestStreamMaxSide(t *testing.T) {
+	stream := New(&fakeSource{png: testPNG(t)}, Options{Scale: 1, Quality: 70, MaxFPS: 30, MaxSide: 40})
+	defer stream.Close()
+	frames, _ := stream.Subscribe()
+	select {
+	case frame := <-frames:
+		img, err := jpeg.Decode(bytes.NewReader(frame))
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds())
+	case <-time.After(5 * time.Second):
+		t.Fatal("no frame")
+	}
+}
+
+func TestStreamFails(t *testing.T) {
+	source := &fakeSource{png: testPNG(t), failAt: 2}
+	stream := New(source, Options{Scale: 1, Quality: 70, MaxFPS: 30})
+	frames, _ := stream.Subscribe()
+	select {
+	case <-stream.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not stop")
+	}
+	assert.ErrorContains(t, stream.Err(), "device gone")
+	for range frames {
+	}
+	assert.True(t, source.closed.Load())
+}
+
+func TestServeMJPEG(t *testing.T) {
+	frames := make(chan []byte, 2)
+	frames <- []byte("frame1")
+	frames <- []byte("frame2")
+	close(frames)
+	w := httptest.NewRecorder()
+	require.NoError(t, ServeMJPEG(context.Background(), w, frames))
+	assert.Equal(t, "multipart/x-mixed-replace; boundary=go-ios-frame", w.Header().Get("Content-Type"))
+	assert.Equal(t, 2, strings.Count(w.Body.String(), "--go-ios-frame\r\nContent-Type: image/jpeg\r\nContent-Length: 6\r\n\r\n"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, ServeMJPEG(ctx, httptest.NewRecorder(), make(chan []byte)))
+}
+
+func TestOptionsValidate(t *testing.T) {
+	assert.NoError(t, DefaultOptions().Validate())
+	assert.Error(t, Options{Scale: 0, Quality: 80, MaxFPS: 10}.Validate())
+	assert.Error(t, Options{Scale: 1, Quality: 0, MaxFPS: 10}.Validate())
+	assert.Error(t, Options{Scale: 1, Quality: 80, MaxFPS: 60}.Validate())
+	assert.Error(t, Options{Scale: 1, Quality: 80, MaxFPS: 10, MaxSide: -1}.Validate())
+}
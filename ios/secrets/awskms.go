@@ -0,0 +1,208 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// AWSCredentials are the credentials requests to AWS KMS are signed with
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is only set for temporary credentials
+	SessionToken string
+}
+
+// AWSKMSProvider wraps data keys with a symmetric KMS key, using the Encrypt and Decrypt actions of the KMS API.
+// Requests are signed with signature version 4, so no AWS SDK is needed.
+type AWSKMSProvider struct {
+	keyID       string
+	region      string
+	credentials AWSCredentials
+	endpoint    string
+	client      *http.Client
+	now         func() time.Time
+}
+
+// NewAWSKMSProvider creates a provider for the KMS key keyID, which can be a key id, alias or arn
+func NewAWSKMSProvider(keyID string, region string, credentials AWSCredentials) AWSKMSProvider {
+	return AWSKMSProvider{
+		keyID:       keyID,
+		region:      region,
+		credentials: credentials,
+		endpoint:    fmt.Sprintf("https://kms.%s.amazonaws.com/", region),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		now:         time.Now,
+	}
+}
+
+// NewAWSKMSProviderFromEnv creates a provider with the credentials in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN. The region is taken from the key arn, AWS_REGION or AWS_DEFAULT_REGION.
+func NewAWSKMSProviderFromEnv(keyID string) (AWSKMSProvider, error) {
+	credentials := AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return AWSKMSProvider{}, fmt.Errorf("NewAWSKMSProviderFromEnv: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	region := regionFromARN(keyID)
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		return AWSKMSProvider{}, fmt.Errorf("NewAWSKMSProviderFromEnv: use a key arn or set AWS_REGION")
+	}
+	return NewAWSKMSProvider(keyID, region, credentials), nil
+}
+
+// regionFromARN returns the region of arn:aws:kms:<region>:<account>:key/<id>, or an empty string for key ids
+func regionFromARN(keyID string) string {
+	parts := strings.Split(keyID, ":")
+	if len(parts) < 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[3]
+}
+
+// Name returns awskms
+func (p AWSKMSProvider) Name() string {
+	return "awskms"
+}
+
+// WrapKey encrypts key with the KMS key
+func (p AWSKMSProvider) WrapKey(key []byte) ([]byte, error) {
+	var response struct {
+		CiphertextBlob []byte
+	}
+	err := p.call("Encrypt", map[string]interface{}{"KeyId": p.keyID, "Plaintext": key}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.CiphertextBlob, nil
+}
+
+// UnwrapKey decrypts a key wrapped by WrapKey
+func (p AWSKMSProvider) UnwrapKey(wrapped []byte) ([]byte, error) {
+	var response struct {
+		Plaintext []byte
+	}
+	err := p.call("Decrypt", map[string]interface{}{"KeyId": p.keyID, "CiphertextBlob": wrapped}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Plaintext, nil
+}
+
+// call runs a KMS action, []byte values are base64 encoded in json like KMS expects them
+func (p AWSKMSProvider) call(action string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: %w", action, err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	p.sign(req, body)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: kms returned %d: %s", action, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	err = json.Unmarshal(respBody, response)
+	if err != nil {
+		return fmt.Errorf("%s: invalid kms response: %w", action, err)
+	}
+	return nil
+}
+
+// sign adds the signature version 4 Authorization header to req
+func (p AWSKMSProvider) sign(req *http.Request, body []byte) {
+	now := p.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if p.credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.credentials.SessionToken)
+	}
+	host := req.URL.Host
+	req.Host = host
+
+	// headers have to be sorted by their lower case names
+	headers := [][2]string{
+		{"content-type", req.Header.Get("Content-Type")},
+		{"host", host},
+		{"x-amz-date", amzDate},
+	}
+	if p.credentials.SessionToken != "" {
+		headers = append(headers, [2]string{"x-amz-security-token", p.credentials.SessionToken})
+	}
+	headers = append(headers, [2]string{"x-amz-target", req.Header.Get("X-Amz-Target")})
+
+	var canonicalHeaders strings.Builder
+	names := make([]string, 0, len(headers))
+	for _, header := range headers {
+		canonicalHeaders.WriteString(header[0] + ":" + strings.TrimSpace(header[1]) + "\n")
+		names = append(names, header[0])
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		"", // KMS requests have no query string
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/kms/aws4_request", date, p.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+p.credentials.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, p.region)
+	signingKey = hmacSHA256(signingKey, "kms")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,77 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// LocalKeyProvider wraps data keys with a 256 bit master key from a key file. Keep the key file on a different
+// volume than the sealed secrets, or use a KMS, otherwise a copy of the disk still contains everything.
+type LocalKeyProvider struct {
+	key []byte
+}
+
+// NewLocalKeyProvider reads the master key from path, the file contains 32 raw bytes or 64 hex characters
+func NewLocalKeyProvider(path string) (LocalKeyProvider, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return LocalKeyProvider{}, fmt.Errorf("NewLocalKeyProvider: failed reading key file: %w", err)
+	}
+	if len(content) == 32 {
+		return LocalKeyProvider{key: content}, nil
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(content)))
+	if err != nil || len(key) != 32 {
+		return LocalKeyProvider{}, fmt.Errorf("NewLocalKeyProvider: %s must contain a 256 bit key as 32 bytes or 64 hex characters", path)
+	}
+	return LocalKeyProvider{key: key}, nil
+}
+
+// GenerateKeyFile writes a new random master key as hex to path, only readable by the current user.
+// It fails if the file exists already, overwriting a key makes everything sealed with it unreadable.
+func GenerateKeyFile(path string) error {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return fmt.Errorf("GenerateKeyFile: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("GenerateKeyFile: %w", err)
+	}
+	defer f.Close()
+	_, err = f.WriteString(hex.EncodeToString(key) + "\n")
+	if err != nil {
+		return fmt.Errorf("GenerateKeyFile: %w", err)
+	}
+	return nil
+}
+
+// Name returns local
+func (p LocalKeyProvider) Name() string {
+	return "local"
+}
+
+// WrapKey encrypts key with the master key, the nonce is prepended to the result
+func (p LocalKeyProvider) WrapKey(key []byte) ([]byte, error) {
+	nonce, ciphertext, err := aesGCMSeal(p.key, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+// UnwrapKey decrypts a key wrapped by WrapKey
+func (p LocalKeyProvider) UnwrapKey(wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(p.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("UnwrapKey: wrapped key too short")
+	}
+	return aesGCMOpen(p.key, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():])
+}
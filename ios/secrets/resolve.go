@@ -0,0 +1,174 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EnvPrefix starts the names of the environment variables env: references can read. Other variables of the
+// agent, like VAULT_TOKEN or the keys of the API, must never end up in a test runner a client controls.
+const EnvPrefix = "GO_IOS_SECRET_"
+
+// VaultPrefixEnvVar configures the mount and path prefix vault: references are restricted to, f.ex. secret/ci.
+// vault: references are refused if it is not set, VAULT_TOKEN can usually read more than test runs should get.
+const VaultPrefixEnvVar = "GO_IOS_SECRETS_VAULT_PREFIX"
+
+// Resolver fetches secrets by reference when a test run is launched, so job definitions only contain the
+// references and never the secrets
+type Resolver struct {
+	// Provider opens sealed values, it is nil if GO_IOS_KMS is not set
+	Provider KeyProvider
+	// VaultAddr and VaultToken are the Vault vault: references are read from
+	VaultAddr  string
+	VaultToken string
+	// VaultPrefix is the <mount>/<path> all vault: references have to be below
+	VaultPrefix string
+	client      *http.Client
+	lookupEnv   func(string) (string, bool)
+}
+
+// ResolverFromEnv creates a Resolver with the key provider of GO_IOS_KMS and the Vault at VAULT_ADDR with VAULT_TOKEN,
+// restricted to GO_IOS_SECRETS_VAULT_PREFIX
+func ResolverFromEnv() (Resolver, error) {
+	provider, err := ProviderFromEnv()
+	if err != nil {
+		return Resolver{}, err
+	}
+	return NewResolver(provider, os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN"), os.Getenv(VaultPrefixEnvVar)), nil
+}
+
+// NewResolver creates a Resolver, provider and the Vault are only needed for the references that use them.
+// vault: references are only resolved below vaultPrefix.
+func NewResolver(provider KeyProvider, vaultAddr string, vaultToken string, vaultPrefix string) Resolver {
+	return Resolver{
+		Provider:    provider,
+		VaultAddr:   strings.TrimSuffix(vaultAddr, "/"),
+		VaultToken:  vaultToken,
+		VaultPrefix: strings.Trim(vaultPrefix, "/"),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		lookupEnv:   os.LookupEnv,
+	}
+}
+
+// ValidReference checks the scheme of a reference without fetching the secret. Errors don't contain the
+// reference, in case a secret was passed instead of a reference by mistake.
+func ValidReference(ref string) error {
+	scheme, arg, found := strings.Cut(ref, ":")
+	if !found || arg == "" {
+		return fmt.Errorf("invalid secret reference, use vault:<mount>/<path>#<field>, env:%s<name> or enc:<sealed value>", EnvPrefix)
+	}
+	switch scheme {
+	case "vault":
+		path, field, _ := strings.Cut(arg, "#")
+		if !strings.Contains(path, "/") || field == "" {
+			return fmt.Errorf("invalid vault reference, use vault:<mount>/<path>#<field>")
+		}
+		for _, segment := range strings.Split(path, "/") {
+			if segment == "" || segment == "." || segment == ".." {
+				return fmt.Errorf("invalid vault reference, the path must not contain empty, . or .. segments")
+			}
+		}
+		return nil
+	case "env":
+		if !strings.HasPrefix(arg, EnvPrefix) || arg == EnvPrefix {
+			return fmt.Errorf("invalid env reference, only variables starting with %s can be read", EnvPrefix)
+		}
+		return nil
+	case "enc":
+		return nil
+	default:
+		return fmt.Errorf("unknown secret backend, use vault, env or enc")
+	}
+}
+
+// Resolve returns the secret a reference points to
+//   - vault:<mount>/<path>#<field> reads a field of a secret of a KV version 2 engine of Vault below VaultPrefix
+//   - env:GO_IOS_SECRET_<name> reads an environment variable of the agent, its value can be sealed with 'ios secrets seal-value'
+//   - enc:<sealed value> is a value sealed with 'ios secrets seal-value'
+func (r Resolver) Resolve(ref string) (string, error) {
+	err := ValidReference(ref)
+	if err != nil {
+		return "", err
+	}
+	scheme, arg, _ := strings.Cut(ref, ":")
+	switch scheme {
+	case "vault":
+		return r.readVault(arg)
+	case "env":
+		value, ok := r.lookupEnv(arg)
+		if !ok {
+			return "", fmt.Errorf("Resolve: environment variable %s is not set", arg)
+		}
+		return OpenString(r.Provider, value)
+	default:
+		return OpenString(r.Provider, ref)
+	}
+}
+
+func (r Resolver) readVault(arg string) (string, error) {
+	if r.VaultAddr == "" || r.VaultToken == "" {
+		return "", fmt.Errorf("readVault: VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	if r.VaultPrefix == "" {
+		return "", fmt.Errorf("readVault: %s must be set to read secrets from vault", VaultPrefixEnvVar)
+	}
+	path, field, _ := strings.Cut(arg, "#")
+	if path != r.VaultPrefix && !strings.HasPrefix(path, r.VaultPrefix+"/") {
+		return "", fmt.Errorf("readVault: %s is not below %s", path, r.VaultPrefix)
+	}
+	mount, path, _ := strings.Cut(path, "/")
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/%s/data/%s", r.VaultAddr, mount, path), nil)
+	if err != nil {
+		return "", fmt.Errorf("readVault: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", r.VaultToken)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("readVault: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("readVault: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// the body of errors never contains the secret
+		return "", fmt.Errorf("readVault: vault returned %d for %s/%s: %s", resp.StatusCode, mount, path, strings.TrimSpace(string(body)))
+	}
+	var response struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return "", fmt.Errorf("readVault: invalid vault response: %w", err)
+	}
+	value, ok := response.Data.Data[field]
+	if !ok {
+		return "", fmt.Errorf("readVault: %s/%s has no field %s", mount, path, field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// Redact replaces every occurrence of the values in s with ***. Longer values are replaced first, so a secret that
+// contains another one is not left partly visible.
+func Redact(s string, values []string) string {
+	sorted := append([]string{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, value := range sorted {
+		if value != "" {
+			s = strings.ReplaceAll(s, value, "***")
+		}
+	}
+	return s
+}
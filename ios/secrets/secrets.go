@@ -0,0 +1,210 @@
+// Package secrets encrypts sensitive material like pair records, supervision identities and auth tokens at rest.
+// Data is encrypted with AES-GCM and a random data key per secret, the data key is wrapped by a KeyProvider,
+// which can be a local key file, AWS KMS or the transit engine of HashiCorp Vault. Sealed data is self-describing,
+// so readers can tell it apart from plaintext and existing plaintext files keep working during a migration.
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KMSEnvVar configures the KeyProvider of ProviderFromEnv, f.ex. local:/etc/go-ios/master.key,
+// awskms:arn:aws:kms:eu-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab or vault:go-ios
+const KMSEnvVar = "GO_IOS_KMS"
+
+// sealedPrefix starts every sealed secret
+var sealedPrefix = []byte("go-ios-sealed:v1\n")
+
+// sealedStringPrefix marks sealed values in strings like environment variables
+const sealedStringPrefix = "enc:"
+
+// KeyProvider wraps the data keys secrets are encrypted with. Implementations must be safe for concurrent use.
+type KeyProvider interface {
+	// Name is stored with every sealed secret, so opening it with a different provider fails with a clear error
+	Name() string
+	WrapKey(key []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+type envelope struct {
+	Provider   string `json:"provider"`
+	WrappedKey []byte `json:"wrappedKey"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// IsSealed tells whether data was created by Seal
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedPrefix)
+}
+
+// Seal encrypts plaintext with a new data key that gets wrapped by provider
+func Seal(provider KeyProvider, plaintext []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("Seal: failed creating data key: %w", err)
+	}
+	nonce, ciphertext, err := aesGCMSeal(key, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("Seal: %w", err)
+	}
+	wrapped, err := provider.WrapKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Seal: failed wrapping data key with %s: %w", provider.Name(), err)
+	}
+	b, err := json.Marshal(envelope{Provider: provider.Name(), WrappedKey: wrapped, Nonce: nonce, Ciphertext: ciphertext})
+	if err != nil {
+		return nil, fmt.Errorf("Seal: %w", err)
+	}
+	return append(append([]byte{}, sealedPrefix...), b...), nil
+}
+
+// Open decrypts data created by Seal
+func Open(provider KeyProvider, data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return nil, fmt.Errorf("Open: data is not sealed")
+	}
+	var e envelope
+	err := json.Unmarshal(data[len(sealedPrefix):], &e)
+	if err != nil {
+		return nil, fmt.Errorf("Open: invalid sealed data: %w", err)
+	}
+	if e.Provider != provider.Name() {
+		return nil, fmt.Errorf("Open: data was sealed with %s, not %s", e.Provider, provider.Name())
+	}
+	key, err := provider.UnwrapKey(e.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("Open: failed unwrapping data key with %s: %w", provider.Name(), err)
+	}
+	plaintext, err := aesGCMOpen(key, e.Nonce, e.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	return plaintext, nil
+}
+
+// ReadFile reads a file and opens it if it is sealed. Plaintext files are returned as they are, provider can
+// be nil if no KeyProvider is configured, sealed files fail then.
+func ReadFile(provider KeyProvider, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !IsSealed(data) {
+		return data, nil
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("ReadFile: %s is sealed but no key provider is configured, set %s", path, KMSEnvVar)
+	}
+	return Open(provider, data)
+}
+
+// WriteFile seals data and writes it to path. Data is written in plaintext if provider is nil.
+func WriteFile(provider KeyProvider, path string, data []byte, perm os.FileMode) error {
+	if provider != nil {
+		sealed, err := Seal(provider, data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
+	return os.WriteFile(path, data, perm)
+}
+
+// SealString seals value for places that only take strings, like environment variables. The result starts with enc:
+func SealString(provider KeyProvider, value string) (string, error) {
+	sealed, err := Seal(provider, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return sealedStringPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenString opens values created by SealString, other values are returned as they are
+func OpenString(provider KeyProvider, value string) (string, error) {
+	if !strings.HasPrefix(value, sealedStringPrefix) {
+		return value, nil
+	}
+	if provider == nil {
+		return "", fmt.Errorf("OpenString: value is sealed but no key provider is configured, set %s", KMSEnvVar)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedStringPrefix))
+	if err != nil {
+		return "", fmt.Errorf("OpenString: invalid sealed value: %w", err)
+	}
+	plaintext, err := Open(provider, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// ProviderFromEnv creates the KeyProvider configured with GO_IOS_KMS. It returns nil if GO_IOS_KMS is not set.
+//   - local:<path> uses the key in a local key file, see GenerateKeyFile
+//   - awskms:<key id or arn> uses AWS KMS with the credentials and region of the AWS_* environment variables
+//   - vault:[<mount>/]<key> uses the transit engine of Vault at VAULT_ADDR with VAULT_TOKEN, the mount defaults to transit
+func ProviderFromEnv() (KeyProvider, error) {
+	config := os.Getenv(KMSEnvVar)
+	if config == "" {
+		return nil, nil
+	}
+	kind, arg, found := strings.Cut(config, ":")
+	if !found || arg == "" {
+		return nil, fmt.Errorf("ProviderFromEnv: invalid %s '%s', use local:<path>, awskms:<key id> or vault:<key>", KMSEnvVar, config)
+	}
+	switch kind {
+	case "local":
+		return NewLocalKeyProvider(arg)
+	case "awskms":
+		return NewAWSKMSProviderFromEnv(arg)
+	case "vault":
+		return NewVaultProviderFromEnv(arg)
+	default:
+		return nil, fmt.Errorf("ProviderFromEnv: unknown key provider '%s', use local, awskms or vault", kind)
+	}
+}
+
+func aesGCMSeal(key []byte, plaintext []byte) ([]byte, []byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed creating nonce: %w", err)
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func aesGCMOpen(key []byte, nonce []byte, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length %d", len(nonce))
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed, wrong key or tampered data: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,273 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLocalProvider(t *testing.T) LocalKeyProvider {
+	path := filepath.Join(t.TempDir(), "master.key")
+	require.NoError(t, GenerateKeyFile(path))
+	provider, err := NewLocalKeyProvider(path)
+	require.NoError(t, err)
+	return provider
+}
+
+func TestSealOpen(t *testing.T) {
+	provider := newLocalProvider(t)
+	sealed, err := Seal(provider, []byte("p12 content"))
+	require.NoError(t, err)
+	assert.True(t, IsSealed(sealed))
+	assert.NotContains(t, string(sealed), "p12 content")
+
+	plaintext, err := Open(provider, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "p12 content", string(plaintext))
+
+	t.Run("other key fails", func(t *testing.T) {
+		_, err := Open(newLocalProvider(t), sealed)
+		assert.Error(t, err)
+	})
+	t.Run("tampered data fails", func(t *testing.T) {
+		var e envelope
+		require.NoError(t, json.Unmarshal(sealed[len(sealedPrefix):], &e))
+		e.Ciphertext[0] ^= 0xff
+		b, _ := json.Marshal(e)
+		_, err := Open(provider, append(append([]byte{}, sealedPrefix...), b...))
+		assert.Error(t, err)
+	})
+	t.Run("other provider fails", func(t *testing.T) {
+		_, err := Open(NewVaultProvider("http://localhost", "token", "transit", "go-ios"), sealed)
+		assert.ErrorContains(t, err, "sealed with local")
+	})
+}
+
+func TestFiles(t *testing.T) {
+	provider := newLocalProvider(t)
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, "plain")
+	sealedPath := filepath.Join(dir, "sealed")
+	require.NoError(t, WriteFile(nil, plainPath, []byte("plain"), 0o600))
+	require.NoError(t, WriteFile(provider, sealedPath, []byte("secret"), 0o600))
+
+	content, err := ReadFile(provider, plainPath)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(content), "plaintext files are still readable")
+
+	content, err = ReadFile(provider, sealedPath)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(content))
+
+	_, err = ReadFile(nil, sealedPath)
+	assert.ErrorContains(t, err, KMSEnvVar)
+}
+
+func TestStrings(t *testing.T) {
+	provider := newLocalProvider(t)
+	sealed, err := SealString(provider, "admin-token")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, "enc:"))
+
+	value, err := OpenString(provider, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "admin-token", value)
+
+	value, err = OpenString(nil, "plain-token")
+	require.NoError(t, err)
+	assert.Equal(t, "plain-token", value)
+
+	_, err = OpenString(nil, sealed)
+	assert.Error(t, err)
+}
+
+func TestLocalKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "master.key")
+	require.NoError(t, GenerateKeyFile(path))
+	assert.Error(t, GenerateKeyFile(path), "existing keys are never overwritten")
+
+	raw := filepath.Join(dir, "raw.key")
+	require.NoError(t, os.WriteFile(raw, make([]byte, 32), 0o600))
+	_, err := NewLocalKeyProvider(raw)
+	assert.NoError(t, err)
+
+	short := filepath.Join(dir, "short.key")
+	require.NoError(t, os.WriteFile(short, []byte("abcd"), 0o600))
+	_, err = NewLocalKeyProvider(short)
+	assert.Error(t, err)
+}
+
+func TestProviderFromEnv(t *testing.T) {
+	t.Setenv(KMSEnvVar, "")
+	provider, err := ProviderFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, provider)
+
+	path := filepath.Join(t.TempDir(), "master.key")
+	require.NoError(t, GenerateKeyFile(path))
+	t.Setenv(KMSEnvVar, "local:"+path)
+	provider, err = ProviderFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "local", provider.Name())
+
+	t.Setenv("VAULT_ADDR", "http://vault:8200")
+	t.Setenv("VAULT_TOKEN", "token")
+	t.Setenv(KMSEnvVar, "vault:transit-lab/go-ios")
+	provider, err = ProviderFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "transit-lab", provider.(VaultProvider).mount)
+	assert.Equal(t, "go-ios", provider.(VaultProvider).key)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv(KMSEnvVar, "awskms:arn:aws:kms:eu-west-1:111122223333:key/1234")
+	provider, err = ProviderFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "eu-west-1", provider.(AWSKMSProvider).region)
+
+	t.Setenv(KMSEnvVar, "awskms:1234")
+	_, err = ProviderFromEnv()
+	assert.Error(t, err, "key ids need a region")
+
+	t.Setenv(KMSEnvVar, "gcpkms:key")
+	_, err = ProviderFromEnv()
+	assert.Error(t, err)
+}
+
+func TestVaultProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token", r.Header.Get("X-Vault-Token"))
+		var request map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/go-ios":
+			// fake transit engine that prefixes the plaintext like real ciphertexts are
+			w.Write([]byte(`{"data":{"ciphertext":"vault:v1:` + request["plaintext"] + `"}}`))
+		case "/v1/transit/decrypt/go-ios":
+			w.Write([]byte(`{"data":{"plaintext":"` + strings.TrimPrefix(request["ciphertext"], "vault:v1:") + `"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":["no handler for route"]}`))
+		}
+	}))
+	defer server.Close()
+
+	provider := NewVaultProvider(server.URL, "token", "transit", "go-ios")
+	sealed, err := Seal(provider, []byte("secret"))
+	require.NoError(t, err)
+	plaintext, err := Open(provider, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+
+	_, err = NewVaultProvider(server.URL, "token", "other", "go-ios").WrapKey([]byte("key"))
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestAWSKMSProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "20240116T150000Z", r.Header.Get("X-Amz-Date"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"),
+			"AWS4-HMAC-SHA256 Credential=AKID/20240116/eu-west-1/kms/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-target, Signature="))
+		var request map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, "alias/go-ios", request["KeyId"])
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			// fake kms that reverses the key
+			key, _ := base64.StdEncoding.DecodeString(request["Plaintext"])
+			w.Write([]byte(`{"CiphertextBlob":"` + base64.StdEncoding.EncodeToString(reverse(key)) + `"}`))
+		case "TrentService.Decrypt":
+			blob, _ := base64.StdEncoding.DecodeString(request["CiphertextBlob"])
+			w.Write([]byte(`{"Plaintext":"` + base64.StdEncoding.EncodeToString(reverse(blob)) + `"}`))
+		}
+	}))
+	defer server.Close()
+
+	provider := NewAWSKMSProvider("alias/go-ios", "eu-west-1", AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"})
+	provider.endpoint = server.URL + "/"
+	provider.now = func() time.Time { return time.Date(2024, 1, 16, 15, 0, 0, 0, time.UTC) }
+
+	sealed, err := Seal(provider, []byte("secret"))
+	require.NoError(t, err)
+	plaintext, err := Open(provider, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+}
+
+func reverse(b []byte) []byte {
+	result := make([]byte, len(b))
+	for i := range b {
+		result[len(b)-1-i] = b[i]
+	}
+	return result
+}
+
+func TestResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token", r.Header.Get("X-Vault-Token"))
+		if r.URL.Path != "/v1/secret/data/ci/account" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"password":"hunter2","pin":1234},"metadata":{"version":3}}}`))
+	}))
+	defer server.Close()
+	provider := newLocalProvider(t)
+	sealed, err := SealString(provider, "sealed token")
+	require.NoError(t, err)
+	resolver := NewResolver(provider, server.URL+"/", "token", "/secret/ci/")
+	resolver.lookupEnv = func(name string) (string, bool) {
+		values := map[string]string{"GO_IOS_SECRET_API_TOKEN": "plain token", "GO_IOS_SECRET_SEALED_TOKEN": sealed, "VAULT_TOKEN": "token"}
+		value, ok := values[name]
+		return value, ok
+	}
+
+	for ref, expected := range map[string]string{
+		"vault:secret/ci/account#password": "hunter2",
+		"vault:secret/ci/account#pin":      "1234",
+		"env:GO_IOS_SECRET_API_TOKEN":      "plain token",
+		"env:GO_IOS_SECRET_SEALED_TOKEN":   "sealed token",
+		sealed:                             "sealed token",
+	} {
+		value, err := resolver.Resolve(ref)
+		require.NoError(t, err, ref)
+		assert.Equal(t, expected, value, ref)
+	}
+	for _, ref := range []string{
+		"vault:secret/ci/account#missing", "vault:secret/ci/other#password", "env:GO_IOS_SECRET_MISSING", "env:VAULT_TOKEN",
+		"vault:secret/prod/account#password", "vault:secret/ci2/account#password", "vault:secret/ci/../prod/account#password",
+	} {
+		_, err := resolver.Resolve(ref)
+		assert.Error(t, err, ref)
+	}
+	for _, ref := range []string{
+		"hunter2", "vault:account#password", "vault:secret/ci/account", "vault:secret//ci#password", "file:/etc/passwd",
+		"env:", "env:HOME", "env:GO_IOS_SECRET_",
+	} {
+		assert.Error(t, ValidReference(ref), ref)
+	}
+	assert.NotContains(t, ValidReference("hunter2").Error(), "hunter2", "a secret passed as reference is not echoed")
+	_, err = NewResolver(nil, "", "", "secret").Resolve("vault:secret/ci/account#password")
+	assert.ErrorContains(t, err, "VAULT_ADDR")
+	_, err = NewResolver(nil, server.URL, "token", "").Resolve("vault:secret/ci/account#password")
+	assert.ErrorContains(t, err, VaultPrefixEnvVar, "vault references need a prefix")
+}
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "login with *** and ***", Redact("login with user and hunter2", []string{"hunter2", "user", ""}))
+	assert.Equal(t, "token ***", Redact("token abc123", []string{"abc", "abc123"}), "the longer secret is replaced first")
+	assert.Equal(t, "nothing to hide", Redact("nothing to hide", nil))
+}
@@ -0,0 +1,115 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultProvider wraps data keys with a key of the transit secrets engine of HashiCorp Vault
+type VaultProvider struct {
+	addr   string
+	token  string
+	mount  string
+	key    string
+	client *http.Client
+}
+
+// NewVaultProvider creates a provider for the transit key at <addr>/v1/<mount>/keys/<key>
+func NewVaultProvider(addr string, token string, mount string, key string) VaultProvider {
+	return VaultProvider{
+		addr:   strings.TrimSuffix(addr, "/"),
+		token:  token,
+		mount:  strings.Trim(mount, "/"),
+		key:    key,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NewVaultProviderFromEnv creates a provider for the Vault at VAULT_ADDR with VAULT_TOKEN. key is the name of the
+// transit key, optionally prefixed with the mount path of the transit engine like transit-lab/go-ios.
+func NewVaultProviderFromEnv(key string) (VaultProvider, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	token := os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return VaultProvider{}, fmt.Errorf("NewVaultProviderFromEnv: VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	mount := "transit"
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		mount, key = key[:i], key[i+1:]
+	}
+	return NewVaultProvider(addr, token, mount, key), nil
+}
+
+// Name returns vault
+func (p VaultProvider) Name() string {
+	return "vault"
+}
+
+// WrapKey encrypts key with the transit key, the result is the vault:v1:... ciphertext
+func (p VaultProvider) WrapKey(key []byte) ([]byte, error) {
+	var response struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	err := p.call("encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key)}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(response.Data.Ciphertext), nil
+}
+
+// UnwrapKey decrypts a key wrapped by WrapKey
+func (p VaultProvider) UnwrapKey(wrapped []byte) ([]byte, error) {
+	var response struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	err := p.call("decrypt", map[string]string{"ciphertext": string(wrapped)}, &response)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(response.Data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: invalid plaintext in vault response: %w", err)
+	}
+	return key, nil
+}
+
+func (p VaultProvider) call(operation string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v1/%s/%s/%s", p.addr, p.mount, operation, p.key), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", p.token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: vault returned %d: %s", operation, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	err = json.Unmarshal(respBody, response)
+	if err != nil {
+		return fmt.Errorf("%s: invalid vault response: %w", operation, err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package ios
+
+import (
+	"fmt"
+)
+
+// ServiceAvailability is the result of starting a lockdown service
+type ServiceAvailability string
+
+const (
+	// ServiceAvailable means lockdown started the service
+	ServiceAvailable = ServiceAvailability("available")
+	// ServiceMissing means the device does not know the service, f.ex. because the developer image is not mounted
+	// or the service does not exist on this iOS version
+	ServiceMissing = ServiceAvailability("missing")
+	// ServiceDenied means the service exists but lockdown refused to start it, f.ex. because the device is
+	// locked with a passcode, not supervised or a restriction prevents it
+	ServiceDenied = ServiceAvailability("denied")
+)
+
+// KnownLockdownServices are the services go-ios and Xcode start over lockdown. Services that are only reachable
+// over the RSD tunnel of iOS 17+ devices are not included.
+var KnownLockdownServices = []string{
+	"com.apple.afc",
+	"com.apple.accessibility.axAuditDaemon.remoteserver",
+	"com.apple.amfi.lockdown",
+	"com.apple.crashreportcopymobile",
+	"com.apple.crashreportmover",
+	"com.apple.debugserver",
+	"com.apple.debugserver.DVTSecureSocketProxy",
+	"com.apple.dt.simulatelocation",
+	"com.apple.instruments.remoteserver",
+	"com.apple.instruments.remoteserver.DVTSecureSocketProxy",
+	"com.apple.misagent",
+	"com.apple.mobile.MCInstall",
+	"com.apple.mobile.diagnostics_relay",
+	"com.apple.mobile.file_relay",
+	"com.apple.mobile.heartbeat",
+	"com.apple.mobile.house_arrest",
+	"com.apple.mobile.installation_proxy",
+	"com.apple.mobile.mobile_image_mounter",
+	"com.apple.mobile.notification_proxy",
+	"com.apple.mobile.screenshotr",
+	"com.apple.mobileactivationd",
+	"com.apple.pcapd",
+	"com.apple.springboardservices",
+	"com.apple.streaming_zip_conduit",
+	"com.apple.syslog_relay",
+	"com.apple.os_trace_relay",
+	"com.apple.testmanagerd.lockdown",
+	"com.apple.testmanagerd.lockdown.secure",
+}
+
+// ServiceProbe is the availability of one lockdown service on a device
+type ServiceProbe struct {
+	Service      string              `json:"service"`
+	Availability ServiceAvailability `json:"availability"`
+	// Reason is the error lockdown returned, f.ex. InvalidService or PasswordProtected
+	Reason string `json:"reason,omitempty"`
+	SSL    bool   `json:"ssl,omitempty"`
+}
+
+// ProbeServices starts each of the services over one lockdown session and reports which are available.
+// Started services are never connected to, the device closes their ports again after a short time.
+// Use KnownLockdownServices to probe all services go-ios uses.
+func ProbeServices(device DeviceEntry, services []string) ([]ServiceProbe, error) {
+	lockdown, err := ConnectLockdownWithSession(device)
+	if err != nil {
+		return nil, fmt.Errorf("ProbeServices: %w", err)
+	}
+	defer lockdown.Close()
+	result := make([]ServiceProbe, 0, len(services))
+	for _, service := range services {
+		clearDeadline := SetOperationDeadline(lockdown.deviceConnection, OperationServiceStart)
+		response, err := lockdown.requestService(service)
+		clearDeadline()
+		if err != nil {
+			return result, fmt.Errorf("ProbeServices: starting %s: %w", service, err)
+		}
+		result = append(result, serviceProbeFromResponse(service, response))
+	}
+	return result, nil
+}
+
+func serviceProbeFromResponse(service string, response StartServiceResponse) ServiceProbe {
+	probe := ServiceProbe{Service: service, Reason: response.Error, SSL: response.EnableServiceSSL}
+	switch response.Error {
+	case "":
+		probe.Availability = ServiceAvailable
+	case "InvalidService":
+		probe.Availability = ServiceMissing
+	default:
+		probe.Availability = ServiceDenied
+	}
+	return probe
+}
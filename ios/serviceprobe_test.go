@@ -0,0 +1,16 @@
+package ios
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceProbeFromResponse(t *testing.T) {
+	assert.Equal(t, ServiceProbe{Service: "com.apple.afc", Availability: ServiceAvailable, SSL: true},
+		serviceProbeFromResponse("com.apple.afc", StartServiceResponse{Port: 1234, EnableServiceSSL: true}))
+	assert.Equal(t, ServiceProbe{Service: "com.apple.debugserver", Availability: ServiceMissing, Reason: "InvalidService"},
+		serviceProbeFromResponse("com.apple.debugserver", StartServiceResponse{Error: "InvalidService"}))
+	assert.Equal(t, ServiceProbe{Service: "com.apple.mobile.file_relay", Availability: ServiceDenied, Reason: "PasswordProtected"},
+		serviceProbeFromResponse("com.apple.mobile.file_relay", StartServiceResponse{Error: "PasswordProtected"}))
+}
@@ -0,0 +1,250 @@
+package simlocation
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	ios "github.com/danielpaulus/go-ios/ios"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRouteInterval is how often PlayRoute updates the location if RouteOptions has no interval
+const DefaultRouteInterval = time.Second
+
+// earthRadius is the mean radius of the earth in meters
+const earthRadius = 6371008.8
+
+// Waypoint is a position on a route
+type Waypoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+func (w Waypoint) validate() error {
+	if math.IsNaN(w.Latitude) || w.Latitude < -90 || w.Latitude > 90 {
+		return fmt.Errorf("invalid latitude %f", w.Latitude)
+	}
+	if math.IsNaN(w.Longitude) || w.Longitude < -180 || w.Longitude > 180 {
+		return fmt.Errorf("invalid longitude %f", w.Longitude)
+	}
+	return nil
+}
+
+// Distance returns the great circle distance to other in meters
+func (w Waypoint) Distance(other Waypoint) float64 {
+	lat1, lat2 := w.Latitude*math.Pi/180, other.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (other.Longitude - w.Longitude) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// interpolate returns the position at fraction f of the way to other. Waypoints of a route are close to each
+// other, so interpolating linearly is precise enough.
+func (w Waypoint) interpolate(other Waypoint, f float64) Waypoint {
+	return Waypoint{
+		Latitude:  w.Latitude + (other.Latitude-w.Latitude)*f,
+		Longitude: w.Longitude + (other.Longitude-w.Longitude)*f,
+	}
+}
+
+// Route is a list of waypoints that is travelled in a straight line from one waypoint to the next
+type Route []Waypoint
+
+// Validate checks that the route has at least two valid waypoints
+func (r Route) Validate() error {
+	if len(r) < 2 {
+		return errors.New("a route needs at least two waypoints")
+	}
+	for i, w := range r {
+		err := w.validate()
+		if err != nil {
+			return fmt.Errorf("waypoint %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Length returns the length of the route in meters
+func (r Route) Length() float64 {
+	length := 0.0
+	for i := 1; i < len(r); i++ {
+		length += r[i-1].Distance(r[i])
+	}
+	return length
+}
+
+// PositionAt returns the position after travelling distance meters along the route. Distances beyond the
+// end of the route return the last waypoint.
+func (r Route) PositionAt(distance float64) Waypoint {
+	if len(r) == 0 {
+		return Waypoint{}
+	}
+	for i := 1; i < len(r); i++ {
+		segment := r[i-1].Distance(r[i])
+		if distance <= segment {
+			if segment == 0 {
+				return r[i]
+			}
+			return r[i-1].interpolate(r[i], distance/segment)
+		}
+		distance -= segment
+	}
+	return r[len(r)-1]
+}
+
+// gpxPoint is a track point, route point or waypoint of a gpx file
+type gpxPoint struct {
+	Longitude string `xml:"lon,attr"`
+	Latitude  string `xml:"lat,attr"`
+}
+
+type gpxRoute struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+	Waypoints []gpxPoint `xml:"wpt"`
+}
+
+// ParseGPXRoute reads the points of all tracks of a gpx file as route. Files without tracks can use route points
+// or waypoints instead. The times of the points are ignored, PlayRoute moves at a constant speed.
+func ParseGPXRoute(data []byte) (Route, error) {
+	var gpx gpxRoute
+	err := xml.Unmarshal(data, &gpx)
+	if err != nil {
+		return nil, fmt.Errorf("ParseGPXRoute: %w", err)
+	}
+	var points []gpxPoint
+	for _, track := range gpx.Tracks {
+		for _, segment := range track.Segments {
+			points = append(points, segment.Points...)
+		}
+	}
+	if len(points) == 0 {
+		for _, route := range gpx.Routes {
+			points = append(points, route.Points...)
+		}
+	}
+	if len(points) == 0 {
+		points = gpx.Waypoints
+	}
+	route := make(Route, 0, len(points))
+	for i, point := range points {
+		lat, err := strconv.ParseFloat(point.Latitude, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ParseGPXRoute: point %d: invalid latitude: %w", i, err)
+		}
+		lon, err := strconv.ParseFloat(point.Longitude, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ParseGPXRoute: point %d: invalid longitude: %w", i, err)
+		}
+		route = append(route, Waypoint{Latitude: lat, Longitude: lon})
+	}
+	err = route.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("ParseGPXRoute: %w", err)
+	}
+	return route, nil
+}
+
+// RouteOptions configure PlayRoute
+type RouteOptions struct {
+	// Speed in meters per second
+	Speed float64
+	// Interval between location updates, DefaultRouteInterval if it is 0
+	Interval time.Duration
+	// Loop starts over at the first waypoint after the last one was reached, until ctx is done
+	Loop bool
+	// OnUpdate is called after each location update with the position and the distance travelled in the current lap
+	OnUpdate func(position Waypoint, distance float64)
+}
+
+func (o RouteOptions) interval() time.Duration {
+	if o.Interval <= 0 {
+		return DefaultRouteInterval
+	}
+	return o.Interval
+}
+
+// Set changes the simulated location of the device
+func (locationConn *Connection) Set(latitude float64, longitude float64) error {
+	data := locationData{lat: latitude, lon: longitude}
+	locationBytes, err := data.LocationBytes()
+	if err != nil {
+		return err
+	}
+	return locationConn.deviceConn.Send(locationBytes)
+}
+
+// PlayRoute moves the simulated location of the device along the route at opts.Speed. The location is updated
+// every opts.Interval with the interpolated position, until the end of the route was reached or, if opts.Loop
+// is set, until ctx is done. The simulated location stays at the last position, use ResetLocation to reset it.
+// Returns ctx.Err() if the playback was stopped before the route ended.
+func PlayRoute(ctx context.Context, device ios.DeviceEntry, route Route, opts RouteOptions) error {
+	err := ValidateRoute(route, opts)
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{"waypoints": len(route), "meters": route.Length(), "speed": opts.Speed}).Info("Playing route")
+	return withTransport(device, func(transport locationTransport) error {
+		return playRoute(ctx, route, opts, func(w Waypoint) error {
+			return transport.Set(w.Latitude, w.Longitude)
+		})
+	})
+}
+
+// ValidateRoute checks that the route can be played with the options
+func ValidateRoute(route Route, opts RouteOptions) error {
+	err := route.Validate()
+	if err != nil {
+		return err
+	}
+	if math.IsNaN(opts.Speed) || opts.Speed <= 0 {
+		return errors.New("speed must be positive")
+	}
+	if opts.Loop && route.Length() == 0 {
+		return errors.New("a looped route needs a length")
+	}
+	return nil
+}
+
+// playRoute sets the positions of the route with set at opts.Speed. The distance is computed from the time that
+// passed, so slow updates don't slow down the playback.
+func playRoute(ctx context.Context, route Route, opts RouteOptions, set func(Waypoint) error) error {
+	length := route.Length()
+	ticker := time.NewTicker(opts.interval())
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		distance := time.Since(start).Seconds() * opts.Speed
+		if opts.Loop {
+			distance = math.Mod(distance, length)
+		}
+		position := route.PositionAt(distance)
+		err := set(position)
+		if err != nil {
+			return err
+		}
+		if opts.OnUpdate != nil {
+			opts.OnUpdate(position, math.Min(distance, length))
+		}
+		if !opts.Loop && distance >= length {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package simlocation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteInterpolation(t *testing.T) {
+	// one degree of latitude is about 111km
+	route := Route{{Latitude: 0, Longitude: 0}, {Latitude: 1, Longitude: 0}, {Latitude: 1, Longitude: 1}}
+	require.NoError(t, route.Validate())
+	assert.InDelta(t, 2*111195, route.Length(), 100)
+
+	half := route.PositionAt(111195 / 2)
+	assert.InDelta(t, 0.5, half.Latitude, 0.001)
+	assert.Equal(t, 0.0, half.Longitude)
+	second := route.PositionAt(111195 * 1.5)
+	assert.InDelta(t, 1, second.Latitude, 0.001)
+	assert.InDelta(t, 0.5, second.Longitude, 0.01)
+	assert.Equal(t, route[2], route.PositionAt(1e9))
+
+	assert.Error(t, Route{{Latitude: 1}}.Validate())
+	assert.Error(t, Route{{Latitude: 91}, {}}.Validate())
+}
+
+func TestParseGPXRoute(t *testing.T) {
+	track := `<gpx><trk><trkseg><trkpt lat="52.5" lon="13.4"></trkpt><trkpt lat="52.6" lon="13.5"><time>2024-01-01T00:00:00Z</time></trkpt></trkseg></trk></gpx>`
+	route, err := ParseGPXRoute([]byte(track))
+	require.NoError(t, err)
+	assert.Equal(t, Route{{Latitude: 52.5, Longitude: 13.4}, {Latitude: 52.6, Longitude: 13.5}}, route)
+
+	routePoints := `<gpx><rte><rtept lat="1" lon="2"/><rtept lat="3" lon="4"/></rte></gpx>`
+	route, err = ParseGPXRoute([]byte(routePoints))
+	require.NoError(t, err)
+	assert.Equal(t, Route{{Latitude: 1, Longitude: 2}, {Latitude: 3, Longitude: 4}}, route)
+
+	_, err = ParseGPXRoute([]byte(`<gpx><wpt lat="1" lon="2"/></gpx>`))
+	assert.Error(t, err, "a single waypoint is no route")
+	_, err = ParseGPXRoute([]byte(`<gpx><wpt lat="x" lon="2"/><wpt lat="1" lon="2"/></gpx>`))
+	assert.Error(t, err)
+}
+
+func TestPlayRoute(t *testing.T) {
+	route := Route{{Latitude: 0, Longitude: 0}, {Latitude: 0.001, Longitude: 0}}
+	opts := RouteOptions{Speed: route.Length() / 0.05, Interval: 5 * time.Millisecond}
+	require.NoError(t, ValidateRoute(route, opts))
+	var positions []Waypoint
+	err := playRoute(context.Background(), route, opts, func(w Waypoint) error {
+		positions = append(positions, w)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Greater(t, len(positions), 2)
+	assert.InDelta(t, 0, positions[0].Latitude, 0.0001)
+	assert.Equal(t, route[1], positions[len(positions)-1])
+	for i := 1; i < len(positions); i++ {
+		assert.GreaterOrEqual(t, positions[i].Latitude, positions[i-1].Latitude, "positions move forward")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	opts.Loop = true
+	laps := 0
+	opts.OnUpdate = func(position Waypoint, distance float64) {
+		if distance < opts.Speed*opts.Interval.Seconds() {
+			laps++
+		}
+	}
+	err = playRoute(ctx, route, opts, func(w Waypoint) error { return nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Greater(t, laps, 1, "looped routes start over")
+
+	assert.Error(t, ValidateRoute(route, RouteOptions{}), "speed is required")
+}
@@ -39,18 +39,14 @@ func (locationConn *Connection) Close() {
 	locationConn.deviceConn.Close()
 }
 
-// Set the device location to a point by latitude and longitude
+// SetLocation sets the device location to a point by latitude and longitude. iOS 17+ devices use the DVT
+// location simulation service, which only simulates the location while its connection is open. The connection is
+// kept open until ResetLocation is called, so the process has to keep running on these devices.
 func SetLocation(device ios.DeviceEntry, lat string, lon string) error {
 	if lat == "" || lon == "" {
 		return errors.New("Please provide non-empty values for latitude and longitude")
 	}
 
-	// Create new connection to the location service
-	locationConn, err := New(device)
-	if err != nil {
-		return err
-	}
-
 	latitude, err := strconv.ParseFloat(lat, 64)
 	if err != nil {
 		return err
@@ -61,27 +57,12 @@ func SetLocation(device ios.DeviceEntry, lat string, lon string) error {
 		return err
 	}
 
-	data := new(locationData)
-	data.lat = latitude
-	data.lon = longitude
-
 	log.WithFields(log.Fields{"latitude": latitude, "longitude": longitude}).
 		Info("Simulating device location")
 
-	// Generate the byte data needed by the service to set the location
-	locationBytes, err := data.LocationBytes()
-	if err != nil {
-		return err
-	}
-
-	// Send the generated byte data for the expected simulated coordinates
-	err = locationConn.deviceConn.Send(locationBytes)
-	if err != nil {
-		return err
-	}
-
-	locationConn.Close()
-	return nil
+	return withTransport(device, func(transport locationTransport) error {
+		return transport.Set(latitude, longitude)
+	})
 }
 
 type Gpx struct {
@@ -173,28 +154,11 @@ func SetLocationGPX(device ios.DeviceEntry, filePath string) error {
 	return nil
 }
 
+// ResetLocation resets the location of the device to the actual one
 func ResetLocation(device ios.DeviceEntry) error {
-	// Create a new connection to the location service
-	locationConn, err := New(device)
-	if err != nil {
-		return err
-	}
-
-	buf := new(bytes.Buffer)
-
-	// The location service accepts the binary representation of 1 to reset to the original location
-	err = binary.Write(buf, binary.BigEndian, uint32(1))
-	if err != nil {
-		return err
-	}
-
-	// Send the byte data that should reset the simulated location
-	err = locationConn.deviceConn.Send(buf.Bytes())
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return withTransport(device, func(transport locationTransport) error {
+		return transport.Reset()
+	})
 }
 
 // Create the byte data needed to set a specific location
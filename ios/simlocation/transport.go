@@ -0,0 +1,149 @@
+package simlocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	ios "github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	log "github.com/sirupsen/logrus"
+)
+
+// locationTransport changes the simulated location of a device, either through the legacy
+// com.apple.dt.simulatelocation service or the DVT LocationSimulation service of instruments
+type locationTransport interface {
+	Set(latitude float64, longitude float64) error
+	Reset() error
+}
+
+// dvtService is the part of instruments.LocationSimulationService that is used, tests replace it
+type dvtService interface {
+	StartSimulateLocation(lat, lon float64) error
+	StopSimulateLocation() error
+	Closed() <-chan struct{}
+	Close()
+}
+
+// dvtLocation uses the DVT LocationSimulation service
+type dvtLocation struct {
+	udid    string
+	service dvtService
+}
+
+func (d dvtLocation) Set(latitude float64, longitude float64) error {
+	return d.service.StartSimulateLocation(latitude, longitude)
+}
+
+// Reset stops the simulation, which closes the connection
+func (d dvtLocation) Reset() error {
+	err := d.service.StopSimulateLocation()
+	closeDVTSession(d.udid, d.service)
+	return err
+}
+
+// Reset resets the simulated location of the device to the actual one
+func (locationConn *Connection) Reset() error {
+	// The location service accepts the binary representation of 1 to reset to the original location
+	return locationConn.deviceConn.Send([]byte{0, 0, 0, 1})
+}
+
+var (
+	newDVTService = func(device ios.DeviceEntry) (dvtService, error) {
+		return instruments.NewLocationSimulationService(device)
+	}
+	newLegacyTransport = func(device ios.DeviceEntry) (locationTransport, func(), error) {
+		conn, err := New(device)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, conn.Close, nil
+	}
+	productVersion = ios.GetProductVersion
+)
+
+// dvtSessions keeps the DVT connection of each device open, because the device stops simulating the
+// location when the connection is closed
+var dvtSessions = struct {
+	mu       sync.Mutex
+	services map[string]dvtService
+}{services: map[string]dvtService{}}
+
+// usesDVT returns true if the device needs the DVT service. The legacy service is not available on iOS 17+,
+// where instruments is reached through the tunnel.
+func usesDVT(device ios.DeviceEntry) (bool, error) {
+	if device.SupportsRsd() {
+		return true, nil
+	}
+	version, err := productVersion(device)
+	if err != nil {
+		// keep the previous behaviour if the version can't be read
+		log.WithError(err).Debug("simlocation: failed reading the product version, using the legacy service")
+		return false, nil
+	}
+	if !version.LessThan(ios.IOS17()) {
+		return false, fmt.Errorf("iOS %s simulates locations through instruments, start a tunnel with 'ios tunnel start'", version)
+	}
+	return false, nil
+}
+
+// withTransport calls f with the location transport for the device. Legacy connections are closed afterwards,
+// the DVT connection is kept open until the location is reset and reopened if the device closed it.
+func withTransport(device ios.DeviceEntry, f func(locationTransport) error) error {
+	dvt, err := usesDVT(device)
+	if err != nil {
+		return err
+	}
+	if !dvt {
+		transport, closeTransport, err := newLegacyTransport(device)
+		if err != nil {
+			return err
+		}
+		defer closeTransport()
+		return f(transport)
+	}
+
+	udid := device.Properties.SerialNumber
+	service, err := dvtSession(device)
+	if err != nil {
+		return err
+	}
+	err = f(dvtLocation{udid: udid, service: service})
+	// a stopped route playback keeps its location, other errors leave the connection in an unknown state
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		closeDVTSession(udid, service)
+	}
+	return err
+}
+
+// dvtSession returns the open DVT connection of the device or opens a new one
+func dvtSession(device ios.DeviceEntry) (dvtService, error) {
+	udid := device.Properties.SerialNumber
+	dvtSessions.mu.Lock()
+	defer dvtSessions.mu.Unlock()
+	if service, ok := dvtSessions.services[udid]; ok {
+		select {
+		case <-service.Closed():
+			log.WithField("udid", udid).Debug("simlocation: DVT connection was closed, reconnecting")
+		default:
+			return service, nil
+		}
+	}
+	service, err := newDVTService(device)
+	if err != nil {
+		return nil, err
+	}
+	dvtSessions.services[udid] = service
+	return service, nil
+}
+
+// closeDVTSession closes the DVT connection of the device after it failed or the simulated location was reset
+func closeDVTSession(udid string, service dvtService) {
+	service.Close()
+	dvtSessions.mu.Lock()
+	defer dvtSessions.mu.Unlock()
+	if dvtSessions.services[udid] == service {
+		delete(dvtSessions.services, udid)
+	}
+}
@@ -0,0 +1,106 @@
+package simlocation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Masterminds/semver"
+	ios "github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDVTService struct {
+	locations [][2]float64
+	stopped   bool
+	fail      error
+	closed    chan struct{}
+}
+
+func (f *fakeDVTService) StartSimulateLocation(lat, lon float64) error {
+	f.locations = append(f.locations, [2]float64{lat, lon})
+	return f.fail
+}
+
+func (f *fakeDVTService) StopSimulateLocation() error {
+	f.stopped = true
+	f.Close()
+	return nil
+}
+
+func (f *fakeDVTService) Closed() <-chan struct{} {
+	return f.closed
+}
+
+func (f *fakeDVTService) Close() {
+	select {
+	case <-f.closed:
+	default:
+		close(f.closed)
+	}
+}
+
+func fakeTransports(t *testing.T, version string) (*[]*fakeDVTService, *int) {
+	originalDVT, originalLegacy, originalVersion := newDVTService, newLegacyTransport, productVersion
+	t.Cleanup(func() {
+		newDVTService, newLegacyTransport, productVersion = originalDVT, originalLegacy, originalVersion
+		dvtSessions.services = map[string]dvtService{}
+	})
+	var services []*fakeDVTService
+	newDVTService = func(device ios.DeviceEntry) (dvtService, error) {
+		service := &fakeDVTService{closed: make(chan struct{})}
+		services = append(services, service)
+		return service, nil
+	}
+	legacy := 0
+	newLegacyTransport = func(device ios.DeviceEntry) (locationTransport, func(), error) {
+		legacy++
+		return nil, nil, errors.New("legacy service")
+	}
+	productVersion = func(device ios.DeviceEntry) (*semver.Version, error) {
+		return semver.NewVersion(version)
+	}
+	return &services, &legacy
+}
+
+func TestDVTSessionIsKeptOpen(t *testing.T) {
+	services, legacy := fakeTransports(t, "17.4")
+	device := ios.DeviceEntry{Properties: ios.DeviceProperties{SerialNumber: "udid"}, Rsd: ios.RsdPortProviderJson{}}
+
+	require.NoError(t, SetLocation(device, "1.5", "2"))
+	require.NoError(t, SetLocation(device, "3", "4"))
+	require.Len(t, *services, 1, "the connection is reused")
+	assert.Equal(t, [][2]float64{{1.5, 2}, {3, 4}}, (*services)[0].locations)
+
+	require.NoError(t, ResetLocation(device))
+	assert.True(t, (*services)[0].stopped)
+	require.NoError(t, SetLocation(device, "5", "6"))
+	assert.Len(t, *services, 2, "a reset closes the connection")
+
+	(*services)[1].Close()
+	require.NoError(t, SetLocation(device, "5", "6"))
+	assert.Len(t, *services, 3, "closed connections are reopened")
+
+	(*services)[2].fail = errors.New("timeout")
+	assert.Error(t, SetLocation(device, "7", "8"))
+	assert.Empty(t, dvtSessions.services, "failed connections are closed")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := PlayRoute(ctx, device, Route{{Latitude: 1}, {Latitude: 2}}, RouteOptions{Speed: 1})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, dvtSessions.services, 1, "stopping a route keeps the location")
+	assert.Zero(t, *legacy)
+}
+
+func TestTransportIsSelectedByVersion(t *testing.T) {
+	_, legacy := fakeTransports(t, "16.7")
+	device := ios.DeviceEntry{Properties: ios.DeviceProperties{SerialNumber: "udid"}}
+	assert.EqualError(t, SetLocation(device, "1", "2"), "legacy service")
+	assert.Equal(t, 1, *legacy)
+
+	fakeTransports(t, "17.0")
+	err := SetLocation(device, "1", "2")
+	assert.ErrorContains(t, err, "ios tunnel start")
+}
@@ -0,0 +1,152 @@
+// Package springboard reads the home screen layout and the wallpapers of a device with springboardservices.
+package springboard
+
+import (
+	"bytes"
+	"fmt"
+
+	ios "github.com/danielpaulus/go-ios/ios"
+	"howett.net/plist"
+)
+
+const serviceName = "com.apple.springboardservices"
+
+// the wallpapers WallpaperPreview returns
+const (
+	HomeScreen = "homescreen"
+	LockScreen = "lockscreen"
+)
+
+// Connection is a connection to springboardservices
+type Connection struct {
+	deviceConn ios.DeviceConnectionInterface
+	plistCodec ios.PlistCodec
+}
+
+// New connects to springboardservices
+func New(device ios.DeviceEntry) (*Connection, error) {
+	deviceConn, err := ios.ConnectToService(device, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return &Connection{deviceConn: deviceConn, plistCodec: ios.NewPlistCodec()}, nil
+}
+
+// Close closes the connection
+func (c *Connection) Close() error {
+	c.deviceConn.Close()
+	return nil
+}
+
+// Icon is an app, a web clip or a folder on the home screen
+type Icon struct {
+	// BundleID is empty for folders
+	BundleID    string `json:"bundleId,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	// Folder are the pages of the icons in a folder
+	Folder [][]Icon `json:"folder,omitempty"`
+}
+
+// IconState is the layout of the home screen
+type IconState struct {
+	Dock  []Icon   `json:"dock"`
+	Pages [][]Icon `json:"pages"`
+}
+
+// IconState returns the icons of the dock and of every home screen page in the order they are shown
+func (c *Connection) IconState() (IconState, error) {
+	var lists []interface{}
+	err := c.request(map[string]interface{}{"command": "getIconState", "formatVersion": "2"}, &lists)
+	if err != nil {
+		return IconState{}, fmt.Errorf("IconState: %w", err)
+	}
+	return parseIconState(lists), nil
+}
+
+// HomeScreenWallpaper returns the wallpaper of the home screen as png. Devices that only provide a preview of
+// the wallpaper return an empty image, use WallpaperPreview for those.
+func (c *Connection) HomeScreenWallpaper() ([]byte, error) {
+	var response struct {
+		PngData []byte `plist:"pngData"`
+	}
+	err := c.request(map[string]interface{}{"command": "getHomeScreenWallpaperPNGData"}, &response)
+	if err != nil {
+		return nil, fmt.Errorf("HomeScreenWallpaper: %w", err)
+	}
+	return response.PngData, nil
+}
+
+// WallpaperPreview returns the preview of the HomeScreen or LockScreen wallpaper as png, like the wallpaper
+// settings show it
+func (c *Connection) WallpaperPreview(name string) ([]byte, error) {
+	var response struct {
+		PngData []byte `plist:"pngData"`
+	}
+	err := c.request(map[string]interface{}{"command": "getWallpaperPreviewImage", "wallpaperName": name}, &response)
+	if err != nil {
+		return nil, fmt.Errorf("WallpaperPreview: %w", err)
+	}
+	return response.PngData, nil
+}
+
+func (c *Connection) request(req map[string]interface{}, response interface{}) error {
+	b, err := c.plistCodec.Encode(req)
+	if err != nil {
+		return err
+	}
+	err = c.deviceConn.Send(b)
+	if err != nil {
+		return err
+	}
+	b, err = c.plistCodec.Decode(c.deviceConn.Reader())
+	if err != nil {
+		return err
+	}
+	var failure struct {
+		Error string `plist:"Error"`
+	}
+	// errors are dictionaries, the icon state is an array
+	if plist.NewDecoder(bytes.NewReader(b)).Decode(&failure) == nil && failure.Error != "" {
+		return fmt.Errorf("springboardservices returned error: %s", failure.Error)
+	}
+	return plist.NewDecoder(bytes.NewReader(b)).Decode(response)
+}
+
+// parseIconState converts the lists of the icon state, the first is the dock and the others are the pages
+func parseIconState(lists []interface{}) IconState {
+	state := IconState{Dock: []Icon{}, Pages: [][]Icon{}}
+	for i, list := range lists {
+		icons := parseIcons(list)
+		if i == 0 {
+			state.Dock = icons
+			continue
+		}
+		state.Pages = append(state.Pages, icons)
+	}
+	return state
+}
+
+func parseIcons(list interface{}) []Icon {
+	icons := []Icon{}
+	entries, _ := list.([]interface{})
+	for _, entry := range entries {
+		values, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		icon := Icon{}
+		icon.BundleID, _ = values["bundleIdentifier"].(string)
+		if icon.BundleID == "" {
+			icon.BundleID, _ = values["displayIdentifier"].(string)
+		}
+		icon.DisplayName, _ = values["displayName"].(string)
+		if pages, ok := values["iconLists"].([]interface{}); ok {
+			icon.Folder = [][]Icon{}
+			for _, page := range pages {
+				icon.Folder = append(icon.Folder, parseIcons(page))
+			}
+		}
+		icons = append(icons, icon)
+	}
+	return icons
+}
@@ -0,0 +1,51 @@
+package springboard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"howett.net/plist"
+)
+
+func TestParseIconState(t *testing.T) {
+	// formatVersion 2 of an iPhone with two apps in the dock, a folder and an entry that isn't an icon on the first page
+	raw := []interface{}{
+		[]interface{}{
+			map[string]interface{}{"bundleIdentifier": "com.apple.mobilephone", "displayName": "Phone"},
+			map[string]interface{}{"displayIdentifier": "com.apple.mobilesafari", "displayName": "Safari"},
+		},
+		[]interface{}{
+			map[string]interface{}{"bundleIdentifier": "com.apple.mobilecal", "displayName": "Calendar"},
+			map[string]interface{}{
+				"displayName": "Utilities",
+				"listType":    "folder",
+				"iconLists": []interface{}{
+					[]interface{}{map[string]interface{}{"bundleIdentifier": "com.apple.calculator", "displayName": "Calculator"}},
+				},
+			},
+			"unknown entry",
+		},
+		[]interface{}{},
+	}
+	b, err := plist.Marshal(raw, plist.BinaryFormat)
+	require.NoError(t, err)
+	var lists []interface{}
+	_, err = plist.Unmarshal(b, &lists)
+	require.NoError(t, err)
+
+	assert.Equal(t, IconState{
+		Dock: []Icon{
+			{BundleID: "com.apple.mobilephone", DisplayName: "Phone"},
+			{BundleID: "com.apple.mobilesafari", DisplayName: "Safari"},
+		},
+		Pages: [][]Icon{
+			{
+				{BundleID: "com.apple.mobilecal", DisplayName: "Calendar"},
+				{DisplayName: "Utilities", Folder: [][]Icon{{{BundleID: "com.apple.calculator", DisplayName: "Calculator"}}}},
+			},
+			{},
+		},
+	}, parseIconState(lists))
+	assert.Equal(t, IconState{Dock: []Icon{}, Pages: [][]Icon{}}, parseIconState(nil))
+}
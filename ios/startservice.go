@@ -31,10 +31,8 @@ func getStartServiceResponsefromBytes(plistBytes []byte) StartServiceResponse {
 	return data
 }
 
-// StartService sends a StartServiceRequest using the provided serviceName
-// and returns the Port of the services in a BigEndian Integer.
-// This port cann be used with a new UsbMuxClient and the Connect call.
-func (lockDownConn *LockDownConnection) StartService(serviceName string) (StartServiceResponse, error) {
+// requestService sends a StartServiceRequest and returns the response without checking its Error
+func (lockDownConn *LockDownConnection) requestService(serviceName string) (StartServiceResponse, error) {
 	err := lockDownConn.Send(startServiceRequest{Label: "go.ios.control", Request: "StartService", Service: serviceName})
 	if err != nil {
 		return StartServiceResponse{}, err
@@ -43,7 +41,17 @@ func (lockDownConn *LockDownConnection) StartService(serviceName string) (StartS
 	if err != nil {
 		return StartServiceResponse{}, err
 	}
-	response := getStartServiceResponsefromBytes(resp)
+	return getStartServiceResponsefromBytes(resp), nil
+}
+
+// StartService sends a StartServiceRequest using the provided serviceName
+// and returns the Port of the services in a BigEndian Integer.
+// This port cann be used with a new UsbMuxClient and the Connect call.
+func (lockDownConn *LockDownConnection) StartService(serviceName string) (StartServiceResponse, error) {
+	response, err := lockDownConn.requestService(serviceName)
+	if err != nil {
+		return StartServiceResponse{}, err
+	}
 	if response.Error != "" {
 		return StartServiceResponse{}, fmt.Errorf("Could not start service:%s with reason:'%s'. Have you mounted the Developer Image?", serviceName, response.Error)
 	}
@@ -59,6 +67,7 @@ func StartService(device DeviceEntry, serviceName string) (StartServiceResponse,
 		return StartServiceResponse{}, err
 	}
 	defer lockdown.Close()
+	defer SetOperationDeadline(lockdown.deviceConnection, OperationServiceStart)()
 	response, err := lockdown.StartService(serviceName)
 	if err != nil {
 		return response, err
@@ -29,6 +29,7 @@ type StartSessionResponse struct {
 	EnableSessionSSL bool
 	Request          string
 	SessionID        string
+	Error            string
 }
 
 func startSessionResponsefromBytes(plistBytes []byte) StartSessionResponse {
@@ -52,6 +53,12 @@ func (lockDownConn *LockDownConnection) StartSession(pairRecord PairRecord) (Sta
 		return StartSessionResponse{}, err
 	}
 	response := startSessionResponsefromBytes(resp)
+	if response.Error == lockdownErrorPasswordProtected {
+		return StartSessionResponse{}, ErrDeviceLocked
+	}
+	if response.Error == lockdownErrorInvalidHostID {
+		return StartSessionResponse{}, ErrPairingInvalid
+	}
 	lockDownConn.sessionID = response.SessionID
 	if response.EnableSessionSSL {
 		err = lockDownConn.deviceConnection.EnableSessionSsl(pairRecord)
@@ -0,0 +1,68 @@
+package syslog
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// MarkerPrefix starts the names of the notifications posted to mark the start and end of a job in the device log
+const MarkerPrefix = "go-ios.marker."
+
+const (
+	MarkerStart = "start"
+	MarkerEnd   = "end"
+)
+
+var (
+	markerPattern = regexp.MustCompile(`go-ios\.marker\.(start|end)\.([A-Za-z0-9_-]+)\.([A-Za-z0-9_-]+)`)
+	unsafeMarker  = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+)
+
+// Marker marks the start or end of a job in the device log. Posting Notification on the device, f.ex. with
+// notificationproxy.PostNotification, logs its name, so the messages of a job can be found in logs collected by
+// any tool with FindMarker and Slice.
+type Marker struct {
+	// Event is MarkerStart or MarkerEnd
+	Event string `json:"event"`
+	// Job is the type of the job, like "install" or "test"
+	Job string `json:"job"`
+	// ID correlates the messages of the job, it is the id of the job
+	ID string `json:"id"`
+}
+
+// Notification is the name of the notification for the marker like "go-ios.marker.start.install.<id>".
+// Characters other than letters, digits, '-' and '_' are replaced in Job and ID.
+func (m Marker) Notification() string {
+	return fmt.Sprintf("%s%s.%s.%s", MarkerPrefix, m.Event, unsafeMarker.ReplaceAllString(m.Job, "_"), unsafeMarker.ReplaceAllString(m.ID, "_"))
+}
+
+// FindMarker returns the marker in a log message
+func FindMarker(message string) (Marker, bool) {
+	match := markerPattern.FindStringSubmatch(message)
+	if match == nil {
+		return Marker{}, false
+	}
+	return Marker{Event: match[1], Job: match[2], ID: match[3]}, true
+}
+
+// Slice returns the messages from the start marker of the job with the id to its end marker, including both. Without
+// end marker it returns all messages after the start marker, it returns nil if there is no start marker.
+func Slice(messages []string, id string) []string {
+	start := -1
+	for i, message := range messages {
+		marker, ok := FindMarker(message)
+		if !ok || marker.ID != id {
+			continue
+		}
+		if marker.Event == MarkerStart && start < 0 {
+			start = i
+		}
+		if marker.Event == MarkerEnd && start >= 0 {
+			return messages[start : i+1]
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+	return messages[start:]
+}
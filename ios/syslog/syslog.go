@@ -2,7 +2,11 @@ package syslog
 
 import (
 	"bufio"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/danielpaulus/go-ios/ios"
 )
@@ -66,3 +70,119 @@ func (sysLogConn *Connection) ReadLogMessage() (string, error) {
 func (sysLogConn *Connection) Close() error {
 	return sysLogConn.closer.Close()
 }
+
+// ParseTimestamp parses the timestamp at the start of a syslog message like "Oct 17 12:34:56 iPhone kernel[0] <Notice>: ...".
+// Syslog timestamps are in the local time of the device and don't contain the year, so loc has to be the time zone
+// of the device and the year is picked that puts the timestamp closest to now.
+func ParseTimestamp(message string, now time.Time, loc *time.Location) (time.Time, bool) {
+	if len(message) < len(time.Stamp) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(time.Stamp, message[:len(time.Stamp)], loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	now = now.In(loc)
+	best := t.AddDate(now.Year(), 0, 0)
+	for _, years := range []int{-1, 1} {
+		candidate := best.AddDate(years, 0, 0)
+		if candidate.Sub(now).Abs() < best.Sub(now).Abs() {
+			best = candidate
+		}
+	}
+	return best, true
+}
+
+// Level is the severity of a syslog message
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelNotice
+	LevelWarning
+	LevelError
+	LevelCritical
+	LevelAlert
+	LevelEmergency
+)
+
+var levelNames = []string{"Debug", "Info", "Notice", "Warning", "Error", "Critical", "Alert", "Emergency"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return "Unknown"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses the level of a syslog message, like "Notice" or "notice"
+func ParseLevel(level string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(name, level) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("ParseLevel: unknown level '%s'", level)
+}
+
+// Message is a parsed syslog message
+type Message struct {
+	// Timestamp is the timestamp of the message as sent by the device, see ParseTimestamp
+	Timestamp string `json:"timestamp"`
+	Device    string `json:"device"`
+	Process   string `json:"process"`
+	// Library is the library or subsystem the message was logged by, if the device sent it
+	Library string `json:"library,omitempty"`
+	Pid     int    `json:"pid"`
+	Level   Level  `json:"-"`
+	// LevelName is the name of Level, like "Notice"
+	LevelName string `json:"level"`
+	Text      string `json:"message"`
+}
+
+// Parse splits a syslog message like "Oct 17 12:34:56 iPhone SpringBoard(FrontBoard)[58] <Notice>: text" into its parts.
+// It returns false for messages that don't have that format.
+func Parse(message string) (Message, bool) {
+	message = strings.TrimRight(message, "\x00\n")
+	if len(message) <= len(time.Stamp)+1 {
+		return Message{}, false
+	}
+	result := Message{Timestamp: message[:len(time.Stamp)]}
+	rest := message[len(time.Stamp)+1:]
+
+	var ok bool
+	result.Device, rest, ok = strings.Cut(rest, " ")
+	if !ok {
+		return Message{}, false
+	}
+	levelStart := strings.Index(rest, " <")
+	levelEnd := strings.Index(rest, ">: ")
+	if levelStart < 0 || levelEnd < levelStart {
+		return Message{}, false
+	}
+	level, err := ParseLevel(rest[levelStart+2 : levelEnd])
+	if err != nil {
+		return Message{}, false
+	}
+	result.Level = level
+	result.LevelName = level.String()
+	result.Text = rest[levelEnd+3:]
+
+	process := rest[:levelStart]
+	pidStart := strings.LastIndex(process, "[")
+	if pidStart < 0 || !strings.HasSuffix(process, "]") {
+		return Message{}, false
+	}
+	result.Pid, err = strconv.Atoi(process[pidStart+1 : len(process)-1])
+	if err != nil {
+		return Message{}, false
+	}
+	process = process[:pidStart]
+	if libraryStart := strings.Index(process, "("); libraryStart > 0 && strings.HasSuffix(process, ")") {
+		result.Library = process[libraryStart+1 : len(process)-1]
+		process = process[:libraryStart]
+	}
+	result.Process = process
+	return result, true
+}
@@ -0,0 +1,85 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	loc := time.FixedZone("device", 2*60*60)
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	ts, ok := ParseTimestamp("Jun  1 12:00:05 iPhone kernel[0] <Notice>: hello", now, loc)
+	require.True(t, ok)
+	assert.Equal(t, now.Add(5*time.Second), ts.UTC())
+
+	// a message from the end of last year that is read right after new year
+	ts, ok = ParseTimestamp("Dec 31 23:59:59 iPhone kernel[0] <Notice>: hello", time.Date(2025, 1, 1, 0, 0, 10, 0, loc), loc)
+	require.True(t, ok)
+	assert.Equal(t, 2024, ts.Year())
+
+	_, ok = ParseTimestamp("not a syslog line", now, loc)
+	assert.False(t, ok)
+}
+
+func TestParse(t *testing.T) {
+	msg, ok := Parse("Oct 17 12:34:56 iPhone SpringBoard(FrontBoard)[58] <Notice>: [app<com.example>] scene <x>: done\x00")
+	require.True(t, ok)
+	assert.Equal(t, Message{
+		Timestamp: "Oct 17 12:34:56",
+		Device:    "iPhone",
+		Process:   "SpringBoard",
+		Library:   "FrontBoard",
+		Pid:       58,
+		Level:     LevelNotice,
+		LevelName: "Notice",
+		Text:      "[app<com.example>] scene <x>: done",
+	}, msg)
+
+	msg, ok = Parse("Oct  7 01:02:03 iPad kernel[0] <Error>: panic\n")
+	require.True(t, ok)
+	assert.Equal(t, "kernel", msg.Process)
+	assert.Equal(t, "", msg.Library)
+	assert.Equal(t, LevelError, msg.Level)
+	assert.Equal(t, "panic", msg.Text)
+
+	_, ok = Parse("Oct 17 12:34:56 iPhone kernel <Notice>: no pid")
+	assert.False(t, ok)
+	_, ok = Parse("not a syslog line")
+	assert.False(t, ok)
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("warning")
+	require.NoError(t, err)
+	assert.Equal(t, LevelWarning, level)
+	assert.True(t, LevelError > LevelWarning)
+	_, err = ParseLevel("loud")
+	assert.Error(t, err)
+}
+
+func TestMarkers(t *testing.T) {
+	marker := Marker{Event: MarkerStart, Job: "mount image", ID: "5f0c-42"}
+	assert.Equal(t, "go-ios.marker.start.mount_image.5f0c-42", marker.Notification())
+
+	messages := []string{
+		"Oct 17 12:00:00 iPhone SpringBoard[58] <Notice>: before",
+		"Oct 17 12:00:01 iPhone notifyd[40] <Notice>: posting go-ios.marker.start.test.a1 from notification_proxy",
+		"Oct 17 12:00:02 iPhone notifyd[40] <Notice>: posting go-ios.marker.start.install.b2 from notification_proxy",
+		"Oct 17 12:00:03 iPhone Example[312] <Error>: during",
+		"Oct 17 12:00:04 iPhone notifyd[40] <Notice>: posting go-ios.marker.end.test.a1 from notification_proxy",
+		"Oct 17 12:00:05 iPhone SpringBoard[58] <Notice>: after",
+	}
+	found, ok := FindMarker(messages[4])
+	require.True(t, ok)
+	assert.Equal(t, Marker{Event: MarkerEnd, Job: "test", ID: "a1"}, found)
+	_, ok = FindMarker(messages[0])
+	assert.False(t, ok)
+
+	assert.Equal(t, messages[1:5], Slice(messages, "a1"))
+	assert.Equal(t, messages[2:], Slice(messages, "b2"), "a job without end marker runs until the last message")
+	assert.Nil(t, Slice(messages, "c3"))
+}
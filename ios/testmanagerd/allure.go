@@ -0,0 +1,258 @@
+package testmanagerd
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// AllureLabel is a label of an Allure result, Allure dashboards group and filter results by their labels
+type AllureLabel struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// AllureDeviceLabels labels results with the model and iOS version of the device the tests ran on.
+// productType is the lockdown ProductType f.ex. iPhone14,2, empty values are left out.
+func AllureDeviceLabels(productType string, osVersion string, deviceName string) []AllureLabel {
+	labels := []AllureLabel{}
+	if productType != "" {
+		labels = append(labels, AllureLabel{Name: "deviceModel", Value: productType})
+	}
+	if osVersion != "" {
+		labels = append(labels, AllureLabel{Name: "osVersion", Value: "iOS " + osVersion})
+	}
+	if deviceName != "" {
+		labels = append(labels, AllureLabel{Name: "host", Value: deviceName})
+	}
+	return labels
+}
+
+type allureResult struct {
+	UUID          string             `json:"uuid"`
+	HistoryID     string             `json:"historyId"`
+	FullName      string             `json:"fullName"`
+	Name          string             `json:"name"`
+	Status        string             `json:"status"`
+	StatusDetails *allureDetails     `json:"statusDetails,omitempty"`
+	Stage         string             `json:"stage"`
+	Start         int64              `json:"start"`
+	Stop          int64              `json:"stop"`
+	Labels        []AllureLabel      `json:"labels"`
+	Steps         []allureStep       `json:"steps"`
+	Attachments   []allureAttachment `json:"attachments"`
+}
+
+type allureDetails struct {
+	Message string `json:"message,omitempty"`
+	Trace   string `json:"trace,omitempty"`
+	Flaky   bool   `json:"flaky,omitempty"`
+}
+
+type allureStep struct {
+	Name        string             `json:"name"`
+	Status      string             `json:"status"`
+	Stage       string             `json:"stage"`
+	Start       int64              `json:"start"`
+	Stop        int64              `json:"stop"`
+	Attachments []allureAttachment `json:"attachments"`
+}
+
+type allureAttachment struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Type   string `json:"type"`
+}
+
+// allureAttachmentTypes are the file extension and mime type of the uniform type identifiers of attachments
+var allureAttachmentTypes = map[string][2]string{
+	"public.png":                {".png", "image/png"},
+	"public.jpeg":               {".jpg", "image/jpeg"},
+	"public.plain-text":         {".txt", "text/plain"},
+	"public.utf8-plain-text":    {".txt", "text/plain"},
+	"public.json":               {".json", "application/json"},
+	"public.mpeg-4":             {".mp4", "video/mp4"},
+	"com.apple.quicktime-movie": {".mov", "video/quicktime"},
+}
+
+// AllureReporter writes the results as Allure result files, the format allure generate and allure serve read
+type AllureReporter struct {
+	dir    string
+	labels []AllureLabel
+}
+
+// NewAllureReporter creates a reporter that writes Allure results to the directory dir, labels are added to
+// every result, f.ex. the AllureDeviceLabels
+func NewAllureReporter(dir string, labels ...AllureLabel) AllureReporter {
+	return AllureReporter{dir: dir, labels: labels}
+}
+
+// Report writes a <uuid>-result.json file per test case and copies the attachments next to it. Attachments of
+// the same activity become a step. Failed attempts of retried test cases are written as results with the same
+// history id, Allure shows them as retries. testmanagerd only reports durations, so test cases start one after
+// another at the start date of their suite. A test run that did not finish adds a broken result.
+func (r AllureReporter) Report(suites []TestSuite, runErr error) error {
+	err := os.MkdirAll(r.dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("Report: failed creating allure results directory: %w", err)
+	}
+	for _, suite := range suites {
+		start := suite.StartDate
+		for _, testCase := range suite.TestCases {
+			for _, attempt := range testCase.Attempts {
+				earlier := TestCase{ClassName: testCase.ClassName, MethodName: testCase.MethodName, Status: attempt.Status,
+					Err: attempt.Err, Duration: attempt.Duration, Attachments: attempt.Attachments}
+				err := r.writeResult(suite.Name, earlier, start, false)
+				if err != nil {
+					return err
+				}
+				start = start.Add(attempt.Duration)
+			}
+			err := r.writeResult(suite.Name, testCase, start, testCase.Flaky())
+			if err != nil {
+				return err
+			}
+			start = start.Add(testCase.Duration)
+		}
+	}
+	if runErr != nil {
+		now := time.Now()
+		return r.write(allureResult{
+			UUID:          uuid.New().String(),
+			HistoryID:     allureHistoryID("go-ios.testRun"),
+			FullName:      "go-ios.testRun",
+			Name:          "testRun",
+			Status:        "broken",
+			StatusDetails: &allureDetails{Message: runErr.Error()},
+			Stage:         "finished",
+			Start:         now.UnixMilli(),
+			Stop:          now.UnixMilli(),
+			Labels:        append([]AllureLabel{{Name: "suite", Value: "go-ios"}, {Name: "framework", Value: "XCTest"}}, r.labels...),
+			Steps:         []allureStep{},
+			Attachments:   []allureAttachment{},
+		})
+	}
+	return nil
+}
+
+func (r AllureReporter) writeResult(suiteName string, testCase TestCase, start time.Time, flaky bool) error {
+	fullName := testCase.ClassName + "." + testCase.MethodName
+	stop := start.Add(testCase.Duration)
+	result := allureResult{
+		UUID:      uuid.New().String(),
+		HistoryID: allureHistoryID(fullName),
+		FullName:  fullName,
+		Name:      testCase.MethodName,
+		Status:    allureStatus(testCase.Status),
+		Stage:     "finished",
+		Start:     start.UnixMilli(),
+		Stop:      stop.UnixMilli(),
+		Labels: append([]AllureLabel{
+			{Name: "suite", Value: suiteName},
+			{Name: "testClass", Value: testCase.ClassName},
+			{Name: "testMethod", Value: testCase.MethodName},
+			{Name: "framework", Value: "XCTest"},
+			{Name: "language", Value: "swift"},
+		}, r.labels...),
+		Steps:       []allureStep{},
+		Attachments: []allureAttachment{},
+	}
+	if testCase.Err != (TestError{}) || flaky {
+		result.StatusDetails = &allureDetails{Message: testCase.Err.Message, Flaky: flaky}
+		if testCase.Err.File != "" {
+			result.StatusDetails.Trace = fmt.Sprintf("%s:%d", testCase.Err.File, testCase.Err.Line)
+		}
+	}
+	steps := map[string]int{}
+	for _, attachment := range testCase.Attachments {
+		copied, ok := r.copyAttachment(attachment)
+		if !ok {
+			continue
+		}
+		if attachment.Activity == "" {
+			result.Attachments = append(result.Attachments, copied)
+			continue
+		}
+		i, ok := steps[attachment.Activity]
+		if !ok {
+			i = len(result.Steps)
+			steps[attachment.Activity] = i
+			result.Steps = append(result.Steps, allureStep{Name: attachment.Activity, Status: result.Status, Stage: "finished",
+				Start: result.Start, Stop: result.Stop, Attachments: []allureAttachment{}})
+		}
+		result.Steps[i].Attachments = append(result.Steps[i].Attachments, copied)
+	}
+	return r.write(result)
+}
+
+// copyAttachment copies an attachment into the results directory, missing attachments are skipped
+func (r AllureReporter) copyAttachment(attachment TestAttachment) (allureAttachment, bool) {
+	fileType, ok := allureAttachmentTypes[attachment.UniformTypeIdentifier]
+	if !ok {
+		fileType = [2]string{"", "application/octet-stream"}
+	}
+	source := uuid.New().String() + "-attachment" + fileType[0]
+	err := copyFile(attachment.Path, filepath.Join(r.dir, source))
+	if err != nil {
+		log.WithError(err).WithField("attachment", attachment.Name).Warn("failed copying attachment to allure results")
+		return allureAttachment{}, false
+	}
+	return allureAttachment{Name: attachment.Name, Source: source, Type: fileType[1]}, true
+}
+
+func (r AllureReporter) write(result allureResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("Report: failed writing allure result: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(r.dir, result.UUID+"-result.json"), data, 0o644)
+	if err != nil {
+		return fmt.Errorf("Report: failed writing allure result: %w", err)
+	}
+	return nil
+}
+
+func allureStatus(status TestCaseStatus) string {
+	switch status {
+	case StatusPassed, StatusExpectedFailure:
+		return "passed"
+	case StatusSkipped:
+		return "skipped"
+	case StatusFailed:
+		return "failed"
+	default:
+		// stalled test cases and test cases that never finished did not fail an assertion
+		return "broken"
+	}
+}
+
+// allureHistoryID identifies a test case across runs, Allure uses it for retries and history trends
+func allureHistoryID(fullName string) string {
+	sum := md5.Sum([]byte(fullName))
+	return hex.EncodeToString(sum[:])
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,344 @@
+package testmanagerd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/diagnostics"
+	"github.com/danielpaulus/go-ios/ios/installationproxy"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	log "github.com/sirupsen/logrus"
+)
+
+// energySampleInterval is how often the energy impact is sampled during a test run with energy profiling
+const energySampleInterval = 2 * time.Second
+
+// EnergyReport summarizes the energy a test run used, so battery regressions can be tracked per build
+type EnergyReport struct {
+	// BatteryStart and BatteryEnd are the battery charge in percent before and after the run, BatteryDrain is
+	// their difference. A charging device can have a negative drain.
+	BatteryStart int     `json:"batteryStart"`
+	BatteryEnd   int     `json:"batteryEnd"`
+	BatteryDrain int     `json:"batteryDrain"`
+	Duration     float64 `json:"duration"`
+	// Processes are the energy impact of the app under test and the test runner
+	Processes []ProcessEnergy `json:"processes"`
+	// Error is set if sampling failed during the run, the report only has the samples taken until then
+	Error string `json:"error,omitempty"`
+}
+
+// ProcessEnergy is the energy impact of a process during a test run like the energy gauge of Xcode shows it,
+// 0 is none and values above 10 are a very high impact
+type ProcessEnergy struct {
+	Process string                   `json:"process"`
+	Samples int                      `json:"samples"`
+	MaxCost float64                  `json:"maxCost"`
+	Average instruments.EnergySample `json:"average"`
+}
+
+// EnergyReporter is implemented by reporters that include the EnergyReport of test runs with
+// WithEnergyProfiling, ReportEnergy is called instead of Report for them
+type EnergyReporter interface {
+	ReportEnergy(suites []TestSuite, runErr error, energy EnergyReport) error
+}
+
+// energySource accesses the device for energy profiling
+type energySource interface {
+	batteryLevel() (int, error)
+	// processes returns the pids of the running processes by name
+	processes() (map[string]uint64, error)
+	Start(pids []uint64) error
+	Sample(pids []uint64) (map[uint64]instruments.EnergySample, error)
+	Stop(pids []uint64) error
+	Close() error
+}
+
+// newEnergySource connects to the services energy profiling needs, tests replace it
+var newEnergySource = func(device ios.DeviceEntry) (energySource, error) {
+	monitor, err := instruments.NewEnergyMonitor(device)
+	if err != nil {
+		return nil, err
+	}
+	info, err := instruments.NewDeviceInfoService(device)
+	if err != nil {
+		monitor.Close()
+		return nil, err
+	}
+	return &deviceEnergySource{EnergyMonitor: monitor, info: info, device: device}, nil
+}
+
+type deviceEnergySource struct {
+	*instruments.EnergyMonitor
+	info   *instruments.DeviceInfoService
+	device ios.DeviceEntry
+}
+
+func (s *deviceEnergySource) batteryLevel() (int, error) {
+	conn, err := diagnostics.New(s.device)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	battery, err := conn.Battery()
+	if err != nil {
+		return 0, err
+	}
+	return battery.CurrentCapacity, nil
+}
+
+func (s *deviceEnergySource) processes() (map[string]uint64, error) {
+	list, err := s.info.ProcessList()
+	if err != nil {
+		return nil, err
+	}
+	pids := map[string]uint64{}
+	for _, p := range list {
+		pids[p.Name] = p.Pid
+	}
+	return pids, nil
+}
+
+func (s *deviceEnergySource) Close() error {
+	s.info.Close()
+	return s.EnergyMonitor.Close()
+}
+
+type energyTotals struct {
+	samples int
+	max     float64
+	sum     instruments.EnergySample
+}
+
+// energyProfiler samples the energy impact of processes by name until it is stopped. Processes are looked up on
+// every sample, so an app that is launched again is followed with its new pid.
+type energyProfiler struct {
+	source   energySource
+	names    []string
+	interval time.Duration
+	started  time.Time
+	battery  int
+	sampling map[uint64]bool
+	totals   map[string]*energyTotals
+	err      error
+	stop     context.CancelFunc
+	// done is closed when run returned, the fields it modifies can be read then
+	done chan struct{}
+}
+
+// startEnergyProfiling starts sampling the processes of the apps with the bundle ids
+func startEnergyProfiling(device ios.DeviceEntry, bundleIDs []string) (*energyProfiler, error) {
+	names, err := executables(device, bundleIDs)
+	if err != nil {
+		return nil, err
+	}
+	source, err := newEnergySource(device)
+	if err != nil {
+		return nil, err
+	}
+	return newEnergyProfiler(source, names, energySampleInterval)
+}
+
+func newEnergyProfiler(source energySource, names []string, interval time.Duration) (*energyProfiler, error) {
+	battery, err := source.batteryLevel()
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	p := &energyProfiler{
+		source:   source,
+		names:    names,
+		interval: interval,
+		started:  time.Now(),
+		battery:  battery,
+		sampling: map[uint64]bool{},
+		totals:   map[string]*energyTotals{},
+		stop:     stop,
+		done:     make(chan struct{}),
+	}
+	go p.run(ctx)
+	return p, nil
+}
+
+func (p *energyProfiler) run(ctx context.Context) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := p.sample()
+		if err != nil {
+			log.WithError(err).Warn("energy profiling failed, the report only has the samples taken so far")
+			p.err = err
+			return
+		}
+	}
+}
+
+// sample adds the energy impact of the running processes to the totals
+func (p *energyProfiler) sample() error {
+	running, err := p.source.processes()
+	if err != nil {
+		return err
+	}
+	names := map[uint64]string{}
+	var sampled, newPids []uint64
+	for _, name := range p.names {
+		pid, ok := running[name]
+		if !ok {
+			continue
+		}
+		names[pid] = name
+		if p.sampling[pid] {
+			sampled = append(sampled, pid)
+		} else {
+			newPids = append(newPids, pid)
+		}
+	}
+	if len(newPids) > 0 {
+		err = p.source.Start(newPids)
+		if err != nil {
+			return err
+		}
+		for _, pid := range newPids {
+			p.sampling[pid] = true
+		}
+	}
+	// the first sample of a new process is only available after the next interval
+	if len(sampled) == 0 {
+		return nil
+	}
+	samples, err := p.source.Sample(sampled)
+	if err != nil {
+		return err
+	}
+	for pid, sample := range samples {
+		name, ok := names[pid]
+		if !ok {
+			continue
+		}
+		totals := p.totals[name]
+		if totals == nil {
+			totals = &energyTotals{}
+			p.totals[name] = totals
+		}
+		totals.samples++
+		if sample.Cost > totals.max {
+			totals.max = sample.Cost
+		}
+		totals.sum.Cost += sample.Cost
+		totals.sum.CPUCost += sample.CPUCost
+		totals.sum.GPUCost += sample.GPUCost
+		totals.sum.NetworkingCost += sample.NetworkingCost
+		totals.sum.DisplayCost += sample.DisplayCost
+		totals.sum.LocationCost += sample.LocationCost
+		totals.sum.AppStateCost += sample.AppStateCost
+		totals.sum.OverheadCost += sample.OverheadCost
+	}
+	return nil
+}
+
+// finish stops sampling and returns the report
+func (p *energyProfiler) finish() EnergyReport {
+	p.stop()
+	<-p.done
+	defer p.source.Close()
+	report := EnergyReport{BatteryStart: p.battery, Duration: time.Since(p.started).Seconds(), Processes: []ProcessEnergy{}}
+	if len(p.sampling) > 0 {
+		pids := make([]uint64, 0, len(p.sampling))
+		for pid := range p.sampling {
+			pids = append(pids, pid)
+		}
+		if err := p.source.Stop(pids); err != nil {
+			log.WithError(err).Debug("failed stopping energy sampling")
+		}
+	}
+	battery, err := p.source.batteryLevel()
+	if err != nil {
+		log.WithError(err).Warn("failed reading the battery level after the test run")
+		battery = p.battery
+	}
+	report.BatteryEnd = battery
+	report.BatteryDrain = report.BatteryStart - report.BatteryEnd
+	if p.err != nil {
+		report.Error = p.err.Error()
+	}
+	for name, totals := range p.totals {
+		n := float64(totals.samples)
+		report.Processes = append(report.Processes, ProcessEnergy{
+			Process: name,
+			Samples: totals.samples,
+			MaxCost: totals.max,
+			Average: instruments.EnergySample{
+				Cost:           totals.sum.Cost / n,
+				CPUCost:        totals.sum.CPUCost / n,
+				GPUCost:        totals.sum.GPUCost / n,
+				NetworkingCost: totals.sum.NetworkingCost / n,
+				DisplayCost:    totals.sum.DisplayCost / n,
+				LocationCost:   totals.sum.LocationCost / n,
+				AppStateCost:   totals.sum.AppStateCost / n,
+				OverheadCost:   totals.sum.OverheadCost / n,
+			},
+		})
+	}
+	sort.Slice(report.Processes, func(i, j int) bool { return report.Processes[i].Process < report.Processes[j].Process })
+	return report
+}
+
+// executables returns the process names of the apps with the bundle ids, empty bundle ids are skipped
+func executables(device ios.DeviceEntry, bundleIDs []string) ([]string, error) {
+	svc, err := installationproxy.New(device)
+	if err != nil {
+		return nil, err
+	}
+	defer svc.Close()
+	apps, err := svc.BrowseAllApps()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, bundleID := range bundleIDs {
+		if bundleID == "" {
+			continue
+		}
+		found := false
+		for _, app := range apps {
+			if app.CFBundleIdentifier == bundleID {
+				names = append(names, app.CFBundleExecutable)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("executables: app %s is not installed", bundleID)
+		}
+	}
+	return names, nil
+}
+
+// runWithEnergyProfiling profiles the apps with the bundle ids while run runs the tests. The reporters of listener
+// are called once the profile is complete, reporters implementing EnergyReporter get it with the results.
+// If profiling can't start, the tests run without it.
+func runWithEnergyProfiling(device ios.DeviceEntry, bundleIDs []string, listener *TestListener, run func() ([]TestSuite, error)) ([]TestSuite, error) {
+	profiler, err := startEnergyProfiling(device, bundleIDs)
+	if err != nil {
+		log.WithError(err).Warn("energy profiling failed to start, running the tests without it")
+		return run()
+	}
+	reporters := listener.reporters
+	listener.reporters = nil
+	suites, runErr := run()
+	energy := profiler.finish()
+	listener.TestSuites = suites
+	listener.err = runErr
+	listener.Energy = &energy
+	listener.reporters = reporters
+	return listener.results()
+}
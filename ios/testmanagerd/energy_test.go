@@ -0,0 +1,144 @@
+package testmanagerd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeEnergySource struct {
+	mu       sync.Mutex
+	battery  []int
+	running  map[string]uint64
+	started  []uint64
+	stopped  []uint64
+	samples  int
+	closed   bool
+	sampleFn func(pids []uint64) (map[uint64]instruments.EnergySample, error)
+}
+
+func (f *fakeEnergySource) batteryLevel() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	level := f.battery[0]
+	if len(f.battery) > 1 {
+		f.battery = f.battery[1:]
+	}
+	return level, nil
+}
+
+func (f *fakeEnergySource) processes() (map[string]uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.running, nil
+}
+
+func (f *fakeEnergySource) Start(pids []uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.started = append(f.started, pids...)
+	return nil
+}
+
+func (f *fakeEnergySource) Sample(pids []uint64) (map[uint64]instruments.EnergySample, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.samples++
+	return f.sampleFn(pids)
+}
+
+func (f *fakeEnergySource) Stop(pids []uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = append(f.stopped, pids...)
+	return nil
+}
+
+func (f *fakeEnergySource) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakeEnergySource) sampleCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.samples
+}
+
+func TestEnergyProfiler(t *testing.T) {
+	source := &fakeEnergySource{
+		battery: []int{90, 88},
+		running: map[string]uint64{"Example": 312, "ExampleUITests-Runner": 313, "SpringBoard": 1},
+		sampleFn: func(pids []uint64) (map[uint64]instruments.EnergySample, error) {
+			return map[uint64]instruments.EnergySample{
+				312: {Cost: 4, CPUCost: 3, NetworkingCost: 1},
+				313: {Cost: 1, CPUCost: 1},
+				// processes that were not asked for are ignored
+				1: {Cost: 20},
+			}, nil
+		},
+	}
+	profiler, err := newEnergyProfiler(source, []string{"Example", "ExampleUITests-Runner"}, time.Millisecond)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return source.sampleCount() >= 2 }, 5*time.Second, time.Millisecond)
+	report := profiler.finish()
+
+	assert.Equal(t, 90, report.BatteryStart)
+	assert.Equal(t, 88, report.BatteryEnd)
+	assert.Equal(t, 2, report.BatteryDrain)
+	assert.ElementsMatch(t, []uint64{312, 313}, source.started)
+	assert.ElementsMatch(t, []uint64{312, 313}, source.stopped)
+	assert.True(t, source.closed)
+	require.Len(t, report.Processes, 2)
+	app := report.Processes[0]
+	assert.Equal(t, "Example", app.Process)
+	assert.Equal(t, source.samples, app.Samples)
+	assert.Equal(t, 4.0, app.MaxCost)
+	assert.Equal(t, instruments.EnergySample{Cost: 4, CPUCost: 3, NetworkingCost: 1}, app.Average)
+	assert.Equal(t, "ExampleUITests-Runner", report.Processes[1].Process)
+	assert.Empty(t, report.Error)
+}
+
+func TestEnergyProfilerKeepsSamplesWhenSamplingFails(t *testing.T) {
+	calls := 0
+	source := &fakeEnergySource{
+		battery: []int{50},
+		running: map[string]uint64{"Example": 312},
+		sampleFn: func(pids []uint64) (map[uint64]instruments.EnergySample, error) {
+			calls++
+			if calls > 1 {
+				return nil, errors.New("device went away")
+			}
+			return map[uint64]instruments.EnergySample{312: {Cost: 2}}, nil
+		},
+	}
+	profiler, err := newEnergyProfiler(source, []string{"Example"}, time.Millisecond)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return source.sampleCount() >= 2 }, 5*time.Second, time.Millisecond)
+	report := profiler.finish()
+	assert.Equal(t, "device went away", report.Error)
+	assert.Equal(t, 1, report.Processes[0].Samples)
+	assert.Equal(t, 0, report.BatteryDrain)
+}
+
+func TestListenerReportsEnergy(t *testing.T) {
+	var buf bytes.Buffer
+	listener := NewTestListener(&bytes.Buffer{}, &bytes.Buffer{}, t.TempDir())
+	listener.AddReporter(NewJSONReporter(&buf))
+	listener.TestSuites = reportTestSuites()
+	listener.Energy = &EnergyReport{BatteryStart: 90, BatteryEnd: 89, BatteryDrain: 1, Processes: []ProcessEnergy{{Process: "Example", Samples: 3, MaxCost: 5}}}
+	_, err := listener.results()
+	require.NoError(t, err)
+
+	var report TestReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	assert.Equal(t, 4, report.Summary.Total)
+	assert.Equal(t, listener.Energy, report.Energy)
+}
@@ -0,0 +1,188 @@
+package testmanagerd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+)
+
+// runXCUITest runs the tests of one device of a parallel run, tests replace it
+var runXCUITest = RunXCUITestCtx
+
+// ParallelConfig configures running the same tests on several devices
+type ParallelConfig struct {
+	BundleID           string
+	TestRunnerBundleID string
+	XCTestConfigName   string
+	Args               []string
+	Env                []string
+	TestsToRun         []string
+	TestsToSkip        []string
+	IsXCTest           bool
+	// Shard splits TestsToRun across the devices instead of running all of them on every device,
+	// the device at index i runs Shard{Index: i, Total: len(devices)}
+	Shard bool
+	// Retries runs failed test cases again on the same device, see WithRetries. With FailFast, the other devices
+	// are stopped once a device still has failed test cases after its retries.
+	Retries int
+	// MaxParallel limits how many devices run the tests at the same time, 0 runs them on all devices at once
+	MaxParallel int
+	// FailFast stops the runs of all devices as soon as a test failed or the run of a device ended with an error.
+	// Devices that did not start yet are skipped.
+	FailFast bool
+	// NewListener creates the listener of a device, f.ex. to write its log to a file or to add reporters.
+	// By default the logs are discarded.
+	NewListener func(device ios.DeviceEntry) *TestListener
+}
+
+// DeviceResult is the outcome of the tests on one device
+type DeviceResult struct {
+	UDID    string            `json:"udid"`
+	Suites  []TestSuite       `json:"-"`
+	Summary TestReportSummary `json:"summary"`
+	// Err is why the run of the device ended early, f.ex. because the test runner crashed
+	Err error `json:"-"`
+	// Skipped is true if the tests did not start on the device, because the run was stopped before
+	Skipped  bool      `json:"skipped"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+// ParallelResult is the outcome of the tests on all devices
+type ParallelResult struct {
+	// Devices are the results in the order of the devices
+	Devices []DeviceResult `json:"devices"`
+	// Summary counts the test cases of all devices
+	Summary TestReportSummary `json:"summary"`
+}
+
+// Failed returns true if a test failed, a device did not finish its run or was skipped
+func (r ParallelResult) Failed() bool {
+	for _, device := range r.Devices {
+		if device.Skipped || device.Err != nil || device.Summary.Failed > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RunXCUITestParallel runs the same tests on all devices concurrently, every device with its own TestListener.
+// It returns when the runs of all devices ended and their test runners were stopped, also if ctx is done.
+func RunXCUITestParallel(ctx context.Context, devices []ios.DeviceEntry, config ParallelConfig) ParallelResult {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	limit := config.MaxParallel
+	if limit <= 0 || limit > len(devices) {
+		limit = len(devices)
+	}
+	slots := make(chan struct{}, limit)
+	results := make([]DeviceResult, len(devices))
+	var wg sync.WaitGroup
+	// devices start in their order, so the first ones are never skipped for later ones
+	for i, device := range devices {
+		result := &results[i]
+		result.UDID = device.Properties.SerialNumber
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		// the slot may have been free while ctx was done already
+		if ctx.Err() != nil {
+			result.Skipped = true
+			continue
+		}
+		wg.Add(1)
+		selection := TestSelection{TestsToRun: config.TestsToRun, TestsToSkip: config.TestsToSkip}
+		if config.Shard {
+			selection.Shard = &Shard{Index: i, Total: len(devices)}
+		}
+		go func(device ios.DeviceEntry) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			runDevice(ctx, device, config, selection, stop, result)
+		}(device)
+	}
+	wg.Wait()
+
+	result := ParallelResult{Devices: results}
+	unfinished := 0
+	for _, device := range results {
+		result.Summary.add(device.Summary)
+		if device.Skipped || device.Err != nil {
+			unfinished++
+		}
+	}
+	if unfinished > 0 {
+		result.Summary.Error = fmt.Sprintf("%d of %d devices did not finish the tests", unfinished, len(devices))
+	}
+	return result
+}
+
+func runDevice(ctx context.Context, device ios.DeviceEntry, config ParallelConfig, selection TestSelection, stop context.CancelFunc, result *DeviceResult) {
+	testsToRun, testsToSkip, err := selection.Resolve()
+	if err != nil {
+		result.Err = err
+		result.Summary = NewTestReport(nil, err).Summary
+		return
+	}
+	if testsToRun != nil && len(testsToRun) == 0 {
+		// more devices than tests, this shard has nothing to run
+		return
+	}
+	var listener *TestListener
+	if config.NewListener != nil {
+		listener = config.NewListener(device)
+	}
+	if listener == nil {
+		listener = NewTestListener(io.Discard, io.Discard, "")
+	}
+	var failed atomic.Bool
+	// with retries a failed test case may still pass, so the run stops only after the retries of the device failed
+	if config.FailFast && config.Retries <= 0 {
+		finished := listener.Events.TestCaseFinished
+		listener.Events.TestCaseFinished = func(testCase TestCase) {
+			if finished != nil {
+				finished(testCase)
+			}
+			if testCase.Status == StatusFailed {
+				failed.Store(true)
+				stop()
+			}
+		}
+	}
+	result.Started = time.Now()
+	suites, err := runXCUITestWithRetries(ctx, config.Retries, config.BundleID, config.TestRunnerBundleID, config.XCTestConfigName, device, config.Args, config.Env, testsToRun, testsToSkip, listener, config.IsXCTest)
+	result.Finished = time.Now()
+	if config.FailFast && config.Retries > 0 && len(failedTests(suites)) > 0 {
+		failed.Store(true)
+		stop()
+	}
+	// a runner stopped by ctx returns the results so far without an error,
+	// unless this device failed and stopped the others it did not finish its run
+	if err == nil && ctx.Err() != nil && !failed.Load() {
+		err = ctx.Err()
+	}
+	if err != nil && config.FailFast && !errors.Is(err, context.Canceled) {
+		stop()
+	}
+	result.Suites, result.Err = suites, err
+	result.Summary = NewTestReport(suites, err).Summary
+}
+
+// add counts the test cases of other as well
+func (s *TestReportSummary) add(other TestReportSummary) {
+	s.Total += other.Total
+	s.Passed += other.Passed
+	s.Failed += other.Failed
+	s.Skipped += other.Skipped
+	s.ExpectedFailures += other.ExpectedFailures
+	s.Stalled += other.Stalled
+	s.Flaky += other.Flaky
+	s.Duration += other.Duration
+}
@@ -0,0 +1,114 @@
+package testmanagerd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+)
+
+func parallelDevices(udids ...string) []ios.DeviceEntry {
+	devices := make([]ios.DeviceEntry, len(udids))
+	for i, udid := range udids {
+		devices[i].Properties.SerialNumber = udid
+	}
+	return devices
+}
+
+func suiteWith(status TestCaseStatus) []TestSuite {
+	return []TestSuite{{Name: "Suite", TestCases: []TestCase{{ClassName: "Suite", MethodName: "test", Status: status}}}}
+}
+
+func TestRunXCUITestParallelLimitsParallelism(t *testing.T) {
+	defer func(old func(context.Context, string, string, string, ios.DeviceEntry, []string, []string, []string, []string, *TestListener, bool) ([]TestSuite, error)) {
+		runXCUITest = old
+	}(runXCUITest)
+	var running, maxRunning int32
+	runXCUITest = func(ctx context.Context, bundleID, testRunnerBundleID, xctestConfigName string, device ios.DeviceEntry, args, env, testsToRun, testsToSkip []string, listener *TestListener, isXCTest bool) ([]TestSuite, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&maxRunning)
+			if n <= old || atomic.CompareAndSwapInt32(&maxRunning, old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return suiteWith(StatusPassed), nil
+	}
+
+	result := RunXCUITestParallel(context.Background(), parallelDevices("a", "b", "c", "d", "e"), ParallelConfig{MaxParallel: 2})
+
+	assert.Equal(t, int32(2), maxRunning)
+	assert.False(t, result.Failed())
+	assert.Equal(t, 5, result.Summary.Total)
+	assert.Equal(t, 5, result.Summary.Passed)
+	assert.Empty(t, result.Summary.Error)
+	for i, udid := range []string{"a", "b", "c", "d", "e"} {
+		assert.Equal(t, udid, result.Devices[i].UDID)
+	}
+}
+
+func TestRunXCUITestParallelFailFast(t *testing.T) {
+	defer func(old func(context.Context, string, string, string, ios.DeviceEntry, []string, []string, []string, []string, *TestListener, bool) ([]TestSuite, error)) {
+		runXCUITest = old
+	}(runXCUITest)
+	var finished sync.Map
+	runXCUITest = func(ctx context.Context, bundleID, testRunnerBundleID, xctestConfigName string, device ios.DeviceEntry, args, env, testsToRun, testsToSkip []string, listener *TestListener, isXCTest bool) ([]TestSuite, error) {
+		if device.Properties.SerialNumber == "failing" {
+			listener.Events.TestCaseFinished(TestCase{Status: StatusFailed})
+			return suiteWith(StatusFailed), nil
+		}
+		<-ctx.Done()
+		finished.Store(device.Properties.SerialNumber, true)
+		return nil, ctx.Err()
+	}
+	var listenerCalls int32
+	config := ParallelConfig{
+		MaxParallel: 2,
+		FailFast:    true,
+		NewListener: func(device ios.DeviceEntry) *TestListener {
+			listener := NewTestListener(nil, nil, "")
+			listener.Events.TestCaseFinished = func(TestCase) { atomic.AddInt32(&listenerCalls, 1) }
+			return listener
+		},
+	}
+
+	result := RunXCUITestParallel(context.Background(), parallelDevices("slow", "failing", "waiting"), config)
+
+	assert.True(t, result.Failed())
+	assert.Equal(t, int32(1), listenerCalls)
+	assert.Equal(t, 1, result.Summary.Failed)
+	assert.ErrorIs(t, result.Devices[0].Err, context.Canceled)
+	_, ok := finished.Load("slow")
+	assert.True(t, ok, "runs are waited for before returning")
+	assert.NoError(t, result.Devices[1].Err)
+	assert.True(t, result.Devices[2].Skipped)
+	assert.Equal(t, "2 of 3 devices did not finish the tests", result.Summary.Error)
+}
+
+func TestRunXCUITestParallelWithoutFailFast(t *testing.T) {
+	defer func(old func(context.Context, string, string, string, ios.DeviceEntry, []string, []string, []string, []string, *TestListener, bool) ([]TestSuite, error)) {
+		runXCUITest = old
+	}(runXCUITest)
+	runXCUITest = func(ctx context.Context, bundleID, testRunnerBundleID, xctestConfigName string, device ios.DeviceEntry, args, env, testsToRun, testsToSkip []string, listener *TestListener, isXCTest bool) ([]TestSuite, error) {
+		if device.Properties.SerialNumber == "broken" {
+			return nil, errors.New("test runner crashed")
+		}
+		return suiteWith(StatusPassed), nil
+	}
+
+	result := RunXCUITestParallel(context.Background(), parallelDevices("broken", "a", "b"), ParallelConfig{MaxParallel: 1})
+
+	assert.True(t, result.Failed())
+	assert.EqualError(t, result.Devices[0].Err, "test runner crashed")
+	assert.False(t, result.Devices[1].Skipped)
+	assert.False(t, result.Devices[2].Skipped)
+	assert.Equal(t, 2, result.Summary.Passed)
+	assert.Equal(t, "1 of 3 devices did not finish the tests", result.Summary.Error)
+}
@@ -140,7 +140,7 @@ func (p proxyDispatcher) Dispatch(m dtx.Message) {
 				break
 			}
 
-			testIdentifier, decoderErr := extractTestIdentifierArg(m, 0)
+			testIdentifier, decoderErr := extractTestCaseIdentifierArg(m, 0)
 			if decoderErr != nil {
 				break
 			}
@@ -215,7 +215,7 @@ func (p proxyDispatcher) Dispatch(m dtx.Message) {
 				break
 			}
 
-			testIdentifier, decoderErr := extractTestIdentifierArg(m, 0)
+			testIdentifier, decoderErr := extractTestCaseIdentifierArg(m, 0)
 			if decoderErr != nil {
 				break
 			}
@@ -224,7 +224,7 @@ func (p proxyDispatcher) Dispatch(m dtx.Message) {
 				break
 			}
 
-			p.testListener.testCaseFailedForClass(testIdentifier.C[0], testIdentifier.C[1], issue.CompactDescription, issue.SourceCodeContext.Location.FileUrl.Path, issue.SourceCodeContext.Location.LineNumber)
+			p.testListener.testCaseFailedForClass(testIdentifier.C[0], testIdentifier.C[1], issueMessage(issue), issue.SourceCodeContext.Location.FileUrl.Path, issue.SourceCodeContext.Location.LineNumber)
 		case "_XCT_testCaseDidFinishForTestClass:method:withStatus:duration:":
 			argumentLengthErr := assertArgumentsLengthEqual(m, 4)
 			if argumentLengthErr != nil {
@@ -257,7 +257,7 @@ func (p proxyDispatcher) Dispatch(m dtx.Message) {
 				break
 			}
 
-			testIdentifier, decoderErr := extractTestIdentifierArg(m, 0)
+			testIdentifier, decoderErr := extractTestCaseIdentifierArg(m, 0)
 			if decoderErr != nil {
 				break
 			}
@@ -295,7 +295,7 @@ func (p proxyDispatcher) Dispatch(m dtx.Message) {
 				break
 			}
 
-			testIdentifier, decoderErr := extractTestIdentifierArg(m, 0)
+			testIdentifier, decoderErr := extractTestCaseIdentifierArg(m, 0)
 			if decoderErr != nil {
 				break
 			}
@@ -564,6 +564,26 @@ func extractTestIdentifierArg(m dtx.Message, index int) (nskeyedarchiver.XCTTest
 	return data[0].(nskeyedarchiver.XCTTestIdentifier), nil
 }
 
+// extractTestCaseIdentifierArg extracts the identifier of a test case, its first component is the class and the second the method
+func extractTestCaseIdentifierArg(m dtx.Message, index int) (nskeyedarchiver.XCTTestIdentifier, error) {
+	testIdentifier, err := extractTestIdentifierArg(m, index)
+	if err != nil {
+		return testIdentifier, err
+	}
+	if len(testIdentifier.C) < 2 {
+		return testIdentifier, fmt.Errorf("extractTestCaseIdentifierArg: expected class and method but got %v", testIdentifier.C)
+	}
+	return testIdentifier, nil
+}
+
+// issueMessage prefers the compact description of an issue which is the message the test failed with
+func issueMessage(issue nskeyedarchiver.XCTIssue) string {
+	if issue.CompactDescription != "" {
+		return issue.CompactDescription
+	}
+	return issue.DetailedDescription
+}
+
 func extractIssueArg(m dtx.Message, index int) (nskeyedarchiver.XCTIssue, error) {
 	mbytes, ok := m.Auxiliary.GetArguments()[index].([]byte)
 	if !ok {
@@ -0,0 +1,313 @@
+package testmanagerd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// TestReporter writes the results of a test run, f.ex. as a report file for a CI system.
+// Add reporters to a TestListener with AddReporter, the runners call them once the test run is over.
+type TestReporter interface {
+	// Report is called with all finished test suites and the error the test run ended with, if any
+	Report(suites []TestSuite, runErr error) error
+}
+
+// TestReport is the machine-readable summary of a test run JSONReporter writes
+type TestReport struct {
+	Summary TestReportSummary `json:"summary"`
+	Suites  []TestSuiteReport `json:"suites"`
+	// Crashes are crash reports of the app under test collected after the test run, NewTestReport leaves it empty
+	Crashes []TestAttachment `json:"crashes,omitempty"`
+	// Energy is the energy profile of runs with WithEnergyProfiling, NewTestReport leaves it empty
+	Energy *EnergyReport `json:"energy,omitempty"`
+}
+
+// TestReportSummary counts the test cases of a test run by status, flaky test cases passed after they were retried
+type TestReportSummary struct {
+	Total            int     `json:"total"`
+	Passed           int     `json:"passed"`
+	Failed           int     `json:"failed"`
+	Skipped          int     `json:"skipped"`
+	ExpectedFailures int     `json:"expectedFailures"`
+	Stalled          int     `json:"stalled"`
+	Flaky            int     `json:"flaky"`
+	Duration         float64 `json:"duration"`
+	// Error is set if the test run did not finish, f.ex. because the test runner crashed
+	Error string `json:"error,omitempty"`
+}
+
+// TestSuiteReport is a TestSuite with durations in seconds
+type TestSuiteReport struct {
+	Name      string           `json:"name"`
+	StartDate time.Time        `json:"startDate"`
+	EndDate   time.Time        `json:"endDate"`
+	Duration  float64          `json:"duration"`
+	TestCases []TestCaseReport `json:"testCases"`
+}
+
+// TestCaseReport is a TestCase with the duration in seconds
+type TestCaseReport struct {
+	ClassName   string           `json:"className"`
+	MethodName  string           `json:"methodName"`
+	Status      TestCaseStatus   `json:"status"`
+	Duration    float64          `json:"duration"`
+	Failure     *TestError       `json:"failure,omitempty"`
+	Attachments []TestAttachment `json:"attachments,omitempty"`
+	// Attempts are the earlier runs of a retried test case, the fields above are the result of the last one
+	Attempts []TestAttemptReport `json:"attempts,omitempty"`
+}
+
+// TestAttemptReport is a TestAttempt with the duration in seconds
+type TestAttemptReport struct {
+	Status      TestCaseStatus   `json:"status"`
+	Duration    float64          `json:"duration"`
+	Failure     *TestError       `json:"failure,omitempty"`
+	Attachments []TestAttachment `json:"attachments,omitempty"`
+}
+
+// NewTestReport summarizes the results of a test run
+func NewTestReport(suites []TestSuite, runErr error) TestReport {
+	report := TestReport{Suites: make([]TestSuiteReport, 0, len(suites))}
+	if runErr != nil {
+		report.Summary.Error = runErr.Error()
+	}
+	for _, suite := range suites {
+		suiteReport := TestSuiteReport{
+			Name:      suite.Name,
+			StartDate: suite.StartDate,
+			EndDate:   suite.EndDate,
+			Duration:  suite.TotalDuration.Seconds(),
+			TestCases: make([]TestCaseReport, 0, len(suite.TestCases)),
+		}
+		report.Summary.Duration += suite.TotalDuration.Seconds()
+		for _, testCase := range suite.TestCases {
+			caseReport := TestCaseReport{
+				ClassName:   testCase.ClassName,
+				MethodName:  testCase.MethodName,
+				Status:      testCase.Status,
+				Duration:    testCase.Duration.Seconds(),
+				Attachments: testCase.Attachments,
+			}
+			if testCase.Err != (TestError{}) {
+				failure := testCase.Err
+				caseReport.Failure = &failure
+			}
+			for _, attempt := range testCase.Attempts {
+				attemptReport := TestAttemptReport{Status: attempt.Status, Duration: attempt.Duration.Seconds(), Attachments: attempt.Attachments}
+				if attempt.Err != (TestError{}) {
+					failure := attempt.Err
+					attemptReport.Failure = &failure
+				}
+				caseReport.Attempts = append(caseReport.Attempts, attemptReport)
+			}
+			report.Summary.count(testCase.Status)
+			if testCase.Flaky() {
+				report.Summary.Flaky++
+			}
+			suiteReport.TestCases = append(suiteReport.TestCases, caseReport)
+		}
+		report.Suites = append(report.Suites, suiteReport)
+	}
+	return report
+}
+
+func (s *TestReportSummary) count(status TestCaseStatus) {
+	s.Total++
+	switch status {
+	case StatusPassed:
+		s.Passed++
+	case StatusSkipped:
+		s.Skipped++
+	case StatusExpectedFailure:
+		s.ExpectedFailures++
+	case StatusStalled:
+		s.Stalled++
+	default:
+		// test cases that never finished have no status, they count as failed
+		s.Failed++
+	}
+}
+
+// JSONReporter writes a TestReport as json
+type JSONReporter struct {
+	w io.Writer
+}
+
+// NewJSONReporter creates a reporter that writes a TestReport to w
+func NewJSONReporter(w io.Writer) JSONReporter {
+	return JSONReporter{w: w}
+}
+
+// Report writes the json report
+func (r JSONReporter) Report(suites []TestSuite, runErr error) error {
+	return r.write(NewTestReport(suites, runErr))
+}
+
+// ReportEnergy writes the json report with the energy profile
+func (r JSONReporter) ReportEnergy(suites []TestSuite, runErr error, energy EnergyReport) error {
+	report := NewTestReport(suites, runErr)
+	report.Energy = &energy
+	return r.write(report)
+}
+
+func (r JSONReporter) write(report TestReport) error {
+	encoder := json.NewEncoder(r.w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(report)
+	if err != nil {
+		return fmt.Errorf("Report: failed writing json report: %w", err)
+	}
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	// FlakyFailures and RerunFailures are the failed attempts of retried test cases, like Maven Surefire reports them
+	FlakyFailures []junitProblem `xml:"flakyFailure,omitempty"`
+	RerunFailures []junitProblem `xml:"rerunFailure,omitempty"`
+	SystemOut     string         `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// JUnitReporter writes the results in the JUnit XML format most CI systems understand
+type JUnitReporter struct {
+	w    io.Writer
+	name string
+}
+
+// NewJUnitReporter creates a reporter that writes JUnit XML to w, name is the name of the testsuites element
+func NewJUnitReporter(w io.Writer, name string) JUnitReporter {
+	return JUnitReporter{w: w, name: name}
+}
+
+// Report writes the JUnit XML report. Failed test cases have a failure element with the location of the failure,
+// stalled test cases and test cases that never finished an error element. Failed attempts of retried test cases
+// are flakyFailure elements if the test case passed in the end and rerunFailure elements otherwise. Attachments are referenced in system-out
+// with the [[ATTACHMENT|path]] syntax of the Jenkins JUnit attachments plugin.
+func (r JUnitReporter) Report(suites []TestSuite, runErr error) error {
+	report := junitTestSuites{Name: r.name, Suites: make([]junitTestSuite, 0, len(suites))}
+	var total time.Duration
+	for _, suite := range suites {
+		junitSuite := junitTestSuite{
+			Name:      suite.Name,
+			Tests:     len(suite.TestCases),
+			Time:      junitSeconds(suite.TotalDuration),
+			Timestamp: suite.StartDate.UTC().Format("2006-01-02T15:04:05"),
+			Cases:     make([]junitTestCase, 0, len(suite.TestCases)),
+		}
+		for _, testCase := range suite.TestCases {
+			junitCase := junitTestCase{
+				ClassName: testCase.ClassName,
+				Name:      testCase.MethodName,
+				Time:      junitSeconds(testCase.Duration),
+			}
+			switch testCase.Status {
+			case StatusPassed, StatusExpectedFailure:
+			case StatusSkipped:
+				junitCase.Skipped = &struct{}{}
+				junitSuite.Skipped++
+			case StatusFailed:
+				junitCase.Failure = junitProblemFor(testCase, "failure")
+				junitSuite.Failures++
+			case StatusStalled:
+				junitCase.Error = junitProblemFor(testCase, "stalled")
+				junitSuite.Errors++
+			default:
+				junitCase.Error = &junitProblem{Message: "test case did not finish", Type: "error"}
+				junitSuite.Errors++
+			}
+			for _, attempt := range testCase.Attempts {
+				problem := *junitProblemFor(TestCase{Err: attempt.Err}, string(attempt.Status))
+				if testCase.Status == StatusPassed {
+					junitCase.FlakyFailures = append(junitCase.FlakyFailures, problem)
+				} else {
+					junitCase.RerunFailures = append(junitCase.RerunFailures, problem)
+				}
+			}
+			for _, attachment := range testCase.Attachments {
+				junitCase.SystemOut += fmt.Sprintf("[[ATTACHMENT|%s]]\n", attachment.Path)
+			}
+			junitSuite.Cases = append(junitSuite.Cases, junitCase)
+		}
+		report.Tests += junitSuite.Tests
+		report.Failures += junitSuite.Failures
+		report.Errors += junitSuite.Errors
+		report.Skipped += junitSuite.Skipped
+		total += suite.TotalDuration
+		report.Suites = append(report.Suites, junitSuite)
+	}
+	if runErr != nil {
+		// a test run that did not finish is reported as a suite with one errored test case, otherwise CI systems
+		// would show an incomplete run as green
+		report.Suites = append(report.Suites, junitTestSuite{
+			Name:   "go-ios",
+			Tests:  1,
+			Errors: 1,
+			Time:   junitSeconds(0),
+			Cases:  []junitTestCase{{ClassName: "go-ios", Name: "testRun", Time: junitSeconds(0), Error: &junitProblem{Message: runErr.Error(), Type: "error"}}},
+		})
+		report.Tests++
+		report.Errors++
+	}
+	report.Time = junitSeconds(total)
+
+	_, err := io.WriteString(r.w, xml.Header)
+	if err != nil {
+		return fmt.Errorf("Report: failed writing junit report: %w", err)
+	}
+	encoder := xml.NewEncoder(r.w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(report)
+	if err != nil {
+		return fmt.Errorf("Report: failed writing junit report: %w", err)
+	}
+	_, err = io.WriteString(r.w, "\n")
+	return err
+}
+
+func junitProblemFor(testCase TestCase, problemType string) *junitProblem {
+	problem := &junitProblem{Message: testCase.Err.Message, Type: problemType}
+	if testCase.Err.File != "" {
+		problem.Text = fmt.Sprintf("%s:%d", testCase.Err.File, testCase.Err.Line)
+	}
+	return problem
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,112 @@
+package testmanagerd
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reportTestSuites() []TestSuite {
+	return []TestSuite{{
+		Name:          "LoginTests",
+		StartDate:     time.Date(2024, 1, 16, 15, 36, 43, 0, time.UTC),
+		EndDate:       time.Date(2024, 1, 16, 15, 36, 50, 0, time.UTC),
+		TotalDuration: 7 * time.Second,
+		TestCases: []TestCase{
+			{ClassName: "LoginTests", MethodName: "testLogin", Status: StatusPassed, Duration: time.Second},
+			{ClassName: "LoginTests", MethodName: "testLogout", Status: StatusFailed, Duration: 2 * time.Second,
+				Err:         TestError{Message: "XCTAssertTrue failed", File: "/src/LoginTests.swift", Line: 42},
+				Attachments: []TestAttachment{{Name: "screenshot", Path: "/tmp/screenshot.png"}}},
+			{ClassName: "LoginTests", MethodName: "testSignup", Status: StatusSkipped},
+			{ClassName: "LoginTests", MethodName: "testReset", Status: StatusStalled, Err: TestError{Message: "Test case stalled"}},
+		},
+	}}
+}
+
+func TestJSONReporter(t *testing.T) {
+	var buf bytes.Buffer
+	err := NewJSONReporter(&buf).Report(reportTestSuites(), nil)
+	require.NoError(t, err)
+
+	var report TestReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	assert.Equal(t, TestReportSummary{Total: 4, Passed: 1, Failed: 1, Skipped: 1, Stalled: 1, Duration: 7}, report.Summary)
+	assert.Equal(t, 4, len(report.Suites[0].TestCases))
+	assert.Nil(t, report.Suites[0].TestCases[0].Failure)
+	assert.Equal(t, &TestError{Message: "XCTAssertTrue failed", File: "/src/LoginTests.swift", Line: 42}, report.Suites[0].TestCases[1].Failure)
+	assert.Equal(t, 2.0, report.Suites[0].TestCases[1].Duration)
+}
+
+func TestJUnitReporter(t *testing.T) {
+	var buf bytes.Buffer
+	err := NewJUnitReporter(&buf, "com.example.app").Report(reportTestSuites(), errors.New("lost connection to testmanagerd"))
+	require.NoError(t, err)
+
+	var report junitTestSuites
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &report))
+	assert.Equal(t, "com.example.app", report.Name)
+	assert.Equal(t, 5, report.Tests)
+	assert.Equal(t, 1, report.Failures)
+	assert.Equal(t, 2, report.Errors)
+	assert.Equal(t, 1, report.Skipped)
+
+	cases := report.Suites[0].Cases
+	assert.Nil(t, cases[0].Failure)
+	assert.Equal(t, "XCTAssertTrue failed", cases[1].Failure.Message)
+	assert.Equal(t, "/src/LoginTests.swift:42", cases[1].Failure.Text)
+	assert.Equal(t, "[[ATTACHMENT|/tmp/screenshot.png]]\n", cases[1].SystemOut)
+	assert.NotNil(t, cases[2].Skipped)
+	assert.Equal(t, "stalled", cases[3].Error.Type)
+	assert.Equal(t, "lost connection to testmanagerd", report.Suites[1].Cases[0].Error.Message)
+}
+
+func TestAllureReporter(t *testing.T) {
+	dir := t.TempDir()
+	screenshot := filepath.Join(t.TempDir(), "screenshot")
+	require.NoError(t, os.WriteFile(screenshot, []byte("png"), 0o644))
+	suites := reportTestSuites()
+	suites[0].TestCases[1].Attachments = []TestAttachment{{Name: "screenshot", Path: screenshot, Activity: "Tap login", UniformTypeIdentifier: "public.png"}}
+	suites[0].TestCases[0].Attempts = []TestAttempt{{Status: StatusFailed, Err: TestError{Message: "timeout"}, Duration: time.Second}}
+
+	err := NewAllureReporter(dir, AllureDeviceLabels("iPhone14,2", "17.2", "")...).Report(suites, errors.New("lost connection to testmanagerd"))
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*-result.json"))
+	require.NoError(t, err)
+	results := map[string][]allureResult{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		var result allureResult
+		require.NoError(t, json.Unmarshal(data, &result))
+		results[result.FullName] = append(results[result.FullName], result)
+	}
+	assert.Len(t, files, 6)
+	assert.Len(t, results["LoginTests.testLogin"], 2, "the failed attempt is a retry with the same history id")
+	assert.Equal(t, results["LoginTests.testLogin"][0].HistoryID, results["LoginTests.testLogin"][1].HistoryID)
+	assert.Equal(t, "skipped", results["LoginTests.testSignup"][0].Status)
+	assert.Equal(t, "broken", results["LoginTests.testReset"][0].Status)
+	assert.Equal(t, "broken", results["go-ios.testRun"][0].Status)
+
+	failed := results["LoginTests.testLogout"][0]
+	assert.Equal(t, "failed", failed.Status)
+	assert.Equal(t, &allureDetails{Message: "XCTAssertTrue failed", Trace: "/src/LoginTests.swift:42"}, failed.StatusDetails)
+	assert.Equal(t, suites[0].StartDate.Add(2*time.Second).UnixMilli(), failed.Start)
+	assert.Contains(t, failed.Labels, AllureLabel{Name: "deviceModel", Value: "iPhone14,2"})
+	assert.Contains(t, failed.Labels, AllureLabel{Name: "osVersion", Value: "iOS 17.2"})
+	require.Len(t, failed.Steps, 1)
+	assert.Equal(t, "Tap login", failed.Steps[0].Name)
+	attachment := failed.Steps[0].Attachments[0]
+	assert.Equal(t, "image/png", attachment.Type)
+	data, err := os.ReadFile(filepath.Join(dir, attachment.Source))
+	require.NoError(t, err)
+	assert.Equal(t, "png", string(data))
+}
@@ -0,0 +1,113 @@
+package testmanagerd
+
+import (
+	"context"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	log "github.com/sirupsen/logrus"
+)
+
+// TestAttempt is an earlier run of a test case that was retried
+type TestAttempt struct {
+	Status      TestCaseStatus
+	Err         TestError
+	Duration    time.Duration
+	Attachments []TestAttachment
+}
+
+// Flaky returns true if the test case passed after failed attempts
+func (t TestCase) Flaky() bool {
+	return t.Status == StatusPassed && len(t.Attempts) > 0
+}
+
+// retryable returns true for test cases that failed, stalled or never finished
+func (t TestCase) retryable() bool {
+	switch t.Status {
+	case StatusPassed, StatusSkipped, StatusExpectedFailure:
+		return false
+	}
+	return true
+}
+
+// runWithRetries runs the tests once and then the failed test cases again, each retry in a fresh test session
+// that only runs the test cases that still fail, until they pass or retries attempts were made.
+// The results of a retry replace the result of the test case, earlier results are kept in its Attempts.
+// The reporters of listener get the merged results, its events are sent for every attempt.
+func runWithRetries(ctx context.Context, retries int, testsToRun []string, listener *TestListener,
+	run func(testsToRun []string, listener *TestListener) ([]TestSuite, error),
+) ([]TestSuite, error) {
+	reporters := listener.reporters
+	listener.reporters = nil
+
+	suites, runErr := run(testsToRun, listener)
+	for attempt := 1; attempt <= retries && ctx.Err() == nil; attempt++ {
+		failed := failedTests(suites)
+		if len(failed) == 0 {
+			break
+		}
+		log.WithField("attempt", attempt).WithField("tests", failed).Info("retrying failed test cases")
+		retryListener := NewTestListener(listener.logWriter, listener.debugLogWriter, listener.attachmentsDirectory)
+		retryListener.Events = listener.Events
+		retried, err := run(failed, retryListener)
+		if err != nil {
+			log.WithError(err).WithField("attempt", attempt).Warn("retry of failed test cases did not finish")
+		}
+		mergeRetry(suites, retried)
+	}
+
+	listener.TestSuites = suites
+	listener.err = runErr
+	listener.reporters = reporters
+	return listener.results()
+}
+
+// failedTests returns the identifiers of the failed test cases, like "LoginTests/testLogout"
+func failedTests(suites []TestSuite) []string {
+	failed := []string{}
+	for _, suite := range suites {
+		for _, testCase := range suite.TestCases {
+			if testCase.retryable() {
+				failed = append(failed, testCase.ClassName+"/"+testCase.MethodName)
+			}
+		}
+	}
+	return failed
+}
+
+// mergeRetry replaces the results of the retried test cases, test cases missing in retried keep their result
+func mergeRetry(suites []TestSuite, retried []TestSuite) {
+	for _, retriedSuite := range retried {
+		for _, retriedCase := range retriedSuite.TestCases {
+			testCase := findResult(suites, retriedCase.ClassName, retriedCase.MethodName)
+			if testCase == nil || !testCase.retryable() {
+				continue
+			}
+			attempts := append(testCase.Attempts, TestAttempt{Status: testCase.Status, Err: testCase.Err, Duration: testCase.Duration, Attachments: testCase.Attachments})
+			*testCase = retriedCase
+			testCase.Attempts = attempts
+		}
+	}
+}
+
+func findResult(suites []TestSuite, className string, methodName string) *TestCase {
+	for i := range suites {
+		for j := range suites[i].TestCases {
+			testCase := &suites[i].TestCases[j]
+			if testCase.ClassName == className && testCase.MethodName == methodName {
+				return testCase
+			}
+		}
+	}
+	return nil
+}
+
+// runXCUITestWithRetries runs the tests with runXCUITest and retries failed test cases
+func runXCUITestWithRetries(ctx context.Context, retries int, bundleID string, testRunnerBundleID string, xctestConfigName string, device ios.DeviceEntry, args []string, env []string, testsToRun []string, testsToSkip []string, testListener *TestListener, isXCTest bool) ([]TestSuite, error) {
+	if retries <= 0 {
+		return runXCUITest(ctx, bundleID, testRunnerBundleID, xctestConfigName, device, args, env, testsToRun, testsToSkip, testListener, isXCTest)
+	}
+	return runWithRetries(ctx, retries, testsToRun, testListener, func(testsToRun []string, listener *TestListener) ([]TestSuite, error) {
+		return runXCUITest(ctx, bundleID, testRunnerBundleID, xctestConfigName, device, args, env, testsToRun, testsToSkip, listener, isXCTest)
+	})
+}
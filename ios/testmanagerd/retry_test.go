@@ -0,0 +1,89 @@
+package testmanagerd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingReporter struct {
+	calls  int
+	suites []TestSuite
+}
+
+func (r *recordingReporter) Report(suites []TestSuite, runErr error) error {
+	r.calls++
+	r.suites = suites
+	return nil
+}
+
+func TestRetryFailedTestCases(t *testing.T) {
+	defer func(old func(context.Context, string, string, string, ios.DeviceEntry, []string, []string, []string, []string, *TestListener, bool) ([]TestSuite, error)) {
+		runXCUITest = old
+	}(runXCUITest)
+	var runs [][]string
+	runXCUITest = func(ctx context.Context, bundleID, testRunnerBundleID, xctestConfigName string, device ios.DeviceEntry, args, env, testsToRun, testsToSkip []string, listener *TestListener, isXCTest bool) ([]TestSuite, error) {
+		runs = append(runs, testsToRun)
+		var cases []TestCase
+		switch len(runs) {
+		case 1:
+			cases = []TestCase{
+				{ClassName: "LoginTests", MethodName: "testLogin", Status: StatusPassed},
+				{ClassName: "LoginTests", MethodName: "testFlaky", Status: StatusFailed, Err: TestError{Message: "timeout"}},
+				{ClassName: "LoginTests", MethodName: "testBroken", Status: StatusFailed, Err: TestError{Message: "assert"}},
+			}
+		case 2:
+			cases = []TestCase{
+				{ClassName: "LoginTests", MethodName: "testFlaky", Status: StatusPassed},
+				{ClassName: "LoginTests", MethodName: "testBroken", Status: StatusFailed, Err: TestError{Message: "assert"}},
+			}
+		default:
+			cases = []TestCase{{ClassName: "LoginTests", MethodName: "testBroken", Status: StatusFailed, Err: TestError{Message: "assert"}}}
+		}
+		for _, testCase := range cases {
+			if listener.Events.TestCaseFinished != nil {
+				listener.Events.TestCaseFinished(testCase)
+			}
+		}
+		listener.TestSuites = []TestSuite{{Name: "LoginTests", TestCases: cases}}
+		return listener.results()
+	}
+	listener := NewTestListener(io.Discard, io.Discard, "")
+	reporter := &recordingReporter{}
+	listener.AddReporter(reporter)
+	var junit bytes.Buffer
+	listener.AddReporter(NewJUnitReporter(&junit, "app"))
+	finished := 0
+	listener.Events.TestCaseFinished = func(TestCase) { finished++ }
+
+	suites, err := RunXCUITestWithOptions(context.Background(), "com.example.app", "", "", ios.DeviceEntry{}, nil, nil, listener, false,
+		WithTestsToRun([]string{"LoginTests"}), WithRetries(2))
+
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"LoginTests"}, {"LoginTests/testFlaky", "LoginTests/testBroken"}, {"LoginTests/testBroken"}}, runs)
+	assert.Equal(t, 6, finished, "events are sent for every attempt")
+	assert.Equal(t, 1, reporter.calls, "reporters get the merged results once")
+	assert.Equal(t, suites, reporter.suites)
+
+	cases := suites[0].TestCases
+	assert.Empty(t, cases[0].Attempts)
+	assert.Equal(t, StatusPassed, cases[1].Status)
+	assert.True(t, cases[1].Flaky())
+	assert.Equal(t, []TestAttempt{{Status: StatusFailed, Err: TestError{Message: "timeout"}}}, cases[1].Attempts)
+	assert.Equal(t, StatusFailed, cases[2].Status)
+	assert.Len(t, cases[2].Attempts, 2)
+
+	summary := NewTestReport(suites, nil).Summary
+	assert.Equal(t, 3, summary.Total)
+	assert.Equal(t, 2, summary.Passed)
+	assert.Equal(t, 1, summary.Failed)
+	assert.Equal(t, 1, summary.Flaky)
+	assert.Equal(t, 1, strings.Count(junit.String(), "<flakyFailure"))
+	assert.Equal(t, 2, strings.Count(junit.String(), "<rerunFailure"))
+}
@@ -0,0 +1,151 @@
+package testmanagerd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/danielpaulus/go-ios/ios"
+	log "github.com/sirupsen/logrus"
+)
+
+// Shard is one of Total parts of a test suite, Index starts at 0
+type Shard struct {
+	Index int `json:"index"`
+	Total int `json:"total"`
+}
+
+// Validate returns an error if the index is not within the shards
+func (s Shard) Validate() error {
+	if s.Total < 1 || s.Index < 0 || s.Index >= s.Total {
+		return fmt.Errorf("invalid shard %d of %d, the index must be at least 0 and less than the total", s.Index, s.Total)
+	}
+	return nil
+}
+
+// Tests returns the tests of the shard. Tests are sorted and dealt to the shards in turn, so every device
+// that gets the same tests computes the same shards, no matter in which order the tests were listed.
+// The tests can be classes or single test methods like "LoginTests/testLogout".
+func (s Shard) Tests(tests []string) ([]string, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	sorted := append([]string{}, tests...)
+	sort.Strings(sorted)
+	shard := []string{}
+	n := 0
+	for i, test := range sorted {
+		if i > 0 && test == sorted[i-1] {
+			continue
+		}
+		if n%s.Total == s.Index {
+			shard = append(shard, test)
+		}
+		n++
+	}
+	return shard, nil
+}
+
+// TestSelection are the tests a run executes, how often failed ones are retried and whether the run is profiled
+type TestSelection struct {
+	// TestsToRun are the classes or methods to run, all tests run if it is empty
+	TestsToRun []string
+	// TestsToSkip are not run even if they are in TestsToRun
+	TestsToSkip []string
+	// Shard runs only a part of TestsToRun, so the tests can be split across several devices
+	Shard *Shard
+	// Retries is how often failed test cases are run again, each time in a new test session
+	Retries int
+	// EnergyProfiling samples the energy impact of the app under test and the test runner during the run
+	EnergyProfiling bool
+}
+
+// TestOption selects the tests of a run
+type TestOption func(*TestSelection)
+
+// WithTestsToRun runs only the given classes or methods, like "LoginTests" or "LoginTests/testLogout"
+func WithTestsToRun(tests []string) TestOption {
+	return func(s *TestSelection) {
+		s.TestsToRun = tests
+	}
+}
+
+// WithTestsToSkip does not run the given classes or methods
+func WithTestsToSkip(tests []string) TestOption {
+	return func(s *TestSelection) {
+		s.TestsToSkip = tests
+	}
+}
+
+// WithShard runs shard index of total shards of the tests to run, index starts at 0.
+// Sharding needs the tests to run, because testmanagerd can't list the tests of a bundle before running them.
+func WithShard(index, total int) TestOption {
+	return func(s *TestSelection) {
+		s.Shard = &Shard{Index: index, Total: total}
+	}
+}
+
+// WithRetries runs failed test cases again up to retries times until they pass. The results report the last attempt
+// of every test case and the earlier ones in its Attempts, test cases that passed after failing are flaky.
+func WithRetries(retries int) TestOption {
+	return func(s *TestSelection) {
+		s.Retries = retries
+	}
+}
+
+// WithEnergyProfiling samples the energy impact of the app under test and the test runner and the battery level
+// during the run. The EnergyReport is set on the listener and JSON reports include it, so battery regressions can
+// be tracked per build. Energy profiling needs a mounted developer disk image, the tests run without it if it
+// can't start.
+func WithEnergyProfiling() TestOption {
+	return func(s *TestSelection) {
+		s.EnergyProfiling = true
+	}
+}
+
+// NewTestSelection applies opts
+func NewTestSelection(opts ...TestOption) TestSelection {
+	var selection TestSelection
+	for _, opt := range opts {
+		opt(&selection)
+	}
+	return selection
+}
+
+// Resolve returns the tests to run and skip that go into the XCTestConfiguration, with the shard applied
+func (s TestSelection) Resolve() ([]string, []string, error) {
+	if s.Shard == nil {
+		return s.TestsToRun, s.TestsToSkip, nil
+	}
+	if len(s.TestsToRun) == 0 {
+		return nil, nil, errors.New("sharding needs the tests to run, testmanagerd can't list the tests of a bundle")
+	}
+	tests, err := s.Shard.Tests(s.TestsToRun)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tests, s.TestsToSkip, nil
+}
+
+// RunXCUITestWithOptions runs the tests like RunXCUITestCtx, opts select which tests run, how often failed ones are retried
+// and whether the run is profiled.
+// A shard without tests, because there are more shards than tests, finishes right away without results.
+func RunXCUITestWithOptions(ctx context.Context, bundleID string, testRunnerBundleID string, xctestConfigName string, device ios.DeviceEntry, args []string, env []string, testListener *TestListener, isXCTest bool, opts ...TestOption) ([]TestSuite, error) {
+	selection := NewTestSelection(opts...)
+	testsToRun, testsToSkip, err := selection.Resolve()
+	if err != nil {
+		return make([]TestSuite, 0), fmt.Errorf("RunXCUITestWithOptions: %w", err)
+	}
+	if testsToRun != nil && len(testsToRun) == 0 {
+		log.WithField("udid", device.Properties.SerialNumber).Info("the shard has no tests, not starting the test runner")
+		return make([]TestSuite, 0), nil
+	}
+	run := func() ([]TestSuite, error) {
+		return runXCUITestWithRetries(ctx, selection.Retries, bundleID, testRunnerBundleID, xctestConfigName, device, args, env, testsToRun, testsToSkip, testListener, isXCTest)
+	}
+	if selection.EnergyProfiling {
+		return runWithEnergyProfiling(device, []string{bundleID, testRunnerBundleID}, testListener, run)
+	}
+	return run()
+}
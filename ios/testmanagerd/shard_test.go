@@ -0,0 +1,65 @@
+package testmanagerd
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardTests(t *testing.T) {
+	tests := []string{"C/testC", "A/testA", "B/testB", "A/testA", "D"}
+	var all []string
+	for index := 0; index < 3; index++ {
+		shard, err := Shard{Index: index, Total: 3}.Tests(tests)
+		require.NoError(t, err)
+		all = append(all, shard...)
+	}
+	assert.ElementsMatch(t, []string{"A/testA", "B/testB", "C/testC", "D"}, all, "every test runs in exactly one shard")
+
+	shard, _ := Shard{Index: 0, Total: 3}.Tests([]string{"D", "C/testC", "B/testB", "A/testA"})
+	assert.Equal(t, []string{"A/testA", "D"}, shard, "the order of the tests does not change the shards")
+
+	shard, err := Shard{Index: 4, Total: 5}.Tests([]string{"A"})
+	require.NoError(t, err)
+	assert.Empty(t, shard)
+	_, err = Shard{Index: 2, Total: 2}.Tests(tests)
+	assert.Error(t, err)
+}
+
+func TestTestSelection(t *testing.T) {
+	run, skip, err := NewTestSelection(WithTestsToRun([]string{"B", "A"}), WithTestsToSkip([]string{"A/testSlow"}), WithShard(1, 2)).Resolve()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"B"}, run)
+	assert.Equal(t, []string{"A/testSlow"}, skip)
+
+	run, _, err = NewTestSelection().Resolve()
+	require.NoError(t, err)
+	assert.Nil(t, run, "all tests run without a selection")
+
+	_, _, err = NewTestSelection(WithShard(0, 2)).Resolve()
+	assert.Error(t, err, "sharding needs the tests to run")
+}
+
+func TestRunXCUITestParallelShard(t *testing.T) {
+	defer func(old func(context.Context, string, string, string, ios.DeviceEntry, []string, []string, []string, []string, *TestListener, bool) ([]TestSuite, error)) {
+		runXCUITest = old
+	}(runXCUITest)
+	var mu sync.Mutex
+	ran := map[string][]string{}
+	runXCUITest = func(ctx context.Context, bundleID, testRunnerBundleID, xctestConfigName string, device ios.DeviceEntry, args, env, testsToRun, testsToSkip []string, listener *TestListener, isXCTest bool) ([]TestSuite, error) {
+		mu.Lock()
+		ran[device.Properties.SerialNumber] = testsToRun
+		mu.Unlock()
+		return suiteWith(StatusPassed), nil
+	}
+
+	result := RunXCUITestParallel(context.Background(), parallelDevices("a", "b", "c"), ParallelConfig{TestsToRun: []string{"A", "B"}, Shard: true})
+
+	assert.False(t, result.Failed())
+	assert.Equal(t, map[string][]string{"a": {"A"}, "b": {"B"}}, ran, "the device without tests does not start the runner")
+	assert.Equal(t, 2, result.Summary.Total)
+}
@@ -0,0 +1,59 @@
+package testmanagerd
+
+import (
+	"strings"
+)
+
+// Template variables that can be used in the args and env passed to a test run.
+// They are resolved right before the run starts, which allows the same test job
+// definition to be used for every device of a batch run.
+const (
+	TemplateUDID        = "{{udid}}"
+	TemplateWdaPort     = "{{wda_port}}"
+	TemplateArtifactDir = "{{artifact_dir}}"
+	TemplateJobID       = "{{job_id}}"
+)
+
+// DefaultWdaPort is the port WebDriverAgent listens on if USE_PORT is not set
+const DefaultWdaPort = "8100"
+
+// WdaPortFromEnv returns the port of a USE_PORT env var, or DefaultWdaPort if env has none
+func WdaPortFromEnv(env []string) string {
+	for _, e := range env {
+		if port, found := strings.CutPrefix(e, "USE_PORT="); found && !strings.Contains(port, "{{") {
+			return port
+		}
+	}
+	return DefaultWdaPort
+}
+
+// TemplateVars contains the per run values for the supported template variables
+type TemplateVars struct {
+	UDID        string
+	WdaPort     string
+	ArtifactDir string
+	JobID       string
+}
+
+// Resolve returns a copy of values where all known template variables have been
+// replaced with the values of v. Unknown variables are left untouched.
+func (v TemplateVars) Resolve(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	wdaPort := v.WdaPort
+	if wdaPort == "" {
+		wdaPort = DefaultWdaPort
+	}
+	replacer := strings.NewReplacer(
+		TemplateUDID, v.UDID,
+		TemplateWdaPort, wdaPort,
+		TemplateArtifactDir, v.ArtifactDir,
+		TemplateJobID, v.JobID,
+	)
+	resolved := make([]string, len(values))
+	for i, value := range values {
+		resolved[i] = replacer.Replace(value)
+	}
+	return resolved
+}
@@ -0,0 +1,42 @@
+package testmanagerd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateVarsResolve(t *testing.T) {
+	vars := TemplateVars{UDID: "abcdef", WdaPort: "8200", ArtifactDir: "/tmp/artifacts", JobID: "job-1"}
+
+	t.Run("all variables are replaced", func(t *testing.T) {
+		resolved := vars.Resolve([]string{"USE_PORT={{wda_port}}", "DIR={{artifact_dir}}/{{job_id}}/{{udid}}"})
+		assert.Equal(t, []string{"USE_PORT=8200", "DIR=/tmp/artifacts/job-1/abcdef"}, resolved)
+	})
+
+	t.Run("unknown variables are kept", func(t *testing.T) {
+		resolved := vars.Resolve([]string{"{{unknown}}", "plain"})
+		assert.Equal(t, []string{"{{unknown}}", "plain"}, resolved)
+	})
+
+	t.Run("wda port falls back to default", func(t *testing.T) {
+		resolved := TemplateVars{}.Resolve([]string{"{{wda_port}}"})
+		assert.Equal(t, []string{DefaultWdaPort}, resolved)
+	})
+
+	t.Run("input is not modified", func(t *testing.T) {
+		input := []string{"{{udid}}"}
+		vars.Resolve(input)
+		assert.Equal(t, []string{"{{udid}}"}, input)
+	})
+
+	t.Run("nil stays nil", func(t *testing.T) {
+		assert.Nil(t, vars.Resolve(nil))
+	})
+}
+
+func TestWdaPortFromEnv(t *testing.T) {
+	assert.Equal(t, "8200", WdaPortFromEnv([]string{"LANG=de", "USE_PORT=8200"}))
+	assert.Equal(t, DefaultWdaPort, WdaPortFromEnv([]string{"USE_PORT={{wda_port}}"}))
+	assert.Equal(t, DefaultWdaPort, WdaPortFromEnv(nil))
+}
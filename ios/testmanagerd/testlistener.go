@@ -25,6 +25,27 @@ type TestListener struct {
 	attachmentsDirectory string
 	TestSuites           []TestSuite
 	runningTestSuite     *TestSuite
+	// Events receives the results while the tests are running. Set the callbacks before starting the test run.
+	Events TestEvents
+	// Energy is the energy profile of a run with WithEnergyProfiling, it is set once the run is over
+	Energy    *EnergyReport
+	reporters []TestReporter
+}
+
+// TestEvents are typed callbacks for reporters, f.ex. to write JUnit reports, that need results while the tests
+// are running instead of scraping the logs. Nil callbacks are skipped. The callbacks are invoked from the goroutine
+// that decodes the testmanagerd messages and must not block.
+type TestEvents struct {
+	// TestRunnerStarted is called with the process id of the test runner once it was launched
+	TestRunnerStarted func(pid uint64)
+	// TestSuiteStarted is called with the suite name and start date
+	TestSuiteStarted func(suite TestSuite)
+	// TestCaseStarted is called with a test case that only has ClassName and MethodName set
+	TestCaseStarted func(testCase TestCase)
+	// TestCaseFinished is called with the status, duration, failure and attachments of the test case
+	TestCaseFinished func(testCase TestCase)
+	// TestSuiteFinished is called with the suite and all its test cases
+	TestSuiteFinished func(suite TestSuite)
 }
 
 type TestSuite struct {
@@ -43,6 +64,8 @@ type TestCase struct {
 	Err         TestError
 	Duration    time.Duration
 	Attachments []TestAttachment
+	// Attempts are the results of earlier runs if the test case was retried after it failed
+	Attempts []TestAttempt
 }
 
 type TestCaseStatus string
@@ -51,6 +74,7 @@ const (
 	StatusFailed          = TestCaseStatus("failed")           // Defined by Apple
 	StatusPassed          = TestCaseStatus("passed")           // Defined by Apple
 	StatusExpectedFailure = TestCaseStatus("expected failure") // Defined by Apple
+	StatusSkipped         = TestCaseStatus("skipped")          // Defined by Apple
 	StatusStalled         = TestCaseStatus("stalled")          // Defined by us
 
 	// Test suite counter constants
@@ -82,6 +106,12 @@ func NewTestListener(logWriter io.Writer, debugLogWriter io.Writer, attachmentsD
 	}
 }
 
+func (t *TestListener) testRunnerStarted(pid uint64) {
+	if t.Events.TestRunnerStarted != nil {
+		t.Events.TestRunnerStarted(pid)
+	}
+}
+
 func (t *TestListener) didFinishExecutingTestPlan() {
 	t.executionFinished()
 }
@@ -116,7 +146,7 @@ func (t *TestListener) testCaseFinished(testClass string, testMethod string, xcA
 		// That's unfortunately the default behavior defined by Apple.
 		// This if block is a safe guard to auto correct the test case information
 		ts = t.runningTestSuite
-		if len(ts.TestCases) == 0 {
+		if ts == nil || len(ts.TestCases) == 0 {
 			log.Debug(fmt.Sprintf("Received testCaseFinished for %s:%s without initialization", testClass, testMethod))
 			return
 		}
@@ -161,14 +191,25 @@ func (t *TestListener) testSuiteDidStart(suiteName string, date string) {
 		StartDate: d,
 		TestCases: make([]TestCase, 0),
 	}
+	if t.Events.TestSuiteStarted != nil {
+		t.Events.TestSuiteStarted(*t.runningTestSuite)
+	}
 }
 
 func (t *TestListener) testCaseDidStartForClass(testClass string, testMethod string) {
-	ts := t.findTestSuite(testClass)
-	ts.TestCases = append(ts.TestCases, TestCase{
+	testCase := TestCase{
 		ClassName:  testClass,
 		MethodName: testMethod,
-	})
+	}
+	ts := t.findTestSuite(testClass)
+	if ts == nil {
+		log.Debug(fmt.Sprintf("Received testCaseDidStart for %s:%s outside of its test suite", testClass, testMethod))
+	} else {
+		ts.TestCases = append(ts.TestCases, testCase)
+	}
+	if t.Events.TestCaseStarted != nil {
+		t.Events.TestCaseStarted(testCase)
+	}
 }
 
 func (t *TestListener) testCaseFailedForClass(testClass string, testMethod string, message string, file string, line uint64) {
@@ -206,6 +247,9 @@ func (t *TestListener) testCaseDidFinishForTest(testClass string, testMethod str
 		}
 
 		testCase.Duration = d
+		if t.Events.TestCaseFinished != nil {
+			t.Events.TestCaseFinished(*testCase)
+		}
 	}
 }
 
@@ -240,6 +284,9 @@ func (t *TestListener) testSuiteFinished(suiteName string, date string, testCoun
 
 	t.TestSuites = append(t.TestSuites, *t.runningTestSuite)
 	t.runningTestSuite = nil
+	if t.Events.TestSuiteFinished != nil {
+		t.Events.TestSuiteFinished(*ts)
+	}
 }
 
 func (t *TestListener) LogMessage(msg string) {
@@ -264,6 +311,27 @@ func (t *TestListener) Done() <-chan struct{} {
 	return t.finished
 }
 
+// AddReporter adds a reporter that writes the results once the test run is over
+func (t *TestListener) AddReporter(reporter TestReporter) {
+	t.reporters = append(t.reporters, reporter)
+}
+
+// results calls the reporters and returns the results of the test run. Failing reporters don't fail the test run.
+func (t *TestListener) results() ([]TestSuite, error) {
+	for _, reporter := range t.reporters {
+		var err error
+		if energyReporter, ok := reporter.(EnergyReporter); ok && t.Energy != nil {
+			err = energyReporter.ReportEnergy(t.TestSuites, t.err, *t.Energy)
+		} else {
+			err = reporter.Report(t.TestSuites, t.err)
+		}
+		if err != nil {
+			log.WithError(err).Error("failed writing test report")
+		}
+	}
+	return t.TestSuites, t.err
+}
+
 func (t *TestListener) findTestCase(className string, methodName string) *TestCase {
 	ts := t.findTestSuite(className)
 
@@ -226,6 +226,35 @@ func TestFinishExecutingTestPlan(t *testing.T) {
 
 		assert.Equal(t, "test", string(attachment), "Attachment content should be put in a file")
 	})
+
+	t.Run("Check test events", func(t *testing.T) {
+		testListener := NewTestListener(io.Discard, io.Discard, os.TempDir())
+		var started []TestCase
+		var finished []TestCase
+		var suites []TestSuite
+		testListener.Events = TestEvents{
+			TestCaseStarted:   func(testCase TestCase) { started = append(started, testCase) },
+			TestCaseFinished:  func(testCase TestCase) { finished = append(finished, testCase) },
+			TestSuiteFinished: func(suite TestSuite) { suites = append(suites, suite) },
+		}
+
+		testListener.testSuiteDidStart("mysuite", "2024-01-16 15:36:43 +0000")
+		testListener.testCaseDidStartForClass("mysuite", "mymethod1")
+		testListener.testCaseDidFinishForTest("mysuite", "mymethod1", "passed", 1.0)
+		testListener.testCaseDidStartForClass("mysuite", "mymethod2")
+		testListener.testCaseFailedForClass("mysuite", "mymethod2", "error", "file://app.swift", 123)
+		testListener.testCaseDidFinishForTest("mysuite", "mymethod2", "failed", 2.0)
+		testListener.testSuiteFinished("mysuite", "2024-01-16 15:36:46 +0000", 2, 1, 0, 0, 0, 0, 3.0, 3.0)
+
+		assert.Equal(t, []TestCase{{ClassName: "mysuite", MethodName: "mymethod1"}, {ClassName: "mysuite", MethodName: "mymethod2"}}, started)
+		assert.Equal(t, 2, len(finished))
+		assert.Equal(t, StatusPassed, finished[0].Status)
+		assert.Equal(t, 1.0, finished[0].Duration.Seconds())
+		assert.Equal(t, StatusFailed, finished[1].Status)
+		assert.Equal(t, TestError{Message: "error", File: "file://app.swift", Line: 123}, finished[1].Err)
+		assert.Equal(t, 1, len(suites))
+		assert.Equal(t, 2, len(suites[0].TestCases))
+	})
 }
 
 type assertionWriter struct {
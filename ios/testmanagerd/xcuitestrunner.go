@@ -220,11 +220,20 @@ const (
 const testBundleSuffix = "UITests.xctrunner"
 
 func RunXCUITest(bundleID string, testRunnerBundleID string, xctestConfigName string, device ios.DeviceEntry, env []string, testsToRun []string, testsToSkip []string, testListener *TestListener, isXCTest bool) ([]TestSuite, error) {
+	return RunXCUITestCtx(context.TODO(), bundleID, testRunnerBundleID, xctestConfigName, device, nil, env, testsToRun, testsToSkip, testListener, isXCTest)
+}
+
+// RunXCUITestCtx runs the tests like RunXCUIWithBundleIdsCtx until ctx is done. If only bundleID is given, the test runner
+// bundle id and the xctest config are derived from it like Xcode names them.
+func RunXCUITestCtx(ctx context.Context, bundleID string, testRunnerBundleID string, xctestConfigName string, device ios.DeviceEntry, args []string, env []string, testsToRun []string, testsToSkip []string, testListener *TestListener, isXCTest bool) ([]TestSuite, error) {
 	// FIXME: this is redundant code, getting the app list twice and creating the appinfos twice
 	// just to generate the xctestConfigFileName. Should be cleaned up at some point.
+	if err := ctx.Err(); err != nil {
+		return make([]TestSuite, 0), fmt.Errorf("RunXCUITestCtx: %w", err)
+	}
 	installationProxy, err := installationproxy.New(device)
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("RunXCUITest: cannot connect to installation proxy: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("RunXCUITestCtx: cannot connect to installation proxy: %w", err)
 	}
 	defer installationProxy.Close()
 
@@ -234,21 +243,24 @@ func RunXCUITest(bundleID string, testRunnerBundleID string, xctestConfigName st
 
 	apps, err := installationProxy.BrowseUserApps()
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("RunXCUITest: cannot browse user apps: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("RunXCUITestCtx: cannot browse user apps: %w", err)
 	}
 
 	if bundleID != "" && xctestConfigName == "" {
 		info, err := getappInfo(bundleID, apps)
 		if err != nil {
-			return make([]TestSuite, 0), fmt.Errorf("RunXCUITest: cannot get app information: %w", err)
+			return make([]TestSuite, 0), fmt.Errorf("RunXCUITestCtx: cannot get app information: %w", err)
 		}
 
 		xctestConfigName = info.bundleName + "UITests.xctest"
 	}
 
-	return RunXCUIWithBundleIdsCtx(context.TODO(), bundleID, testRunnerBundleID, xctestConfigName, device, nil, env, testsToRun, testsToSkip, testListener, isXCTest)
+	return RunXCUIWithBundleIdsCtx(ctx, bundleID, testRunnerBundleID, xctestConfigName, device, args, env, testsToRun, testsToSkip, testListener, isXCTest)
 }
 
+// RunXCUIWithBundleIdsCtx starts the test runner and runs the tests until they finished or ctx is done.
+// If ctx is done while the tests are set up, the connections to testmanagerd are closed and the error of ctx is returned.
+// If it is done while the tests run, the results so far are returned. In both cases the test runner gets killed.
 func RunXCUIWithBundleIdsCtx(
 	ctx context.Context,
 	bundleID string,
@@ -299,12 +311,14 @@ func runXUITestWithBundleIdsXcode15Ctx(
 		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: cannot create a tunnel connection to testmanagerd: %w", err)
 	}
 	defer conn1.Close()
+	defer closeOnDone(ctx, conn1)()
 
 	conn2, err := dtx.NewTunnelConnection(device, testmanagerdiOS17)
 	if err != nil {
 		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: cannot create a tunnel connection to testmanagerd: %w", err)
 	}
 	defer conn2.Close()
+	defer closeOnDone(ctx, conn2)()
 
 	installationProxy, err := installationproxy.New(device)
 	if err != nil {
@@ -352,7 +366,7 @@ func runXUITestWithBundleIdsXcode15Ctx(
 	}}
 	receivedCaps, err := ideDaemonProxy1.daemonConnection.initiateSessionWithIdentifierAndCaps(testSessionID, localCaps)
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: cannot initiate a IDE session: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: cannot initiate a IDE session: %w", stopped(ctx, err))
 	}
 	log.WithField("receivedCaps", receivedCaps).Info("got capabilities")
 
@@ -362,12 +376,17 @@ func runXUITestWithBundleIdsXcode15Ctx(
 	}
 	defer appserviceConn.Close()
 
+	if err := ctx.Err(); err != nil {
+		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: not starting test runner: %w", err)
+	}
 	testRunnerLaunch, err := startTestRunner17(device, appserviceConn, "", testRunnerBundleID, strings.ToUpper(testSessionID.String()), info.testApp.path+"/PlugIns/"+xctestConfigFileName, args, env, isXCTest)
 	if err != nil {
 		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: cannot start test runner: %w", err)
 	}
 
 	defer testRunnerLaunch.Close()
+	defer killTestRunner(appserviceConn, testRunnerLaunch.Pid)
+	testListener.testRunnerStarted(uint64(testRunnerLaunch.Pid))
 	go func() {
 		_, err := io.Copy(testListener.logWriter, testRunnerLaunch)
 		if err != nil {
@@ -378,12 +397,12 @@ func runXUITestWithBundleIdsXcode15Ctx(
 	ideDaemonProxy2 := newDtxProxyWithConfig(conn2, testconfig, testListener)
 	caps, err := ideDaemonProxy2.daemonConnection.initiateControlSessionWithCapabilities(nskeyedarchiver.XCTCapabilities{CapabilitiesDictionary: map[string]interface{}{}})
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: cannot initiate a control session with capabilities: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: cannot initiate a control session with capabilities: %w", stopped(ctx, err))
 	}
 	log.WithField("caps", caps).Info("got capabilities")
 	authorized, err := ideDaemonProxy2.daemonConnection.authorizeTestSessionWithProcessID(uint64(testRunnerLaunch.Pid))
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: cannot authorize test session: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: cannot authorize test session: %w", stopped(ctx, err))
 	}
 	log.WithField("authorized", authorized).Info("authorized")
 
@@ -392,12 +411,15 @@ func runXUITestWithBundleIdsXcode15Ctx(
 	proto := uint64(36)
 	err = ideDaemonProxy1.daemonConnection.startExecutingTestPlanWithProtocolVersion(ideInterfaceChannel, proto)
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: cannot start executing test plan: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode15Ctx: cannot start executing test plan: %w", stopped(ctx, err))
 	}
 
 	select {
 	case <-conn1.Closed():
 		log.Debug("conn1 closed")
+		if ctx.Err() != nil {
+			break
+		}
 		if !errors.Is(conn1.Err(), dtx.ErrConnectionClosed) {
 			log.WithError(conn1.Err()).Error("conn1 closed unexpectedly")
 		}
@@ -405,6 +427,9 @@ func runXUITestWithBundleIdsXcode15Ctx(
 		break
 	case <-conn2.Closed():
 		log.Debug("conn2 closed")
+		if ctx.Err() != nil {
+			break
+		}
 		if !errors.Is(conn2.Err(), dtx.ErrConnectionClosed) {
 			log.WithError(conn2.Err()).Error("conn2 closed unexpectedly")
 		}
@@ -413,19 +438,13 @@ func runXUITestWithBundleIdsXcode15Ctx(
 	case <-testListener.Done():
 		break
 	case <-ctx.Done():
+		log.Info("Test run stopped")
 		break
 	}
-	log.Infof("Killing test runner with pid %d ...", testRunnerLaunch.Pid)
-	err = killTestRunner(appserviceConn, testRunnerLaunch.Pid)
-	if err != nil {
-		log.Infof("Nothing to kill, process with pid %d is already dead", testRunnerLaunch.Pid)
-	} else {
-		log.Info("Test runner killed with success")
-	}
 
 	log.Debugf("Done running test")
 
-	return testListener.TestSuites, testListener.err
+	return testListener.results()
 }
 
 type processKiller interface {
@@ -436,6 +455,7 @@ func killTestRunner(killer processKiller, pid int) error {
 	log.Infof("Killing test runner with pid %d ...", pid)
 	err := killer.KillProcess(pid)
 	if err != nil {
+		log.Infof("Nothing to kill, process with pid %d is already dead", pid)
 		return err
 	}
 	log.Info("Test runner killed with success")
@@ -443,6 +463,22 @@ func killTestRunner(killer processKiller, pid int) error {
 	return nil
 }
 
+// closeOnDone closes conn when ctx is done, so that calls waiting for testmanagerd return right away.
+// The returned func stops watching ctx.
+func closeOnDone(ctx context.Context, conn *dtx.Connection) func() bool {
+	return context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+}
+
+// stopped adds the error of ctx to err if ctx is done, because the call failed after its connection was closed then
+func stopped(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%w: %w", ctx.Err(), err)
+	}
+	return err
+}
+
 func startTestRunner17(device ios.DeviceEntry, appserviceConn *appservice.Connection, xctestConfigPath string, bundleID string, sessionIdentifier string, testBundlePath string, testArgs []string, testEnv []string, isXCTest bool) (appservice.LaunchedAppWithStdIo, error) {
 	args := []interface{}{}
 	for _, arg := range testArgs {
@@ -472,11 +508,10 @@ func startTestRunner17(device ios.DeviceEntry, appserviceConn *appservice.Connec
 	}
 
 	for _, entrystring := range testEnv {
-		entry := strings.Split(entrystring, "=")
-		key := entry[0]
-		value := entry[1]
+		key, value, _ := strings.Cut(entrystring, "=")
 		env[key] = value
-		log.Debugf("adding extra env %s=%s", key, value)
+		// values are not logged, they can be secrets
+		log.Debugf("adding extra env %s", key)
 	}
 
 	opts := map[string]interface{}{
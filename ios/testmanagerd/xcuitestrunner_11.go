@@ -35,6 +35,7 @@ func runXCUIWithBundleIdsXcode11Ctx(
 		return make([]TestSuite, 0), fmt.Errorf("RunXCUIWithBundleIdsXcode11Ctx: cannot create a usbmuxd connection to testmanagerd: %w", err)
 	}
 	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
 
 	ideDaemonProxy := newDtxProxyWithConfig(conn, testConfig, testListener)
 
@@ -43,6 +44,7 @@ func runXCUIWithBundleIdsXcode11Ctx(
 		return make([]TestSuite, 0), fmt.Errorf("RunXCUIWithBundleIdsXcode11Ctx: cannot create a usbmuxd connection to testmanagerd: %w", err)
 	}
 	defer conn2.Close()
+	defer closeOnDone(ctx, conn2)()
 	log.Debug("connections ready")
 	ideDaemonProxy2 := newDtxProxyWithConfig(conn2, testConfig, testListener)
 	ideDaemonProxy2.ideInterface.testConfig = testConfig
@@ -50,7 +52,7 @@ func runXCUIWithBundleIdsXcode11Ctx(
 	protocolVersion := uint64(25)
 	_, err = ideDaemonProxy.daemonConnection.initiateSessionWithIdentifier(testSessionId, protocolVersion)
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("RunXCUIWithBundleIdsXcode11Ctx: cannot initiate a test session: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("RunXCUIWithBundleIdsXcode11Ctx: cannot initiate a test session: %w", stopped(ctx, err))
 	}
 
 	pControl, err := instruments.NewProcessControl(device)
@@ -59,15 +61,28 @@ func runXCUIWithBundleIdsXcode11Ctx(
 	}
 	defer pControl.Close()
 
+	if err := ctx.Err(); err != nil {
+		return make([]TestSuite, 0), fmt.Errorf("RunXCUIWithBundleIdsXcode11Ctx: not starting test runner: %w", err)
+	}
 	pid, err := startTestRunner11(pControl, xctestConfigPath, testRunnerBundleID, testSessionId.String(), testInfo.testApp.path+"/PlugIns/"+xctestConfigFileName, args, env)
 	if err != nil {
 		return make([]TestSuite, 0), fmt.Errorf("RunXCUIWithBundleIdsXcode11Ctx: cannot start the test runner: %w", err)
 	}
+	defer func() {
+		log.Infof("Killing test runner with pid %d ...", pid)
+		err := pControl.KillProcess(pid)
+		if err != nil {
+			log.Infof("Nothing to kill, process with pid %d is already dead", pid)
+		} else {
+			log.Info("Test runner killed with success")
+		}
+	}()
 	log.Debugf("Runner started with pid:%d, waiting for testBundleReady", pid)
+	testListener.testRunnerStarted(pid)
 
 	err = ideDaemonProxy2.daemonConnection.initiateControlSession(pid, protocolVersion)
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("RunXCUIWithBundleIdsXcode11Ctx: cannot initiate a control session with capabilities: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("RunXCUIWithBundleIdsXcode11Ctx: cannot initiate a control session with capabilities: %w", stopped(ctx, err))
 	}
 	log.Debugf("control session initiated")
 	ideInterfaceChannel := ideDaemonProxy.dtxConnection.ForChannelRequest(proxyDispatcher{id: "emty"})
@@ -75,38 +90,32 @@ func runXCUIWithBundleIdsXcode11Ctx(
 	log.Debug("start executing testplan")
 	err = ideDaemonProxy2.daemonConnection.startExecutingTestPlanWithProtocolVersion(ideInterfaceChannel, 25)
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("RunXCUIWithBundleIdsXcode11Ctx: cannot start executing test plan: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("RunXCUIWithBundleIdsXcode11Ctx: cannot start executing test plan: %w", stopped(ctx, err))
 	}
 
 	select {
 	case <-conn.Closed():
 		log.Debug("conn closed")
-		if conn.Err() != dtx.ErrConnectionClosed {
+		if ctx.Err() == nil && conn.Err() != dtx.ErrConnectionClosed {
 			log.WithError(conn.Err()).Error("conn closed unexpectedly")
 		}
 		break
 	case <-conn2.Closed():
 		log.Debug("conn2 closed")
-		if conn2.Err() != dtx.ErrConnectionClosed {
+		if ctx.Err() == nil && conn2.Err() != dtx.ErrConnectionClosed {
 			log.WithError(conn2.Err()).Error("conn2 closed unexpectedly")
 		}
 		break
 	case <-testListener.Done():
 		break
 	case <-ctx.Done():
+		log.Info("Test run stopped")
 		break
 	}
-	log.Infof("Killing test runner with pid %d ...", pid)
-	err = pControl.KillProcess(pid)
-	if err != nil {
-		log.Infof("Nothing to kill, process with pid %d is already dead", pid)
-	} else {
-		log.Info("Test runner killed with success")
-	}
 
 	log.Debugf("Done running test")
 
-	return testListener.TestSuites, testListener.err
+	return testListener.results()
 }
 
 func startTestRunner11(pControl *instruments.ProcessControl, xctestConfigPath string, bundleID string,
@@ -123,11 +132,10 @@ func startTestRunner11(pControl *instruments.ProcessControl, xctestConfigPath st
 	}
 
 	for _, entrystring := range wdaenv {
-		entry := strings.Split(entrystring, "=")
-		key := entry[0]
-		value := entry[1]
+		key, value, _ := strings.Cut(entrystring, "=")
 		env[key] = value
-		log.Debugf("adding extra env %s=%s", key, value)
+		// values are not logged, they can be secrets
+		log.Debugf("adding extra env %s", key)
 	}
 
 	opts := map[string]interface{}{
@@ -20,12 +20,13 @@ func runXUITestWithBundleIdsXcode12Ctx(ctx context.Context, bundleID string, tes
 	if err != nil {
 		return make([]TestSuite, 0), fmt.Errorf("RunXUITestWithBundleIdsXcode12Ctx: cannot create a usbmuxd connection to testmanagerd: %w", err)
 	}
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
 
 	testSessionId, xctestConfigPath, testConfig, testInfo, err := setupXcuiTest(device, bundleID, testRunnerBundleID, xctestConfigFileName, testsToRun, testsToSkip, isXCTest)
 	if err != nil {
 		return make([]TestSuite, 0), fmt.Errorf("RunXUITestWithBundleIdsXcode12Ctx: cannot setup test config: %w", err)
 	}
-	defer conn.Close()
 
 	ideDaemonProxy := newDtxProxyWithConfig(conn, testConfig, testListener)
 
@@ -34,12 +35,13 @@ func runXUITestWithBundleIdsXcode12Ctx(ctx context.Context, bundleID string, tes
 		return make([]TestSuite, 0), fmt.Errorf("RunXUITestWithBundleIdsXcode12Ctx: cannot create a usbmuxd connection to testmanagerd: %w", err)
 	}
 	defer conn2.Close()
+	defer closeOnDone(ctx, conn2)()
 	log.Debug("connections ready")
 	ideDaemonProxy2 := newDtxProxyWithConfig(conn2, testConfig, testListener)
 	ideDaemonProxy2.ideInterface.testConfig = testConfig
 	caps, err := ideDaemonProxy.daemonConnection.initiateControlSessionWithCapabilities(nskeyedarchiver.XCTCapabilities{})
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("RunXUITestWithBundleIdsXcode12Ctx: cannot initiate a control session with capabilities: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("RunXUITestWithBundleIdsXcode12Ctx: cannot initiate a control session with capabilities: %w", stopped(ctx, err))
 	}
 	log.Debug(caps)
 	localCaps := nskeyedarchiver.XCTCapabilities{CapabilitiesDictionary: map[string]interface{}{
@@ -50,7 +52,7 @@ func runXUITestWithBundleIdsXcode12Ctx(ctx context.Context, bundleID string, tes
 
 	caps2, err := ideDaemonProxy2.daemonConnection.initiateSessionWithIdentifierAndCaps(testSessionId, localCaps)
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("RunXUITestWithBundleIdsXcode12Ctx: cannot initiate a session with identifier and capabilities: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("RunXUITestWithBundleIdsXcode12Ctx: cannot initiate a session with identifier and capabilities: %w", stopped(ctx, err))
 	}
 	log.Debug(caps2)
 	pControl, err := instruments.NewProcessControl(device)
@@ -59,52 +61,63 @@ func runXUITestWithBundleIdsXcode12Ctx(ctx context.Context, bundleID string, tes
 	}
 	defer pControl.Close()
 
+	if err := ctx.Err(); err != nil {
+		return make([]TestSuite, 0), fmt.Errorf("RunXUITestWithBundleIdsXcode12Ctx: not starting test runner: %w", err)
+	}
 	pid, err := startTestRunner12(pControl, xctestConfigPath, testRunnerBundleID, testSessionId.String(), testInfo.testApp.path+"/PlugIns/"+xctestConfigFileName, args, env)
 	if err != nil {
 		return make([]TestSuite, 0), fmt.Errorf("RunXUITestWithBundleIdsXcode12Ctx: cannot start test runner: %w", err)
 	}
+	defer func() {
+		log.Infof("Killing test runner with pid %d ...", pid)
+		err := pControl.KillProcess(pid)
+		if err != nil {
+			log.Infof("Nothing to kill, process with pid %d is already dead", pid)
+		} else {
+			log.Info("Test runner killed with success")
+		}
+	}()
 	log.Debugf("Runner started with pid:%d, waiting for testBundleReady", pid)
+	testListener.testRunnerStarted(pid)
 
 	ideInterfaceChannel := ideDaemonProxy2.dtxConnection.ForChannelRequest(proxyDispatcher{id: "emty"})
 
-	time.Sleep(time.Second)
+	select {
+	case <-time.After(time.Second):
+	case <-ctx.Done():
+		return make([]TestSuite, 0), fmt.Errorf("RunXUITestWithBundleIdsXcode12Ctx: not authorizing test session: %w", ctx.Err())
+	}
 
 	success, _ := ideDaemonProxy.daemonConnection.authorizeTestSessionWithProcessID(pid)
 	log.Debugf("authorizing test session for pid %d successful %t", pid, success)
 	err = ideDaemonProxy2.daemonConnection.startExecutingTestPlanWithProtocolVersion(ideInterfaceChannel, 36)
 	if err != nil {
-		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode12Ctx: cannot start executing test plan: %w", err)
+		return make([]TestSuite, 0), fmt.Errorf("runXUITestWithBundleIdsXcode12Ctx: cannot start executing test plan: %w", stopped(ctx, err))
 	}
 
 	select {
 	case <-conn.Closed():
 		log.Debug("conn closed")
-		if conn.Err() != dtx.ErrConnectionClosed {
+		if ctx.Err() == nil && conn.Err() != dtx.ErrConnectionClosed {
 			log.WithError(conn.Err()).Error("conn closed unexpectedly")
 		}
 		break
 	case <-conn2.Closed():
 		log.Debug("conn2 closed")
-		if conn2.Err() != dtx.ErrConnectionClosed {
+		if ctx.Err() == nil && conn2.Err() != dtx.ErrConnectionClosed {
 			log.WithError(conn2.Err()).Error("conn2 closed unexpectedly")
 		}
 		break
 	case <-testListener.Done():
 		break
 	case <-ctx.Done():
+		log.Info("Test run stopped")
 		break
 	}
-	log.Infof("Killing test runner with pid %d ...", pid)
-	err = pControl.KillProcess(pid)
-	if err != nil {
-		log.Infof("Nothing to kill, process with pid %d is already dead", pid)
-	} else {
-		log.Info("Test runner killed with success")
-	}
 
 	log.Debugf("Done running test")
 
-	return testListener.TestSuites, testListener.err
+	return testListener.results()
 }
 
 func startTestRunner12(pControl *instruments.ProcessControl, xctestConfigPath string, bundleID string,
@@ -131,11 +144,10 @@ func startTestRunner12(pControl *instruments.ProcessControl, xctestConfigPath st
 	}
 
 	for _, entrystring := range wdaenv {
-		entry := strings.Split(entrystring, "=")
-		key := entry[0]
-		value := entry[1]
+		key, value, _ := strings.Cut(entrystring, "=")
 		env[key] = value
-		log.Debugf("adding extra env %s=%s", key, value)
+		// values are not logged, they can be secrets
+		log.Debugf("adding extra env %s", key)
 	}
 
 	opts := map[string]interface{}{
@@ -1,6 +1,7 @@
 package tunnel
 
 import (
+	"bytes"
 	"crypto/rand"
 	"errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"path"
 	"strings"
 
+	"github.com/danielpaulus/go-ios/ios/secrets"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/ed25519"
 	"howett.net/plist"
@@ -42,42 +44,51 @@ type device struct {
 type PairRecordManager struct {
 	selfId        selfIdentity
 	peersLocation string
+	keys          secrets.KeyProvider
 }
 
 // NewPairRecordManager creates a PairRecordManager that reads/stores the pair records information at the given path
 // To use the same pair records as macOS does, this path should be /var/db/lockdown/RemotePairing/user_501
 // (user_501 is the default for the root user)
 func NewPairRecordManager(p string) (PairRecordManager, error) {
+	return NewPairRecordManagerWithKeys(p, nil)
+}
+
+// NewPairRecordManagerWithKeys creates a PairRecordManager that seals the self identity and the device infos with
+// keys, so the private key of the host is not stored in plaintext. Plaintext pair records are still read, which
+// allows switching existing hosts over. macOS can't read sealed pair records, so keys should be nil if the pair
+// records are shared with macOS.
+func NewPairRecordManagerWithKeys(p string, keys secrets.KeyProvider) (PairRecordManager, error) {
 	selfIdPath := path.Join(p, "selfIdentity.plist")
-	selfId, err := getOrCreateSelfIdentity(selfIdPath)
+	selfId, err := getOrCreateSelfIdentity(selfIdPath, keys)
 	if err != nil {
 		return PairRecordManager{}, fmt.Errorf("NewPairRecordManager: failed to get self identity: %w", err)
 	}
 	return PairRecordManager{
 		selfId:        selfId,
 		peersLocation: path.Join(p, "peers"),
+		keys:          keys,
 	}, nil
 }
 
 // StoreDeviceInfo stores the provided Device info as a plist encoded file in the `peers/` directory
 func (p PairRecordManager) StoreDeviceInfo(d device) error {
 	devicePath := path.Join(p.peersLocation, fmt.Sprintf("%s.plist", d.Identifier))
-	f, err := os.OpenFile(devicePath, os.O_CREATE|os.O_WRONLY, 0o644)
+	var buf bytes.Buffer
+	enc := plist.NewEncoderForFormat(&buf, plist.BinaryFormat)
+	err := enc.Encode(d)
 	if err != nil {
-		return fmt.Errorf("StoreDeviceInfo: could open file for writing: %w", err)
+		return fmt.Errorf("StoreDeviceInfo: could not encode device info: %w", err)
 	}
-	defer f.Close()
-
-	enc := plist.NewEncoderForFormat(f, plist.BinaryFormat)
-	err = enc.Encode(d)
+	err = secrets.WriteFile(p.keys, devicePath, buf.Bytes(), 0o644)
 	if err != nil {
-		return fmt.Errorf("StoreDeviceInfo: could not encode device info: %w", err)
+		return fmt.Errorf("StoreDeviceInfo: could not write device info: %w", err)
 	}
 	return nil
 }
 
-func readSelfIdentity(p string) (selfIdentity, error) {
-	content, err := os.ReadFile(p)
+func readSelfIdentity(p string, keys secrets.KeyProvider) (selfIdentity, error) {
+	content, err := secrets.ReadFile(keys, p)
 	if err != nil {
 		return selfIdentity{}, fmt.Errorf("readSelfIdentity: could not read file: %w", err)
 	}
@@ -90,11 +101,11 @@ func readSelfIdentity(p string) (selfIdentity, error) {
 	return s, nil
 }
 
-func getOrCreateSelfIdentity(p string) (selfIdentity, error) {
+func getOrCreateSelfIdentity(p string, keys secrets.KeyProvider) (selfIdentity, error) {
 	info, err := os.Stat(p)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return createSelfIdentity(p)
+			return createSelfIdentity(p, keys)
 		} else {
 			return selfIdentity{}, fmt.Errorf("getOrCreateSelfIdentity: failed to get file info: %w", err)
 		}
@@ -102,10 +113,10 @@ func getOrCreateSelfIdentity(p string) (selfIdentity, error) {
 	if info.IsDir() {
 		return selfIdentity{}, fmt.Errorf("getOrCreateSelfIdentity: '%s' is a directory", p)
 	}
-	return readSelfIdentity(p)
+	return readSelfIdentity(p, keys)
 }
 
-func createSelfIdentity(p string) (selfIdentity, error) {
+func createSelfIdentity(p string, keys secrets.KeyProvider) (selfIdentity, error) {
 	irk := make([]byte, 16)
 	_, _ = rand.Read(irk)
 
@@ -121,17 +132,16 @@ func createSelfIdentity(p string) (selfIdentity, error) {
 		PublicKey:  pub,
 	}
 
-	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY, 0o644)
-	if err != nil {
-		return selfIdentity{}, fmt.Errorf("createSelfIdentity: failed to open file for writing: %w", err)
-	}
-	defer f.Close()
-
-	enc := plist.NewEncoderForFormat(f, plist.BinaryFormat)
+	var buf bytes.Buffer
+	enc := plist.NewEncoderForFormat(&buf, plist.BinaryFormat)
 	err = enc.Encode(si)
 	if err != nil {
 		return selfIdentity{}, fmt.Errorf("createSelfIdentity: failed to encode self identity as plist: %w", err)
 	}
+	err = secrets.WriteFile(keys, p, buf.Bytes(), 0o600)
+	if err != nil {
+		return selfIdentity{}, fmt.Errorf("createSelfIdentity: failed to write self identity: %w", err)
+	}
 
 	return si, nil
 }
@@ -3,8 +3,10 @@ package tunnel
 import (
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
 
+	"github.com/danielpaulus/go-ios/ios/secrets"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/ed25519"
@@ -36,3 +38,25 @@ func TestPairRecordManager(t *testing.T) {
 		assert.True(t, private.Equal(pm.selfId.privateKey()))
 	})
 }
+
+func TestSealedPairRecordManager(t *testing.T) {
+	tmp := t.TempDir()
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	require.NoError(t, secrets.GenerateKeyFile(keyFile))
+	keys, err := secrets.NewLocalKeyProvider(keyFile)
+	require.NoError(t, err)
+
+	pm, err := NewPairRecordManagerWithKeys(tmp, keys)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(path.Join(tmp, "selfIdentity.plist"))
+	require.NoError(t, err)
+	assert.True(t, secrets.IsSealed(b))
+
+	reopened, err := NewPairRecordManagerWithKeys(tmp, keys)
+	require.NoError(t, err)
+	assert.True(t, reopened.selfId.privateKey().Equal(pm.selfId.privateKey()))
+
+	_, err = NewPairRecordManager(tmp)
+	assert.Error(t, err, "sealed pair records can't be read without keys")
+}
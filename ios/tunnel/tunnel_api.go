@@ -212,8 +212,13 @@ type TunnelManager struct {
 	userspaceTUN         bool
 	closeOnce            sync.Once
 	portOffset           int
+	// notNeeded are the connected devices older than iOS 17, they are not checked again until they reconnect
+	notNeeded map[string]bool
 }
 
+// ErrTunnelNotNeeded is returned for devices older than iOS 17, they are reachable through usbmuxd
+var ErrTunnelNotNeeded = errors.New("devices older than iOS 17 need no tunnel")
+
 // NewTunnelManager creates a new TunnelManager instance for setting up device tunnels for all connected devices
 // If userspaceTUN is set to true, the network stack will run in user space.
 func NewTunnelManager(pm PairRecordManager, userspaceTUN bool) *TunnelManager {
@@ -222,6 +227,7 @@ func NewTunnelManager(pm PairRecordManager, userspaceTUN bool) *TunnelManager {
 		dl:                 deviceList{},
 		pm:                 pm,
 		tunnels:            map[string]Tunnel{},
+		notNeeded:          map[string]bool{},
 		startTunnelTimeout: 10 * time.Second,
 		userspaceTUN:       userspaceTUN,
 		portOffset:         1,
@@ -272,11 +278,23 @@ func (m *TunnelManager) UpdateTunnels(ctx context.Context) error {
 		if _, exists := localTunnels[udid]; exists {
 			continue
 		}
+		m.mux.Lock()
+		notNeeded := m.notNeeded[udid]
+		m.mux.Unlock()
+		if notNeeded {
+			continue
+		}
 		if m.userspaceTUN && d.UserspaceTUNPort == 0 {
 			d.UserspaceTUNPort = ios.HttpApiPort() + m.portOffset
 			m.portOffset++
 		}
 		t, err := m.startTunnel(ctx, d)
+		if errors.Is(err, ErrTunnelNotNeeded) {
+			m.mux.Lock()
+			m.notNeeded[udid] = true
+			m.mux.Unlock()
+			continue
+		}
 		if err != nil {
 			log.WithField("udid", udid).
 				WithError(err).
@@ -297,6 +315,13 @@ func (m *TunnelManager) UpdateTunnels(ctx context.Context) error {
 		}
 	}
 	m.mux.Lock()
+	for udid := range m.notNeeded {
+		if !slices.ContainsFunc(devices.DeviceList, func(entry ios.DeviceEntry) bool {
+			return entry.Properties.SerialNumber == udid
+		}) {
+			delete(m.notNeeded, udid)
+		}
+	}
 	m.firstUpdateCompleted = true
 	m.mux.Unlock()
 	return nil
@@ -330,6 +355,9 @@ func (m *TunnelManager) startTunnel(ctx context.Context, device ios.DeviceEntry)
 	if err != nil {
 		return Tunnel{}, fmt.Errorf("startTunnel: failed to get device version: %w", err)
 	}
+	if version.Major() < 17 {
+		return Tunnel{}, ErrTunnelNotNeeded
+	}
 	t, err := m.ts.StartTunnel(startTunnelCtx, device, m.pm, version, m.userspaceTUN)
 	if err != nil {
 		return Tunnel{}, err
@@ -102,6 +102,9 @@ func (muxConn *UsbMuxConnection) Send(msg interface{}) error {
 	}
 	writer := muxConn.deviceConn.Writer()
 	muxConn.tag++
+	if FaultsEnabled() {
+		writer = &faultWriter{writer: writer}
+	}
 	err := muxConn.encode(msg, writer)
 	if err != nil {
 		log.Error("Error sending mux")
@@ -118,12 +121,18 @@ func (muxConn *UsbMuxConnection) SendMuxMessage(msg UsbMuxMessage) error {
 		return io.EOF
 	}
 	writer := muxConn.deviceConn.Writer()
+	if FaultsEnabled() {
+		writer = &faultWriter{writer: writer}
+	}
 	err := binary.Write(writer, binary.LittleEndian, msg.Header)
 	if err != nil {
 		return err
 	}
 	_, err = writer.Write(msg.Payload)
-	return err
+	if err != nil {
+		return err
+	}
+	return flushFaults(writer)
 }
 
 // ReadMessage blocks until the next muxMessage is available on the underlying DeviceConnection and returns it.
@@ -132,11 +141,21 @@ func (muxConn *UsbMuxConnection) ReadMessage() (UsbMuxMessage, error) {
 		return UsbMuxMessage{}, io.EOF
 	}
 	reader := muxConn.deviceConn.Reader()
-	msg, err := muxConn.decode(reader)
-	if err != nil {
-		return UsbMuxMessage{}, err
+	for {
+		msg, err := muxConn.decode(reader)
+		if err != nil {
+			return UsbMuxMessage{}, err
+		}
+		payload, err := InjectFault(FaultTransportUsbmuxd, msg.Payload)
+		if err != nil {
+			return UsbMuxMessage{}, err
+		}
+		if payload == nil {
+			continue
+		}
+		msg.Payload = payload
+		return msg, nil
 	}
-	return msg, nil
 }
 
 // encode serializes a MuxMessage struct to a Plist and writes it to the io.Writer.
@@ -148,6 +167,34 @@ func (muxConn *UsbMuxConnection) encode(message interface{}, writer io.Writer) e
 		return err
 	}
 	_, err = writer.Write(mbytes)
+	if err != nil {
+		return err
+	}
+	return flushFaults(writer)
+}
+
+// faultWriter collects the header and payload of a message, so flushFaults can inject faults into the whole frame
+type faultWriter struct {
+	writer io.Writer
+	frame  []byte
+}
+
+func (w *faultWriter) Write(p []byte) (int, error) {
+	w.frame = append(w.frame, p...)
+	return len(p), nil
+}
+
+// flushFaults writes the frame collected by a faultWriter, it does nothing for other writers
+func flushFaults(writer io.Writer) error {
+	w, ok := writer.(*faultWriter)
+	if !ok {
+		return nil
+	}
+	frame, err := InjectFault(FaultTransportUsbmuxd, w.frame)
+	if err != nil || frame == nil {
+		return err
+	}
+	_, err = w.writer.Write(frame)
 	return err
 }
 
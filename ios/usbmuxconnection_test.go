@@ -153,3 +153,21 @@ func (mock *DeviceConnectionMock) DisableSessionSSL() {
 func (mock *DeviceConnectionMock) Conn() net.Conn {
 	return nil
 }
+
+func TestUsbMuxFaults(t *testing.T) {
+	mc := new(DeviceConnectionMock)
+	buf := new(bytes.Buffer)
+	mc.On("Writer").Return(buf)
+	mc.On("Reader").Return(buf)
+	muxConn := ios.NewUsbMuxConnection(mc)
+
+	restore := ios.SetFaults(ios.FaultConfig{DropRate: 1, Transports: []ios.FaultTransport{ios.FaultTransportUsbmuxd}})
+	assert.NoError(t, muxConn.Send(ios.NewReadDevices()))
+	assert.Zero(t, buf.Len(), "the message was dropped")
+	restore()
+
+	assert.NoError(t, muxConn.Send(ios.NewReadDevices()))
+	defer ios.SetFaults(ios.FaultConfig{ErrorRate: 1})()
+	_, err := muxConn.ReadMessage()
+	assert.ErrorIs(t, err, ios.ErrInjectedFault)
+}
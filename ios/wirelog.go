@@ -0,0 +1,130 @@
+package ios
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WireLogLevel controls how much of the protocol traffic with a device is logged
+type WireLogLevel string
+
+const (
+	// WireLogOff logs no protocol traffic, it is the default
+	WireLogOff = WireLogLevel("off")
+	// WireLogMessages logs every decoded lockdown plist and DTX message
+	WireLogMessages = WireLogLevel("messages")
+	// WireLogHexDump additionally logs a hex dump of every lockdown and DTX frame
+	WireLogHexDump = WireLogLevel("hexdump")
+)
+
+var wireLogLevelOrder = map[WireLogLevel]int{WireLogOff: 0, WireLogMessages: 1, WireLogHexDump: 2}
+
+// ParseWireLogLevel parses "off", "messages" or "hexdump"
+func ParseWireLogLevel(level string) (WireLogLevel, error) {
+	if _, ok := wireLogLevelOrder[WireLogLevel(level)]; !ok {
+		return WireLogOff, fmt.Errorf("ParseWireLogLevel: unknown level '%s', use off, messages or hexdump", level)
+	}
+	return WireLogLevel(level), nil
+}
+
+var wireLog = struct {
+	mu sync.RWMutex
+	// levels by udid
+	levels map[string]WireLogLevel
+	// udids by usbmuxd device id
+	udids map[int]string
+	// enabled is true if any device has a level other than off, it keeps the check cheap when wire logging is not used
+	enabled atomic.Bool
+}{levels: map[string]WireLogLevel{}, udids: map[int]string{}}
+
+// SetWireLogLevel changes the protocol logging of a device at runtime, it applies to open connections as well.
+// Only connections through usbmuxd are logged. Logs are written with logrus at info level and can contain
+// sensitive data like pair records, so only enable it while debugging.
+func SetWireLogLevel(device DeviceEntry, level WireLogLevel) error {
+	if _, ok := wireLogLevelOrder[level]; !ok {
+		return fmt.Errorf("SetWireLogLevel: unknown level '%s'", level)
+	}
+	udid := device.Properties.SerialNumber
+	wireLog.mu.Lock()
+	defer wireLog.mu.Unlock()
+	if level == WireLogOff {
+		delete(wireLog.levels, udid)
+	} else {
+		wireLog.levels[udid] = level
+	}
+	if device.DeviceID != 0 {
+		wireLog.udids[device.DeviceID] = udid
+	}
+	wireLog.enabled.Store(len(wireLog.levels) > 0)
+	return nil
+}
+
+// WireLogLevels returns the udids of all devices with wire logging enabled and their level
+func WireLogLevels() map[string]WireLogLevel {
+	wireLog.mu.RLock()
+	defer wireLog.mu.RUnlock()
+	result := make(map[string]WireLogLevel, len(wireLog.levels))
+	for udid, level := range wireLog.levels {
+		result[udid] = level
+	}
+	return result
+}
+
+// WireLogger logs the frames of a connection at the level configured for its device with SetWireLogLevel
+type WireLogger struct {
+	deviceID int
+	service  string
+	connID   uint64
+}
+
+// NewWireLogger creates a WireLogger for a connection. Connections that do not go through usbmuxd are never logged.
+func NewWireLogger(conn DeviceConnectionInterface) WireLogger {
+	tracked, ok := trackedConnOf(conn)
+	if !ok {
+		return WireLogger{}
+	}
+	info := tracked.snapshot()
+	return WireLogger{deviceID: info.DeviceID, service: info.Service, connID: info.ID}
+}
+
+func (w WireLogger) enabled(level WireLogLevel) (string, bool) {
+	if w.connID == 0 || !wireLog.enabled.Load() {
+		return "", false
+	}
+	wireLog.mu.RLock()
+	defer wireLog.mu.RUnlock()
+	udid, ok := wireLog.udids[w.deviceID]
+	if !ok {
+		return "", false
+	}
+	return udid, wireLogLevelOrder[wireLog.levels[udid]] >= wireLogLevelOrder[level]
+}
+
+// Enabled returns true if at least level is configured for the device of the connection.
+// Use it to skip decoding messages only needed for logging.
+func (w WireLogger) Enabled(level WireLogLevel) bool {
+	_, ok := w.enabled(level)
+	return ok
+}
+
+// Message logs a decoded message at WireLogMessages. direction is "send" or "receive".
+func (w WireLogger) Message(direction string, message interface{}) {
+	if udid, ok := w.enabled(WireLogMessages); ok {
+		w.entry(udid, direction).Infof("%+v", message)
+	}
+}
+
+// Frame logs a hex dump of a raw frame at WireLogHexDump. direction is "send" or "receive".
+func (w WireLogger) Frame(direction string, frame []byte) {
+	if udid, ok := w.enabled(WireLogHexDump); ok {
+		w.entry(udid, direction).WithField("length", len(frame)).Info("\n" + hex.Dump(frame))
+	}
+}
+
+func (w WireLogger) entry(udid string, direction string) *log.Entry {
+	return log.WithFields(log.Fields{"udid": udid, "service": w.service, "connection": w.connID, "direction": direction})
+}
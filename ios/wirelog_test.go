@@ -0,0 +1,57 @@
+package ios
+
+import (
+	"net"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWireLogger(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	client, server := net.Pipe()
+	defer server.Close()
+	deviceConn := NewDeviceConnectionWithConn(trackConn(client))
+	defer deviceConn.Close()
+	tracked, _ := trackedConnOf(deviceConn)
+	tracked.connectedTo(ConnectionKindService, 7)
+	tracked.startedService("com.apple.instruments.remoteserver")
+	device := DeviceEntry{DeviceID: 7, Properties: DeviceProperties{SerialNumber: "udid"}}
+	defer SetWireLogLevel(device, WireLogOff)
+
+	wireLogger := NewWireLogger(deviceConn)
+	wireLogger.Message("send", "not logged")
+	assert.Empty(t, hook.AllEntries())
+
+	require.NoError(t, SetWireLogLevel(device, WireLogMessages))
+	assert.Equal(t, map[string]WireLogLevel{"udid": WireLogMessages}, WireLogLevels())
+	wireLogger.Message("send", "hello")
+	wireLogger.Frame("send", []byte("hello"))
+	require.Len(t, hook.AllEntries(), 1)
+	entry := hook.LastEntry()
+	assert.Equal(t, "hello", entry.Message)
+	assert.Equal(t, log.Fields{"udid": "udid", "service": "com.apple.instruments.remoteserver", "connection": tracked.info.ID, "direction": "send"}, entry.Data)
+
+	require.NoError(t, SetWireLogLevel(device, WireLogHexDump))
+	wireLogger.Frame("receive", []byte("hello"))
+	assert.Contains(t, hook.LastEntry().Message, "68 65 6c 6c 6f")
+
+	require.NoError(t, SetWireLogLevel(device, WireLogOff))
+	assert.Empty(t, WireLogLevels())
+	assert.False(t, wireLogger.Enabled(WireLogMessages))
+	assert.Error(t, SetWireLogLevel(device, WireLogLevel("loud")))
+}
+
+func TestWireLoggerIgnoresUntrackedConnections(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	device := DeviceEntry{Properties: DeviceProperties{SerialNumber: "udid"}}
+	require.NoError(t, SetWireLogLevel(device, WireLogHexDump))
+	defer SetWireLogLevel(device, WireLogOff)
+	assert.False(t, NewWireLogger(NewDeviceConnectionWithConn(client)).Enabled(WireLogMessages))
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package workspace
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+const stillActive = 259
+
+func processRunning(pid int) bool {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(handle)
+	var exitCode uint32
+	err = windows.GetExitCodeProcess(handle, &exitCode)
+	return err == nil && exitCode == stillActive
+}
@@ -0,0 +1,175 @@
+// Package workspace gives jobs isolated working directories for derived xctestconfigs, pulled artifacts
+// and temporary ipas. Every workspace records the process that created it, so workspaces left behind by
+// a crashed process can be removed with GC on the next start.
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ownerFile is created in every workspace and contains the pid of the process using it
+const ownerFile = ".owner"
+
+// orphanGracePeriod is how old a workspace without owner file has to be before GC removes it. The owner file
+// is written right after creating the directory, so it can only be missing for a moment or after a crash.
+const orphanGracePeriod = time.Hour
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Manager creates workspaces below its root directory
+type Manager struct {
+	root   string
+	mu     sync.Mutex
+	active map[string]*Workspace
+}
+
+// Workspace is a working directory that is removed with everything in it when it is closed
+type Workspace struct {
+	// Dir is the absolute path of the workspace
+	Dir     string
+	manager *Manager
+	once    sync.Once
+}
+
+var defaultManager = NewManager(filepath.Join(os.TempDir(), "go-ios-workspaces"))
+
+// Default returns the Manager that creates workspaces in the go-ios-workspaces folder of the temp dir
+func Default() *Manager {
+	return defaultManager
+}
+
+// NewManager creates a Manager for workspaces below root. The directory is created with the first workspace.
+func NewManager(root string) *Manager {
+	return &Manager{root: root, active: map[string]*Workspace{}}
+}
+
+// Root returns the directory containing the workspaces
+func (m *Manager) Root() string {
+	return m.root
+}
+
+// New creates a workspace. The name, f.ex. a job id, is used as prefix of the directory name to make it easier to
+// find, it does not have to be unique.
+func (m *Manager) New(name string) (*Workspace, error) {
+	err := os.MkdirAll(m.root, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("New: failed creating workspace root: %w", err)
+	}
+	prefix := strings.Trim(unsafeChars.ReplaceAllString(name, "_"), "._")
+	if prefix == "" {
+		prefix = "workspace"
+	}
+	dir, err := os.MkdirTemp(m.root, prefix+"-")
+	if err != nil {
+		return nil, fmt.Errorf("New: failed creating workspace: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, ownerFile), []byte(strconv.Itoa(os.Getpid())), 0o644)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("New: failed writing owner of workspace: %w", err)
+	}
+	ws := &Workspace{Dir: dir, manager: m}
+	m.mu.Lock()
+	m.active[dir] = ws
+	m.mu.Unlock()
+	log.WithField("dir", dir).Debug("created workspace")
+	return ws, nil
+}
+
+// Active returns the directories of the workspaces of this process that are not closed yet
+func (m *Manager) Active() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]string, 0, len(m.active))
+	for dir := range m.active {
+		result = append(result, dir)
+	}
+	return result
+}
+
+// GC removes all workspaces whose process is not running anymore and returns their directories. Call it on
+// startup to clean up after crashes. Workspaces of running processes, including this one, are kept.
+func (m *Manager) GC() ([]string, error) {
+	entries, err := os.ReadDir(m.root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GC: failed reading %s: %w", m.root, err)
+	}
+	var removed []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(m.root, entry.Name())
+		if !m.abandoned(dir) {
+			continue
+		}
+		err := os.RemoveAll(dir)
+		if err != nil {
+			log.WithField("dir", dir).WithError(err).Warn("failed removing abandoned workspace")
+			continue
+		}
+		removed = append(removed, dir)
+	}
+	if len(removed) > 0 {
+		log.WithField("count", len(removed)).Info("removed abandoned workspaces")
+	}
+	return removed, nil
+}
+
+func (m *Manager) abandoned(dir string) bool {
+	owner, err := os.ReadFile(filepath.Join(dir, ownerFile))
+	if err != nil {
+		info, err := os.Stat(dir)
+		return err == nil && time.Since(info.ModTime()) > orphanGracePeriod
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(owner)))
+	if err != nil {
+		return true
+	}
+	if pid == os.Getpid() {
+		return false
+	}
+	return !processRunning(pid)
+}
+
+// Path returns the path of elem inside the workspace
+func (w *Workspace) Path(elem ...string) string {
+	return filepath.Join(append([]string{w.Dir}, elem...)...)
+}
+
+// MkdirTemp creates a new directory inside the workspace, see os.MkdirTemp
+func (w *Workspace) MkdirTemp(pattern string) (string, error) {
+	return os.MkdirTemp(w.Dir, pattern)
+}
+
+// CreateTemp creates a new file inside the workspace, see os.CreateTemp
+func (w *Workspace) CreateTemp(pattern string) (*os.File, error) {
+	return os.CreateTemp(w.Dir, pattern)
+}
+
+// Close removes the workspace and everything in it. It is safe to call Close multiple times.
+func (w *Workspace) Close() error {
+	var err error
+	w.once.Do(func() {
+		w.manager.mu.Lock()
+		delete(w.manager.active, w.Dir)
+		w.manager.mu.Unlock()
+		err = os.RemoveAll(w.Dir)
+		if err != nil {
+			log.WithField("dir", w.Dir).WithError(err).Warn("failed removing workspace")
+		}
+	})
+	return err
+}
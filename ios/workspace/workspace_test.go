@@ -0,0 +1,66 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkspaceIsolation(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	a, err := manager.New("job/1")
+	require.NoError(t, err)
+	b, err := manager.New("job/1")
+	require.NoError(t, err)
+	assert.NotEqual(t, a.Dir, b.Dir)
+	assert.Equal(t, manager.Root(), filepath.Dir(a.Dir))
+	assert.Contains(t, filepath.Base(a.Dir), "job_1-")
+	assert.ElementsMatch(t, []string{a.Dir, b.Dir}, manager.Active())
+
+	f, err := a.CreateTemp("ipa-*")
+	require.NoError(t, err)
+	f.Close()
+	assert.Equal(t, a.Dir, filepath.Dir(f.Name()))
+
+	require.NoError(t, a.Close())
+	require.NoError(t, a.Close())
+	assert.NoDirExists(t, a.Dir)
+	assert.DirExists(t, b.Dir)
+	assert.Equal(t, []string{b.Dir}, manager.Active())
+}
+
+func TestGCRemovesAbandonedWorkspaces(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	own, err := manager.New("own")
+	require.NoError(t, err)
+
+	crashed := filepath.Join(manager.Root(), "crashed")
+	require.NoError(t, os.Mkdir(crashed, 0o755))
+	// pids are much smaller than this on all supported systems, so no process is running with it
+	require.NoError(t, os.WriteFile(filepath.Join(crashed, ownerFile), []byte(strconv.Itoa(1<<30)), 0o644))
+
+	orphan := filepath.Join(manager.Root(), "orphan")
+	require.NoError(t, os.Mkdir(orphan, 0o755))
+	old := time.Now().Add(-2 * orphanGracePeriod)
+	require.NoError(t, os.Chtimes(orphan, old, old))
+
+	fresh := filepath.Join(manager.Root(), "fresh")
+	require.NoError(t, os.Mkdir(fresh, 0o755))
+
+	removed, err := manager.GC()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{crashed, orphan}, removed)
+	assert.DirExists(t, own.Dir)
+	assert.DirExists(t, fresh)
+}
+
+func TestGCWithoutRoot(t *testing.T) {
+	removed, err := NewManager(filepath.Join(t.TempDir(), "missing")).GC()
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+}
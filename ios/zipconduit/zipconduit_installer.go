@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/workspace"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -83,10 +84,24 @@ func NewWithShimConnection(device ios.DeviceEntry) (*Connection, error) {
 	}, nil
 }
 
+// Progress is a status update the device sends while it installs an app
+type Progress struct {
+	Status          string `json:"status"`
+	PercentComplete int    `json:"percentComplete"`
+}
+
 // SendFile will send either a zipFile or an unzipped directory to the device.
 // If you specify appFilePath to a file, it will try to Unzip it to a temp dir first and then send.
 // If appFilePath points to a directory, it will try to install the dir contents as an app.
+// The installation fails if it takes longer than the timeout of ios.OperationInstall, if one is configured.
 func (conn Connection) SendFile(appFilePath string) error {
+	return conn.SendFileWithProgress(appFilePath, nil)
+}
+
+// SendFileWithProgress works like SendFile and calls progress for every status update of the installation.
+// The last update before it returns without error has PercentComplete 100.
+func (conn Connection) SendFileWithProgress(appFilePath string, progress func(Progress)) error {
+	defer ios.SetOperationDeadline(conn.deviceConn, ios.OperationInstall)()
 	openedFile, err := os.Open(appFilePath)
 	if err != nil {
 		return err
@@ -98,28 +113,26 @@ func (conn Connection) SendFile(appFilePath string) error {
 	if err != nil {
 		return err
 	}
+	if progress == nil {
+		progress = func(Progress) {}
+	}
 	if info.IsDir() {
-		return conn.sendDirectory(appFilePath)
+		return conn.sendDirectory(appFilePath, progress)
 	}
-	return conn.sendIpaFile(appFilePath)
+	return conn.sendIpaFile(appFilePath, progress)
 }
 
 func (conn Connection) Close() error {
 	return conn.deviceConn.Close()
 }
 
-func (conn Connection) sendDirectory(dir string) error {
-	tmpDir, err := os.MkdirTemp("", "prefix")
+func (conn Connection) sendDirectory(dir string, progress func(Progress)) error {
+	ws, err := workspace.Default().New("zipconduit")
 	if err != nil {
 		return err
 	}
-	log.Debugf("created tempdir: %s", tmpDir)
-	defer func() {
-		err := os.RemoveAll(tmpDir)
-		if err != nil {
-			log.WithFields(log.Fields{"dir": tmpDir}).Warn("failed removing tempdir")
-		}
-	}()
+	defer ws.Close()
+	tmpDir := ws.Dir
 	var totalBytes int64
 	var unzippedFiles []string
 	err = filepath.Walk(dir,
@@ -177,21 +190,16 @@ func (conn Connection) sendDirectory(dir string) error {
 		return err
 	}
 
-	return conn.waitForInstallation()
+	return conn.waitForInstallation(progress)
 }
 
-func (conn Connection) sendIpaFile(ipaFile string) error {
-	tmpDir, err := os.MkdirTemp("", "prefix")
+func (conn Connection) sendIpaFile(ipaFile string, progress func(Progress)) error {
+	ws, err := workspace.Default().New("zipconduit")
 	if err != nil {
 		return err
 	}
-	log.Debugf("created tempdir: %s", tmpDir)
-	defer func() {
-		err := os.RemoveAll(tmpDir)
-		if err != nil {
-			log.WithFields(log.Fields{"dir": tmpDir}).Warn("failed removing tempdir")
-		}
-	}()
+	defer ws.Close()
+	tmpDir := ws.Dir
 	log.Debug("unzipping..")
 	unzippedFiles, totalBytes, err := ios.Unzip(ipaFile, tmpDir)
 	if err != nil {
@@ -240,10 +248,10 @@ func (conn Connection) sendIpaFile(ipaFile string) error {
 		return err
 	}
 
-	return conn.waitForInstallation()
+	return conn.waitForInstallation(progress)
 }
 
-func (conn Connection) waitForInstallation() error {
+func (conn Connection) waitForInstallation(progress func(Progress)) error {
 	for {
 		msg, _ := conn.plistCodec.Decode(conn.deviceConn)
 		plist, _ := ios.ParsePlist(msg)
@@ -252,6 +260,7 @@ func (conn Connection) waitForInstallation() error {
 		if err != nil {
 			return err
 		}
+		progress(Progress{Status: status, PercentComplete: percent})
 		if done {
 			log.Info("installation successful")
 			return nil
@@ -0,0 +1,44 @@
+package zipconduit
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type bufferConn struct {
+	*bytes.Buffer
+}
+
+func (bufferConn) Close() error { return nil }
+
+func progressUpdate(percent int, status string) map[string]interface{} {
+	return map[string]interface{}{"InstallProgressDict": map[string]interface{}{"PercentComplete": percent, "Status": status}}
+}
+
+func TestWaitForInstallationReportsProgress(t *testing.T) {
+	codec := ios.NewPlistCodec()
+	buf := &bytes.Buffer{}
+	for _, update := range []interface{}{
+		progressUpdate(20, "CreatingStagingDirectory"),
+		progressUpdate(60, "VerifyingApplication"),
+		map[string]interface{}{"Status": "DataComplete"},
+	} {
+		b, err := codec.Encode(update)
+		require.NoError(t, err)
+		buf.Write(b)
+	}
+	conn := Connection{deviceConn: bufferConn{buf}, plistCodec: codec}
+
+	var updates []Progress
+	err := conn.waitForInstallation(func(p Progress) { updates = append(updates, p) })
+	require.NoError(t, err)
+	assert.Equal(t, []Progress{
+		{Status: "CreatingStagingDirectory", PercentComplete: 20},
+		{Status: "VerifyingApplication", PercentComplete: 60},
+		{Status: "DataComplete", PercentComplete: 100},
+	}, updates)
+}
@@ -26,14 +26,18 @@ import (
 	"github.com/danielpaulus/go-ios/ios/mobileactivation"
 
 	"github.com/danielpaulus/go-ios/ios/afc"
+	"github.com/danielpaulus/go-ios/ios/afc/afcfuse"
+	"github.com/danielpaulus/go-ios/ios/house_arrest"
 
 	"github.com/danielpaulus/go-ios/ios/crashreport"
 	"github.com/danielpaulus/go-ios/ios/testmanagerd"
 
 	"github.com/danielpaulus/go-ios/ios/debugserver"
+	"github.com/danielpaulus/go-ios/ios/healthcheck"
 	"github.com/danielpaulus/go-ios/ios/imagemounter"
 	"github.com/danielpaulus/go-ios/ios/zipconduit"
 
+	"github.com/danielpaulus/go-ios/ios/secrets"
 	"github.com/danielpaulus/go-ios/ios/simlocation"
 
 	"github.com/danielpaulus/go-ios/ios"
@@ -45,8 +49,10 @@ import (
 	"github.com/danielpaulus/go-ios/ios/mcinstall"
 	"github.com/danielpaulus/go-ios/ios/notificationproxy"
 	"github.com/danielpaulus/go-ios/ios/pcap"
+	"github.com/danielpaulus/go-ios/ios/ratelimit"
 	syslog "github.com/danielpaulus/go-ios/ios/syslog"
 	"github.com/docopt/docopt-go"
+	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -91,8 +97,10 @@ Usage:
   ios lang [--setlocale=<locale>] [--setlang=<newlang>] [options]
   ios mobilegestalt <key>... [--plist] [options]
   ios diagnostics list [options]
+  ios services [<service>...] [options]
+  ios healthcheck [options]
   ios profile list [options]
-  ios prepare [--skip-all] [--skip=<option>]... [--certfile=<cert_file_path>] [--orgname=<org_name>] [--locale] [--lang] [options]
+  ios prepare [--skip-all] [--skip=<option>]... [--certfile=<cert_file_path>] [--orgname=<org_name>] [--locale] [--lang] [--devmode] [options]
   ios prepare create-cert
   ios prepare printskip
   ios profile remove <profileName> [options]
@@ -111,12 +119,13 @@ Usage:
   ios apps [--system] [--all] [--list] [--filesharing] [options]
   ios launch <bundleID> [--wait] [--kill-existing] [options]
   ios kill (<bundleID> | --pid=<processID> | --process=<processName>) [options]
-  ios runtest [--bundle-id=<bundleid>] [--test-runner-bundle-id=<testrunnerbundleid>] [--xctest-config=<xctestconfig>] [--log-output=<file>] [--xctest] [--test-to-run=<tests>]... [--test-to-skip=<tests>]... [--env=<e>]... [options]
+  ios runtest [--bundle-id=<bundleid>] [--test-runner-bundle-id=<testrunnerbundleid>] [--xctest-config=<xctestconfig>] [--log-output=<file>] [--junit=<file>] [--json-report=<file>] [--allure=<dir>] [--xctest] [--test-to-run=<tests>]... [--test-to-skip=<tests>]... [--env=<e>]... [--retries=<n>] [--energy] [options]
   ios runwda [--bundleid=<bundleid>] [--testrunnerbundleid=<testbundleid>] [--xctestconfig=<xctestconfig>] [--log-output=<file>] [--arg=<a>]... [--env=<e>]... [options]
   ios ax [--font=<fontSize>] [options]
   ios debug [options] [--stop-at-entry] <app_path>
   ios fsync (rm [--r] | tree | mkdir) --path=<targetPath>
-  ios fsync (pull | push) --srcPath=<srcPath> --dstPath=<dstPath>
+  ios fsync (pull | push) --srcPath=<srcPath> --dstPath=<dstPath> [--limit=<bytes_per_second>]
+  ios fsync mount --mountpoint=<dir> [--app=<bundleID>] [--readonly] [options]
   ios reboot [options]
   ios -h | --help
   ios --version | version [options]
@@ -133,6 +142,10 @@ Usage:
   ios tunnel stopagent 
   ios devmode (enable | get) [--enable-post-restart] [options]
   ios rsd ls [options]
+  ios secrets keygen <keyfile>
+  ios secrets seal <input> <output>
+  ios secrets open <input> <output>
+  ios secrets seal-value <value>
 
 Options:
   -v --verbose              Enable Debug Logging.
@@ -164,7 +177,7 @@ The commands work as following:
    ios image mount [--path=<imagepath>] [options]                     Mount a image from <imagepath>
    >                                                                  For iOS 17+ (personalized developer disk images) <imagepath> must point to the "Restore" directory inside the developer disk
    ios image unmount [options]                                        Unmount developer disk image
-   ios image auto [--basedir=<where_dev_images_are_stored>] [options] Automatically download correct dev image from the internets and mount it.
+   ios image auto [--basedir=<where_dev_images_are_stored>] [options] Automatically download correct dev image from the internets and mount it, unless an image is mounted already.
    >                                                                  You can specify a dir where images should be cached.
    >                                                                  The default is the current dir.
    ios syslog [options]                                               Prints a device's log output
@@ -186,15 +199,21 @@ The commands work as following:
    >                                                                  it in plist format by adding the --plist param.
    >                                                                  Ex.: "ios mobilegestalt MainScreenCanvasSizes ArtworkTraits --plist"
    ios diagnostics list [options]                                     List diagnostic infos
+   ios services [<service>...] [options]                              Tries to start each lockdown service go-ios knows, or only the given ones, and
+   >                                                                  prints whether it is available, missing or denied with the reason of lockdown.
+   >                                                                  Helps finding out why a feature fails on a particular iOS version or supervision state.
+   ios healthcheck [options]                                          Exercises pairing, lockdown, the tunnel, the developer image, instruments, afc and screenshots
+   >                                                                  and prints which of them work with timings. Exits with 1 if the device is not usable.
    ios pair [--p12file=<orgid>] [--password=<p12password>] [options]  Pairs the device. If the device is supervised, specify the path to the p12 file
    >                                                                  to pair without a trust dialog. Specify the password either with the argument or
    >                                                                  by setting the environment variable 'P12_PASSWORD'
    ios profile list                                                   List the profiles on the device
    ios profile remove <profileName>                                   Remove the profileName from the device
    ios profile add <profileFile> [--p12file=<orgid>] [--password=<p12password>] Install profile file on the device. If supervised set p12file and password or the environment variable 'P12_PASSWORD'
-   ios prepare [--skip-all] [--skip=<option>]... [--certfile=<cert_file_path>] [--orgname=<org_name>] [--locale] [--lang] [options] prepare a device. Use skip-all to skip everything multiple --skip args to skip only a subset.
+   ios prepare [--skip-all] [--skip=<option>]... [--certfile=<cert_file_path>] [--orgname=<org_name>] [--locale] [--lang] [--devmode] [options] prepare a device. Use skip-all to skip everything multiple --skip args to skip only a subset.
    >                                                                  You can use 'ios prepare printskip' to get a list of all options to skip. Use certfile and orgname if you want to supervise the device. If you need certificates
    >                                                                  to supervise, run 'ios prepare create-cert' and go-ios will generate one you can use. locale and lang are optional, the default is en_US and en.
+   >                                                                  Use --devmode to enable developer mode (iOS 16+) after preparing. The resulting state tells if something still needs to be confirmed on the device.
    >                                                                  Run 'ios lang' to see a list of all supported locales and languages.
    ios prepare create-cert                                            A nice util to generate a certificate you can use for supervising devices. Make sure you rename and store it in a safe place.
    ios prepare printskip                                              Print all options you can skip.
@@ -223,16 +242,24 @@ The commands work as following:
    ios apps [--system] [--all] [--list] [--filesharing]               Retrieves a list of installed applications. --system prints out preinstalled system apps. --all prints all apps, including system, user, and hidden apps. --list only prints bundle ID, bundle name and version number. --filesharing only prints apps which enable documents sharing.
    ios launch <bundleID> [--wait] [--kill-existing] [options]         Launch app with the bundleID on the device. Get your bundle ID from the apps command. --wait keeps the connection open if you want logs.
    ios kill (<bundleID> | --pid=<processID> | --process=<processName>) [options] Kill app with the specified bundleID, process id, or process name on the device.
-   ios runtest [--bundle-id=<bundleid>] [--test-runner-bundle-id=<testbundleid>] [--xctest-config=<xctestconfig>] [--log-output=<file>] [--xctest] [--test-to-run=<tests>]... [--test-to-skip=<tests>]... [--env=<e>]... [options]                    Run a XCUITest. If you provide only bundle-id go-ios will try to dynamically create test-runner-bundle-id and xctest-config.
+   ios runtest [--bundle-id=<bundleid>] [--test-runner-bundle-id=<testbundleid>] [--xctest-config=<xctestconfig>] [--log-output=<file>] [--junit=<file>] [--json-report=<file>] [--allure=<dir>] [--xctest] [--test-to-run=<tests>]... [--test-to-skip=<tests>]... [--env=<e>]... [--retries=<n>] [--energy] [options]                    Run a XCUITest. If you provide only bundle-id go-ios will try to dynamically create test-runner-bundle-id and xctest-config.
    >                                                                  If you provide '-' as log output, it prints resuts to stdout.
+   >                                                                  --junit writes a JUnit XML report and --json-report a json summary of the test run to the given file.
+   >                                                                  --allure writes Allure results labeled with the device model and iOS version to the given directory.
+   >                                                                  --retries runs failed test cases again up to n times, the reports list the earlier attempts and count tests that passed then as flaky.
+   >                                                                  --energy samples the energy impact of the app and the test runner and the battery drain, the json report includes them.
    >                                                                  To be able to filter for tests to run or skip, use one argument per test selector. Example: runtest --test-to-run=(TestTarget.)TestClass/testMethod --test-to-run=(TestTarget.)TestClass/testMethod (the value for 'TestTarget' is optional)
    >                                                                  The method name can also be omitted and in this case all tests of the specified class are run
    ios runwda [--bundleid=<bundleid>] [--testrunnerbundleid=<testbundleid>] [--xctestconfig=<xctestconfig>] [--log-output=<file>] [--arg=<a>]... [--env=<e>]...[options]  runs WebDriverAgents
    >                                                                  specify runtime args and env vars like --env ENV_1=something --env ENV_2=else  and --arg ARG1 --arg ARG2
+   >                                                                  args and env vars of runtest and runwda can contain the template variables {{udid}}, {{wda_port}}, {{artifact_dir}} and {{job_id}}
    ios ax [--font=<fontSize>] [options]                               Access accessibility inspector features.
    ios debug [--stop-at-entry] <app_path>                             Start debug with lldb
    ios fsync (rm [--r] | tree | mkdir) --path=<targetPath>            Remove | treeview | mkdir in target path. --r used alongside rm will recursively remove all files and directories from target path.
-   ios fsync (pull | push) --srcPath=<srcPath> --dstPath=<dstPath>    Pull or Push file from srcPath to dstPath.
+   ios fsync (pull | push) --srcPath=<srcPath> --dstPath=<dstPath> [--limit=<bytes_per_second>]    Pull or Push file from srcPath to dstPath.
+   >                                                                  Use --limit to limit the bandwidth of every file, so large transfers don't saturate the network.
+   ios fsync mount --mountpoint=<dir> [--app=<bundleID>] [--readonly] [options]   Mount the media directory, or the container of an app signed for development with --app, at dir until ctrl+c.
+   >                                                                Needs FUSE (Linux) or macFUSE (macOS), shell tools and rsync work on the mounted files.
    ios reboot [options]                                               Reboot the given device
    ios -h | --help                                                    Prints this screen.
    ios --version | version [options]                                  Prints the version
@@ -253,6 +280,11 @@ The commands work as following:
    ios tunnel ls                                                      List currently started tunnels. Use --enabletun to activate using TUN devices rather than user space network. Requires sudo/admin shells. 
    ios devmode (enable | get) [--enable-post-restart] [options]	  Enable developer mode on the device or check if it is enabled. Can also completely finalize developer mode setup after device is restarted.
    ios rsd ls [options]											  List RSD services and their port.
+   ios secrets keygen <keyfile>                                       Creates a master key file for GO_IOS_KMS=local:<keyfile>. Keep it on a different volume than the secrets.
+   ios secrets seal <input> <output>                                  Encrypts a file like a supervision p12 with the key provider configured in GO_IOS_KMS
+   >                                                                  (local:<keyfile>, awskms:<key arn> or vault:<transit key>). go-ios decrypts sealed files when reading them.
+   ios secrets open <input> <output>                                  Decrypts a file sealed with 'ios secrets seal'.
+   ios secrets seal-value <value>                                     Prints an encrypted value for environment variables like GO_IOS_ADMIN_TOKEN or P12_PASSWORD.
 
   `, version)
 	arguments, err := docopt.ParseDoc(usage)
@@ -307,6 +339,12 @@ The commands work as following:
 		return
 	}
 
+	b, _ = arguments.Bool("secrets")
+	if b {
+		handleSecrets(arguments)
+		return
+	}
+
 	listCommand, _ := arguments.Bool("list")
 	diagnosticsCommand, _ := arguments.Bool("diagnostics")
 	imageCommand, _ := arguments.Bool("image")
@@ -453,6 +491,15 @@ The commands work as following:
 			}
 		}
 		exitIfError("failed erasing", mcinstall.Prepare(device, skip, certBytes, orgname, locale, lang))
+		devmode, _ := arguments.Bool("--devmode")
+		if devmode {
+			state, err := amfi.EnableDeveloperModeWithState(device, true, func(state amfi.DevModeState) {
+				log.WithFields(log.Fields{"udid": device.Properties.SerialNumber, "state": state}).Info("developer mode")
+			})
+			exitIfError("failed enabling developer mode", err)
+			print(convertToJSONString(map[string]interface{}{"prepare": "ok", "developerMode": state}))
+			return
+		}
 		print(convertToJSONString("ok"))
 		return
 	}
@@ -646,15 +693,14 @@ The commands work as following:
 		lat, _ := arguments.String("--lat")
 		lon, _ := arguments.String("--lon")
 
+		setLocation(device, lat, lon)
 		if device.SupportsRsd() {
-			server, err := instruments.NewLocationSimulationService(device)
-			exitIfError("failed to create location simulation service:", err)
-
-			startLocationSimulation(server, lat, lon)
-			return
+			// the device only simulates the location while the connection is open
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, os.Interrupt)
+			<-c
+			resetLocation(device)
 		}
-
-		setLocation(device, lat, lon)
 		return
 	}
 
@@ -699,6 +745,19 @@ The commands work as following:
 		return
 	}
 
+	b, _ = arguments.Bool("services")
+	if b {
+		services := arguments["<service>"].([]string)
+		probeServices(device, services)
+		return
+	}
+
+	b, _ = arguments.Bool("healthcheck")
+	if b {
+		runHealthcheck(device)
+		return
+	}
+
 	b, _ = arguments.Bool("timeformat")
 	if b {
 		force, _ := arguments.Bool("--force")
@@ -725,7 +784,7 @@ The commands work as following:
 		org, _ := arguments.String("--p12file")
 		pwd, _ := arguments.String("--password")
 		if pwd == "" {
-			pwd = os.Getenv("P12_PASSWORD")
+			pwd = secretEnv("P12_PASSWORD")
 		}
 		pairDevice(device, org, pwd)
 		return
@@ -751,14 +810,14 @@ The commands work as following:
 		user, _ := arguments.String("<user>")
 		pass, _ := arguments.String("<pass>")
 		if pass == "" {
-			pass = os.Getenv("PROXY_PASSWORD")
+			pass = secretEnv("PROXY_PASSWORD")
 		}
 		p12file, _ := arguments.String("--p12file")
 		p12password, _ := arguments.String("--password")
 		if p12password == "" {
-			p12password = os.Getenv("P12_PASSWORD")
+			p12password = secretEnv("P12_PASSWORD")
 		}
-		p12bytes, err := os.ReadFile(p12file)
+		p12bytes, err := readSecretFile(p12file)
 		exitIfError("could not read p12-file", err)
 
 		err = mcinstall.SetHttpProxy(device, host, port, user, pass, p12bytes, p12password)
@@ -778,7 +837,7 @@ The commands work as following:
 			p12file, _ := arguments.String("--p12file")
 			p12password, _ := arguments.String("--password")
 			if p12password == "" {
-				p12password = os.Getenv("P12_PASSWORD")
+				p12password = secretEnv("P12_PASSWORD")
 			}
 			if p12file != "" {
 				handleProfileAddSupervised(device, name, p12file, p12password)
@@ -912,9 +971,11 @@ The commands work as following:
 
 		rawTestlog, rawTestlogErr := arguments.String("--log-output")
 		env := arguments["--env"].([]string)
+		env = testTemplateVars(device, env).Resolve(env)
 
 		isXCTest, _ := arguments.Bool("--xctest")
 
+		logWriter := io.Discard
 		if rawTestlogErr == nil {
 			var writer *os.File = os.Stdout
 			if rawTestlog != "-" {
@@ -923,18 +984,50 @@ The commands work as following:
 				writer = file
 			}
 			defer writer.Close()
+			logWriter = writer
+		}
+		listener := testmanagerd.NewTestListener(logWriter, logWriter, os.TempDir())
 
-			testResults, err := testmanagerd.RunXCUITest(bundleID, testRunnerBundleId, xctestConfig, device, env, testsToRun, testsToSkip, testmanagerd.NewTestListener(writer, writer, os.TempDir()), isXCTest)
+		if junitPath, err := arguments.String("--junit"); err == nil {
+			file, err := os.Create(junitPath)
+			exitIfError("Cannot open file "+junitPath, err)
+			defer file.Close()
+			listener.AddReporter(testmanagerd.NewJUnitReporter(file, bundleID))
+		}
+		if jsonReportPath, err := arguments.String("--json-report"); err == nil {
+			file, err := os.Create(jsonReportPath)
+			exitIfError("Cannot open file "+jsonReportPath, err)
+			defer file.Close()
+			listener.AddReporter(testmanagerd.NewJSONReporter(file))
+		}
+		if allureDir, err := arguments.String("--allure"); err == nil {
+			var labels []testmanagerd.AllureLabel
+			values, err := ios.GetValues(device)
 			if err != nil {
-				log.WithFields(log.Fields{"error": err}).Info("Failed running Xcuitest")
+				log.WithError(err).Warn("failed reading device values, allure results are not labeled with the device")
+			} else {
+				labels = testmanagerd.AllureDeviceLabels(values.Value.ProductType, values.Value.ProductVersion, values.Value.DeviceName)
 			}
+			listener.AddReporter(testmanagerd.NewAllureReporter(allureDir, labels...))
+		}
 
+		retries := 0
+		if retriesArg, err := arguments.String("--retries"); err == nil {
+			retries, err = strconv.Atoi(retriesArg)
+			exitIfError("--retries must be a number", err)
+		}
+
+		opts := []testmanagerd.TestOption{testmanagerd.WithTestsToRun(testsToRun), testmanagerd.WithTestsToSkip(testsToSkip), testmanagerd.WithRetries(retries)}
+		if energy, _ := arguments.Bool("--energy"); energy {
+			opts = append(opts, testmanagerd.WithEnergyProfiling())
+		}
+
+		testResults, err := testmanagerd.RunXCUITestWithOptions(context.TODO(), bundleID, testRunnerBundleId, xctestConfig, device, nil, env, listener, isXCTest, opts...)
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Info("Failed running Xcuitest")
+		}
+		if rawTestlogErr == nil {
 			log.Info(fmt.Printf("%+v", testResults))
-		} else {
-			_, err := testmanagerd.RunXCUITest(bundleID, testRunnerBundleId, xctestConfig, device, env, testsToRun, testsToSkip, testmanagerd.NewTestListener(io.Discard, io.Discard, os.TempDir()), isXCTest)
-			if err != nil {
-				log.WithFields(log.Fields{"error": err}).Info("Failed running Xcuitest")
-			}
 		}
 		return
 	}
@@ -975,8 +1068,20 @@ The commands work as following:
 
 	b, _ = arguments.Bool("fsync")
 	if b {
+		b, _ = arguments.Bool("mount")
+		if b {
+			mountpoint, _ := arguments.String("--mountpoint")
+			bundleID, _ := arguments.String("--app")
+			readOnly, _ := arguments.Bool("--readonly")
+			mountDeviceFiles(device, mountpoint, bundleID, readOnly)
+			return
+		}
 		afcService, err := afc.New(device)
 		exitIfError("fsync: connect afc service failed", err)
+		if limit, _ := arguments.Int("--limit"); limit > 0 {
+			err = ratelimit.SetLimits(ratelimit.Limits{PerTransfer: int64(limit)})
+			exitIfError("fsync: invalid limit", err)
+		}
 		b, _ = arguments.Bool("rm")
 		if b {
 			path, _ := arguments.String("--path")
@@ -1146,19 +1251,22 @@ func imageCommand1(device ios.DeviceEntry, arguments docopt.Opts) bool {
 				basedir = "./devimages"
 			}
 
-			var err error
-			path, err = imagemounter.DownloadImageFor(device, basedir)
+			result, err := imagemounter.EnsureMountedIn(device, basedir)
 			if err != nil {
 				log.WithFields(log.Fields{"basedir": basedir, "udid": device.Properties.SerialNumber, "err": err}).
-					Error("failed downloading image")
+					Error("failed mounting image")
 				return false
 			}
-
-			log.WithFields(log.Fields{"basedir": basedir, "udid": device.Properties.SerialNumber}).Info("success downloaded image")
+			if result.AlreadyMounted {
+				log.WithFields(log.Fields{"udid": device.Properties.SerialNumber}).Info("an image is mounted already")
+			} else {
+				log.WithFields(log.Fields{"image": result.Path, "udid": device.Properties.SerialNumber}).Info("success mounting image")
+			}
+			return true
 		}
 
 		mount, _ := arguments.Bool("mount")
-		if mount || auto {
+		if mount {
 			err := imagemounter.MountImage(device, path)
 			if err != nil {
 				log.WithFields(log.Fields{"image": path, "udid": device.Properties.SerialNumber, "err": err}).
@@ -1182,6 +1290,17 @@ func imageCommand1(device ios.DeviceEntry, arguments docopt.Opts) bool {
 	return b
 }
 
+// testTemplateVars creates the values for the template variables supported in test args and env.
+// The WDA port is taken from a USE_PORT env var if present, the job id is unique for each invocation.
+func testTemplateVars(device ios.DeviceEntry, env []string) testmanagerd.TemplateVars {
+	return testmanagerd.TemplateVars{
+		UDID:        device.Properties.SerialNumber,
+		WdaPort:     testmanagerd.WdaPortFromEnv(env),
+		ArtifactDir: os.TempDir(),
+		JobID:       uuid.New().String(),
+	}
+}
+
 func runWdaCommand(device ios.DeviceEntry, arguments docopt.Opts) bool {
 	b, _ := arguments.Bool("runwda")
 	if b {
@@ -1190,6 +1309,9 @@ func runWdaCommand(device ios.DeviceEntry, arguments docopt.Opts) bool {
 		xctestconfig, _ := arguments.String("--xctestconfig")
 		wdaargs := arguments["--arg"].([]string)
 		wdaenv := arguments["--env"].([]string)
+		vars := testTemplateVars(device, wdaenv)
+		wdaargs = vars.Resolve(wdaargs)
+		wdaenv = vars.Resolve(wdaenv)
 
 		if bundleID == "" && testbundleID == "" && xctestconfig == "" {
 			log.Info("no bundle ids specified, falling back to defaults")
@@ -1690,7 +1812,7 @@ func handleProfileAddSupervised(device ios.DeviceEntry, file string, p12file str
 	exitIfError("Starting mcInstall failed with", err)
 	filebytes, err := os.ReadFile(file)
 	exitIfError("could not read profile-file", err)
-	p12bytes, err := os.ReadFile(p12file)
+	p12bytes, err := readSecretFile(p12file)
 	exitIfError("could not read p12-file", err)
 	err = profileService.AddProfileSupervised(filebytes, p12bytes, p12password)
 	exitIfError("failed adding profile", err)
@@ -1721,6 +1843,35 @@ func stopForwarding(cl *forward.ConnListener) {
 	}
 }
 
+func mountDeviceFiles(device ios.DeviceEntry, mountpoint string, bundleID string, readOnly bool) {
+	var conn *afc.Connection
+	var err error
+	name := "media"
+	if bundleID == "" {
+		conn, err = afc.New(device)
+	} else {
+		conn, err = house_arrest.NewAFC(device, bundleID)
+		name = bundleID
+	}
+	exitIfError("fsync: connect afc service failed", err)
+	defer conn.Close()
+	server, err := afcfuse.Mount(conn, mountpoint, afcfuse.Options{Name: device.Properties.SerialNumber + ":" + name, ReadOnly: readOnly})
+	exitIfError("fsync: mount failed", err)
+	log.WithFields(log.Fields{"mountpoint": mountpoint, "files": name}).Info("mounted, press ctrl+c to unmount")
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range c {
+			err := server.Unmount()
+			if err == nil {
+				return
+			}
+			log.WithError(err).Error("fsync: unmount failed, close the files in the mountpoint and press ctrl+c again")
+		}
+	}()
+	server.Wait()
+}
+
 func printDiagnostics(device ios.DeviceEntry) {
 	log.Debug("print diagnostics")
 	diagnosticsService, err := diagnostics.New(device)
@@ -1732,6 +1883,35 @@ func printDiagnostics(device ios.DeviceEntry) {
 	fmt.Println(convertToJSONString(values))
 }
 
+func probeServices(device ios.DeviceEntry, services []string) {
+	if len(services) == 0 {
+		services = ios.KnownLockdownServices
+	}
+	probes, err := ios.ProbeServices(device, services)
+	exitIfError("probing services failed", err)
+	if JSONdisabled {
+		for _, probe := range probes {
+			fmt.Printf("%s\t%s\t%s\n", probe.Service, probe.Availability, probe.Reason)
+		}
+		return
+	}
+	fmt.Println(convertToJSONString(probes))
+}
+
+func runHealthcheck(device ios.DeviceEntry) {
+	report := healthcheck.Run(context.Background(), device, healthcheck.DefaultChecks(), healthcheck.DefaultTimeout)
+	if JSONdisabled {
+		for _, result := range report.Results {
+			fmt.Printf("%s\t%s\t%dms\t%s%s\n", result.Check, result.Status, result.DurationMs, result.Details, result.Error)
+		}
+	} else {
+		fmt.Println(convertToJSONString(report))
+	}
+	if !report.Healthy {
+		os.Exit(1)
+	}
+}
+
 func printBatteryDiagnostics(device ios.DeviceEntry) {
 	battery, err := ios.GetBatteryDiagnostics(device)
 	exitIfError("failed getting battery diagnostics", err)
@@ -1838,29 +2018,6 @@ func setLocationGPX(device ios.DeviceEntry, gpxFilePath string) {
 	exitIfError("Setting location failed with", err)
 }
 
-func startLocationSimulation(service *instruments.LocationSimulationService, lat string, lon string) {
-	latitude, err := strconv.ParseFloat(lat, 64)
-	exitIfError("location simulation failed to parse lat", err)
-
-	longitude, err := strconv.ParseFloat(lon, 64)
-	exitIfError("location simulation failed to parse lon", err)
-
-	err = service.StartSimulateLocation(latitude, longitude)
-	exitIfError("location simulation failed to start with", err)
-
-	defer stopLocationSimulation(service)
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
-}
-
-func stopLocationSimulation(service *instruments.LocationSimulationService) {
-	err := service.StopSimulateLocation()
-	if err != nil {
-		exitIfError("location simulation failed to stop with", err)
-	}
-}
-
 func resetLocation(device ios.DeviceEntry) {
 	err := simlocation.ResetLocation(device)
 	exitIfError("Resetting location failed with", err)
@@ -2071,6 +2228,64 @@ func runSyslog(device ios.DeviceEntry) {
 	<-c
 }
 
+// readSecretFile reads files like p12s that can be sealed with 'ios secrets seal'
+func readSecretFile(path string) ([]byte, error) {
+	keys, err := secrets.ProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return secrets.ReadFile(keys, path)
+}
+
+// secretEnv reads environment variables that can contain values sealed with 'ios secrets seal-value'
+func secretEnv(name string) string {
+	keys, err := secrets.ProviderFromEnv()
+	exitIfError("invalid "+secrets.KMSEnvVar, err)
+	value, err := secrets.OpenString(keys, os.Getenv(name))
+	exitIfError("failed opening "+name, err)
+	return value
+}
+
+func handleSecrets(arguments docopt.Opts) {
+	b, _ := arguments.Bool("keygen")
+	if b {
+		keyfile, _ := arguments.String("<keyfile>")
+		exitIfError("failed creating key file", secrets.GenerateKeyFile(keyfile))
+		log.Infof("created %s, use it with %s=local:%s", keyfile, secrets.KMSEnvVar, keyfile)
+		return
+	}
+	keys, err := secrets.ProviderFromEnv()
+	exitIfError("invalid "+secrets.KMSEnvVar, err)
+	if keys == nil {
+		exitIfError("no key provider", fmt.Errorf("set %s to local:<keyfile>, awskms:<key arn> or vault:<transit key>", secrets.KMSEnvVar))
+	}
+	b, _ = arguments.Bool("seal-value")
+	if b {
+		value, _ := arguments.String("<value>")
+		sealed, err := secrets.SealString(keys, value)
+		exitIfError("failed sealing value", err)
+		fmt.Println(sealed)
+		return
+	}
+	input, _ := arguments.String("<input>")
+	output, _ := arguments.String("<output>")
+	content, err := os.ReadFile(input)
+	exitIfError("failed reading "+input, err)
+	b, _ = arguments.Bool("seal")
+	if b {
+		if secrets.IsSealed(content) {
+			exitIfError("failed sealing", fmt.Errorf("%s is sealed already", input))
+		}
+		exitIfError("failed sealing", secrets.WriteFile(keys, output, content, 0o600))
+		log.Infof("sealed %s to %s", input, output)
+		return
+	}
+	plaintext, err := secrets.Open(keys, content)
+	exitIfError("failed opening "+input, err)
+	exitIfError("failed writing "+output, os.WriteFile(output, plaintext, 0o600))
+	log.Infof("opened %s to %s", input, output)
+}
+
 func pairDevice(device ios.DeviceEntry, orgIdentityP12File string, p12Password string) {
 	if orgIdentityP12File == "" {
 		err := ios.Pair(device)
@@ -2078,7 +2293,7 @@ func pairDevice(device ios.DeviceEntry, orgIdentityP12File string, p12Password s
 		log.Infof("Successfully paired %s", device.Properties.SerialNumber)
 		return
 	}
-	p12, err := os.ReadFile(orgIdentityP12File)
+	p12, err := readSecretFile(orgIdentityP12File)
 	exitIfError("Invalid file:"+orgIdentityP12File, err)
 	err = ios.PairSupervised(device, p12, p12Password)
 	exitIfError("Pairing failed", err)
@@ -2086,7 +2301,9 @@ func pairDevice(device ios.DeviceEntry, orgIdentityP12File string, p12Password s
 }
 
 func startTunnel(ctx context.Context, recordsPath string, tunnelInfoPort int, userspaceTUN bool) {
-	pm, err := tunnel.NewPairRecordManager(recordsPath)
+	keys, err := secrets.ProviderFromEnv()
+	exitIfError("invalid "+secrets.KMSEnvVar, err)
+	pm, err := tunnel.NewPairRecordManagerWithKeys(recordsPath, keys)
 	exitIfError("could not creat pair record manager", err)
 	tm := tunnel.NewTunnelManager(pm, userspaceTUN)
 
@@ -0,0 +1,69 @@
+package ncm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// usbDevice is an Apple device opened by a usbBackend
+type usbDevice interface {
+	String() string
+	SerialNumber() (string, error)
+	ActiveConfig() (int, error)
+	// Configs are the configurations the device had when it was opened
+	Configs() []usbConfig
+	Control(requestType uint8, request uint8, value uint16, index uint16, data []byte) (int, error)
+	// OpenNCM activates the ncm configuration, claims the interface and opens a stream on its endpoints
+	OpenNCM(iface usbInterface, in usbEndpoint, out usbEndpoint) (io.ReadWriteCloser, error)
+	Close() error
+}
+
+// usbBackend accesses usb devices, either through libusb or directly through the usbfs of the linux kernel
+type usbBackend interface {
+	// OpenDevices opens all devices for which match returns true
+	OpenDevices(match func(vendor uint16, product uint16) bool) ([]usbDevice, error)
+	Close() error
+}
+
+// usbBackends are the backends compiled into the binary. libusb needs cgo and is only included with the libusb
+// build tag, usbfs is pure go and always available on linux.
+var usbBackends = map[string]func() (usbBackend, error){}
+
+// BackendAuto picks libusb if it is compiled in and usbfs otherwise
+const BackendAuto = "auto"
+
+// Backends lists the usb backends compiled into the binary
+func Backends() []string {
+	var names []string
+	for name := range usbBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func openBackend(name string) (usbBackend, error) {
+	if name == "" || name == BackendAuto {
+		name = "usbfs"
+		if _, ok := usbBackends["libusb"]; ok {
+			name = "libusb"
+		}
+	}
+	open, ok := usbBackends[name]
+	if !ok {
+		return nil, fmt.Errorf("openBackend: usb backend '%s' is not available, this binary supports %v", name, Backends())
+	}
+	return open()
+}
+
+// isNCMCandidate reports whether the device is an iOS device that can have an ncm interface
+func isNCMCandidate(vendor uint16, product uint16) bool {
+	if vendor != VID_APPLE {
+		return false
+	}
+	if product == PID_APPLE_T2_COPROCESSOR {
+		return false
+	}
+	return product >= PID_RANGE_LOW && product <= PID_RANGE_MAX
+}
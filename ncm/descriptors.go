@@ -0,0 +1,102 @@
+package ncm
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	descriptorTypeDevice    = 1
+	descriptorTypeConfig    = 2
+	descriptorTypeInterface = 4
+	descriptorTypeEndpoint  = 5
+
+	// ncmConfig is the configuration of Apple devices with the ncm network interface, it is enabled by usbmuxd
+	ncmConfig = 5
+)
+
+// usbConfig is a configuration of a usb device as described by its descriptors
+type usbConfig struct {
+	Value      int
+	Interfaces []usbInterface
+}
+
+// usbInterface is an alternate setting of an interface
+type usbInterface struct {
+	Number    int
+	Alternate int
+	Class     int
+	SubClass  int
+	Endpoints []usbEndpoint
+}
+
+// usbEndpoint is an endpoint of an interface, its direction is in the highest bit of the address
+type usbEndpoint struct {
+	Address       uint8
+	MaxPacketSize int
+}
+
+func (e usbEndpoint) In() bool {
+	return e.Address&0x80 != 0
+}
+
+// ncmInterface finds the ncm data interface in the configs and returns it with its in and out endpoint
+func ncmInterface(configs []usbConfig) (usbInterface, usbEndpoint, usbEndpoint, error) {
+	for _, config := range configs {
+		if config.Value != ncmConfig {
+			continue
+		}
+		for _, iface := range config.Interfaces {
+			if iface.Class != 10 || iface.SubClass != 0 || len(iface.Endpoints) != 2 {
+				continue
+			}
+			in, out := iface.Endpoints[0], iface.Endpoints[1]
+			if out.In() {
+				in, out = out, in
+			}
+			if !in.In() || out.In() {
+				return usbInterface{}, usbEndpoint{}, usbEndpoint{}, fmt.Errorf("ncmInterface: interface %d needs an in and an out endpoint", iface.Number)
+			}
+			return iface, in, out, nil
+		}
+	}
+	return usbInterface{}, usbEndpoint{}, usbEndpoint{}, fmt.Errorf("ncmInterface: could not find interface or altsetting")
+}
+
+// parseDescriptors parses the descriptors of a usb device as the linux kernel provides them in
+// /dev/bus/usb/<bus>/<device>: the device descriptor followed by all configuration descriptors.
+func parseDescriptors(raw []byte) ([]usbConfig, error) {
+	var configs []usbConfig
+	var config *usbConfig
+	var iface *usbInterface
+	for len(raw) > 0 {
+		length := int(raw[0])
+		if length < 2 || length > len(raw) {
+			return nil, fmt.Errorf("parseDescriptors: invalid descriptor length %d", length)
+		}
+		d := raw[:length]
+		raw = raw[length:]
+		switch d[1] {
+		case descriptorTypeDevice:
+		case descriptorTypeConfig:
+			if length < 9 {
+				return nil, fmt.Errorf("parseDescriptors: config descriptor too short")
+			}
+			configs = append(configs, usbConfig{Value: int(d[5])})
+			config, iface = &configs[len(configs)-1], nil
+		case descriptorTypeInterface:
+			if length < 9 || config == nil {
+				return nil, fmt.Errorf("parseDescriptors: unexpected interface descriptor")
+			}
+			config.Interfaces = append(config.Interfaces, usbInterface{Number: int(d[2]), Alternate: int(d[3]), Class: int(d[5]), SubClass: int(d[6])})
+			iface = &config.Interfaces[len(config.Interfaces)-1]
+		case descriptorTypeEndpoint:
+			if length < 7 || iface == nil {
+				return nil, fmt.Errorf("parseDescriptors: unexpected endpoint descriptor")
+			}
+			// the upper bits of wMaxPacketSize are the additional transactions per microframe
+			iface.Endpoints = append(iface.Endpoints, usbEndpoint{Address: d[2], MaxPacketSize: int(binary.LittleEndian.Uint16(d[4:6]) & 0x7ff)})
+		}
+	}
+	return configs, nil
+}
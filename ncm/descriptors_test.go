@@ -0,0 +1,56 @@
+package ncm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDescriptors(t *testing.T) {
+	raw := []byte{
+		// device
+		18, descriptorTypeDevice, 0x00, 0x02, 0, 0, 0, 64, 0xac, 0x05, 0xa8, 0x12, 0x00, 0x15, 1, 2, 3, 5,
+		// config 1 with the usbmux interface
+		9, descriptorTypeConfig, 32, 0, 1, 1, 0, 0xc0, 250,
+		9, descriptorTypeInterface, 0, 0, 2, 0xff, 0xfe, 2, 0,
+		7, descriptorTypeEndpoint, 0x04, 2, 0x00, 0x02, 0,
+		7, descriptorTypeEndpoint, 0x85, 2, 0x00, 0x02, 0,
+		// config 5 with the ncm control interface, a class specific descriptor and the data interface
+		9, descriptorTypeConfig, 41, 0, 2, 5, 0, 0xc0, 250,
+		9, descriptorTypeInterface, 1, 0, 1, 2, 13, 0, 0,
+		5, 0x24, 0, 0x10, 0x01,
+		9, descriptorTypeInterface, 2, 0, 0, 10, 0, 1, 0,
+		9, descriptorTypeInterface, 2, 1, 2, 10, 0, 1, 0,
+		7, descriptorTypeEndpoint, 0x86, 2, 0x00, 0x02, 0,
+		7, descriptorTypeEndpoint, 0x05, 2, 0x00, 0x02, 0,
+	}
+	configs, err := parseDescriptors(raw)
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, 1, configs[0].Value)
+	assert.Equal(t, 5, configs[1].Value)
+	require.Len(t, configs[1].Interfaces, 3)
+
+	iface, in, out, err := ncmInterface(configs)
+	require.NoError(t, err)
+	assert.Equal(t, 2, iface.Number)
+	assert.Equal(t, 1, iface.Alternate)
+	assert.Equal(t, usbEndpoint{Address: 0x86, MaxPacketSize: 512}, in)
+	assert.Equal(t, usbEndpoint{Address: 0x05, MaxPacketSize: 512}, out)
+
+	_, _, _, err = ncmInterface(configs[:1])
+	assert.Error(t, err, "only config 5 has the ncm interface")
+
+	_, err = parseDescriptors(raw[:len(raw)-3])
+	assert.Error(t, err)
+}
+
+func TestOpenBackend(t *testing.T) {
+	assert.Contains(t, Backends(), "usbfs")
+	_, err := openBackend("unknown")
+	assert.Error(t, err)
+	backend, err := openBackend(BackendAuto)
+	require.NoError(t, err)
+	assert.NoError(t, backend.Close())
+}
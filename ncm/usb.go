@@ -11,7 +11,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/google/gousb"
 	"github.com/songgao/packets/ethernet"
 	"github.com/songgao/water"
 )
@@ -28,13 +27,22 @@ var serialToInterface = map[string]string{}
 var deviceLock = sync.Mutex{}
 var deviceCounter = 0
 
+// Start looks for devices with the usb backend picked by BackendAuto until c receives a signal
 func Start(c chan os.Signal) error {
-	ctx := gousb.NewContext()
-	defer ctx.Close()
+	return StartWithBackend(c, BackendAuto)
+}
+
+// StartWithBackend looks for devices with the named usb backend, see Backends, until c receives a signal
+func StartWithBackend(c chan os.Signal, backendName string) error {
+	backend, err := openBackend(backendName)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
 	for {
 		select {
 		case <-time.After(5 * time.Second):
-			checkDevices(ctx)
+			checkDevices(backend)
 			printStatus()
 		case <-c:
 			slog.Info("shut down complete")
@@ -54,25 +62,14 @@ func printStatus() {
 	slog.Debug("connected devices", "devices", connectedDevices)
 }
 
-func checkDevices(ctx *gousb.Context) {
-	devices, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
-		if desc.Vendor != VID_APPLE {
-			return false
-		}
-		if desc.Product == PID_APPLE_T2_COPROCESSOR {
-			return false
-		}
-		if desc.Product < PID_RANGE_LOW || desc.Product > PID_RANGE_MAX {
-			return false
-		}
-		return true
-	})
+func checkDevices(backend usbBackend) {
+	devices, err := backend.OpenDevices(isNCMCandidate)
 	if err != nil {
 		slog.Error("failed opening devices", "err", err)
 	}
 	slog.Debug("device list", "length", len(devices))
 	for _, d := range devices {
-		go func(d *gousb.Device) {
+		go func(d usbDevice) {
 			err := handleDevice(d)
 			if err != nil {
 				slog.Error("failed opening network adapter for device", "device", d.String(), "err", err)
@@ -104,7 +101,7 @@ func interfaceName(serial string) string {
 // USBMUXD should make sure they are already enabled. Without doing anything devices usually have 4.
 // The 5th one should be the ncm driver. Then finds endpoints for the ncm network device, starts a virtual
 // TAP network device and starts a read/write loop to send data from virtual network to USB and back
-func handleDevice(device *gousb.Device) error {
+func handleDevice(device usbDevice) error {
 	defer closeWithLog("device "+device.String(), device.Close)
 	serial, err := device.SerialNumber()
 	if err != nil {
@@ -121,13 +118,13 @@ func handleDevice(device *gousb.Device) error {
 	defer allocatedDevices.Delete(serial)
 	slog.Info("got device", slog.String("serial", serial))
 
-	activeConfig, err := device.ActiveConfigNum()
+	activeConfig, err := device.ActiveConfig()
 	if err != nil {
 		return fmt.Errorf("handleDevice: failed to get active config for device %s with err %w", serial, err)
 	}
 	slog.Info("active config", slog.Int("active", activeConfig), "serial", serial)
-	confLen := len(device.Desc.Configs)
-	slog.Info("available configs", "configs", device.Desc.Configs, "len", confLen, "serial", serial)
+	confLen := len(device.Configs())
+	slog.Info("available configs", "configs", device.Configs(), "len", confLen, "serial", serial)
 
 	if confLen != 5 {
 		_, err = device.Control(0xc0, 69, 0, 0, make([]byte, 4))
@@ -143,74 +140,18 @@ func handleDevice(device *gousb.Device) error {
 		}
 	}
 
-	cfg, err := device.Config(5)
+	iface, in, out, err := ncmInterface(device.Configs())
 	if err != nil {
-		return fmt.Errorf("handleDevice: failed activating config for device %s with err %w", serial, err)
+		return fmt.Errorf("handleDevice: %w", err)
 	}
-	defer closeWithLog("config "+serial, cfg.Close)
-	slog.Info("got config", slog.String("config", cfg.String()), "serial", serial)
-
-	var ifNumber = -1
-	var altS = -1
-	for _, iface := range cfg.Desc.Interfaces {
-		for _, alt := range iface.AltSettings {
-			if alt.Class == 10 && alt.SubClass == 0 && len(alt.Endpoints) == 2 {
-				slog.Info("alt setting", slog.String("alt", alt.String()), slog.Int("class", int(alt.Class)), slog.Int("subclass", int(alt.SubClass)), slog.String("protocol", alt.Protocol.String()), slog.String("serial", serial))
-				ifNumber = iface.Number
-				altS = alt.Alternate
-			}
-		}
-	}
-	if ifNumber == -1 || altS == -1 {
-		return fmt.Errorf("handleDevice: could not find interface or altsetting")
-	}
-	iface, err := cfg.Interface(ifNumber, altS)
+	slog.Info("alt setting", slog.Int("interface", iface.Number), slog.Int("alt", iface.Alternate), slog.String("serial", serial))
+	stream, err := device.OpenNCM(iface, in, out)
 	if err != nil {
 		return fmt.Errorf("handleDevice: failed to claim interface for device %s. this can happen if some other process already claimed it. err %w", serial, err)
 	}
-	defer func() {
-		slog.Info("closing interface", "serial", serial)
-		iface.Close()
-	}()
-	var inEndpoint = -1
-	var outEndpoint = -1
-	var inDesc gousb.EndpointDesc
-	for endpoint, i := range iface.Setting.Endpoints {
-		slog.Info(endpoint.String())
-		if i.Direction == gousb.EndpointDirectionIn {
-			inEndpoint = i.Number
-			inDesc = i
-		}
-		if i.Direction == gousb.EndpointDirectionOut {
-			outEndpoint = i.Number
-		}
-	}
-	if inEndpoint == -1 {
-		return fmt.Errorf("handleDevice: failed to find in-endpoint for device %s", serial)
-	}
-	if outEndpoint == -1 {
-		return fmt.Errorf("handleDevice: failed to find out-endpoint for device %s", serial)
-	}
-
-	in, err := iface.InEndpoint(inEndpoint)
-	if err != nil {
-		return fmt.Errorf("handleDevice: failed to open in-endpoint for device %s with err %w", serial, err)
-	}
-
-	out, err := iface.OutEndpoint(outEndpoint)
-	if err != nil {
-		return fmt.Errorf("handleDevice: failed to open out-endpoint for device %s with err %w", serial, err)
-	}
+	defer closeWithLog("ncm stream "+serial, stream.Close)
 	slog.Info("claimed interfaces", "serial", serial)
 
-	inStream, err := in.NewStream(inDesc.MaxPacketSize*3, 1)
-	if err != nil {
-		return fmt.Errorf("handleDevice: failed to open in-stream for device %s with err %w", serial, err)
-	}
-	defer closeWithLog("in stream", inStream.Close)
-
-	slog.Info("created streams", "serial", serial)
-
 	ifce, err := createConfig(serial)
 	if err != nil {
 		return fmt.Errorf("handleDevice: failed to create config for device %s with err %w", serial, err)
@@ -218,7 +159,7 @@ func handleDevice(device *gousb.Device) error {
 	defer closeWithLog("virtual TAP interface", ifce.Close)
 
 	//blocks until the device disconnects or the adapter fails for some reason
-	err = ncmIOCopy(out, inStream, ifce, serial)
+	err = ncmIOCopy(stream, stream, ifce, serial)
 	slog.Info("stopping interface for device", "serial", serial)
 	if err != nil {
 		slog.Error("failed to copy data", "err", err, "serial", serial)
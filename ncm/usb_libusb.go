@@ -0,0 +1,119 @@
+//go:build libusb
+
+package ncm
+
+import (
+	"errors"
+	"io"
+
+	"github.com/google/gousb"
+)
+
+func init() {
+	usbBackends["libusb"] = func() (usbBackend, error) {
+		return &libusbBackend{ctx: gousb.NewContext()}, nil
+	}
+}
+
+// libusbBackend accesses devices with libusb, it needs cgo
+type libusbBackend struct {
+	ctx *gousb.Context
+}
+
+func (b *libusbBackend) OpenDevices(match func(vendor uint16, product uint16) bool) ([]usbDevice, error) {
+	devices, err := b.ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		return match(uint16(desc.Vendor), uint16(desc.Product))
+	})
+	result := make([]usbDevice, len(devices))
+	for i, d := range devices {
+		result[i] = libusbDevice{d}
+	}
+	return result, err
+}
+
+func (b *libusbBackend) Close() error {
+	return b.ctx.Close()
+}
+
+type libusbDevice struct {
+	*gousb.Device
+}
+
+func (d libusbDevice) ActiveConfig() (int, error) {
+	return d.ActiveConfigNum()
+}
+
+func (d libusbDevice) Configs() []usbConfig {
+	var configs []usbConfig
+	for _, cfg := range d.Desc.Configs {
+		config := usbConfig{Value: cfg.Number}
+		for _, iface := range cfg.Interfaces {
+			for _, alt := range iface.AltSettings {
+				setting := usbInterface{Number: alt.Number, Alternate: alt.Alternate, Class: int(alt.Class), SubClass: int(alt.SubClass)}
+				for _, endpoint := range alt.Endpoints {
+					setting.Endpoints = append(setting.Endpoints, usbEndpoint{Address: uint8(endpoint.Address), MaxPacketSize: endpoint.MaxPacketSize})
+				}
+				config.Interfaces = append(config.Interfaces, setting)
+			}
+		}
+		configs = append(configs, config)
+	}
+	return configs
+}
+
+func (d libusbDevice) Control(requestType uint8, request uint8, value uint16, index uint16, data []byte) (int, error) {
+	return d.Device.Control(requestType, request, value, index, data)
+}
+
+func (d libusbDevice) OpenNCM(setting usbInterface, in usbEndpoint, out usbEndpoint) (io.ReadWriteCloser, error) {
+	cfg, err := d.Config(ncmConfig)
+	if err != nil {
+		return nil, err
+	}
+	iface, err := cfg.Interface(setting.Number, setting.Alternate)
+	if err != nil {
+		cfg.Close()
+		return nil, err
+	}
+	stream := &libusbStream{cfg: cfg, iface: iface}
+	inEndpoint, err := iface.InEndpoint(int(in.Address & 0x0f))
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	stream.out, err = iface.OutEndpoint(int(out.Address & 0x0f))
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	stream.in, err = inEndpoint.NewStream(in.MaxPacketSize*3, 1)
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+type libusbStream struct {
+	cfg   *gousb.Config
+	iface *gousb.Interface
+	in    *gousb.ReadStream
+	out   *gousb.OutEndpoint
+}
+
+func (s *libusbStream) Read(p []byte) (int, error) {
+	return s.in.Read(p)
+}
+
+func (s *libusbStream) Write(p []byte) (int, error) {
+	return s.out.Write(p)
+}
+
+func (s *libusbStream) Close() error {
+	var err error
+	if s.in != nil {
+		err = s.in.Close()
+	}
+	s.iface.Close()
+	return errors.Join(err, s.cfg.Close())
+}
@@ -0,0 +1,293 @@
+package ncm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	usbBackends["usbfs"] = func() (usbBackend, error) {
+		return &usbfsBackend{sysfs: "/sys/bus/usb/devices", devfs: "/dev/bus/usb"}, nil
+	}
+}
+
+// usbfs ioctls from linux/usbdevice_fs.h
+var (
+	usbdevfsControl          = ioctlNumber(3, 0, unsafe.Sizeof(usbdevfsCtrlTransfer{}))
+	usbdevfsBulk             = ioctlNumber(3, 2, unsafe.Sizeof(usbdevfsBulkTransfer{}))
+	usbdevfsSetInterface     = ioctlNumber(2, 4, unsafe.Sizeof(usbdevfsSetInterfaceArgs{}))
+	usbdevfsSetConfiguration = ioctlNumber(2, 5, unsafe.Sizeof(uint32(0)))
+	usbdevfsClaimInterface   = ioctlNumber(2, 15, unsafe.Sizeof(uint32(0)))
+	usbdevfsReleaseInterface = ioctlNumber(2, 16, unsafe.Sizeof(uint32(0)))
+)
+
+// ioctlNumber builds the number of a 'U' ioctl like the _IOR and _IOWR macros, dir is 2 for read and 3 for read/write
+func ioctlNumber(dir uintptr, nr uintptr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 'U'<<8 | nr
+}
+
+type usbdevfsCtrlTransfer struct {
+	RequestType uint8
+	Request     uint8
+	Value       uint16
+	Index       uint16
+	Length      uint16
+	Timeout     uint32
+	Data        unsafe.Pointer
+}
+
+type usbdevfsBulkTransfer struct {
+	Endpoint uint32
+	Length   uint32
+	Timeout  uint32
+	Data     unsafe.Pointer
+}
+
+type usbdevfsSetInterfaceArgs struct {
+	Interface  uint32
+	AltSetting uint32
+}
+
+// controlTimeoutMs is the timeout of control transfers, bulk transfers have none
+const controlTimeoutMs = 1000
+
+// usbfsBackend accesses devices through the usbfs of the linux kernel. It is pure go, so it works in static
+// binaries, and finds devices in sysfs.
+type usbfsBackend struct {
+	sysfs string
+	devfs string
+}
+
+func (b *usbfsBackend) OpenDevices(match func(vendor uint16, product uint16) bool) ([]usbDevice, error) {
+	entries, err := os.ReadDir(b.sysfs)
+	if err != nil {
+		return nil, fmt.Errorf("OpenDevices: %w", err)
+	}
+	var devices []usbDevice
+	var errs []error
+	for _, entry := range entries {
+		// interfaces are listed as well, they contain a ':'
+		if strings.Contains(entry.Name(), ":") {
+			continue
+		}
+		dir := filepath.Join(b.sysfs, entry.Name())
+		vendor, err := readSysfsUint(dir, "idVendor", 16)
+		if err != nil {
+			continue
+		}
+		product, err := readSysfsUint(dir, "idProduct", 16)
+		if err != nil || !match(uint16(vendor), uint16(product)) {
+			continue
+		}
+		device, err := b.open(dir)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		devices = append(devices, device)
+	}
+	return devices, errors.Join(errs...)
+}
+
+func (b *usbfsBackend) open(sysfsDir string) (*usbfsDevice, error) {
+	bus, err := readSysfsUint(sysfsDir, "busnum", 10)
+	if err != nil {
+		return nil, err
+	}
+	address, err := readSysfsUint(sysfsDir, "devnum", 10)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(b.devfs, fmt.Sprintf("%03d", bus), fmt.Sprintf("%03d", address))
+	// the fd stays blocking and out of the poller, usbfs transfers are synchronous ioctls
+	fd, err := unix.Open(path, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open: failed opening %s: %w", path, err)
+	}
+	f := os.NewFile(uintptr(fd), path)
+	descriptors, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open: failed reading descriptors of %s: %w", path, err)
+	}
+	configs, err := parseDescriptors(descriptors)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open: %s: %w", path, err)
+	}
+	return &usbfsDevice{file: f, sysfsDir: sysfsDir, name: fmt.Sprintf("bus %d address %d", bus, address), configs: configs}, nil
+}
+
+func (b *usbfsBackend) Close() error {
+	return nil
+}
+
+func readSysfs(dir string, name string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	return strings.TrimSpace(string(b)), err
+}
+
+func readSysfsUint(dir string, name string, base int) (uint64, error) {
+	s, err := readSysfs(dir, name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(s, base, 16)
+}
+
+type usbfsDevice struct {
+	file     *os.File
+	sysfsDir string
+	name     string
+	configs  []usbConfig
+}
+
+func (d *usbfsDevice) String() string {
+	return d.name
+}
+
+func (d *usbfsDevice) SerialNumber() (string, error) {
+	return readSysfs(d.sysfsDir, "serial")
+}
+
+func (d *usbfsDevice) ActiveConfig() (int, error) {
+	s, err := readSysfs(d.sysfsDir, "bConfigurationValue")
+	if err != nil {
+		return 0, err
+	}
+	if s == "" {
+		// the device is unconfigured
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func (d *usbfsDevice) Configs() []usbConfig {
+	return d.configs
+}
+
+func (d *usbfsDevice) ioctl(request uintptr, arg unsafe.Pointer) (int, error) {
+	conn, err := d.file.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var r uintptr
+	var errno unix.Errno
+	err = conn.Control(func(fd uintptr) {
+		r, _, errno = unix.Syscall(unix.SYS_IOCTL, fd, request, uintptr(arg))
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+func (d *usbfsDevice) Control(requestType uint8, request uint8, value uint16, index uint16, data []byte) (int, error) {
+	transfer := usbdevfsCtrlTransfer{RequestType: requestType, Request: request, Value: value, Index: index, Length: uint16(len(data)), Timeout: controlTimeoutMs}
+	if len(data) > 0 {
+		transfer.Data = unsafe.Pointer(&data[0])
+	}
+	n, err := d.ioctl(usbdevfsControl, unsafe.Pointer(&transfer))
+	if err != nil {
+		return 0, fmt.Errorf("Control: %w", err)
+	}
+	return n, nil
+}
+
+func (d *usbfsDevice) OpenNCM(iface usbInterface, in usbEndpoint, out usbEndpoint) (io.ReadWriteCloser, error) {
+	active, err := d.ActiveConfig()
+	if err != nil {
+		return nil, fmt.Errorf("OpenNCM: %w", err)
+	}
+	if active != ncmConfig {
+		config := uint32(ncmConfig)
+		_, err = d.ioctl(usbdevfsSetConfiguration, unsafe.Pointer(&config))
+		if err != nil {
+			return nil, fmt.Errorf("OpenNCM: failed activating config %d: %w", ncmConfig, err)
+		}
+	}
+	number := uint32(iface.Number)
+	_, err = d.ioctl(usbdevfsClaimInterface, unsafe.Pointer(&number))
+	if err != nil {
+		return nil, fmt.Errorf("OpenNCM: failed claiming interface %d: %w", iface.Number, err)
+	}
+	setting := usbdevfsSetInterfaceArgs{Interface: number, AltSetting: uint32(iface.Alternate)}
+	_, err = d.ioctl(usbdevfsSetInterface, unsafe.Pointer(&setting))
+	if err != nil {
+		d.ioctl(usbdevfsReleaseInterface, unsafe.Pointer(&number))
+		return nil, fmt.Errorf("OpenNCM: failed selecting alt setting %d: %w", iface.Alternate, err)
+	}
+	// reads use buffers of three packets like the libusb backend, but at least 16k so a transfer never overflows
+	size := in.MaxPacketSize * 3
+	if size < 16384 {
+		size = 16384
+	}
+	return &usbfsStream{device: d, iface: number, in: in.Address, out: out.Address, buf: make([]byte, size)}, nil
+}
+
+func (d *usbfsDevice) Close() error {
+	return d.file.Close()
+}
+
+// usbfsStream reads and writes with bulk transfers. Reads are buffered, so the ncm wrapper can read
+// headers and blocks of any size.
+type usbfsStream struct {
+	device  *usbfsDevice
+	iface   uint32
+	in, out uint8
+	readMu  sync.Mutex
+	buf     []byte
+	pending []byte
+	once    sync.Once
+}
+
+func (s *usbfsStream) bulk(endpoint uint8, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	transfer := usbdevfsBulkTransfer{Endpoint: uint32(endpoint), Length: uint32(len(p)), Data: unsafe.Pointer(&p[0])}
+	return s.device.ioctl(usbdevfsBulk, unsafe.Pointer(&transfer))
+}
+
+func (s *usbfsStream) Read(p []byte) (int, error) {
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	for len(s.pending) == 0 {
+		n, err := s.bulk(s.in, s.buf)
+		if err != nil {
+			return 0, fmt.Errorf("Read: %w", err)
+		}
+		s.pending = s.buf[:n]
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *usbfsStream) Write(p []byte) (int, error) {
+	n, err := s.bulk(s.out, p)
+	if err != nil {
+		return n, fmt.Errorf("Write: %w", err)
+	}
+	return n, nil
+}
+
+func (s *usbfsStream) Close() error {
+	var err error
+	s.once.Do(func() {
+		_, err = s.device.ioctl(usbdevfsReleaseInterface, unsafe.Pointer(&s.iface))
+	})
+	return err
+}
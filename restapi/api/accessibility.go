@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/accessibility"
+	"github.com/gin-gonic/gin"
+)
+
+// accessibilityAuditTimeout is how long the AX service gets to audit a screen, screens with long lists take a while
+const accessibilityAuditTimeout = time.Minute
+
+// AccessibilityAudit is the result of auditing the screen of a device
+type AccessibilityAudit struct {
+	// BundleID and Pid are the app the audit was restricted to, the whole screen is audited without them
+	BundleID string                     `json:"bundleId,omitempty"`
+	Pid      uint64                     `json:"pid,omitempty"`
+	Issues   []accessibility.AuditIssue `json:"issues"`
+	// Summary counts the issues per category
+	Summary map[string]int `json:"summary"`
+}
+
+// runAccessibilityAudit audits the screen with the AX service, restricted to the process if pid is not 0. Tests
+// replace it.
+var runAccessibilityAudit = func(device ios.DeviceEntry, pid uint64) ([]accessibility.AuditIssue, error) {
+	conn, err := accessibility.New(device)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = conn.SetAuditTarget(pid)
+	if err != nil {
+		return nil, err
+	}
+	return conn.Audit(accessibilityAuditTimeout)
+}
+
+// Audit the accessibility of the screen
+// @Summary      Run an accessibility audit
+// @Description  Audits the current screen with the accessibility service Accessibility Inspector uses and returns the issues it found, like elements without labels, text with too little contrast and too small touch targets. With bundleId only the elements of the running app are audited.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        bundleId query string false "only audit this app, it has to be running"
+// @Success      200 {object} AccessibilityAudit
+// @Failure      404 {object} GenericResponse
+// @Failure      409 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/accessibility/audit [post]
+func RunAccessibilityAudit(c *gin.Context) {
+	device := MustGetDevice(c)
+	audit := AccessibilityAudit{BundleID: c.Query("bundleId"), Summary: map[string]int{}}
+	if audit.BundleID != "" {
+		state, err := appState(c.Request.Context(), device, audit.BundleID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+			return
+		}
+		if !state.Installed {
+			c.JSON(http.StatusNotFound, GenericResponse{Error: "app is not installed"})
+			return
+		}
+		if !state.Running {
+			c.JSON(http.StatusConflict, GenericResponse{Error: "app is not running, launch it before auditing"})
+			return
+		}
+		audit.Pid = state.Pid
+	}
+	issues, err := runAccessibilityAudit(device, audit.Pid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	audit.Issues = issues
+	for _, issue := range issues {
+		audit.Summary[issue.Category]++
+	}
+	c.JSON(http.StatusOK, audit)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/accessibility"
+	"github.com/danielpaulus/go-ios/ios/installationproxy"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunAccessibilityAudit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	originalAudit, originalLookup, originalList := runAccessibilityAudit, lookupApp, listProcesses
+	t.Cleanup(func() { runAccessibilityAudit, lookupApp, listProcesses = originalAudit, originalLookup, originalList })
+	var auditedPid uint64
+	runAccessibilityAudit = func(device ios.DeviceEntry, pid uint64) ([]accessibility.AuditIssue, error) {
+		auditedPid = pid
+		return []accessibility.AuditIssue{
+			{Type: "testTypeContrast", Category: accessibility.CategoryContrast},
+			{Type: "testTypeHitRegion", Category: accessibility.CategoryTouchTarget, Rect: &accessibility.Rect{Width: 24, Height: 24}},
+			{Type: "testTypeHitRegion", Category: accessibility.CategoryTouchTarget},
+		}, nil
+	}
+	lookupApp = func(device ios.DeviceEntry, bundleID string) (installationproxy.AppInfo, bool, error) {
+		if bundleID == "com.example.missing" {
+			return installationproxy.AppInfo{}, false, nil
+		}
+		return installationproxy.AppInfo{CFBundleIdentifier: bundleID, CFBundleExecutable: "Example", Path: "/private/var/containers/Bundle/Application/" + bundleID + "/Example.app"}, true, nil
+	}
+	listProcesses = func(ctx context.Context, device ios.DeviceEntry, withMemory bool) ([]Process, error) {
+		return []Process{{Pid: 4711, Name: "Example", RealAppName: "/var/containers/Bundle/Application/com.example.app/Example.app/Example"}}, nil
+	}
+	r := gin.New()
+	r.POST("/device/:udid/accessibility/audit", func(c *gin.Context) {
+		c.Set(IOS_KEY, testDevice(c.Param("udid")))
+		c.Next()
+	}, RunAccessibilityAudit)
+	post := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/ax-udid/accessibility/audit"+query, nil))
+		return w
+	}
+
+	w := post("")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var audit AccessibilityAudit
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &audit))
+	assert.Len(t, audit.Issues, 3)
+	assert.Equal(t, map[string]int{accessibility.CategoryContrast: 1, accessibility.CategoryTouchTarget: 2}, audit.Summary)
+	assert.Equal(t, uint64(0), auditedPid, "the whole screen is audited")
+
+	w = post("?bundleId=com.example.app")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &audit))
+	assert.Equal(t, uint64(4711), audit.Pid)
+	assert.Equal(t, uint64(4711), auditedPid)
+
+	assert.Equal(t, http.StatusNotFound, post("?bundleId=com.example.missing").Code)
+	assert.Equal(t, http.StatusConflict, post("?bundleId=com.example.stopped").Code)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// adminTokenEnvVar configures the bearer token of the admin role. Admin endpoints are disabled if it is not set.
+const adminTokenEnvVar = "GO_IOS_ADMIN_TOKEN"
+
+// AdminMiddleware only lets requests with the admin token in the Authorization header pass.
+// Will return 403 if no admin token is configured and 401 if the token is missing or wrong.
+// If authentication is enabled, the admin scope is required instead.
+func AdminMiddleware() gin.HandlerFunc {
+	requireAdmin := RequireScope(ScopeAdmin)
+	return func(c *gin.Context) {
+		if authn.enabled() {
+			requireAdmin(c)
+			return
+		}
+		token, err := sealedSecrets.env(adminTokenEnvVar)
+		if err != nil {
+			log.WithError(err).Error("admin token can't be read")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, GenericResponse{Error: "admin token can't be read, check the server logs"})
+			return
+		}
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, GenericResponse{Error: "admin endpoints are disabled, set " + adminTokenEnvVar + " to enable them"})
+			return
+		}
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, GenericResponse{Error: "admin token missing or invalid"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// pprofRoutes serves the net/http/pprof profiles and expvar. The handlers of net/http/pprof expect to be
+// served at /debug/pprof/, so profiles are looked up by name instead of using pprof.Index for them.
+func pprofRoutes(router *gin.RouterGroup) {
+	router.GET("/pprof/", gin.WrapF(pprof.Index))
+	router.GET("/pprof/cmdline", gin.WrapF(pprof.Cmdline))
+	router.GET("/pprof/profile", gin.WrapF(pprof.Profile))
+	router.GET("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	router.POST("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	router.GET("/pprof/trace", gin.WrapF(pprof.Trace))
+	router.GET("/pprof/:profile", func(c *gin.Context) {
+		pprof.Handler(c.Param("profile")).ServeHTTP(c.Writer, c.Request)
+	})
+	router.GET("/vars", gin.WrapH(expvar.Handler()))
+	router.GET("/goroutines", GoroutineDump)
+}
+
+// GoroutineDump returns the stack traces of all goroutines
+// @Summary      Dump all goroutines
+// @Description  Returns the stack traces of all goroutines as text, useful to find leaked syslog or DTX streams. Needs the admin token.
+// @Tags         debug
+// @Produce      plain
+// @Success      200
+// @Failure      401  {object}  GenericResponse
+// @Failure      403  {object}  GenericResponse
+// @Router       /debug/goroutines [get]
+func GoroutineDump(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+	runtimepprof.Lookup("goroutine").WriteTo(c.Writer, 2)
+}
@@ -1,12 +1,21 @@
 package api
 
 import (
+	"context"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/deltainstall"
 	"github.com/danielpaulus/go-ios/ios/installationproxy"
 	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/danielpaulus/go-ios/ios/misagent"
+	"github.com/danielpaulus/go-ios/ios/zipconduit"
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 )
 
 // List apps on a device
@@ -18,7 +27,7 @@ import (
 // @Failure      500 {object} GenericResponse
 // @Router       /device/{udid}/apps [post]
 func ListApps(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 	svc, _ := installationproxy.New(device)
 	var err error
 	var response []installationproxy.AppInfo
@@ -39,7 +48,7 @@ func ListApps(c *gin.Context) {
 // @Failure      500  {object} GenericResponse
 // @Router       /device/{udid}/apps/launch [post]
 func LaunchApp(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 
 	bundleID := c.Query("bundleID")
 	if bundleID == "" {
@@ -72,7 +81,7 @@ func LaunchApp(c *gin.Context) {
 // @Failure      500 {object} GenericResponse
 // @Router       /device/{udid}/apps/kill [post]
 func KillApp(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 	processName := ""
 
 	bundleID := c.Query("bundleID")
@@ -138,3 +147,350 @@ func KillApp(c *gin.Context) {
 
 	c.JSON(http.StatusOK, GenericResponse{Message: bundleID + " is not running"})
 }
+
+// installedArtifacts remembers which artifact was installed last for an app on a device,
+// keyed by "<udid>/<bundle id>"
+var installedArtifacts = sync.Map{}
+
+// Install app on a device
+// @Summary      Install app on a device
+// @Description  Installs an ipa on a device. Reference an artifact that was uploaded before with the artifact query param or let the host download it with the url query param, that way an app can be installed on many devices without uploading it for each of them. Files that did not change since the last download are not downloaded again. Uploads as multipart field "file" or raw body with the name query param are stored in the artifact store as well.
+// @Description  Ipas are extracted once per artifact. If the same artifact was installed on the device already and the app is still installed with the same version, the installation is skipped unless force=true.
+// @Description  New builds of apps that are installed already only upload the files that changed since the last install.
+// @Tags         apps
+// @Produce      json
+// @Param        artifact query string false "id of an artifact from the artifact store"
+// @Param        url query string false "url to download the ipa from"
+// @Param        name query string false "file name of a raw body upload, f.ex. app.ipa"
+// @Param        force query bool false "install even if the artifact is installed already"
+// @Success      200  {object} GenericResponse
+// @Failure      404  {object} GenericResponse
+// @Failure      422  {object} GenericResponse
+// @Failure      500  {object} GenericResponse
+// @Router       /device/{udid}/apps/install [post]
+func InstallApp(c *gin.Context) {
+	device := MustGetDevice(c)
+	artifact, status, err := artifactFromRequest(c)
+	if err != nil {
+		c.JSON(status, GenericResponse{Error: err.Error()})
+		return
+	}
+	message, err := installArtifact(context.Background(), device, artifact, c.Query("force") == "true", nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: message})
+}
+
+// installArtifact installs the artifact on the device unless the same artifact is installed already and force is false.
+// The installation is aborted when ctx is done. progress is called for every status update of the device if it is not nil.
+func installArtifact(ctx context.Context, device ios.DeviceEntry, artifact Artifact, force bool, progress func(zipconduit.Progress)) (message string, err error) {
+	defer slos.observe(sloInstall, time.Now(), &err)
+	path, err := artifacts.extract(artifact)
+	if err != nil {
+		return "", err
+	}
+	bundleID, version, bundleErr := appBundleInfo(path)
+	installKey := device.Properties.SerialNumber + "/" + bundleID
+	if bundleErr == nil && !force {
+		if installed, ok := installedArtifacts.Load(installKey); ok && installed == artifact.ID && appInstalled(device, bundleID, version) {
+			return artifact.Name + " is installed already", nil
+		}
+	}
+
+	err = sendApp(ctx, device, path, progress)
+	if err != nil {
+		return "", err
+	}
+	if bundleErr == nil {
+		installedArtifacts.Store(installKey, artifact.ID)
+	}
+	return artifact.Name + " installed successfully", nil
+}
+
+// sendApp installs the extracted app at path, a DeviceSimulation replaces it. New builds of apps that are installed
+// already are installed with deltainstall, which only uploads the files that changed. Everything else and failed
+// delta installs are installed with zipconduit.
+var sendApp = func(ctx context.Context, device ios.DeviceEntry, path string, progress func(zipconduit.Progress)) error {
+	if appDir, bundleID, ok := deltaCandidate(device, path); ok {
+		err := sendDelta(ctx, device, appDir, bundleID, progress)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		log.WithError(err).WithField("bundleID", bundleID).Warn("delta install failed, installing the complete app")
+	}
+	conn, err := zipconduit.New(device)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	err = conn.SendFileWithProgress(path, progress)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Install app on a device and stream the progress
+// @Summary      Install app on a device with progress events
+// @Description  Installs an ipa like /device/{udid}/apps/install and streams the progress as server-sent events. "progress" events have the status and percentComplete the device reports, the stream ends with a "done" event or an "error" event if the installation failed.
+// @Description  Upload the ipa as multipart field "file" or reference it with the artifact, url or name query params.
+// @Tags         apps
+// @Accept       multipart/form-data
+// @Produce      text/event-stream
+// @Param        udid path string true "Device UDID"
+// @Param        file formData file false "the ipa"
+// @Param        artifact query string false "id of an artifact from the artifact store"
+// @Param        url query string false "url to download the ipa from"
+// @Param        force query bool false "install even if the artifact is installed already"
+// @Success      200  {object} zipconduit.Progress
+// @Failure      404  {object} GenericResponse
+// @Failure      422  {object} GenericResponse
+// @Router       /device/{udid}/apps [post]
+func InstallAppWithProgress(c *gin.Context) {
+	device := MustGetDevice(c)
+	artifact, status, err := artifactFromRequest(c)
+	if err != nil {
+		c.JSON(status, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.SSEvent("uploaded", GenericResponse{Message: artifact.Name + " uploaded"})
+	c.Writer.Flush()
+	message, err := installArtifact(context.Background(), device, artifact, c.Query("force") == "true", func(progress zipconduit.Progress) {
+		c.SSEvent("progress", progress)
+		c.Writer.Flush()
+	})
+	if err != nil {
+		c.SSEvent("error", GenericResponse{Error: err.Error()})
+		return
+	}
+	c.SSEvent("done", GenericResponse{Message: message})
+}
+
+// Uninstall app from a device
+// @Summary      Uninstall app from a device
+// @Description  Uninstalls an app. Use /device/{udid}/apps/system/{bundleID} for system apps.
+// @Tags         apps
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        bundleID path string true "bundle identifier of the app"
+// @Success      200 {object} GenericResponse
+// @Failure      404 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/apps/{bundleID} [delete]
+func UninstallApp(c *gin.Context) {
+	device := MustGetDevice(c)
+	bundleID := c.Param("bundleID")
+	svc, err := installationproxy.New(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer svc.Close()
+	apps, err := svc.BrowseUserApps()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	installed := false
+	for _, app := range apps {
+		if app.CFBundleIdentifier == bundleID {
+			installed = true
+			break
+		}
+	}
+	if !installed {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: bundleID + " is not installed"})
+		return
+	}
+	err = svc.Uninstall(bundleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	installedArtifacts.Delete(device.Properties.SerialNumber + "/" + bundleID)
+	c.JSON(http.StatusOK, GenericResponse{Message: bundleID + " uninstalled"})
+}
+
+// appInstalled returns true if the app with the bundle id and version is installed on the device
+func appInstalled(device ios.DeviceEntry, bundleID string, version string) bool {
+	installed, ok := installedVersion(device, bundleID)
+	return ok && installed == version
+}
+
+// installedVersion returns the CFBundleVersion of the app if it is installed on the device
+func installedVersion(device ios.DeviceEntry, bundleID string) (string, bool) {
+	svc, err := installationproxy.New(device)
+	if err != nil {
+		return "", false
+	}
+	defer svc.Close()
+	apps, err := svc.BrowseUserApps()
+	if err != nil {
+		return "", false
+	}
+	for _, app := range apps {
+		if app.CFBundleIdentifier == bundleID {
+			return app.CFBundleVersion, true
+		}
+	}
+	return "", false
+}
+
+// deltaCandidate returns the app bundle of an extracted ipa at path if the app is installed on the device already
+func deltaCandidate(device ios.DeviceEntry, path string) (appDir string, bundleID string, ok bool) {
+	bundleID, _, err := appBundleInfo(path)
+	if err != nil {
+		return "", "", false
+	}
+	apps, err := filepath.Glob(filepath.Join(path, "Payload", "*.app"))
+	if err != nil || len(apps) != 1 {
+		return "", "", false
+	}
+	if _, installed := installedVersion(device, bundleID); !installed {
+		return "", "", false
+	}
+	return apps[0], bundleID, true
+}
+
+// sendDelta installs the app bundle with deltainstall, the installation is aborted when ctx is done
+func sendDelta(ctx context.Context, device ios.DeviceEntry, appDir string, bundleID string, progress func(zipconduit.Progress)) error {
+	installer, err := deltainstall.New(device)
+	if err != nil {
+		return err
+	}
+	defer installer.Close()
+	stop := context.AfterFunc(ctx, func() { installer.Close() })
+	defer stop()
+	stats, err := installer.Install(appDir, bundleID, func(status string, percent int) {
+		if progress != nil {
+			progress(zipconduit.Progress{Status: status, PercentComplete: percent})
+		}
+	})
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == nil {
+		log.WithFields(log.Fields{"bundleID": bundleID, "uploadedFiles": stats.UploadedFiles, "files": stats.Files}).Info("delta install done")
+	}
+	return err
+}
+
+// AppSignature describes how an installed app is signed
+type AppSignature struct {
+	BundleID         string                 `json:"bundleId"`
+	SignerIdentity   string                 `json:"signerIdentity,omitempty"`
+	TeamID           string                 `json:"teamId,omitempty"`
+	ProfileValidated bool                   `json:"profileValidated"`
+	Entitlements     map[string]interface{} `json:"entitlements"`
+	// Profile is the installed provisioning profile matching the application identifier of the app, if there is one
+	Profile *ProfileSummary `json:"profile,omitempty"`
+}
+
+// ProfileSummary contains the fields of a provisioning profile relevant to check an app's signing
+type ProfileSummary struct {
+	Name           string    `json:"name"`
+	UUID           string    `json:"uuid"`
+	AppIDName      string    `json:"appIdName,omitempty"`
+	TeamName       string    `json:"teamName,omitempty"`
+	TeamIdentifier []string  `json:"teamIdentifier"`
+	ExpirationDate time.Time `json:"expirationDate"`
+	Expired        bool      `json:"expired"`
+}
+
+// Get the signature of an app
+// @Summary      Get entitlements and signing of an installed app
+// @Description  Returns the entitlements, signing identity, team id and the matching provisioning profile with its expiry date of an installed app, so CI can check the signing before running tests. The profile is looked up in the profiles installed on the device by the application identifier of the app.
+// @Tags         apps
+// @Produce      json
+// @Param        bundleID query string true "bundle identifier of the targeted app"
+// @Success      200  {object} AppSignature
+// @Failure      404  {object} GenericResponse
+// @Failure      422  {object} GenericResponse
+// @Failure      500  {object} GenericResponse
+// @Router       /device/{udid}/apps/signature [get]
+func GetAppSignature(c *gin.Context) {
+	device := MustGetDevice(c)
+	bundleID := c.Query("bundleID")
+	if bundleID == "" {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "bundleID query param is missing"})
+		return
+	}
+	svc, err := installationproxy.New(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	apps, err := svc.BrowseAllApps()
+	svc.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	var app *installationproxy.AppInfo
+	for i := range apps {
+		if apps[i].CFBundleIdentifier == bundleID {
+			app = &apps[i]
+			break
+		}
+	}
+	if app == nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: bundleID + " is not installed"})
+		return
+	}
+
+	signature := AppSignature{
+		BundleID:         bundleID,
+		SignerIdentity:   app.SignerIdentity,
+		ProfileValidated: app.ProfileValidated,
+		Entitlements:     app.Entitlements,
+	}
+	applicationIdentifier, _ := app.Entitlements["application-identifier"].(string)
+	signature.TeamID, _ = app.Entitlements["com.apple.developer.team-identifier"].(string)
+	if signature.TeamID == "" && strings.Contains(applicationIdentifier, ".") {
+		signature.TeamID = strings.SplitN(applicationIdentifier, ".", 2)[0]
+	}
+	if applicationIdentifier == "" {
+		c.JSON(http.StatusOK, signature)
+		return
+	}
+
+	profiles, err := installedProfiles(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	for _, profile := range profiles {
+		if !profile.Matches(applicationIdentifier) {
+			continue
+		}
+		// prefer the profile that is valid the longest if multiple profiles match
+		if signature.Profile != nil && profile.ExpirationDate.Before(signature.Profile.ExpirationDate) {
+			continue
+		}
+		signature.Profile = &ProfileSummary{
+			Name:           profile.Name,
+			UUID:           profile.UUID,
+			AppIDName:      profile.AppIDName,
+			TeamName:       profile.TeamName,
+			TeamIdentifier: profile.TeamIdentifier,
+			ExpirationDate: profile.ExpirationDate,
+			Expired:        profile.ExpirationDate.Before(time.Now()),
+		}
+	}
+	c.JSON(http.StatusOK, signature)
+}
+
+func installedProfiles(device ios.DeviceEntry) ([]misagent.ProvisioningProfile, error) {
+	conn, err := misagent.New(device)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.ProvisioningProfiles()
+}
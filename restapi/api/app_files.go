@@ -0,0 +1,267 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/afc"
+	"github.com/danielpaulus/go-ios/ios/house_arrest"
+	"github.com/gin-gonic/gin"
+)
+
+// AppFile is a file or directory in the container of an app
+type AppFile struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	IsDir   bool      `json:"isDir"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// appContainer is the file system of an app container
+type appContainer interface {
+	stat(path string) (AppFile, error)
+	readDir(path string) ([]string, error)
+	read(path string, w io.Writer) error
+	write(r io.Reader, path string) error
+	mkdir(path string) error
+	remove(path string, recursive bool) error
+	close()
+}
+
+// openAppContainer vends the container of an app with house_arrest, tests replace it
+var openAppContainer = func(device ios.DeviceEntry, bundleID string) (appContainer, error) {
+	conn, err := house_arrest.NewAFC(device, bundleID)
+	if err != nil {
+		return nil, err
+	}
+	return afcContainer{conn: conn}, nil
+}
+
+type afcContainer struct {
+	conn *afc.Connection
+}
+
+func (a afcContainer) stat(p string) (AppFile, error) {
+	info, err := a.conn.Stat(p)
+	if err != nil {
+		return AppFile{}, err
+	}
+	return AppFile{Name: path.Base(p), Path: p, Size: info.Size(), IsDir: info.IsDir(), ModTime: info.ModTime()}, nil
+}
+
+func (a afcContainer) readDir(p string) ([]string, error) { return a.conn.ReadDir(p) }
+
+func (a afcContainer) read(p string, w io.Writer) error { return a.conn.ReadFile(p, w) }
+
+func (a afcContainer) write(r io.Reader, p string) error { return a.conn.WriteToFile(r, p) }
+
+func (a afcContainer) mkdir(p string) error { return a.conn.MkDir(p) }
+
+func (a afcContainer) remove(p string, recursive bool) error {
+	if recursive {
+		return a.conn.RemovePathAndContents(p)
+	}
+	return a.conn.Remove(p)
+}
+
+func (a afcContainer) close() { a.conn.Close() }
+
+// containerPath cleans the path query param, paths are relative to the root of the container
+func containerPath(c *gin.Context) string {
+	return path.Clean("/" + c.Query("path"))
+}
+
+// withAppContainer opens the container of the app in the bundleID param, writes the error response if that fails
+func withAppContainer(c *gin.Context, f func(container appContainer)) {
+	bundleID := c.Param("bundleID")
+	container, err := openAppContainer(MustGetDevice(c), bundleID)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: fmt.Sprintf("%s, only the containers of installed apps signed for development can be accessed", err.Error())})
+		return
+	}
+	defer container.close()
+	f(container)
+}
+
+// appFileError writes the response for a failed file operation
+func appFileError(c *gin.Context, err error) {
+	if errors.Is(err, afc.ErrObjectNotFound) {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: fmt.Sprintf("%s not found", containerPath(c))})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+}
+
+// ListAppFiles lists files in the container of an app
+// @Summary      List files in an app container
+// @Description  Lists the directory at path in the container of the app, f.ex. /Documents or /Library/Caches. Only works for apps signed for development.
+// @Tags         apps
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        bundleID path string true "bundle identifier of the app"
+// @Param        path query string false "directory in the container, defaults to /"
+// @Success      200  {object}  []AppFile
+// @Failure      404  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/apps/{bundleID}/files [get]
+func ListAppFiles(c *gin.Context) {
+	withAppContainer(c, func(container appContainer) {
+		dir := containerPath(c)
+		info, err := container.stat(dir)
+		if err != nil {
+			appFileError(c, err)
+			return
+		}
+		if !info.IsDir {
+			c.JSON(http.StatusOK, []AppFile{info})
+			return
+		}
+		names, err := container.readDir(dir)
+		if err != nil {
+			appFileError(c, err)
+			return
+		}
+		files := make([]AppFile, 0, len(names))
+		for _, name := range names {
+			file, err := container.stat(path.Join(dir, name))
+			if err != nil {
+				// files can be removed by the app while listing
+				continue
+			}
+			files = append(files, file)
+		}
+		c.JSON(http.StatusOK, files)
+	})
+}
+
+// DownloadAppFile downloads a file from the container of an app
+// @Summary      Download a file from an app container
+// @Description  Downloads the file at path from the container of the app, f.ex. result files written by a test.
+// @Tags         apps
+// @Produce      octet-stream
+// @Param        udid path string true "Device UDID"
+// @Param        bundleID path string true "bundle identifier of the app"
+// @Param        path query string true "file in the container"
+// @Success      200
+// @Failure      404  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/apps/{bundleID}/files/download [get]
+func DownloadAppFile(c *gin.Context) {
+	withAppContainer(c, func(container appContainer) {
+		file := containerPath(c)
+		info, err := container.stat(file)
+		if err != nil {
+			appFileError(c, err)
+			return
+		}
+		if info.IsDir {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: fmt.Sprintf("%s is a directory", file)})
+			return
+		}
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name}))
+		c.Status(http.StatusOK)
+		err = container.read(file, c.Writer)
+		if err != nil {
+			// the headers are sent already, the client sees a short body
+			c.Error(err)
+		}
+	})
+}
+
+// UploadAppFile uploads a file into the container of an app
+// @Summary      Upload a file into an app container
+// @Description  Writes the multipart field "file" to path in the container of the app, f.ex. to seed test fixtures. If path is a directory, the file keeps its name. Missing parent directories are created and existing files are replaced.
+// @Tags         apps
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        bundleID path string true "bundle identifier of the app"
+// @Param        path query string true "file or directory in the container"
+// @Param        file formData file true "the file"
+// @Success      201  {object}  AppFile
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/apps/{bundleID}/files/upload [post]
+func UploadAppFile(c *gin.Context) {
+	upload, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "upload the file as multipart field 'file'"})
+		return
+	}
+	withAppContainer(c, func(container appContainer) {
+		file := containerPath(c)
+		if info, err := container.stat(file); err == nil && info.IsDir {
+			file = path.Join(file, path.Base("/"+upload.Filename))
+		}
+		if file == "/" {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "path must name a file or directory"})
+			return
+		}
+		dir := path.Dir(file)
+		if _, err := container.stat(dir); errors.Is(err, afc.ErrObjectNotFound) {
+			err = container.mkdir(dir)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+				return
+			}
+		}
+		f, err := upload.Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+			return
+		}
+		defer f.Close()
+		err = container.write(f, file)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+			return
+		}
+		info, err := container.stat(file)
+		if err != nil {
+			info = AppFile{Name: path.Base(file), Path: file, Size: upload.Size}
+		}
+		c.JSON(http.StatusCreated, info)
+	})
+}
+
+// DeleteAppFile deletes a file or directory in the container of an app
+// @Summary      Delete a file in an app container
+// @Description  Deletes the file or directory at path in the container of the app. Directories that are not empty need recursive=true.
+// @Tags         apps
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        bundleID path string true "bundle identifier of the app"
+// @Param        path query string true "file or directory in the container"
+// @Param        recursive query bool false "delete directories with their contents"
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/apps/{bundleID}/files [delete]
+func DeleteAppFile(c *gin.Context) {
+	file := containerPath(c)
+	if file == "/" {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "the root of the container can't be deleted"})
+		return
+	}
+	withAppContainer(c, func(container appContainer) {
+		err := container.remove(file, c.Query("recursive") == "true")
+		if err != nil {
+			appFileError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, GenericResponse{Message: fmt.Sprintf("%s deleted", file)})
+	})
+}
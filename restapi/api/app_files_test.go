@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/afc"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeContainer keeps files by path, directories have nil contents
+type fakeContainer map[string][]byte
+
+func (f fakeContainer) stat(p string) (AppFile, error) {
+	contents, ok := f[p]
+	if !ok && p != "/" {
+		return AppFile{}, afc.ErrObjectNotFound
+	}
+	return AppFile{Name: path.Base(p), Path: p, Size: int64(len(contents)), IsDir: contents == nil}, nil
+}
+
+func (f fakeContainer) readDir(p string) ([]string, error) {
+	var names []string
+	for file := range f {
+		if path.Dir(file) == p {
+			names = append(names, path.Base(file))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (f fakeContainer) read(p string, w io.Writer) error {
+	_, err := w.Write(f[p])
+	return err
+}
+
+func (f fakeContainer) write(r io.Reader, p string) error {
+	b, err := io.ReadAll(r)
+	f[p] = append([]byte{}, b...)
+	return err
+}
+
+func (f fakeContainer) mkdir(p string) error {
+	for ; p != "/"; p = path.Dir(p) {
+		f[p] = nil
+	}
+	return nil
+}
+
+func (f fakeContainer) remove(p string, recursive bool) error {
+	if _, ok := f[p]; !ok {
+		return afc.ErrObjectNotFound
+	}
+	for file := range f {
+		if strings.HasPrefix(file, p+"/") {
+			if !recursive {
+				return errors.New("DirNotEmpty")
+			}
+			delete(f, file)
+		}
+	}
+	delete(f, p)
+	return nil
+}
+
+func (f fakeContainer) close() {}
+
+func TestAppFiles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("files-a"))
+	defer devices.Remove("files-a")
+	container := fakeContainer{"/Documents": nil, "/Documents/result.json": []byte(`{"passed":true}`), "/Library": nil}
+	defer func(open func(ios.DeviceEntry, string) (appContainer, error)) { openAppContainer = open }(openAppContainer)
+	openAppContainer = func(device ios.DeviceEntry, bundleID string) (appContainer, error) {
+		if bundleID != "com.example.debug" {
+			return nil, errors.New("InstallationLookupFailed")
+		}
+		return container, nil
+	}
+
+	r := gin.New()
+	appFileRoutes(r.Group("/device/:udid", DeviceMiddleware()))
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	base := "/device/files-a/apps/com.example.debug/files"
+
+	w := serve(httptest.NewRequest(http.MethodGet, base, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var files []AppFile
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &files))
+	require.Len(t, files, 2)
+	assert.Equal(t, AppFile{Name: "Documents", Path: "/Documents", IsDir: true, ModTime: files[0].ModTime}, files[0])
+
+	w = serve(httptest.NewRequest(http.MethodGet, "/device/files-a/apps/com.example.release/files", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "signed for development")
+
+	w = serve(httptest.NewRequest(http.MethodGet, base+"/download?path=Documents/result.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"passed":true}`, w.Body.String())
+	assert.Equal(t, `attachment; filename=result.json`, w.Header().Get("Content-Disposition"))
+	w = serve(httptest.NewRequest(http.MethodGet, base+"/download?path=/Documents", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	w = serve(httptest.NewRequest(http.MethodGet, base+"/download?path=/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "fixture.db")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("fixture"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	req := httptest.NewRequest(http.MethodPost, base+"/upload?path=/Library/Fixtures/seed.db", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w = serve(req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, []byte("fixture"), container["/Library/Fixtures/seed.db"])
+	assert.Contains(t, container, "/Library/Fixtures", "missing parent directories are created")
+
+	w = serve(httptest.NewRequest(http.MethodDelete, base+"?path=/Library", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = serve(httptest.NewRequest(http.MethodDelete, base+"?path=/Library&recursive=true", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, container, "/Library/Fixtures/seed.db")
+	w = serve(httptest.NewRequest(http.MethodDelete, base+"?path=/", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/installationproxy"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// wdaActiveAppTimeout is how long the app state waits for WDA to tell the foreground app
+const wdaActiveAppTimeout = 5 * time.Second
+
+// AppState is whether an app is installed, running and in the foreground
+type AppState struct {
+	BundleID  string `json:"bundleId"`
+	Installed bool   `json:"installed"`
+	// Version is the CFBundleShortVersionString and BuildVersion the CFBundleVersion of the installed app
+	Version      string `json:"version,omitempty"`
+	BuildVersion string `json:"buildVersion,omitempty"`
+	Running      bool   `json:"running"`
+	Pid          uint64 `json:"pid,omitempty"`
+	// Foreground is missing if it is unknown. Only WebDriverAgent can tell the foreground app, so it is known while
+	// a WDA session of the device runs.
+	Foreground *bool `json:"foreground,omitempty"`
+}
+
+// lookupApp finds an installed app by its bundle id, tests replace it
+var lookupApp = func(device ios.DeviceEntry, bundleID string) (installationproxy.AppInfo, bool, error) {
+	svc, err := installationproxy.New(device)
+	if err != nil {
+		return installationproxy.AppInfo{}, false, err
+	}
+	defer svc.Close()
+	apps, err := svc.BrowseAllApps()
+	if err != nil {
+		return installationproxy.AppInfo{}, false, err
+	}
+	for _, app := range apps {
+		if app.CFBundleIdentifier == bundleID {
+			return app, true, nil
+		}
+	}
+	return installationproxy.AppInfo{}, false, nil
+}
+
+// wdaActiveApp asks the WDA forwarded to hostPort for the bundle id of the foreground app, tests replace it
+var wdaActiveApp = func(ctx context.Context, hostPort int) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/wda/activeAppInfo", hostPort), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("wdaActiveApp: WDA returned %s", resp.Status)
+	}
+	var response struct {
+		Value struct {
+			BundleID string `json:"bundleId"`
+		} `json:"value"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return "", fmt.Errorf("wdaActiveApp: could not decode response: %w", err)
+	}
+	return response.Value.BundleID, nil
+}
+
+// appExecutable returns the path of the executable of an app with the /private prefix processes report trimmed
+func appExecutable(app installationproxy.AppInfo) string {
+	return strings.TrimPrefix(app.Path, "/private") + "/" + app.CFBundleExecutable
+}
+
+// appState combines the installation proxy, the process list and WDA into the state of an app
+func appState(ctx context.Context, device ios.DeviceEntry, bundleID string) (AppState, error) {
+	state := AppState{BundleID: bundleID}
+	app, installed, err := lookupApp(device, bundleID)
+	if err != nil {
+		return AppState{}, err
+	}
+	if !installed {
+		return state, nil
+	}
+	state.Installed = true
+	state.Version = app.CFBundleShortVersionString
+	state.BuildVersion = app.CFBundleVersion
+
+	processes, err := listProcesses(ctx, device, false)
+	if err != nil {
+		return AppState{}, err
+	}
+	executable := appExecutable(app)
+	for _, p := range processes {
+		if strings.TrimPrefix(p.RealAppName, "/private") == executable {
+			state.Running = true
+			state.Pid = p.Pid
+			break
+		}
+	}
+
+	sessions := sessionPool.list(device.Properties.SerialNumber)
+	if len(sessions) == 0 {
+		return state, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, wdaActiveAppTimeout)
+	defer cancel()
+	active, err := wdaActiveApp(ctx, sessions[0].HostPort)
+	if err != nil {
+		log.WithField("udid", device.Properties.SerialNumber).WithError(err).Debug("failed getting the foreground app from WDA")
+		return state, nil
+	}
+	foreground := state.Running && active == bundleID
+	state.Foreground = &foreground
+	return state, nil
+}
+
+// Get the state of an app
+// @Summary      Get the state of an app
+// @Description  Returns whether the app is installed, its version, the pid of its process if it is running and whether it is in the foreground. The foreground app is only known while a WDA session of the device runs.
+// @Tags         apps
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        bundleID path string true "bundle identifier of the app"
+// @Success      200  {object}  AppState
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/apps/{bundleID}/state [get]
+func GetAppState(c *gin.Context) {
+	device := MustGetDevice(c)
+	state, err := appState(c.Request.Context(), device, c.Param("bundleID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/installationproxy"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAppState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	originalLookup, originalList, originalActive := lookupApp, listProcesses, wdaActiveApp
+	t.Cleanup(func() { lookupApp, listProcesses, wdaActiveApp = originalLookup, originalList, originalActive })
+	lookupApp = func(device ios.DeviceEntry, bundleID string) (installationproxy.AppInfo, bool, error) {
+		if bundleID != "com.example.app" {
+			return installationproxy.AppInfo{}, false, nil
+		}
+		return installationproxy.AppInfo{CFBundleIdentifier: bundleID, CFBundleShortVersionString: "1.2", CFBundleVersion: "42",
+			CFBundleExecutable: "Example", Path: "/private/var/containers/Bundle/Application/5F0C/Example.app"}, true, nil
+	}
+	listProcesses = func(ctx context.Context, device ios.DeviceEntry, withMemory bool) ([]Process, error) {
+		return []Process{
+			{Pid: 1, Name: "launchd", RealAppName: "/sbin/launchd"},
+			{Pid: 4711, Name: "Example", RealAppName: "/var/containers/Bundle/Application/5F0C/Example.app/Example", IsApplication: true},
+		}, nil
+	}
+	wdaActiveApp = func(ctx context.Context, hostPort int) (string, error) { return "com.example.app", nil }
+
+	r := gin.New()
+	r.GET("/device/:udid/apps/:bundleID/state", func(c *gin.Context) {
+		c.Set(IOS_KEY, testDevice(c.Param("udid")))
+		c.Next()
+	}, GetAppState)
+	get := func(udid string, bundleID string) AppState {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/"+udid+"/apps/"+bundleID+"/state", nil))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var state AppState
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+		return state
+	}
+
+	assert.Equal(t, AppState{BundleID: "com.example.other"}, get("state-udid", "com.example.other"))
+	assert.Equal(t, AppState{BundleID: "com.example.app", Installed: true, Version: "1.2", BuildVersion: "42", Running: true, Pid: 4711},
+		get("state-udid", "com.example.app"), "the foreground app is unknown without WDA")
+
+	sessionPool.mu.Lock()
+	sessionPool.sessions["state-udid"] = []*WdaSession{{ID: "s1", UDID: "state-udid", HostPort: 8100}}
+	sessionPool.mu.Unlock()
+	t.Cleanup(func() {
+		sessionPool.mu.Lock()
+		delete(sessionPool.sessions, "state-udid")
+		sessionPool.mu.Unlock()
+	})
+	state := get("state-udid", "com.example.app")
+	require.NotNil(t, state.Foreground)
+	assert.True(t, *state.Foreground)
+}
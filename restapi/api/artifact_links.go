@@ -0,0 +1,271 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// artifactSigningKeyEnvVar is the key shared artifact links are signed with. It can be sealed with
+	// 'ios secrets seal-value'. Without it a random key is used and links stop working when the server restarts.
+	// Changing the key revokes all links.
+	artifactSigningKeyEnvVar = "GO_IOS_ARTIFACT_SIGNING_KEY"
+	// publicURLEnvVar is the base url shared links point to, f.ex. https://lab.example.com if the server
+	// is behind a reverse proxy that only exposes /shared
+	publicURLEnvVar = "GO_IOS_PUBLIC_URL"
+
+	defaultArtifactLinkTTL = 24 * time.Hour
+	maxArtifactLinkTTL     = 7 * 24 * time.Hour
+)
+
+// ArtifactLink is a time-limited link to download an artifact without access to the rest of the API
+type ArtifactLink struct {
+	Artifact Artifact  `json:"artifact"`
+	URL      string    `json:"url"`
+	Expires  time.Time `json:"expires"`
+}
+
+var (
+	randomSigningKeyOnce sync.Once
+	randomSigningKey     []byte
+)
+
+func artifactSigningKey() ([]byte, error) {
+	key, err := sealedSecrets.env(artifactSigningKeyEnvVar)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		return []byte(key), nil
+	}
+	randomSigningKeyOnce.Do(func() {
+		randomSigningKey = make([]byte, 32)
+		_, _ = rand.Read(randomSigningKey)
+		log.Warnf("%s is not set, shared artifact links stop working when the server restarts", artifactSigningKeyEnvVar)
+	})
+	return randomSigningKey, nil
+}
+
+func artifactLinkSignature(key []byte, id string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(fmt.Sprintf("%s\n%d", id, expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyArtifactLink checks the signature and expiry of a shared link. It returns the http status to fail with.
+func verifyArtifactLink(id string, expiresParam string, signature string, now time.Time) (int, error) {
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil || signature == "" {
+		return http.StatusForbidden, fmt.Errorf("link is not signed")
+	}
+	key, err := artifactSigningKey()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	expected := artifactLinkSignature(key, id, expires)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return http.StatusForbidden, fmt.Errorf("invalid link signature")
+	}
+	if now.Unix() > expires {
+		return http.StatusGone, fmt.Errorf("link expired at %s", time.Unix(expires, 0).UTC().Format(time.RFC3339))
+	}
+	return http.StatusOK, nil
+}
+
+// linkTTL parses the ttl query param
+func linkTTL(c *gin.Context) (time.Duration, error) {
+	ttl := defaultArtifactLinkTTL
+	if value := c.Query("ttl"); value != "" {
+		var err error
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl <= 0 || ttl > maxArtifactLinkTTL {
+			return 0, fmt.Errorf("ttl must be a duration like 24h of at most %s", maxArtifactLinkTTL)
+		}
+	}
+	return ttl, nil
+}
+
+// publicBaseURL is GO_IOS_PUBLIC_URL or the scheme and host the request was sent to
+func publicBaseURL(c *gin.Context) string {
+	if base := os.Getenv(publicURLEnvVar); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+func newArtifactLink(c *gin.Context, artifact Artifact, ttl time.Duration) (ArtifactLink, error) {
+	key, err := artifactSigningKey()
+	if err != nil {
+		return ArtifactLink{}, err
+	}
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", artifactLinkSignature(key, artifact.ID, expires.Unix()))
+	return ArtifactLink{
+		Artifact: artifact,
+		URL:      fmt.Sprintf("%s/shared/artifacts/%s?%s", publicBaseURL(c), artifact.ID, query.Encode()),
+		Expires:  expires,
+	}, nil
+}
+
+// respondWithArtifactLink creates a link for artifact with the ttl of the request
+func respondWithArtifactLink(c *gin.Context, artifact Artifact, ttl time.Duration) {
+	link, err := newArtifactLink(c, artifact, ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, link)
+}
+
+// ShareArtifact creates a signed link to an artifact
+// @Summary      Create a signed download link for an artifact
+// @Description  Returns a link to download the artifact that expires after ttl, so it can be shared in a bug tracker without access to the rest of the API. The link is signed with GO_IOS_ARTIFACT_SIGNING_KEY, changing the key revokes all links.
+// @Tags         artifacts
+// @Produce      json
+// @Param        id path string true "Artifact id"
+// @Param        ttl query string false "how long the link is valid, f.ex. 2h, defaults to 24h, at most 168h"
+// @Success      201  {object}  ArtifactLink
+// @Failure      404  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /artifacts/{id}/share [post]
+func ShareArtifact(c *gin.Context) {
+	ttl, err := linkTTL(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	artifact, err := artifacts.get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: err.Error()})
+		return
+	}
+	respondWithArtifactLink(c, artifact, ttl)
+}
+
+// ShareSessionRecording stores a session recording as artifact and creates a signed link to it
+// @Summary      Create a signed download link for a session recording
+// @Description  Stores the recording of a WebDriverAgent session in the artifact store and returns a link to download it that expires after ttl.
+// @Tags         wda
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        id path string true "Session id"
+// @Param        ttl query string false "how long the link is valid, f.ex. 2h, defaults to 24h, at most 168h"
+// @Success      201  {object}  ArtifactLink
+// @Failure      404  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/wda/recording/{id}/share [post]
+func ShareSessionRecording(c *gin.Context) {
+	ttl, err := linkTTL(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	device := MustGetDevice(c)
+	path := recordingPath(device.Properties.SerialNumber, c.Param("id"))
+	f, err := os.Open(path)
+	if err != nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "recording not found"})
+		return
+	}
+	defer f.Close()
+	artifact, err := artifacts.put(f, filepath.Base(path), "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	respondWithArtifactLink(c, artifact, ttl)
+}
+
+// ShareDeviceExport stores a device export as artifact and creates a signed link to it
+// @Summary      Create a signed download link for a device export
+// @Description  Collects the same archive as GET /device/{udid}/export, stores it in the artifact store and returns a link to download it that expires after ttl. Use it to attach crash reports and logs of a time window to a bug.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        from query string true "start of the window as RFC3339 time, f.ex. 2024-01-16T15:00:00Z"
+// @Param        to query string false "end of the window as RFC3339 time, defaults to now"
+// @Param        ttl query string false "how long the link is valid, f.ex. 2h, defaults to 24h, at most 168h"
+// @Success      201  {object}  ArtifactLink
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/export/share [post]
+func ShareDeviceExport(c *gin.Context) {
+	ttl, err := linkTTL(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	from, to, err := exportWindow(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	export, err := prepareExport(MustGetDevice(c), from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer export.close()
+	f, err := export.ws.CreateTemp("export-*.zip")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer f.Close()
+	err = export.write(f)
+	if err == nil {
+		_, err = f.Seek(0, 0)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	artifact, err := artifacts.put(f, export.filename(), "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	respondWithArtifactLink(c, artifact, ttl)
+}
+
+// DownloadSharedArtifact serves an artifact to anyone with a valid signed link. It is registered outside of
+// /api, so a reverse proxy can expose shared links without exposing the API. Downloads are limited like other
+// transfers, see GO_IOS_BANDWIDTH.
+func DownloadSharedArtifact(c *gin.Context) {
+	id := c.Param("id")
+	status, err := verifyArtifactLink(id, c.Query("expires"), c.Query("signature"), time.Now())
+	if err != nil {
+		c.JSON(status, GenericResponse{Error: err.Error()})
+		return
+	}
+	artifact, err := artifacts.get(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "artifact not found"})
+		return
+	}
+	serveLimited(c, artifacts.path(artifact), artifact.Name)
+}
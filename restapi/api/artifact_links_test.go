@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactLinks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv(artifactSigningKeyEnvVar, "signing-key")
+	t.Setenv(publicURLEnvVar, "https://lab.example.com/")
+	original := artifacts
+	artifacts = newArtifactStore(t.TempDir())
+	defer func() { artifacts = original }()
+	artifact, err := artifacts.put(strings.NewReader("crash log"), "crash.ips", "")
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.POST("/artifacts/:id/share", ShareArtifact)
+	r.GET("/shared/artifacts/:id", DownloadSharedArtifact)
+	do := func(method string, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	w := do(http.MethodPost, "/artifacts/"+artifact.ID+"/share?ttl=2h")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var link ArtifactLink
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &link))
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), link.Expires, time.Minute)
+	require.True(t, strings.HasPrefix(link.URL, "https://lab.example.com/shared/artifacts/"+artifact.ID+"?"), link.URL)
+
+	shared, err := url.Parse(link.URL)
+	require.NoError(t, err)
+	w = do(http.MethodGet, shared.RequestURI())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "crash log", w.Body.String())
+	assert.Equal(t, `attachment; filename=crash.ips`, w.Header().Get("Content-Disposition"))
+
+	t.Run("tampered links fail", func(t *testing.T) {
+		query := shared.Query()
+		query.Set("expires", "4102444800")
+		w := do(http.MethodGet, shared.Path+"?"+query.Encode())
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = do(http.MethodGet, shared.Path)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+	t.Run("expired links fail", func(t *testing.T) {
+		status, err := verifyArtifactLink(artifact.ID, shared.Query().Get("expires"), shared.Query().Get("signature"), time.Now().Add(3*time.Hour))
+		assert.Equal(t, http.StatusGone, status)
+		assert.Error(t, err)
+	})
+	t.Run("rotating the key revokes links", func(t *testing.T) {
+		t.Setenv(artifactSigningKeyEnvVar, "new-key")
+		w := do(http.MethodGet, shared.RequestURI())
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+	t.Run("invalid ttl", func(t *testing.T) {
+		w := do(http.MethodPost, "/artifacts/"+artifact.ID+"/share?ttl=1000h")
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+	t.Run("unknown artifact", func(t *testing.T) {
+		w := do(http.MethodPost, "/artifacts/"+strings.Repeat("0", 64)+"/share")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
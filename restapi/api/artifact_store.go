@@ -0,0 +1,418 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const artifactDirEnvVar = "GO_IOS_ARTIFACT_DIR"
+
+// Artifact is a file, like an ipa, that was uploaded to or downloaded by the host once and can be used for many devices.
+// Its id is the sha256 of its content, so the same file is only stored once.
+type Artifact struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SourceURL string    `json:"sourceUrl,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// artifactStore keeps artifacts as <dir>/<id><ext> next to their metadata in <dir>/<id>.json
+type artifactStore struct {
+	dir string
+	mu  sync.Mutex
+	// downloads de-duplicates concurrent downloads of the same url
+	downloads map[string]*artifactDownload
+	// fetched are the validators of downloaded urls, an unchanged file is not downloaded again
+	fetched map[string]fetchedURL
+	// extractMu guards extracting artifacts, so every artifact is only extracted once
+	extractMu sync.Mutex
+	// writing are the resumable uploads a chunk is written to right now
+	writing map[string]bool
+}
+
+type artifactDownload struct {
+	done     chan struct{}
+	artifact Artifact
+	err      error
+}
+
+type fetchedURL struct {
+	artifact     Artifact
+	etag         string
+	lastModified string
+}
+
+// artifactClient gives up on servers that don't answer, but leaves large apps enough time to download
+var artifactClient = &http.Client{Timeout: 30 * time.Minute, Transport: artifactTransport()}
+
+func artifactTransport() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = time.Minute
+	return t
+}
+
+var artifacts = newArtifactStore(artifactDirFromEnv())
+
+func artifactDirFromEnv() string {
+	if dir := os.Getenv(artifactDirEnvVar); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "go-ios-artifacts")
+}
+
+func newArtifactStore(dir string) *artifactStore {
+	return &artifactStore{dir: dir, downloads: map[string]*artifactDownload{}, fetched: map[string]fetchedURL{}, writing: map[string]bool{}}
+}
+
+func validArtifactID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// put stores the content of r and returns the artifact. If an artifact with the same content exists already,
+// the existing one is returned.
+func (s *artifactStore) put(r io.Reader, name string, sourceURL string) (Artifact, error) {
+	err := os.MkdirAll(s.dir, 0o755)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("put: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return Artifact{}, fmt.Errorf("put: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	tmp.Close()
+	if err != nil {
+		return Artifact{}, fmt.Errorf("put: failed storing %s: %w", name, err)
+	}
+	artifact, err := s.add(tmp.Name(), hex.EncodeToString(h.Sum(nil)), size, name, sourceURL)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("put: %w", err)
+	}
+	return artifact, nil
+}
+
+// add moves the file at tmpPath, which must be in the store directory, into the store as artifact id.
+// If the artifact exists already, the existing one is returned and tmpPath is left alone.
+func (s *artifactStore) add(tmpPath string, id string, size int64, name string, sourceURL string) (Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, err := s.get(id); err == nil {
+		return existing, nil
+	}
+	artifact := Artifact{ID: id, Name: filepath.Base(name), Size: size, SourceURL: sourceURL, Created: time.Now()}
+	err := os.Rename(tmpPath, s.path(artifact))
+	if err != nil {
+		return Artifact{}, err
+	}
+	err = os.WriteFile(filepath.Join(s.dir, id+".json"), []byte(MustMarshal(artifact)), 0o644)
+	if err != nil {
+		os.Remove(s.path(artifact))
+		return Artifact{}, err
+	}
+	return artifact, nil
+}
+
+// download fetches url into the store, concurrent calls wait for the same download. A url that was downloaded
+// before is revalidated with its ETag or Last-Modified, so a changed file behind the same url is downloaded again.
+func (s *artifactStore) download(url string) (Artifact, error) {
+	s.mu.Lock()
+	d, ok := s.downloads[url]
+	if !ok {
+		d = &artifactDownload{done: make(chan struct{})}
+		s.downloads[url] = d
+	}
+	s.mu.Unlock()
+	if ok {
+		<-d.done
+		return d.artifact, d.err
+	}
+
+	d.artifact, d.err = s.fetch(url)
+	s.mu.Lock()
+	delete(s.downloads, url)
+	s.mu.Unlock()
+	close(d.done)
+	return d.artifact, d.err
+}
+
+func (s *artifactStore) fetch(url string) (Artifact, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("fetch: %w", err)
+	}
+	s.mu.Lock()
+	previous, ok := s.fetched[url]
+	s.mu.Unlock()
+	if ok {
+		if _, err := os.Stat(s.path(previous.artifact)); err != nil {
+			ok = false
+		}
+	}
+	if ok {
+		if previous.etag != "" {
+			req.Header.Set("If-None-Match", previous.etag)
+		}
+		if previous.lastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.lastModified)
+		}
+	}
+	log.WithField("url", url).Info("downloading artifact")
+	resp, err := artifactClient.Do(req)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("fetch: %w", err)
+	}
+	defer resp.Body.Close()
+	if ok && resp.StatusCode == http.StatusNotModified {
+		return previous.artifact, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Artifact{}, fmt.Errorf("fetch: downloading %s failed with status %s", url, resp.Status)
+	}
+	name := filepath.Base(strings.SplitN(url, "?", 2)[0])
+	artifact, err := s.put(resp.Body, name, url)
+	if err != nil {
+		return Artifact{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		delete(s.fetched, url)
+	} else {
+		s.fetched[url] = fetchedURL{artifact: artifact, etag: etag, lastModified: lastModified}
+	}
+	return artifact, nil
+}
+
+// get returns the artifact with the given id
+func (s *artifactStore) get(id string) (Artifact, error) {
+	if !validArtifactID(id) {
+		return Artifact{}, fmt.Errorf("get: invalid artifact id '%s'", id)
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
+	if err != nil {
+		return Artifact{}, fmt.Errorf("get: artifact %s not found: %w", id, err)
+	}
+	var artifact Artifact
+	err = json.Unmarshal(data, &artifact)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("get: %w", err)
+	}
+	return artifact, nil
+}
+
+// path returns where the content of the artifact is stored. The original file extension is kept,
+// because installers look at it.
+func (s *artifactStore) path(artifact Artifact) string {
+	return filepath.Join(s.dir, artifact.ID+filepath.Ext(artifact.Name))
+}
+
+func (s *artifactStore) list() ([]Artifact, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Artifact{}, nil
+		}
+		return nil, fmt.Errorf("list: %w", err)
+	}
+	result := []Artifact{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		artifact, err := s.get(id)
+		if err != nil {
+			continue
+		}
+		result = append(result, artifact)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Created.Before(result[j].Created) })
+	return result, nil
+}
+
+func (s *artifactStore) remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	artifact, err := s.get(id)
+	if err != nil {
+		return fmt.Errorf("remove: %w", err)
+	}
+	os.Remove(s.path(artifact))
+	os.RemoveAll(s.extractedPath(artifact))
+	return os.Remove(filepath.Join(s.dir, id+".json"))
+}
+
+func (s *artifactStore) extractedPath(artifact Artifact) string {
+	return filepath.Join(s.dir, artifact.ID+".extracted")
+}
+
+// extract unzips an ipa artifact once and returns the directory it was extracted to. Installing the
+// extracted directory saves unzipping the ipa again for every device. Other artifacts are returned as is.
+func (s *artifactStore) extract(artifact Artifact) (string, error) {
+	if !strings.EqualFold(filepath.Ext(artifact.Name), ".ipa") {
+		return s.path(artifact), nil
+	}
+	s.extractMu.Lock()
+	defer s.extractMu.Unlock()
+	dir := s.extractedPath(artifact)
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+	tmp, err := os.MkdirTemp(s.dir, "extract-*")
+	if err != nil {
+		return "", fmt.Errorf("extract: %w", err)
+	}
+	_, _, err = ios.Unzip(s.path(artifact), tmp)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("extract: %w", err)
+	}
+	err = os.Rename(tmp, dir)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("extract: %w", err)
+	}
+	return dir, nil
+}
+
+// appBundleInfo reads bundle id and version from Payload/*.app/Info.plist of an extracted ipa
+func appBundleInfo(extractedDir string) (bundleID string, version string, err error) {
+	infoPlists, err := filepath.Glob(filepath.Join(extractedDir, "Payload", "*.app", "Info.plist"))
+	if err != nil || len(infoPlists) == 0 {
+		return "", "", fmt.Errorf("appBundleInfo: no Payload/*.app/Info.plist in %s", extractedDir)
+	}
+	data, err := os.ReadFile(infoPlists[0])
+	if err != nil {
+		return "", "", fmt.Errorf("appBundleInfo: %w", err)
+	}
+	info, err := ios.ParsePlist(data)
+	if err != nil {
+		return "", "", fmt.Errorf("appBundleInfo: %w", err)
+	}
+	bundleID, _ = info["CFBundleIdentifier"].(string)
+	version, _ = info["CFBundleVersion"].(string)
+	if bundleID == "" {
+		return "", "", fmt.Errorf("appBundleInfo: %s has no CFBundleIdentifier", infoPlists[0])
+	}
+	return bundleID, version, nil
+}
+
+// artifactFromRequest returns the artifact a request refers to with the artifact or url query param,
+// or stores the uploaded file of the request. Files can be uploaded as multipart form field "file" or as raw body
+// with the file name in the name query param.
+func artifactFromRequest(c *gin.Context) (Artifact, int, error) {
+	if id := c.Query("artifact"); id != "" {
+		artifact, err := artifacts.get(id)
+		if err != nil {
+			return Artifact{}, http.StatusNotFound, err
+		}
+		return artifact, http.StatusOK, nil
+	}
+	if url := c.Query("url"); url != "" {
+		artifact, err := artifacts.download(url)
+		if err != nil {
+			return Artifact{}, http.StatusBadGateway, err
+		}
+		return artifact, http.StatusOK, nil
+	}
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return Artifact{}, http.StatusInternalServerError, err
+		}
+		defer f.Close()
+		artifact, err := artifacts.put(f, file.Filename, "")
+		if err != nil {
+			return Artifact{}, http.StatusInternalServerError, err
+		}
+		return artifact, http.StatusOK, nil
+	}
+	name := c.Query("name")
+	if name == "" || c.Request.ContentLength == 0 {
+		return Artifact{}, http.StatusUnprocessableEntity, fmt.Errorf("provide an artifact id, a url, a multipart file or a body with the name query param")
+	}
+	artifact, err := artifacts.put(c.Request.Body, name, "")
+	if err != nil {
+		return Artifact{}, http.StatusInternalServerError, err
+	}
+	return artifact, http.StatusOK, nil
+}
+
+// UploadArtifact stores a file in the artifact store
+// @Summary      Upload an artifact
+// @Description  Stores a file, like an ipa, on the host so it can be installed on many devices without uploading it again. Either upload it as multipart field "file", as raw body with the name query param or let the host download it with the url query param. Artifacts are de-duplicated by their sha256, which is their id.
+// @Tags         artifacts
+// @Produce      json
+// @Param        url query string false "url to download the artifact from"
+// @Param        name query string false "file name of a raw body upload, f.ex. app.ipa"
+// @Success      201  {object}  Artifact
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /artifacts [post]
+func UploadArtifact(c *gin.Context) {
+	if c.Query("artifact") != "" {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "artifact query param is not supported for uploads"})
+		return
+	}
+	artifact, status, err := artifactFromRequest(c)
+	if err != nil {
+		c.JSON(status, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, artifact)
+}
+
+// ListArtifacts lists the artifacts in the artifact store
+// @Summary      List artifacts
+// @Tags         artifacts
+// @Produce      json
+// @Success      200  {object}  []Artifact
+// @Failure      500  {object}  GenericResponse
+// @Router       /artifacts [get]
+func ListArtifacts(c *gin.Context) {
+	list, err := artifacts.list()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// DeleteArtifact removes an artifact from the artifact store
+// @Summary      Delete an artifact
+// @Tags         artifacts
+// @Produce      json
+// @Param        id path string true "Artifact id"
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Router       /artifacts/{id} [delete]
+func DeleteArtifact(c *gin.Context) {
+	err := artifacts.remove(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "artifact deleted"})
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArtifactStoreDeduplicates(t *testing.T) {
+	store := newArtifactStore(t.TempDir())
+	first, err := store.put(strings.NewReader("ipa content"), "app.ipa", "")
+	assert.NoError(t, err)
+	second, err := store.put(strings.NewReader("ipa content"), "other.ipa", "")
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, "app.ipa", second.Name)
+
+	content, err := os.ReadFile(store.path(first))
+	assert.NoError(t, err)
+	assert.Equal(t, "ipa content", string(content))
+
+	list, err := store.list()
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+
+	assert.NoError(t, store.remove(first.ID))
+	_, err = store.get(first.ID)
+	assert.Error(t, err)
+	_, err = store.get("../../etc/passwd")
+	assert.Error(t, err)
+}
+
+func TestArtifactStoreDownloadsOnce(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Write([]byte("downloaded ipa"))
+	}))
+	defer server.Close()
+	store := newArtifactStore(t.TempDir())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			artifact, err := store.download(server.URL + "/builds/app.ipa?token=abc")
+			assert.NoError(t, err)
+			assert.Equal(t, "app.ipa", artifact.Name)
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), requests.Load(), "concurrent downloads of the same url share one request")
+}
+
+func TestArtifactStoreRevalidatesDownloads(t *testing.T) {
+	var downloads, build atomic.Int32
+	build.Store(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"%d"`, build.Load())
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads.Add(1)
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, "nightly %d", build.Load())
+	}))
+	defer server.Close()
+	store := newArtifactStore(t.TempDir())
+	url := server.URL + "/latest.ipa"
+
+	first, err := store.download(url)
+	require.NoError(t, err)
+	again, err := store.download(url)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+	assert.Equal(t, int32(1), downloads.Load(), "an unchanged file is not downloaded again")
+
+	build.Store(2)
+	next, err := store.download(url)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, next.ID, "a new build behind the same url is downloaded")
+	assert.Equal(t, int32(2), downloads.Load())
+
+	require.NoError(t, store.remove(next.ID))
+	next, err = store.download(url)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), downloads.Load(), "a deleted artifact is downloaded again")
+	b, err := os.ReadFile(store.path(next))
+	require.NoError(t, err)
+	assert.Equal(t, "nightly 2", string(b))
+}
+
+func TestArtifactStoreExtractsIpaOnce(t *testing.T) {
+	var ipa bytes.Buffer
+	w := zip.NewWriter(&ipa)
+	f, _ := w.Create("Payload/Test.app/Info.plist")
+	f.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict>
+<key>CFBundleIdentifier</key><string>com.example.test</string>
+<key>CFBundleVersion</key><string>42</string>
+</dict></plist>`))
+	w.Close()
+
+	store := newArtifactStore(t.TempDir())
+	artifact, err := store.put(&ipa, "test.ipa", "")
+	assert.NoError(t, err)
+	dir, err := store.extract(artifact)
+	assert.NoError(t, err)
+	again, err := store.extract(artifact)
+	assert.NoError(t, err)
+	assert.Equal(t, dir, again)
+
+	bundleID, version, err := appBundleInfo(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "com.example.test", bundleID)
+	assert.Equal(t, "42", version)
+
+	assert.NoError(t, store.remove(artifact.ID))
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,363 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// artifactUploadTTL is how long an upload can be resumed after its last chunk
+const artifactUploadTTL = 24 * time.Hour
+
+// ArtifactUpload is a resumable upload of an artifact. Chunks are appended at Offset until Size bytes arrived,
+// then the upload becomes an artifact. Uploads survive restarts of the API.
+type ArtifactUpload struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+	// SHA256 is checked when the upload is complete, if the client sent it
+	SHA256  string    `json:"sha256,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+var (
+	errUploadNotFound = errors.New("upload not found, it finished, expired or was cancelled")
+	errUploadBusy     = errors.New("another chunk of the upload is being written")
+)
+
+// uploadOffsetError is returned if a chunk does not start where the upload ends
+type uploadOffsetError struct {
+	offset int64
+	got    int64
+}
+
+func (e uploadOffsetError) Error() string {
+	return fmt.Sprintf("the chunk starts at %d but the upload continues at %d", e.got, e.offset)
+}
+
+// uploadsDir keeps the partial content as <id>.part and the upload as <id>.json. It is in the store directory,
+// so completed uploads are renamed into the store without copying them.
+func (s *artifactStore) uploadsDir() string {
+	return filepath.Join(s.dir, "uploads")
+}
+
+func (s *artifactStore) uploadPath(id string, ext string) string {
+	return filepath.Join(s.uploadsDir(), id+ext)
+}
+
+// createUpload starts a resumable upload of size bytes and removes expired uploads
+func (s *artifactStore) createUpload(name string, size int64, sha string) (ArtifactUpload, error) {
+	if name == "" || size <= 0 {
+		return ArtifactUpload{}, errors.New("createUpload: name and a size greater than 0 are required")
+	}
+	if sha != "" && !validArtifactID(strings.ToLower(sha)) {
+		return ArtifactUpload{}, errors.New("createUpload: sha256 must be 64 hex characters")
+	}
+	err := os.MkdirAll(s.uploadsDir(), 0o755)
+	if err != nil {
+		return ArtifactUpload{}, fmt.Errorf("createUpload: %w", err)
+	}
+	s.pruneUploads(time.Now())
+	now := time.Now()
+	upload := ArtifactUpload{ID: uuid.New().String(), Name: filepath.Base(name), Size: size, SHA256: strings.ToLower(sha), Created: now, Expires: now.Add(artifactUploadTTL)}
+	f, err := os.Create(s.uploadPath(upload.ID, ".part"))
+	if err != nil {
+		return ArtifactUpload{}, fmt.Errorf("createUpload: %w", err)
+	}
+	f.Close()
+	err = os.WriteFile(s.uploadPath(upload.ID, ".json"), []byte(MustMarshal(upload)), 0o644)
+	if err != nil {
+		os.Remove(s.uploadPath(upload.ID, ".part"))
+		return ArtifactUpload{}, fmt.Errorf("createUpload: %w", err)
+	}
+	return upload, nil
+}
+
+// getUpload returns the upload, its offset is the size of the partial content on disk
+func (s *artifactStore) getUpload(id string) (ArtifactUpload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return ArtifactUpload{}, errUploadNotFound
+	}
+	data, err := os.ReadFile(s.uploadPath(id, ".json"))
+	if err != nil {
+		return ArtifactUpload{}, errUploadNotFound
+	}
+	var upload ArtifactUpload
+	err = json.Unmarshal(data, &upload)
+	if err != nil {
+		return ArtifactUpload{}, fmt.Errorf("getUpload: %w", err)
+	}
+	info, err := os.Stat(s.uploadPath(id, ".part"))
+	if err != nil {
+		return ArtifactUpload{}, errUploadNotFound
+	}
+	upload.Offset = info.Size()
+	upload.Expires = info.ModTime().Add(artifactUploadTTL)
+	return upload, nil
+}
+
+// writeUpload appends the chunk r that starts at offset. Bytes that arrived before r failed are kept,
+// so the client continues after them. It returns the artifact once all bytes arrived.
+func (s *artifactStore) writeUpload(id string, offset int64, r io.Reader) (ArtifactUpload, *Artifact, error) {
+	s.mu.Lock()
+	if s.writing[id] {
+		s.mu.Unlock()
+		return ArtifactUpload{}, nil, errUploadBusy
+	}
+	s.writing[id] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.writing, id)
+		s.mu.Unlock()
+	}()
+
+	upload, err := s.getUpload(id)
+	if err != nil {
+		return ArtifactUpload{}, nil, err
+	}
+	if offset != upload.Offset {
+		return upload, nil, uploadOffsetError{offset: upload.Offset, got: offset}
+	}
+	f, err := os.OpenFile(s.uploadPath(id, ".part"), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return upload, nil, fmt.Errorf("writeUpload: %w", err)
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(r, upload.Size-upload.Offset))
+	closeErr := f.Close()
+	upload.Offset += n
+	upload.Expires = time.Now().Add(artifactUploadTTL)
+	if copyErr != nil {
+		return upload, nil, fmt.Errorf("writeUpload: chunk interrupted at %d: %w", upload.Offset, copyErr)
+	}
+	if closeErr != nil {
+		return upload, nil, fmt.Errorf("writeUpload: %w", closeErr)
+	}
+	if upload.Offset < upload.Size {
+		return upload, nil, nil
+	}
+	artifact, err := s.completeUpload(upload)
+	if err != nil {
+		return upload, nil, err
+	}
+	return upload, &artifact, nil
+}
+
+// completeUpload hashes the content and moves it into the store
+func (s *artifactStore) completeUpload(upload ArtifactUpload) (Artifact, error) {
+	part := s.uploadPath(upload.ID, ".part")
+	f, err := os.Open(part)
+	if err != nil {
+		return Artifact{}, fmt.Errorf("completeUpload: %w", err)
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return Artifact{}, fmt.Errorf("completeUpload: %w", err)
+	}
+	id := hex.EncodeToString(h.Sum(nil))
+	if upload.SHA256 != "" && upload.SHA256 != id {
+		s.removeUpload(upload.ID)
+		return Artifact{}, fmt.Errorf("completeUpload: the sha256 of the upload is %s, not %s, upload it again", id, upload.SHA256)
+	}
+	artifact, err := s.add(part, id, upload.Size, upload.Name, "")
+	if err != nil {
+		return Artifact{}, fmt.Errorf("completeUpload: %w", err)
+	}
+	s.removeUpload(upload.ID)
+	return artifact, nil
+}
+
+func (s *artifactStore) removeUpload(id string) error {
+	if _, err := s.getUpload(id); err != nil {
+		return err
+	}
+	os.Remove(s.uploadPath(id, ".part"))
+	return os.Remove(s.uploadPath(id, ".json"))
+}
+
+// pruneUploads removes uploads that did not get a chunk for artifactUploadTTL
+func (s *artifactStore) pruneUploads(now time.Time) {
+	entries, err := os.ReadDir(s.uploadsDir())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		upload, err := s.getUpload(id)
+		if err == nil && now.Before(upload.Expires) {
+			continue
+		}
+		log.WithField("upload", id).Info("removing expired artifact upload")
+		os.Remove(s.uploadPath(id, ".part"))
+		os.Remove(s.uploadPath(id, ".json"))
+	}
+}
+
+// chunkOffset reads where a chunk starts from the tus Upload-Offset header or a Content-Range header
+// like "bytes 0-1023/4096"
+func chunkOffset(c *gin.Context, size int64) (int64, error) {
+	if value := c.GetHeader("Upload-Offset"); value != "" {
+		offset, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || offset < 0 {
+			return 0, fmt.Errorf("invalid Upload-Offset '%s'", value)
+		}
+		return offset, nil
+	}
+	value := c.GetHeader("Content-Range")
+	if value == "" {
+		return 0, errors.New("send the offset of the chunk in the Upload-Offset or Content-Range header")
+	}
+	var start, end, total int64
+	_, err := fmt.Sscanf(value, "bytes %d-%d/%d", &start, &end, &total)
+	if err != nil || start < 0 || end < start {
+		return 0, fmt.Errorf("invalid Content-Range '%s'", value)
+	}
+	if total != size {
+		return 0, fmt.Errorf("Content-Range '%s' does not match the upload size %d", value, size)
+	}
+	return start, nil
+}
+
+func setUploadHeaders(c *gin.Context, upload ArtifactUpload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	c.Header("Cache-Control", "no-store")
+}
+
+// CreateArtifactUpload starts a resumable upload
+// @Summary      Start a resumable artifact upload
+// @Description  Starts an upload of a large artifact, like an ipa, developer disk image or backup, that can be resumed after network errors. Send the chunks in order with PATCH and the Upload-Offset header, or with PUT and a Content-Range header. If a chunk fails, HEAD the upload and continue at its Upload-Offset. The response of the last chunk is the artifact. Uploads without chunks for 24 hours are removed.
+// @Tags         artifacts
+// @Produce      json
+// @Param        name query string true "file name, f.ex. app.ipa"
+// @Param        size query int false "size in bytes, or send the Upload-Length header"
+// @Param        sha256 query string false "sha256 of the content, checked when the upload is complete"
+// @Success      201  {object}  ArtifactUpload
+// @Failure      422  {object}  GenericResponse
+// @Router       /artifacts/uploads [post]
+func CreateArtifactUpload(c *gin.Context) {
+	sizeValue := c.Query("size")
+	if sizeValue == "" {
+		sizeValue = c.GetHeader("Upload-Length")
+	}
+	size, _ := strconv.ParseInt(sizeValue, 10, 64)
+	upload, err := artifacts.createUpload(c.Query("name"), size, c.Query("sha256"))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	setUploadHeaders(c, upload)
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+upload.ID)
+	c.JSON(http.StatusCreated, upload)
+}
+
+// GetArtifactUpload returns the offset to continue an upload at
+// @Summary      Get a resumable artifact upload
+// @Description  Returns the upload with the offset the next chunk has to start at, also in the Upload-Offset header. HEAD returns only the headers.
+// @Tags         artifacts
+// @Produce      json
+// @Param        id path string true "Upload id"
+// @Success      200  {object}  ArtifactUpload
+// @Failure      404  {object}  GenericResponse
+// @Router       /artifacts/uploads/{id} [get]
+func GetArtifactUpload(c *gin.Context) {
+	upload, err := artifacts.getUpload(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: err.Error()})
+		return
+	}
+	setUploadHeaders(c, upload)
+	if c.Request.Method == http.MethodHead {
+		c.Status(http.StatusOK)
+		return
+	}
+	c.JSON(http.StatusOK, upload)
+}
+
+// WriteArtifactUpload appends a chunk to an upload
+// @Summary      Upload a chunk of a resumable artifact upload
+// @Description  Appends the body at the offset of the Upload-Offset or Content-Range header, which must be the current offset of the upload. Returns the upload with its new offset, or the artifact with status 201 after the last chunk. A chunk at the wrong offset returns 409 with the current offset.
+// @Tags         artifacts
+// @Accept       application/offset+octet-stream
+// @Produce      json
+// @Param        id path string true "Upload id"
+// @Success      200  {object}  ArtifactUpload
+// @Success      201  {object}  Artifact
+// @Failure      404  {object}  GenericResponse
+// @Failure      409  {object}  GenericResponse
+// @Failure      413  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /artifacts/uploads/{id} [patch]
+func WriteArtifactUpload(c *gin.Context) {
+	upload, err := artifacts.getUpload(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: err.Error()})
+		return
+	}
+	offset, err := chunkOffset(c, upload.Size)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	if c.Request.ContentLength > upload.Size-offset {
+		c.JSON(http.StatusRequestEntityTooLarge, GenericResponse{Error: fmt.Sprintf("the chunk ends after the upload size %d", upload.Size)})
+		return
+	}
+	upload, artifact, err := artifacts.writeUpload(upload.ID, offset, c.Request.Body)
+	var offsetErr uploadOffsetError
+	switch {
+	case errors.Is(err, errUploadNotFound):
+		c.JSON(http.StatusNotFound, GenericResponse{Error: err.Error()})
+	case errors.Is(err, errUploadBusy):
+		c.JSON(http.StatusConflict, GenericResponse{Error: err.Error()})
+	case errors.As(err, &offsetErr):
+		setUploadHeaders(c, upload)
+		c.JSON(http.StatusConflict, GenericResponse{Error: err.Error()})
+	case err != nil:
+		// the client may be gone already, if not it learns where to continue
+		setUploadHeaders(c, upload)
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+	case artifact != nil:
+		c.JSON(http.StatusCreated, artifact)
+	default:
+		setUploadHeaders(c, upload)
+		c.JSON(http.StatusOK, upload)
+	}
+}
+
+// CancelArtifactUpload removes an upload and its partial content
+// @Summary      Cancel a resumable artifact upload
+// @Tags         artifacts
+// @Produce      json
+// @Param        id path string true "Upload id"
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Router       /artifacts/uploads/{id} [delete]
+func CancelArtifactUpload(c *gin.Context) {
+	err := artifacts.removeUpload(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "upload cancelled"})
+}
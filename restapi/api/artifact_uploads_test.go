@@ -0,0 +1,117 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// droppedConnection returns content and then fails like a connection that went away
+type droppedConnection struct {
+	content io.Reader
+}
+
+func (d droppedConnection) Read(p []byte) (int, error) {
+	n, err := d.content.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset by peer")
+	}
+	return n, err
+}
+
+func TestResumableArtifactUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := artifacts
+	artifacts = newArtifactStore(t.TempDir())
+	defer func() { artifacts = original }()
+	r := gin.New()
+	artifactRoutes(r.Group("/api/v1"))
+	do := func(method string, target string, body io.Reader, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, body)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	content := "a large ipa that is uploaded in chunks"
+	sum := sha256.Sum256([]byte(content))
+
+	w := do(http.MethodPost, "/api/v1/artifacts/uploads?name=app.ipa&sha256="+hex.EncodeToString(sum[:]), nil, "Upload-Length", "38")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var upload ArtifactUpload
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &upload))
+	location := w.Header().Get("Location")
+	assert.Equal(t, "/api/v1/artifacts/uploads/"+upload.ID, location)
+	assert.Equal(t, int64(38), upload.Size)
+
+	w = do(http.MethodPatch, location, droppedConnection{strings.NewReader(content[:10])}, "Upload-Offset", "0")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	w = do(http.MethodHead, location, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Upload-Offset"), "bytes before the connection dropped are kept")
+
+	w = do(http.MethodPatch, location, strings.NewReader(content[5:20]), "Upload-Offset", "5")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Upload-Offset"))
+	w = do(http.MethodPatch, location, strings.NewReader(content[10:]+"extra"), "Upload-Offset", "10")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// a restarted API continues the upload
+	artifacts = newArtifactStore(artifacts.dir)
+	w = do(http.MethodPut, location, strings.NewReader(content[10:20]), "Content-Range", "bytes 10-19/38")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &upload))
+	assert.Equal(t, int64(20), upload.Offset)
+	w = do(http.MethodPatch, location, strings.NewReader(content[20:]), "Upload-Offset", "20")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var artifact Artifact
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &artifact))
+	assert.Equal(t, hex.EncodeToString(sum[:]), artifact.ID)
+	assert.Equal(t, "app.ipa", artifact.Name)
+	stored, err := os.ReadFile(artifacts.path(artifact))
+	require.NoError(t, err)
+	assert.Equal(t, content, string(stored))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, location, nil).Code, "completed uploads are removed")
+}
+
+func TestResumableArtifactUploadChecksSHA256(t *testing.T) {
+	store := newArtifactStore(t.TempDir())
+	upload, err := store.createUpload("backup.tar", 4, strings.Repeat("0", 64))
+	require.NoError(t, err)
+	_, _, err = store.writeUpload(upload.ID, 0, strings.NewReader("data"))
+	assert.ErrorContains(t, err, "sha256")
+	_, err = store.getUpload(upload.ID)
+	assert.ErrorIs(t, err, errUploadNotFound)
+	list, err := store.list()
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	_, err = store.createUpload("backup.tar", 0, "")
+	assert.Error(t, err)
+}
+
+func TestPruneArtifactUploads(t *testing.T) {
+	store := newArtifactStore(t.TempDir())
+	upload, err := store.createUpload("image.dmg", 10, "")
+	require.NoError(t, err)
+	store.pruneUploads(time.Now())
+	_, err = store.getUpload(upload.ID)
+	assert.NoError(t, err)
+	store.pruneUploads(time.Now().Add(artifactUploadTTL + time.Minute))
+	_, err = store.getUpload(upload.ID)
+	assert.ErrorIs(t, err, errUploadNotFound)
+}
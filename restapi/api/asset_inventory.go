@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/diagnostics"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// assetMaxAge is how long collected asset information is used before it is collected again
+	assetMaxAge          = 24 * time.Hour
+	assetRefreshInterval = time.Hour
+	diskUsageDomain      = "com.apple.disk_usage"
+)
+
+// DeviceAsset contains the inventory relevant information of a device
+type DeviceAsset struct {
+	UDID                  string    `json:"udid"`
+	SerialNumber          string    `json:"serialNumber,omitempty"`
+	DeviceName            string    `json:"deviceName,omitempty"`
+	DeviceClass           string    `json:"deviceClass,omitempty"`
+	ProductType           string    `json:"productType,omitempty"`
+	ModelNumber           string    `json:"modelNumber,omitempty"`
+	HardwareModel         string    `json:"hardwareModel,omitempty"`
+	OSVersion             string    `json:"osVersion,omitempty"`
+	BuildVersion          string    `json:"buildVersion,omitempty"`
+	TotalDiskCapacity     int64     `json:"totalDiskCapacity,omitempty"`
+	AvailableDiskCapacity int64     `json:"availableDiskCapacity,omitempty"`
+	BatteryCycleCount     int64     `json:"batteryCycleCount,omitempty"`
+	BatteryHealthPercent  int64     `json:"batteryHealthPercent,omitempty"`
+	CollectedAt           time.Time `json:"collectedAt"`
+}
+
+// assetCache keeps the collected DeviceAssets, so exports never need to talk to devices
+type assetCache struct {
+	mu     sync.RWMutex
+	assets map[string]DeviceAsset
+}
+
+var assets = &assetCache{assets: map[string]DeviceAsset{}}
+
+func (a *assetCache) get(udid string) (DeviceAsset, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	asset, ok := a.assets[udid]
+	return asset, ok
+}
+
+func (a *assetCache) put(asset DeviceAsset) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.assets[asset.UDID] = asset
+}
+
+// collectFromRegistry collects the assets of devices when they are added to the registry and refreshes
+// assets older than assetMaxAge, until ctx is done.
+func (a *assetCache) collectFromRegistry(ctx context.Context, registry *DeviceRegistry) {
+	changes, unsubscribe := registry.Subscribe()
+	defer unsubscribe()
+	a.refresh(registry)
+	ticker := time.NewTicker(assetRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			if change.Type == DeviceAdded {
+				go a.collect(change.Device)
+			}
+		case <-ticker.C:
+			a.refresh(registry)
+		}
+	}
+}
+
+func (a *assetCache) refresh(registry *DeviceRegistry) {
+	registry.Range(func(device ios.DeviceEntry) bool {
+		asset, ok := a.get(device.Properties.SerialNumber)
+		if !ok || time.Since(asset.CollectedAt) > assetMaxAge {
+			a.collect(device)
+		}
+		return true
+	})
+}
+
+func (a *assetCache) collect(device ios.DeviceEntry) {
+	asset, err := collectDeviceAsset(device)
+	if err != nil {
+		log.WithField("udid", device.Properties.SerialNumber).WithError(err).Debug("could not collect device asset")
+		return
+	}
+	a.put(asset)
+}
+
+// collectDeviceAsset reads the lockdown values, disk usage and battery gas gauge of a device.
+// Disk usage and battery health are optional, devices without a mounted developer image or
+// an older iOS version might not provide them.
+func collectDeviceAsset(device ios.DeviceEntry) (DeviceAsset, error) {
+	lockdown, err := ios.ConnectLockdownWithSession(device)
+	if err != nil {
+		return DeviceAsset{}, err
+	}
+	defer lockdown.Close()
+	values, err := lockdown.GetValues()
+	if err != nil {
+		return DeviceAsset{}, err
+	}
+	asset := DeviceAsset{
+		UDID:          device.Properties.SerialNumber,
+		SerialNumber:  values.Value.SerialNumber,
+		DeviceName:    values.Value.DeviceName,
+		DeviceClass:   values.Value.DeviceClass,
+		ProductType:   values.Value.ProductType,
+		ModelNumber:   values.Value.ModelNumber,
+		HardwareModel: values.Value.HardwareModel,
+		OSVersion:     values.Value.ProductVersion,
+		BuildVersion:  values.Value.BuildVersion,
+		CollectedAt:   time.Now(),
+	}
+	if total, err := lockdown.GetValueForDomain("TotalDiskCapacity", diskUsageDomain); err == nil {
+		asset.TotalDiskCapacity = toInt64(total)
+	}
+	if available, err := lockdown.GetValueForDomain("AmountDataAvailable", diskUsageDomain); err == nil {
+		asset.AvailableDiskCapacity = toInt64(available)
+	}
+
+	diagnosticsConn, err := diagnostics.New(device)
+	if err != nil {
+		return asset, nil
+	}
+	defer diagnosticsConn.Close()
+	allValues, err := diagnosticsConn.AllValues()
+	if err != nil {
+		return asset, nil
+	}
+	gasGauge := allValues.Diagnostics.GasGauge
+	asset.BatteryCycleCount = int64(gasGauge.CycleCount)
+	if gasGauge.DesignCapacity > 0 {
+		asset.BatteryHealthPercent = int64(gasGauge.FullChargeCapacity * 100 / gasGauge.DesignCapacity)
+	}
+	return asset, nil
+}
+
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case uint64:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	}
+	return 0
+}
+
+// cycloneDXBom is the subset of the CycloneDX 1.5 JSON format needed to describe the device fleet
+type cycloneDXBom struct {
+	BomFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string `json:"timestamp"`
+	Tools     struct {
+		Components []cycloneDXComponent `json:"components"`
+	} `json:"tools"`
+}
+
+type cycloneDXComponent struct {
+	Type         string              `json:"type"`
+	BomRef       string              `json:"bom-ref,omitempty"`
+	Name         string              `json:"name"`
+	Version      string              `json:"version,omitempty"`
+	Description  string              `json:"description,omitempty"`
+	Manufacturer *cycloneDXEntity    `json:"manufacturer,omitempty"`
+	Properties   []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXEntity struct {
+	Name string `json:"name"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func newCycloneDXBom(deviceAssets []DeviceAsset) cycloneDXBom {
+	bom := cycloneDXBom{
+		BomFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + uuid.New().String(),
+		Version:      1,
+		Components:   []cycloneDXComponent{},
+	}
+	bom.Metadata.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	bom.Metadata.Tools.Components = []cycloneDXComponent{{Type: "application", Name: "go-ios", Version: strings.TrimSpace(GetVersion())}}
+	for _, asset := range deviceAssets {
+		name := asset.ProductType
+		if name == "" {
+			name = asset.UDID
+		}
+		component := cycloneDXComponent{
+			Type:         "device",
+			BomRef:       asset.UDID,
+			Name:         name,
+			Version:      asset.OSVersion,
+			Description:  asset.DeviceName,
+			Manufacturer: &cycloneDXEntity{Name: "Apple Inc."},
+		}
+		property := func(name string, value string) {
+			if value != "" && value != "0" {
+				component.Properties = append(component.Properties, cycloneDXProperty{Name: "go-ios:" + name, Value: value})
+			}
+		}
+		property("udid", asset.UDID)
+		property("serialNumber", asset.SerialNumber)
+		property("deviceClass", asset.DeviceClass)
+		property("modelNumber", asset.ModelNumber)
+		property("hardwareModel", asset.HardwareModel)
+		property("buildVersion", asset.BuildVersion)
+		property("totalDiskCapacity", strconv.FormatInt(asset.TotalDiskCapacity, 10))
+		property("availableDiskCapacity", strconv.FormatInt(asset.AvailableDiskCapacity, 10))
+		property("batteryCycleCount", strconv.FormatInt(asset.BatteryCycleCount, 10))
+		property("batteryHealthPercent", strconv.FormatInt(asset.BatteryHealthPercent, 10))
+		if !asset.CollectedAt.IsZero() {
+			property("collectedAt", asset.CollectedAt.UTC().Format(time.RFC3339))
+		}
+		bom.Components = append(bom.Components, component)
+	}
+	return bom
+}
+
+// fleetAssets returns the cached assets of all devices in the registry. Devices whose asset was
+// not collected yet are only listed with their udid.
+func fleetAssets(registry *DeviceRegistry) []DeviceAsset {
+	result := []DeviceAsset{}
+	registry.Range(func(device ios.DeviceEntry) bool {
+		asset, ok := assets.get(device.Properties.SerialNumber)
+		if !ok {
+			asset = DeviceAsset{UDID: device.Properties.SerialNumber}
+		}
+		result = append(result, asset)
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].UDID < result[j].UDID })
+	return result
+}
+
+// ExportInventory exports the device fleet as asset inventory
+// @Summary      Export the device inventory
+// @Description  Exports serials, models, OS versions, storage and battery health of all devices as CycloneDX 1.5 BOM (default) or plain JSON. The data is collected when a device is attached and refreshed once a day, the export never talks to devices.
+// @Tags         general
+// @Produce      json
+// @Param        format query string false "cyclonedx or json"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      422  {object}  GenericResponse
+// @Router       /inventory/export [get]
+func ExportInventory(c *gin.Context) {
+	fleet := fleetAssets(devices)
+	switch c.DefaultQuery("format", "cyclonedx") {
+	case "cyclonedx":
+		c.Header("Content-Disposition", `attachment; filename="go-ios-inventory.cdx.json"`)
+		c.IndentedJSON(http.StatusOK, newCycloneDXBom(fleet))
+	case "json":
+		c.IndentedJSON(http.StatusOK, fleet)
+	default:
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "format must be cyclonedx or json"})
+	}
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCycloneDXBom(t *testing.T) {
+	bom := newCycloneDXBom([]DeviceAsset{
+		{UDID: "a", ProductType: "iPhone14,2", OSVersion: "17.4", SerialNumber: "F00", BatteryHealthPercent: 87},
+		{UDID: "b"},
+	})
+	assert.Equal(t, "CycloneDX", bom.BomFormat)
+	assert.Equal(t, "1.5", bom.SpecVersion)
+	assert.Len(t, bom.Components, 2)
+
+	phone := bom.Components[0]
+	assert.Equal(t, "device", phone.Type)
+	assert.Equal(t, "iPhone14,2", phone.Name)
+	assert.Equal(t, "17.4", phone.Version)
+	assert.Contains(t, phone.Properties, cycloneDXProperty{Name: "go-ios:serialNumber", Value: "F00"})
+	assert.Contains(t, phone.Properties, cycloneDXProperty{Name: "go-ios:batteryHealthPercent", Value: "87"})
+	assert.NotContains(t, phone.Properties, cycloneDXProperty{Name: "go-ios:totalDiskCapacity", Value: "0"})
+
+	// devices without collected assets are still part of the inventory
+	assert.Equal(t, "b", bom.Components[1].Name)
+}
@@ -0,0 +1,346 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/danielpaulus/go-ios/ios/secrets"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// authEnvVar is the path of a json AuthConfig. All /api routes are open if it is not set.
+const authEnvVar = "GO_IOS_AUTH"
+
+// principalKey is the gin context key of the authenticated Principal
+const principalKey = "principal"
+
+// Scope is what a principal is allowed to do. Every scope includes the scopes before it.
+type Scope string
+
+const (
+	// ScopeRead allows GET requests, like listing devices or taking screenshots
+	ScopeRead = Scope("read")
+	// ScopeControl allows all other requests, like installing apps or rebooting devices
+	ScopeControl = Scope("control")
+	// ScopeAdmin allows the admin endpoints, like changing webhooks or the debug endpoints
+	ScopeAdmin = Scope("admin")
+)
+
+var scopeRanks = map[Scope]int{ScopeRead: 1, ScopeControl: 2, ScopeAdmin: 3}
+
+// Includes reports whether s allows what required allows
+func (s Scope) Includes(required Scope) bool {
+	return scopeRanks[s] > 0 && scopeRanks[s] >= scopeRanks[required]
+}
+
+// Principal is who made a request
+type Principal struct {
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// Has reports whether any of the scopes of the principal includes required
+func (p Principal) Has(required Scope) bool {
+	for _, scope := range p.Scopes {
+		if scope.Includes(required) {
+			return true
+		}
+	}
+	return false
+}
+
+// errNoCredentials is returned by an Authenticator if the request has no credentials it understands
+var errNoCredentials = errors.New("no credentials")
+
+// Authenticator checks the credentials of a request. It returns errNoCredentials if the request has none it
+// understands, so the next Authenticator is tried.
+type Authenticator interface {
+	Authenticate(c *gin.Context) (Principal, error)
+}
+
+// AuthConfig configures the authentication of the REST API
+type AuthConfig struct {
+	APIKeys []APIKey   `json:"apiKeys,omitempty"`
+	JWT     *JWTConfig `json:"jwt,omitempty"`
+}
+
+// APIKey is a static key, sent as bearer token or in the X-API-Key header
+type APIKey struct {
+	// Name identifies the key in logs
+	Name string `json:"name"`
+	// Key can be sealed with 'ios secrets seal-value'
+	Key    string  `json:"key"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// authenticators authenticates requests to /api with the configured Authenticators
+type authenticators struct {
+	mu   sync.Mutex
+	list []Authenticator
+}
+
+var authn = &authenticators{}
+
+// AddAuthenticator enables authentication with a custom Authenticator, f.ex. for programs embedding the REST API
+// that use client certificates. Authenticators are tried in the order they were added.
+func AddAuthenticator(a Authenticator) {
+	authn.mu.Lock()
+	defer authn.mu.Unlock()
+	authn.list = append(authn.list, a)
+}
+
+func (a *authenticators) enabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.list) > 0
+}
+
+func (a *authenticators) authenticate(c *gin.Context) (Principal, error) {
+	a.mu.Lock()
+	list := a.list
+	a.mu.Unlock()
+	for _, authenticator := range list {
+		principal, err := authenticator.Authenticate(c)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return Principal{}, errNoCredentials
+}
+
+// requestToken returns the bearer token or api key of the request. The access_token query parameter is accepted
+// for GET requests, browsers can't set headers for EventSource and websocket requests. WebDAV clients only
+// support basic auth, they send the token as password.
+func requestToken(c *gin.Context) string {
+	if _, password, ok := c.Request.BasicAuth(); ok {
+		return password
+	}
+	if token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); token != "" {
+		return token
+	}
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if c.Request.Method == http.MethodGet {
+		return c.Query("access_token")
+	}
+	return ""
+}
+
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// apiKeyAuthenticator accepts static api keys
+type apiKeyAuthenticator struct {
+	keys []APIKey
+}
+
+func (a apiKeyAuthenticator) Authenticate(c *gin.Context) (Principal, error) {
+	token := requestToken(c)
+	if token == "" || isJWT(token) {
+		return Principal{}, errNoCredentials
+	}
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.Key)) == 1 {
+			return Principal{Name: key.Name, Scopes: key.Scopes}, nil
+		}
+	}
+	return Principal{}, errors.New("invalid api key")
+}
+
+// jwtAuthenticator accepts bearer JWTs
+type jwtAuthenticator struct {
+	verifier *jwtVerifier
+}
+
+func (a jwtAuthenticator) Authenticate(c *gin.Context) (Principal, error) {
+	token := requestToken(c)
+	if !isJWT(token) {
+		return Principal{}, errNoCredentials
+	}
+	claims, err := a.verifier.verify(token)
+	if err != nil {
+		return Principal{}, err
+	}
+	principal := Principal{Name: claims.Subject}
+	for _, scope := range append(strings.Fields(claims.Scope), claims.Scopes...) {
+		principal.Scopes = append(principal.Scopes, Scope(scope))
+	}
+	return principal, nil
+}
+
+// authenticatorsFromConfig opens the secrets of the config. The admin token is added as api key with the admin
+// scope, so it keeps working once authentication is enabled.
+func authenticatorsFromConfig(config AuthConfig) ([]Authenticator, error) {
+	keys, err := sealedSecrets.keys()
+	if err != nil {
+		return nil, err
+	}
+	var apiKeys []APIKey
+	for _, key := range config.APIKeys {
+		if key.Key == "" {
+			return nil, fmt.Errorf("api key '%s' is empty", key.Name)
+		}
+		for _, scope := range key.Scopes {
+			if scopeRanks[scope] == 0 {
+				return nil, fmt.Errorf("api key '%s' has invalid scope '%s'", key.Name, scope)
+			}
+		}
+		key.Key, err = secrets.OpenString(keys, key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed opening api key '%s': %w", key.Name, err)
+		}
+		apiKeys = append(apiKeys, key)
+	}
+	adminToken, err := sealedSecrets.env(adminTokenEnvVar)
+	if err != nil {
+		return nil, err
+	}
+	if adminToken != "" {
+		apiKeys = append(apiKeys, APIKey{Name: "admin", Key: adminToken, Scopes: []Scope{ScopeAdmin}})
+	}
+	var result []Authenticator
+	if len(apiKeys) > 0 {
+		result = append(result, apiKeyAuthenticator{keys: apiKeys})
+	}
+	if config.JWT != nil {
+		secret, err := secrets.OpenString(keys, config.JWT.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed opening jwt secret: %w", err)
+		}
+		verifier, err := newJWTVerifier(*config.JWT, secret)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, jwtAuthenticator{verifier: verifier})
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no api keys or jwt configured")
+	}
+	return result, nil
+}
+
+// loadAuth enables the authentication configured with GO_IOS_AUTH. The server refuses to start if the config
+// is invalid, falling back to no authentication would expose all devices.
+func loadAuth() error {
+	path := os.Getenv(authEnvVar)
+	if path == "" {
+		log.Warnf("%s is not set, the REST API does not require authentication", authEnvVar)
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config AuthConfig
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return fmt.Errorf("invalid auth config in %s: %w", path, err)
+	}
+	list, err := authenticatorsFromConfig(config)
+	if err != nil {
+		return fmt.Errorf("invalid auth config in %s: %w", path, err)
+	}
+	for _, a := range list {
+		AddAuthenticator(a)
+	}
+	return nil
+}
+
+// requiredScope is read for GET, HEAD, OPTIONS and WebDAV PROPFIND requests and control for all others
+func requiredScope(c *gin.Context) Scope {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return ScopeRead
+	default:
+		return ScopeControl
+	}
+}
+
+// requestPrincipal returns the principal AuthMiddleware authenticated
+func requestPrincipal(c *gin.Context) (Principal, bool) {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return Principal{}, false
+	}
+	principal, ok := value.(Principal)
+	return principal, ok
+}
+
+// AuthMiddleware authenticates requests with the configured Authenticators and checks that the principal has
+// the read scope for GET requests and the control scope for all others. Routes needing more use RequireScope.
+// Will return 401 if the credentials are missing or invalid and 403 if a scope is missing. Requests pass
+// unauthenticated if no Authenticator is configured.
+func AuthMiddleware() gin.HandlerFunc {
+	return authMiddleware(func(c *gin.Context, status int, message string) {
+		c.AbortWithStatusJSON(status, GenericResponse{Error: message})
+	})
+}
+
+// AuthMiddlewareV2 is AuthMiddleware with v2 errors
+func AuthMiddlewareV2() gin.HandlerFunc {
+	return authMiddleware(func(c *gin.Context, status int, message string) {
+		code := ErrorCodeUnauthorized
+		if status == http.StatusForbidden {
+			code = ErrorCodeForbidden
+		}
+		abortWithAPIError(c, status, APIError{Code: code, Message: message})
+	})
+}
+
+func authMiddleware(abort func(c *gin.Context, status int, message string)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authn.enabled() {
+			c.Next()
+			return
+		}
+		principal, err := authn.authenticate(c)
+		if err != nil {
+			if isDAVRoute(c) {
+				c.Header("WWW-Authenticate", `Basic realm="go-ios"`)
+			} else {
+				c.Header("WWW-Authenticate", `Bearer realm="go-ios"`)
+			}
+			if errors.Is(err, errNoCredentials) {
+				abort(c, http.StatusUnauthorized, "authentication required, send an api key or jwt as bearer token")
+				return
+			}
+			log.WithError(err).WithField("path", c.FullPath()).Warn("authentication failed")
+			abort(c, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+		c.Set(principalKey, principal)
+		required := requiredScope(c)
+		if !principal.Has(required) {
+			abort(c, http.StatusForbidden, fmt.Sprintf("'%s' needs the %s scope", principal.Name, required))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope lets only requests pass whose principal has the scope. Requests pass if authentication is not
+// enabled.
+func RequireScope(scope Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authn.enabled() {
+			c.Next()
+			return
+		}
+		principal, ok := requestPrincipal(c)
+		if !ok || !principal.Has(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, GenericResponse{Error: fmt.Sprintf("'%s' needs the %s scope", principal.Name, scope)})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/secrets"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signJWT(t *testing.T, alg string, claims map[string]interface{}, sign func(signed []byte) []byte) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256(secret string) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func authRouter(t *testing.T, list ...Authenticator) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	previous := authn
+	authn = &authenticators{list: list}
+	t.Cleanup(func() { authn = previous })
+	r := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	v1 := r.Group("/api/v1", AuthMiddleware())
+	v1.GET("/devices", ok)
+	v1.POST("/devices", ok)
+	v1.PUT("/webhooks", AdminMiddleware(), ok)
+	r.GET("/api/v2/devices", AuthMiddlewareV2(), ok)
+	return r
+}
+
+func authRequest(r *gin.Engine, method string, path string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthDisabled(t *testing.T) {
+	r := authRouter(t)
+	assert.Equal(t, http.StatusOK, authRequest(r, http.MethodPost, "/api/v1/devices", "").Code)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	t.Setenv(secrets.KMSEnvVar, "")
+	t.Setenv(adminTokenEnvVar, "admin-token")
+	list, err := authenticatorsFromConfig(AuthConfig{APIKeys: []APIKey{
+		{Name: "dashboard", Key: "read-key", Scopes: []Scope{ScopeRead}},
+		{Name: "ci", Key: "control-key", Scopes: []Scope{ScopeControl}},
+	}})
+	require.NoError(t, err)
+	r := authRouter(t, list...)
+
+	w := authRequest(r, http.MethodGet, "/api/v1/devices", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, authRequest(r, http.MethodGet, "/api/v1/devices", "wrong").Code)
+	assert.Equal(t, http.StatusOK, authRequest(r, http.MethodGet, "/api/v1/devices", "read-key").Code)
+	assert.Equal(t, http.StatusForbidden, authRequest(r, http.MethodPost, "/api/v1/devices", "read-key").Code)
+	assert.Equal(t, http.StatusOK, authRequest(r, http.MethodPost, "/api/v1/devices", "control-key").Code)
+	assert.Equal(t, http.StatusForbidden, authRequest(r, http.MethodPut, "/api/v1/webhooks", "control-key").Code)
+	assert.Equal(t, http.StatusOK, authRequest(r, http.MethodPut, "/api/v1/webhooks", "admin-token").Code, "the admin token is an admin api key")
+	assert.Equal(t, http.StatusOK, authRequest(r, http.MethodGet, "/api/v1/devices?access_token=read-key", "").Code)
+
+	w = authRequest(r, http.MethodGet, "/api/v2/devices", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"unauthorized"`)
+
+	_, err = authenticatorsFromConfig(AuthConfig{APIKeys: []APIKey{{Name: "bad", Key: "k", Scopes: []Scope{"root"}}}})
+	assert.Error(t, err)
+}
+
+func TestJWT(t *testing.T) {
+	t.Setenv(secrets.KMSEnvVar, "")
+	t.Setenv(adminTokenEnvVar, "")
+	list, err := authenticatorsFromConfig(AuthConfig{JWT: &JWTConfig{Secret: "jwt-secret", Issuer: "lab", Audience: "go-ios"}})
+	require.NoError(t, err)
+	r := authRouter(t, list...)
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	claims := func(modify func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{"sub": "ci", "iss": "lab", "aud": []string{"go-ios"}, "exp": exp, "scope": "read control"}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	assert.Equal(t, http.StatusOK, authRequest(r, http.MethodPost, "/api/v1/devices", signJWT(t, "HS256", claims(nil), hs256("jwt-secret"))).Code)
+	assert.Equal(t, http.StatusForbidden, authRequest(r, http.MethodPut, "/api/v1/webhooks", signJWT(t, "HS256", claims(nil), hs256("jwt-secret"))).Code)
+	invalid := map[string]string{
+		"wrong secret": signJWT(t, "HS256", claims(nil), hs256("other")),
+		"alg none":     signJWT(t, "none", claims(nil), func([]byte) []byte { return nil }),
+		"expired":      signJWT(t, "HS256", claims(func(c map[string]interface{}) { c["exp"] = float64(time.Now().Add(-time.Hour).Unix()) }), hs256("jwt-secret")),
+		"no exp":       signJWT(t, "HS256", claims(func(c map[string]interface{}) { delete(c, "exp") }), hs256("jwt-secret")),
+		"issuer":       signJWT(t, "HS256", claims(func(c map[string]interface{}) { c["iss"] = "other" }), hs256("jwt-secret")),
+		"audience":     signJWT(t, "HS256", claims(func(c map[string]interface{}) { c["aud"] = "other" }), hs256("jwt-secret")),
+	}
+	for name, token := range invalid {
+		assert.Equal(t, http.StatusUnauthorized, authRequest(r, http.MethodGet, "/api/v1/devices", token).Code, name)
+	}
+}
+
+func TestJWTPublicKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+	verifier, err := newJWTVerifier(JWTConfig{PublicKeyFiles: []string{path}}, "")
+	require.NoError(t, err)
+
+	es256 := func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		require.NoError(t, err)
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature
+	}
+	token := signJWT(t, "ES256", map[string]interface{}{"sub": "dashboard", "exp": float64(time.Now().Add(time.Hour).Unix()), "scopes": []string{"admin"}}, es256)
+	claims, err := verifier.verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, "dashboard", claims.Subject)
+	assert.Equal(t, []string{"admin"}, claims.Scopes)
+
+	_, err = verifier.verify(signJWT(t, "HS256", map[string]interface{}{"exp": float64(time.Now().Add(time.Hour).Unix())}, hs256("")))
+	assert.Error(t, err, "hmac tokens need a secret")
+	_, err = newJWTVerifier(JWTConfig{}, "")
+	assert.Error(t, err)
+}
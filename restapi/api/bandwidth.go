@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+
+	"github.com/danielpaulus/go-ios/ios/ratelimit"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// bandwidthEnvVar configures the bandwidth limits on startup with the same JSON that PUT /config/bandwidth accepts,
+// f.ex. {"global": 50000000, "perTransfer": 10000000} to limit all transfers to 50MB/s and each of them to 10MB/s
+const bandwidthEnvVar = "GO_IOS_BANDWIDTH"
+
+// loadBandwidthLimits applies the limits configured with GO_IOS_BANDWIDTH
+func loadBandwidthLimits() {
+	config := os.Getenv(bandwidthEnvVar)
+	if config == "" {
+		return
+	}
+	var limits ratelimit.Limits
+	err := json.Unmarshal([]byte(config), &limits)
+	if err == nil {
+		err = ratelimit.SetLimits(limits)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("ignoring invalid %s", bandwidthEnvVar)
+	}
+}
+
+// limitedResponseWriter limits the bandwidth of the body written to the response. It does not implement
+// io.ReaderFrom, so files served with it are copied through Write instead of sendfile.
+type limitedResponseWriter struct {
+	http.ResponseWriter
+	body io.Writer
+}
+
+func (w limitedResponseWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+// serveLimited serves a file as an attachment with the bandwidth limits of a transfer
+func serveLimited(c *gin.Context, path string, name string) {
+	f, err := os.Open(path)
+	if err != nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w := limitedResponseWriter{ResponseWriter: c.Writer, body: ratelimit.NewTransfer().Writer(c.Request.Context(), c.Writer)}
+	http.ServeContent(w, c.Request, name, info.ModTime(), f)
+}
+
+// Get the bandwidth limits
+// @Summary      Get the bandwidth limits
+// @Description  Returns the bandwidth limits of AFC transfers and shared artifact downloads in bytes per second, 0 is unlimited.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  ratelimit.Limits
+// @Router       /config/bandwidth [get]
+func GetBandwidthLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, ratelimit.CurrentLimits())
+}
+
+// Change the bandwidth limits
+// @Summary      Change the bandwidth limits
+// @Description  Limits the bandwidth of AFC transfers and shared artifact downloads in bytes per second, for all of them together and for each of them. 0 is unlimited. The global limit applies to running transfers right away. Needs the admin token.
+// @Tags         general
+// @Accept       json
+// @Produce      json
+// @Param        limits body ratelimit.Limits true "Limits in bytes per second"
+// @Success      200  {object}  ratelimit.Limits
+// @Failure      401  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /config/bandwidth [put]
+func SetBandwidthLimits(c *gin.Context) {
+	var limits ratelimit.Limits
+	err := c.ShouldBindJSON(&limits)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	err = ratelimit.SetLimits(limits)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ratelimit.CurrentLimits())
+}
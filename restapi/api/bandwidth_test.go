@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer ratelimit.SetLimits(ratelimit.Limits{})
+	r := gin.New()
+	r.GET("/config/bandwidth", GetBandwidthLimits)
+	r.PUT("/config/bandwidth", SetBandwidthLimits)
+	put := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/bandwidth", strings.NewReader(body)))
+		return w
+	}
+
+	w := put(`{"global": 50000000, "perTransfer": 10000000}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"global": 50000000, "perTransfer": 10000000}`, w.Body.String())
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`{"global": -1}`).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`fast`).Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config/bandwidth", nil))
+	assert.JSONEq(t, `{"global": 50000000, "perTransfer": 10000000}`, w.Body.String())
+
+	t.Setenv(bandwidthEnvVar, `{"perTransfer": 1000}`)
+	loadBandwidthLimits()
+	assert.Equal(t, ratelimit.Limits{PerTransfer: 1000}, ratelimit.CurrentLimits())
+}
+
+func TestServeLimitedSupportsRanges(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	path := filepath.Join(t.TempDir(), "backup.tar")
+	require.NoError(t, os.WriteFile(path, []byte("0123456789"), 0o644))
+	r := gin.New()
+	r.GET("/download", func(c *gin.Context) { serveLimited(c, path, "backup.tar") })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/download", nil)
+	req.Header.Set("Range", "bytes=4-")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "456789", w.Body.String())
+	assert.Equal(t, "attachment; filename=backup.tar", w.Header().Get("Content-Disposition"))
+}
@@ -0,0 +1,414 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/afc"
+	"github.com/gin-gonic/gin"
+)
+
+// barcodeTimeout is how long scanning a screenshot or generating a QR code may take
+const barcodeTimeout = 30 * time.Second
+
+// photosDir is where images pushed to the device are stored, relative to the media directory of AFC
+const photosDir = "/DCIM/100APPLE"
+
+// maxPhotoSize is the largest image that can be pushed to the device
+const maxPhotoSize = 32 << 20
+
+// Point is a position on the screen in pixels of the screenshot
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Barcode is a QR code or barcode found on the screen
+type Barcode struct {
+	// Type is the symbology as reported by the scanner, f.ex. QR-Code or EAN-13
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	// Polygon are the corners of the code, it is empty if the scanner does not report them
+	Polygon []Point `json:"polygon"`
+	X       int     `json:"x"`
+	Y       int     `json:"y"`
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+}
+
+// ScreenBarcodes are the codes found on the screen of a device
+type ScreenBarcodes struct {
+	Barcodes []Barcode `json:"barcodes"`
+	// Found is set if the content query param was given and tells whether a code with the content is on the screen
+	Found *bool `json:"found,omitempty"`
+}
+
+// BarcodeScanner finds and decodes the QR codes and barcodes in a png image
+type BarcodeScanner interface {
+	Scan(ctx context.Context, png []byte) ([]Barcode, error)
+}
+
+// QRGenerator encodes content as a QR code png
+type QRGenerator interface {
+	Generate(ctx context.Context, content string, scale int) ([]byte, error)
+}
+
+var (
+	barcodeMu      sync.Mutex
+	barcodeScanner BarcodeScanner = zbarScanner{command: "zbarimg"}
+	qrGenerator    QRGenerator    = qrencodeGenerator{command: "qrencode"}
+)
+
+// SetBarcodeScanner replaces the scanner, which runs the zbarimg command by default
+func SetBarcodeScanner(scanner BarcodeScanner) {
+	barcodeMu.Lock()
+	defer barcodeMu.Unlock()
+	barcodeScanner = scanner
+}
+
+// SetQRGenerator replaces the QR code generator, which runs the qrencode command by default
+func SetQRGenerator(generator QRGenerator) {
+	barcodeMu.Lock()
+	defer barcodeMu.Unlock()
+	qrGenerator = generator
+}
+
+func currentBarcodeScanner() BarcodeScanner {
+	barcodeMu.Lock()
+	defer barcodeMu.Unlock()
+	return barcodeScanner
+}
+
+func currentQRGenerator() QRGenerator {
+	barcodeMu.Lock()
+	defer barcodeMu.Unlock()
+	return qrGenerator
+}
+
+// zbarScanner runs the zbarimg command of the zbar tools, it has to be installed on the host
+type zbarScanner struct {
+	command string
+}
+
+func (z zbarScanner) Scan(ctx context.Context, png []byte) ([]Barcode, error) {
+	path, err := exec.LookPath(z.command)
+	if err != nil {
+		return nil, fmt.Errorf("zbarimg is not installed, install the zbar tools: %w", err)
+	}
+	// zbarimg can not read images from stdin on all platforms
+	file, err := os.CreateTemp("", "go-ios-screen-*.png")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(png)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, path, "--xml", "-q", file.Name())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	// zbarimg exits with 4 if the image has no codes
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 4 {
+		return []Barcode{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("zbarimg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseZbarXML(out)
+}
+
+type zbarResult struct {
+	Symbols []struct {
+		Type    string `xml:"type,attr"`
+		Polygon struct {
+			Points string `xml:"points,attr"`
+		} `xml:"polygon"`
+		Data struct {
+			Format string `xml:"format,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"data"`
+	} `xml:"source>index>symbol"`
+}
+
+// parseZbarXML parses the output of zbarimg --xml, binary content is base64 encoded by zbarimg
+func parseZbarXML(out []byte) ([]Barcode, error) {
+	var result zbarResult
+	err := xml.Unmarshal(out, &result)
+	if err != nil {
+		return nil, fmt.Errorf("parseZbarXML: %w", err)
+	}
+	codes := []Barcode{}
+	for _, symbol := range result.Symbols {
+		code := Barcode{Type: symbol.Type, Content: symbol.Data.Value, Polygon: []Point{}}
+		if symbol.Data.Format == "base64" {
+			content, err := base64.StdEncoding.DecodeString(strings.TrimSpace(symbol.Data.Value))
+			if err != nil {
+				return nil, fmt.Errorf("parseZbarXML: invalid base64 content: %w", err)
+			}
+			code.Content = string(content)
+		}
+		for _, point := range strings.Fields(symbol.Polygon.Points) {
+			var p Point
+			_, err := fmt.Sscanf(point, "%d,%d", &p.X, &p.Y)
+			if err != nil {
+				return nil, fmt.Errorf("parseZbarXML: invalid point '%s'", point)
+			}
+			code.Polygon = append(code.Polygon, p)
+		}
+		codes = append(codes, withBounds(code))
+	}
+	return codes, nil
+}
+
+// withBounds sets the bounding box of the polygon
+func withBounds(code Barcode) Barcode {
+	if len(code.Polygon) == 0 {
+		return code
+	}
+	minX, minY := code.Polygon[0].X, code.Polygon[0].Y
+	maxX, maxY := minX, minY
+	for _, p := range code.Polygon[1:] {
+		minX, minY = min(minX, p.X), min(minY, p.Y)
+		maxX, maxY = max(maxX, p.X), max(maxY, p.Y)
+	}
+	code.X, code.Y, code.Width, code.Height = minX, minY, maxX-minX, maxY-minY
+	return code
+}
+
+// qrencodeGenerator runs the qrencode command, it has to be installed on the host
+type qrencodeGenerator struct {
+	command string
+}
+
+func (q qrencodeGenerator) Generate(ctx context.Context, content string, scale int) ([]byte, error) {
+	path, err := exec.LookPath(q.command)
+	if err != nil {
+		return nil, fmt.Errorf("qrencode is not installed: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, path, "-t", "PNG", "-o", "-", "-s", strconv.Itoa(scale))
+	cmd.Stdin = strings.NewReader(content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("qrencode failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// screenBarcodes filters the codes by type and searches them for the content
+func screenBarcodes(codes []Barcode, codeType string, content string) ScreenBarcodes {
+	result := ScreenBarcodes{Barcodes: []Barcode{}}
+	for _, code := range codes {
+		if codeType == "" || strings.EqualFold(code.Type, codeType) {
+			result.Barcodes = append(result.Barcodes, code)
+		}
+	}
+	if content == "" {
+		return result
+	}
+	found := false
+	for _, code := range result.Barcodes {
+		found = found || code.Content == content
+	}
+	result.Found = &found
+	return result
+}
+
+// qrScale parses the scale query param, the size of a module of the QR code in pixels
+func qrScale(c *gin.Context) (int, error) {
+	value := c.DefaultQuery("scale", "8")
+	scale, err := strconv.Atoi(value)
+	if err != nil || scale < 1 || scale > 64 {
+		return 0, fmt.Errorf("scale must be a number from 1 to 64")
+	}
+	return scale, nil
+}
+
+// generatorStatus is the http status for errors of the scanner and generator
+func generatorStatus(err error) int {
+	if errors.Is(err, exec.ErrNotFound) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// openMediaStorage connects to the media directory of the device with AFC, tests replace it
+var openMediaStorage = func(device ios.DeviceEntry) (appContainer, error) {
+	conn, err := afc.New(device)
+	if err != nil {
+		return nil, err
+	}
+	return afcContainer{conn: conn}, nil
+}
+
+// PushedPhoto is an image stored on the device
+type PushedPhoto struct {
+	Path string `json:"path"`
+	Size int    `json:"size"`
+}
+
+// pushPhoto stores the image in the DCIM directory of the device
+func pushPhoto(device ios.DeviceEntry, name string, b []byte) (PushedPhoto, error) {
+	storage, err := openMediaStorage(device)
+	if err != nil {
+		return PushedPhoto{}, err
+	}
+	defer storage.close()
+	if _, err := storage.stat(photosDir); err != nil {
+		err = storage.mkdir(photosDir)
+		if err != nil {
+			return PushedPhoto{}, fmt.Errorf("pushPhoto: failed creating %s: %w", photosDir, err)
+		}
+	}
+	p := path.Join(photosDir, name)
+	err = storage.write(bytes.NewReader(b), p)
+	if err != nil {
+		return PushedPhoto{}, fmt.Errorf("pushPhoto: %w", err)
+	}
+	return PushedPhoto{Path: p, Size: len(b)}, nil
+}
+
+// photoName returns the file name for an image pushed to the device, keeping the extension of the upload
+func photoName(upload string, now time.Time) string {
+	ext := strings.ToUpper(path.Ext(upload))
+	switch ext {
+	case ".JPG", ".JPEG", ".PNG", ".HEIC", ".GIF":
+	default:
+		ext = ".PNG"
+	}
+	return fmt.Sprintf("GOIOS_%d%s", now.UnixNano(), ext)
+}
+
+// GetScreenBarcodes finds the QR codes and barcodes on the screen
+// @Summary      Get the QR codes and barcodes on the screen
+// @Description  Takes a screenshot and returns the decoded codes with their corners and bounding boxes in pixels. With content, found tells whether a code with exactly this content is on the screen. Scanning uses the zbarimg command of the zbar tools.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        type query string false "only return codes of this type, f.ex. QR-Code or EAN-13"
+// @Param        content query string false "content to look for"
+// @Success      200  {object}  ScreenBarcodes
+// @Failure      500  {object}  GenericResponse
+// @Failure      503  {object}  GenericResponse
+// @Router       /device/{udid}/screen/barcodes [get]
+func GetScreenBarcodes(c *gin.Context) {
+	device := MustGetDevice(c)
+	screenshot, err := captureScreen(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), barcodeTimeout)
+	defer cancel()
+	codes, err := currentBarcodeScanner().Scan(ctx, screenshot)
+	if err != nil {
+		c.JSON(generatorStatus(err), GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, screenBarcodes(codes, c.Query("type"), c.Query("content")))
+}
+
+// GenerateQRCode encodes content as a QR code png
+// @Summary      Generate a QR code
+// @Description  Returns a png of the QR code for the content, f.ex. to show it to the camera of a device. Generating uses the qrencode command.
+// @Tags         general
+// @Produce      png
+// @Param        content query string true "content of the QR code"
+// @Param        scale query int false "size of a module in pixels, from 1 to 64, defaults to 8"
+// @Success      200
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Failure      503  {object}  GenericResponse
+// @Router       /qrcode [get]
+func GenerateQRCode(c *gin.Context) {
+	content := c.Query("content")
+	if content == "" {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "content is missing"})
+		return
+	}
+	scale, err := qrScale(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), barcodeTimeout)
+	defer cancel()
+	png, err := currentQRGenerator().Generate(ctx, content, scale)
+	if err != nil {
+		c.JSON(generatorStatus(err), GenericResponse{Error: err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// PushPhoto stores an image in the camera roll directory of the device
+// @Summary      Push an image to the photo library
+// @Description  Stores the image uploaded as multipart field "image", or a QR code generated for the qrcode query param, in DCIM/100APPLE of the device. The Photos app picks up files in DCIM on some iOS versions only, usually after a restart; apps scanning files with AFC always see them.
+// @Tags         general_device_specific
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        image formData file false "png, jpeg, heic or gif image"
+// @Param        qrcode query string false "content of a QR code to generate instead of uploading an image"
+// @Param        scale query int false "size of a QR code module in pixels, from 1 to 64, defaults to 8"
+// @Success      200  {object}  PushedPhoto
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Failure      503  {object}  GenericResponse
+// @Router       /device/{udid}/photos [post]
+func PushPhoto(c *gin.Context) {
+	var b []byte
+	name := photoName("", time.Now())
+	if content := c.Query("qrcode"); content != "" {
+		scale, err := qrScale(c)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), barcodeTimeout)
+		defer cancel()
+		b, err = currentQRGenerator().Generate(ctx, content, scale)
+		if err != nil {
+			c.JSON(generatorStatus(err), GenericResponse{Error: err.Error()})
+			return
+		}
+	} else {
+		var err error
+		b, err = formFileBytes(c, "image", maxPhotoSize)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+			return
+		}
+		if b == nil {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "upload an image or set qrcode"})
+			return
+		}
+		if header, err := c.FormFile("image"); err == nil {
+			name = photoName(header.Filename, time.Now())
+		}
+	}
+	photo, err := pushPhoto(MustGetDevice(c), name, b)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, photo)
+}
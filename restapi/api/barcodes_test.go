@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const zbarXML = `<barcodes xmlns='http://zbar.sourceforge.net/2008/barcode'>
+<source href='/tmp/screen.png'>
+<index num='0'>
+<symbol type='QR-Code' quality='1' orientation='UP'><polygon points='+36,40 +36,213 +213,213 +210,36'/><data><![CDATA[https://example.com/pay?id=1]]></data></symbol>
+<symbol type='EAN-13' quality='120'><data format='base64' length='5'><![CDATA[aGVsbG8=]]></data></symbol>
+</index>
+</source>
+</barcodes>`
+
+func TestParseZbarXML(t *testing.T) {
+	codes, err := parseZbarXML([]byte(zbarXML))
+	require.NoError(t, err)
+	require.Len(t, codes, 2)
+	assert.Equal(t, "QR-Code", codes[0].Type)
+	assert.Equal(t, "https://example.com/pay?id=1", codes[0].Content)
+	assert.Equal(t, []Point{{36, 40}, {36, 213}, {213, 213}, {210, 36}}, codes[0].Polygon)
+	assert.Equal(t, 36, codes[0].X)
+	assert.Equal(t, 36, codes[0].Y)
+	assert.Equal(t, 177, codes[0].Width)
+	assert.Equal(t, 177, codes[0].Height)
+	assert.Equal(t, "hello", codes[1].Content)
+	assert.Empty(t, codes[1].Polygon)
+
+	_, err = parseZbarXML([]byte("<barcodes"))
+	assert.Error(t, err)
+}
+
+func TestScreenBarcodes(t *testing.T) {
+	codes, err := parseZbarXML([]byte(zbarXML))
+	require.NoError(t, err)
+
+	result := screenBarcodes(codes, "", "")
+	assert.Len(t, result.Barcodes, 2)
+	assert.Nil(t, result.Found)
+
+	result = screenBarcodes(codes, "qr-code", "hello")
+	assert.Len(t, result.Barcodes, 1)
+	require.NotNil(t, result.Found)
+	assert.False(t, *result.Found, "the content is in a barcode of another type")
+
+	result = screenBarcodes(codes, "", "hello")
+	assert.True(t, *result.Found)
+}
+
+type fakeQRGenerator struct{}
+
+func (fakeQRGenerator) Generate(ctx context.Context, content string, scale int) ([]byte, error) {
+	return []byte(content), nil
+}
+
+func TestPushPhoto(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("photos-a"))
+	defer devices.Remove("photos-a")
+	storage := fakeContainer{"/DCIM": nil}
+	defer func(open func(ios.DeviceEntry) (appContainer, error)) { openMediaStorage = open }(openMediaStorage)
+	openMediaStorage = func(device ios.DeviceEntry) (appContainer, error) { return storage, nil }
+	defer SetQRGenerator(currentQRGenerator())
+	SetQRGenerator(fakeQRGenerator{})
+
+	r := gin.New()
+	r.POST("/device/:udid/photos", DeviceMiddleware(), PushPhoto)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/photos-a/photos?qrcode=otp-1234", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var photo PushedPhoto
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &photo))
+	assert.Regexp(t, `^/DCIM/100APPLE/GOIOS_\d+\.PNG$`, photo.Path)
+	assert.Equal(t, []byte("otp-1234"), storage[photo.Path])
+	_, ok := storage[photosDir]
+	assert.True(t, ok, "the directory is created")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/photos-a/photos?qrcode=x&scale=0", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestPhotoName(t *testing.T) {
+	now := time.Unix(0, 42)
+	assert.Equal(t, "GOIOS_42.JPG", photoName("card.jpg", now))
+	assert.Equal(t, "GOIOS_42.PNG", photoName("card.bmp", now))
+	assert.Equal(t, "GOIOS_42.PNG", photoName("", now))
+}
@@ -3,7 +3,6 @@ package api
 import (
 	"net/http"
 
-	"github.com/danielpaulus/go-ios/ios"
 	"github.com/gin-gonic/gin"
 )
 
@@ -16,7 +15,7 @@ import (
 // @Success      200  {object}  map[string]interface{}
 // @Router       /list [get]
 func List(c *gin.Context) {
-	list, err := ios.ListDevices()
+	list, err := usbmuxdList()
 	if err != nil {
 		c.Error(err)
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Failed getting device list with error", "error": err.Error()})
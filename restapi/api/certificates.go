@@ -0,0 +1,172 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/gin-gonic/gin"
+)
+
+// maxCertificateSize is far more than a certificate needs
+const maxCertificateSize = 64 << 10
+
+// mcinstall functions, tests replace them
+var (
+	listProfiles         = mcinstall.ListProfiles
+	installProfileSilent = mcinstall.InstallProfileSilent
+	removeDeviceProfile  = mcinstall.RemoveProfileFromDevice
+)
+
+// TrustedCertificate is a root certificate installed with POST /device/{udid}/certificates
+type TrustedCertificate struct {
+	// Fingerprint is the hex SHA-256 fingerprint of the certificate
+	Fingerprint string `json:"fingerprint"`
+	Name        string `json:"name"`
+	// Identifier is the identifier of the profile that installed the certificate
+	Identifier string `json:"identifier"`
+	// NotAfter is when the certificate expires, it is only known right after installing it
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+}
+
+// certificateIdentity returns the uploaded supervision identity or the configured one
+func certificateIdentity(c *gin.Context) ([]byte, string, int, error) {
+	p12, err := formFileBytes(c, "p12file", maxProfileSize)
+	if err != nil {
+		return nil, "", http.StatusUnprocessableEntity, err
+	}
+	if p12 != nil {
+		return p12, c.PostForm("supervision_password"), 0, nil
+	}
+	p12, password, err := supervisionIdentity()
+	if err != nil {
+		return nil, "", http.StatusPreconditionFailed, err
+	}
+	return p12, password, 0, nil
+}
+
+func trustedCertificates(device ios.DeviceEntry) ([]TrustedCertificate, error) {
+	profiles, err := listProfiles(device)
+	if err != nil {
+		return nil, err
+	}
+	certificates := []TrustedCertificate{}
+	for _, profile := range profiles {
+		fingerprint, ok := mcinstall.IsCertificateProfile(profile.Identifier)
+		if !ok {
+			continue
+		}
+		certificates = append(certificates, TrustedCertificate{Fingerprint: fingerprint, Name: profile.Metadata.PayloadDisplayName, Identifier: profile.Identifier})
+	}
+	return certificates, nil
+}
+
+// Install a trusted root certificate
+// @Summary      Install a trusted root certificate
+// @Description  Installs the CA certificate uploaded as multipart field "certificate" (PEM or DER) with a profile on a supervised device, so TLS connections intercepted by proxies like mitmproxy or Charles are trusted. Installing the same certificate again replaces its profile. The device is supervised with the p12file and supervision_password, or the supervision identity configured with GO_IOS_SUPERVISION_P12.
+// @Tags         general_device_specific
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        certificate formData file true "the CA certificate"
+// @Param        p12file formData file false "Supervision *.p12 file"
+// @Param        supervision_password formData string false "Supervision password"
+// @Success      200 {object} TrustedCertificate
+// @Failure      412 {object} GenericResponse
+// @Failure      422 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/certificates [post]
+func InstallCertificate(c *gin.Context) {
+	device := MustGetDevice(c)
+	data, err := formFileBytes(c, "certificate", maxCertificateSize)
+	if err == nil && data == nil {
+		err = fmt.Errorf("upload the certificate as multipart field 'certificate'")
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	cert, err := mcinstall.ParseCertificate(data)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	if !cert.IsCA {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: fmt.Sprintf("%s is not a CA certificate", mcinstall.CertificateName(cert))})
+		return
+	}
+	p12, password, status, err := certificateIdentity(c)
+	if err != nil {
+		c.JSON(status, GenericResponse{Error: err.Error()})
+		return
+	}
+	profile := mcinstall.CertificateProfile(cert)
+	err = installProfileSilent(device, p12, password, profile.Bytes())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	identifier := profile.PayloadIdentifier
+	ci.created(device.Properties.SerialNumber, "profile", identifier, func() error {
+		return removeDeviceProfile(device, identifier)
+	})
+	notAfter := cert.NotAfter
+	c.JSON(http.StatusOK, TrustedCertificate{Fingerprint: mcinstall.CertificateFingerprint(cert), Name: mcinstall.CertificateName(cert), Identifier: identifier, NotAfter: &notAfter})
+}
+
+// List trusted root certificates
+// @Summary      List trusted root certificates
+// @Description  Lists the root certificates installed with POST /device/{udid}/certificates.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200 {object} []TrustedCertificate
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/certificates [get]
+func ListCertificates(c *gin.Context) {
+	certificates, err := trustedCertificates(MustGetDevice(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, certificates)
+}
+
+// Remove a trusted root certificate
+// @Summary      Remove a trusted root certificate
+// @Description  Removes the profile of a root certificate installed with POST /device/{udid}/certificates.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        fingerprint path string true "SHA-256 fingerprint of the certificate"
+// @Success      200 {object} GenericResponse
+// @Failure      404 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/certificates/{fingerprint} [delete]
+func RemoveCertificate(c *gin.Context) {
+	device := MustGetDevice(c)
+	certificates, err := trustedCertificates(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	// fingerprints are often copied with colons and in upper case
+	fingerprint := strings.ToLower(strings.ReplaceAll(c.Param("fingerprint"), ":", ""))
+	for _, certificate := range certificates {
+		if certificate.Fingerprint != fingerprint {
+			continue
+		}
+		err = removeDeviceProfile(device, certificate.Identifier)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+			return
+		}
+		ci.removed(device.Properties.SerialNumber, "profile", certificate.Identifier)
+		c.JSON(http.StatusOK, GenericResponse{Message: fmt.Sprintf("certificate %s removed", certificate.Name)})
+		return
+	}
+	c.JSON(http.StatusNotFound, GenericResponse{Error: fmt.Sprintf("no certificate with fingerprint %s is installed", c.Param("fingerprint"))})
+}
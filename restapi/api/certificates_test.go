@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCertificate(t *testing.T, name string, ca bool) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestCertificates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv(supervisionP12EnvVar, "")
+	originalList, originalInstall, originalRemove := listProfiles, installProfileSilent, removeDeviceProfile
+	t.Cleanup(func() {
+		listProfiles, installProfileSilent, removeDeviceProfile = originalList, originalInstall, originalRemove
+	})
+	installed := []mcinstall.ProfileInfo{{Identifier: mcinstall.HTTPProxyProfileIdentifier}}
+	listProfiles = func(device ios.DeviceEntry) ([]mcinstall.ProfileInfo, error) { return installed, nil }
+	installProfileSilent = func(device ios.DeviceEntry, p12 []byte, password string, profile []byte) error {
+		assert.Equal(t, "p12", string(p12))
+		assert.Equal(t, "pw", password)
+		parsed, err := ios.ParsePlist(profile)
+		require.NoError(t, err)
+		name, _ := parsed["PayloadDisplayName"].(string)
+		installed = append(installed, mcinstall.ProfileInfo{Identifier: parsed["PayloadIdentifier"].(string), Metadata: mcinstall.ProfileMetadata{PayloadDisplayName: name}})
+		return nil
+	}
+	var removed []string
+	removeDeviceProfile = func(device ios.DeviceEntry, identifier string) error {
+		removed = append(removed, identifier)
+		return nil
+	}
+
+	r := gin.New()
+	device := r.Group("/device/:udid", func(c *gin.Context) {
+		c.Set(IOS_KEY, testDevice(c.Param("udid")))
+		c.Next()
+	})
+	simpleDeviceRoutes(device)
+	upload := func(certificate []byte, withIdentity bool) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("certificate", "ca.pem")
+		require.NoError(t, err)
+		_, err = part.Write(certificate)
+		require.NoError(t, err)
+		if withIdentity {
+			part, err = writer.CreateFormFile("p12file", "supervision.p12")
+			require.NoError(t, err)
+			_, err = part.Write([]byte("p12"))
+			require.NoError(t, err)
+			require.NoError(t, writer.WriteField("supervision_password", "pw"))
+		}
+		require.NoError(t, writer.Close())
+		req := httptest.NewRequest(http.MethodPost, "/device/cert-udid/certificates", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	do := func(method string, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnprocessableEntity, upload([]byte("not a certificate"), true).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, upload(testCertificate(t, "leaf", false), true).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, upload(testCertificate(t, "mitmproxy", true), false).Code)
+
+	w := upload(testCertificate(t, "mitmproxy", true), true)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var certificate TrustedCertificate
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &certificate))
+	assert.Equal(t, "mitmproxy", certificate.Name)
+	assert.Equal(t, mcinstall.CertificateProfilePrefix+certificate.Fingerprint, certificate.Identifier)
+	assert.NotNil(t, certificate.NotAfter)
+
+	w = do(http.MethodGet, "/device/cert-udid/certificates")
+	require.Equal(t, http.StatusOK, w.Code)
+	var certificates []TrustedCertificate
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &certificates))
+	require.Len(t, certificates, 1, "other profiles are not listed")
+	assert.Equal(t, certificate.Fingerprint, certificates[0].Fingerprint)
+	assert.Equal(t, "mitmproxy", certificates[0].Name)
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/device/cert-udid/certificates/0000").Code)
+	assert.Empty(t, removed)
+	colons := strings.ToUpper(certificate.Fingerprint[:2] + ":" + certificate.Fingerprint[2:])
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/device/cert-udid/certificates/"+colons).Code)
+	assert.Equal(t, []string{certificate.Identifier}, removed)
+}
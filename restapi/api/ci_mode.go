@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// modeEnvVar selects how the agent runs. "lab", the default, is the long running daemon of a device lab.
+	// "ci" is tuned for single use CI runners: state is kept in a temporary directory, timeouts are short, lab
+	// background work is off, and everything the run created on devices is removed when it gets SIGINT or SIGTERM.
+	modeEnvVar = "GO_IOS_MODE"
+	// ciSummaryEnvVar is the file the summary of a ci run is written to on exit, it is printed to stdout if unset
+	ciSummaryEnvVar = "GO_IOS_CI_SUMMARY"
+	// ciShutdownTimeout is how long running requests may take to finish when a ci run ends
+	ciShutdownTimeout = 10 * time.Second
+)
+
+// ciTimeoutPolicies are the timeout policies of ci mode, GO_IOS_TIMEOUTS overrides them
+var ciTimeoutPolicies = map[ios.Operation]TimeoutPolicy{
+	ios.OperationPairing:      {Timeout: "15s"},
+	ios.OperationInstall:      {Timeout: "5m"},
+	ios.OperationServiceStart: {Timeout: "10s"},
+	ios.OperationDTXRequest:   {Timeout: "5s"},
+	ios.OperationScreenshot:   {Timeout: "5s"},
+}
+
+// CIAction is something a ci run did to a device
+type CIAction struct {
+	Time time.Time `json:"time"`
+	UDID string    `json:"udid"`
+	// Action is created, removed by a client, or cleaned-up on exit
+	Action string `json:"action"`
+	// Kind is profile, condition, wda-session or xcuitest
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	Error  string `json:"error,omitempty"`
+}
+
+// CISummary lists everything a ci run did, Leftovers are the resources that could not be cleaned up
+type CISummary struct {
+	Started   time.Time  `json:"started"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Actions   []CIAction `json:"actions"`
+	Leftovers []CIAction `json:"leftovers"`
+}
+
+type ciResource struct {
+	udid    string
+	kind    string
+	target  string
+	cleanup func() error
+}
+
+// ciRun keeps track of the resources a ci run creates on devices, so they can be removed on exit
+type ciRun struct {
+	mu        sync.Mutex
+	enabled   bool
+	started   time.Time
+	stateDir  string
+	actions   []CIAction
+	leftovers []CIAction
+	resources []ciResource
+}
+
+var ci = &ciRun{}
+
+// ciModeFromEnv returns true if GO_IOS_MODE selects ci mode
+func ciModeFromEnv() (bool, error) {
+	switch mode := os.Getenv(modeEnvVar); mode {
+	case "", "lab":
+		return false, nil
+	case "ci":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid %s '%s', use lab or ci", modeEnvVar, mode)
+	}
+}
+
+// start switches the agent to ci mode. State files are moved to a temporary directory that is removed on exit.
+func (r *ciRun) start() error {
+	dir, err := os.MkdirTemp("", "go-ios-ci-")
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	r.mu.Lock()
+	r.enabled, r.started, r.stateDir = true, time.Now(), dir
+	r.mu.Unlock()
+
+	deviceConditions.mu.Lock()
+	deviceConditions.path = filepath.Join(dir, "conditions.json")
+	deviceConditions.mu.Unlock()
+	xcuitestStateFile = filepath.Join(dir, "xcuitest-sessions.json")
+	recordingsDir = filepath.Join(dir, "recordings")
+	if os.Getenv(artifactDirEnvVar) == "" {
+		artifacts = newArtifactStore(filepath.Join(dir, "artifacts"))
+	}
+	sessionPool.size = 0
+	return applyTimeoutPolicies(ciTimeoutPolicies)
+}
+
+func (r *ciRun) active() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enabled
+}
+
+// created records a resource the run created on a device, cleanup removes it on exit
+func (r *ciRun) created(udid string, kind string, target string, cleanup func() error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.enabled {
+		return
+	}
+	r.actions = append(r.actions, CIAction{Time: time.Now(), UDID: udid, Action: "created", Kind: kind, Target: target})
+	r.resources = append(r.resources, ciResource{udid: udid, kind: kind, target: target, cleanup: cleanup})
+}
+
+// removed records that a client removed a resource, it is not cleaned up on exit anymore
+func (r *ciRun) removed(udid string, kind string, target string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.enabled {
+		return
+	}
+	r.actions = append(r.actions, CIAction{Time: time.Now(), UDID: udid, Action: "removed", Kind: kind, Target: target})
+	for i, resource := range r.resources {
+		if resource.udid == udid && resource.kind == kind && resource.target == target {
+			r.resources = append(r.resources[:i], r.resources[i+1:]...)
+			return
+		}
+	}
+}
+
+// cleanup removes all resources in the reverse order they were created
+func (r *ciRun) cleanup() {
+	r.mu.Lock()
+	resources := r.resources
+	r.resources = nil
+	r.mu.Unlock()
+	for i := len(resources) - 1; i >= 0; i-- {
+		resource := resources[i]
+		action := CIAction{UDID: resource.udid, Action: "cleaned-up", Kind: resource.kind, Target: resource.target}
+		err := resource.cleanup()
+		action.Time = time.Now()
+		if err != nil {
+			action.Error = err.Error()
+			log.WithError(err).WithFields(log.Fields{"udid": resource.udid, "kind": resource.kind, "target": resource.target}).Error("ci cleanup failed")
+		}
+		r.mu.Lock()
+		r.actions = append(r.actions, action)
+		if err != nil {
+			r.leftovers = append(r.leftovers, action)
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *ciRun) summary() CISummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return CISummary{Started: r.started, Actions: append([]CIAction{}, r.actions...), Leftovers: append([]CIAction{}, r.leftovers...)}
+}
+
+// finish cleans up, writes the summary and removes the state directory
+func (r *ciRun) finish() CISummary {
+	r.cleanup()
+	summary := r.summary()
+	finished := time.Now()
+	summary.Finished = &finished
+	b, _ := json.MarshalIndent(summary, "", "  ")
+	if path := os.Getenv(ciSummaryEnvVar); path != "" {
+		err := os.WriteFile(path, b, 0o644)
+		if err != nil {
+			log.WithError(err).Errorf("could not write ci summary to %s", path)
+		}
+	} else {
+		fmt.Println(string(b))
+	}
+	err := os.RemoveAll(r.stateDir)
+	if err != nil {
+		log.WithError(err).Warn("could not remove ci state")
+	}
+	return summary
+}
+
+// serveCI serves the API until the agent gets SIGINT or SIGTERM, then cleans up and writes the summary.
+// It returns an error if the server failed or resources were left on devices, so CI jobs can fail on it.
+func serveCI(handler http.Handler, addr string) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe() }()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var err error
+	select {
+	case sig := <-signals:
+		log.WithField("signal", sig.String()).Info("ci run finished, cleaning up")
+	case err = <-served:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ciShutdownTimeout)
+	defer cancel()
+	server.Shutdown(ctx)
+
+	summary := ci.finish()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if len(summary.Leftovers) > 0 {
+		return fmt.Errorf("serveCI: %d resources could not be cleaned up", len(summary.Leftovers))
+	}
+	return nil
+}
+
+// GetCISummary returns what the ci run did so far
+// @Summary      Get the summary of a ci run
+// @Description  Lists the profiles, conditions, WDA sessions and xcuitest runs created on devices. Resources that were not removed by clients are cleaned up when the agent gets SIGINT or SIGTERM. Only available if GO_IOS_MODE is ci.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  CISummary
+// @Failure      404  {object}  GenericResponse
+// @Router       /ci/summary [get]
+func GetCISummary(c *gin.Context) {
+	if !ci.active() {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "the agent does not run in ci mode, set " + modeEnvVar + "=ci"})
+		return
+	}
+	c.JSON(http.StatusOK, ci.summary())
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCIRunCleanup(t *testing.T) {
+	run := &ciRun{enabled: true}
+	var cleaned []string
+	cleanup := func(name string, err error) func() error {
+		return func() error {
+			cleaned = append(cleaned, name)
+			return err
+		}
+	}
+	run.created("ci-a", "profile", "com.example.wifi", cleanup("profile", nil))
+	run.created("ci-a", "condition", "SlowNetworkCondition", cleanup("condition", errors.New("device is gone")))
+	run.created("ci-a", "wda-session", "1", cleanup("wda-session", nil))
+	run.removed("ci-a", "wda-session", "1")
+
+	run.cleanup()
+	assert.Equal(t, []string{"condition", "profile"}, cleaned, "resources are cleaned up in reverse order, removed ones are skipped")
+	summary := run.summary()
+	require.Len(t, summary.Actions, 6)
+	assert.Equal(t, "removed", summary.Actions[3].Action)
+	assert.Equal(t, "cleaned-up", summary.Actions[4].Action)
+	require.Len(t, summary.Leftovers, 1)
+	assert.Equal(t, "condition", summary.Leftovers[0].Kind)
+	assert.Equal(t, "device is gone", summary.Leftovers[0].Error)
+
+	run.cleanup()
+	assert.Len(t, cleaned, 2, "resources are only cleaned up once")
+}
+
+func TestCIRunDisabled(t *testing.T) {
+	run := &ciRun{}
+	run.created("ci-a", "profile", "com.example.wifi", func() error { return nil })
+	assert.Empty(t, run.summary().Actions)
+	assert.Empty(t, run.resources)
+}
+
+func TestCIModeFromEnv(t *testing.T) {
+	t.Setenv(modeEnvVar, "ci")
+	enabled, err := ciModeFromEnv()
+	require.NoError(t, err)
+	assert.True(t, enabled)
+	t.Setenv(modeEnvVar, "daemon")
+	_, err = ciModeFromEnv()
+	assert.Error(t, err)
+}
+
+func TestGetCISummaryOutsideCIMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/ci/summary", GetCISummary)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ci/summary", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
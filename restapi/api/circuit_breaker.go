@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	circuitBreakerThreshold = 5
+	circuitBreakerCooldown  = 30 * time.Second
+)
+
+// circuitBreaker stops calling a device service after it failed threshold times in a row. While it is open,
+// requests fail fast. After the cooldown a single request is let through to probe if the service recovered.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns true if a request may be made and otherwise how long the breaker stays open
+func (b *circuitBreaker) allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < circuitBreakerThreshold {
+		return true, 0
+	}
+	if now.Before(b.openUntil) {
+		return false, b.openUntil.Sub(now)
+	}
+	if b.probing {
+		return false, circuitBreakerCooldown
+	}
+	b.probing = true
+	return true, 0
+}
+
+// record returns true if the breaker opened because of this result
+func (b *circuitBreaker) record(success bool, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if success {
+		b.failures = 0
+		return false
+	}
+	b.failures++
+	if b.failures >= circuitBreakerThreshold {
+		b.openUntil = now.Add(circuitBreakerCooldown)
+		return true
+	}
+	return false
+}
+
+// CircuitBreakerMiddleware keeps a circuit breaker per device and route. Once a route fails with a 5xx status
+// five times in a row for a device, further requests fail fast with 503 for 30 seconds, so a single bad device
+// can't tie up handlers. Needs to run after DeviceMiddleware.
+func CircuitBreakerMiddleware() gin.HandlerFunc {
+	breakers := sync.Map{}
+	return func(c *gin.Context) {
+		key := MustGetDevice(c).Properties.SerialNumber + " " + c.Request.Method + " " + c.FullPath()
+		b, _ := breakers.LoadOrStore(key, &circuitBreaker{})
+		breaker := b.(*circuitBreaker)
+		ok, retryAfter := breaker.allow(time.Now())
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, GenericResponse{Error: "device service keeps failing, circuit breaker open"})
+			return
+		}
+		c.Next()
+		if breaker.record(c.Writer.Status() < http.StatusInternalServerError, time.Now()) {
+			log.WithField("breaker", key).Warn("circuit breaker opened")
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := &circuitBreaker{}
+	now := time.Now()
+	for i := 0; i < circuitBreakerThreshold-1; i++ {
+		ok, _ := breaker.allow(now)
+		assert.True(t, ok)
+		assert.False(t, breaker.record(false, now))
+	}
+	assert.True(t, breaker.record(false, now))
+	ok, retryAfter := breaker.allow(now)
+	assert.False(t, ok)
+	assert.Equal(t, circuitBreakerCooldown, retryAfter)
+
+	// after the cooldown only one probe request is let through
+	later := now.Add(circuitBreakerCooldown)
+	ok, _ = breaker.allow(later)
+	assert.True(t, ok)
+	ok, _ = breaker.allow(later)
+	assert.False(t, ok)
+	breaker.record(true, later)
+	ok, _ = breaker.allow(later)
+	assert.True(t, ok)
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RecoveryMiddleware(logrus.New()))
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), `"incidentId"`)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// clockMaxAge is how long a clock measurement is used before the device is measured again. Device clocks
+	// drift slowly, but are adjusted by NTP on the device every now and then.
+	clockMaxAge  = 15 * time.Minute
+	clockSamples = 5
+)
+
+// clockStore keeps the latest clock measurement of every device
+type clockStore struct {
+	mu           sync.RWMutex
+	measurements map[string]ios.ClockMeasurement
+}
+
+var clocks = &clockStore{measurements: map[string]ios.ClockMeasurement{}}
+
+func (s *clockStore) get(udid string) (ios.ClockMeasurement, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.measurements[udid]
+	return m, ok
+}
+
+func (s *clockStore) put(udid string, m ios.ClockMeasurement) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.measurements[udid] = m
+}
+
+// measure measures the clock of the device and stores the result
+func (s *clockStore) measure(device ios.DeviceEntry) (ios.ClockMeasurement, error) {
+	m, err := ios.MeasureClock(device, clockSamples)
+	if err != nil {
+		return ios.ClockMeasurement{}, err
+	}
+	s.put(device.Properties.SerialNumber, m)
+	return m, nil
+}
+
+// current returns a measurement not older than clockMaxAge, measuring the device again if needed
+func (s *clockStore) current(device ios.DeviceEntry) (ios.ClockMeasurement, error) {
+	if m, ok := s.get(device.Properties.SerialNumber); ok && time.Since(m.MeasuredAt) < clockMaxAge {
+		return m, nil
+	}
+	return s.measure(device)
+}
+
+// measureRegistry keeps the clock measurements of all devices in the registry up to date until ctx is done
+func (s *clockStore) measureRegistry(ctx context.Context, registry *DeviceRegistry) {
+	ticker := time.NewTicker(clockMaxAge)
+	defer ticker.Stop()
+	for {
+		registry.Range(func(device ios.DeviceEntry) bool {
+			if _, err := s.current(device); err != nil {
+				log.WithField("udid", device.Properties.SerialNumber).WithError(err).Debug("could not measure device clock")
+			}
+			return true
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Get the clock offset and latency of a device
+// @Summary      Get clock offset and latency
+// @Description  Returns the round trip latency to the device through usbmuxd and the offset of the device clock to the host clock, which is used to correct device timestamps in session recordings. Measurements are refreshed every 15 minutes or with refresh=true.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        refresh query bool false "measure again instead of returning the last measurement"
+// @Success      200 {object} ios.ClockMeasurement
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/clock [get]
+func GetClock(c *gin.Context) {
+	device := MustGetDevice(c)
+	var m ios.ClockMeasurement
+	var err error
+	if c.Query("refresh") == "true" {
+		m, err = clocks.measure(device)
+	} else {
+		m, err = clocks.current(device)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockStoreUsesFreshMeasurement(t *testing.T) {
+	store := &clockStore{measurements: map[string]ios.ClockMeasurement{}}
+	measurement := ios.ClockMeasurement{Offset: time.Second, MeasuredAt: time.Now()}
+	store.put("udid", measurement)
+	current, err := store.current(testDevice("udid"))
+	require.NoError(t, err)
+	assert.Equal(t, measurement, current)
+}
+
+func TestSyslogOffsetCorrectsClockSkew(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	// the device clock is 30 seconds ahead and the device is in UTC+2
+	clock := ios.ClockMeasurement{Offset: 30 * time.Second, UTCOffset: 2 * time.Hour}
+	r := &sessionRecording{start: start, clock: &clock}
+
+	msg := "Jun  1 12:00:40 iPhone SpringBoard[58] <Notice>: tapped\n"
+	assert.Equal(t, int64(10000), r.syslogOffset(msg, start.Add(11*time.Second)))
+	assert.Equal(t, int64(11000), r.syslogOffset("garbage", start.Add(11*time.Second)))
+
+	r.clock = nil
+	assert.Equal(t, int64(11000), r.syslogOffset(msg, start.Add(11*time.Second)))
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// conditionPresetsEnvVar is the path of a json file with a list of instruments.ConditionPreset that are available
+// in addition to the builtin presets. Presets with the name of a builtin one replace it.
+const conditionPresetsEnvVar = "GO_IOS_CONDITION_PRESETS"
+
+// conditionPresetStore holds the device condition presets by lower case name
+type conditionPresetStore struct {
+	mu      sync.RWMutex
+	presets map[string]instruments.ConditionPreset
+}
+
+var conditionPresets = newConditionPresetStore()
+
+func newConditionPresetStore() *conditionPresetStore {
+	store := &conditionPresetStore{presets: map[string]instruments.ConditionPreset{}}
+	for _, preset := range instruments.BuiltinConditionPresets() {
+		store.presets[strings.ToLower(preset.Name)] = preset
+	}
+	return store
+}
+
+// add validates all presets before adding any of them
+func (s *conditionPresetStore) add(presets []instruments.ConditionPreset) error {
+	for _, preset := range presets {
+		if err := preset.Validate(); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, preset := range presets {
+		s.presets[strings.ToLower(preset.Name)] = preset
+	}
+	return nil
+}
+
+// get returns the preset with the name, ignoring case
+func (s *conditionPresetStore) get(name string) (instruments.ConditionPreset, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	preset, ok := s.presets[strings.ToLower(name)]
+	return preset, ok
+}
+
+func (s *conditionPresetStore) list() []instruments.ConditionPreset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]instruments.ConditionPreset, 0, len(s.presets))
+	for _, preset := range s.presets {
+		result = append(result, preset)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// loadFile adds the presets of a json file
+func (s *conditionPresetStore) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var presets []instruments.ConditionPreset
+	err = json.Unmarshal(b, &presets)
+	if err != nil {
+		return fmt.Errorf("invalid presets in %s: %w", path, err)
+	}
+	return s.add(presets)
+}
+
+// loadConditionPresets adds the presets configured with GO_IOS_CONDITION_PRESETS
+func loadConditionPresets() {
+	path := os.Getenv(conditionPresetsEnvVar)
+	if path == "" {
+		return
+	}
+	err := conditionPresets.loadFile(path)
+	if err != nil {
+		log.WithError(err).Errorf("ignoring %s", conditionPresetsEnvVar)
+	}
+}
+
+// ListConditionPresets lists the device condition presets
+// @Summary      List device condition presets
+// @Description  Lists the named presets that can be enabled with /device/{udid}/enable-condition?preset=name. Besides the builtin presets, custom presets can be configured with a json file at GO_IOS_CONDITION_PRESETS. A preset can combine conditions of different profile types.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  []instruments.ConditionPreset
+// @Router       /conditions/presets [get]
+func ListConditionPresets(c *gin.Context) {
+	c.JSON(http.StatusOK, conditionPresets.list())
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionPresetsFromFile(t *testing.T) {
+	store := newConditionPresetStore()
+	_, ok := store.get("3G-Poor")
+	assert.True(t, ok)
+
+	path := filepath.Join(t.TempDir(), "presets.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"name": "lte-average", "conditions": [{"profileType": "SlowNetworkCondition", "profiles": ["SlowNetworkLTE"]}]},
+		{"name": "hot-lte", "conditions": [
+			{"profileType": "SlowNetworkCondition", "profiles": ["SlowNetworkLTE"]},
+			{"profileType": "ThermalCondition", "profiles": ["ThermalSerious"]}
+		]}
+	]`), 0o644))
+	require.NoError(t, store.loadFile(path))
+
+	preset, ok := store.get("lte-average")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"SlowNetworkLTE"}, preset.Conditions[0].Profiles)
+	preset, ok = store.get("hot-lte")
+	assert.True(t, ok)
+	assert.Len(t, preset.Conditions, 2)
+}
+
+func TestConditionPresetsRejectsInvalidFile(t *testing.T) {
+	store := newConditionPresetStore()
+	count := len(store.list())
+	path := filepath.Join(t.TempDir(), "presets.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"name": "valid", "conditions": [{"profileType": "SlowNetworkCondition", "profiles": ["SlowNetworkLTE"]}]},
+		{"name": "invalid"}
+	]`), 0o644))
+	assert.Error(t, store.loadFile(path))
+	assert.Len(t, store.list(), count)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/crashreport"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchCrashLoop watches the app for a crash loop until ctx is done. The crash reports are checked whenever a
+// process of the app terminates, so a loop is found within seconds. Tests replace it.
+var watchCrashLoop = func(ctx context.Context, device ios.DeviceEntry, bundleID string, thresholds crashreport.LoopThresholds) (*crashreport.CrashLoop, error) {
+	wake := make(chan struct{}, 1)
+	receive, closeNotifications, err := instruments.ListenAppStateNotifications(device)
+	if err != nil {
+		log.WithField("udid", device.Properties.SerialNumber).WithError(err).Debug("no process events, only polling crash reports")
+	} else {
+		defer closeNotifications()
+		go func() {
+			for {
+				notification, err := receive()
+				if err != nil {
+					return
+				}
+				if processTerminated(notification, bundleID) {
+					select {
+					case wake <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}()
+	}
+	return crashreport.WatchForLoop(ctx, device, bundleID, thresholds, wake)
+}
+
+// processTerminated tells if an application state notification is about a process of the app that terminated
+func processTerminated(notification map[string]interface{}, bundleID string) bool {
+	displayID, _ := notification["displayID"].(string)
+	state, _ := notification["state_description"].(string)
+	return displayID == bundleID && state == "Terminated"
+}
+
+// crashLoopCheck cancels a test run once the app under test crashes in a loop, so it fails right away instead
+// of waiting for the tests to time out
+type crashLoopCheck struct {
+	stopWatch context.CancelFunc
+	stopRun   context.CancelFunc
+	done      chan struct{}
+	loop      *crashreport.CrashLoop
+}
+
+// startCrashLoopCheck watches the app under test if the request has a crashLoop. The test run has to use the
+// returned context, it is canceled when a crash loop is found.
+func startCrashLoopCheck(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest) (context.Context, *crashLoopCheck) {
+	if request.CrashLoop == nil {
+		return ctx, nil
+	}
+	runCtx, stopRun := context.WithCancel(ctx)
+	watchCtx, stopWatch := context.WithCancel(runCtx)
+	check := &crashLoopCheck{stopWatch: stopWatch, stopRun: stopRun, done: make(chan struct{})}
+	go func() {
+		defer close(check.done)
+		loop, err := watchCrashLoop(watchCtx, device, request.BundleID, *request.CrashLoop)
+		if err != nil || loop == nil {
+			return
+		}
+		log.WithFields(log.Fields{"udid": device.Properties.SerialNumber, "bundleId": request.BundleID}).Warn(loop.Verdict)
+		check.loop = loop
+		stopRun()
+	}()
+	return runCtx, check
+}
+
+// stop ends watching and returns the crash loop that canceled the run, if there was one
+func (c *crashLoopCheck) stop() *crashreport.CrashLoop {
+	if c == nil {
+		return nil
+	}
+	c.stopWatch()
+	<-c.done
+	c.stopRun()
+	return c.loop
+}
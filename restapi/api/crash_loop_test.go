@@ -0,0 +1,14 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessTerminated(t *testing.T) {
+	assert.True(t, processTerminated(map[string]interface{}{"displayID": "com.example.app", "state_description": "Terminated", "pid": uint64(312)}, "com.example.app"))
+	assert.False(t, processTerminated(map[string]interface{}{"displayID": "com.example.app", "state_description": "Foreground Running"}, "com.example.app"))
+	assert.False(t, processTerminated(map[string]interface{}{"displayID": "com.apple.mobilesafari", "state_description": "Terminated"}, "com.example.app"))
+	assert.False(t, processTerminated(map[string]interface{}{}, "com.example.app"))
+}
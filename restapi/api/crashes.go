@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/crashreport"
+	"github.com/danielpaulus/go-ios/ios/testmanagerd"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// listCrashReports and readCrashReport access the crash reports of a device, tests replace them
+var (
+	listCrashReports = crashreport.Reports
+	readCrashReport  = crashreport.ReadReport
+)
+
+// crashFilter reads the bundleId, process, from and to query parameters, from and to are RFC3339 dates
+func crashFilter(c *gin.Context) (crashreport.Filter, error) {
+	filter := crashreport.Filter{BundleID: c.Query("bundleId"), Process: c.Query("process")}
+	var err error
+	if from := c.Query("from"); from != "" {
+		filter.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return crashreport.Filter{}, err
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		filter.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return crashreport.Filter{}, err
+		}
+	}
+	return filter, nil
+}
+
+// List the crash reports of a device
+// @Summary      List crash reports
+// @Description  Lists the crash reports on the device, newest first. The bundle id and app version are read from the header of .ips reports.
+// @Tags         crashes
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        bundleId query string false "only reports of this app"
+// @Param        process query string false "only reports of this process"
+// @Param        from query string false "only reports modified after this RFC3339 date"
+// @Param        to query string false "only reports modified before this RFC3339 date"
+// @Success      200  {object}  []crashreport.Report
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/crashes [get]
+func ListCrashes(c *gin.Context) {
+	device := MustGetDevice(c)
+	filter, err := crashFilter(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	reports, err := listCrashReports(device, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reports)
+}
+
+// Download a crash report
+// @Summary      Download a crash report
+// @Description  Downloads the crash report with the name the list returns, f.ex. Retired/Example-2024-01-16-153643.ips
+// @Tags         crashes
+// @Produce      octet-stream
+// @Param        udid path string true "Device UDID"
+// @Param        name path string true "name of the crash report"
+// @Success      200  {file}    file
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/crashes/{name} [get]
+func GetCrash(c *gin.Context) {
+	device := MustGetDevice(c)
+	name := strings.TrimPrefix(c.Param("name"), "/")
+	var report bytes.Buffer
+	err := readCrashReport(device, name, &report)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+path.Base(name)+`"`)
+	c.Data(http.StatusOK, "application/octet-stream", report.Bytes())
+}
+
+// collectCrashes downloads the crash reports of the app under test written since the test run started to dir.
+// It returns the reports as attachments of the test report, collecting is best effort and failures are logged.
+func collectCrashes(device ios.DeviceEntry, bundleID string, since time.Time, dir string) []testmanagerd.TestAttachment {
+	logger := log.WithFields(log.Fields{"udid": device.Properties.SerialNumber, "bundleId": bundleID})
+	reports, err := listCrashReports(device, crashreport.Filter{BundleID: bundleID, From: since})
+	if err != nil {
+		logger.WithError(err).Warn("failed listing the crash reports of the test run")
+		return nil
+	}
+	var attachments []testmanagerd.TestAttachment
+	for _, report := range reports {
+		target := filepath.Join(dir, filepath.FromSlash(report.Name))
+		err := os.MkdirAll(filepath.Dir(target), 0o755)
+		if err != nil {
+			logger.WithError(err).Warn("failed collecting crash report")
+			return attachments
+		}
+		var content bytes.Buffer
+		err = readCrashReport(device, report.Name, &content)
+		if err == nil {
+			err = os.WriteFile(target, content.Bytes(), 0o644)
+		}
+		if err != nil {
+			logger.WithError(err).WithField("report", report.Name).Warn("failed collecting crash report")
+			continue
+		}
+		attachments = append(attachments, testmanagerd.TestAttachment{
+			Name:      report.Name,
+			Path:      target,
+			Type:      "crash report",
+			Timestamp: float64(report.Modified.Unix()),
+		})
+	}
+	return attachments
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/crashreport"
+	"github.com/danielpaulus/go-ios/ios/testmanagerd"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeCrashReports(t *testing.T, reports []crashreport.Report) *[]crashreport.Filter {
+	var filters []crashreport.Filter
+	originalList, originalRead := listCrashReports, readCrashReport
+	t.Cleanup(func() { listCrashReports, readCrashReport = originalList, originalRead })
+	listCrashReports = func(device ios.DeviceEntry, filter crashreport.Filter) ([]crashreport.Report, error) {
+		filters = append(filters, filter)
+		return reports, nil
+	}
+	readCrashReport = func(device ios.DeviceEntry, name string, w io.Writer) error {
+		_, err := io.WriteString(w, "crash of "+name)
+		return err
+	}
+	return &filters
+}
+
+func TestCrashEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	modified := time.Date(2024, 1, 16, 15, 36, 43, 0, time.UTC)
+	filters := fakeCrashReports(t, []crashreport.Report{{Name: "Retired/Example-2024-01-16-153643.ips", Process: "Example", BundleID: "com.example.app", Modified: modified}})
+	r := gin.New()
+	device := r.Group("/device/:udid", func(c *gin.Context) {
+		c.Set(IOS_KEY, testDevice(c.Param("udid")))
+		c.Next()
+	})
+	device.GET("/crashes", ListCrashes)
+	device.GET("/crashes/*name", GetCrash)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/crash-udid/crashes?bundleId=com.example.app&from=2024-01-16T00:00:00Z", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var reports []crashreport.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reports))
+	assert.Equal(t, "Retired/Example-2024-01-16-153643.ips", reports[0].Name)
+	assert.Equal(t, crashreport.Filter{BundleID: "com.example.app", From: time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC)}, (*filters)[0])
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/crash-udid/crashes?to=yesterday", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/crash-udid/crashes/Retired/Example-2024-01-16-153643.ips", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "crash of Retired/Example-2024-01-16-153643.ips", w.Body.String())
+	assert.Equal(t, `attachment; filename="Example-2024-01-16-153643.ips"`, w.Header().Get("Content-Disposition"))
+}
+
+func TestXCUITestCollectsCrashes(t *testing.T) {
+	xcuitestStateFile = filepath.Join(t.TempDir(), "sessions.json")
+	filters := fakeCrashReports(t, []crashreport.Report{{Name: "Example-2024-01-16-153643.ips", BundleID: "com.example.app"}})
+	store := newXCUITestStore()
+	store.run = func(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		return nil, nil
+	}
+
+	info, err := store.start(testDevice("crash-udid"), XCUITestRequest{BundleID: "com.example.app", CollectCrashes: true})
+	require.NoError(t, err)
+	session, _ := store.get("crash-udid", info.ID)
+	ended := waitForXCUITest(t, session)
+	assert.Equal(t, []string{"Example-2024-01-16-153643.ips"}, ended.Crashes)
+	assert.Equal(t, "com.example.app", (*filters)[0].BundleID)
+	assert.Equal(t, info.Started, (*filters)[0].From)
+
+	run, err := newExportedRun(ended, nil, nil, []testmanagerd.TestAttachment{{Name: "Example.ips", Path: "/tmp/crashes/Example.ips"}})
+	require.NoError(t, err)
+	assert.Equal(t, "crashes/Example.ips", run.report.Crashes[0].Path)
+	assert.Equal(t, "/tmp/crashes/Example.ips", run.attachments["crashes/Example.ips"])
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2024, time.March, 15, 10, 7, 30, 0, time.UTC) // a friday
+	cases := map[string]time.Time{
+		"* * * * *":      time.Date(2024, time.March, 15, 10, 8, 0, 0, time.UTC),
+		"*/15 * * * *":   time.Date(2024, time.March, 15, 10, 15, 0, 0, time.UTC),
+		"0 2 * * *":      time.Date(2024, time.March, 16, 2, 0, 0, 0, time.UTC),
+		"30 1 * * 1-5":   time.Date(2024, time.March, 18, 1, 30, 0, 0, time.UTC),
+		"0 0 1 * *":      time.Date(2024, time.April, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":     time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+		"0 12 1 * 0":     time.Date(2024, time.March, 17, 12, 0, 0, 0, time.UTC),
+		"5,10 10 15 3 *": time.Date(2024, time.March, 15, 10, 10, 0, 0, time.UTC),
+	}
+	for expr, expected := range cases {
+		schedule, err := parseCron(expr)
+		if assert.NoError(t, err, expr) {
+			assert.Equal(t, expected, schedule.next(from), expr)
+		}
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := parseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestMaintenanceWindowOccurrence(t *testing.T) {
+	window := MaintenanceWindow{UDID: "a", Cron: "0 2 * * *", Duration: "2h"}
+	assert.NoError(t, window.validate())
+
+	occurrence, ok := window.occurrence(time.Date(2024, time.March, 15, 3, 0, 0, 0, time.Local))
+	assert.True(t, ok)
+	assert.True(t, occurrence.Active)
+	assert.Equal(t, time.Date(2024, time.March, 15, 4, 0, 0, 0, time.Local), occurrence.End)
+
+	occurrence, ok = window.occurrence(time.Date(2024, time.March, 15, 4, 0, 0, 0, time.Local))
+	assert.True(t, ok)
+	assert.False(t, occurrence.Active)
+	assert.Equal(t, time.Date(2024, time.March, 16, 2, 0, 0, 0, time.Local), occurrence.Start)
+
+	assert.Error(t, (&MaintenanceWindow{Cron: "0 2 * * *", Duration: "2h"}).validate())
+	assert.Error(t, (&MaintenanceWindow{Label: "lab", Cron: "0 2 * * *", Duration: "2h", Tasks: []string{"format"}}).validate())
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConnectionInfo is an open connection to usbmuxd or a device
+type ConnectionInfo struct {
+	ios.ConnectionInfo
+	UDID string `json:"udid,omitempty"`
+	Age  string `json:"age"`
+}
+
+// requestOwners keeps track of the requests that are currently running per device, so connections
+// that are opened to a device can be attributed to the request that opened them
+type requestOwners struct {
+	mu       sync.Mutex
+	requests map[int]map[uint64]string
+	nextID   uint64
+}
+
+var connectionOwners = &requestOwners{requests: map[int]map[uint64]string{}}
+
+func init() {
+	ios.SetConnectionOwnerFunc(connectionOwners.owner)
+}
+
+// add registers a running request for the device and returns the function to remove it again
+func (o *requestOwners) add(deviceID int, request string) func() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.nextID++
+	id := o.nextID
+	if o.requests[deviceID] == nil {
+		o.requests[deviceID] = map[uint64]string{}
+	}
+	o.requests[deviceID][id] = request
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		delete(o.requests[deviceID], id)
+		if len(o.requests[deviceID]) == 0 {
+			delete(o.requests, deviceID)
+		}
+	}
+}
+
+func (o *requestOwners) owner(deviceID int) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	owners := make([]string, 0, len(o.requests[deviceID]))
+	for _, request := range o.requests[deviceID] {
+		owners = append(owners, request)
+	}
+	sort.Strings(owners)
+	return strings.Join(owners, ", ")
+}
+
+// ListConnections lists all open connections
+// @Summary      List open connections
+// @Description  Lists all open usbmuxd, lockdown and service (f.ex. DTX or AFC) connections with their age, transferred bytes and the request that opened them. Use it to find leaked connections. Needs the admin token.
+// @Tags         debug
+// @Produce      json
+// @Param        udid query string false "Only list connections of this device"
+// @Success      200  {object}  []ConnectionInfo
+// @Router       /debug/connections [get]
+func ListConnections(c *gin.Context) {
+	deviceIDs := map[int]string{}
+	devices.Range(func(device ios.DeviceEntry) bool {
+		if device.DeviceID != 0 {
+			deviceIDs[device.DeviceID] = device.Properties.SerialNumber
+		}
+		return true
+	})
+	udid := c.Query("udid")
+	result := []ConnectionInfo{}
+	for _, conn := range ios.ListConnections() {
+		info := ConnectionInfo{ConnectionInfo: conn, UDID: deviceIDs[conn.DeviceID], Age: time.Since(conn.Opened).Round(time.Second).String()}
+		if udid != "" && info.UDID != udid {
+			continue
+		}
+		result = append(result, info)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// CloseConnection force closes an open connection
+// @Summary      Close a connection
+// @Description  Force closes the connection with the given id, whoever uses it gets an error on the next read or write. Needs the admin token.
+// @Tags         debug
+// @Produce      json
+// @Param        id path int true "Connection id"
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /debug/connections/{id} [delete]
+func CloseConnection(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "invalid connection id"})
+		return
+	}
+	err = ios.CloseConnection(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "connection closed"})
+}
+
+// LogLevels are the log level of the host and the wire log levels of devices
+type LogLevels struct {
+	// Level is the logrus level, like info, debug or trace
+	Level string `json:"level,omitempty"`
+	// UDID selects the device Wire applies to
+	UDID string `json:"udid,omitempty"`
+	// Wire is the protocol logging of the device, off, messages (decoded lockdown and DTX messages) or hexdump
+	Wire ios.WireLogLevel `json:"wire,omitempty"`
+	// Devices lists the wire log levels of all devices that have wire logging enabled
+	Devices map[string]ios.WireLogLevel `json:"devices,omitempty"`
+}
+
+func currentLogLevels() LogLevels {
+	return LogLevels{Level: log.GetLevel().String(), Devices: ios.WireLogLevels()}
+}
+
+// GetLogLevel returns the log levels
+// @Summary      Get log levels
+// @Description  Returns the log level of the host and the devices that have wire logging enabled. Needs the admin token.
+// @Tags         debug
+// @Produce      json
+// @Success      200  {object}  LogLevels
+// @Router       /debug/loglevel [get]
+func GetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, currentLogLevels())
+}
+
+// SetLogLevel changes log levels at runtime
+// @Summary      Change log levels
+// @Description  Changes the log level of the host and the protocol logging of a single device without a restart. Wire logging applies to open connections as well, hexdump logs every lockdown and DTX frame of the device. Wire logs can contain pair records and other sensitive data. Needs the admin token.
+// @Tags         debug
+// @Accept       json
+// @Produce      json
+// @Param        levels body LogLevels true "Levels to change, level and wire are optional"
+// @Success      200  {object}  LogLevels
+// @Failure      404  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /debug/loglevel [put]
+func SetLogLevel(c *gin.Context) {
+	var request LogLevels
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	var level log.Level
+	if request.Level != "" {
+		level, err = log.ParseLevel(request.Level)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+			return
+		}
+	}
+	var device ios.DeviceEntry
+	if request.Wire != "" {
+		if request.UDID == "" {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "udid is required to change the wire log level"})
+			return
+		}
+		if _, err := ios.ParseWireLogLevel(string(request.Wire)); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+			return
+		}
+		var ok bool
+		device, ok = devices.Get(request.UDID)
+		if !ok {
+			device, err = ios.GetDevice(request.UDID)
+			if err != nil {
+				c.JSON(http.StatusNotFound, GenericResponse{Error: "device not found on the host"})
+				return
+			}
+		}
+	}
+	if request.Level != "" {
+		log.SetLevel(level)
+	}
+	if request.Wire != "" {
+		ios.SetWireLogLevel(device, request.Wire)
+	}
+	c.JSON(http.StatusOK, currentLogLevels())
+}
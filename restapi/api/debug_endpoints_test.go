@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func putLogLevel(body string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PUT("/debug/loglevel", SetLogLevel)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/debug/loglevel", strings.NewReader(body)))
+	return recorder
+}
+
+func TestSetLogLevel(t *testing.T) {
+	defer log.SetLevel(log.GetLevel())
+	device := ios.DeviceEntry{DeviceID: 42, Properties: ios.DeviceProperties{SerialNumber: "wirelog-udid"}}
+	devices.Put(device)
+	defer devices.Remove(device.Properties.SerialNumber)
+	defer ios.SetWireLogLevel(device, ios.WireLogOff)
+
+	recorder := putLogLevel(`{"level": "debug", "udid": "wirelog-udid", "wire": "hexdump"}`)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var levels LogLevels
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &levels))
+	assert.Equal(t, "debug", levels.Level)
+	assert.Equal(t, ios.WireLogHexDump, levels.Devices["wirelog-udid"])
+	assert.Equal(t, log.DebugLevel, log.GetLevel())
+}
+
+func TestSetLogLevelValidates(t *testing.T) {
+	level := log.GetLevel()
+	assert.Equal(t, http.StatusUnprocessableEntity, putLogLevel(`{"level": "loud"}`).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, putLogLevel(`{"wire": "hexdump"}`).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, putLogLevel(`{"udid": "x", "wire": "everything"}`).Code)
+	assert.Equal(t, level, log.GetLevel())
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// conditionsStateFile keeps the active conditions, so they can still be seen and disabled after the API restarts
+var conditionsStateFile = filepath.Join(os.TempDir(), "go-ios-conditions.json")
+
+// agentSession identifies this run of the API. Conditions enabled by earlier runs are recovered conditions.
+var agentSession = uuid.New().String()
+
+// deviceConditionsMutex serializes enabling and disabling conditions
+var deviceConditionsMutex sync.Mutex
+
+type deviceCondition struct {
+	// Preset is the name of the preset the conditions were enabled with, if any
+	Preset     string
+	Conditions []instruments.Condition
+	// StateControl is the connection the conditions were enabled with, it is nil for recovered conditions
+	StateControl *instruments.DeviceStateControl
+	Enabled      time.Time
+	// Session is the agentSession that enabled the conditions
+	Session string
+}
+
+func (d deviceCondition) String() string {
+	parts := make([]string, len(d.Conditions))
+	for i, condition := range d.Conditions {
+		parts[i] = "profileTypeID=" + condition.ProfileType.Identifier + ", profileID=" + condition.Profile.Identifier
+	}
+	description := strings.Join(parts, "; ")
+	if d.Preset != "" {
+		description = "preset=" + d.Preset + " (" + description + ")"
+	}
+	return description
+}
+
+// ActiveConditionProfile is a profile that is enabled on a device
+type ActiveConditionProfile struct {
+	ProfileType string `json:"profileType"`
+	Profile     string `json:"profile"`
+}
+
+// ActiveCondition is the condition enabled on a device
+type ActiveCondition struct {
+	UDID       string                   `json:"udid"`
+	Preset     string                   `json:"preset,omitempty"`
+	Conditions []ActiveConditionProfile `json:"conditions"`
+	Enabled    time.Time                `json:"enabled"`
+	// Session is the id of the API run that enabled the condition
+	Session string `json:"session"`
+	// Recovered is true if the condition was enabled before the API restarted or was found active on the device.
+	// It is disabled with a new instruments connection.
+	Recovered bool `json:"recovered"`
+	// ActiveOnDevice are the profiles the device reports as active
+	ActiveOnDevice []ActiveConditionProfile `json:"activeOnDevice"`
+}
+
+func (d deviceCondition) active(udid string) ActiveCondition {
+	result := ActiveCondition{UDID: udid, Preset: d.Preset, Enabled: d.Enabled, Session: d.Session, Recovered: d.StateControl == nil, Conditions: []ActiveConditionProfile{}}
+	for _, condition := range d.Conditions {
+		result.Conditions = append(result.Conditions, ActiveConditionProfile{ProfileType: condition.ProfileType.Identifier, Profile: condition.Profile.Identifier})
+	}
+	return result
+}
+
+func conditionFromActive(active ActiveCondition) deviceCondition {
+	result := deviceCondition{Preset: active.Preset, Enabled: active.Enabled, Session: active.Session}
+	for _, profile := range active.Conditions {
+		result.Conditions = append(result.Conditions, instruments.Condition{
+			ProfileType: instruments.ProfileType{Identifier: profile.ProfileType},
+			Profile:     instruments.Profile{Identifier: profile.Profile},
+		})
+	}
+	return result
+}
+
+// conditionStore keeps the active conditions by udid and writes them to a file whenever they change
+type conditionStore struct {
+	mu     sync.Mutex
+	active map[string]deviceCondition
+	path   string
+}
+
+var deviceConditions = &conditionStore{active: map[string]deviceCondition{}, path: conditionsStateFile}
+
+func (s *conditionStore) get(udid string) (deviceCondition, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	condition, ok := s.active[udid]
+	return condition, ok
+}
+
+func (s *conditionStore) set(udid string, condition deviceCondition) {
+	s.mu.Lock()
+	s.active[udid] = condition
+	s.mu.Unlock()
+	s.persist()
+}
+
+func (s *conditionStore) remove(udid string) {
+	s.mu.Lock()
+	delete(s.active, udid)
+	s.mu.Unlock()
+	s.persist()
+}
+
+func (s *conditionStore) persist() {
+	s.mu.Lock()
+	list := make([]ActiveCondition, 0, len(s.active))
+	for udid, condition := range s.active {
+		list = append(list, condition.active(udid))
+	}
+	path := s.path
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].UDID < list[j].UDID })
+	b, err := json.Marshal(list)
+	if err == nil {
+		err = os.WriteFile(path, b, 0o644)
+	}
+	if err != nil {
+		log.WithError(err).Warn("could not save active conditions")
+	}
+}
+
+// recover loads the conditions that were active when the API stopped. Their instruments connections are gone,
+// the conditions are disabled with new connections.
+func (s *conditionStore) recover() error {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []ActiveCondition
+	err = json.Unmarshal(b, &list)
+	if err != nil {
+		return fmt.Errorf("invalid active conditions in %s: %w", s.path, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, active := range list {
+		if _, ok := s.active[active.UDID]; !ok {
+			s.active[active.UDID] = conditionFromActive(active)
+		}
+	}
+	if len(list) > 0 {
+		log.WithField("devices", len(list)).Warn("conditions were active when the API stopped, check them with GET /device/{udid}/active-condition")
+	}
+	return nil
+}
+
+// recoverConditions loads the conditions that were active when the API stopped
+func recoverConditions() {
+	err := deviceConditions.recover()
+	if err != nil {
+		log.WithError(err).Warn("could not recover active conditions")
+	}
+}
+
+// activeProfiles returns the profiles the device reports as active
+func activeProfiles(types []instruments.ProfileType) []ActiveConditionProfile {
+	result := []ActiveConditionProfile{}
+	for _, profileType := range types {
+		if profileType.IsActive {
+			result = append(result, ActiveConditionProfile{ProfileType: profileType.Identifier, Profile: profileType.ActiveProfile})
+		}
+	}
+	return result
+}
+
+// disableRecovered disables conditions whose instruments connection is gone. Disabling with a new connection does
+// not work on all iOS versions, so the conditions are enabled on the new connection first to take them over.
+// Closing the connection deactivates them in any case.
+func disableRecovered(device ios.DeviceEntry, condition deviceCondition) error {
+	control, err := instruments.NewDeviceStateControl(device)
+	if err != nil {
+		return err
+	}
+	defer control.Close()
+	for _, c := range condition.Conditions {
+		if control.Disable(c.ProfileType) == nil {
+			continue
+		}
+		err = control.Enable(c.ProfileType, c.Profile)
+		if err != nil {
+			log.WithError(err).WithField("udid", device.Properties.SerialNumber).Debug("could not take over recovered condition")
+		}
+		err = control.Disable(c.ProfileType)
+		if err != nil {
+			return fmt.Errorf("disableRecovered: %s: %w", c.ProfileType.Identifier, err)
+		}
+	}
+	types, err := control.List()
+	if err != nil {
+		return err
+	}
+	if active := activeProfiles(types); len(active) > 0 {
+		return fmt.Errorf("disableRecovered: conditions are still active: %+v", active)
+	}
+	return nil
+}
+
+// GetActiveCondition returns the condition enabled on a device
+// @Summary      Get the active condition of a device
+// @Description  Returns the condition enabled on the device and the profiles the device reports as active. Conditions enabled before the API restarted, or by someone else, are returned as recovered and can be disabled with /disable-condition as usual.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200  {object}  ActiveCondition
+// @Failure      404  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/active-condition [get]
+func GetActiveCondition(c *gin.Context) {
+	device := MustGetDevice(c)
+	udid := device.Properties.SerialNumber
+	deviceConditionsMutex.Lock()
+	defer deviceConditionsMutex.Unlock()
+
+	control, err := instruments.NewDeviceStateControl(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer control.Close()
+	types, err := control.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	onDevice := activeProfiles(types)
+	condition, ok := deviceConditions.get(udid)
+	switch {
+	case !ok && len(onDevice) == 0:
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "Device has no active condition"})
+		return
+	case !ok:
+		condition = conditionFromActive(ActiveCondition{Conditions: onDevice, Enabled: time.Now()})
+		deviceConditions.set(udid, condition)
+	case condition.StateControl == nil && len(onDevice) == 0:
+		// the device deactivated the recovered condition when the old connection closed
+		deviceConditions.remove(udid)
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "Device has no active condition"})
+		return
+	}
+	active := condition.active(udid)
+	active.ActiveOnDevice = onDevice
+	c.JSON(http.StatusOK, active)
+}
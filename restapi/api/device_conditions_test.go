@@ -0,0 +1,53 @@
+package api
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionStoreRecover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conditions.json")
+	store := &conditionStore{active: map[string]deviceCondition{}, path: path}
+	enabled := time.Now().Round(time.Second)
+	store.set("udid", deviceCondition{
+		Preset: "3g-poor",
+		Conditions: []instruments.Condition{{
+			ProfileType: instruments.ProfileType{Identifier: "SlowNetworkCondition"},
+			Profile:     instruments.Profile{Identifier: "SlowNetwork3GBad"},
+		}},
+		StateControl: &instruments.DeviceStateControl{},
+		Enabled:      enabled,
+		Session:      agentSession,
+	})
+
+	restarted := &conditionStore{active: map[string]deviceCondition{}, path: path}
+	require.NoError(t, restarted.recover())
+	condition, ok := restarted.get("udid")
+	require.True(t, ok)
+	active := condition.active("udid")
+	assert.True(t, active.Recovered, "the instruments connection is gone after a restart")
+	assert.Equal(t, "3g-poor", active.Preset)
+	assert.Equal(t, []ActiveConditionProfile{{ProfileType: "SlowNetworkCondition", Profile: "SlowNetwork3GBad"}}, active.Conditions)
+	assert.True(t, enabled.Equal(active.Enabled))
+	assert.Equal(t, agentSession, active.Session)
+
+	restarted.remove("udid")
+	empty := &conditionStore{active: map[string]deviceCondition{}, path: path}
+	require.NoError(t, empty.recover())
+	_, ok = empty.get("udid")
+	assert.False(t, ok)
+	require.NoError(t, (&conditionStore{active: map[string]deviceCondition{}, path: filepath.Join(t.TempDir(), "missing.json")}).recover())
+}
+
+func TestActiveProfiles(t *testing.T) {
+	types := []instruments.ProfileType{
+		{Identifier: "SlowNetworkCondition", IsActive: true, ActiveProfile: "SlowNetwork100PctLoss"},
+		{Identifier: "ThermalCondition"},
+	}
+	assert.Equal(t, []ActiveConditionProfile{{ProfileType: "SlowNetworkCondition", Profile: "SlowNetwork100PctLoss"}}, activeProfiles(types))
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/installationproxy"
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/gin-gonic/gin"
+)
+
+// identifyingLockdownKeys differ on every device, they are left out of diffs because they would only be noise
+var identifyingLockdownKeys = map[string]bool{
+	"BluetoothAddress":                      true,
+	"DeviceCertificate":                     true,
+	"DeviceName":                            true,
+	"DevicePublicKey":                       true,
+	"DieID":                                 true,
+	"EthernetAddress":                       true,
+	"IntegratedCircuitCardIdentity":         true,
+	"InternationalMobileEquipmentIdentity":  true,
+	"InternationalMobileEquipmentIdentity2": true,
+	"InternationalMobileSubscriberIdentity": true,
+	"MLBSerialNumber":                       true,
+	"MobileEquipmentIdentifier":             true,
+	"PhoneNumber":                           true,
+	"ProximitySensorCalibration":            true,
+	"SerialNumber":                          true,
+	"UniqueChipID":                          true,
+	"UniqueDeviceID":                        true,
+	"WiFiAddress":                           true,
+	"WirelessBoardSerialNumber":             true,
+}
+
+// deviceSnapshot is everything that is compared between two devices
+type deviceSnapshot struct {
+	lockdown map[string]interface{}
+	// apps maps bundle ids of user apps to their version
+	apps map[string]interface{}
+	// profiles maps profile identifiers to their display name
+	profiles map[string]interface{}
+	settings map[string]interface{}
+	errors   []string
+}
+
+// ValueDiff is a value that differs between device a and b. A value missing on one device is null.
+type ValueDiff struct {
+	Key string      `json:"key"`
+	A   interface{} `json:"a"`
+	B   interface{} `json:"b"`
+}
+
+// SetDiff compares two sets of items, like installed apps
+type SetDiff struct {
+	OnlyA   []string    `json:"onlyA"`
+	OnlyB   []string    `json:"onlyB"`
+	Changed []ValueDiff `json:"changed"`
+}
+
+// DeviceDiff lists what differs between two devices. Identifiers like the serial number or mac addresses are
+// left out, they differ on every device.
+type DeviceDiff struct {
+	A        string      `json:"a"`
+	B        string      `json:"b"`
+	Lockdown []ValueDiff `json:"lockdown"`
+	Apps     SetDiff     `json:"apps"`
+	Profiles SetDiff     `json:"profiles"`
+	Settings []ValueDiff `json:"settings"`
+	// Errors lists what could not be collected, f.ex. the profiles of a device that refused the connection
+	Errors []string `json:"errors,omitempty"`
+}
+
+// snapshotLookup collects the snapshot of a device, tests replace it
+var snapshotLookup = collectSnapshot
+
+func collectSnapshot(device ios.DeviceEntry) deviceSnapshot {
+	snapshot := deviceSnapshot{lockdown: map[string]interface{}{}, apps: map[string]interface{}{}, profiles: map[string]interface{}{}, settings: map[string]interface{}{}}
+	udid := device.Properties.SerialNumber
+	addError := func(part string, err error) {
+		snapshot.errors = append(snapshot.errors, fmt.Sprintf("%s: %s: %s", udid, part, err.Error()))
+	}
+
+	values, err := ios.GetValuesPlist(device)
+	if err != nil {
+		addError("lockdown values", err)
+	}
+	for key, value := range values {
+		if !identifyingLockdownKeys[key] {
+			snapshot.lockdown[key] = value
+		}
+	}
+
+	svc, err := installationproxy.New(device)
+	if err == nil {
+		var apps []installationproxy.AppInfo
+		apps, err = svc.BrowseUserApps()
+		svc.Close()
+		for _, app := range apps {
+			snapshot.apps[app.CFBundleIdentifier] = app.CFBundleShortVersionString + " (" + app.CFBundleVersion + ")"
+		}
+	}
+	if err != nil {
+		addError("apps", err)
+	}
+
+	profileService, err := mcinstall.New(device)
+	if err == nil {
+		var profiles []mcinstall.ProfileInfo
+		profiles, err = profileService.HandleList()
+		profileService.Close()
+		for _, profile := range profiles {
+			snapshot.profiles[profile.Identifier] = profile.Metadata.PayloadDisplayName
+		}
+	}
+	if err != nil {
+		addError("profiles", err)
+	}
+
+	if language, err := ios.GetLanguage(device); err == nil {
+		snapshot.settings["Language"] = language.Language
+		snapshot.settings["Locale"] = language.Locale
+	} else {
+		addError("language", err)
+	}
+	boolSettings := map[string]func(ios.DeviceEntry) (bool, error){
+		"Uses24HourClock": ios.GetUses24HourClock,
+		"AssistiveTouch":  ios.GetAssistiveTouch,
+		"VoiceOver":       ios.GetVoiceOver,
+		"ZoomTouch":       ios.GetZoomTouch,
+	}
+	for name, get := range boolSettings {
+		if enabled, err := get(device); err == nil {
+			snapshot.settings[name] = enabled
+		} else {
+			addError(name, err)
+		}
+	}
+	return snapshot
+}
+
+// diffValues returns the values that differ between a and b sorted by key, nested dictionaries are compared
+// key by key with dotted keys like NonVolatileRAM.auto-boot
+func diffValues(a map[string]interface{}, b map[string]interface{}) []ValueDiff {
+	flatA := map[string]interface{}{}
+	flatB := map[string]interface{}{}
+	flatten("", a, flatA)
+	flatten("", b, flatB)
+	keys := map[string]bool{}
+	for key := range flatA {
+		keys[key] = true
+	}
+	for key := range flatB {
+		keys[key] = true
+	}
+	result := []ValueDiff{}
+	for key := range keys {
+		valueA, valueB := flatA[key], flatB[key]
+		if !reflect.DeepEqual(valueA, valueB) {
+			result = append(result, ValueDiff{Key: key, A: valueA, B: valueB})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+func flatten(prefix string, values map[string]interface{}, result map[string]interface{}) {
+	for key, value := range values {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flatten(key, nested, result)
+			continue
+		}
+		result[key] = value
+	}
+}
+
+// diffSets compares sets of items, items on both devices with different values are listed in Changed
+func diffSets(a map[string]interface{}, b map[string]interface{}) SetDiff {
+	result := SetDiff{OnlyA: []string{}, OnlyB: []string{}, Changed: []ValueDiff{}}
+	for key, valueA := range a {
+		valueB, ok := b[key]
+		if !ok {
+			result.OnlyA = append(result.OnlyA, key)
+			continue
+		}
+		if !reflect.DeepEqual(valueA, valueB) {
+			result.Changed = append(result.Changed, ValueDiff{Key: key, A: valueA, B: valueB})
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			result.OnlyB = append(result.OnlyB, key)
+		}
+	}
+	sort.Strings(result.OnlyA)
+	sort.Strings(result.OnlyB)
+	sort.Slice(result.Changed, func(i, j int) bool { return result.Changed[i].Key < result.Changed[j].Key })
+	return result
+}
+
+func diffDevices(udidA string, a deviceSnapshot, udidB string, b deviceSnapshot) DeviceDiff {
+	return DeviceDiff{
+		A:        udidA,
+		B:        udidB,
+		Lockdown: diffValues(a.lockdown, b.lockdown),
+		Apps:     diffSets(a.apps, b.apps),
+		Profiles: diffSets(a.profiles, b.profiles),
+		Settings: diffValues(a.settings, b.settings),
+		Errors:   append(a.errors, b.errors...),
+	}
+}
+
+// DiffDevices compares two devices
+// @Summary      Compare two devices
+// @Description  Compares the lockdown values, installed user apps, configuration profiles and settings like language and accessibility of two devices and returns what differs. Identifiers like serial numbers and mac addresses are left out. Helps finding out why something only fails on one device.
+// @Tags         general
+// @Produce      json
+// @Param        udid query []string true "udids of the two devices" collectionFormat(multi)
+// @Success      200  {object}  DeviceDiff
+// @Failure      404  {object}  GenericResponse
+// @Failure      409  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /devices/diff [get]
+func DiffDevices(c *gin.Context) {
+	udids := c.QueryArray("udid")
+	if len(udids) != 2 || udids[0] == udids[1] {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "provide two different devices with udid=a&udid=b"})
+		return
+	}
+	entries := make([]ios.DeviceEntry, 2)
+	for i, udid := range udids {
+		device, ok := devices.Get(udid)
+		if !ok {
+			c.JSON(http.StatusNotFound, GenericResponse{Error: fmt.Sprintf("device %s not found", udid)})
+			return
+		}
+		if !pairedLookup(udid) {
+			c.JSON(http.StatusConflict, GenericResponse{Error: fmt.Sprintf("device %s is not paired with the host", udid)})
+			return
+		}
+		entries[i] = device
+	}
+
+	snapshots := make([]deviceSnapshot, 2)
+	var wg sync.WaitGroup
+	for i := range entries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			snapshots[i] = snapshotLookup(entries[i])
+		}(i)
+	}
+	wg.Wait()
+	c.JSON(http.StatusOK, diffDevices(udids[0], snapshots[0], udids[1], snapshots[1]))
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffValues(t *testing.T) {
+	a := map[string]interface{}{
+		"ProductVersion": "17.2",
+		"ProductType":    "iPhone15,2",
+		"NonVolatileRAM": map[string]interface{}{"auto-boot": "true", "boot-args": ""},
+		"OnlyA":          true,
+	}
+	b := map[string]interface{}{
+		"ProductVersion": "17.4",
+		"ProductType":    "iPhone15,2",
+		"NonVolatileRAM": map[string]interface{}{"auto-boot": "true", "boot-args": "-v"},
+	}
+	assert.Equal(t, []ValueDiff{
+		{Key: "NonVolatileRAM.boot-args", A: "", B: "-v"},
+		{Key: "OnlyA", A: true, B: nil},
+		{Key: "ProductVersion", A: "17.2", B: "17.4"},
+	}, diffValues(a, b))
+}
+
+func TestDiffSets(t *testing.T) {
+	a := map[string]interface{}{"com.example.a": "1.0 (1)", "com.example.both": "1.0 (1)", "com.example.same": "2.0 (2)"}
+	b := map[string]interface{}{"com.example.b": "1.0 (1)", "com.example.both": "1.1 (2)", "com.example.same": "2.0 (2)"}
+	assert.Equal(t, SetDiff{
+		OnlyA:   []string{"com.example.a"},
+		OnlyB:   []string{"com.example.b"},
+		Changed: []ValueDiff{{Key: "com.example.both", A: "1.0 (1)", B: "1.1 (2)"}},
+	}, diffSets(a, b))
+}
+
+func TestDiffDevicesEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, udid := range []string{"diff-a", "diff-b", "diff-unpaired"} {
+		devices.Put(testDevice(udid))
+		defer devices.Remove(udid)
+	}
+	defer func(lookup func(string) bool) { pairedLookup = lookup }(pairedLookup)
+	pairedLookup = func(udid string) bool { return udid != "diff-unpaired" }
+	defer func(lookup func(ios.DeviceEntry) deviceSnapshot) { snapshotLookup = lookup }(snapshotLookup)
+	snapshotLookup = func(device ios.DeviceEntry) deviceSnapshot {
+		snapshot := deviceSnapshot{
+			lockdown: map[string]interface{}{"ProductVersion": "17.2"},
+			apps:     map[string]interface{}{},
+			profiles: map[string]interface{}{},
+			settings: map[string]interface{}{"Locale": "en_US"},
+		}
+		if device.Properties.SerialNumber == "diff-b" {
+			snapshot.settings["Locale"] = "de_DE"
+			snapshot.apps["com.example.app"] = "1.0 (1)"
+			snapshot.errors = []string{"diff-b: profiles: refused"}
+		}
+		return snapshot
+	}
+
+	r := gin.New()
+	r.GET("/devices/diff", DiffDevices)
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/devices/diff?"+query, nil))
+		return w
+	}
+
+	w := get("udid=diff-a&udid=diff-b")
+	require.Equal(t, http.StatusOK, w.Code)
+	var diff DeviceDiff
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &diff))
+	assert.Equal(t, "diff-a", diff.A)
+	assert.Empty(t, diff.Lockdown)
+	assert.Equal(t, []ValueDiff{{Key: "Locale", A: "en_US", B: "de_DE"}}, diff.Settings)
+	assert.Equal(t, []string{"com.example.app"}, diff.Apps.OnlyB)
+	assert.Equal(t, []string{"diff-b: profiles: refused"}, diff.Errors)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, get("udid=diff-a").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get("udid=diff-a&udid=diff-a").Code)
+	assert.Equal(t, http.StatusNotFound, get("udid=diff-a&udid=diff-unknown").Code)
+	assert.Equal(t, http.StatusConflict, get("udid=diff-a&udid=diff-unpaired").Code)
+}
@@ -2,19 +2,19 @@ package api
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/danielpaulus/go-ios/ios/imagemounter"
 	"github.com/danielpaulus/go-ios/ios/mobileactivation"
 	"io"
 	"net/http"
-	"os"
-	"sync"
+	"time"
 
 	"github.com/danielpaulus/go-ios/ios"
 	"github.com/danielpaulus/go-ios/ios/instruments"
 	"github.com/danielpaulus/go-ios/ios/mcinstall"
-	"github.com/danielpaulus/go-ios/ios/screenshotr"
 	"github.com/danielpaulus/go-ios/ios/simlocation"
+	"github.com/danielpaulus/go-ios/ios/workspace"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
@@ -29,7 +29,7 @@ import (
 // @Param        udid path string true "Device UDID"
 // @Router       /device/{udid}/activate [post]
 func Activate(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 	err := mobileactivation.Activate(device)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
@@ -38,16 +38,32 @@ func Activate(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, GenericResponse{Message: "Activation successful"})
 }
 
+// MountedImages contains the signatures of the developer disk images mounted on a device
+type MountedImages struct {
+	Mounted    bool     `json:"mounted"`
+	Signatures []string `json:"signatures"`
+}
+
+// GetImages lists the mounted developer disk images
+// @Summary      Get mounted developer disk images
+// @Description  Returns if a developer disk image is mounted and the signatures of all mounted images
+// @Tags         general_device_specific, image
+// @Produce      json
+// @Success      200  {object}  MountedImages
+// @Failure      500  {object}  GenericResponse
+// @Param        udid path string true "Device UDID"
+// @Router       /device/{udid}/image [get]
 func GetImages(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 	conn, err := imagemounter.NewImageMounter(device)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
 		return
 	}
+	defer conn.Close()
 	signatures, err := conn.ListImages()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err)
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
 		return
 	}
 
@@ -55,19 +71,33 @@ func GetImages(c *gin.Context) {
 	for i, sig := range signatures {
 		res[i] = fmt.Sprintf("%x", sig)
 	}
-	c.JSON(http.StatusOK, res)
+	c.JSON(http.StatusOK, MountedImages{Mounted: len(res) > 0, Signatures: res})
+}
 
+// UnmountImage unmounts the developer disk image
+// @Summary      Unmount the developer disk image
+// @Description  Unmounts the developer disk image from the device
+// @Tags         general_device_specific, image
+// @Produce      json
+// @Success      200  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Param        udid path string true "Device UDID"
+// @Router       /device/{udid}/image/unmount [post]
+func UnmountImage(c *gin.Context) {
+	device := MustGetDevice(c)
+	err := imagemounter.UnmountImage(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "image unmounted"})
 }
+
 func InstallImage(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 	auto := c.Query("auto")
 	if auto == "true" {
-		basedir := c.Query("basedir")
-		if basedir == "" {
-			basedir = "./devimages"
-		}
-
-		path, err := imagemounter.DownloadImageFor(device, basedir)
+		path, err := imagemounter.DownloadImageFor(device, imageDir())
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, err)
 			return
@@ -77,19 +107,25 @@ func InstallImage(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, err)
 			return
 		}
+		history.record(DeviceEvent{UDID: device.Properties.SerialNumber, Type: "image-mounted", Message: path})
 		c.JSON(http.StatusOK, "ok")
 		return
 	}
 	body := c.Request.Body
 	defer body.Close()
 
-	tempfile, err := os.CreateTemp(os.TempDir(), "go-ios")
+	ws, err := workspace.Default().New("image")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	defer ws.Close()
+	tempfile, err := ws.CreateTemp("go-ios")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, err)
 		return
 	}
 	tempfilepath := tempfile.Name()
-	defer os.Remove(tempfilepath)
 	_, err = io.Copy(tempfile, body)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, err)
@@ -105,10 +141,54 @@ func InstallImage(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, err)
 		return
 	}
+	history.record(DeviceEvent{UDID: device.Properties.SerialNumber, Type: "image-mounted"})
 	c.JSON(http.StatusOK, "ok")
 	return
 }
 
+// Status gets the passcode state of a device
+// @Summary      Get the passcode state of a device
+// @Description  Returns if a passcode is set on the device, not if it is locked right now. Operations like pairing fail with 423 while a device with a passcode is locked.
+// @Tags         general_device_specific
+// @Produce      json
+// @Success      200  {object}  ios.PasscodeState
+// @Failure      500  {object}  GenericResponse
+// @Param        udid path string true "Device UDID"
+// @Router       /device/{udid}/status [get]
+func Status(c *gin.Context) {
+	device := MustGetDevice(c)
+	state, err := ios.GetPasscodeState(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// ProbeServices reports which lockdown services a device starts
+// @Summary      Probe lockdown services
+// @Description  Tries to start each lockdown service go-ios knows, or only the services given with the service param, and reports whether it is available, missing (f.ex. the developer image is not mounted) or denied with the reason of lockdown. Helps finding out why a feature fails on a particular iOS version or supervision state.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        service query []string false "only probe these services, f.ex. com.apple.afc" collectionFormat(multi)
+// @Success      200  {object}  []ios.ServiceProbe
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/services [get]
+func ProbeServices(c *gin.Context) {
+	device := MustGetDevice(c)
+	services := c.QueryArray("service")
+	if len(services) == 0 {
+		services = ios.KnownLockdownServices
+	}
+	probes, err := ios.ProbeServices(device, services)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, probes)
+}
+
 // Info gets device info
 // Info                godoc
 // @Summary      Get lockdown info for a device by udid
@@ -120,7 +200,7 @@ func InstallImage(c *gin.Context) {
 // @Param        udid path string true "Device UDID"
 // @Router       /device/{udid}/info [get]
 func Info(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 
 	allValues, err := ios.GetValuesPlist(device)
 	if err != nil {
@@ -150,26 +230,41 @@ func Info(c *gin.Context) {
 // Screenshot grab screenshot from a device
 // Screenshot                godoc
 // @Summary      Get screenshot for device
-// @Description Takes a png screenshot and returns it.
+// @Description Takes a screenshot and returns it as png or jpeg. Use scale to get smaller screenshots, f.ex. for dashboards that refresh every second, the screenshot is scaled down on the server.
 // @Tags         general_device_specific
-// @Produce      png
-// @Param        udid  path      string  true  "device udid"
-// @Success      200  {object}  []byte
+// @Produce      png,jpeg
 // @Param        udid path string true "Device UDID"
+// @Param        format query string false "png or jpeg, defaults to png"
+// @Param        scale query number false "between 0 and 1, defaults to 1"
+// @Param        quality query int false "jpeg quality from 1 to 100, defaults to 80"
+// @Success      200  {object}  []byte
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
 // @Router       /device/{udid}/screenshot [get]
 func Screenshot(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
-	conn, err := screenshotr.New(device)
-	log.Error(err)
-	b, _ := conn.TakeScreenshot()
-
-	c.Header("Content-Type", "image/png")
-	c.Data(http.StatusOK, "application/octet-stream", b)
+	options, err := screenshotOptionsFrom(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	device := MustGetDevice(c)
+	raw, err := captureScreen(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	b, contentType, err := convertScreenshot(raw, options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, contentType, b)
 }
 
 // Change the current device location
 // @Summary      Change the current device location
-// @Description Change the current device location to provided latitude and longtitude
+// @Description Change the current device location to provided latitude and longtitude. iOS 17+ devices simulate the location through instruments, the agent keeps that connection open until the location is reset.
 // @Tags         general_device_specific
 // @Produce      json
 // @Param        latitude  query      string  true  "Location latitude"
@@ -180,7 +275,7 @@ func Screenshot(c *gin.Context) {
 // @Param        udid path string true "Device UDID"
 // @Router       /device/{udid}/setlocation [post]
 func SetLocation(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 	latitude := c.Query("latitude")
 	if latitude == "" {
 		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "latitude query param is missing"})
@@ -212,7 +307,7 @@ func SetLocation(c *gin.Context) {
 // @Param        udid path string true "Device UDID"
 // @Router       /device/{udid}/resetlocation [post]
 func ResetLocation(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 	err := simlocation.ResetLocation(device)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
@@ -233,7 +328,7 @@ func ResetLocation(c *gin.Context) {
 // @Param        udid path string true "Device UDID"
 // @Router       /device/{udid}/profiles [get]
 func GetProfiles(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 
 	mcinstallconn, err := mcinstall.New(device)
 	if err != nil {
@@ -256,17 +351,6 @@ func GetProfiles(c *gin.Context) {
 // DEVICE STATE CONDITIONS
 //========================================
 
-var (
-	deviceConditionsMap   = make(map[string]deviceCondition)
-	deviceConditionsMutex sync.Mutex
-)
-
-type deviceCondition struct {
-	ProfileType  instruments.ProfileType
-	Profile      instruments.Profile
-	StateControl *instruments.DeviceStateControl
-}
-
 // Get a list of the available conditions that can be applied on the device
 // @Summary      Get a list of available device conditions
 // @Description  Get a list of the available conditions that can be applied on the device
@@ -277,7 +361,7 @@ type deviceCondition struct {
 // @Param        udid path string true "Device UDID"
 // @Router       /device/{udid}/conditions [get]
 func GetSupportedConditions(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 
 	control, err := instruments.NewDeviceStateControl(device)
 	if err != nil {
@@ -296,38 +380,50 @@ func GetSupportedConditions(c *gin.Context) {
 
 // Enable condition on a device
 // @Summary      Enable condition on a device
-// @Description  Enable condition on a device by provided profileTypeID and profileID
+// @Description  Enable condition on a device by provided profileTypeID and profileID or by the name of a preset like 3g-poor, lte-average or wifi-congested. See /conditions/presets for all presets.
 // @Tags         general_device_specific
 // @Produce      json
 // @Param        udid path string true "Device UDID"
-// @Param        profileTypeID  query      string  true  "Identifier of the profile type, eg. SlowNetworkCondition"
-// @Param        profileID  query      string  true  "Identifier of the sub-profile, eg. SlowNetwork100PctLoss"
+// @Param        profileTypeID  query      string  false  "Identifier of the profile type, eg. SlowNetworkCondition"
+// @Param        profileID  query      string  false  "Identifier of the sub-profile, eg. SlowNetwork100PctLoss"
+// @Param        preset  query      string  false  "Name of a condition preset, eg. 3g-poor. Used instead of profileTypeID and profileID."
 // @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
 // @Failure      500  {object}  GenericResponse
 // @Router       /device/{udid}/enable-condition [put]
 func EnableDeviceCondition(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 	udid := device.Properties.SerialNumber
 
 	deviceConditionsMutex.Lock()
 	defer deviceConditionsMutex.Unlock()
 
-	conditionedDevice, exists := deviceConditionsMap[udid]
+	conditionedDevice, exists := deviceConditions.get(udid)
 	if exists {
-		c.JSON(http.StatusOK, GenericResponse{Error: "Device has an active condition - profileTypeID=" + conditionedDevice.ProfileType.Identifier + ", profileID=" + conditionedDevice.Profile.Identifier})
+		c.JSON(http.StatusOK, GenericResponse{Error: "Device has an active condition - " + conditionedDevice.String()})
 		return
 	}
 
-	profileTypeID := c.Query("profileTypeID")
-	if profileTypeID == "" {
-		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "profileTypeID query param is missing"})
+	presetName := c.Query("preset")
+	preset, ok := conditionPresets.get(presetName)
+	if presetName != "" && !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "condition preset '" + presetName + "' not found"})
 		return
 	}
+	if presetName == "" {
+		profileTypeID := c.Query("profileTypeID")
+		if profileTypeID == "" {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "profileTypeID query param is missing"})
+			return
+		}
 
-	profileID := c.Query("profileID")
-	if profileID == "" {
-		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "profileID query param is missing"})
-		return
+		profileID := c.Query("profileID")
+		if profileID == "" {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "profileID query param is missing"})
+			return
+		}
+		preset = instruments.ConditionPreset{Name: profileTypeID, Conditions: []instruments.ConditionStep{{ProfileType: profileTypeID, Profiles: []string{profileID}}}}
 	}
 
 	control, err := instruments.NewDeviceStateControl(device)
@@ -342,13 +438,13 @@ func EnableDeviceCondition(c *gin.Context) {
 		return
 	}
 
-	profileType, profile, err := instruments.VerifyProfileAndType(profileTypes, profileTypeID, profileID)
+	conditions, err := instruments.ResolvePreset(profileTypes, preset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
 		return
 	}
 
-	err = control.Enable(profileType, profile)
+	err = control.EnableConditions(conditions)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
 		return
@@ -358,15 +454,21 @@ func EnableDeviceCondition(c *gin.Context) {
 	// Creating a new *DeviceStateControl and providing the same profileType WILL NOT disable the already active condition
 	// For this reason we keep a map of `deviceConditions` that contain their original *DeviceStateControl pointers
 	// which we can use in `DisableDeviceCondition()` to successfully disable the active condition
-	newDeviceCondition := deviceCondition{ProfileType: profileType, Profile: profile, StateControl: control}
-	deviceConditionsMap[device.Properties.SerialNumber] = newDeviceCondition
-
-	c.JSON(http.StatusOK, GenericResponse{Message: "Enabled condition for ProfileType=" + profileTypeID + " and Profile=" + profileID})
+	newDeviceCondition := deviceCondition{Preset: presetName, Conditions: conditions, StateControl: control, Enabled: time.Now(), Session: agentSession}
+	deviceConditions.set(udid, newDeviceCondition)
+	ci.created(udid, "condition", newDeviceCondition.String(), func() error {
+		deviceConditionsMutex.Lock()
+		defer deviceConditionsMutex.Unlock()
+		_, err := disableCondition(device)
+		return err
+	})
+
+	c.JSON(http.StatusOK, GenericResponse{Message: "Enabled condition " + newDeviceCondition.String()})
 }
 
 // Disable the currently active condition on a device
 // @Summary      Disable the currently active condition on a device
-// @Description  Disable the currently active condition on a device
+// @Description  Disable the currently active condition on a device, all conditions of a preset are disabled together
 // @Tags         general_device_specific
 // @Produce      json
 // @Success      200  {object}  GenericResponse
@@ -374,28 +476,55 @@ func EnableDeviceCondition(c *gin.Context) {
 // @Param        udid path string true "Device UDID"
 // @Router       /device/{udid}/disable-condition [post]
 func DisableDeviceCondition(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 	udid := device.Properties.SerialNumber
 
 	deviceConditionsMutex.Lock()
 	defer deviceConditionsMutex.Unlock()
 
-	conditionedDevice, exists := deviceConditionsMap[udid]
-	if !exists {
+	conditionedDevice, err := disableCondition(device)
+	if errors.Is(err, errNoCondition) {
 		c.JSON(http.StatusOK, GenericResponse{Error: "Device has no active condition"})
 		return
 	}
-
-	// Disable() does not throw an error if the respective condition is not active on the device
-	err := conditionedDevice.StateControl.Disable(conditionedDevice.ProfileType)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
 		return
 	}
+	ci.removed(udid, "condition", conditionedDevice.String())
+	c.JSON(http.StatusOK, GenericResponse{Message: "Device condition disabled"})
+}
 
-	delete(deviceConditionsMap, udid)
+var errNoCondition = errors.New("device has no active condition")
 
-	c.JSON(http.StatusOK, GenericResponse{Message: "Device condition disabled"})
+// disableCondition disables the active condition of the device, the caller holds deviceConditionsMutex
+func disableCondition(device ios.DeviceEntry) (deviceCondition, error) {
+	udid := device.Properties.SerialNumber
+	conditionedDevice, exists := deviceConditions.get(udid)
+	if !exists {
+		return deviceCondition{}, errNoCondition
+	}
+
+	if conditionedDevice.StateControl == nil {
+		err := disableRecovered(device, conditionedDevice)
+		if err != nil {
+			return conditionedDevice, err
+		}
+		deviceConditions.remove(udid)
+		return conditionedDevice, nil
+	}
+
+	// Disable() does not throw an error if the respective condition is not active on the device
+	for _, condition := range conditionedDevice.Conditions {
+		err := conditionedDevice.StateControl.Disable(condition.ProfileType)
+		if err != nil {
+			return conditionedDevice, err
+		}
+	}
+
+	conditionedDevice.StateControl.Close()
+	deviceConditions.remove(udid)
+	return conditionedDevice, nil
 }
 
 // ========================================
@@ -409,13 +538,14 @@ func DisableDeviceCondition(c *gin.Context) {
 // @Success      200  {object}  GenericResponse
 // @Failure      500  {object}  GenericResponse
 // @Failure      422  {object}  GenericResponse
+// @Failure      423  {object}  GenericResponse
 // @Param        udid path string true "Device UDID"
 // @Param        supervised query string true "Set if device is supervised - true/false"
 // @Param 		 p12file formData file false "Supervision *.p12 file"
 // @Param 		 supervision_password formData string false "Supervision password"
 // @Router       /device/{udid}/pair [post]
 func PairDevice(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 
 	supervised := c.Query("supervised")
 	if supervised == "" {
@@ -424,11 +554,14 @@ func PairDevice(c *gin.Context) {
 	}
 
 	if supervised == "false" {
-		err := ios.Pair(device)
+		start := time.Now()
+		err := pairWithHost(device)
+		slos.observe(sloPair, start, &err)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+			c.JSON(errorStatus(err), GenericResponse{Error: err.Error()})
 			return
 		}
+		history.record(DeviceEvent{UDID: device.Properties.SerialNumber, Type: "device-paired"})
 		c.JSON(http.StatusOK, GenericResponse{Message: "Device paired"})
 		return
 	}
@@ -447,11 +580,14 @@ func PairDevice(c *gin.Context) {
 		return
 	}
 
-	err = ios.PairSupervised(device, p12fileBuf.Bytes(), supervision_password)
+	start := time.Now()
+	err = pairWithHostSupervised(device, p12fileBuf.Bytes(), supervision_password)
+	slos.observe(sloPair, start, &err)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		c.JSON(errorStatus(err), GenericResponse{Error: err.Error()})
 		return
 	}
+	history.record(DeviceEvent{UDID: device.Properties.SerialNumber, Type: "device-paired", Message: "supervised"})
 
 	c.JSON(http.StatusOK, GenericResponse{Message: "Device paired"})
 }
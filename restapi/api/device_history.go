@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/restapi/events"
+)
+
+const (
+	// deviceHistorySize is how many events are kept per device, older events are dropped
+	deviceHistorySize = 1000
+	// bootTimeout is how long after a reboot was requested a device coming back counts as boot completed
+	bootTimeout = 10 * time.Minute
+)
+
+// DeviceEvent is a state transition of a device, like it being connected or a job on it finishing
+type DeviceEvent = events.Event
+
+// deviceHistory keeps the latest events of every device in memory, so they can be exported with the other
+// artifacts of a device
+type deviceHistory struct {
+	mu     sync.Mutex
+	events map[string][]DeviceEvent
+	// rebooting are the devices a reboot was requested for, by the time of the request
+	rebooting map[string]time.Time
+	bus       *events.Bus
+}
+
+var history = newDeviceHistory()
+
+func newDeviceHistory() *deviceHistory {
+	return &deviceHistory{events: map[string][]DeviceEvent{}, rebooting: map[string]time.Time{}, bus: events.NewBus()}
+}
+
+func (h *deviceHistory) record(event DeviceEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	recorded := append(h.events[event.UDID], event)
+	if len(recorded) > deviceHistorySize {
+		recorded = recorded[len(recorded)-deviceHistorySize:]
+	}
+	h.events[event.UDID] = recorded
+	if event.Type == events.RebootRequested {
+		h.rebooting[event.UDID] = event.Time
+	}
+	h.bus.Publish(event)
+}
+
+// Events returns the bus all device events are published on. Programs embedding the REST API can subscribe to
+// it instead of using webhooks or the /events stream.
+func Events() *events.Bus {
+	return history.bus
+}
+
+// bootCompleted returns true once if a reboot was requested for the device less than bootTimeout ago
+func (h *deviceHistory) bootCompleted(udid string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	requested, ok := h.rebooting[udid]
+	delete(h.rebooting, udid)
+	return ok && time.Since(requested) < bootTimeout
+}
+
+// between returns the events of the device in the time window, oldest first
+func (h *deviceHistory) between(udid string, from time.Time, to time.Time) []DeviceEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result := []DeviceEvent{}
+	for _, event := range h.events[udid] {
+		if !event.Time.Before(from) && !event.Time.After(to) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// recordRegistry records devices being added, updated and removed until ctx is done
+func (h *deviceHistory) recordRegistry(ctx context.Context, registry *DeviceRegistry) {
+	changes, unsubscribe := registry.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			h.record(DeviceEvent{UDID: change.UDID, Type: events.Type("device-" + string(change.Type))})
+			if change.Type == DeviceAdded && h.bootCompleted(change.UDID) {
+				h.record(DeviceEvent{UDID: change.UDID, Type: events.BootCompleted})
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+)
+
+// maxDeviceListLimit is the largest page size of the device list
+const maxDeviceListLimit = 500
+
+// DeviceV2 is the schema of devices in API v2. All fields are always present, values that are unknown, like
+// the os version of a device whose assets were not collected yet, are empty.
+type DeviceV2 struct {
+	UDID        string `json:"udid"`
+	Name        string `json:"name"`
+	Model       string `json:"model"`
+	DeviceClass string `json:"deviceClass"`
+	OSVersion   string `json:"osVersion"`
+	// Connection is usb or wifi
+	Connection string `json:"connection"`
+	// Paired is only set if it was asked for with the paired filter or field, it needs a pair record lookup per device
+	Paired *bool    `json:"paired,omitempty"`
+	Manual bool     `json:"manual"`
+	Labels []string `json:"labels"`
+}
+
+// deviceV2Fields are the json names of the DeviceV2 fields that can be projected
+var deviceV2Fields = []string{"udid", "name", "model", "deviceClass", "osVersion", "connection", "paired", "manual", "labels"}
+
+// pairedLookup tells whether the host has a pair record for the device
+var pairedLookup = func(udid string) bool {
+	_, err := readPairRecord(udid)
+	return err == nil
+}
+
+func deviceConnection(device ios.DeviceEntry) string {
+	if strings.EqualFold(device.Properties.ConnectionType, "USB") {
+		return "usb"
+	}
+	return "wifi"
+}
+
+func newDeviceV2(registry *DeviceRegistry, device ios.DeviceEntry, withPaired bool) DeviceV2 {
+	udid := device.Properties.SerialNumber
+	result := DeviceV2{UDID: udid, Connection: deviceConnection(device), Manual: registry.IsManual(udid), Labels: registry.Labels(udid)}
+	if result.Labels == nil {
+		result.Labels = []string{}
+	}
+	if asset, ok := assets.get(udid); ok {
+		result.Name = asset.DeviceName
+		result.Model = asset.ProductType
+		result.DeviceClass = asset.DeviceClass
+		result.OSVersion = asset.OSVersion
+	}
+	if withPaired {
+		paired := pairedLookup(udid)
+		result.Paired = &paired
+	}
+	return result
+}
+
+// DeviceListQuery filters, projects and pages the device list
+type DeviceListQuery struct {
+	Label      string
+	OSVersion  string
+	Model      string
+	Connection string
+	Paired     *bool
+	Fields     []string
+	Offset     int
+	Limit      int
+}
+
+// deviceListQueryFrom reads the query params of the device list
+func deviceListQueryFrom(values url.Values) (DeviceListQuery, error) {
+	query := DeviceListQuery{
+		Label:      values.Get("label"),
+		OSVersion:  values.Get("os_version"),
+		Model:      values.Get("model"),
+		Connection: strings.ToLower(values.Get("connection")),
+	}
+	if query.Connection != "" && query.Connection != "usb" && query.Connection != "wifi" {
+		return query, fmt.Errorf("connection must be usb or wifi")
+	}
+	if paired := values.Get("paired"); paired != "" {
+		value, err := strconv.ParseBool(paired)
+		if err != nil {
+			return query, fmt.Errorf("paired must be true or false")
+		}
+		query.Paired = &value
+	}
+	if fields := values.Get("fields"); fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			if !containsString(deviceV2Fields, field) {
+				return query, fmt.Errorf("unknown field '%s', use %s", field, strings.Join(deviceV2Fields, ", "))
+			}
+			query.Fields = append(query.Fields, field)
+		}
+	}
+	var err error
+	if query.Offset, err = intQueryValue(values, "offset", 0); err != nil || query.Offset < 0 {
+		return query, fmt.Errorf("offset must be a positive number")
+	}
+	if query.Limit, err = intQueryValue(values, "limit", 0); err != nil || query.Limit < 0 || query.Limit > maxDeviceListLimit {
+		return query, fmt.Errorf("limit must be a number up to %d", maxDeviceListLimit)
+	}
+	return query, nil
+}
+
+func intQueryValue(values url.Values, key string, fallback int) (int, error) {
+	if values.Get(key) == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(values.Get(key))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// needsPaired is true if the pair state has to be looked up
+func (q DeviceListQuery) needsPaired() bool {
+	return q.Paired != nil || containsString(q.Fields, "paired")
+}
+
+func (q DeviceListQuery) matches(device DeviceV2) bool {
+	if q.Label != "" && !containsString(device.Labels, q.Label) {
+		return false
+	}
+	// 17 matches 17.x, 17.2 matches 17.2 and 17.2.x but not 17.20
+	if q.OSVersion != "" && device.OSVersion != q.OSVersion && !strings.HasPrefix(device.OSVersion, q.OSVersion+".") {
+		return false
+	}
+	if q.Model != "" && !strings.EqualFold(device.DeviceClass, q.Model) && !strings.HasPrefix(strings.ToLower(device.Model), strings.ToLower(q.Model)) {
+		return false
+	}
+	if q.Connection != "" && device.Connection != q.Connection {
+		return false
+	}
+	if q.Paired != nil && (device.Paired == nil || *device.Paired != *q.Paired) {
+		return false
+	}
+	return true
+}
+
+// project returns only the requested fields of the device, or the whole device if no fields were requested
+func (q DeviceListQuery) project(device DeviceV2) interface{} {
+	if len(q.Fields) == 0 {
+		return device
+	}
+	all := map[string]interface{}{
+		"udid": device.UDID, "name": device.Name, "model": device.Model, "deviceClass": device.DeviceClass,
+		"osVersion": device.OSVersion, "connection": device.Connection, "paired": device.Paired,
+		"manual": device.Manual, "labels": device.Labels,
+	}
+	result := make(map[string]interface{}, len(q.Fields))
+	for _, field := range q.Fields {
+		result[field] = all[field]
+	}
+	return result
+}
+
+// list returns the page of matching devices and the number of all matching devices
+func (q DeviceListQuery) list(registry *DeviceRegistry) ([]interface{}, int) {
+	page := []interface{}{}
+	total := 0
+	registry.Range(func(entry ios.DeviceEntry) bool {
+		device := newDeviceV2(registry, entry, q.needsPaired())
+		if !q.matches(device) {
+			return true
+		}
+		if total >= q.Offset && (q.Limit == 0 || len(page) < q.Limit) {
+			page = append(page, q.project(device))
+		}
+		total++
+		return true
+	})
+	return page, total
+}
+
+// setPaginationHeaders sets X-Total-Count and a Link header to the next page if there is one
+func setPaginationHeaders(c *gin.Context, query DeviceListQuery, total int) {
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	if query.Limit == 0 || query.Offset+query.Limit >= total {
+		return
+	}
+	next := c.Request.URL.Query()
+	next.Set("offset", strconv.Itoa(query.Offset+query.Limit))
+	c.Header("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", c.Request.URL.Path, next.Encode()))
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func deviceListRegistry() *DeviceRegistry {
+	registry := NewDeviceRegistry()
+	for _, d := range []struct {
+		udid, connection, productType, class, version string
+	}{
+		{"list-a", "USB", "iPhone14,2", "iPhone", "17.2.1"},
+		{"list-b", "Network", "iPhone12,1", "iPhone", "16.7"},
+		{"list-c", "USB", "iPad13,4", "iPad", "17.20"},
+		{"list-d", "USB", "iPhone14,5", "iPhone", "17.2"},
+	} {
+		registry.Put(ios.DeviceEntry{Properties: ios.DeviceProperties{SerialNumber: d.udid, ConnectionType: d.connection}})
+		assets.put(DeviceAsset{UDID: d.udid, ProductType: d.productType, DeviceClass: d.class, OSVersion: d.version})
+	}
+	registry.SetLabels("list-a", []string{"team-a"})
+	return registry
+}
+
+func listDevices(t *testing.T, registry *DeviceRegistry, query string) ([]DeviceV2, int) {
+	q, err := deviceListQueryFrom(mustParseQuery(t, query))
+	require.NoError(t, err)
+	page, total := q.list(registry)
+	b, err := json.Marshal(page)
+	require.NoError(t, err)
+	var result []DeviceV2
+	require.NoError(t, json.Unmarshal(b, &result))
+	return result, total
+}
+
+func mustParseQuery(t *testing.T, query string) url.Values {
+	values, err := url.ParseQuery(query)
+	require.NoError(t, err)
+	return values
+}
+
+func udidsOf(list []DeviceV2) []string {
+	result := []string{}
+	for _, d := range list {
+		result = append(result, d.UDID)
+	}
+	return result
+}
+
+func TestDeviceListFilters(t *testing.T) {
+	registry := deviceListRegistry()
+	defer func(lookup func(string) bool) { pairedLookup = lookup }(pairedLookup)
+	pairedLookup = func(udid string) bool { return udid == "list-b" }
+
+	all, total := listDevices(t, registry, "")
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []string{"list-a", "list-b", "list-c", "list-d"}, udidsOf(all))
+	assert.Nil(t, all[0].Paired, "pair state is only looked up when asked for")
+	assert.Equal(t, "usb", all[0].Connection)
+	assert.Equal(t, "wifi", all[1].Connection)
+
+	for query, expected := range map[string][]string{
+		"os_version=17":                {"list-a", "list-c", "list-d"},
+		"os_version=17.2":              {"list-a", "list-d"},
+		"model=ipad":                   {"list-c"},
+		"model=iPhone14":               {"list-a", "list-d"},
+		"connection=wifi":              {"list-b"},
+		"paired=true":                  {"list-b"},
+		"paired=false&os_version=17.2": {"list-a", "list-d"},
+		"label=team-a":                 {"list-a"},
+	} {
+		list, _ := listDevices(t, registry, query)
+		assert.Equal(t, expected, udidsOf(list), query)
+	}
+}
+
+func TestDeviceListPagingAndProjection(t *testing.T) {
+	registry := deviceListRegistry()
+	page, total := listDevices(t, registry, "limit=2&offset=1")
+	assert.Equal(t, 4, total)
+	assert.Equal(t, []string{"list-b", "list-c"}, udidsOf(page))
+
+	q, err := deviceListQueryFrom(mustParseQuery(t, "fields=udid,osVersion&limit=1"))
+	require.NoError(t, err)
+	projected, _ := q.list(registry)
+	assert.Equal(t, []interface{}{map[string]interface{}{"udid": "list-a", "osVersion": "17.2.1"}}, projected)
+
+	for _, invalid := range []string{"fields=udid,secret", "connection=bluetooth", "paired=maybe", "limit=501", "offset=-1"} {
+		_, err := deviceListQueryFrom(mustParseQuery(t, invalid))
+		assert.Error(t, err, invalid)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/v2/devices?limit=2&model=iPhone", nil)
+	q, _ = deviceListQueryFrom(c.Request.URL.Query())
+	setPaginationHeaders(c, q, 3)
+	assert.Equal(t, "3", w.Header().Get("X-Total-Count"))
+	assert.Equal(t, `</api/v2/devices?limit=2&model=iPhone&offset=2>; rel="next"`, w.Header().Get("Link"))
+}
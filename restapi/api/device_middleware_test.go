@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceMiddlewareResolvesRegisteredDevices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("middleware-test-udid"))
+	defer devices.Remove("middleware-test-udid")
+
+	r := gin.New()
+	r.GET("/device/:udid", DeviceMiddleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, MustGetDevice(c).Properties.SerialNumber)
+	})
+	r.GET("/device/:udid/paired", DeviceMiddleware(), DevicePairedMiddleware(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/middleware-test-udid", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "middleware-test-udid", w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/middleware-test-udid/paired", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
@@ -0,0 +1,347 @@
+package api
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeviceChangeType tells what happened to a device in the registry
+type DeviceChangeType string
+
+const (
+	DeviceAdded   = DeviceChangeType("added")
+	DeviceUpdated = DeviceChangeType("updated")
+	DeviceRemoved = DeviceChangeType("removed")
+)
+
+// DeviceChange is sent to subscribers of the DeviceRegistry whenever a device is added, updated or removed
+type DeviceChange struct {
+	Type   DeviceChangeType `json:"type"`
+	UDID   string           `json:"udid"`
+	Device ios.DeviceEntry  `json:"device"`
+}
+
+// DeletedDevice is a device that was soft-deleted from the DeviceRegistry
+type DeletedDevice struct {
+	Device    ios.DeviceEntry `json:"device"`
+	Manual    bool            `json:"manual"`
+	DeletedAt time.Time       `json:"deletedAt"`
+}
+
+// DeviceRegistry is the concurrent-safe list of devices known to the API.
+// Reads never copy the whole list, use Snapshot or Range to iterate it. Updates go through Update,
+// which modifies the entry while holding the write lock, so concurrent requests can't overwrite each
+// other's changes like they can with copy-then-mutate. Long running operations that need exclusive
+// access to a device, like a reboot, use LockDevice.
+// Besides the devices usbmuxd reports, devices that are only reachable over the network can be registered
+// manually with PutManual. Those are never removed by the usbmuxd sync.
+type DeviceRegistry struct {
+	mu          sync.RWMutex
+	devices     map[string]ios.DeviceEntry
+	manual      map[string]bool
+	labels      map[string][]string
+	deleted     map[string]DeletedDevice
+	deviceLocks sync.Map
+	subMu       sync.Mutex
+	subscribers map[int]chan DeviceChange
+	nextSubID   int
+}
+
+// deviceChangeBufferSize is how many changes a subscriber can lag behind before changes are dropped for it
+const deviceChangeBufferSize = 64
+
+var devices = NewDeviceRegistry()
+
+// usbmuxdList and usbmuxdListen ask usbmuxd for the attached devices, a DeviceSimulation replaces them
+var (
+	usbmuxdList   = ios.ListDevices
+	usbmuxdListen = ios.Listen
+)
+
+// NewDeviceRegistry creates an empty DeviceRegistry
+func NewDeviceRegistry() *DeviceRegistry {
+	return &DeviceRegistry{
+		devices:     map[string]ios.DeviceEntry{},
+		manual:      map[string]bool{},
+		labels:      map[string][]string{},
+		deleted:     map[string]DeletedDevice{},
+		subscribers: map[int]chan DeviceChange{},
+	}
+}
+
+// Get returns the device with the given udid
+func (r *DeviceRegistry) Get(udid string) (ios.DeviceEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	device, ok := r.devices[udid]
+	return device, ok
+}
+
+// Snapshot returns all devices sorted by udid at the time of the call
+func (r *DeviceRegistry) Snapshot() []ios.DeviceEntry {
+	r.mu.RLock()
+	result := make([]ios.DeviceEntry, 0, len(r.devices))
+	for _, device := range r.devices {
+		result = append(result, device)
+	}
+	r.mu.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Properties.SerialNumber < result[j].Properties.SerialNumber
+	})
+	return result
+}
+
+// Range calls f for every device of a snapshot of the registry until f returns false.
+// The registry is not locked while f runs, so f can modify the registry.
+func (r *DeviceRegistry) Range(f func(device ios.DeviceEntry) bool) {
+	for _, device := range r.Snapshot() {
+		if !f(device) {
+			return
+		}
+	}
+}
+
+// Put adds a device or replaces it if a device with the same udid exists already.
+// A soft-deleted device with the same udid is restored.
+func (r *DeviceRegistry) Put(device ios.DeviceEntry) {
+	r.put(device, false)
+}
+
+// PutManual adds a manually registered device, like a device that is only reachable over the network.
+// Manually registered devices are kept until they are removed explicitly.
+func (r *DeviceRegistry) PutManual(device ios.DeviceEntry) {
+	r.put(device, true)
+}
+
+func (r *DeviceRegistry) put(device ios.DeviceEntry, manual bool) {
+	udid := device.Properties.SerialNumber
+	r.mu.Lock()
+	_, exists := r.devices[udid]
+	r.devices[udid] = device
+	if manual {
+		r.manual[udid] = true
+	}
+	delete(r.deleted, udid)
+	r.mu.Unlock()
+	changeType := DeviceAdded
+	if exists {
+		changeType = DeviceUpdated
+	}
+	r.notify(DeviceChange{Type: changeType, UDID: udid, Device: device})
+}
+
+// Update atomically modifies the device with the given udid. It returns false if the device does not exist.
+func (r *DeviceRegistry) Update(udid string, modify func(device *ios.DeviceEntry)) bool {
+	r.mu.Lock()
+	device, ok := r.devices[udid]
+	if !ok {
+		r.mu.Unlock()
+		return false
+	}
+	modify(&device)
+	r.devices[udid] = device
+	r.mu.Unlock()
+	r.notify(DeviceChange{Type: DeviceUpdated, UDID: udid, Device: device})
+	return true
+}
+
+// IsManual returns true if the device with the given udid was registered with PutManual
+func (r *DeviceRegistry) IsManual(udid string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.manual[udid]
+}
+
+// SetLabels replaces the labels of the device with the given udid. Labels are kept when the device
+// is detached, so they apply again once it is reattached.
+func (r *DeviceRegistry) SetLabels(udid string, labels []string) {
+	sorted := append([]string{}, labels...)
+	sort.Strings(sorted)
+	r.mu.Lock()
+	r.labels[udid] = sorted
+	device, ok := r.devices[udid]
+	r.mu.Unlock()
+	if ok {
+		r.notify(DeviceChange{Type: DeviceUpdated, UDID: udid, Device: device})
+	}
+}
+
+// Labels returns the labels of the device with the given udid
+func (r *DeviceRegistry) Labels(udid string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string{}, r.labels[udid]...)
+}
+
+// HasLabel returns true if the device with the given udid has the label
+func (r *DeviceRegistry) HasLabel(udid string, label string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, l := range r.labels[udid] {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove deletes the device with the given udid. It returns false if the device did not exist.
+func (r *DeviceRegistry) Remove(udid string) bool {
+	r.mu.Lock()
+	device, ok := r.devices[udid]
+	delete(r.devices, udid)
+	delete(r.manual, udid)
+	r.mu.Unlock()
+	if ok {
+		r.notify(DeviceChange{Type: DeviceRemoved, UDID: udid, Device: device})
+	}
+	return ok
+}
+
+// SoftDelete removes the device with the given udid from the registry but remembers it, so it can be listed
+// with Deleted. It returns false if the device did not exist.
+func (r *DeviceRegistry) SoftDelete(udid string) bool {
+	r.mu.Lock()
+	device, ok := r.devices[udid]
+	if ok {
+		r.deleted[udid] = DeletedDevice{Device: device, Manual: r.manual[udid], DeletedAt: time.Now()}
+		delete(r.devices, udid)
+		delete(r.manual, udid)
+	}
+	r.mu.Unlock()
+	if ok {
+		r.notify(DeviceChange{Type: DeviceRemoved, UDID: udid, Device: device})
+	}
+	return ok
+}
+
+// Deleted returns all soft-deleted devices sorted by udid
+func (r *DeviceRegistry) Deleted() []DeletedDevice {
+	r.mu.RLock()
+	result := make([]DeletedDevice, 0, len(r.deleted))
+	for _, device := range r.deleted {
+		result = append(result, device)
+	}
+	r.mu.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Device.Properties.SerialNumber < result[j].Device.Properties.SerialNumber
+	})
+	return result
+}
+
+// LockDevice acquires the exclusive lock of a device and returns the function to release it.
+// The lock is independent of the registry lock, holding it does not block reads or updates of the entry.
+func (r *DeviceRegistry) LockDevice(udid string) func() {
+	lock, _ := r.deviceLocks.LoadOrStore(udid, &sync.Mutex{})
+	mutex := lock.(*sync.Mutex)
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+// Subscribe returns a channel receiving all changes made to the registry and a function to unsubscribe.
+// Slow subscribers miss changes instead of blocking the registry.
+func (r *DeviceRegistry) Subscribe() (<-chan DeviceChange, func()) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	id := r.nextSubID
+	r.nextSubID++
+	c := make(chan DeviceChange, deviceChangeBufferSize)
+	r.subscribers[id] = c
+	return c, func() {
+		r.subMu.Lock()
+		defer r.subMu.Unlock()
+		if _, ok := r.subscribers[id]; ok {
+			delete(r.subscribers, id)
+			close(c)
+		}
+	}
+}
+
+func (r *DeviceRegistry) notify(change DeviceChange) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+	for _, c := range r.subscribers {
+		select {
+		case c <- change:
+		default:
+			log.WithField("udid", change.UDID).Warn("device change subscriber too slow, dropping change")
+		}
+	}
+}
+
+// syncWithUsbmuxd keeps the registry in sync with the devices usbmuxd reports until ctx is done.
+// If the connection to usbmuxd breaks, it reconnects after a short delay.
+func (r *DeviceRegistry) syncWithUsbmuxd(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := r.listenUsbmuxd(ctx)
+		if err != nil {
+			log.WithError(err).Warn("lost connection to usbmuxd, retrying")
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (r *DeviceRegistry) listenUsbmuxd(ctx context.Context) error {
+	list, err := usbmuxdList()
+	if err != nil {
+		return err
+	}
+	attached := map[int]string{}
+	connected := map[string]bool{}
+	for _, device := range list.DeviceList {
+		attached[device.DeviceID] = device.Properties.SerialNumber
+		connected[device.Properties.SerialNumber] = true
+		if !r.IsManual(device.Properties.SerialNumber) {
+			r.Put(device)
+		}
+	}
+	// devices might have been detached while we were not listening
+	r.Range(func(device ios.DeviceEntry) bool {
+		if !connected[device.Properties.SerialNumber] && !r.IsManual(device.Properties.SerialNumber) {
+			r.Remove(device.Properties.SerialNumber)
+		}
+		return true
+	})
+
+	receive, closeListener, err := usbmuxdListen()
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			closeListener()
+		case <-done:
+			closeListener()
+		}
+	}()
+	for {
+		msg, err := receive()
+		if err != nil {
+			return err
+		}
+		if msg.DeviceAttached() {
+			attached[msg.DeviceID] = msg.Properties.SerialNumber
+			if !r.IsManual(msg.Properties.SerialNumber) {
+				r.Put(msg.DeviceEntry())
+			}
+		}
+		if msg.DeviceDetached() {
+			udid, ok := attached[msg.DeviceID]
+			delete(attached, msg.DeviceID)
+			if ok && !r.IsManual(udid) {
+				r.Remove(udid)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+)
+
+func testDevice(udid string) ios.DeviceEntry {
+	return ios.DeviceEntry{Properties: ios.DeviceProperties{SerialNumber: udid}}
+}
+
+func TestDeviceRegistryConcurrentUpdates(t *testing.T) {
+	registry := NewDeviceRegistry()
+	registry.Put(testDevice("a"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			registry.Update("a", func(device *ios.DeviceEntry) {
+				device.DeviceID++
+			})
+		}()
+	}
+	wg.Wait()
+
+	device, ok := registry.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 100, device.DeviceID)
+	assert.False(t, registry.Update("missing", func(device *ios.DeviceEntry) {}))
+}
+
+func TestDeviceRegistrySnapshot(t *testing.T) {
+	registry := NewDeviceRegistry()
+	for _, udid := range []string{"c", "a", "b"} {
+		registry.Put(testDevice(udid))
+	}
+	var udids []string
+	registry.Range(func(device ios.DeviceEntry) bool {
+		udids = append(udids, device.Properties.SerialNumber)
+		// modifying the registry while iterating must not deadlock
+		registry.Remove(device.Properties.SerialNumber)
+		return true
+	})
+	assert.Equal(t, []string{"a", "b", "c"}, udids)
+	assert.Empty(t, registry.Snapshot())
+}
+
+func TestDeviceRegistryNotifications(t *testing.T) {
+	registry := NewDeviceRegistry()
+	changes, unsubscribe := registry.Subscribe()
+
+	registry.Put(testDevice("a"))
+	registry.Put(testDevice("a"))
+	registry.Remove("a")
+	registry.Remove("a")
+	unsubscribe()
+
+	var received []string
+	for change := range changes {
+		received = append(received, fmt.Sprintf("%s:%s", change.Type, change.UDID))
+	}
+	assert.Equal(t, []string{"added:a", "updated:a", "removed:a"}, received)
+}
+
+func TestDeviceRegistrySoftDelete(t *testing.T) {
+	registry := NewDeviceRegistry()
+	registry.PutManual(testDevice("a"))
+	registry.Put(testDevice("b"))
+	assert.True(t, registry.IsManual("a"))
+	assert.False(t, registry.IsManual("b"))
+
+	assert.True(t, registry.SoftDelete("a"))
+	assert.False(t, registry.SoftDelete("a"))
+	_, ok := registry.Get("a")
+	assert.False(t, ok)
+	deleted := registry.Deleted()
+	if assert.Len(t, deleted, 1) {
+		assert.Equal(t, "a", deleted[0].Device.Properties.SerialNumber)
+		assert.True(t, deleted[0].Manual)
+	}
+
+	registry.Put(testDevice("a"))
+	assert.Empty(t, registry.Deleted())
+	assert.False(t, registry.IsManual("a"))
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultStoryHours = 24
+	maxStoryHours     = 7 * 24
+)
+
+// StoryEntry is one thing that happened to a device, told in a sentence
+type StoryEntry struct {
+	Time time.Time `json:"time"`
+	// Until is the time of the last repetition if the same thing happened several times in a row
+	Until *time.Time  `json:"until,omitempty"`
+	Count int         `json:"count"`
+	Type  events.Type `json:"type"`
+	// Severity is info, warning or error
+	Severity string `json:"severity"`
+	Text     string `json:"text"`
+}
+
+// DeviceStory is what happened to a device in a time window, oldest first
+type DeviceStory struct {
+	UDID    string       `json:"udid"`
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	Summary string       `json:"summary"`
+	Entries []StoryEntry `json:"entries"`
+}
+
+// narrate tells an event in a sentence and rates how bad it is
+func narrate(event DeviceEvent) (string, string) {
+	suffix := ""
+	if event.Message != "" {
+		suffix = ": " + event.Message
+	}
+	switch event.Type {
+	case events.DeviceAdded:
+		return "Device connected", "info"
+	case events.DeviceRemoved:
+		return "Device disconnected", "warning"
+	case events.DeviceUpdated:
+		return "Device properties changed", "info"
+	case events.DevicePaired:
+		return "Device was paired" + suffix, "info"
+	case events.PairingInvalid:
+		return "Device rejected the pair record" + suffix, "error"
+	case events.Repaired:
+		return "Device was paired again" + suffix, "info"
+	case events.RepairFailed:
+		return "Pairing the device again failed" + suffix, "error"
+	case events.ImageMounted:
+		return "Developer image was mounted", "info"
+	case events.RebootRequested:
+		return "Reboot was requested" + suffix, "info"
+	case events.BootCompleted:
+		return "Device came back after the reboot", "info"
+	case events.DriftDetected:
+		return "Device drifted from its golden state" + suffix, "warning"
+	case events.OSUpdateRequired:
+		return "Device needs an os update" + suffix, "warning"
+	case events.HealthCheck:
+		if strings.HasPrefix(event.Message, "failed") {
+			return "Health check " + event.Message, "error"
+		}
+		return "Health check passed", "info"
+	case events.HookFailed:
+		return "Job hook failed" + suffix, "warning"
+	case events.SessionRecording:
+		return "Session recording " + event.Recording + " finished", "info"
+	case events.TestFinished:
+		if event.Test == nil {
+			return "XCUITest finished" + suffix, "info"
+		}
+		text := fmt.Sprintf("XCUITest of %s %s, %d passed, %d failed", event.Test.BundleID, event.Test.State, event.Test.Passed, event.Test.Failed)
+		if event.Test.State != "finished" || event.Test.Failed > 0 {
+			return text, "error"
+		}
+		return text, "info"
+	}
+	if event.Job != nil {
+		text := fmt.Sprintf("Job %s %s %s", event.Job.Type, event.Job.ID, strings.TrimPrefix(string(event.Type), "job-"))
+		if event.Job.Error != "" {
+			text += ": " + event.Job.Error
+		}
+		switch event.Type {
+		case events.JobFailed:
+			return text, "error"
+		case events.JobCanceled:
+			return text, "warning"
+		}
+		return text, "info"
+	}
+	return string(event.Type) + suffix, "info"
+}
+
+// tellStory narrates the events, the same thing happening several times in a row is told once
+func tellStory(udid string, from time.Time, to time.Time, recorded []DeviceEvent) DeviceStory {
+	story := DeviceStory{UDID: udid, From: from, To: to, Entries: []StoryEntry{}}
+	jobs, failedJobs, failures, remediations := 0, 0, 0, 0
+	for _, event := range recorded {
+		text, severity := narrate(event)
+		switch event.Type {
+		case events.JobRunning:
+			jobs++
+		case events.JobFailed:
+			failedJobs++
+		case events.Repaired:
+			remediations++
+		}
+		if severity == "error" {
+			failures++
+		}
+		if n := len(story.Entries); n > 0 && story.Entries[n-1].Text == text {
+			last := &story.Entries[n-1]
+			last.Count++
+			until := event.Time
+			last.Until = &until
+			continue
+		}
+		story.Entries = append(story.Entries, StoryEntry{Time: event.Time, Count: 1, Type: event.Type, Severity: severity, Text: text})
+	}
+	if len(recorded) == 0 {
+		story.Summary = "Nothing happened."
+		return story
+	}
+	story.Summary = fmt.Sprintf("%d events, %d jobs started, %d jobs failed, %d errors, %d remediations.", len(recorded), jobs, failedJobs, failures, remediations)
+	return story
+}
+
+// text renders the story for humans, one line per entry
+func (s DeviceStory) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Device %s from %s to %s\n%s\n\n", s.UDID, s.From.Format(time.RFC3339), s.To.Format(time.RFC3339), s.Summary)
+	for _, entry := range s.Entries {
+		marker := ""
+		switch entry.Severity {
+		case "error":
+			marker = "ERROR "
+		case "warning":
+			marker = "WARN  "
+		}
+		line := fmt.Sprintf("%s  %s%s", entry.Time.Format("2006-01-02 15:04:05"), marker, entry.Text)
+		if entry.Count > 1 {
+			line += fmt.Sprintf(" (%d times until %s)", entry.Count, entry.Until.Format("15:04:05"))
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+// GetDeviceStory tells what happened to a device
+// @Summary      Get the recent activity of a device as a story
+// @Description  Tells the connections, pairing, jobs, xcuitest runs, health checks, errors and remediations of the last hours in order, one sentence each. The same thing happening several times in a row is told once with a count. Returns plain text, or json with format=json.
+// @Tags         general_device_specific
+// @Produce      plain,json
+// @Param        udid path string true "Device UDID"
+// @Param        hours query int false "how many hours to look back, up to 168, defaults to 24"
+// @Param        format query string false "text or json, defaults to text"
+// @Success      200  {object}  DeviceStory
+// @Failure      422  {object}  GenericResponse
+// @Router       /device/{udid}/story [get]
+func GetDeviceStory(c *gin.Context) {
+	hours := defaultStoryHours
+	if value := c.Query("hours"); value != "" {
+		var err error
+		hours, err = strconv.Atoi(value)
+		if err != nil || hours < 1 || hours > maxStoryHours {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: fmt.Sprintf("hours must be a number from 1 to %d", maxStoryHours)})
+			return
+		}
+	}
+	udid := MustGetDevice(c).Properties.SerialNumber
+	to := time.Now()
+	from := to.Add(-time.Duration(hours) * time.Hour)
+	story := tellStory(udid, from, to, history.between(udid, from, to))
+	if c.Query("format") == "json" {
+		c.JSON(http.StatusOK, story)
+		return
+	}
+	c.String(http.StatusOK, story.text())
+}
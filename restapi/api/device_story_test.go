@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTellStory(t *testing.T) {
+	start := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	recorded := []DeviceEvent{
+		{Time: at(1), Type: events.DeviceAdded},
+		{Time: at(2), Type: events.JobRunning, Job: &events.Job{ID: "1", Type: "install", State: "running"}},
+		{Time: at(3), Type: events.JobFailed, Job: &events.Job{ID: "1", Type: "install", State: "failed", Error: "ApplicationVerificationFailed"}},
+		{Time: at(4), Type: events.PairingInvalid, Message: "InvalidHostID"},
+		{Time: at(5), Type: events.Repaired, Message: "supervised"},
+		{Time: at(6), Type: events.DeviceUpdated},
+		{Time: at(7), Type: events.DeviceUpdated},
+		{Time: at(8), Type: events.DeviceUpdated},
+	}
+	story := tellStory("story-a", start, at(60), recorded)
+	require.Len(t, story.Entries, 6)
+	assert.Equal(t, "Job install 1 failed: ApplicationVerificationFailed", story.Entries[2].Text)
+	assert.Equal(t, "error", story.Entries[2].Severity)
+	assert.Equal(t, "Device rejected the pair record: InvalidHostID", story.Entries[3].Text)
+	assert.Equal(t, 3, story.Entries[5].Count)
+	assert.Equal(t, at(8), *story.Entries[5].Until)
+	assert.Equal(t, "8 events, 1 jobs started, 1 jobs failed, 2 errors, 1 remediations.", story.Summary)
+
+	text := story.text()
+	assert.Contains(t, text, "2024-03-05 10:03:00  ERROR Job install 1 failed: ApplicationVerificationFailed\n")
+	assert.Contains(t, text, "Device properties changed (3 times until 10:08:00)\n")
+
+	assert.Equal(t, "Nothing happened.", tellStory("story-a", start, at(60), nil).Summary)
+}
+
+func TestGetDeviceStory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("story-a"))
+	defer devices.Remove("story-a")
+	defer func(h *deviceHistory) { history = h }(history)
+	history = newDeviceHistory()
+	history.record(DeviceEvent{UDID: "story-a", Type: events.RebootRequested, Message: "maintenance window"})
+	history.record(DeviceEvent{UDID: "story-a", Type: events.DeviceAdded, Time: time.Now().Add(-48 * time.Hour)})
+
+	r := gin.New()
+	r.GET("/device/:udid/story", DeviceMiddleware(), GetDeviceStory)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/story-a/story?format=json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var story DeviceStory
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &story))
+	require.Len(t, story.Entries, 1, "events older than 24 hours are left out")
+	assert.Equal(t, "Reboot was requested: maintenance window", story.Entries[0].Text)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/story-a/story?hours=72", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Device connected")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/story-a/story?hours=1000", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
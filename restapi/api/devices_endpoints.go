@@ -0,0 +1,145 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisteredDevice is a device of the device registry
+type RegisteredDevice struct {
+	ios.DeviceEntry
+	// Manual is true for devices that were registered with POST /devices instead of being reported by usbmuxd
+	Manual bool     `json:"manual"`
+	Labels []string `json:"labels"`
+}
+
+// RegisterDeviceRequest describes a device that is only reachable over the network, f.ex. through a tunnel
+type RegisterDeviceRequest struct {
+	UDID    string `json:"udid"`
+	Address string `json:"address"`
+	RsdPort int    `json:"rsdPort"`
+	// UserspaceTUNPort is the port of the userspace tunnel, leave it empty for kernel TUN devices
+	UserspaceTUNPort int `json:"userspaceTunPort,omitempty"`
+	// PairRecord is saved to usbmuxd if provided. Use `ios readpair` on the host the device was paired with to get it.
+	PairRecord *ios.PairRecord `json:"pairRecord,omitempty"`
+}
+
+// ListRegisteredDevices lists the devices of the device registry
+// @Summary      List registered devices
+// @Description  Lists all devices known to the API, the ones usbmuxd reports and the manually registered ones. Use deleted=true to list soft-deleted devices instead.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  []RegisteredDevice
+// @Param        deleted query bool false "List soft-deleted devices"
+// @Router       /devices [get]
+func ListRegisteredDevices(c *gin.Context) {
+	if c.Query("deleted") == "true" {
+		c.IndentedJSON(http.StatusOK, devices.Deleted())
+		return
+	}
+	result := []RegisteredDevice{}
+	devices.Range(func(device ios.DeviceEntry) bool {
+		udid := device.Properties.SerialNumber
+		result = append(result, RegisteredDevice{DeviceEntry: device, Manual: devices.IsManual(udid), Labels: devices.Labels(udid)})
+		return true
+	})
+	c.IndentedJSON(http.StatusOK, result)
+}
+
+// RegisterDevice registers a device that is only reachable over the network
+// @Summary      Register a network device
+// @Description  Registers a device that is reachable over Wi-Fi or a tunnel only. The RSD handshake is done right away, so the device needs to be reachable. If a pair record is provided, it is saved to usbmuxd.
+// @Tags         general
+// @Accept       json
+// @Produce      json
+// @Param        device body RegisterDeviceRequest true "Device to register"
+// @Success      201  {object}  RegisteredDevice
+// @Failure      409  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /devices [post]
+func RegisterDevice(c *gin.Context) {
+	var request RegisterDeviceRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	if request.UDID == "" || request.Address == "" || request.RsdPort == 0 {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "udid, address and rsdPort are required"})
+		return
+	}
+	if request.PairRecord != nil {
+		err = ios.SavePairRecord(request.UDID, *request.PairRecord)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+			return
+		}
+	}
+	device := ios.DeviceEntry{
+		Properties:       ios.DeviceProperties{SerialNumber: request.UDID, ConnectionType: "Network"},
+		Address:          request.Address,
+		UserspaceTUN:     request.UserspaceTUNPort != 0,
+		UserspaceTUNPort: request.UserspaceTUNPort,
+	}
+	rsdService, err := ios.NewWithAddrPortDevice(request.Address, request.RsdPort, device)
+	if err != nil {
+		c.JSON(http.StatusConflict, GenericResponse{Error: "device not reachable: " + err.Error()})
+		return
+	}
+	defer rsdService.Close()
+	rsd, err := rsdService.Handshake()
+	if err != nil {
+		c.JSON(http.StatusConflict, GenericResponse{Error: "RSD handshake failed: " + err.Error()})
+		return
+	}
+	if rsd.Udid != "" && rsd.Udid != request.UDID {
+		c.JSON(http.StatusConflict, GenericResponse{Error: "device at " + request.Address + " has udid " + rsd.Udid})
+		return
+	}
+	device.Rsd = rsd
+	devices.PutManual(device)
+	c.IndentedJSON(http.StatusCreated, RegisteredDevice{DeviceEntry: device, Manual: true, Labels: devices.Labels(request.UDID)})
+}
+
+// SoftDeleteDevice soft-deletes a device from the device registry
+// @Summary      Soft-delete a device
+// @Description  Removes a stale device from the registry. It can still be listed with GET /devices?deleted=true and is restored when usbmuxd reports it again or it is registered again.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Param        udid path string true "Device UDID"
+// @Router       /devices/{udid} [delete]
+func SoftDeleteDevice(c *gin.Context) {
+	if !devices.SoftDelete(c.Param("udid")) {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "device not found"})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "device deleted"})
+}
+
+// SetDeviceLabels replaces the labels of a device
+// @Summary      Set the labels of a device
+// @Description  Replaces the labels of a device. Labels are used to select devices, f.ex. for maintenance windows.
+// @Tags         general_device_specific
+// @Accept       json
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        labels body []string true "Labels"
+// @Success      200  {object}  []string
+// @Failure      422  {object}  GenericResponse
+// @Router       /device/{udid}/labels [put]
+func SetDeviceLabels(c *gin.Context) {
+	var labels []string
+	err := c.ShouldBindJSON(&labels)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	udid := MustGetDevice(c).Properties.SerialNumber
+	devices.SetLabels(udid, labels)
+	c.JSON(http.StatusOK, devices.Labels(udid))
+}
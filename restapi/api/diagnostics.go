@@ -0,0 +1,171 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/diagnostics"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultDiagnosticsMaxAge is how old cached diagnostics may be if the max_age query param is missing. Dashboards
+// polling many devices share the cached values instead of connecting to every device on every request.
+const defaultDiagnosticsMaxAge = 30 * time.Second
+
+// BatteryResponse is the battery state of a device
+type BatteryResponse struct {
+	diagnostics.Battery
+	Collected time.Time `json:"collected"`
+}
+
+// MobileGestaltResponse are the MobileGestalt values of a device
+type MobileGestaltResponse struct {
+	Values    map[string]interface{} `json:"values"`
+	Collected time.Time              `json:"collected"`
+}
+
+type diagnosticsEntry struct {
+	value     interface{}
+	collected time.Time
+}
+
+// diagnosticsCache keeps the last diagnostics of a device by udid and query
+type diagnosticsCache struct {
+	mu      sync.Mutex
+	entries map[string]diagnosticsEntry
+}
+
+var diagnosticsValues = &diagnosticsCache{entries: map[string]diagnosticsEntry{}}
+
+// get returns the cached value if it is at most maxAge old, or collects and caches a new one
+func (d *diagnosticsCache) get(key string, maxAge time.Duration, collect func() (interface{}, error)) (interface{}, time.Time, error) {
+	d.mu.Lock()
+	entry, ok := d.entries[key]
+	d.mu.Unlock()
+	if ok && time.Since(entry.collected) <= maxAge {
+		return entry.value, entry.collected, nil
+	}
+	value, err := collect()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	entry = diagnosticsEntry{value: value, collected: time.Now()}
+	d.mu.Lock()
+	d.entries[key] = entry
+	d.mu.Unlock()
+	return entry.value, entry.collected, nil
+}
+
+// diagnosticsMaxAge parses the max_age query param in seconds
+func diagnosticsMaxAge(c *gin.Context) (time.Duration, error) {
+	value := c.Query("max_age")
+	if value == "" {
+		return defaultDiagnosticsMaxAge, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, errors.New("max_age must be a number of seconds")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// gestaltKeys parses the comma separated keys query param, sorted so the cache key does not depend on the order
+func gestaltKeys(query string) []string {
+	keys := []string{}
+	for _, key := range strings.Split(query, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" && !containsString(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// queryDiagnostics connects to the diagnostics service of the device, tests replace it
+var queryDiagnostics = func(device ios.DeviceEntry, query func(*diagnostics.Connection) (interface{}, error)) (interface{}, error) {
+	conn, err := diagnostics.New(device)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return query(conn)
+}
+
+// GetBattery returns the battery state
+// @Summary      Get the battery state of a device
+// @Description  Returns charge, health, cycle count and temperature from the diagnostics service. Values up to max_age seconds old are returned from a cache, so dashboards can poll many devices.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        max_age query int false "maximum age of cached values in seconds, 0 always queries the device, defaults to 30"
+// @Success      200  {object}  BatteryResponse
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/battery [get]
+func GetBattery(c *gin.Context) {
+	maxAge, err := diagnosticsMaxAge(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	device := MustGetDevice(c)
+	value, collected, err := diagnosticsValues.get(device.Properties.SerialNumber+"/battery", maxAge, func() (interface{}, error) {
+		return queryDiagnostics(device, func(conn *diagnostics.Connection) (interface{}, error) {
+			return conn.Battery()
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, BatteryResponse{Battery: value.(diagnostics.Battery), Collected: collected})
+}
+
+// GetMobileGestalt returns MobileGestalt values
+// @Summary      Get MobileGestalt values of a device
+// @Description  Returns the values of the MobileGestalt keys from the diagnostics service, keys unknown to the device are missing. Newer iOS versions no longer answer MobileGestalt queries, 501 is returned then. Values up to max_age seconds old are returned from a cache.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        keys query string true "comma separated MobileGestalt keys, f.ex. BatteryCurrentCapacity,DeviceName"
+// @Param        max_age query int false "maximum age of cached values in seconds, 0 always queries the device, defaults to 30"
+// @Success      200  {object}  MobileGestaltResponse
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Failure      501  {object}  GenericResponse
+// @Router       /device/{udid}/mobilegestalt [get]
+func GetMobileGestalt(c *gin.Context) {
+	keys := gestaltKeys(c.Query("keys"))
+	if len(keys) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "keys is missing"})
+		return
+	}
+	maxAge, err := diagnosticsMaxAge(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	device := MustGetDevice(c)
+	key := device.Properties.SerialNumber + "/gestalt/" + strings.Join(keys, ",")
+	value, collected, err := diagnosticsValues.get(key, maxAge, func() (interface{}, error) {
+		return queryDiagnostics(device, func(conn *diagnostics.Connection) (interface{}, error) {
+			return conn.MobileGestalt(keys)
+		})
+	})
+	if errors.Is(err, diagnostics.ErrMobileGestaltDeprecated) {
+		c.JSON(http.StatusNotImplemented, GenericResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, MobileGestaltResponse{Values: value.(map[string]interface{}), Collected: collected})
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/diagnostics"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiagnosticsCache(t *testing.T) {
+	cache := &diagnosticsCache{entries: map[string]diagnosticsEntry{}}
+	calls := 0
+	collect := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+	value, first, err := cache.get("a", time.Minute, collect)
+	require.NoError(t, err)
+	assert.Equal(t, 1, value)
+	value, collected, err := cache.get("a", time.Minute, collect)
+	require.NoError(t, err)
+	assert.Equal(t, 1, value, "cached values are shared")
+	assert.Equal(t, first, collected)
+	value, _, err = cache.get("a", 0, collect)
+	require.NoError(t, err)
+	assert.Equal(t, 2, value)
+
+	_, _, err = cache.get("b", time.Minute, func() (interface{}, error) { return nil, errors.New("lost") })
+	assert.Error(t, err)
+	_, ok := cache.entries["b"]
+	assert.False(t, ok, "errors are not cached")
+}
+
+func TestGestaltKeys(t *testing.T) {
+	assert.Equal(t, []string{"BatteryCurrentCapacity", "DeviceName"}, gestaltKeys("DeviceName, BatteryCurrentCapacity,,DeviceName"))
+	assert.Empty(t, gestaltKeys(" , "))
+}
+
+func TestGetMobileGestalt(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("gestalt-a"))
+	defer devices.Remove("gestalt-a")
+	defer func(query func(ios.DeviceEntry, func(*diagnostics.Connection) (interface{}, error)) (interface{}, error)) {
+		queryDiagnostics = query
+	}(queryDiagnostics)
+	queryDiagnostics = func(device ios.DeviceEntry, query func(*diagnostics.Connection) (interface{}, error)) (interface{}, error) {
+		return nil, diagnostics.ErrMobileGestaltDeprecated
+	}
+
+	r := gin.New()
+	r.GET("/device/:udid/mobilegestalt", DeviceMiddleware(), GetMobileGestalt)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/gestalt-a/mobilegestalt", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/gestalt-a/mobilegestalt?keys=DeviceName&max_age=0", nil))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+)
+
+// eventKeepAliveInterval is how often an idle event stream gets a comment, so proxies don't close it
+const eventKeepAliveInterval = 15 * time.Second
+
+// StreamEvents streams device events as server-sent events
+// @Summary      Stream device events
+// @Description  Streams the events of the device history as server-sent events, named after the event type, like device-added, device-removed, device-updated, device-paired, image-mounted, boot-completed, job-succeeded or xcuitest-finished. Use it in dashboards instead of polling /devices. Idle streams get a comment every 15 seconds.
+// @Tags         general
+// @Produce      text/event-stream
+// @Param        udid query []string false "only events of these devices" collectionFormat(multi)
+// @Param        type query []string false "only events of these types" collectionFormat(multi)
+// @Success      200  {object}  DeviceEvent
+// @Router       /events [get]
+func StreamEvents(c *gin.Context) {
+	filter := events.Filter{UDIDs: c.QueryArray("udid")}
+	for _, t := range c.QueryArray("type") {
+		filter.Types = append(filter.Types, events.Type(t))
+	}
+	subscription := history.bus.Subscribe(filter, events.DefaultBufferSize)
+	defer subscription.Close()
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	// send the headers right away, so clients know the stream is open before the first event
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-keepAlive.C:
+			_, err := w.Write([]byte(": keep-alive\n\n"))
+			return err == nil
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(string(event.Type), event)
+			return true
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(h *deviceHistory) { history = h }(history)
+	history = newDeviceHistory()
+	r := gin.New()
+	r.GET("/events", StreamingHeaderMiddleware(), StreamEvents)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events?udid=events-a", nil)
+	require.NoError(t, err)
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	history.record(DeviceEvent{UDID: "events-b", Type: "device-added"})
+	history.record(DeviceEvent{UDID: "events-a", Type: "image-mounted"})
+	reader := bufio.NewReader(resp.Body)
+	name, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event:image-mounted\n", name)
+	data, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(data, "data:{"))
+	assert.Contains(t, data, `"udid":"events-a"`)
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/crashreport"
+	"github.com/danielpaulus/go-ios/ios/workspace"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxExportWindow limits how much an export collects, crash reports of a longer window take very long to download
+const maxExportWindow = 7 * 24 * time.Hour
+
+// ExportManifest describes the contents of a device export, it is the manifest.json of the archive
+type ExportManifest struct {
+	UDID       string    `json:"udid"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	Created    time.Time `json:"created"`
+	Events     int       `json:"events"`
+	Jobs       int       `json:"jobs"`
+	Recordings []string  `json:"recordings"`
+	Crashes    []string  `json:"crashes"`
+	Screenshot string    `json:"screenshot,omitempty"`
+	Errors     []string  `json:"errors,omitempty"`
+}
+
+// exportWindow parses the from and to query params, to defaults to now
+func exportWindow(c *gin.Context) (time.Time, time.Time, error) {
+	from, err := time.Parse(time.RFC3339, c.Query("from"))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be a RFC3339 time like 2024-01-16T15:00:00Z")
+	}
+	to := time.Now()
+	if c.Query("to") != "" {
+		to, err = time.Parse(time.RFC3339, c.Query("to"))
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("to must be a RFC3339 time like 2024-01-16T16:00:00Z")
+		}
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	if to.Sub(from) > maxExportWindow {
+		return time.Time{}, time.Time{}, fmt.Errorf("the time window must not be longer than %s", maxExportWindow)
+	}
+	return from, to, nil
+}
+
+// ExportDevice bundles everything collected of a device in a time window
+// @Summary      Export logs and artifacts of a device for a time window
+// @Description  Returns a zip archive with everything collected of the device between from and to: the state transitions of the device (connects, disconnects, jobs), the jobs, the session recordings with their screen frames and syslog, the crash reports on the device and the last wallboard screenshot. manifest.json lists the contents and the parts that could not be collected, f.ex. crash reports of a disconnected device.
+// @Tags         general_device_specific
+// @Produce      application/zip
+// @Param        udid path string true "Device UDID"
+// @Param        from query string true "start of the window as RFC3339 time, f.ex. 2024-01-16T15:00:00Z"
+// @Param        to query string false "end of the window as RFC3339 time, defaults to now"
+// @Success      200
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/export [get]
+func ExportDevice(c *gin.Context) {
+	from, to, err := exportWindow(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	device := MustGetDevice(c)
+	export, err := prepareExport(device, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer export.close()
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.filename()))
+	c.Status(http.StatusOK)
+	err = export.write(c.Writer)
+	if err != nil {
+		log.WithField("udid", export.udid).WithError(err).Warn("export aborted")
+	}
+}
+
+// deviceExport has everything of an export that needs the device, so the archive can be written afterwards
+type deviceExport struct {
+	udid     string
+	from     time.Time
+	to       time.Time
+	ws       *workspace.Workspace
+	crashDir string
+	crashes  []string
+	crashErr error
+}
+
+// prepareExport downloads the crash reports before the archive is written, so failing to reach the device can
+// still be reported in the manifest
+func prepareExport(device ios.DeviceEntry, from time.Time, to time.Time) (*deviceExport, error) {
+	udid := device.Properties.SerialNumber
+	ws, err := workspace.Default().New("export-" + udid)
+	if err != nil {
+		return nil, err
+	}
+	export := &deviceExport{udid: udid, from: from, to: to, ws: ws, crashDir: ws.Path("crashes")}
+	export.crashes, export.crashErr = downloadCrashReports(device, from, to, export.crashDir)
+	return export, nil
+}
+
+func (e *deviceExport) filename() string {
+	return fmt.Sprintf("%s-%s.zip", e.udid, e.from.UTC().Format("20060102T150405Z"))
+}
+
+// write writes the zip archive to w
+func (e *deviceExport) write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	manifest := writeExport(zw, e.udid, e.from, e.to, e.crashDir, e.crashes, e.crashErr)
+	err := writeZipJSON(zw, "manifest.json", manifest)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (e *deviceExport) close() {
+	e.ws.Close()
+}
+
+func downloadCrashReports(device ios.DeviceEntry, from time.Time, to time.Time, dir string) ([]string, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return crashreport.DownloadReportsBetween(device, from, to, dir)
+}
+
+// writeExport writes everything but the manifest to the archive and returns the manifest
+func writeExport(w *zip.Writer, udid string, from time.Time, to time.Time, crashDir string, crashes []string, crashErr error) ExportManifest {
+	manifest := ExportManifest{UDID: udid, From: from, To: to, Created: time.Now(), Recordings: []string{}, Crashes: []string{}}
+	addError := func(part string, err error) {
+		manifest.Errors = append(manifest.Errors, fmt.Sprintf("%s: %s", part, err.Error()))
+	}
+
+	events := history.between(udid, from, to)
+	manifest.Events = len(events)
+	if err := writeZipJSON(w, "events.json", events); err != nil {
+		addError("events", err)
+	}
+
+	deviceJobs := []Job{}
+	for _, job := range jobs.list(udid) {
+		if job.Created.After(to) || (job.Finished != nil && job.Finished.Before(from)) {
+			continue
+		}
+		deviceJobs = append(deviceJobs, job)
+	}
+	manifest.Jobs = len(deviceJobs)
+	if err := writeZipJSON(w, "jobs.json", deviceJobs); err != nil {
+		addError("jobs", err)
+	}
+
+	for _, event := range events {
+		if event.Recording == "" {
+			continue
+		}
+		name := path.Join("recordings", event.Recording+".zip")
+		err := writeZipFile(w, name, recordingPath(udid, event.Recording))
+		if err != nil {
+			addError("recording "+event.Recording, err)
+			continue
+		}
+		manifest.Recordings = append(manifest.Recordings, name)
+	}
+
+	if crashErr != nil {
+		addError("crash reports", crashErr)
+	}
+	for _, crash := range crashes {
+		name := path.Join("crashes", crash)
+		err := writeZipFile(w, name, filepath.Join(crashDir, filepath.FromSlash(crash)))
+		if err != nil {
+			addError("crash report "+crash, err)
+			continue
+		}
+		manifest.Crashes = append(manifest.Crashes, name)
+	}
+
+	if screen, ok := screens.get(udid); ok && len(screen.png) > 0 && !screen.CapturedAt.Before(from) && !screen.CapturedAt.After(to) {
+		name := fmt.Sprintf("screenshots/%s.png", screen.CapturedAt.UTC().Format("20060102T150405Z"))
+		entry, err := w.Create(name)
+		if err == nil {
+			_, err = entry.Write(screen.png)
+		}
+		if err != nil {
+			addError("screenshot", err)
+		} else {
+			manifest.Screenshot = name
+		}
+	}
+	return manifest
+}
+
+func writeZipJSON(w *zip.Writer, name string, value interface{}) error {
+	entry, err := w.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func writeZipFile(w *zip.Writer, name string, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entry, err := w.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, f)
+	return err
+}
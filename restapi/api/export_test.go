@@ -0,0 +1,93 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceHistoryBetween(t *testing.T) {
+	h := newDeviceHistory()
+	start := time.Date(2024, 1, 16, 15, 0, 0, 0, time.UTC)
+	for i := 0; i < deviceHistorySize+10; i++ {
+		h.record(DeviceEvent{UDID: "udid", Type: "device-updated", Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	h.record(DeviceEvent{UDID: "other", Type: "device-added", Time: start.Add(15 * time.Minute)})
+
+	events := h.between("udid", start.Add(15*time.Minute), start.Add(20*time.Minute))
+	assert.Equal(t, 6, len(events))
+	assert.Equal(t, start.Add(15*time.Minute), events[0].Time)
+	assert.Equal(t, 0, len(h.between("udid", start, start.Add(9*time.Minute))), "oldest events must be dropped")
+}
+
+func TestExportWindow(t *testing.T) {
+	window := func(query string) error {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/export?"+query, nil)
+		_, _, err := exportWindow(c)
+		return err
+	}
+	assert.NoError(t, window("from=2024-01-16T15:00:00Z&to=2024-01-16T16:00:00Z"))
+	assert.Error(t, window(""))
+	assert.Error(t, window("from=yesterday"))
+	assert.Error(t, window("from=2024-01-16T16:00:00Z&to=2024-01-16T15:00:00Z"))
+	assert.Error(t, window("from=2024-01-01T00:00:00Z&to=2024-01-16T15:00:00Z"))
+}
+
+func TestWriteExport(t *testing.T) {
+	udid := "export-test-udid"
+	from := time.Now().Add(-time.Hour)
+	to := time.Now().Add(time.Minute)
+
+	recording := recordingPath(udid, "export-test-session")
+	require.NoError(t, os.MkdirAll(filepath.Dir(recording), 0o755))
+	require.NoError(t, os.WriteFile(recording, []byte("recording"), 0o644))
+	defer os.Remove(recording)
+	history.record(DeviceEvent{UDID: udid, Type: "session-recording", Recording: "export-test-session"})
+	history.record(DeviceEvent{UDID: udid, Type: "session-recording", Recording: "missing-session"})
+	history.record(DeviceEvent{UDID: udid, Type: "device-removed", Time: from.Add(-time.Minute)})
+
+	crashDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(crashDir, "Retired"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(crashDir, "Retired", "app.ips"), []byte("crash"), 0o644))
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	manifest := writeExport(w, udid, from, to, crashDir, []string{"Retired/app.ips"}, errors.New("partial"))
+	require.NoError(t, w.Close())
+
+	assert.Equal(t, 2, manifest.Events)
+	assert.Equal(t, []string{"recordings/export-test-session.zip"}, manifest.Recordings)
+	assert.Equal(t, []string{"crashes/Retired/app.ips"}, manifest.Crashes)
+	assert.Equal(t, 2, len(manifest.Errors), "the missing recording and the crash report error must be listed")
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	assert.Equal(t, "recording", files["recordings/export-test-session.zip"])
+	assert.Equal(t, "crash", files["crashes/Retired/app.ips"])
+	var events []DeviceEvent
+	require.NoError(t, json.Unmarshal([]byte(files["events.json"]), &events))
+	assert.Equal(t, 2, len(events))
+	assert.Contains(t, files, "jobs.json")
+}
@@ -0,0 +1,556 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// goldenStatesEnvVar is the path of a json file with a list of GoldenState. Changes made with PUT /golden-states are
+// written back to it.
+const goldenStatesEnvVar = "GO_IOS_GOLDEN_STATES"
+
+// driftCheckInterval is how often devices are checked for drift, if a golden state has a webhook or auto remediation
+const driftCheckInterval = 15 * time.Minute
+
+// GoldenState is the desired state of all devices with a label
+type GoldenState struct {
+	Label string `json:"label"`
+	// MinOSVersion and MaxOSVersion are inclusive, 17 includes all 17.x versions when used as maximum
+	MinOSVersion string `json:"minOsVersion,omitempty"`
+	MaxOSVersion string `json:"maxOsVersion,omitempty"`
+	// Profiles are the identifiers of configuration profiles that have to be installed
+	Profiles []string       `json:"profiles,omitempty"`
+	Apps     []GoldenApp    `json:"apps,omitempty"`
+	Settings GoldenSettings `json:"settings"`
+	// AutoRemediate fixes remediable drift found by the periodic check without asking
+	AutoRemediate bool `json:"autoRemediate,omitempty"`
+	// Webhook gets a POST with the DriftReport when the periodic check finds drift
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// GoldenApp is an app that has to be installed. Missing apps and other versions can be remediated if artifact
+// is the id of the app in the artifact store.
+type GoldenApp struct {
+	BundleID string `json:"bundleId"`
+	// Version is the CFBundleShortVersionString, any version is fine if it is empty
+	Version  string `json:"version,omitempty"`
+	Artifact string `json:"artifact,omitempty"`
+}
+
+// GoldenSettings are device settings, settings that are not set are not checked
+type GoldenSettings struct {
+	Language        string `json:"language,omitempty"`
+	Locale          string `json:"locale,omitempty"`
+	Uses24HourClock *bool  `json:"uses24HourClock,omitempty"`
+	AssistiveTouch  *bool  `json:"assistiveTouch,omitempty"`
+	VoiceOver       *bool  `json:"voiceOver,omitempty"`
+	ZoomTouch       *bool  `json:"zoomTouch,omitempty"`
+}
+
+// Drift is one way a device differs from a golden state
+type Drift struct {
+	Label string `json:"label"`
+	// Kind is os_version, profile, app or setting
+	Kind       string      `json:"kind"`
+	Key        string      `json:"key"`
+	Expected   interface{} `json:"expected"`
+	Actual     interface{} `json:"actual"`
+	Remediable bool        `json:"remediable"`
+}
+
+// DriftReport lists the drift of a device from the golden states of its labels
+type DriftReport struct {
+	UDID      string    `json:"udid"`
+	Labels    []string  `json:"labels"`
+	InSync    bool      `json:"inSync"`
+	Drift     []Drift   `json:"drift"`
+	Errors    []string  `json:"errors,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// Validate checks a golden state before it is stored
+func (g GoldenState) Validate() error {
+	if g.Label == "" {
+		return fmt.Errorf("golden states need a label")
+	}
+	for _, version := range []string{g.MinOSVersion, g.MaxOSVersion} {
+		if version != "" && !validOSVersion(version) {
+			return fmt.Errorf("%s: invalid os version '%s'", g.Label, version)
+		}
+	}
+	for _, app := range g.Apps {
+		if app.BundleID == "" {
+			return fmt.Errorf("%s: apps need a bundleId", g.Label)
+		}
+		if app.Artifact != "" && !validArtifactID(app.Artifact) {
+			return fmt.Errorf("%s: invalid artifact id '%s' for %s", g.Label, app.Artifact, app.BundleID)
+		}
+	}
+	return nil
+}
+
+func validOSVersion(version string) bool {
+	for _, part := range strings.Split(version, ".") {
+		if _, err := strconv.Atoi(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// compareOSVersions compares dotted versions like 17.2.1 component by component, missing components only count
+// if the other version has them and limitOnly is false, so 17 is equal to 17.2 if limitOnly is true
+func compareOSVersions(a string, b string, limitOnly bool) int {
+	partsA := strings.Split(a, ".")
+	partsB := strings.Split(b, ".")
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		if i >= len(partsB) && limitOnly {
+			return 0
+		}
+		var x, y int
+		if i < len(partsA) {
+			x, _ = strconv.Atoi(partsA[i])
+		}
+		if i < len(partsB) {
+			y, _ = strconv.Atoi(partsB[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// goldenStateStore holds the golden states by label
+type goldenStateStore struct {
+	mu     sync.RWMutex
+	states map[string]GoldenState
+	path   string
+}
+
+var goldenStates = &goldenStateStore{states: map[string]GoldenState{}}
+
+func (s *goldenStateStore) list() []GoldenState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]GoldenState, 0, len(s.states))
+	for _, state := range s.states {
+		result = append(result, state)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Label < result[j].Label })
+	return result
+}
+
+// set validates all states, replaces the stored ones with them and writes them to the file they were loaded from
+func (s *goldenStateStore) set(states []GoldenState) error {
+	byLabel := map[string]GoldenState{}
+	for _, state := range states {
+		if err := state.Validate(); err != nil {
+			return err
+		}
+		if _, ok := byLabel[state.Label]; ok {
+			return fmt.Errorf("there is more than one golden state for label %s", state.Label)
+		}
+		byLabel[state.Label] = state
+	}
+	s.mu.Lock()
+	s.states = byLabel
+	path := s.path
+	s.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// forLabels returns the golden states of the labels
+func (s *goldenStateStore) forLabels(labels []string) []GoldenState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := []GoldenState{}
+	for _, label := range labels {
+		if state, ok := s.states[label]; ok {
+			result = append(result, state)
+		}
+	}
+	return result
+}
+
+func (s *goldenStateStore) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var states []GoldenState
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &states)
+		if err != nil {
+			return fmt.Errorf("invalid golden states in %s: %w", path, err)
+		}
+	}
+	err = s.set(states)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.path = path
+	s.mu.Unlock()
+	return nil
+}
+
+// loadGoldenStates loads the golden states configured with GO_IOS_GOLDEN_STATES
+func loadGoldenStates() {
+	path := os.Getenv(goldenStatesEnvVar)
+	if path == "" {
+		return
+	}
+	err := goldenStates.loadFile(path)
+	if err != nil {
+		log.WithError(err).Errorf("ignoring %s", goldenStatesEnvVar)
+	}
+}
+
+// checkDrift compares a snapshot of a device with its golden states
+func checkDrift(udid string, states []GoldenState, snapshot deviceSnapshot) DriftReport {
+	report := DriftReport{UDID: udid, Labels: []string{}, Drift: []Drift{}, Errors: snapshot.errors, CheckedAt: time.Now()}
+	osVersion, _ := snapshot.lockdown["ProductVersion"].(string)
+	for _, state := range states {
+		report.Labels = append(report.Labels, state.Label)
+		add := func(kind string, key string, expected interface{}, actual interface{}, remediable bool) {
+			report.Drift = append(report.Drift, Drift{Label: state.Label, Kind: kind, Key: key, Expected: expected, Actual: actual, Remediable: remediable})
+		}
+		if osVersion != "" {
+			if state.MinOSVersion != "" && compareOSVersions(osVersion, state.MinOSVersion, false) < 0 {
+				add("os_version", "minOsVersion", state.MinOSVersion, osVersion, false)
+			}
+			if state.MaxOSVersion != "" && compareOSVersions(osVersion, state.MaxOSVersion, true) > 0 {
+				add("os_version", "maxOsVersion", state.MaxOSVersion, osVersion, false)
+			}
+		}
+		for _, profile := range state.Profiles {
+			if _, ok := snapshot.profiles[profile]; !ok {
+				add("profile", profile, "installed", nil, false)
+			}
+		}
+		for _, app := range state.Apps {
+			installed, ok := snapshot.apps[app.BundleID].(string)
+			version, _, _ := strings.Cut(installed, " (")
+			if !ok {
+				add("app", app.BundleID, "installed", nil, app.Artifact != "")
+			} else if app.Version != "" && version != app.Version {
+				add("app", app.BundleID, app.Version, version, app.Artifact != "")
+			}
+		}
+		for key, expected := range state.Settings.values() {
+			actual, ok := snapshot.settings[key]
+			if ok && actual != expected {
+				add("setting", key, expected, actual, true)
+			}
+		}
+	}
+	sort.SliceStable(report.Drift, func(i, j int) bool {
+		return report.Drift[i].Kind < report.Drift[j].Kind || (report.Drift[i].Kind == report.Drift[j].Kind && report.Drift[i].Key < report.Drift[j].Key)
+	})
+	report.InSync = len(report.Drift) == 0
+	return report
+}
+
+// values returns the settings that are set by the keys of deviceSnapshot.settings
+func (s GoldenSettings) values() map[string]interface{} {
+	result := map[string]interface{}{}
+	if s.Language != "" {
+		result["Language"] = s.Language
+	}
+	if s.Locale != "" {
+		result["Locale"] = s.Locale
+	}
+	for key, value := range map[string]*bool{"Uses24HourClock": s.Uses24HourClock, "AssistiveTouch": s.AssistiveTouch, "VoiceOver": s.VoiceOver, "ZoomTouch": s.ZoomTouch} {
+		if value != nil {
+			result[key] = *value
+		}
+	}
+	return result
+}
+
+// driftReport collects a snapshot of the device and checks it against the golden states of its labels
+func driftReport(device ios.DeviceEntry) DriftReport {
+	udid := device.Properties.SerialNumber
+	states := goldenStates.forLabels(devices.Labels(udid))
+	if len(states) == 0 {
+		return checkDrift(udid, states, deviceSnapshot{})
+	}
+	return checkDrift(udid, states, snapshotLookup(device))
+}
+
+// boolSetters set the boolean settings of GoldenSettings
+var boolSetters = map[string]func(ios.DeviceEntry, bool) error{
+	"Uses24HourClock": ios.SetUses24HourClock,
+	"AssistiveTouch":  ios.SetAssistiveTouch,
+	"VoiceOver":       ios.SetVoiceOver,
+	"ZoomTouch":       ios.SetZoomTouch,
+}
+
+// RemediationResult lists what a remediation fixed and what failed
+type RemediationResult struct {
+	Fixed  []string `json:"fixed"`
+	Failed []string `json:"failed"`
+}
+
+// remediate fixes the remediable drift of a report, settings first because installs take long
+func remediate(ctx context.Context, device ios.DeviceEntry, report DriftReport, states []GoldenState) RemediationResult {
+	result := RemediationResult{Fixed: []string{}, Failed: []string{}}
+	done := func(what string, err error) {
+		if err != nil {
+			result.Failed = append(result.Failed, fmt.Sprintf("%s: %s", what, err.Error()))
+			return
+		}
+		result.Fixed = append(result.Fixed, what)
+	}
+	apps := map[string]string{}
+	for _, state := range states {
+		for _, app := range state.Apps {
+			apps[app.BundleID] = app.Artifact
+		}
+	}
+	language := ios.LanguageConfiguration{}
+	for _, drift := range report.Drift {
+		if !drift.Remediable || drift.Kind != "setting" {
+			continue
+		}
+		switch drift.Key {
+		case "Language":
+			language.Language = drift.Expected.(string)
+		case "Locale":
+			language.Locale = drift.Expected.(string)
+		default:
+			done("setting "+drift.Key, boolSetters[drift.Key](device, drift.Expected.(bool)))
+		}
+	}
+	if language.Language != "" || language.Locale != "" {
+		done("language and locale", ios.SetLanguage(device, language))
+	}
+	for _, drift := range report.Drift {
+		if !drift.Remediable || drift.Kind != "app" {
+			continue
+		}
+		if ctx.Err() != nil {
+			done("app "+drift.Key, ctx.Err())
+			continue
+		}
+		artifact, err := artifacts.get(apps[drift.Key])
+		if err == nil {
+			_, err = installArtifact(ctx, device, artifact, true, nil)
+		}
+		done("app "+drift.Key, err)
+	}
+	return result
+}
+
+// startRemediation starts a job remediating the drift of the device
+func startRemediation(device ios.DeviceEntry, report DriftReport) Job {
+	states := goldenStates.forLabels(report.Labels)
+	return jobs.start("remediate-drift", device.Properties.SerialNumber, func(ctx context.Context) (interface{}, error) {
+		result := remediate(ctx, device, report, states)
+		if len(result.Failed) > 0 {
+			return result, fmt.Errorf("remediating %d of %d drifts failed: %s", len(result.Failed), len(result.Failed)+len(result.Fixed), strings.Join(result.Failed, ", "))
+		}
+		return result, nil
+	})
+}
+
+// run checks the devices with golden states that have a webhook or auto remediation for drift until ctx is done
+func (s *goldenStateStore) run(ctx context.Context, registry *DeviceRegistry) {
+	ticker := time.NewTicker(driftCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			registry.Range(func(device ios.DeviceEntry) bool {
+				s.check(device, registry)
+				return true
+			})
+		}
+	}
+}
+
+func (s *goldenStateStore) check(device ios.DeviceEntry, registry *DeviceRegistry) {
+	udid := device.Properties.SerialNumber
+	states := s.forLabels(registry.Labels(udid))
+	watched := false
+	for _, state := range states {
+		watched = watched || state.AutoRemediate || state.Webhook != ""
+	}
+	if !watched || !pairedLookup(udid) {
+		return
+	}
+	report := checkDrift(udid, states, snapshotLookup(device))
+	if report.InSync {
+		return
+	}
+	history.record(DeviceEvent{UDID: udid, Type: "drift-detected", Message: fmt.Sprintf("%d drifts from the golden state", len(report.Drift))})
+	remediable := false
+	for _, drift := range report.Drift {
+		remediable = remediable || drift.Remediable
+	}
+	for _, state := range states {
+		if state.Webhook != "" {
+			go notifyDriftWebhook(state.Webhook, report)
+		}
+		if state.AutoRemediate && remediable {
+			startRemediation(device, report)
+			remediable = false
+		}
+	}
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func notifyDriftWebhook(url string, report DriftReport) {
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader([]byte(MustMarshal(report))))
+	if err != nil {
+		log.WithField("udid", report.UDID).WithError(err).Warn("drift webhook failed")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.WithField("udid", report.UDID).Warnf("drift webhook returned %s", resp.Status)
+	}
+}
+
+// ListGoldenStates lists the golden states
+// @Summary      List golden states
+// @Description  Lists the desired state of the devices with a label: os version range, profiles, apps and settings. Configure them with a json file at GO_IOS_GOLDEN_STATES or with PUT.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  []GoldenState
+// @Router       /golden-states [get]
+func ListGoldenStates(c *gin.Context) {
+	c.JSON(http.StatusOK, goldenStates.list())
+}
+
+// SetGoldenStates replaces the golden states
+// @Summary      Replace the golden states
+// @Description  Replaces all golden states and writes them to the file at GO_IOS_GOLDEN_STATES, if it is set. Every label can have one golden state. Needs the admin token.
+// @Tags         general
+// @Accept       json
+// @Produce      json
+// @Param        states body []GoldenState true "golden states"
+// @Success      200  {object}  []GoldenState
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /golden-states [put]
+func SetGoldenStates(c *gin.Context) {
+	var states []GoldenState
+	err := c.ShouldBindJSON(&states)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	for _, state := range states {
+		if err := state.Validate(); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+			return
+		}
+	}
+	err = goldenStates.set(states)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, goldenStates.list())
+}
+
+// GetDeviceDrift reports the drift of a device from its golden states
+// @Summary      Get the drift of a device
+// @Description  Compares the device with the golden states of its labels. Devices without golden states are always in sync.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200  {object}  DriftReport
+// @Router       /device/{udid}/drift [get]
+func GetDeviceDrift(c *gin.Context) {
+	c.JSON(http.StatusOK, driftReport(MustGetDevice(c)))
+}
+
+// RemediateDeviceDrift starts a job fixing the drift of a device
+// @Summary      Remediate the drift of a device
+// @Description  Starts a job that changes settings back to the golden state and installs missing apps and other app versions from the artifact store. OS versions and profiles can't be remediated, they are only reported.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      202  {object}  Job
+// @Success      200  {object}  DriftReport
+// @Failure      422  {object}  GenericResponse
+// @Router       /device/{udid}/drift/remediate [post]
+func RemediateDeviceDrift(c *gin.Context) {
+	device := MustGetDevice(c)
+	report := driftReport(device)
+	if report.InSync {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+	for _, drift := range report.Drift {
+		if drift.Remediable {
+			acceptJob(c, startRemediation(device, report))
+			return
+		}
+	}
+	c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "none of the drift can be remediated automatically"})
+}
+
+// ListDrift reports the drift of all devices with golden states
+// @Summary      Get the drift of all devices
+// @Description  Checks all paired devices that have a label with a golden state, or only the devices with the given label.
+// @Tags         general
+// @Produce      json
+// @Param        label query string false "only check devices with this label"
+// @Success      200  {object}  []DriftReport
+// @Router       /devices/drift [get]
+func ListDrift(c *gin.Context) {
+	label := c.Query("label")
+	var checked []ios.DeviceEntry
+	devices.Range(func(device ios.DeviceEntry) bool {
+		udid := device.Properties.SerialNumber
+		labels := devices.Labels(udid)
+		if label != "" && !containsString(labels, label) {
+			return true
+		}
+		if len(goldenStates.forLabels(labels)) > 0 && pairedLookup(udid) {
+			checked = append(checked, device)
+		}
+		return true
+	})
+	reports := make([]DriftReport, len(checked))
+	var wg sync.WaitGroup
+	for i, device := range checked {
+		wg.Add(1)
+		go func(i int, device ios.DeviceEntry) {
+			defer wg.Done()
+			reports[i] = driftReport(device)
+		}(i, device)
+	}
+	wg.Wait()
+	c.JSON(http.StatusOK, reports)
+}
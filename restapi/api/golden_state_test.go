@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareOSVersions(t *testing.T) {
+	assert.Equal(t, 0, compareOSVersions("17.2", "17.2", false))
+	assert.Equal(t, -1, compareOSVersions("17.2", "17.2.1", false))
+	assert.Equal(t, 1, compareOSVersions("17.10", "17.9", false))
+	assert.Equal(t, 0, compareOSVersions("17.4.1", "17", true))
+	assert.Equal(t, 1, compareOSVersions("18.0", "17", true))
+}
+
+func TestCheckDrift(t *testing.T) {
+	enabled := true
+	states := []GoldenState{{
+		Label:        "lab",
+		MinOSVersion: "17.4",
+		MaxOSVersion: "17",
+		Profiles:     []string{"com.example.wifi"},
+		Apps: []GoldenApp{
+			{BundleID: "com.example.app", Version: "2.0", Artifact: "app-2"},
+			{BundleID: "com.example.missing"},
+			{BundleID: "com.example.any"},
+		},
+		Settings: GoldenSettings{Locale: "en_US", VoiceOver: &enabled},
+	}}
+	snapshot := deviceSnapshot{
+		lockdown: map[string]interface{}{"ProductVersion": "17.2"},
+		apps:     map[string]interface{}{"com.example.app": "1.0 (1)", "com.example.any": "3.0 (30)"},
+		profiles: map[string]interface{}{},
+		settings: map[string]interface{}{"Locale": "de_DE", "VoiceOver": true},
+	}
+
+	report := checkDrift("drift-a", states, snapshot)
+	assert.False(t, report.InSync)
+	assert.Equal(t, []string{"lab"}, report.Labels)
+	assert.Equal(t, []Drift{
+		{Label: "lab", Kind: "app", Key: "com.example.app", Expected: "2.0", Actual: "1.0", Remediable: true},
+		{Label: "lab", Kind: "app", Key: "com.example.missing", Expected: "installed", Actual: nil, Remediable: false},
+		{Label: "lab", Kind: "os_version", Key: "minOsVersion", Expected: "17.4", Actual: "17.2", Remediable: false},
+		{Label: "lab", Kind: "profile", Key: "com.example.wifi", Expected: "installed", Actual: nil, Remediable: false},
+		{Label: "lab", Kind: "setting", Key: "Locale", Expected: "en_US", Actual: "de_DE", Remediable: true},
+	}, report.Drift)
+
+	assert.True(t, checkDrift("drift-a", nil, snapshot).InSync)
+}
+
+func TestGoldenStatesFromFile(t *testing.T) {
+	store := &goldenStateStore{states: map[string]GoldenState{}}
+	path := filepath.Join(t.TempDir(), "golden.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"label": "lab", "minOsVersion": "17.0", "settings": {"locale": "en_US"}}]`), 0o644))
+	require.NoError(t, store.loadFile(path))
+	assert.Len(t, store.forLabels([]string{"lab", "other"}), 1)
+
+	assert.Error(t, store.set([]GoldenState{{Label: "lab"}, {Label: "lab"}}))
+	assert.Error(t, store.set([]GoldenState{{Label: "lab", MaxOSVersion: "seventeen"}}))
+	assert.Len(t, store.list(), 1, "invalid states don't replace the stored ones")
+
+	require.NoError(t, store.set([]GoldenState{{Label: "ci"}, {Label: "lab"}}))
+	reloaded := &goldenStateStore{states: map[string]GoldenState{}}
+	require.NoError(t, reloaded.loadFile(path))
+	assert.Equal(t, []GoldenState{{Label: "ci"}, {Label: "lab"}}, reloaded.list())
+}
+
+func TestDriftEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("drift-a"))
+	defer devices.Remove("drift-a")
+	devices.SetLabels("drift-a", []string{"lab"})
+	devices.Put(testDevice("drift-b"))
+	defer devices.Remove("drift-b")
+
+	defer func(store *goldenStateStore) { goldenStates = store }(goldenStates)
+	goldenStates = &goldenStateStore{states: map[string]GoldenState{}}
+	require.NoError(t, goldenStates.set([]GoldenState{{Label: "lab", MinOSVersion: "18", Profiles: []string{"com.example.wifi"}}}))
+	defer func(lookup func(string) bool) { pairedLookup = lookup }(pairedLookup)
+	pairedLookup = func(udid string) bool { return true }
+	defer func(lookup func(ios.DeviceEntry) deviceSnapshot) { snapshotLookup = lookup }(snapshotLookup)
+	snapshotLookup = func(device ios.DeviceEntry) deviceSnapshot {
+		return deviceSnapshot{lockdown: map[string]interface{}{"ProductVersion": "17.2"}, profiles: map[string]interface{}{}}
+	}
+
+	r := gin.New()
+	r.GET("/devices/drift", ListDrift)
+	device := r.Group("/device/:udid", DeviceMiddleware())
+	device.GET("/drift", GetDeviceDrift)
+	device.POST("/drift/remediate", RemediateDeviceDrift)
+	serve := func(method string, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := serve(http.MethodGet, "/devices/drift")
+	require.Equal(t, http.StatusOK, w.Code)
+	var reports []DriftReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reports))
+	require.Len(t, reports, 1, "devices without golden states are not checked")
+	assert.Equal(t, "drift-a", reports[0].UDID)
+	assert.Len(t, reports[0].Drift, 2)
+
+	w = serve(http.MethodGet, "/devices/drift?label=ci")
+	assert.Equal(t, "[]", w.Body.String())
+
+	w = serve(http.MethodGet, "/device/drift-b/drift")
+	require.Equal(t, http.StatusOK, w.Code)
+	var report DriftReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.True(t, report.InSync)
+
+	w = serve(http.MethodPost, "/device/drift-a/drift/remediate")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "os versions and profiles can't be remediated")
+	w = serve(http.MethodPost, "/device/drift-b/drift/remediate")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/healthcheck"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// minFreeDiskEnvVar is the free space in megabytes the host needs in the temp directory, defaults to 1024
+	minFreeDiskEnvVar  = "GO_IOS_MIN_FREE_DISK_MB"
+	defaultMinFreeDisk = 1024
+	// probeTimeout is how long a check of the readiness probe may take, probes of orchestrators time out quickly
+	probeTimeout = 5 * time.Second
+)
+
+// agentStarted is when the agent started
+var agentStarted = time.Now()
+
+// ProbeStatus is the response of the liveness and readiness probes
+type ProbeStatus struct {
+	// Status is ok or unavailable
+	Status string               `json:"status"`
+	Uptime string               `json:"uptime"`
+	Checks []healthcheck.Result `json:"checks,omitempty"`
+}
+
+// minFreeDisk returns the free space the host needs in bytes
+func minFreeDisk() uint64 {
+	value := os.Getenv(minFreeDiskEnvVar)
+	if value == "" {
+		return defaultMinFreeDisk << 20
+	}
+	megabytes, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		log.WithField("value", value).Warnf("invalid %s, using %d", minFreeDiskEnvVar, defaultMinFreeDisk)
+		return defaultMinFreeDisk << 20
+	}
+	return megabytes << 20
+}
+
+// readinessChecks are the checks of the readiness probe, tests replace them
+var readinessChecks = func() []healthcheck.Check {
+	return []healthcheck.Check{
+		{Name: "usbmuxd", Run: func(ios.DeviceEntry) (string, error) {
+			list, err := usbmuxdList()
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d devices attached", len(list.DeviceList)), nil
+		}},
+		healthcheck.HostDisk(os.TempDir(), minFreeDisk()),
+	}
+}
+
+// deviceHealthChecks are the checks of the device health endpoint, tests replace them
+var deviceHealthChecks = func() []healthcheck.Check {
+	return healthcheck.QuickChecks(os.TempDir(), minFreeDisk())
+}
+
+func uptime() string {
+	return time.Since(agentStarted).Round(time.Second).String()
+}
+
+// Healthz is the liveness probe
+// @Summary      Liveness probe
+// @Description  Responds as long as the agent serves requests. It does not check devices or usbmuxd, so orchestrators only restart agents that hang.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  ProbeStatus
+// @Router       /healthz [get]
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, ProbeStatus{Status: "ok", Uptime: uptime()})
+}
+
+// Readyz is the readiness probe
+// @Summary      Readiness probe
+// @Description  Checks that usbmuxd answers and the host has enough free disk space in the temp directory, at least GO_IOS_MIN_FREE_DISK_MB megabytes. Responds with 503 if a check failed, so orchestrators stop routing to or cordon the agent.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  ProbeStatus
+// @Failure      503  {object}  ProbeStatus
+// @Router       /readyz [get]
+func Readyz(c *gin.Context) {
+	report := healthcheck.Run(c.Request.Context(), ios.DeviceEntry{}, readinessChecks(), probeTimeout)
+	status := ProbeStatus{Status: "ok", Uptime: uptime(), Checks: report.Results}
+	if !report.Healthy {
+		status.Status = "unavailable"
+		c.JSON(http.StatusServiceUnavailable, status)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// GetDeviceHealth checks whether a device can be used
+// @Summary      Get the health of a device
+// @Description  Checks that the device accepts the pair record, lockdown answers, a developer image is mounted and the host has enough free disk space. Unlike the deep /healthcheck it starts no developer services, so it is cheap enough for periodic probes. Responds with 503 if a check failed.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200  {object}  healthcheck.Report
+// @Failure      503  {object}  healthcheck.Report
+// @Router       /device/{udid}/health [get]
+func GetDeviceHealth(c *gin.Context) {
+	device := MustGetDevice(c)
+	report := healthcheck.Run(c.Request.Context(), device, deviceHealthChecks(), probeTimeout)
+	if !report.Healthy {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
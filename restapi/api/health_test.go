@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/healthcheck"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(checks func() []healthcheck.Check) { readinessChecks = checks }(readinessChecks)
+	usbmuxd := errors.New("dial unix /var/run/usbmuxd: connect: no such file or directory")
+	readinessChecks = func() []healthcheck.Check {
+		return []healthcheck.Check{{Name: "usbmuxd", Run: func(ios.DeviceEntry) (string, error) { return "", usbmuxd }}}
+	}
+	r := gin.New()
+	r.GET("/healthz", Healthz)
+	r.GET("/readyz", Readyz)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "liveness does not depend on usbmuxd")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var status ProbeStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, "unavailable", status.Status)
+	require.Len(t, status.Checks, 1)
+	assert.Equal(t, healthcheck.Fail, status.Checks[0].Status)
+
+	usbmuxd = nil
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestGetDeviceHealth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("device-health-a"))
+	defer devices.Remove("device-health-a")
+	defer func(checks func() []healthcheck.Check) { deviceHealthChecks = checks }(deviceHealthChecks)
+	deviceHealthChecks = func() []healthcheck.Check {
+		return []healthcheck.Check{
+			{Name: "pairing", Run: func(ios.DeviceEntry) (string, error) { return "", ios.ErrPairingInvalid }},
+			{Name: "lockdown", Needs: []string{"pairing"}, Run: func(ios.DeviceEntry) (string, error) { return "", nil }},
+		}
+	}
+	r := gin.New()
+	r.GET("/device/:udid/health", DeviceMiddleware(), GetDeviceHealth)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/device-health-a/health", nil))
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var report healthcheck.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.False(t, report.Healthy)
+	assert.Equal(t, healthcheck.Skip, report.Results[1].Status)
+}
+
+func TestMinFreeDisk(t *testing.T) {
+	t.Setenv(minFreeDiskEnvVar, "")
+	assert.Equal(t, uint64(1<<30), minFreeDisk())
+	t.Setenv(minFreeDiskEnvVar, "10")
+	assert.Equal(t, uint64(10<<20), minFreeDisk())
+	t.Setenv(minFreeDiskEnvVar, "lots")
+	assert.Equal(t, uint64(1<<30), minFreeDisk())
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/healthcheck"
+	"github.com/gin-gonic/gin"
+)
+
+// healthChecks are the checks of the health check endpoint, tests replace them
+var healthChecks = healthcheck.DefaultChecks
+
+// maxHealthCheckTimeout limits the timeout query param of the health check
+const maxHealthCheckTimeout = 2 * time.Minute
+
+// HealthCheck checks whether a device is actually usable
+// @Summary      Run a deep health check
+// @Description  Exercises pairing, lockdown, the tunnel (iOS 17+), the developer image, instruments, afc and screenshots one after the other and returns a pass, fail or skip result with timings for each. Checks are skipped if a check they need failed. The device is healthy if no check failed.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        timeout query string false "timeout of each check, f.ex. 30s, defaults to 20s"
+// @Success      200  {object}  healthcheck.Report
+// @Failure      422  {object}  GenericResponse
+// @Router       /device/{udid}/healthcheck [post]
+func HealthCheck(c *gin.Context) {
+	timeout := healthcheck.DefaultTimeout
+	if value := c.Query("timeout"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > maxHealthCheckTimeout {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: fmt.Sprintf("timeout must be a duration up to %s", maxHealthCheckTimeout)})
+			return
+		}
+		timeout = parsed
+	}
+	device := MustGetDevice(c)
+	report := healthcheck.Run(c.Request.Context(), device, healthChecks(), timeout)
+
+	var failed []string
+	for _, result := range report.Results {
+		if result.Status == healthcheck.Fail {
+			failed = append(failed, result.Check)
+		}
+	}
+	message := "all checks passed"
+	if len(failed) > 0 {
+		message = "failed: " + strings.Join(failed, ", ")
+	}
+	history.record(DeviceEvent{UDID: report.UDID, Type: "healthcheck", Message: message})
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/healthcheck"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthCheckEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("health-a"))
+	defer devices.Remove("health-a")
+	defer func(checks func() []healthcheck.Check) { healthChecks = checks }(healthChecks)
+	healthChecks = func() []healthcheck.Check {
+		return []healthcheck.Check{
+			{Name: "lockdown", Run: func(ios.DeviceEntry) (string, error) { return "iOS 17.2", nil }},
+			{Name: "ddi", Needs: []string{"lockdown"}, Run: func(ios.DeviceEntry) (string, error) {
+				return "", errors.New("no developer image is mounted")
+			}},
+		}
+	}
+	defer func(h *deviceHistory) { history = h }(history)
+	history = newDeviceHistory()
+
+	r := gin.New()
+	r.POST("/device/:udid/healthcheck", DeviceMiddleware(), HealthCheck)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/health-a/healthcheck?timeout=5s", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var report healthcheck.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.False(t, report.Healthy)
+	require.Len(t, report.Results, 2)
+	assert.Equal(t, healthcheck.Pass, report.Results[0].Status)
+	assert.Equal(t, healthcheck.Fail, report.Results[1].Status)
+
+	events := history.between("health-a", time.Time{}, time.Now().Add(time.Minute))
+	require.Len(t, events, 1)
+	assert.Equal(t, "failed: ddi", events[0].Message)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/health-a/healthcheck?timeout=1h", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
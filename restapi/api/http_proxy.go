@@ -0,0 +1,114 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPProxyRequest configures the global HTTP proxy of a device, either with Host and Port or with the PAC file at PACURL
+type HTTPProxyRequest struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	PACURL   string `json:"pacUrl"`
+	// PACFallbackAllowed connects directly if the PAC file can't be loaded
+	PACFallbackAllowed bool `json:"pacFallbackAllowed"`
+}
+
+func (r HTTPProxyRequest) profile() (mcinstall.ConfigurationProfile, error) {
+	var payload mcinstall.GlobalHTTPProxyPayload
+	switch {
+	case r.PACURL != "" && r.Host != "":
+		return mcinstall.ConfigurationProfile{}, fmt.Errorf("set either host and port or pacUrl")
+	case r.PACURL != "":
+		u, err := url.Parse(r.PACURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return mcinstall.ConfigurationProfile{}, fmt.Errorf("pacUrl must be an http or https url")
+		}
+		if r.User != "" {
+			return mcinstall.ConfigurationProfile{}, fmt.Errorf("user and password are only supported with host and port")
+		}
+		payload = mcinstall.NewAutoHTTPProxyPayload(r.PACURL)
+		if r.PACFallbackAllowed {
+			payload = payload.AllowPACFallback()
+		}
+	case r.Host != "":
+		if r.Port < 1 || r.Port > 65535 {
+			return mcinstall.ConfigurationProfile{}, fmt.Errorf("port must be between 1 and 65535")
+		}
+		payload = mcinstall.NewManualHTTPProxyPayload(r.Host, r.Port)
+		if r.User != "" {
+			payload = payload.WithCredentials(r.User, r.Password)
+		}
+	default:
+		return mcinstall.ConfigurationProfile{}, fmt.Errorf("set host and port or pacUrl")
+	}
+	return mcinstall.NewConfigurationProfile(mcinstall.HTTPProxyProfileIdentifier, "Go-iOS Global HTTP Proxy", payload), nil
+}
+
+// Set the global HTTP proxy of a device
+// @Summary      Set the global HTTP proxy
+// @Description  Installs a profile with a global HTTP proxy on a supervised device, f.ex. to intercept the traffic of a test run with mitmproxy or Charles. It replaces the proxy set before, also one set with the setproxy command, DELETE removes it again. Needs the supervision identity configured with GO_IOS_SUPERVISION_P12.
+// @Tags         general_device_specific
+// @Accept       json
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        proxy body HTTPProxyRequest true "Proxy"
+// @Success      200 {object} GenericResponse
+// @Failure      412 {object} GenericResponse
+// @Failure      422 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/httpproxy [put]
+func SetHTTPProxy(c *gin.Context) {
+	device := MustGetDevice(c)
+	var request HTTPProxyRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	profile, err := request.profile()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	p12, password, err := supervisionIdentity()
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, GenericResponse{Error: err.Error()})
+		return
+	}
+	err = mcinstall.InstallProfileSilent(device, p12, password, profile.Bytes())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	ci.created(device.Properties.SerialNumber, "profile", mcinstall.HTTPProxyProfileIdentifier, func() error {
+		return mcinstall.RemoveProxy(device)
+	})
+	c.JSON(http.StatusOK, GenericResponse{Message: "http proxy set"})
+}
+
+// Remove the global HTTP proxy of a device
+// @Summary      Remove the global HTTP proxy
+// @Description  Removes the global HTTP proxy set with PUT /device/{udid}/httpproxy or the setproxy command.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/httpproxy [delete]
+func RemoveHTTPProxy(c *gin.Context) {
+	device := MustGetDevice(c)
+	err := mcinstall.RemoveProxy(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	ci.removed(device.Properties.SerialNumber, "profile", mcinstall.HTTPProxyProfileIdentifier)
+	c.JSON(http.StatusOK, GenericResponse{Message: "http proxy removed"})
+}
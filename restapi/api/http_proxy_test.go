@@ -0,0 +1,38 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPProxyProfile(t *testing.T) {
+	profile, err := HTTPProxyRequest{Host: "10.0.0.2", Port: 8080, User: "lab", Password: "secret"}.profile()
+	require.NoError(t, err)
+	assert.Equal(t, mcinstall.HTTPProxyProfileIdentifier, profile.PayloadIdentifier)
+	manual := profile.PayloadContent[0].(mcinstall.GlobalHTTPProxyPayload)
+	assert.Equal(t, "Manual", manual.ProxyType)
+	assert.Equal(t, 8080, manual.ProxyServerPort)
+	assert.Equal(t, "lab", manual.ProxyUsername)
+
+	profile, err = HTTPProxyRequest{PACURL: "https://proxy.lab.local/proxy.pac", PACFallbackAllowed: true}.profile()
+	require.NoError(t, err)
+	auto := profile.PayloadContent[0].(mcinstall.GlobalHTTPProxyPayload)
+	assert.Equal(t, "Auto", auto.ProxyType)
+	assert.True(t, auto.ProxyPACFallbackAllowed)
+
+	invalid := []HTTPProxyRequest{
+		{},
+		{Host: "10.0.0.2"},
+		{Host: "10.0.0.2", Port: 70000},
+		{Host: "10.0.0.2", Port: 8080, PACURL: "http://proxy.lab.local/proxy.pac"},
+		{PACURL: "proxy.pac"},
+		{PACURL: "http://proxy.lab.local/proxy.pac", User: "lab"},
+	}
+	for _, request := range invalid {
+		_, err := request.profile()
+		assert.Error(t, err, "%+v", request)
+	}
+}
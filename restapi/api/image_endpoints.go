@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/danielpaulus/go-ios/ios/imagemounter"
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultImageDir    = "./devimages"
+	imageMirrorEnvVar  = "GO_IOS_IMAGE_MIRROR"
+	imageBaseDirEnvVar = "GO_IOS_IMAGE_DIR"
+)
+
+// ensureImageMounted mounts the developer disk image of a device, tests replace it
+var ensureImageMounted = imagemounter.EnsureMountedIn
+
+func init() {
+	imagemounter.SetMirror(os.Getenv(imageMirrorEnvVar))
+}
+
+// imageVersionPattern matches the plain version names images are cached under, like 16.4 or ddi-15F31d
+var imageVersionPattern = regexp.MustCompile(`^[0-9A-Za-z]+([.-][0-9A-Za-z]+)*$`)
+
+// imageDir is GO_IOS_IMAGE_DIR or the default image dir. Clients cannot pick the directory,
+// the image endpoints would otherwise read, write and delete arbitrary paths on the host.
+func imageDir() string {
+	if basedir := os.Getenv(imageBaseDirEnvVar); basedir != "" {
+		return basedir
+	}
+	return defaultImageDir
+}
+
+// ListCachedImages lists the developer disk images on the host
+// @Summary      List cached developer disk images
+// @Description  Lists the developer disk images that were downloaded to the host
+// @Tags         image
+// @Produce      json
+// @Success      200  {object}  []imagemounter.CachedImage
+// @Failure      500  {object}  GenericResponse
+// @Router       /images [get]
+func ListCachedImages(c *gin.Context) {
+	images, err := imagemounter.ListCachedImages(imageDir())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, images)
+}
+
+// DownloadImage downloads a developer disk image to the host
+// @Summary      Download a developer disk image
+// @Description  Downloads the developer disk image for an iOS version into the host cache. Set GO_IOS_IMAGE_MIRROR to download from a mirror.
+// @Tags         image
+// @Produce      json
+// @Param        version query string true "iOS version, eg. 16.4.1"
+// @Param        sha256 query string false "expected sha256 checksum of the image, the download is discarded if it does not match"
+// @Success      200  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /images/download [post]
+func DownloadImage(c *gin.Context) {
+	version := c.Query("version")
+	if version == "" {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "version query param is missing"})
+		return
+	}
+	if !imageVersionPattern.MatchString(version) {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: fmt.Sprintf("invalid version '%s'", version)})
+		return
+	}
+	path, err := imagemounter.DownloadImage(imageDir(), version, c.Query("sha256"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: path})
+}
+
+// RemoveCachedImage removes a developer disk image from the host
+// @Summary      Remove a cached developer disk image
+// @Description  Deletes a developer disk image from the host cache
+// @Tags         image
+// @Produce      json
+// @Param        version path string true "version as returned by the image list"
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /images/{version} [delete]
+func RemoveCachedImage(c *gin.Context) {
+	version := c.Param("version")
+	if !imageVersionPattern.MatchString(version) {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: fmt.Sprintf("invalid version '%s'", version)})
+		return
+	}
+	err := imagemounter.RemoveCachedImage(imageDir(), version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "image removed"})
+}
+
+// EnsureDDI mounts the developer disk image of a device, unless one is mounted already
+// @Summary      Ensure the developer disk image is mounted
+// @Description  Downloads the developer disk image matching the iOS version of the device, or the personalized image for iOS 17+, to the image cache if it is not cached yet and mounts it. Nothing is downloaded or mounted if the device has an image mounted already. Set GO_IOS_IMAGE_MIRROR to download from a mirror, downloads are verified with the sha256 checksums the mirror serves.
+// @Tags         image
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200  {object}  imagemounter.EnsureResult
+// @Failure      409  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/ensure-ddi [post]
+func EnsureDDI(c *gin.Context) {
+	device := MustGetDevice(c)
+	result, err := ensureImageMounted(device, imageDir())
+	if errors.Is(err, imagemounter.ErrDeveloperModeDisabled) {
+		c.JSON(http.StatusConflict, GenericResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	if !result.AlreadyMounted {
+		history.record(DeviceEvent{UDID: device.Properties.SerialNumber, Type: events.ImageMounted, Message: result.Path})
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/imagemounter"
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnsureDDI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv(imageBaseDirEnvVar, "/images")
+	defer func(h *deviceHistory) { history = h }(history)
+	history = newDeviceHistory()
+	defer func(ensure func(ios.DeviceEntry, string) (imagemounter.EnsureResult, error)) {
+		ensureImageMounted = ensure
+	}(ensureImageMounted)
+	ensureImageMounted = func(device ios.DeviceEntry, baseDir string) (imagemounter.EnsureResult, error) {
+		switch device.Properties.SerialNumber {
+		case "mounted":
+			return imagemounter.EnsureResult{AlreadyMounted: true, ProductVersion: "16.4"}, nil
+		case "devmode":
+			return imagemounter.EnsureResult{ProductVersion: "17.0"}, fmt.Errorf("EnsureMounted: %w", imagemounter.ErrDeveloperModeDisabled)
+		case "broken":
+			return imagemounter.EnsureResult{}, fmt.Errorf("EnsureMounted: failed listing mounted images")
+		}
+		return imagemounter.EnsureResult{ProductVersion: "15.7.2", Path: baseDir + "/15.7/DeveloperDiskImage.dmg"}, nil
+	}
+
+	ensure := func(udid string) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.POST("/device/:udid/ensure-ddi", func(c *gin.Context) {
+			c.Set(IOS_KEY, testDevice(c.Param("udid")))
+			c.Next()
+		}, EnsureDDI)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/"+udid+"/ensure-ddi", nil))
+		return w
+	}
+
+	w := ensure("fresh")
+	require.Equal(t, http.StatusOK, w.Code)
+	var result imagemounter.EnsureResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, imagemounter.EnsureResult{ProductVersion: "15.7.2", Path: "/images/15.7/DeveloperDiskImage.dmg"}, result)
+	recorded := history.between("fresh", time.Time{}, time.Now().Add(time.Minute))
+	require.Len(t, recorded, 1)
+	assert.Equal(t, events.ImageMounted, recorded[0].Type)
+
+	w = ensure("mounted")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"alreadyMounted":true`)
+	assert.Empty(t, history.between("mounted", time.Time{}, time.Now().Add(time.Minute)), "nothing was mounted")
+
+	assert.Equal(t, http.StatusConflict, ensure("devmode").Code)
+	assert.Equal(t, http.StatusInternalServerError, ensure("broken").Code)
+}
+
+func TestRemoveCachedImageRejectsPaths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	t.Setenv(imageBaseDirEnvVar, filepath.Join(dir, "images"))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "images", "16.4"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "outside"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "16.4"), 0o755))
+
+	r := gin.New()
+	r.DELETE("/images/:version", RemoveCachedImage)
+	r.POST("/images/download", DownloadImage)
+	request := func(method string, target string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnprocessableEntity, request(http.MethodDelete, "/images/.."))
+	assert.Equal(t, http.StatusUnprocessableEntity, request(http.MethodDelete, "/images/..%5Coutside"))
+	assert.Equal(t, http.StatusUnprocessableEntity, request(http.MethodPost, "/images/download?version=../16.4"))
+	assert.Equal(t, http.StatusUnprocessableEntity, request(http.MethodPost, "/images/download?version=16.4%5C.."))
+	assert.DirExists(t, filepath.Join(dir, "outside"))
+
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/images/16.4?basedir="+dir))
+	assert.NoDirExists(t, filepath.Join(dir, "images", "16.4"))
+	assert.DirExists(t, filepath.Join(dir, "16.4"), "basedir is ignored")
+}
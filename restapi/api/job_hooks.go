@@ -0,0 +1,374 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/secrets"
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobHooksEnvVar is the path of a json file with a list of JobHook. Changes made with PUT /config/hooks are written
+// back to it.
+const jobHooksEnvVar = "GO_IOS_JOB_HOOKS"
+
+const (
+	HookBefore = "before"
+	HookAfter  = "after"
+
+	// jobHookDefaultTimeout limits hooks without a timeout
+	jobHookDefaultTimeout = time.Minute
+	// jobHookOutputLimit is how much of the output of a failed command is put in the error
+	jobHookOutputLimit = 500
+)
+
+// Job types of the hooks besides the v2 job types like install or mount-image
+const (
+	hookJobTest    = "test"
+	hookJobSession = "session"
+)
+
+// JobHook runs a command or posts to a URL before or after jobs, so labs can add their own steps like setting up a
+// VPN, warming caches or notifying a chat. Exactly one of Command and URL has to be set.
+type JobHook struct {
+	// Name identifies the hook in logs and errors
+	Name string `json:"name"`
+	// When is before or after
+	When string `json:"when"`
+	// Jobs are the job types to run for: test for xcuitest sessions, session for WebDriverAgent sessions and the
+	// v2 job types like install or mount-image. The hook runs for all jobs if it is empty.
+	Jobs []string `json:"jobs,omitempty"`
+	// Command is run without a shell. The HookContext is passed as json on stdin and in the GO_IOS_HOOK_WHEN,
+	// GO_IOS_JOB_TYPE, GO_IOS_JOB_ID, GO_IOS_UDID, GO_IOS_JOB_STATE and GO_IOS_JOB_ERROR env vars.
+	Command []string `json:"command,omitempty"`
+	// URL gets the HookContext posted as json, any response but 2xx fails the hook
+	URL string `json:"url,omitempty"`
+	// Secret signs the posted body like the secret of a Webhook
+	Secret         string `json:"secret,omitempty"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+	// Required hooks that run before a job fail the job if they fail. Other failing hooks are only reported with
+	// a hook-failed event.
+	Required bool `json:"required,omitempty"`
+}
+
+// HookContext is the job a hook runs for
+type HookContext struct {
+	When string    `json:"when"`
+	Job  string    `json:"job"`
+	ID   string    `json:"id"`
+	UDID string    `json:"udid"`
+	Time time.Time `json:"time"`
+	// State and Error are the outcome of the job, they are only set after it
+	State string `json:"state,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// hookError is returned for a required hook that failed before a job
+type hookError struct {
+	hook string
+	err  error
+}
+
+func (e hookError) Error() string {
+	return fmt.Sprintf("hook %s failed: %s", e.hook, e.err)
+}
+
+func (e hookError) Unwrap() error {
+	return e.err
+}
+
+func (h JobHook) validate() error {
+	if h.Name == "" {
+		return errors.New("hook without name")
+	}
+	if h.When != HookBefore && h.When != HookAfter {
+		return fmt.Errorf("hook %s: when must be %s or %s", h.Name, HookBefore, HookAfter)
+	}
+	if (len(h.Command) == 0) == (h.URL == "") {
+		return fmt.Errorf("hook %s needs either a command or a url", h.Name)
+	}
+	if h.URL != "" {
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("hook %s: invalid url '%s'", h.Name, h.URL)
+		}
+	}
+	if h.TimeoutSeconds < 0 {
+		return fmt.Errorf("hook %s: negative timeout", h.Name)
+	}
+	return nil
+}
+
+func (h JobHook) wants(when string, jobType string) bool {
+	return h.When == when && (len(h.Jobs) == 0 || containsString(h.Jobs, jobType))
+}
+
+func (h JobHook) timeout() time.Duration {
+	if h.TimeoutSeconds == 0 {
+		return jobHookDefaultTimeout
+	}
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+// redacted hides the secret, so hooks can be listed without leaking it
+func (h JobHook) redacted() JobHook {
+	if h.Secret != "" {
+		h.Secret = "redacted"
+	}
+	return h
+}
+
+// hookRunner runs the configured hooks around jobs
+type hookRunner struct {
+	mu     sync.Mutex
+	hooks  []JobHook
+	path   string
+	client *http.Client
+}
+
+var jobHooks = &hookRunner{client: &http.Client{}}
+
+func (r *hookRunner) list() []JobHook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]JobHook{}, r.hooks...)
+}
+
+// set replaces the hooks and writes them to the file they were loaded from
+func (r *hookRunner) set(hooks []JobHook) error {
+	keys, err := sealedSecrets.keys()
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if err := hook.validate(); err != nil {
+			return err
+		}
+		if _, err := secrets.OpenString(keys, hook.Secret); err != nil {
+			return fmt.Errorf("failed opening secret of hook %s: %w", hook.Name, err)
+		}
+	}
+	if hooks == nil {
+		hooks = []JobHook{}
+	}
+	r.mu.Lock()
+	r.hooks = hooks
+	path := r.path
+	r.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(hooks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+func (r *hookRunner) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var hooks []JobHook
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &hooks)
+		if err != nil {
+			return fmt.Errorf("invalid hooks in %s: %w", path, err)
+		}
+	}
+	err = r.set(hooks)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.path = path
+	r.mu.Unlock()
+	return nil
+}
+
+// loadJobHooks loads the hooks configured with GO_IOS_JOB_HOOKS
+func loadJobHooks() {
+	path := os.Getenv(jobHooksEnvVar)
+	if path == "" {
+		return
+	}
+	err := jobHooks.loadFile(path)
+	if err != nil {
+		log.WithError(err).Errorf("ignoring %s", jobHooksEnvVar)
+	}
+}
+
+// before runs the hooks for the start of the job one after the other. It stops at the first required hook that
+// fails and returns a hookError, the job must not run then.
+func (r *hookRunner) before(ctx context.Context, job HookContext) error {
+	job.When = HookBefore
+	for _, hook := range r.matching(HookBefore, job.Job) {
+		err := r.runHook(ctx, hook, job)
+		if err != nil && hook.Required {
+			return hookError{hook: hook.Name, err: err}
+		}
+	}
+	return nil
+}
+
+// after runs the hooks for the end of the job one after the other, state and errMessage are its outcome
+func (r *hookRunner) after(job HookContext, state string, errMessage string) {
+	job.When = HookAfter
+	job.State = state
+	job.Error = errMessage
+	for _, hook := range r.matching(HookAfter, job.Job) {
+		r.runHook(context.Background(), hook, job)
+	}
+}
+
+func (r *hookRunner) matching(when string, jobType string) []JobHook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []JobHook
+	for _, hook := range r.hooks {
+		if hook.wants(when, jobType) {
+			result = append(result, hook)
+		}
+	}
+	return result
+}
+
+// runHook runs the hook and records a hook-failed event if it fails
+func (r *hookRunner) runHook(ctx context.Context, hook JobHook, job HookContext) error {
+	ctx, cancel := context.WithTimeout(ctx, hook.timeout())
+	defer cancel()
+	job.Time = time.Now()
+	logger := log.WithFields(log.Fields{"hook": hook.Name, "when": job.When, "job": job.ID, "type": job.Job, "udid": job.UDID})
+	body, err := json.Marshal(job)
+	if err == nil {
+		if hook.URL != "" {
+			err = r.post(ctx, hook, body)
+		} else {
+			err = runHookCommand(ctx, hook, job, body)
+		}
+	}
+	if err != nil {
+		logger.WithError(err).Warn("job hook failed")
+		history.record(DeviceEvent{UDID: job.UDID, Type: events.HookFailed, Message: fmt.Sprintf("%s hook %s of %s %s: %s", job.When, hook.Name, job.Job, job.ID, err),
+			Job: &events.Job{ID: job.ID, Type: job.Job, State: job.State, Error: job.Error}})
+		return err
+	}
+	logger.Debug("job hook finished")
+	return nil
+}
+
+func (r *hookRunner) post(ctx context.Context, hook JobHook, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hook.Secret != "" {
+		keys, err := sealedSecrets.keys()
+		if err != nil {
+			return err
+		}
+		secret, err := secrets.OpenString(keys, hook.Secret)
+		if err != nil {
+			return fmt.Errorf("failed opening secret: %w", err)
+		}
+		req.Header.Set(webhookSignatureHeader, sign(secret, body))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("hook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func runHookCommand(ctx context.Context, hook JobHook, job HookContext, body []byte) error {
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"GO_IOS_HOOK_WHEN="+job.When,
+		"GO_IOS_JOB_TYPE="+job.Job,
+		"GO_IOS_JOB_ID="+job.ID,
+		"GO_IOS_UDID="+job.UDID,
+		"GO_IOS_JOB_STATE="+job.State,
+		"GO_IOS_JOB_ERROR="+job.Error,
+	)
+	cmd.Stdin = bytes.NewReader(body)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out after %s", hook.timeout())
+	}
+	if err != nil {
+		out := strings.TrimSpace(string(output))
+		if len(out) > jobHookOutputLimit {
+			out = out[len(out)-jobHookOutputLimit:]
+		}
+		if out != "" {
+			return fmt.Errorf("%w: %s", err, out)
+		}
+		return err
+	}
+	return nil
+}
+
+// ListJobHooks lists the job hooks
+// @Summary      List the job hooks
+// @Description  Lists the hooks that run before and after jobs, secrets are redacted. Needs the admin token.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  []JobHook
+// @Router       /config/hooks [get]
+func ListJobHooks(c *gin.Context) {
+	hooks := jobHooks.list()
+	for i := range hooks {
+		hooks[i] = hooks[i].redacted()
+	}
+	c.JSON(http.StatusOK, hooks)
+}
+
+// SetJobHooks replaces the job hooks
+// @Summary      Replace the job hooks
+// @Description  Replaces the hooks that run before and after jobs and writes them to the file at GO_IOS_JOB_HOOKS, if it is set. Hooks run for xcuitest sessions (test), WebDriverAgent sessions (session) and v2 jobs like install. A hook runs a command, which gets the job in GO_IOS_* env vars and as json on stdin, or posts the job as json to a url. A required hook that fails before a job fails the job, all other failures are reported with a hook-failed event. Needs the admin token.
+// @Tags         general
+// @Accept       json
+// @Produce      json
+// @Param        hooks body []JobHook true "hooks"
+// @Success      200  {object}  []JobHook
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /config/hooks [put]
+func SetJobHooks(c *gin.Context) {
+	var hooks []JobHook
+	err := c.ShouldBindJSON(&hooks)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	for _, hook := range hooks {
+		if err := hook.validate(); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+			return
+		}
+	}
+	err = jobHooks.set(hooks)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	ListJobHooks(c)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHooksRunAroundJobs(t *testing.T) {
+	var mu sync.Mutex
+	var posted []HookContext
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, sign("s3cret", body), r.Header.Get(webhookSignatureHeader))
+		var hook HookContext
+		assert.NoError(t, json.Unmarshal(body, &hook))
+		mu.Lock()
+		posted = append(posted, hook)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	out := filepath.Join(t.TempDir(), "hooks.log")
+	defer jobHooks.set(nil)
+	require.NoError(t, jobHooks.set([]JobHook{
+		{Name: "vpn", When: HookBefore, Jobs: []string{"install"}, Command: []string{"sh", "-c", `echo "$GO_IOS_HOOK_WHEN $GO_IOS_JOB_TYPE $GO_IOS_JOB_ID $GO_IOS_UDID" >> ` + out}},
+		{Name: "tests only", When: HookBefore, Jobs: []string{hookJobTest}, Command: []string{"sh", "-c", "echo test >> " + out}},
+		{Name: "chat", When: HookAfter, URL: server.URL, Secret: "s3cret"},
+	}))
+
+	store := newJobStore(NewDeviceRegistry())
+	var ran bool
+	job := store.start("install", "hooks-udid", func(ctx context.Context) (interface{}, error) {
+		b, err := os.ReadFile(out)
+		require.NoError(t, err)
+		ran = strings.HasPrefix(string(b), "before install ")
+		return nil, nil
+	})
+	assert.Equal(t, JobSucceeded, waitForJob(t, store, job.ID).State)
+	assert.True(t, ran, "the before hook has to finish before the job runs")
+	b, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "before install "+job.ID+" hooks-udid\n", string(b))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(posted) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, HookAfter, posted[0].When)
+	assert.Equal(t, "install", posted[0].Job)
+	assert.Equal(t, job.ID, posted[0].ID)
+	assert.Equal(t, string(JobSucceeded), posted[0].State)
+}
+
+func TestRequiredJobHookFailsTheJob(t *testing.T) {
+	defer jobHooks.set(nil)
+	require.NoError(t, jobHooks.set([]JobHook{
+		{Name: "cache", When: HookBefore, Command: []string{"sh", "-c", "exit 1"}},
+		{Name: "vpn", When: HookBefore, Command: []string{"sh", "-c", "echo no route to vpn; exit 2"}, Required: true},
+	}))
+	subscription := history.bus.Subscribe(events.Filter{UDIDs: []string{"hooks-udid"}, Types: []events.Type{events.HookFailed}}, 10)
+	defer subscription.Close()
+
+	store := newJobStore(NewDeviceRegistry())
+	ran := false
+	job := store.start("install", "hooks-udid", func(ctx context.Context) (interface{}, error) {
+		ran = true
+		return nil, nil
+	})
+	job = waitForJob(t, store, job.ID)
+	assert.False(t, ran)
+	assert.Equal(t, JobFailed, job.State)
+	require.NotNil(t, job.Error)
+	assert.Equal(t, ErrorCodeHookFailed, job.Error.Code)
+	assert.Equal(t, "hook vpn failed: exit status 2: no route to vpn", job.Error.Message)
+
+	// the optional hook only reports its failure
+	for _, hook := range []string{"cache", "vpn"} {
+		select {
+		case event := <-subscription.Events:
+			assert.Contains(t, event.Message, "before hook "+hook+" of install "+job.ID)
+		case <-time.After(time.Second):
+			t.Fatalf("no hook-failed event for %s", hook)
+		}
+	}
+}
+
+func TestSetJobHooks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer jobHooks.set(nil)
+	r := gin.New()
+	r.GET("/config/hooks", ListJobHooks)
+	r.PUT("/config/hooks", SetJobHooks)
+
+	for _, body := range []string{
+		`[{"name":"x","when":"during","command":["true"]}]`,
+		`[{"name":"x","when":"before"}]`,
+		`[{"name":"x","when":"before","command":["true"],"url":"http://chat"}]`,
+		`[{"name":"x","when":"after","url":"ftp://chat"}]`,
+		`[{"when":"after","url":"http://chat"}]`,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/hooks", strings.NewReader(body)))
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, body)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/hooks", strings.NewReader(`[{"name":"chat","when":"after","jobs":["session"],"url":"https://chat.example.com/hook","secret":"s3cret"}]`)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var hooks []JobHook
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &hooks))
+	assert.Equal(t, []JobHook{{Name: "chat", When: HookAfter, Jobs: []string{hookJobSession}, URL: "https://chat.example.com/hook", Secret: "redacted"}}, hooks)
+	assert.Equal(t, "s3cret", jobHooks.list()[0].Secret)
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/syslog"
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// JobState is the state of a long running v2 operation
+type JobState string
+
+const (
+	JobPending   = JobState("pending")
+	JobRunning   = JobState("running")
+	JobSucceeded = JobState("succeeded")
+	JobFailed    = JobState("failed")
+	JobCanceled  = JobState("canceled")
+)
+
+// jobRetention is how long finished jobs can be queried
+const jobRetention = time.Hour
+
+// Job is a long running operation started through API v2, like installing an app. Jobs of the same device
+// run one after the other in the order they were started, a job stays pending until the jobs started before
+// it on the device finished.
+type Job struct {
+	ID       string     `json:"id"`
+	Type     string     `json:"type"`
+	UDID     string     `json:"udid"`
+	State    JobState   `json:"state"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Result is set when the job succeeded, its type depends on the job type
+	Result interface{} `json:"result,omitempty"`
+	Error  *APIError   `json:"error,omitempty"`
+	cancel context.CancelFunc
+}
+
+func (j *Job) done() bool {
+	return j.State == JobSucceeded || j.State == JobFailed || j.State == JobCanceled
+}
+
+// jobStore runs jobs and keeps them until jobRetention after they finished
+type jobStore struct {
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	registry *DeviceRegistry
+	// last holds a channel per device that is closed when the job started last on the device finished
+	last map[string]chan struct{}
+}
+
+var jobs = newJobStore(devices)
+
+func newJobStore(registry *DeviceRegistry) *jobStore {
+	return &jobStore{jobs: map[string]*Job{}, registry: registry, last: map[string]chan struct{}{}}
+}
+
+// start runs the job in the background and returns it in its pending state
+func (s *jobStore) start(jobType string, udid string, run func(ctx context.Context) (interface{}, error)) Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{ID: uuid.New().String(), Type: jobType, UDID: udid, State: JobPending, Created: time.Now(), cancel: cancel}
+	s.mu.Lock()
+	s.pruneLocked(job.Created)
+	s.jobs[job.ID] = job
+	previous := s.last[udid]
+	finished := make(chan struct{})
+	s.last[udid] = finished
+	result := *job
+	s.mu.Unlock()
+	go func() {
+		defer s.finish(udid, finished)
+		if previous != nil {
+			<-previous
+		}
+		s.run(ctx, job, run)
+	}()
+	return result
+}
+
+// finish closes finished and forgets it if no job was started on the device since
+func (s *jobStore) finish(udid string, finished chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(finished)
+	if s.last[udid] == finished {
+		delete(s.last, udid)
+	}
+}
+
+func (s *jobStore) run(ctx context.Context, job *Job, run func(ctx context.Context) (interface{}, error)) {
+	defer job.cancel()
+	// maintenance tasks lock the device as well
+	unlock := s.registry.LockDevice(job.UDID)
+	defer unlock()
+	if !s.transition(job, func() {
+		now := time.Now()
+		job.State = JobRunning
+		job.Started = &now
+	}) {
+		return
+	}
+	defer monitors.begin(job.UDID, job.Type+" "+job.ID)()
+	logger := log.WithFields(log.Fields{"job": job.ID, "type": job.Type, "udid": job.UDID})
+	logger.Info("job started")
+	history.record(DeviceEvent{UDID: job.UDID, Type: events.JobRunning, Message: job.Type + " " + job.ID, Job: &events.Job{ID: job.ID, Type: job.Type, State: string(JobRunning)}})
+	hook := HookContext{Job: job.Type, ID: job.ID, UDID: job.UDID}
+	markSyslog(s.registry, job.UDID, syslog.MarkerStart, job.Type, job.ID)
+	var result interface{}
+	err := jobHooks.before(ctx, hook)
+	if err == nil {
+		result, err = run(ctx)
+	}
+	s.transition(job, func() {
+		now := time.Now()
+		job.Finished = &now
+		switch {
+		case err == nil:
+			job.State = JobSucceeded
+			job.Result = result
+		case errors.Is(err, context.Canceled) && ctx.Err() != nil:
+			job.State = JobCanceled
+			job.Error = &APIError{Code: ErrorCodeCanceled, Message: "job was canceled"}
+		default:
+			_, apiErr := apiErrorFor(err)
+			job.State = JobFailed
+			job.Error = &apiErr
+		}
+	})
+	logger.WithField("state", job.State).Info("job finished")
+	info := &events.Job{ID: job.ID, Type: job.Type, State: string(job.State)}
+	if job.Error != nil {
+		info.Error = job.Error.Message
+	}
+	history.record(DeviceEvent{UDID: job.UDID, Type: events.Type("job-" + string(job.State)), Message: job.Type + " " + job.ID, Job: info})
+	// the device stays locked for the hooks, so they can clean up before the next job
+	jobHooks.after(hook, info.State, info.Error)
+	markSyslog(s.registry, job.UDID, syslog.MarkerEnd, job.Type, job.ID)
+}
+
+// transition modifies the job unless it is done already, which happens when it was canceled while pending
+func (s *jobStore) transition(job *Job, modify func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job.done() {
+		return false
+	}
+	modify()
+	return true
+}
+
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// list returns the jobs of the device with udid or all jobs if udid is empty, oldest first
+func (s *jobStore) list(udid string) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	result := []Job{}
+	for _, job := range s.jobs {
+		if udid == "" || job.UDID == udid {
+			result = append(result, *job)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Created.Before(result[j].Created) })
+	return result
+}
+
+// cancel cancels a pending or running job. Pending jobs are canceled right away, running jobs once their
+// operation noticed it.
+func (s *jobStore) cancel(id string) (Job, *APIError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, &APIError{Code: ErrorCodeJobNotFound, Message: "job not found"}
+	}
+	if job.done() {
+		return *job, &APIError{Code: ErrorCodeJobFinished, Message: "job finished already"}
+	}
+	job.cancel()
+	if job.State == JobPending {
+		now := time.Now()
+		job.State = JobCanceled
+		job.Finished = &now
+		job.Error = &APIError{Code: ErrorCodeCanceled, Message: "job was canceled"}
+	}
+	return *job, nil
+}
+
+func (s *jobStore) pruneLocked(now time.Time) {
+	for id, job := range s.jobs {
+		if job.done() && now.Sub(*job.Finished) > jobRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// acceptJob responds with 202 Accepted, the job and its location
+func acceptJob(c *gin.Context, job Job) {
+	c.Header("Location", "/api/v2/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ListJobs lists the jobs of the last hour
+// @Summary      List jobs
+// @Description  Lists pending, running and recently finished jobs. Finished jobs are kept for an hour.
+// @Tags         jobs
+// @Produce      json
+// @Param        udid query string false "Only list jobs of the device with this udid"
+// @Success      200  {object}  []Job
+// @Router       /jobs [get]
+func ListJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, jobs.list(c.Query("udid")))
+}
+
+// GetJob returns a job
+// @Summary      Get a job
+// @Description  Returns the state of a job. Poll it until the state is succeeded, failed or canceled.
+// @Tags         jobs
+// @Produce      json
+// @Param        id path string true "Job id"
+// @Success      200  {object}  Job
+// @Failure      404  {object}  ErrorResponse
+// @Router       /jobs/{id} [get]
+func GetJob(c *gin.Context) {
+	job, ok := jobs.get(c.Param("id"))
+	if !ok {
+		abortWithAPIError(c, http.StatusNotFound, APIError{Code: ErrorCodeJobNotFound, Message: "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob cancels a job
+// @Summary      Cancel a job
+// @Description  Cancels a pending or running job. Running jobs end with state canceled once the device operation was aborted.
+// @Tags         jobs
+// @Produce      json
+// @Param        id path string true "Job id"
+// @Success      202  {object}  Job
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Router       /jobs/{id} [delete]
+func CancelJob(c *gin.Context) {
+	job, apiErr := jobs.cancel(c.Param("id"))
+	if apiErr != nil {
+		status := http.StatusNotFound
+		if apiErr.Code == ErrorCodeJobFinished {
+			status = http.StatusConflict
+		}
+		abortWithAPIError(c, status, *apiErr)
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func waitForJob(t *testing.T, store *jobStore, id string) Job {
+	var job Job
+	assert.Eventually(t, func() bool {
+		job, _ = store.get(id)
+		return job.done()
+	}, time.Second, time.Millisecond)
+	return job
+}
+
+func TestJobSucceedsAndFails(t *testing.T) {
+	store := newJobStore(NewDeviceRegistry())
+	job := store.start("install", "udid", func(ctx context.Context) (interface{}, error) {
+		return GenericResponse{Message: "installed"}, nil
+	})
+	assert.Equal(t, JobPending, job.State)
+	job = waitForJob(t, store, job.ID)
+	assert.Equal(t, JobSucceeded, job.State)
+	assert.Equal(t, GenericResponse{Message: "installed"}, job.Result)
+	assert.NotNil(t, job.Started)
+	assert.NotNil(t, job.Finished)
+
+	job = store.start("install", "udid", func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("broken pipe")
+	})
+	job = waitForJob(t, store, job.ID)
+	assert.Equal(t, JobFailed, job.State)
+	assert.Equal(t, &APIError{Code: ErrorCodeInternal, Message: "broken pipe"}, job.Error)
+	assert.Len(t, store.list("udid"), 2)
+	assert.Len(t, store.list("other"), 0)
+}
+
+func TestJobsOfADeviceRunSequentially(t *testing.T) {
+	store := newJobStore(NewDeviceRegistry())
+	release := make(chan struct{})
+	first := store.start("install", "udid", func(ctx context.Context) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	second := store.start("install", "udid", func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	assert.Eventually(t, func() bool {
+		job, _ := store.get(first.ID)
+		return job.State == JobRunning
+	}, time.Second, time.Millisecond)
+	job, _ := store.get(second.ID)
+	assert.Equal(t, JobPending, job.State)
+
+	close(release)
+	assert.Equal(t, JobSucceeded, waitForJob(t, store, second.ID).State)
+}
+
+func TestCancelJob(t *testing.T) {
+	store := newJobStore(NewDeviceRegistry())
+	running := store.start("install", "udid", func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	pending := store.start("install", "udid", func(ctx context.Context) (interface{}, error) {
+		t.Error("canceled pending job must not run")
+		return nil, nil
+	})
+
+	job, apiErr := store.cancel(pending.ID)
+	assert.Nil(t, apiErr)
+	assert.Equal(t, JobCanceled, job.State)
+
+	_, apiErr = store.cancel(running.ID)
+	assert.Nil(t, apiErr)
+	job = waitForJob(t, store, running.ID)
+	assert.Equal(t, JobCanceled, job.State)
+	assert.Equal(t, ErrorCodeCanceled, job.Error.Code)
+
+	_, apiErr = store.cancel(running.ID)
+	assert.Equal(t, ErrorCodeJobFinished, apiErr.Code)
+	_, apiErr = store.cancel("missing")
+	assert.Equal(t, ErrorCodeJobNotFound, apiErr.Code)
+}
+
+func TestFinishedJobsArePruned(t *testing.T) {
+	store := newJobStore(NewDeviceRegistry())
+	job := store.start("install", "udid", func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	waitForJob(t, store, job.ID)
+	store.mu.Lock()
+	finished := time.Now().Add(-2 * jobRetention)
+	store.jobs[job.ID].Finished = &finished
+	store.mu.Unlock()
+	assert.Empty(t, store.list(""))
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtLeeway is the clock skew allowed when checking exp and nbf
+const jwtLeeway = time.Minute
+
+// JWTConfig configures the keys bearer JWTs are verified with. HS256, HS384 and HS512 tokens are verified with
+// the secret, RS256, RS384, RS512, ES256, ES384 and ES512 tokens with the public keys. Tokens must have an exp
+// claim, scopes are taken from the space separated scope claim or the scopes array claim.
+type JWTConfig struct {
+	// Secret is the HMAC key, it can be sealed with 'ios secrets seal-value'
+	Secret string `json:"secret,omitempty"`
+	// PublicKeyFiles are PEM files with RSA or ECDSA public keys or certificates. Several keys allow rotating them.
+	PublicKeyFiles []string `json:"publicKeyFiles,omitempty"`
+	// Issuer is the required iss claim, any issuer is accepted if it is empty
+	Issuer string `json:"issuer,omitempty"`
+	// Audience must be in the aud claim, any audience is accepted if it is empty
+	Audience string `json:"audience,omitempty"`
+}
+
+// jwtVerifier verifies the signature and claims of JWTs
+type jwtVerifier struct {
+	secret   []byte
+	keys     []crypto.PublicKey
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+type jwtClaims struct {
+	Subject   string      `json:"sub"`
+	Issuer    string      `json:"iss"`
+	Audience  jwtAudience `json:"aud"`
+	Expires   *float64    `json:"exp"`
+	NotBefore *float64    `json:"nbf"`
+	Scope     string      `json:"scope"`
+	Scopes    []string    `json:"scopes"`
+}
+
+// jwtAudience is either a single string or an array of strings
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(b, &list)
+	*a = list
+	return err
+}
+
+// newJWTVerifier opens the secret and reads the public keys of the config
+func newJWTVerifier(config JWTConfig, secret string) (*jwtVerifier, error) {
+	verifier := &jwtVerifier{secret: []byte(secret), issuer: config.Issuer, audience: config.Audience, now: time.Now}
+	for _, path := range config.PublicKeyFiles {
+		keys, err := readPublicKeys(path)
+		if err != nil {
+			return nil, err
+		}
+		verifier.keys = append(verifier.keys, keys...)
+	}
+	if len(verifier.secret) == 0 && len(verifier.keys) == 0 {
+		return nil, errors.New("jwt needs a secret or public keys")
+	}
+	return verifier, nil
+}
+
+func readPublicKeys(path string) ([]crypto.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		var key crypto.PublicKey
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key in %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public key in %s", path)
+	}
+	return keys, nil
+}
+
+// verify checks the signature and the exp, nbf, iss and aud claims of the token
+func (v *jwtVerifier) verify(token string) (jwtClaims, error) {
+	var claims jwtClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed jwt")
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return claims, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errors.New("malformed jwt signature")
+	}
+	err = v.verifySignature(header.Alg, []byte(parts[0]+"."+parts[1]), signature)
+	if err != nil {
+		return claims, err
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return claims, err
+	}
+	now := v.now()
+	if claims.Expires == nil {
+		return claims, errors.New("jwt has no exp claim")
+	}
+	if now.After(unixTime(*claims.Expires).Add(jwtLeeway)) {
+		return claims, errors.New("jwt expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(unixTime(*claims.NotBefore)) {
+		return claims, errors.New("jwt not valid yet")
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return claims, errors.New("jwt has the wrong issuer")
+	}
+	if v.audience != "" && !containsString(claims.Audience, v.audience) {
+		return claims, errors.New("jwt has the wrong audience")
+	}
+	return claims, nil
+}
+
+func (v *jwtVerifier) verifySignature(alg string, signed []byte, signature []byte) error {
+	var hash crypto.Hash
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		hash = crypto.SHA256
+	case strings.HasSuffix(alg, "384"):
+		hash = crypto.SHA384
+	case strings.HasSuffix(alg, "512"):
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported jwt algorithm '%s'", alg)
+	}
+	if strings.HasPrefix(alg, "HS") {
+		if len(v.secret) == 0 {
+			return fmt.Errorf("unsupported jwt algorithm '%s'", alg)
+		}
+		mac := hmac.New(hash.New, v.secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("invalid jwt signature")
+		}
+		return nil
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	for _, key := range v.keys {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			if strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+				continue
+			}
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.New("invalid jwt signature")
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed jwt")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.New("malformed jwt")
+	}
+	return nil
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
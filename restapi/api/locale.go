@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/restapi/localefmt"
+	"github.com/gin-gonic/gin"
+)
+
+// LocaleSettings are the effective locale settings of a device and the values of the query params formatted with them
+type LocaleSettings struct {
+	localefmt.Settings
+	Formatted *LocaleFormatted `json:"formatted,omitempty"`
+}
+
+// LocaleFormatted are values formatted like the device shows them. Errors are set for values the locale can not
+// be formatted for, f.ex. dates of a japanese calendar.
+type LocaleFormatted struct {
+	Number  string            `json:"number,omitempty"`
+	Percent string            `json:"percent,omitempty"`
+	Date    string            `json:"date,omitempty"`
+	Time    string            `json:"time,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// readLocaleSettings reads the locale settings of the device with lockdown, tests replace it
+var readLocaleSettings = func(device ios.DeviceEntry) (localefmt.Settings, error) {
+	language, err := ios.GetLanguage(device)
+	if err != nil {
+		return localefmt.Settings{}, err
+	}
+	uses24HourClock, err := ios.GetUses24HourClock(device)
+	if err != nil {
+		return localefmt.Settings{}, err
+	}
+	return localefmt.New(language.Locale, language.Language, uses24HourClock)
+}
+
+// formatLocaleValues formats the number, decimals, percent and datetime query params
+func formatLocaleValues(c *gin.Context, settings localefmt.Settings) (*LocaleFormatted, error) {
+	if c.Query("number") == "" && c.Query("percent") == "" && c.Query("datetime") == "" {
+		return nil, nil
+	}
+	formatted := &LocaleFormatted{Errors: map[string]string{}}
+	if value := c.Query("number"); value != "" {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("number must be a number")
+		}
+		decimals, err := strconv.Atoi(c.DefaultQuery("decimals", "0"))
+		if err != nil || decimals < 0 || decimals > 20 {
+			return nil, fmt.Errorf("decimals must be a number from 0 to 20")
+		}
+		formatted.Number = settings.FormatNumber(number, decimals)
+	}
+	if value := c.Query("percent"); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("percent must be a number, 0.25 is 25 percent")
+		}
+		formatted.Percent = settings.FormatPercent(ratio)
+	}
+	if value := c.Query("datetime"); value != "" {
+		datetime, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("datetime must be RFC3339, f.ex. 2024-03-05T14:07:00+01:00")
+		}
+		formatted.Date, err = settings.FormatDate(datetime)
+		if err != nil {
+			formatted.Errors["date"] = err.Error()
+		}
+		formatted.Time, err = settings.FormatTime(datetime)
+		if err != nil {
+			formatted.Errors["time"] = err.Error()
+		}
+	}
+	return formatted, nil
+}
+
+// GetLocale returns the locale settings of the device
+// @Summary      Get the locale settings of a device
+// @Description  Returns locale, calendar, numbering system, separators, date and time patterns of the device. Values given as query params are formatted like the device shows them, so localization tests can compute the expected strings. Dates are formatted in the time zone of datetime with the short CLDR pattern, for gregorian and buddhist calendars of common locales.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        number query number false "number to format"
+// @Param        decimals query int false "fraction digits of number, defaults to 0"
+// @Param        percent query number false "ratio to format as percentage, 0.25 is 25 percent"
+// @Param        datetime query string false "RFC3339 date and time to format"
+// @Success      200  {object}  LocaleSettings
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/locale [get]
+func GetLocale(c *gin.Context) {
+	settings, err := readLocaleSettings(MustGetDevice(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	formatted, err := formatLocaleValues(c, settings)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, LocaleSettings{Settings: settings, Formatted: formatted})
+}
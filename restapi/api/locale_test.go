@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/restapi/localefmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLocale(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("locale-a"))
+	defer devices.Remove("locale-a")
+	defer func(read func(ios.DeviceEntry) (localefmt.Settings, error)) { readLocaleSettings = read }(readLocaleSettings)
+	readLocaleSettings = func(device ios.DeviceEntry) (localefmt.Settings, error) {
+		return localefmt.New("de_DE", "de-DE", true)
+	}
+	r := gin.New()
+	r.GET("/device/:udid/locale", DeviceMiddleware(), GetLocale)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/locale-a/locale", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var settings LocaleSettings
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &settings))
+	assert.Equal(t, "de-DE", settings.Tag)
+	assert.Equal(t, "dd.MM.yy", settings.ShortDate)
+	assert.Nil(t, settings.Formatted)
+
+	query := url.Values{"number": {"1234.5"}, "decimals": {"2"}, "datetime": {"2024-03-05T14:07:00+01:00"}}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/locale-a/locale?"+query.Encode(), nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &settings))
+	assert.Equal(t, LocaleFormatted{Number: "1.234,50", Date: "05.03.24", Time: "14:07"}, *settings.Formatted)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/locale-a/locale?datetime=yesterday", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/simlocation"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxGPXSize limits uploaded gpx files
+const maxGPXSize = 10 << 20
+
+// playRoute and resetLocation change the simulated location, tests replace them
+var (
+	playRoute     = simlocation.PlayRoute
+	resetLocation = simlocation.ResetLocation
+)
+
+// RouteRequest starts a route playback. The route is either the list of waypoints or, for multipart requests,
+// the uploaded gpx file.
+type RouteRequest struct {
+	Waypoints []simlocation.Waypoint `json:"waypoints"`
+	// Speed in meters per second
+	Speed float64 `json:"speed"`
+	// IntervalMillis is how often the location is updated, once a second if it is 0
+	IntervalMillis int  `json:"intervalMillis,omitempty"`
+	Loop           bool `json:"loop,omitempty"`
+}
+
+// RoutePlayback is a route that is played or was played on a device
+type RoutePlayback struct {
+	ID        string     `json:"id"`
+	UDID      string     `json:"udid"`
+	Waypoints int        `json:"waypoints"`
+	Meters    float64    `json:"meters"`
+	Speed     float64    `json:"speed"`
+	Loop      bool       `json:"loop"`
+	Started   time.Time  `json:"started"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	// Position is the simulated location set last and Distance how far along the route it is in meters
+	Position *simlocation.Waypoint `json:"position,omitempty"`
+	Distance float64               `json:"distance"`
+	// Error is why the playback ended if it was not stopped and did not reach the end of the route
+	Error string `json:"error,omitempty"`
+}
+
+type routePlayback struct {
+	RoutePlayback
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// routeStore runs at most one route playback per device
+type routeStore struct {
+	mu        sync.Mutex
+	playbacks map[string]*routePlayback
+}
+
+var locationRoutes = &routeStore{playbacks: map[string]*routePlayback{}}
+
+// start plays the route on the device in the background, a playback that is still running on it is stopped first
+func (s *routeStore) start(device ios.DeviceEntry, route simlocation.Route, opts simlocation.RouteOptions) RoutePlayback {
+	udid := device.Properties.SerialNumber
+	s.stop(udid)
+	ctx, cancel := context.WithCancel(context.Background())
+	playback := &routePlayback{
+		RoutePlayback: RoutePlayback{
+			ID:        uuid.New().String(),
+			UDID:      udid,
+			Waypoints: len(route),
+			Meters:    route.Length(),
+			Speed:     opts.Speed,
+			Loop:      opts.Loop,
+			Started:   time.Now(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	opts.OnUpdate = func(position simlocation.Waypoint, distance float64) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		playback.Position = &position
+		playback.Distance = distance
+	}
+	s.mu.Lock()
+	s.playbacks[udid] = playback
+	result := playback.RoutePlayback
+	s.mu.Unlock()
+	go func() {
+		defer close(playback.done)
+		err := playRoute(ctx, device, route, opts)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		now := time.Now()
+		playback.Finished = &now
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.WithError(err).WithField("udid", udid).Warn("route playback failed")
+			playback.Error = err.Error()
+		}
+	}()
+	return result
+}
+
+// stop stops the running playback of the device and waits until it ended. It returns false if none was running.
+func (s *routeStore) stop(udid string) (RoutePlayback, bool) {
+	s.mu.Lock()
+	playback, ok := s.playbacks[udid]
+	running := ok && playback.Finished == nil
+	s.mu.Unlock()
+	if !running {
+		return RoutePlayback{}, false
+	}
+	playback.cancel()
+	<-playback.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return playback.RoutePlayback, true
+}
+
+// get returns the latest playback of the device
+func (s *routeStore) get(udid string) (RoutePlayback, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	playback, ok := s.playbacks[udid]
+	if !ok {
+		return RoutePlayback{}, false
+	}
+	return playback.RoutePlayback, true
+}
+
+// routeFromRequest reads the route either from a json RouteRequest or from a multipart form with the gpx file
+// and the options as fields
+func routeFromRequest(c *gin.Context) (simlocation.Route, simlocation.RouteOptions, error) {
+	var request RouteRequest
+	var route simlocation.Route
+	if c.ContentType() == gin.MIMEMultipartPOSTForm {
+		gpx, err := formFileBytes(c, "gpx", maxGPXSize)
+		if err != nil {
+			return nil, simlocation.RouteOptions{}, err
+		}
+		if gpx == nil {
+			return nil, simlocation.RouteOptions{}, errors.New("upload the route as multipart field gpx")
+		}
+		route, err = simlocation.ParseGPXRoute(gpx)
+		if err != nil {
+			return nil, simlocation.RouteOptions{}, err
+		}
+		request.Speed, err = strconv.ParseFloat(c.PostForm("speed"), 64)
+		if err != nil {
+			return nil, simlocation.RouteOptions{}, errors.New("speed is missing or not a number")
+		}
+		if interval := c.PostForm("intervalMillis"); interval != "" {
+			request.IntervalMillis, err = strconv.Atoi(interval)
+			if err != nil {
+				return nil, simlocation.RouteOptions{}, errors.New("intervalMillis is not a number")
+			}
+		}
+		request.Loop = c.PostForm("loop") == "true"
+	} else {
+		err := c.ShouldBindJSON(&request)
+		if err != nil {
+			return nil, simlocation.RouteOptions{}, err
+		}
+		route = request.Waypoints
+	}
+	if request.IntervalMillis < 0 {
+		return nil, simlocation.RouteOptions{}, errors.New("intervalMillis must not be negative")
+	}
+	opts := simlocation.RouteOptions{Speed: request.Speed, Interval: time.Duration(request.IntervalMillis) * time.Millisecond, Loop: request.Loop}
+	return route, opts, simlocation.ValidateRoute(route, opts)
+}
+
+// StartRoute plays a route on the device
+// @Summary      Move the simulated location along a route
+// @Description  Moves the simulated location along a route at a constant speed in meters per second, updating it every intervalMillis (a second by default) with the position interpolated between the waypoints. Send the waypoints as json or upload a gpx file as multipart field "gpx" with speed, intervalMillis and loop as form fields. Tracks, routes or waypoints of the gpx file are used, their times are ignored.
+// @Description  The playback runs in the background until the end of the route or, with loop=true, until it is stopped. A playback that is still running on the device is stopped first. The location stays at the last position, use /resetlocation to reset it.
+// @Tags         general_device_specific
+// @Accept       json
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        route body RouteRequest false "waypoints and options"
+// @Param        gpx formData file false "the route as gpx file"
+// @Success      202  {object}  RoutePlayback
+// @Failure      422  {object}  GenericResponse
+// @Router       /device/{udid}/setlocation/route [post]
+func StartRoute(c *gin.Context) {
+	device := MustGetDevice(c)
+	route, opts, err := routeFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	playback := locationRoutes.start(device, route, opts)
+	udid := device.Properties.SerialNumber
+	ci.created(udid, "location-route", playback.ID, func() error {
+		locationRoutes.stop(udid)
+		return resetLocation(device)
+	})
+	c.JSON(http.StatusAccepted, playback)
+}
+
+// GetRoute returns the route playback of the device
+// @Summary      Get the route playback
+// @Description  Returns the running or the last route playback of the device with the current position.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200  {object}  RoutePlayback
+// @Failure      404  {object}  GenericResponse
+// @Router       /device/{udid}/setlocation/route [get]
+func GetRoute(c *gin.Context) {
+	playback, ok := locationRoutes.get(MustGetDevice(c).Properties.SerialNumber)
+	if !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "no route was played on the device"})
+		return
+	}
+	c.JSON(http.StatusOK, playback)
+}
+
+// StopRoute stops the route playback of the device
+// @Summary      Stop the route playback
+// @Description  Stops the running route playback, the simulated location stays at the current position.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200  {object}  RoutePlayback
+// @Failure      404  {object}  GenericResponse
+// @Router       /device/{udid}/setlocation/route [delete]
+func StopRoute(c *gin.Context) {
+	udid := MustGetDevice(c).Properties.SerialNumber
+	playback, ok := locationRoutes.stop(udid)
+	if !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "no route is playing"})
+		return
+	}
+	ci.removed(udid, "location-route", playback.ID)
+	c.JSON(http.StatusOK, playback)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/simlocation"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutePlayback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := playRoute
+	t.Cleanup(func() { playRoute = original })
+	played := make(chan simlocation.Route, 2)
+	playRoute = func(ctx context.Context, device ios.DeviceEntry, route simlocation.Route, opts simlocation.RouteOptions) error {
+		played <- route
+		opts.OnUpdate(route[0], 0)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	r := gin.New()
+	device := r.Group("/device/:udid", func(c *gin.Context) {
+		c.Set(IOS_KEY, testDevice(c.Param("udid")))
+		c.Next()
+	})
+	simpleDeviceRoutes(device)
+	do := func(method string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/device/route-udid/setlocation/route", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, do(http.MethodPost, `{"waypoints": [{"latitude": 1, "longitude": 2}], "speed": 10}`).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, do(http.MethodPost, `{"waypoints": [{"latitude": 1, "longitude": 2}, {"latitude": 1.1, "longitude": 2}]}`).Code)
+
+	w := do(http.MethodPost, `{"waypoints": [{"latitude": 1, "longitude": 2}, {"latitude": 1.1, "longitude": 2}], "speed": 10, "loop": true}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var playback RoutePlayback
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &playback))
+	assert.Equal(t, 2, playback.Waypoints)
+	assert.InDelta(t, 11119, playback.Meters, 10)
+	assert.Equal(t, simlocation.Route{{Latitude: 1, Longitude: 2}, {Latitude: 1.1, Longitude: 2}}, <-played)
+
+	assert.Eventually(t, func() bool {
+		current, _ := locationRoutes.get("route-udid")
+		return current.Position != nil
+	}, time.Second, time.Millisecond)
+
+	// a gpx upload replaces the running playback
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("gpx", "route.gpx")
+	require.NoError(t, err)
+	_, err = part.Write([]byte(`<gpx><trk><trkseg><trkpt lat="52.5" lon="13.4"/><trkpt lat="52.6" lon="13.5"/></trkseg></trk></gpx>`))
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteField("speed", "13.9"))
+	require.NoError(t, writer.Close())
+	req := httptest.NewRequest(http.MethodPost, "/device/route-udid/setlocation/route", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var second RoutePlayback
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+	assert.NotEqual(t, playback.ID, second.ID)
+	assert.Equal(t, 13.9, second.Speed)
+	assert.Equal(t, simlocation.Route{{Latitude: 52.5, Longitude: 13.4}, {Latitude: 52.6, Longitude: 13.5}}, <-played)
+
+	w = do(http.MethodDelete, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &playback))
+	assert.Equal(t, second.ID, playback.ID)
+	assert.NotNil(t, playback.Finished)
+	assert.Empty(t, playback.Error, "stopping is no error")
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "").Code)
+}
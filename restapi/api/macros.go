@@ -0,0 +1,556 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// macrosEnvVar is the path of a json file with a list of Macro. Recorded and changed macros are written back to it.
+const macrosEnvVar = "GO_IOS_MACROS"
+
+// maxMacroDelay caps the delay before a step, so a recording that was left running doesn't block a replay for hours
+const maxMacroDelay = time.Minute
+
+// MacroStep is one input event of a macro
+type MacroStep struct {
+	// DelayMs is the time since the previous step
+	DelayMs int64 `json:"delayMs"`
+	// Type is tap, swipe or text
+	Type string  `json:"type"`
+	X    float64 `json:"x,omitempty"`
+	Y    float64 `json:"y,omitempty"`
+	// ToX and ToY are where a swipe ends
+	ToX float64 `json:"toX,omitempty"`
+	ToY float64 `json:"toY,omitempty"`
+	// DurationMs is how long a swipe takes
+	DurationMs int64  `json:"durationMs,omitempty"`
+	Text       string `json:"text,omitempty"`
+}
+
+// Macro is a named sequence of input events. Coordinates are in points of a screen with Width and Height, they
+// are scaled to the screen size of the device a macro is replayed on.
+type Macro struct {
+	Name     string      `json:"name"`
+	Width    float64     `json:"width"`
+	Height   float64     `json:"height"`
+	Steps    []MacroStep `json:"steps"`
+	Recorded string      `json:"recordedOn,omitempty"`
+	Created  time.Time   `json:"created"`
+}
+
+// MacroReplayResult is the result of a replay job
+type MacroReplayResult struct {
+	Macro  string  `json:"macro"`
+	Steps  int     `json:"steps"`
+	ScaleX float64 `json:"scaleX"`
+	ScaleY float64 `json:"scaleY"`
+}
+
+var macroNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Validate checks the name, the screen size and the steps of the macro
+func (m Macro) Validate() error {
+	if !macroNamePattern.MatchString(m.Name) {
+		return fmt.Errorf("invalid macro name '%s', use up to 64 letters, digits, dots, dashes and underscores", m.Name)
+	}
+	if m.Width <= 0 || m.Height <= 0 {
+		return fmt.Errorf("macro %s needs the screen width and height it was made for", m.Name)
+	}
+	for i, step := range m.Steps {
+		if step.DelayMs < 0 || step.DurationMs < 0 {
+			return fmt.Errorf("step %d of macro %s has a negative delay or duration", i, m.Name)
+		}
+		switch step.Type {
+		case "tap", "swipe":
+		case "text":
+			if step.Text == "" {
+				return fmt.Errorf("text step %d of macro %s has no text", i, m.Name)
+			}
+		default:
+			return fmt.Errorf("step %d of macro %s has invalid type '%s', use tap, swipe or text", i, m.Name, step.Type)
+		}
+	}
+	return nil
+}
+
+// macroStore keeps the macros by name
+type macroStore struct {
+	mu     sync.RWMutex
+	macros map[string]Macro
+	path   string
+}
+
+var macros = &macroStore{macros: map[string]Macro{}}
+
+func (s *macroStore) list() []Macro {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]Macro, 0, len(s.macros))
+	for _, macro := range s.macros {
+		result = append(result, macro)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func (s *macroStore) get(name string) (Macro, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	macro, ok := s.macros[name]
+	return macro, ok
+}
+
+func (s *macroStore) put(macro Macro) error {
+	if err := macro.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.macros[macro.Name] = macro
+	s.mu.Unlock()
+	return s.save()
+}
+
+func (s *macroStore) remove(name string) (bool, error) {
+	s.mu.Lock()
+	_, ok := s.macros[name]
+	delete(s.macros, name)
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, s.save()
+}
+
+func (s *macroStore) save() error {
+	s.mu.RLock()
+	path := s.path
+	s.mu.RUnlock()
+	if path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+func (s *macroStore) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var list []Macro
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &list)
+		if err != nil {
+			return fmt.Errorf("invalid macros in %s: %w", path, err)
+		}
+	}
+	byName := map[string]Macro{}
+	for _, macro := range list {
+		if err := macro.Validate(); err != nil {
+			return err
+		}
+		byName[macro.Name] = macro
+	}
+	s.mu.Lock()
+	s.macros = byName
+	s.path = path
+	s.mu.Unlock()
+	return nil
+}
+
+// loadMacros loads the macros configured with GO_IOS_MACROS
+func loadMacros() {
+	path := os.Getenv(macrosEnvVar)
+	if path == "" {
+		return
+	}
+	err := macros.loadFile(path)
+	if err != nil {
+		log.WithError(err).Errorf("ignoring %s", macrosEnvVar)
+	}
+}
+
+var (
+	wdaTapPath  = regexp.MustCompile(`^/session/[^/]+/wda/tap(/[^/]+)?$`)
+	wdaDragPath = regexp.MustCompile(`^/session/[^/]+/wda/dragfromtoforduration$`)
+	wdaKeysPath = regexp.MustCompile(`^/session/[^/]+/wda/keys$`)
+)
+
+// macroRecorder turns the tap, drag and keys requests proxied to WDA into macro steps
+type macroRecorder struct {
+	mu    sync.Mutex
+	macro Macro
+	last  time.Time
+}
+
+func newMacroRecorder(name string, udid string, width float64, height float64) *macroRecorder {
+	now := time.Now()
+	return &macroRecorder{macro: Macro{Name: name, Width: width, Height: height, Steps: []MacroStep{}, Recorded: udid, Created: now}, last: now}
+}
+
+// record adds a step if the request is a tap, drag or keys request, other requests are ignored
+func (r *macroRecorder) record(path string, body []byte) {
+	step, ok := macroStepFromWda(path, body)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	step.DelayMs = now.Sub(r.last).Milliseconds()
+	r.last = now
+	r.macro.Steps = append(r.macro.Steps, step)
+}
+
+func (r *macroRecorder) finish() Macro {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.macro
+}
+
+func macroStepFromWda(path string, body []byte) (MacroStep, bool) {
+	var request struct {
+		X        float64       `json:"x"`
+		Y        float64       `json:"y"`
+		FromX    float64       `json:"fromX"`
+		FromY    float64       `json:"fromY"`
+		ToX      float64       `json:"toX"`
+		ToY      float64       `json:"toY"`
+		Duration float64       `json:"duration"`
+		Value    []interface{} `json:"value"`
+		Text     string        `json:"text"`
+	}
+	if json.Unmarshal(body, &request) != nil {
+		return MacroStep{}, false
+	}
+	switch {
+	case wdaTapPath.MatchString(path):
+		return MacroStep{Type: "tap", X: request.X, Y: request.Y}, true
+	case wdaDragPath.MatchString(path):
+		return MacroStep{Type: "swipe", X: request.FromX, Y: request.FromY, ToX: request.ToX, ToY: request.ToY, DurationMs: int64(request.Duration * 1000)}, true
+	case wdaKeysPath.MatchString(path):
+		text := request.Text
+		for _, value := range request.Value {
+			text += fmt.Sprint(value)
+		}
+		return MacroStep{Type: "text", Text: text}, text != ""
+	}
+	return MacroStep{}, false
+}
+
+// macroTarget is the device a macro is replayed on
+type macroTarget interface {
+	windowSize() (float64, float64, error)
+	tap(x float64, y float64) error
+	swipe(fromX float64, fromY float64, toX float64, toY float64, duration time.Duration) error
+	typeText(text string) error
+}
+
+// wdaTarget replays macros with the WebDriverAgent of a session
+type wdaTarget struct {
+	baseURL   string
+	sessionID string
+	client    *http.Client
+}
+
+func newWdaTarget(session *WdaSession) wdaTarget {
+	return wdaTarget{baseURL: fmt.Sprintf("http://127.0.0.1:%d", session.HostPort), sessionID: session.SessionID, client: &http.Client{Timeout: time.Minute}}
+}
+
+func (t wdaTarget) do(method string, path string, body interface{}, result interface{}) error {
+	var encoded []byte
+	if body != nil {
+		encoded = []byte(MustMarshal(body))
+	}
+	return t.request(method, "/session/"+t.sessionID+path, encoded, result)
+}
+
+// send sends a raw request to WDA, path includes the session if the endpoint needs one
+func (t wdaTarget) send(method string, path string, body []byte) error {
+	return t.request(method, path, body, nil)
+}
+
+func (t wdaTarget) request(method string, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(method, t.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("wda %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("wda %s returned %s", path, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (t wdaTarget) windowSize() (float64, float64, error) {
+	var response struct {
+		Value struct {
+			Width  float64 `json:"width"`
+			Height float64 `json:"height"`
+		} `json:"value"`
+	}
+	err := t.do(http.MethodGet, "/window/size", nil, &response)
+	return response.Value.Width, response.Value.Height, err
+}
+
+func (t wdaTarget) tap(x float64, y float64) error {
+	return t.do(http.MethodPost, "/wda/tap/0", map[string]float64{"x": x, "y": y}, nil)
+}
+
+func (t wdaTarget) swipe(fromX float64, fromY float64, toX float64, toY float64, duration time.Duration) error {
+	return t.do(http.MethodPost, "/wda/dragfromtoforduration", map[string]float64{"fromX": fromX, "fromY": fromY, "toX": toX, "toY": toY, "duration": duration.Seconds()}, nil)
+}
+
+func (t wdaTarget) typeText(text string) error {
+	return t.do(http.MethodPost, "/wda/keys", map[string]interface{}{"value": strings.Split(text, "")}, nil)
+}
+
+// replayMacro sends the steps of the macro to the target with the recorded timing divided by speed. Coordinates
+// are scaled from the screen size of the macro to the one of the target.
+func replayMacro(ctx context.Context, target macroTarget, macro Macro, speed float64) (MacroReplayResult, error) {
+	result := MacroReplayResult{Macro: macro.Name}
+	width, height, err := target.windowSize()
+	if err != nil {
+		return result, fmt.Errorf("replayMacro: failed getting screen size: %w", err)
+	}
+	if width <= 0 || height <= 0 {
+		return result, fmt.Errorf("replayMacro: invalid screen size %vx%v", width, height)
+	}
+	result.ScaleX = width / macro.Width
+	result.ScaleY = height / macro.Height
+	for i, step := range macro.Steps {
+		delay := time.Duration(float64(step.DelayMs) * float64(time.Millisecond) / speed)
+		if delay > maxMacroDelay {
+			delay = maxMacroDelay
+		}
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(delay):
+		}
+		x, y := step.X*result.ScaleX, step.Y*result.ScaleY
+		switch step.Type {
+		case "tap":
+			err = target.tap(x, y)
+		case "swipe":
+			err = target.swipe(x, y, step.ToX*result.ScaleX, step.ToY*result.ScaleY, time.Duration(float64(step.DurationMs)*float64(time.Millisecond)/speed))
+		case "text":
+			err = target.typeText(step.Text)
+		}
+		if err != nil {
+			return result, fmt.Errorf("replayMacro: step %d failed: %w", i, err)
+		}
+		result.Steps++
+	}
+	return result, nil
+}
+
+// ListMacros lists the macros
+// @Summary      List input macros
+// @Description  Lists the recorded and uploaded input macros.
+// @Tags         macros
+// @Produce      json
+// @Success      200  {object}  []Macro
+// @Router       /macros [get]
+func ListMacros(c *gin.Context) {
+	c.JSON(http.StatusOK, macros.list())
+}
+
+// GetMacro returns a macro
+// @Summary      Get an input macro
+// @Tags         macros
+// @Produce      json
+// @Param        name path string true "macro name"
+// @Success      200  {object}  Macro
+// @Failure      404  {object}  GenericResponse
+// @Router       /macros/{name} [get]
+func GetMacro(c *gin.Context) {
+	macro, ok := macros.get(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "macro not found"})
+		return
+	}
+	c.JSON(http.StatusOK, macro)
+}
+
+// PutMacro creates or replaces a macro
+// @Summary      Create or replace an input macro
+// @Description  Stores a macro with the given steps, f.ex. a recorded macro that was edited. Macros are written to the file at GO_IOS_MACROS, if it is set.
+// @Tags         macros
+// @Accept       json
+// @Produce      json
+// @Param        name path string true "macro name"
+// @Param        macro body Macro true "macro"
+// @Success      200  {object}  Macro
+// @Failure      422  {object}  GenericResponse
+// @Router       /macros/{name} [put]
+func PutMacro(c *gin.Context) {
+	var macro Macro
+	err := c.ShouldBindJSON(&macro)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	macro.Name = c.Param("name")
+	if macro.Created.IsZero() {
+		macro.Created = time.Now()
+	}
+	if err := macro.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	err = macros.put(macro)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, macro)
+}
+
+// DeleteMacro removes a macro
+// @Summary      Delete an input macro
+// @Tags         macros
+// @Produce      json
+// @Param        name path string true "macro name"
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Router       /macros/{name} [delete]
+func DeleteMacro(c *gin.Context) {
+	found, err := macros.remove(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "macro not found"})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "macro deleted"})
+}
+
+// StartMacroRecording starts recording the input of a WDA session into a macro
+// @Summary      Record an input macro
+// @Description  Records the taps, drags and keys sent through the WDA proxy of the session, with their timing, until the recording is stopped.
+// @Tags         macros
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        id path string true "Session id"
+// @Param        name query string true "macro name"
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Failure      409  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /device/{udid}/wda/session/{id}/macro/start [post]
+func StartMacroRecording(c *gin.Context) {
+	device := MustGetDevice(c)
+	udid := device.Properties.SerialNumber
+	session := sessionPool.get(udid, c.Param("id"))
+	if session == nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "session not found"})
+		return
+	}
+	name := c.Query("name")
+	if !macroNamePattern.MatchString(name) {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: fmt.Sprintf("invalid macro name '%s'", name)})
+		return
+	}
+	width, height, err := newWdaTarget(session).windowSize()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	if !sessionPool.startMacro(udid, session.ID, newMacroRecorder(name, udid, width, height)) {
+		c.JSON(http.StatusConflict, GenericResponse{Error: "the session is already recording a macro"})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "recording macro " + name})
+}
+
+// StopMacroRecording stops recording a macro and stores it
+// @Summary      Stop recording an input macro
+// @Tags         macros
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        id path string true "Session id"
+// @Success      200  {object}  Macro
+// @Failure      404  {object}  GenericResponse
+// @Router       /device/{udid}/wda/session/{id}/macro/stop [post]
+func StopMacroRecording(c *gin.Context) {
+	device := MustGetDevice(c)
+	recorder := sessionPool.stopMacro(device.Properties.SerialNumber, c.Param("id"))
+	if recorder == nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "the session is not recording a macro"})
+		return
+	}
+	macro := recorder.finish()
+	err := macros.put(macro)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, macro)
+}
+
+// ReplayMacro replays a macro on a WDA session
+// @Summary      Replay an input macro
+// @Description  Starts a job sending the steps of the macro to the WDA session with their recorded timing. Coordinates are scaled to the screen size of the device.
+// @Tags         macros
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        id path string true "Session id"
+// @Param        name path string true "macro name"
+// @Param        speed query number false "replay speed, 2 replays twice as fast, defaults to 1"
+// @Success      202  {object}  Job
+// @Failure      404  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /device/{udid}/wda/session/{id}/macros/{name}/replay [post]
+func ReplayMacro(c *gin.Context) {
+	device := MustGetDevice(c)
+	session := sessionPool.get(device.Properties.SerialNumber, c.Param("id"))
+	if session == nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "session not found"})
+		return
+	}
+	macro, ok := macros.get(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "macro not found"})
+		return
+	}
+	speed := 1.0
+	if value := c.Query("speed"); value != "" {
+		var err error
+		speed, err = strconv.ParseFloat(value, 64)
+		if err != nil || speed <= 0 {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "speed must be a positive number"})
+			return
+		}
+	}
+	target := newWdaTarget(session)
+	acceptJob(c, jobs.start("replay-macro", device.Properties.SerialNumber, func(ctx context.Context) (interface{}, error) {
+		return replayMacro(ctx, target, macro, speed)
+	}))
+}
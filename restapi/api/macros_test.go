@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMacroTarget struct {
+	width, height float64
+	calls         []string
+}
+
+func (t *fakeMacroTarget) windowSize() (float64, float64, error) {
+	return t.width, t.height, nil
+}
+
+func (t *fakeMacroTarget) tap(x float64, y float64) error {
+	t.calls = append(t.calls, fmt.Sprintf("tap %v,%v", x, y))
+	return nil
+}
+
+func (t *fakeMacroTarget) swipe(fromX float64, fromY float64, toX float64, toY float64, duration time.Duration) error {
+	t.calls = append(t.calls, fmt.Sprintf("swipe %v,%v %v,%v %s", fromX, fromY, toX, toY, duration))
+	return nil
+}
+
+func (t *fakeMacroTarget) typeText(text string) error {
+	t.calls = append(t.calls, "text "+text)
+	return nil
+}
+
+func TestMacroRecorder(t *testing.T) {
+	recorder := newMacroRecorder("login", "udid", 375, 667)
+	recorder.record("/session/s1/wda/tap/0", []byte(`{"x":10,"y":20}`))
+	recorder.record("/session/s1/wda/screen", []byte(`{}`))
+	recorder.record("/session/s1/wda/dragfromtoforduration", []byte(`{"fromX":1,"fromY":2,"toX":3,"toY":4,"duration":0.5}`))
+	recorder.record("/session/s1/wda/keys", []byte(`{"value":["h","i"]}`))
+	macro := recorder.finish()
+	require.NoError(t, macro.Validate())
+	require.Len(t, macro.Steps, 3)
+	assert.Equal(t, MacroStep{Type: "tap", X: 10, Y: 20, DelayMs: macro.Steps[0].DelayMs}, macro.Steps[0])
+	assert.Equal(t, int64(500), macro.Steps[1].DurationMs)
+	assert.Equal(t, "hi", macro.Steps[2].Text)
+	assert.Equal(t, "udid", macro.Recorded)
+}
+
+func TestReplayMacro(t *testing.T) {
+	macro := Macro{Name: "m", Width: 100, Height: 200, Steps: []MacroStep{
+		{Type: "tap", X: 10, Y: 20, DelayMs: 20},
+		{Type: "swipe", X: 0, Y: 0, ToX: 50, ToY: 100, DurationMs: 400},
+		{Type: "text", Text: "hello"},
+	}}
+	target := &fakeMacroTarget{width: 200, height: 300}
+	start := time.Now()
+	result, err := replayMacro(context.Background(), target, macro, 2)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	assert.Equal(t, MacroReplayResult{Macro: "m", Steps: 3, ScaleX: 2, ScaleY: 1.5}, result)
+	assert.Equal(t, []string{"tap 20,30", "swipe 0,0 100,150 200ms", "text hello"}, target.calls)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = replayMacro(ctx, target, macro, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMacroStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "macros.json")
+	store := &macroStore{macros: map[string]Macro{}}
+	require.NoError(t, store.loadFile(path))
+	assert.Error(t, store.put(Macro{Name: "bad name", Width: 1, Height: 1}))
+	assert.Error(t, store.put(Macro{Name: "m", Width: 1, Height: 1, Steps: []MacroStep{{Type: "pinch"}}}))
+	require.NoError(t, store.put(Macro{Name: "m", Width: 1, Height: 1, Steps: []MacroStep{{Type: "tap"}}}))
+
+	reloaded := &macroStore{macros: map[string]Macro{}}
+	require.NoError(t, reloaded.loadFile(path))
+	assert.Len(t, reloaded.list(), 1)
+	found, err := reloaded.remove("m")
+	require.NoError(t, err)
+	assert.True(t, found)
+	_, ok := reloaded.get("m")
+	assert.False(t, ok)
+}
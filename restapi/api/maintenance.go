@@ -0,0 +1,320 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/diagnostics"
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const maintenanceCheckInterval = 30 * time.Second
+
+// maintenanceTasks are the tasks that can run at the start of a maintenance window
+var maintenanceTasks = map[string]func(device ios.DeviceEntry) error{
+	"cleanup": func(device ios.DeviceEntry) error {
+		sessionPool.releaseIdle(device.Properties.SerialNumber)
+		return nil
+	},
+	"reboot": func(device ios.DeviceEntry) error {
+		history.record(DeviceEvent{UDID: device.Properties.SerialNumber, Type: "reboot-requested", Message: "maintenance window"})
+		return diagnostics.Reboot(device)
+	},
+	"repair": func(device ios.DeviceEntry) error {
+		return pairings.repair(device, "scheduled by a maintenance window")
+	},
+	"update": updateToGoldenState,
+}
+
+// updateToGoldenState installs the app builds and applies the settings of the golden states of a device. Os
+// updates can't be installed through lockdown, a device with an os version outside of its golden state gets an
+// os-update-required event instead, so an MDM or a person can update it.
+func updateToGoldenState(device ios.DeviceEntry) error {
+	udid := device.Properties.SerialNumber
+	report := driftReport(device)
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("failed checking the golden state: %s", strings.Join(report.Errors, ", "))
+	}
+	for _, drift := range report.Drift {
+		if drift.Kind == "os_version" {
+			history.record(DeviceEvent{UDID: udid, Type: events.OSUpdateRequired,
+				Message: fmt.Sprintf("os version %v, the golden state of %s needs %s %v", drift.Actual, drift.Label, drift.Key, drift.Expected)})
+		}
+	}
+	result := remediate(context.Background(), device, report, goldenStates.forLabels(report.Labels))
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("updating %d of %d drifts failed: %s", len(result.Failed), len(result.Failed)+len(result.Fixed), strings.Join(result.Failed, ", "))
+	}
+	return nil
+}
+
+// MaintenanceWindow is a recurring time span in which a device is not schedulable and maintenance tasks run.
+// It applies either to the device with UDID or to all devices with Label.
+type MaintenanceWindow struct {
+	ID    string `json:"id"`
+	UDID  string `json:"udid,omitempty"`
+	Label string `json:"label,omitempty"`
+	// Cron is a standard 5 field cron expression in the local time of the host for the start of the window
+	Cron string `json:"cron"`
+	// Duration of the window, f.ex. "2h30m"
+	Duration string `json:"duration"`
+	// Tasks run in the given order when the window starts
+	Tasks    []string `json:"tasks,omitempty"`
+	schedule cronSchedule
+	duration time.Duration
+}
+
+// MaintenanceOccurrence is a single occurrence of a MaintenanceWindow
+type MaintenanceOccurrence struct {
+	WindowID string    `json:"windowId"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Active   bool      `json:"active"`
+}
+
+func (w *MaintenanceWindow) validate() error {
+	if (w.UDID == "") == (w.Label == "") {
+		return fmt.Errorf("either udid or label is required")
+	}
+	schedule, err := parseCron(w.Cron)
+	if err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(w.Duration)
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("invalid duration '%s'", w.Duration)
+	}
+	for _, task := range w.Tasks {
+		if _, ok := maintenanceTasks[task]; !ok {
+			return fmt.Errorf("unknown task '%s', supported tasks are %s", task, strings.Join(supportedMaintenanceTasks(), ", "))
+		}
+	}
+	w.schedule = schedule
+	w.duration = duration
+	return nil
+}
+
+func supportedMaintenanceTasks() []string {
+	tasks := make([]string, 0, len(maintenanceTasks))
+	for task := range maintenanceTasks {
+		tasks = append(tasks, task)
+	}
+	sort.Strings(tasks)
+	return tasks
+}
+
+func (w *MaintenanceWindow) appliesTo(udid string, registry *DeviceRegistry) bool {
+	if w.UDID != "" {
+		return w.UDID == udid
+	}
+	return registry.HasLabel(udid, w.Label)
+}
+
+// occurrence returns the occurrence that is active at now or the next one if none is active
+func (w *MaintenanceWindow) occurrence(now time.Time) (MaintenanceOccurrence, bool) {
+	start := w.schedule.next(now.Add(-w.duration))
+	if start.IsZero() {
+		return MaintenanceOccurrence{}, false
+	}
+	return MaintenanceOccurrence{WindowID: w.ID, Start: start, End: start.Add(w.duration), Active: !start.After(now)}, true
+}
+
+// maintenanceScheduler keeps the maintenance windows and runs their tasks when they start
+type maintenanceScheduler struct {
+	mu      sync.Mutex
+	windows map[string]*MaintenanceWindow
+	lastRun map[string]time.Time
+}
+
+var maintenance = newMaintenanceScheduler()
+
+func newMaintenanceScheduler() *maintenanceScheduler {
+	return &maintenanceScheduler{windows: map[string]*MaintenanceWindow{}, lastRun: map[string]time.Time{}}
+}
+
+func (m *maintenanceScheduler) add(w MaintenanceWindow) (MaintenanceWindow, error) {
+	err := w.validate()
+	if err != nil {
+		return MaintenanceWindow{}, err
+	}
+	w.ID = uuid.New().String()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.windows[w.ID] = &w
+	return w, nil
+}
+
+func (m *maintenanceScheduler) remove(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.windows[id]
+	delete(m.windows, id)
+	return ok
+}
+
+func (m *maintenanceScheduler) list() []MaintenanceWindow {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]MaintenanceWindow, 0, len(m.windows))
+	for _, w := range m.windows {
+		result = append(result, *w)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// next returns the active maintenance occurrence for the device or the next upcoming one
+func (m *maintenanceScheduler) next(udid string, registry *DeviceRegistry, now time.Time) (MaintenanceOccurrence, bool) {
+	var result MaintenanceOccurrence
+	found := false
+	for _, w := range m.list() {
+		if !w.appliesTo(udid, registry) {
+			continue
+		}
+		occurrence, ok := w.occurrence(now)
+		if !ok {
+			continue
+		}
+		if !found || occurrence.Start.Before(result.Start) {
+			result = occurrence
+			found = true
+		}
+	}
+	return result, found
+}
+
+// active returns the currently active maintenance occurrence of the device, if there is one
+func (m *maintenanceScheduler) active(udid string) (MaintenanceOccurrence, bool) {
+	occurrence, ok := m.next(udid, devices, time.Now())
+	return occurrence, ok && occurrence.Active
+}
+
+// run starts the tasks of maintenance windows once per occurrence and device until ctx is done
+func (m *maintenanceScheduler) run(ctx context.Context, registry *DeviceRegistry) {
+	ticker := time.NewTicker(maintenanceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		for _, w := range m.list() {
+			window := w
+			if len(window.Tasks) == 0 {
+				continue
+			}
+			occurrence, ok := window.occurrence(now)
+			if !ok || !occurrence.Active {
+				continue
+			}
+			registry.Range(func(device ios.DeviceEntry) bool {
+				udid := device.Properties.SerialNumber
+				if !window.appliesTo(udid, registry) {
+					return true
+				}
+				key := window.ID + "/" + udid
+				m.mu.Lock()
+				alreadyRun := m.lastRun[key].Equal(occurrence.Start)
+				m.lastRun[key] = occurrence.Start
+				m.mu.Unlock()
+				if !alreadyRun {
+					go runMaintenanceTasks(window, device, registry)
+				}
+				return true
+			})
+		}
+	}
+}
+
+func runMaintenanceTasks(window MaintenanceWindow, device ios.DeviceEntry, registry *DeviceRegistry) {
+	unlock := registry.LockDevice(device.Properties.SerialNumber)
+	defer unlock()
+	for _, task := range window.Tasks {
+		logger := log.WithFields(log.Fields{"udid": device.Properties.SerialNumber, "window": window.ID, "task": task})
+		logger.Info("running maintenance task")
+		err := maintenanceTasks[task](device)
+		if err != nil {
+			logger.WithError(err).Warn("maintenance task failed")
+		}
+	}
+}
+
+// CreateMaintenanceWindow creates a maintenance window
+// @Summary      Create a maintenance window
+// @Description  Creates a recurring maintenance window for a device or all devices with a label. While the window is active, devices can't be acquired and the configured tasks (cleanup, reboot, repair, update) run when it starts. update installs the apps and applies the settings of the golden state, devices that need an os update get an os-update-required event.
+// @Tags         maintenance
+// @Accept       json
+// @Produce      json
+// @Param        window body MaintenanceWindow true "Maintenance window"
+// @Success      201  {object}  MaintenanceWindow
+// @Failure      422  {object}  GenericResponse
+// @Router       /maintenance/windows [post]
+func CreateMaintenanceWindow(c *gin.Context) {
+	var window MaintenanceWindow
+	err := c.ShouldBindJSON(&window)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	window, err = maintenance.add(window)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, window)
+}
+
+// ListMaintenanceWindows lists all maintenance windows
+// @Summary      List maintenance windows
+// @Tags         maintenance
+// @Produce      json
+// @Success      200  {object}  []MaintenanceWindow
+// @Router       /maintenance/windows [get]
+func ListMaintenanceWindows(c *gin.Context) {
+	c.JSON(http.StatusOK, maintenance.list())
+}
+
+// DeleteMaintenanceWindow deletes a maintenance window
+// @Summary      Delete a maintenance window
+// @Tags         maintenance
+// @Produce      json
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Param        id path string true "Maintenance window id"
+// @Router       /maintenance/windows/{id} [delete]
+func DeleteMaintenanceWindow(c *gin.Context) {
+	if !maintenance.remove(c.Param("id")) {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "maintenance window not found"})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "maintenance window deleted"})
+}
+
+// NextMaintenance returns the active or next maintenance window of a device
+// @Summary      Get the next maintenance window of a device
+// @Description  Returns the currently active maintenance window of the device or the next upcoming one.
+// @Tags         maintenance
+// @Produce      json
+// @Success      200  {object}  MaintenanceOccurrence
+// @Failure      404  {object}  GenericResponse
+// @Param        udid path string true "Device UDID"
+// @Router       /device/{udid}/maintenance [get]
+func NextMaintenance(c *gin.Context) {
+	occurrence, ok := maintenance.next(MustGetDevice(c).Properties.SerialNumber, devices, time.Now())
+	if !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "no maintenance window scheduled"})
+		return
+	}
+	c.JSON(http.StatusOK, occurrence)
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceUpdateTask(t *testing.T) {
+	defer func(h *deviceHistory) { history = h }(history)
+	history = newDeviceHistory()
+	devices.Put(testDevice("update-a"))
+	defer devices.Remove("update-a")
+	devices.SetLabels("update-a", []string{"lab"})
+	defer func(store *goldenStateStore) { goldenStates = store }(goldenStates)
+	goldenStates = &goldenStateStore{states: map[string]GoldenState{}}
+	require.NoError(t, goldenStates.set([]GoldenState{{Label: "lab", MinOSVersion: "18", Apps: []GoldenApp{{BundleID: "com.example.app", Artifact: strings.Repeat("ab", 32)}}}}))
+	defer func(lookup func(ios.DeviceEntry) deviceSnapshot) { snapshotLookup = lookup }(snapshotLookup)
+	snapshotLookup = func(device ios.DeviceEntry) deviceSnapshot {
+		return deviceSnapshot{lockdown: map[string]interface{}{"ProductVersion": "17.2"}, apps: map[string]interface{}{}}
+	}
+
+	window := MaintenanceWindow{Label: "lab", Cron: "0 3 * * *", Duration: "1h", Tasks: []string{"cleanup", "update"}}
+	require.NoError(t, window.validate())
+	err := maintenanceTasks["update"](testDevice("update-a"))
+	assert.ErrorContains(t, err, "app com.example.app", "the app artifact is not in the store")
+	recorded := history.between("update-a", time.Time{}, time.Now().Add(time.Minute))
+	require.Len(t, recorded, 1)
+	assert.Equal(t, events.OSUpdateRequired, recorded[0].Type)
+	assert.Equal(t, "os version 17.2, the golden state of lab needs minOsVersion 18", recorded[0].Message)
+
+	snapshotLookup = func(device ios.DeviceEntry) deviceSnapshot {
+		return deviceSnapshot{lockdown: map[string]interface{}{"ProductVersion": "18.1"}, apps: map[string]interface{}{"com.example.app": "1.0 (1)"}}
+	}
+	assert.NoError(t, maintenanceTasks["update"](testDevice("update-a")), "nothing to update")
+	assert.Len(t, history.between("update-a", time.Time{}, time.Now().Add(time.Minute)), 1)
+}
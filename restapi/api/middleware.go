@@ -1,6 +1,7 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"sync"
@@ -10,39 +11,127 @@ import (
 )
 
 // DeviceMiddleware makes sure a udid was specified and that a device with that UDID
-// is connected with the host. Will return 404 if the device is not found or 500 if something
-// else went wrong. Use `device := c.MustGet(IOS_KEY).(ios.DeviceEntry)` to acquire the device
-// in downstream handlers.
+// is known to the host. Devices are resolved from the device registry first and from usbmuxd if
+// the registry does not know them yet, iOS 17+ devices get the services of their tunnel. Will return 404 if the device is not found or 500 if something
+// else went wrong. Use `device := MustGetDevice(c)` to acquire the device in downstream handlers.
 func DeviceMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		udid := c.Param("udid")
 
 		if udid == "" {
-			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"message": "udid is missing"})
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, GenericResponse{Error: "udid is missing"})
+			return
+		}
+		device, found, err := findDevice(udid)
+		if !found {
+			c.AbortWithStatusJSON(http.StatusNotFound, GenericResponse{Error: "device not found on the host"})
 			return
 		}
-		device, err := ios.GetDevice(udid)
 		if err != nil {
-			if strings.Contains(err.Error(), "not found") {
-				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "device not found on the host"})
-				return
-			}
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
 			return
 		}
 		c.Set(IOS_KEY, device)
+		removeOwner := connectionOwners.add(device.DeviceID, c.Request.Method+" "+c.Request.URL.Path)
+		defer removeOwner()
+		c.Next()
+	}
+}
+
+// findDevice resolves udid from the device registry first and from usbmuxd if the registry does not know it yet,
+// iOS 17+ devices get the services of their tunnel. found is false if the host does not know the device.
+func findDevice(udid string) (device ios.DeviceEntry, found bool, err error) {
+	device, ok := devices.Get(udid)
+	if !ok {
+		device, err = ios.GetDevice(udid)
+		if err != nil {
+			return ios.DeviceEntry{}, !strings.Contains(err.Error(), "not found"), err
+		}
+	}
+	return deviceTunnels.withTunnel(device), true, nil
+}
+
+// DeviceReachableMiddleware makes sure lockdown of the device in the context accepts connections.
+// Will return 409 if the device can't be reached, which happens f.ex. while it is rebooting.
+// Needs to run after DeviceMiddleware.
+func DeviceReachableMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := deviceReachable(MustGetDevice(c))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusConflict, GenericResponse{Error: "device not reachable: " + err.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// deviceReachable and readPairRecord check devices in the middlewares, a DeviceSimulation replaces them
+var (
+	deviceReachable = checkReachable
+	readPairRecord  = ios.ReadPairRecord
+)
+
+// checkReachable opens and closes a lockdown connection to the device. Devices with a tunnel
+// are checked by connecting to the remote lockdown service, all others through usbmuxd.
+func checkReachable(device ios.DeviceEntry) error {
+	if device.SupportsRsd() {
+		conn, err := ios.ConnectTUNDevice(device.Address, device.Rsd.GetPort(remoteLockdownService), device)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	muxConn, err := ios.NewUsbMuxConnectionSimple()
+	if err != nil {
+		return err
+	}
+	lockdown, err := muxConn.ConnectLockdown(device.DeviceID)
+	if err != nil {
+		return err
+	}
+	lockdown.Close()
+	return nil
+}
+
+// DevicePairedMiddleware makes sure the host has a pair record for the device in the context.
+// Will return 409 if the device is not paired, use the pair endpoint to pair it.
+// Needs to run after DeviceMiddleware.
+func DevicePairedMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		device := MustGetDevice(c)
+		_, err := readPairRecord(device.Properties.SerialNumber)
+		if err != nil {
+			if pairings.get(device.Properties.SerialNumber).Valid {
+				// the pair record was removed since it was checked last
+				pairings.failed(device, fmt.Errorf("%w: %v", ios.ErrPairingInvalid, err))
+			}
+			c.AbortWithStatusJSON(http.StatusConflict, GenericResponse{Error: "device is not paired with the host"})
+			return
+		}
 		c.Next()
+		if c.Writer.Status() == http.StatusConflict {
+			// errorStatus maps ios.ErrPairingInvalid to 409, check whether the device still accepts the pair record
+			go pairings.check(device)
+		}
 	}
 }
 
-const IOS_KEY = "go_ios_device"
+// MustGetDevice returns the device DeviceMiddleware stored in the context and panics if there is none
+func MustGetDevice(c *gin.Context) ios.DeviceEntry {
+	return c.MustGet(IOS_KEY).(ios.DeviceEntry)
+}
+
+const (
+	IOS_KEY               = "go_ios_device"
+	remoteLockdownService = "com.apple.mobile.lockdown.remote.trusted"
+)
 
 // LimitNumClientsUDID limits clients to one concurrent connection per device UDID at a time
 func LimitNumClientsUDID() gin.HandlerFunc {
 	maxClients := 1
 	semaMap := sync.Map{}
 	return func(c *gin.Context) {
-		device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+		device := MustGetDevice(c)
 		udid := device.Properties.SerialNumber
 		var sema chan struct{}
 		semaIntf, ok := semaMap.Load(udid)
@@ -55,7 +144,6 @@ func LimitNumClientsUDID() gin.HandlerFunc {
 		sema <- struct{}{}
 		defer func() { <-sema }()
 		c.Next()
-		print("mid done")
 	}
 }
 
@@ -0,0 +1,511 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/diagnostics"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/danielpaulus/go-ios/ios/syslog"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// monitoringEnvVar holds the MonitoringConfig as json
+	monitoringEnvVar = "GO_IOS_MONITORING"
+	// monitorTick is how often the monitor checks which devices are due for collection
+	monitorTick = time.Second
+
+	monitoringDefaultIdleInterval   = 5 * time.Minute
+	monitoringDefaultActiveInterval = 5 * time.Second
+	// monitoringSyslogLines is how many syslog messages of the running job are kept per device
+	monitoringSyslogLines = 500
+	// monitoringTopProcesses is how many processes of a sysmontap sample are kept, the ones using the most cpu
+	monitoringTopProcesses = 10
+)
+
+// MonitoringMode is how closely a device is monitored
+type MonitoringMode string
+
+const (
+	// MonitoringIdle polls the battery of a device without jobs in the idle interval
+	MonitoringIdle = MonitoringMode("idle")
+	// MonitoringActive collects processes, syslog and screenshots in the active interval while a job runs
+	MonitoringActive = MonitoringMode("active")
+)
+
+// the collectors of the active mode
+const (
+	collectSysmontap   = "sysmontap"
+	collectSyslog      = "syslog"
+	collectScreenshots = "screenshots"
+)
+
+var monitoringCollectors = []string{collectSysmontap, collectSyslog, collectScreenshots}
+
+// MonitoringConfig sets how often devices are monitored. Idle devices only have their battery polled, devices
+// running a v2 job or an xcuitest session are monitored with the collectors in the active interval.
+type MonitoringConfig struct {
+	IdleIntervalSeconds   int `json:"idleIntervalSeconds"`
+	ActiveIntervalSeconds int `json:"activeIntervalSeconds"`
+	// Collectors are sysmontap, syslog and screenshots, all of them are used if it is missing
+	Collectors []string `json:"collectors"`
+}
+
+func defaultMonitoringConfig() MonitoringConfig {
+	return MonitoringConfig{
+		IdleIntervalSeconds:   int(monitoringDefaultIdleInterval / time.Second),
+		ActiveIntervalSeconds: int(monitoringDefaultActiveInterval / time.Second),
+		Collectors:            monitoringCollectors,
+	}
+}
+
+// normalize fills in the defaults and validates the config
+func (c *MonitoringConfig) normalize() error {
+	defaults := defaultMonitoringConfig()
+	if c.IdleIntervalSeconds == 0 {
+		c.IdleIntervalSeconds = defaults.IdleIntervalSeconds
+	}
+	if c.ActiveIntervalSeconds == 0 {
+		c.ActiveIntervalSeconds = defaults.ActiveIntervalSeconds
+	}
+	if c.Collectors == nil {
+		c.Collectors = defaults.Collectors
+	}
+	if c.ActiveIntervalSeconds < 1 {
+		return errors.New("activeIntervalSeconds must be at least 1")
+	}
+	if c.IdleIntervalSeconds < c.ActiveIntervalSeconds {
+		return errors.New("idleIntervalSeconds must not be shorter than activeIntervalSeconds")
+	}
+	for _, collector := range c.Collectors {
+		if !containsString(monitoringCollectors, collector) {
+			return fmt.Errorf("unknown collector %q, use one of %s", collector, strings.Join(monitoringCollectors, ", "))
+		}
+	}
+	return nil
+}
+
+func (c MonitoringConfig) interval(mode MonitoringMode) time.Duration {
+	if mode == MonitoringActive {
+		return time.Duration(c.ActiveIntervalSeconds) * time.Second
+	}
+	return time.Duration(c.IdleIntervalSeconds) * time.Second
+}
+
+func (c MonitoringConfig) collects(collector string) bool {
+	return containsString(c.Collectors, collector)
+}
+
+// MonitoringState is what the monitor collected last of a device
+type MonitoringState struct {
+	UDID      string         `json:"udid"`
+	Mode      MonitoringMode `json:"mode"`
+	ModeSince time.Time      `json:"modeSince"`
+	// Jobs are the v2 jobs and xcuitest sessions running on the device
+	Jobs          []string             `json:"jobs,omitempty"`
+	LastCollected time.Time            `json:"lastCollected,omitempty"`
+	Error         string               `json:"error,omitempty"`
+	Battery       *diagnostics.Battery `json:"battery,omitempty"`
+	// Processes are the processes using the most cpu in the last sysmontap sample
+	Processes []instruments.ProcessSample `json:"processes,omitempty"`
+	// Syslog are the last messages since the device became active
+	Syslog       []syslog.Message `json:"syslog,omitempty"`
+	ScreenshotAt time.Time        `json:"screenshotAt,omitempty"`
+}
+
+type deviceMonitor struct {
+	state      MonitoringState
+	png        []byte
+	collecting bool
+	stopSyslog context.CancelFunc
+}
+
+// monitor collects the state of all devices, rarely while they are idle and in detail while they run jobs,
+// so large fleets are not loaded by collection nobody needs
+type monitor struct {
+	mu      sync.Mutex
+	config  MonitoringConfig
+	devices map[string]*deviceMonitor
+	now     func() time.Time
+}
+
+var monitors = newMonitor()
+
+// sampleProcesses, monitorScreenshot and openSyslog access the device for the active mode, tests replace them
+var (
+	sampleProcesses   = instruments.SampleProcesses
+	monitorScreenshot = captureScreen
+	openSyslog        = func(device ios.DeviceEntry) (syslogReader, error) {
+		return syslog.New(device)
+	}
+)
+
+func newMonitor() *monitor {
+	return &monitor{config: defaultMonitoringConfig(), devices: map[string]*deviceMonitor{}, now: time.Now}
+}
+
+func (m *monitor) getConfig() MonitoringConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config
+}
+
+func (m *monitor) setConfig(config MonitoringConfig) (MonitoringConfig, error) {
+	err := config.normalize()
+	if err != nil {
+		return MonitoringConfig{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.config = config
+	return config, nil
+}
+
+func (m *monitor) deviceLocked(udid string) *deviceMonitor {
+	d, ok := m.devices[udid]
+	if !ok {
+		d = &deviceMonitor{state: MonitoringState{UDID: udid, Mode: MonitoringIdle, ModeSince: m.now()}}
+		m.devices[udid] = d
+	}
+	return d
+}
+
+// begin switches the device to the active mode until the returned function is called. Several jobs can run
+// at the same time, the device becomes idle once all of them ended.
+func (m *monitor) begin(udid string, job string) func() {
+	m.mu.Lock()
+	d := m.deviceLocked(udid)
+	d.state.Jobs = append(d.state.Jobs, job)
+	m.updateModeLocked(d)
+	m.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			for i, running := range d.state.Jobs {
+				if running == job {
+					d.state.Jobs = append(d.state.Jobs[:i:i], d.state.Jobs[i+1:]...)
+					break
+				}
+			}
+			m.updateModeLocked(d)
+		})
+	}
+}
+
+func (m *monitor) updateModeLocked(d *deviceMonitor) {
+	mode := MonitoringIdle
+	if len(d.state.Jobs) > 0 {
+		mode = MonitoringActive
+	}
+	if mode == d.state.Mode {
+		return
+	}
+	log.WithFields(log.Fields{"udid": d.state.UDID, "mode": mode}).Info("monitoring mode changed")
+	d.state.Mode = mode
+	d.state.ModeSince = m.now()
+	// the next tick collects in the new mode right away
+	d.state.LastCollected = time.Time{}
+	if mode == MonitoringActive {
+		d.state.Syslog = nil
+	}
+}
+
+func (m *monitor) get(udid string) (MonitoringState, []byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.devices[udid]
+	if !ok {
+		return MonitoringState{}, nil, false
+	}
+	state := d.state
+	state.Jobs = append([]string(nil), d.state.Jobs...)
+	state.Syslog = append([]syslog.Message(nil), d.state.Syslog...)
+	return state, d.png, true
+}
+
+// list returns the state of all devices without their syslog, sorted by udid
+func (m *monitor) list() []MonitoringState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := []MonitoringState{}
+	for _, d := range m.devices {
+		state := d.state
+		state.Jobs = append([]string(nil), d.state.Jobs...)
+		state.Syslog = nil
+		result = append(result, state)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UDID < result[j].UDID })
+	return result
+}
+
+// run collects the state of the devices in the registry until ctx is done
+func (m *monitor) run(ctx context.Context, registry *DeviceRegistry) {
+	ticker := time.NewTicker(monitorTick)
+	defer ticker.Stop()
+	for {
+		connected := map[string]bool{}
+		registry.Range(func(device ios.DeviceEntry) bool {
+			connected[device.Properties.SerialNumber] = true
+			m.check(ctx, device)
+			return true
+		})
+		m.prune(connected)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check follows the syslog of active devices and starts collecting if the device is due
+func (m *monitor) check(ctx context.Context, device ios.DeviceEntry) {
+	udid := device.Properties.SerialNumber
+	if _, ok := maintenance.active(udid); ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.deviceLocked(udid)
+	active := d.state.Mode == MonitoringActive
+	followsSyslog := active && m.config.collects(collectSyslog)
+	if followsSyslog && d.stopSyslog == nil {
+		syslogCtx, stop := context.WithCancel(ctx)
+		d.stopSyslog = stop
+		go m.followSyslog(syslogCtx, device)
+	}
+	if !followsSyslog && d.stopSyslog != nil {
+		d.stopSyslog()
+		d.stopSyslog = nil
+	}
+	if d.collecting || m.now().Sub(d.state.LastCollected) < m.config.interval(d.state.Mode) {
+		return
+	}
+	d.collecting = true
+	go m.collect(ctx, device, d.state.Mode, m.config)
+}
+
+// prune forgets idle devices that are not connected anymore
+func (m *monitor) prune(connected map[string]bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for udid, d := range m.devices {
+		if connected[udid] || len(d.state.Jobs) > 0 || d.collecting {
+			continue
+		}
+		if d.stopSyslog != nil {
+			d.stopSyslog()
+		}
+		delete(m.devices, udid)
+	}
+}
+
+// collect polls the battery, and in the active mode samples the processes and takes a screenshot
+func (m *monitor) collect(ctx context.Context, device ios.DeviceEntry, mode MonitoringMode, config MonitoringConfig) {
+	udid := device.Properties.SerialNumber
+	var errs []string
+	battery, err := monitorBattery(device)
+	if err != nil {
+		errs = append(errs, "battery: "+err.Error())
+	}
+	var processes []instruments.ProcessSample
+	var png []byte
+	if mode == MonitoringActive && config.collects(collectSysmontap) {
+		sampleCtx, cancel := context.WithTimeout(ctx, processSampleTimeout)
+		processes, err = sampleProcesses(sampleCtx, device)
+		cancel()
+		if err != nil {
+			errs = append(errs, "sysmontap: "+err.Error())
+		}
+		sort.SliceStable(processes, func(i, j int) bool { return processes[i].CPUUsage > processes[j].CPUUsage })
+		if len(processes) > monitoringTopProcesses {
+			processes = processes[:monitoringTopProcesses]
+		}
+	}
+	if mode == MonitoringActive && config.collects(collectScreenshots) {
+		png, err = monitorScreenshot(device)
+		if err != nil {
+			errs = append(errs, "screenshot: "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		log.WithField("udid", udid).WithField("errors", errs).Debug("monitoring failed")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d := m.deviceLocked(udid)
+	d.collecting = false
+	d.state.LastCollected = m.now()
+	d.state.Error = strings.Join(errs, "; ")
+	if battery != nil {
+		d.state.Battery = battery
+	}
+	if processes != nil {
+		d.state.Processes = processes
+	}
+	if png != nil {
+		d.png = png
+		d.state.ScreenshotAt = d.state.LastCollected
+	}
+}
+
+// monitorBattery queries the battery and shares it with the cache of the battery endpoint
+func monitorBattery(device ios.DeviceEntry) (*diagnostics.Battery, error) {
+	value, _, err := diagnosticsValues.get(device.Properties.SerialNumber+"/battery", 0, func() (interface{}, error) {
+		return queryDiagnostics(device, func(conn *diagnostics.Connection) (interface{}, error) {
+			return conn.Battery()
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	battery := value.(diagnostics.Battery)
+	return &battery, nil
+}
+
+// followSyslog keeps the last monitoringSyslogLines messages of the device until ctx is done
+func (m *monitor) followSyslog(ctx context.Context, device ios.DeviceEntry) {
+	udid := device.Properties.SerialNumber
+	conn, err := openSyslog(device)
+	if err != nil {
+		// the syslog is not retried before the next job starts
+		log.WithField("udid", udid).WithError(err).Warn("monitoring failed following the syslog")
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	for {
+		line, err := conn.ReadLogMessage()
+		if err != nil {
+			return
+		}
+		msg, ok := syslog.Parse(line)
+		if !ok {
+			msg = syslog.Message{Text: strings.TrimRight(line, "\x00\n")}
+		}
+		m.mu.Lock()
+		d := m.deviceLocked(udid)
+		d.state.Syslog = append(d.state.Syslog, msg)
+		if len(d.state.Syslog) > monitoringSyslogLines {
+			d.state.Syslog = d.state.Syslog[len(d.state.Syslog)-monitoringSyslogLines:]
+		}
+		m.mu.Unlock()
+	}
+}
+
+// loadMonitoringConfig reads the monitoring config from GO_IOS_MONITORING
+func loadMonitoringConfig() {
+	config := os.Getenv(monitoringEnvVar)
+	if config == "" {
+		return
+	}
+	var monitoring MonitoringConfig
+	err := json.Unmarshal([]byte(config), &monitoring)
+	if err == nil {
+		_, err = monitors.setConfig(monitoring)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("ignoring invalid %s", monitoringEnvVar)
+	}
+}
+
+// List the monitoring state of all devices
+// @Summary      List the monitoring state of all devices
+// @Description  Returns the mode, running jobs, battery and top processes of every device, without the syslog
+// @Tags         monitoring
+// @Produce      json
+// @Success      200  {object}  []MonitoringState
+// @Router       /monitoring [get]
+func ListMonitoring(c *gin.Context) {
+	c.JSON(http.StatusOK, monitors.list())
+}
+
+// Get the monitoring state of a device
+// @Summary      Get the monitoring state of a device
+// @Description  Returns what was collected last. Idle devices only have their battery polled, devices running a v2 job or an xcuitest session also have their processes sampled with sysmontap, their syslog followed and screenshots taken.
+// @Tags         monitoring
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200  {object}  MonitoringState
+// @Failure      404  {object}  GenericResponse
+// @Router       /device/{udid}/monitoring [get]
+func GetMonitoring(c *gin.Context) {
+	state, _, ok := monitors.get(c.Param("udid"))
+	if !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "device is not monitored yet"})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// Get the last monitoring screenshot of a device
+// @Summary      Get the last monitoring screenshot of a device
+// @Description  Returns the screenshot taken last while the device ran a job
+// @Tags         monitoring
+// @Produce      png
+// @Param        udid path string true "Device UDID"
+// @Success      200  {file}    file
+// @Failure      404  {object}  GenericResponse
+// @Router       /device/{udid}/monitoring/screenshot [get]
+func GetMonitoringScreenshot(c *gin.Context) {
+	state, png, ok := monitors.get(c.Param("udid"))
+	if !ok || png == nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "no screenshot was taken yet"})
+		return
+	}
+	c.Header("Last-Modified", state.ScreenshotAt.UTC().Format(http.TimeFormat))
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// Get the monitoring config
+// @Summary      Get the monitoring config
+// @Description  Returns the intervals of the idle and active mode and the collectors of the active mode
+// @Tags         monitoring
+// @Produce      json
+// @Success      200  {object}  MonitoringConfig
+// @Router       /config/monitoring [get]
+func GetMonitoringConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, monitors.getConfig())
+}
+
+// Change the monitoring config
+// @Summary      Change the monitoring config
+// @Description  Sets the intervals of the idle and active mode in seconds, 300 and 5 by default, and the collectors of the active mode: sysmontap, syslog and screenshots. Needs the admin token.
+// @Tags         monitoring
+// @Accept       json
+// @Produce      json
+// @Param        config body MonitoringConfig true "Monitoring config"
+// @Success      200  {object}  MonitoringConfig
+// @Failure      401  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /config/monitoring [put]
+func SetMonitoringConfig(c *gin.Context) {
+	var config MonitoringConfig
+	err := c.ShouldBindJSON(&config)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	config, err = monitors.setConfig(config)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, config)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/diagnostics"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeMonitoring(t *testing.T, reader *fakeSyslog) {
+	originalQuery, originalSample, originalScreenshot, originalSyslog := queryDiagnostics, sampleProcesses, monitorScreenshot, openSyslog
+	t.Cleanup(func() {
+		queryDiagnostics, sampleProcesses, monitorScreenshot, openSyslog = originalQuery, originalSample, originalScreenshot, originalSyslog
+	})
+	queryDiagnostics = func(device ios.DeviceEntry, query func(*diagnostics.Connection) (interface{}, error)) (interface{}, error) {
+		return diagnostics.Battery{CurrentCapacity: 80}, nil
+	}
+	sampleProcesses = func(ctx context.Context, device ios.DeviceEntry) ([]instruments.ProcessSample, error) {
+		var processes []instruments.ProcessSample
+		for pid := 1; pid <= 12; pid++ {
+			processes = append(processes, instruments.ProcessSample{Pid: uint64(pid), Name: fmt.Sprintf("process%d", pid), CPUUsage: float64(pid)})
+		}
+		return processes, nil
+	}
+	monitorScreenshot = func(device ios.DeviceEntry) ([]byte, error) {
+		return []byte("png"), nil
+	}
+	openSyslog = func(device ios.DeviceEntry) (syslogReader, error) {
+		return reader, nil
+	}
+}
+
+// collectNow makes the device due and waits until the monitor collected it
+func collectNow(t *testing.T, m *monitor, device ios.DeviceEntry) MonitoringState {
+	m.check(context.Background(), device)
+	var state MonitoringState
+	require.Eventually(t, func() bool {
+		state, _, _ = m.get(device.Properties.SerialNumber)
+		return !state.LastCollected.IsZero()
+	}, 5*time.Second, 10*time.Millisecond)
+	return state
+}
+
+func TestMonitoringSwitchesModeWithJobs(t *testing.T) {
+	reader := newFakeSyslog()
+	fakeMonitoring(t, reader)
+	m := newMonitor()
+	device := testDevice("monitoring-udid")
+
+	state := collectNow(t, m, device)
+	assert.Equal(t, MonitoringIdle, state.Mode)
+	assert.Equal(t, 80, state.Battery.CurrentCapacity)
+	assert.Empty(t, state.Processes, "idle devices are only polled")
+	m.check(context.Background(), device)
+	state, _, _ = m.get("monitoring-udid")
+	assert.False(t, state.LastCollected.IsZero(), "idle devices are not due again before the idle interval")
+
+	end := m.begin("monitoring-udid", "install 1")
+	endTest := m.begin("monitoring-udid", "xcuitest 2")
+	state = collectNow(t, m, device)
+	assert.Equal(t, MonitoringActive, state.Mode)
+	assert.Equal(t, []string{"install 1", "xcuitest 2"}, state.Jobs)
+	require.Len(t, state.Processes, monitoringTopProcesses)
+	assert.Equal(t, "process12", state.Processes[0].Name)
+	_, png, _ := m.get("monitoring-udid")
+	assert.Equal(t, []byte("png"), png)
+	reader.lines <- "garbage\x00"
+	require.Eventually(t, func() bool {
+		state, _, _ = m.get("monitoring-udid")
+		return len(state.Syslog) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "garbage", state.Syslog[0].Text)
+
+	end()
+	end()
+	state, _, _ = m.get("monitoring-udid")
+	assert.Equal(t, MonitoringActive, state.Mode, "the xcuitest session still runs")
+	endTest()
+	m.check(context.Background(), device)
+	select {
+	case <-reader.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("syslog was not closed when the device became idle")
+	}
+	state = collectNow(t, m, device)
+	assert.Equal(t, MonitoringIdle, state.Mode)
+	assert.Empty(t, state.Jobs)
+}
+
+func TestSetMonitoringConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := monitors
+	monitors = newMonitor()
+	defer func() { monitors = original }()
+	r := gin.New()
+	r.PUT("/config/monitoring", SetMonitoringConfig)
+	put := func(body string) (int, string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/monitoring", strings.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	code, body := put(`{"activeIntervalSeconds": 2}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"idleIntervalSeconds": 300, "activeIntervalSeconds": 2, "collectors": ["sysmontap", "syslog", "screenshots"]}`, body)
+	code, _ = put(`{"idleIntervalSeconds": 1, "activeIntervalSeconds": 2}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	code, _ = put(`{"collectors": ["pcap"]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	code, _ = put(`{"collectors": []}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, monitors.getConfig().Collectors)
+	assert.Equal(t, 2*time.Second, MonitoringConfig{ActiveIntervalSeconds: 2}.interval(MonitoringActive))
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/gin-gonic/gin"
+)
+
+// networkConfigProfileIdentifier is the identifier of the per-app VPN or DNS proxy profile managed by the REST API
+const networkConfigProfileIdentifier = "Go-iOS.NetworkConfig.8C3D5F1A-6B2E-4A9D-B7F0-1E4C2D8A9B57"
+
+// NetworkConfigRequest describes a per-app VPN or DNS proxy configuration.
+// Type is "ikev2" for a VPN to an IKEv2 gateway, "provider" for a VPN provided by the network extension
+// ProviderBundleID or "dnsproxy" for the DNS proxy extension ProviderBundleID of the app AppBundleID.
+type NetworkConfigRequest struct {
+	Type                  string                 `json:"type"`
+	Name                  string                 `json:"name"`
+	BundleIDs             []string               `json:"bundleIds"`
+	RemoteAddress         string                 `json:"remoteAddress"`
+	RemoteIdentifier      string                 `json:"remoteIdentifier"`
+	SharedSecret          string                 `json:"sharedSecret"`
+	AppBundleID           string                 `json:"appBundleId"`
+	ProviderBundleID      string                 `json:"providerBundleId"`
+	ProviderConfiguration map[string]interface{} `json:"providerConfiguration"`
+}
+
+// NetworkConfigStatus tells whether the network configuration profile is installed and active on the device
+type NetworkConfigStatus struct {
+	Identifier string `json:"identifier"`
+	Installed  bool   `json:"installed"`
+	Active     bool   `json:"active"`
+	Status     string `json:"status,omitempty"`
+}
+
+func (r NetworkConfigRequest) profile() (mcinstall.ConfigurationProfile, error) {
+	name := r.Name
+	if name == "" {
+		name = "go-ios"
+	}
+	switch r.Type {
+	case "ikev2", "provider":
+		if len(r.BundleIDs) == 0 {
+			return mcinstall.ConfigurationProfile{}, fmt.Errorf("bundleIds are required for a per-app VPN")
+		}
+		if r.RemoteAddress == "" {
+			return mcinstall.ConfigurationProfile{}, fmt.Errorf("remoteAddress is required for a per-app VPN")
+		}
+		var vpn mcinstall.PerAppVPNPayload
+		if r.Type == "ikev2" {
+			vpn = mcinstall.NewIKEv2PerAppVPNPayload(name, r.RemoteAddress, r.RemoteIdentifier, r.SharedSecret)
+		} else {
+			if r.ProviderBundleID == "" {
+				return mcinstall.ConfigurationProfile{}, fmt.Errorf("providerBundleId is required for a provider VPN")
+			}
+			vpn = mcinstall.NewProviderPerAppVPNPayload(name, r.ProviderBundleID, r.RemoteAddress, r.ProviderConfiguration)
+		}
+		mapping := mcinstall.NewAppMappingPayload(vpn, r.BundleIDs...)
+		return mcinstall.NewConfigurationProfile(networkConfigProfileIdentifier, "Go-iOS Per-App VPN", vpn, mapping), nil
+	case "dnsproxy":
+		if r.AppBundleID == "" || r.ProviderBundleID == "" {
+			return mcinstall.ConfigurationProfile{}, fmt.Errorf("appBundleId and providerBundleId are required for a DNS proxy")
+		}
+		dnsProxy := mcinstall.NewDNSProxyPayload(r.AppBundleID, r.ProviderBundleID, r.ProviderConfiguration)
+		return mcinstall.NewConfigurationProfile(networkConfigProfileIdentifier, "Go-iOS DNS Proxy", dnsProxy), nil
+	}
+	return mcinstall.ConfigurationProfile{}, fmt.Errorf("type must be ikev2, provider or dnsproxy")
+}
+
+func networkConfigStatus(device ios.DeviceEntry) (NetworkConfigStatus, error) {
+	profile, installed, err := mcinstall.ProfileStatus(device, networkConfigProfileIdentifier)
+	if err != nil {
+		return NetworkConfigStatus{}, err
+	}
+	return NetworkConfigStatus{
+		Identifier: networkConfigProfileIdentifier,
+		Installed:  installed,
+		Active:     installed && profile.Manifest.IsActive,
+		Status:     profile.Status,
+	}, nil
+}
+
+// Get the network configuration status of a device
+// @Summary      Get network configuration status
+// @Description  Returns whether the per-app VPN or DNS proxy profile deployed with PUT /device/{udid}/network-config is installed and active.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200 {object} NetworkConfigStatus
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/network-config [get]
+func GetNetworkConfig(c *gin.Context) {
+	status, err := networkConfigStatus(MustGetDevice(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// Deploy a per-app VPN or DNS proxy configuration
+// @Summary      Deploy network configuration
+// @Description  Installs a per-app VPN bound to the given bundle ids or a DNS proxy on a supervised device, so traffic of test apps goes through lab gateways. It replaces the configuration deployed before and verifies the profile is installed and active afterwards. Needs the supervision identity configured with GO_IOS_SUPERVISION_P12.
+// @Tags         general_device_specific
+// @Accept       json
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        config body NetworkConfigRequest true "Network configuration"
+// @Success      200 {object} NetworkConfigStatus
+// @Failure      412 {object} GenericResponse
+// @Failure      422 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/network-config [put]
+func SetNetworkConfig(c *gin.Context) {
+	device := MustGetDevice(c)
+	var request NetworkConfigRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	profile, err := request.profile()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	p12, password, err := supervisionIdentity()
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, GenericResponse{Error: err.Error()})
+		return
+	}
+	err = mcinstall.InstallProfileSilent(device, p12, password, profile.Bytes())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	status, err := networkConfigStatus(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: "profile installed but verification failed: " + err.Error()})
+		return
+	}
+	if !status.Active {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: "profile installed but it is not active on the device"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// Remove the network configuration of a device
+// @Summary      Remove network configuration
+// @Description  Removes the per-app VPN or DNS proxy profile deployed with PUT /device/{udid}/network-config.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/network-config [delete]
+func RemoveNetworkConfig(c *gin.Context) {
+	err := mcinstall.RemoveProfileFromDevice(MustGetDevice(c), networkConfigProfileIdentifier)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "network configuration removed"})
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkConfigProfile(t *testing.T) {
+	profile, err := NetworkConfigRequest{Type: "ikev2", BundleIDs: []string{"com.example.app"}, RemoteAddress: "vpn.lab.local"}.profile()
+	require.NoError(t, err)
+	assert.Equal(t, networkConfigProfileIdentifier, profile.PayloadIdentifier)
+	require.Len(t, profile.PayloadContent, 2)
+	vpn := profile.PayloadContent[0].(mcinstall.PerAppVPNPayload)
+	mapping := profile.PayloadContent[1].(mcinstall.AppMappingPayload)
+	assert.Equal(t, vpn.VPNUUID, mapping.AppLayerVPNMapping[0].VPNUUID)
+
+	profile, err = NetworkConfigRequest{Type: "dnsproxy", AppBundleID: "com.example.dns", ProviderBundleID: "com.example.dns.proxy"}.profile()
+	require.NoError(t, err)
+	assert.IsType(t, mcinstall.DNSProxyPayload{}, profile.PayloadContent[0])
+
+	invalid := []NetworkConfigRequest{
+		{Type: "ikev2", RemoteAddress: "vpn.lab.local"},
+		{Type: "ikev2", BundleIDs: []string{"com.example.app"}},
+		{Type: "provider", BundleIDs: []string{"com.example.app"}, RemoteAddress: "vpn.lab.local"},
+		{Type: "dnsproxy", AppBundleID: "com.example.dns"},
+		{Type: "pptp"},
+	}
+	for _, request := range invalid {
+		_, err := request.profile()
+		assert.Error(t, err, request.Type)
+	}
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ocrEnvVar selects the OCR backend: "tesseract" runs the tesseract command, an http(s) URL posts the png
+// screenshot to an OCR service that responds with a json list of TextBlock. Defaults to tesseract.
+const ocrEnvVar = "GO_IOS_OCR"
+
+// ocrTimeout is how long recognizing the text of a screenshot may take
+const ocrTimeout = time.Minute
+
+// TextBlock is recognized text and where it is on the screen, in pixels of the screenshot
+type TextBlock struct {
+	Text   string `json:"text"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Confidence is between 0 and 100
+	Confidence float64     `json:"confidence"`
+	Words      []TextBlock `json:"words,omitempty"`
+}
+
+// OCRBackend recognizes the text of a png image
+type OCRBackend interface {
+	Recognize(ctx context.Context, png []byte) ([]TextBlock, error)
+}
+
+// ScreenText is the text recognized on the screen of a device
+type ScreenText struct {
+	// Lines are the recognized lines of text, each with its words
+	Lines []TextBlock `json:"lines"`
+	Text  string      `json:"text"`
+	// Found is set if the contains query param was given and tells whether the text is on the screen
+	Found *bool `json:"found,omitempty"`
+	// Matches are the lines containing the text of the contains query param
+	Matches []TextBlock `json:"matches,omitempty"`
+}
+
+var (
+	ocrMu      sync.Mutex
+	ocrBackend OCRBackend
+)
+
+// SetOCRBackend replaces the OCR backend, f.ex. with a cloud OCR service by programs embedding the REST API
+func SetOCRBackend(backend OCRBackend) {
+	ocrMu.Lock()
+	defer ocrMu.Unlock()
+	ocrBackend = backend
+}
+
+// currentOCRBackend returns the backend set with SetOCRBackend or the one configured with GO_IOS_OCR
+func currentOCRBackend() OCRBackend {
+	ocrMu.Lock()
+	defer ocrMu.Unlock()
+	if ocrBackend != nil {
+		return ocrBackend
+	}
+	config := os.Getenv(ocrEnvVar)
+	if strings.HasPrefix(config, "http://") || strings.HasPrefix(config, "https://") {
+		return httpOCR{url: config, client: &http.Client{Timeout: ocrTimeout}}
+	}
+	return tesseractOCR{command: "tesseract"}
+}
+
+// tesseractOCR runs the tesseract command, it has to be installed on the host
+type tesseractOCR struct {
+	command string
+}
+
+func (t tesseractOCR) Recognize(ctx context.Context, png []byte) ([]TextBlock, error) {
+	path, err := exec.LookPath(t.command)
+	if err != nil {
+		return nil, fmt.Errorf("tesseract is not installed, install it or set %s to an OCR service: %w", ocrEnvVar, err)
+	}
+	cmd := exec.CommandContext(ctx, path, "stdin", "stdout", "tsv")
+	cmd.Stdin = bytes.NewReader(png)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("tesseract failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseTesseractTSV(out)
+}
+
+// parseTesseractTSV groups the words of tesseract tsv output into lines
+func parseTesseractTSV(tsv []byte) ([]TextBlock, error) {
+	reader := csv.NewReader(bytes.NewReader(tsv))
+	reader.Comma = '\t'
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parseTesseractTSV: %w", err)
+	}
+	lines := []TextBlock{}
+	index := map[string]int{}
+	for i, record := range records {
+		// level page block paragraph line word left top width height confidence text
+		if i == 0 || len(record) < 12 || record[0] != "5" || strings.TrimSpace(record[11]) == "" {
+			continue
+		}
+		values := make([]int, 4)
+		for j := range values {
+			values[j], err = strconv.Atoi(record[6+j])
+			if err != nil {
+				return nil, fmt.Errorf("parseTesseractTSV: invalid box in line %d", i+1)
+			}
+		}
+		confidence, _ := strconv.ParseFloat(record[10], 64)
+		word := TextBlock{Text: strings.TrimSpace(record[11]), X: values[0], Y: values[1], Width: values[2], Height: values[3], Confidence: confidence}
+		key := strings.Join(record[1:5], "/")
+		n, ok := index[key]
+		if !ok {
+			index[key] = len(lines)
+			lines = append(lines, TextBlock{})
+			n = len(lines) - 1
+		}
+		lines[n].Words = append(lines[n].Words, word)
+	}
+	for i := range lines {
+		lines[i] = joinWords(lines[i].Words)
+	}
+	return lines, nil
+}
+
+// joinWords returns a line with the text, the bounding box and the mean confidence of the words
+func joinWords(words []TextBlock) TextBlock {
+	line := TextBlock{X: words[0].X, Y: words[0].Y, Words: words}
+	right, bottom := words[0].X+words[0].Width, words[0].Y+words[0].Height
+	texts := make([]string, len(words))
+	for i, word := range words {
+		texts[i] = word.Text
+		line.Confidence += word.Confidence / float64(len(words))
+		line.X, line.Y = min(line.X, word.X), min(line.Y, word.Y)
+		right, bottom = max(right, word.X+word.Width), max(bottom, word.Y+word.Height)
+	}
+	line.Text = strings.Join(texts, " ")
+	line.Width, line.Height = right-line.X, bottom-line.Y
+	return line
+}
+
+// httpOCR posts the png to an OCR service
+type httpOCR struct {
+	url    string
+	client *http.Client
+}
+
+func (h httpOCR) Recognize(ctx context.Context, png []byte) ([]TextBlock, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(png))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "image/png")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OCR service failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("OCR service returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var blocks []TextBlock
+	err = json.NewDecoder(resp.Body).Decode(&blocks)
+	if err != nil {
+		return nil, fmt.Errorf("OCR service returned invalid json: %w", err)
+	}
+	return blocks, nil
+}
+
+// screenText filters the lines by confidence and searches them for contains, case insensitive
+func screenText(lines []TextBlock, minConfidence float64, contains string) ScreenText {
+	result := ScreenText{Lines: []TextBlock{}}
+	texts := []string{}
+	for _, line := range lines {
+		if line.Confidence < minConfidence {
+			continue
+		}
+		result.Lines = append(result.Lines, line)
+		texts = append(texts, line.Text)
+	}
+	result.Text = strings.Join(texts, "\n")
+	if contains == "" {
+		return result
+	}
+	needle := strings.ToLower(contains)
+	for _, line := range result.Lines {
+		if strings.Contains(strings.ToLower(line.Text), needle) {
+			result.Matches = append(result.Matches, line)
+		}
+	}
+	found := len(result.Matches) > 0
+	result.Found = &found
+	return result
+}
+
+// GetScreenText recognizes the text on the screen
+// @Summary      Get the text on the screen
+// @Description  Takes a screenshot and returns the recognized lines of text with their bounding boxes in pixels. With contains, found tells whether the text is on the screen, case insensitive, and matches has the lines containing it. The OCR backend is the tesseract command or the OCR service at the URL in GO_IOS_OCR.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        contains query string false "text to look for"
+// @Param        min_confidence query number false "ignore lines with a lower confidence, from 0 to 100, defaults to 0"
+// @Success      200  {object}  ScreenText
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Failure      503  {object}  GenericResponse
+// @Router       /device/{udid}/screen/text [get]
+func GetScreenText(c *gin.Context) {
+	minConfidence := 0.0
+	if value := c.Query("min_confidence"); value != "" {
+		var err error
+		minConfidence, err = strconv.ParseFloat(value, 64)
+		if err != nil || minConfidence < 0 || minConfidence > 100 {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "min_confidence must be a number from 0 to 100"})
+			return
+		}
+	}
+	device := MustGetDevice(c)
+	screenshot, err := captureScreen(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), ocrTimeout)
+	defer cancel()
+	lines, err := currentOCRBackend().Recognize(ctx, screenshot)
+	if errors.Is(err, exec.ErrNotFound) {
+		c.JSON(http.StatusServiceUnavailable, GenericResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, screenText(lines, minConfidence, c.Query("contains")))
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const tesseractTSV = "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n" +
+	"1\t1\t0\t0\t0\t0\t0\t0\t1170\t2532\t-1\t\n" +
+	"4\t1\t1\t1\t1\t0\t100\t200\t300\t40\t-1\t\n" +
+	"5\t1\t1\t1\t1\t1\t100\t200\t120\t40\t96.5\tSign\n" +
+	"5\t1\t1\t1\t1\t2\t240\t205\t160\t35\t91.5\tin\n" +
+	"5\t1\t2\t1\t1\t1\t50\t900\t200\t30\t40\tSettings\n" +
+	"5\t1\t2\t1\t1\t2\t260\t900\t10\t30\t10\t \n"
+
+func TestParseTesseractTSV(t *testing.T) {
+	lines, err := parseTesseractTSV([]byte(tesseractTSV))
+	require.NoError(t, err)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "Sign in", lines[0].Text)
+	assert.Equal(t, 100, lines[0].X)
+	assert.Equal(t, 200, lines[0].Y)
+	assert.Equal(t, 300, lines[0].Width)
+	assert.Equal(t, 40, lines[0].Height)
+	assert.Equal(t, 94.0, lines[0].Confidence)
+	assert.Len(t, lines[0].Words, 2)
+	assert.Equal(t, "Settings", lines[1].Text)
+}
+
+func TestScreenText(t *testing.T) {
+	lines, err := parseTesseractTSV([]byte(tesseractTSV))
+	require.NoError(t, err)
+
+	result := screenText(lines, 0, "")
+	assert.Equal(t, "Sign in\nSettings", result.Text)
+	assert.Nil(t, result.Found)
+
+	result = screenText(lines, 50, "SETTINGS")
+	assert.Len(t, result.Lines, 1)
+	require.NotNil(t, result.Found)
+	assert.False(t, *result.Found, "the settings line is below the confidence")
+
+	result = screenText(lines, 0, "sign")
+	assert.True(t, *result.Found)
+	assert.Equal(t, "Sign in", result.Matches[0].Text)
+}
+
+func TestHTTPOCR(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "png", string(body))
+		assert.Equal(t, "image/png", r.Header.Get("Content-Type"))
+		w.Write([]byte(`[{"text":"Hello","x":1,"y":2,"width":3,"height":4,"confidence":99}]`))
+	}))
+	defer server.Close()
+	t.Setenv(ocrEnvVar, server.URL)
+
+	blocks, err := currentOCRBackend().Recognize(context.Background(), []byte("png"))
+	require.NoError(t, err)
+	assert.Equal(t, []TextBlock{{Text: "Hello", X: 1, Y: 2, Width: 3, Height: 4, Confidence: 99}}, blocks)
+
+	t.Setenv(ocrEnvVar, "")
+	assert.Equal(t, tesseractOCR{command: "tesseract"}, currentOCRBackend())
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// pairingCheckInterval is how often the pair records of all devices are validated
+	pairingCheckInterval = time.Hour
+	// pairRecordRenewBefore is how long before its certificates expire a pair record is replaced
+	pairRecordRenewBefore = 30 * 24 * time.Hour
+)
+
+// pairingValidate checks the pair record of a device and returns when it expires, tests replace it
+var pairingValidate = ios.ValidatePairing
+
+// pairWithHost and pairWithHostSupervised pair a device with the host, a DeviceSimulation replaces them
+var (
+	pairWithHost           = ios.Pair
+	pairWithHostSupervised = ios.PairSupervised
+)
+
+// pairDevice pairs a device again, supervised if the supervision identity is configured. Tests replace it.
+var pairDevice = func(device ios.DeviceEntry) (bool, error) {
+	p12, password, err := supervisionIdentity()
+	if err != nil {
+		return false, pairWithHost(device)
+	}
+	return true, pairWithHostSupervised(device, p12, password)
+}
+
+// PairingStatus is the result of the latest pair record check and re-pair of a device
+type PairingStatus struct {
+	UDID      string    `json:"udid"`
+	Valid     bool      `json:"valid"`
+	Expires   time.Time `json:"expires,omitempty"`
+	CheckedAt time.Time `json:"checkedAt,omitempty"`
+	// Error of the latest check or re-pair
+	Error      string    `json:"error,omitempty"`
+	RepairedAt time.Time `json:"repairedAt,omitempty"`
+	Repairs    int       `json:"repairs"`
+	Repairing  bool      `json:"repairing"`
+}
+
+// pairingWatcher validates pair records and pairs devices again if their pair record was invalidated, f.ex. by
+// an os update, or is about to expire
+type pairingWatcher struct {
+	mu     sync.Mutex
+	status map[string]*PairingStatus
+}
+
+var pairings = &pairingWatcher{status: map[string]*PairingStatus{}}
+
+func (p *pairingWatcher) get(udid string) PairingStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status, ok := p.status[udid]
+	if !ok {
+		return PairingStatus{UDID: udid}
+	}
+	return *status
+}
+
+func (p *pairingWatcher) update(udid string, f func(status *PairingStatus)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status, ok := p.status[udid]
+	if !ok {
+		status = &PairingStatus{UDID: udid}
+		p.status[udid] = status
+	}
+	f(status)
+}
+
+// check validates the pair record of the device and pairs it again if it is invalid or expires soon.
+// Devices that can't be checked, f.ex. because they are locked, keep their pair record.
+func (p *pairingWatcher) check(device ios.DeviceEntry) error {
+	udid := device.Properties.SerialNumber
+	expires, err := pairingValidate(device)
+	p.update(udid, func(status *PairingStatus) {
+		status.CheckedAt = time.Now()
+		status.Valid = err == nil
+		status.Expires = expires
+		status.Error = ""
+		if err != nil {
+			status.Error = err.Error()
+		}
+	})
+	if errors.Is(err, ios.ErrPairingInvalid) {
+		return p.repair(device, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	if time.Until(expires) < pairRecordRenewBefore {
+		return p.repair(device, fmt.Sprintf("pair record expires %s", expires.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// repair pairs the device again, only one re-pair per device runs at a time
+func (p *pairingWatcher) repair(device ios.DeviceEntry, reason string) error {
+	udid := device.Properties.SerialNumber
+	busy := false
+	p.update(udid, func(status *PairingStatus) {
+		busy = status.Repairing
+		status.Repairing = true
+	})
+	if busy {
+		return fmt.Errorf("device %s is already being paired again", udid)
+	}
+	logger := log.WithField("udid", udid)
+	logger.Infof("pairing device again: %s", reason)
+	history.record(DeviceEvent{UDID: udid, Type: "pairing-invalid", Message: reason})
+	supervised, err := pairDevice(device)
+	p.update(udid, func(status *PairingStatus) {
+		status.Repairing = false
+		if err != nil {
+			status.Error = err.Error()
+			return
+		}
+		status.Valid = true
+		status.Error = ""
+		status.RepairedAt = time.Now()
+		status.Repairs++
+	})
+	if err != nil {
+		logger.WithError(err).Warn("pairing device again failed")
+		history.record(DeviceEvent{UDID: udid, Type: "repair-failed", Message: err.Error()})
+		return fmt.Errorf("repair: %w", err)
+	}
+	message := "paired again without supervision, the user had to trust the host"
+	if supervised {
+		message = "paired again with the supervision identity"
+	}
+	history.record(DeviceEvent{UDID: udid, Type: "repaired", Message: message})
+	return nil
+}
+
+// failed is called when a request found the pair record of a device invalid, it pairs the device again in the
+// background so the next request works
+func (p *pairingWatcher) failed(device ios.DeviceEntry, err error) {
+	p.update(device.Properties.SerialNumber, func(status *PairingStatus) {
+		status.Valid = false
+		status.Error = err.Error()
+	})
+	go p.repair(device, err.Error())
+}
+
+// run checks the pair records of all devices until ctx is done
+func (p *pairingWatcher) run(ctx context.Context, registry *DeviceRegistry) {
+	ticker := time.NewTicker(pairingCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		registry.Range(func(device ios.DeviceEntry) bool {
+			if err := p.check(device); err != nil {
+				log.WithField("udid", device.Properties.SerialNumber).WithError(err).Debug("pairing check failed")
+			}
+			return true
+		})
+	}
+}
+
+// GetPairingStatus returns the pairing status of a device
+// @Summary      Get the pairing status of a device
+// @Description  Returns the result of the latest pair record check. Pair records are checked every hour, devices whose pair record became invalid, f.ex. after an os update, or expires within 30 days are paired again, supervised if GO_IOS_SUPERVISION_P12 is configured. Use check=true to check now.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        check query bool false "check the pair record now"
+// @Success      200  {object}  PairingStatus
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/pairing [get]
+func GetPairingStatus(c *gin.Context) {
+	device := MustGetDevice(c)
+	if c.Query("check") == "true" {
+		err := pairings.check(device)
+		if err != nil && !errors.Is(err, ios.ErrDeviceLocked) {
+			c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, pairings.get(device.Properties.SerialNumber))
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPairingWatcher(t *testing.T) {
+	defer func(validate func(ios.DeviceEntry) (time.Time, error)) { pairingValidate = validate }(pairingValidate)
+	defer func(pair func(ios.DeviceEntry) (bool, error)) { pairDevice = pair }(pairDevice)
+	defer func(h *deviceHistory) { history = h }(history)
+	history = newDeviceHistory()
+
+	results := map[string]error{
+		"pair-valid":   nil,
+		"pair-invalid": fmt.Errorf("StartSession failed: %w", ios.ErrPairingInvalid),
+		"pair-locked":  ios.ErrDeviceLocked,
+		"pair-expires": nil,
+	}
+	pairingValidate = func(device ios.DeviceEntry) (time.Time, error) {
+		udid := device.Properties.SerialNumber
+		if udid == "pair-expires" {
+			return time.Now().Add(24 * time.Hour), nil
+		}
+		return time.Now().AddDate(10, 0, 0), results[udid]
+	}
+	paired := map[string]int{}
+	pairDevice = func(device ios.DeviceEntry) (bool, error) {
+		paired[device.Properties.SerialNumber]++
+		return true, nil
+	}
+	watcher := &pairingWatcher{status: map[string]*PairingStatus{}}
+
+	require.NoError(t, watcher.check(testDevice("pair-valid")))
+	assert.True(t, watcher.get("pair-valid").Valid)
+
+	require.NoError(t, watcher.check(testDevice("pair-invalid")))
+	status := watcher.get("pair-invalid")
+	assert.True(t, status.Valid)
+	assert.Equal(t, 1, status.Repairs)
+	assert.False(t, status.Repairing)
+
+	require.NoError(t, watcher.check(testDevice("pair-expires")))
+	assert.Equal(t, 1, watcher.get("pair-expires").Repairs)
+
+	assert.ErrorIs(t, watcher.check(testDevice("pair-locked")), ios.ErrDeviceLocked)
+	assert.False(t, watcher.get("pair-locked").Valid)
+
+	assert.Equal(t, map[string]int{"pair-invalid": 1, "pair-expires": 1}, paired)
+	events := history.between("pair-invalid", time.Time{}, time.Now().Add(time.Minute))
+	require.Len(t, events, 2)
+	assert.EqualValues(t, "pairing-invalid", events[0].Type)
+	assert.EqualValues(t, "repaired", events[1].Type)
+	assert.Equal(t, "paired again with the supervision identity", events[1].Message)
+}
+
+func TestPairingWatcherRepairFails(t *testing.T) {
+	defer func(pair func(ios.DeviceEntry) (bool, error)) { pairDevice = pair }(pairDevice)
+	defer func(h *deviceHistory) { history = h }(history)
+	history = newDeviceHistory()
+	pairDevice = func(device ios.DeviceEntry) (bool, error) {
+		return false, errors.New("user denied the trust popup")
+	}
+	watcher := &pairingWatcher{status: map[string]*PairingStatus{}}
+
+	assert.ErrorContains(t, watcher.repair(testDevice("pair-denied"), "scheduled"), "user denied")
+	status := watcher.get("pair-denied")
+	assert.False(t, status.Repairing)
+	assert.Equal(t, 0, status.Repairs)
+	assert.Equal(t, "user denied the trust popup", status.Error)
+	events := history.between("pair-denied", time.Time{}, time.Now().Add(time.Minute))
+	require.Len(t, events, 2)
+	assert.EqualValues(t, "repair-failed", events[1].Type)
+
+	watcher.update("pair-denied", func(status *PairingStatus) { status.Repairing = true })
+	assert.ErrorContains(t, watcher.repair(testDevice("pair-denied"), "scheduled"), "already being paired")
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/gin-gonic/gin"
+)
+
+// parentalControlsProfileIdentifier is the identifier of the content filter and ratings profile managed by the REST API
+const parentalControlsProfileIdentifier = "Go-iOS.ParentalControls.A4E7C2D9-1F3B-4D6A-8E5C-9B0F2A7D3C18"
+
+var appRatings = map[string]int{
+	"none": mcinstall.AppRatingNone,
+	"4+":   mcinstall.AppRating4,
+	"9+":   mcinstall.AppRating9,
+	"12+":  mcinstall.AppRating12,
+	"17+":  mcinstall.AppRating17,
+	"all":  mcinstall.AppRatingAllow,
+}
+
+// ContentFilter configures the web content filter. Type is "builtin" for the iOS filter or "plugin" for the
+// content filter network extension PluginBundleID.
+type ContentFilter struct {
+	Type              string                            `json:"type"`
+	LimitAdultContent bool                              `json:"limitAdultContent"`
+	PermittedURLs     []string                          `json:"permittedUrls"`
+	BlockedURLs       []string                          `json:"blockedUrls"`
+	AllowedWebsites   []mcinstall.ContentFilterBookmark `json:"allowedWebsites"`
+	Name              string                            `json:"name"`
+	PluginBundleID    string                            `json:"pluginBundleId"`
+}
+
+// ParentalControlsRequest configures the content filter and content ratings of a device, like Screen Time's
+// content restrictions do. MaxAppRating is one of none, 4+, 9+, 12+, 17+ or all.
+type ParentalControlsRequest struct {
+	ContentFilter           *ContentFilter `json:"contentFilter"`
+	MaxAppRating            string         `json:"maxAppRating"`
+	RatingRegion            string         `json:"ratingRegion"`
+	DisallowExplicitContent bool           `json:"disallowExplicitContent"`
+}
+
+func (f ContentFilter) payload() (mcinstall.WebContentFilterPayload, error) {
+	switch f.Type {
+	case "", "builtin":
+		filter := mcinstall.NewBuiltInContentFilterPayload().PermitURLs(f.PermittedURLs...).BlockURLs(f.BlockedURLs...).AllowOnly(f.AllowedWebsites...)
+		if f.LimitAdultContent {
+			filter = filter.LimitAdultContent()
+		}
+		return filter, nil
+	case "plugin":
+		if f.PluginBundleID == "" {
+			return mcinstall.WebContentFilterPayload{}, fmt.Errorf("pluginBundleId is required for a plugin content filter")
+		}
+		name := f.Name
+		if name == "" {
+			name = f.PluginBundleID
+		}
+		return mcinstall.NewPluginContentFilterPayload(name, f.PluginBundleID), nil
+	}
+	return mcinstall.WebContentFilterPayload{}, fmt.Errorf("contentFilter type must be builtin or plugin")
+}
+
+func (r ParentalControlsRequest) profile() (mcinstall.ConfigurationProfile, error) {
+	var payloads []interface{}
+	if r.ContentFilter != nil {
+		filter, err := r.ContentFilter.payload()
+		if err != nil {
+			return mcinstall.ConfigurationProfile{}, err
+		}
+		payloads = append(payloads, filter)
+	}
+	if r.MaxAppRating != "" || r.DisallowExplicitContent {
+		restrictions := mcinstall.NewRestrictionsPayload()
+		if r.MaxAppRating != "" {
+			rating, ok := appRatings[r.MaxAppRating]
+			if !ok {
+				return mcinstall.ConfigurationProfile{}, fmt.Errorf("maxAppRating must be one of none, 4+, 9+, 12+, 17+ or all")
+			}
+			region := r.RatingRegion
+			if region == "" {
+				region = "us"
+			}
+			restrictions = restrictions.LimitAppRating(region, rating)
+		}
+		if r.DisallowExplicitContent {
+			restrictions = restrictions.DisallowExplicitContent()
+		}
+		payloads = append(payloads, restrictions)
+	}
+	if len(payloads) == 0 {
+		return mcinstall.ConfigurationProfile{}, fmt.Errorf("nothing to configure, set contentFilter, maxAppRating or disallowExplicitContent")
+	}
+	return mcinstall.NewConfigurationProfile(parentalControlsProfileIdentifier, "Go-iOS Parental Controls", payloads...), nil
+}
+
+// Configure parental controls of a device
+// @Summary      Configure parental controls
+// @Description  Installs a profile with a web content filter and content ratings on a supervised device to test apps that react to parental controls. It replaces the parental controls configured before, DELETE tears them down again. Needs the supervision identity configured with GO_IOS_SUPERVISION_P12.
+// @Tags         general_device_specific
+// @Accept       json
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        controls body ParentalControlsRequest true "Parental controls"
+// @Success      200 {object} GenericResponse
+// @Failure      412 {object} GenericResponse
+// @Failure      422 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/parental-controls [put]
+func SetParentalControls(c *gin.Context) {
+	device := MustGetDevice(c)
+	var request ParentalControlsRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	profile, err := request.profile()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	p12, password, err := supervisionIdentity()
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, GenericResponse{Error: err.Error()})
+		return
+	}
+	err = mcinstall.InstallProfileSilent(device, p12, password, profile.Bytes())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "parental controls configured"})
+}
+
+// Remove parental controls of a device
+// @Summary      Remove parental controls
+// @Description  Removes the content filter and content ratings configured with PUT /device/{udid}/parental-controls.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/parental-controls [delete]
+func RemoveParentalControls(c *gin.Context) {
+	err := mcinstall.RemoveProfileFromDevice(MustGetDevice(c), parentalControlsProfileIdentifier)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "parental controls removed"})
+}
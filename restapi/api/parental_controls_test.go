@@ -0,0 +1,36 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParentalControlsProfile(t *testing.T) {
+	profile, err := ParentalControlsRequest{
+		ContentFilter: &ContentFilter{LimitAdultContent: true, BlockedURLs: []string{"https://blocked.example.com"}},
+		MaxAppRating:  "12+",
+	}.profile()
+	require.NoError(t, err)
+	assert.Equal(t, parentalControlsProfileIdentifier, profile.PayloadIdentifier)
+	require.Len(t, profile.PayloadContent, 2)
+	filter := profile.PayloadContent[0].(mcinstall.WebContentFilterPayload)
+	assert.Equal(t, "BuiltIn", filter.FilterType)
+	assert.True(t, filter.AutoFilterEnabled)
+	restrictions := profile.PayloadContent[1].(mcinstall.RestrictionsPayload)
+	assert.Equal(t, "us", restrictions.RatingRegion)
+	assert.Equal(t, mcinstall.AppRating12, *restrictions.RatingApps)
+
+	invalid := []ParentalControlsRequest{
+		{},
+		{MaxAppRating: "21+"},
+		{ContentFilter: &ContentFilter{Type: "plugin"}},
+		{ContentFilter: &ContentFilter{Type: "dns"}},
+	}
+	for _, request := range invalid {
+		_, err := request.profile()
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,446 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/pcap"
+	"github.com/danielpaulus/go-ios/ios/workspace"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// pcapDefaultMaxFileSizeMB and pcapDefaultMaxFiles limit a capture to 1GB on the host unless requested otherwise
+	pcapDefaultMaxFileSizeMB = 100
+	pcapDefaultMaxFiles      = 10
+	// pcapRetention is how long stopped captures can be downloaded
+	pcapRetention = time.Hour
+)
+
+// PcapRequest configures a network capture, all fields are optional
+type PcapRequest struct {
+	// Filter is a tcpdump style filter like "tcp port 443 and not host 10.0.0.1"
+	Filter string `json:"filter,omitempty"`
+	// Pid and Process only capture the packets of a process, Process matches the start of its name
+	Pid     int32  `json:"pid,omitempty"`
+	Process string `json:"process,omitempty"`
+	// MaxFileSizeMB starts a new file when the current one would grow beyond it, 100 by default
+	MaxFileSizeMB int `json:"maxFileSizeMb,omitempty"`
+	// RotateSeconds starts a new file after this many seconds, files are only rotated by size if it is 0
+	RotateSeconds int `json:"rotateSeconds,omitempty"`
+	// MaxFiles is how many files are kept, the oldest file is deleted when there are more. 10 by default.
+	MaxFiles int `json:"maxFiles,omitempty"`
+}
+
+func (r *PcapRequest) normalize() (*pcap.Filter, error) {
+	if r.MaxFileSizeMB < 0 || r.RotateSeconds < 0 || r.MaxFiles < 0 {
+		return nil, errors.New("maxFileSizeMb, rotateSeconds and maxFiles must not be negative")
+	}
+	if r.MaxFileSizeMB == 0 {
+		r.MaxFileSizeMB = pcapDefaultMaxFileSizeMB
+	}
+	if r.MaxFiles == 0 {
+		r.MaxFiles = pcapDefaultMaxFiles
+	}
+	return pcap.ParseFilter(r.Filter)
+}
+
+// PcapCapture is a network capture of a device
+type PcapCapture struct {
+	ID      string      `json:"id"`
+	UDID    string      `json:"udid"`
+	Request PcapRequest `json:"request"`
+	Started time.Time   `json:"started"`
+	Stopped *time.Time  `json:"stopped,omitempty"`
+	Packets int         `json:"packets"`
+	// Files are the kept files, oldest first. Rotated counts the files deleted because there were too many.
+	Files   []PcapFile `json:"files"`
+	Rotated int        `json:"rotated"`
+	// Error is why capturing stopped if it was not stopped on request
+	Error string `json:"error,omitempty"`
+}
+
+// PcapFile is a file of a capture
+type PcapFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Started time.Time `json:"started"`
+}
+
+// packetSource is implemented by pcap.Capture
+type packetSource interface {
+	Next() (pcap.Packet, error)
+	Close() error
+}
+
+// newPacketSource connects to pcapd, tests replace it
+var newPacketSource = func(device ios.DeviceEntry) (packetSource, error) {
+	return pcap.NewCapture(device)
+}
+
+type pcapCapture struct {
+	info     PcapCapture
+	filter   *pcap.Filter
+	ws       *workspace.Workspace
+	source   packetSource
+	stopping bool
+	done     chan struct{}
+	// file and writer are only used by run
+	file        *os.File
+	writer      *pcap.Writer
+	fileStarted time.Time
+}
+
+// pcapStore runs one capture per device and keeps stopped captures for pcapRetention
+type pcapStore struct {
+	mu       sync.Mutex
+	captures map[string]*pcapCapture
+}
+
+var pcaps = &pcapStore{captures: map[string]*pcapCapture{}}
+
+var errPcapRunning = errors.New("a capture is running on the device already")
+
+func (s *pcapStore) start(device ios.DeviceEntry, request PcapRequest, filter *pcap.Filter) (PcapCapture, error) {
+	udid := device.Properties.SerialNumber
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	if s.runningLocked(udid) != nil {
+		return PcapCapture{}, errPcapRunning
+	}
+	id := uuid.New().String()
+	ws, err := workspace.Default().New("pcap-" + id)
+	if err != nil {
+		return PcapCapture{}, err
+	}
+	source, err := newPacketSource(device)
+	if err != nil {
+		ws.Close()
+		return PcapCapture{}, err
+	}
+	capture := &pcapCapture{
+		info:   PcapCapture{ID: id, UDID: udid, Request: request, Started: time.Now(), Files: []PcapFile{}},
+		filter: filter,
+		ws:     ws,
+		source: source,
+		done:   make(chan struct{}),
+	}
+	err = s.rotateLocked(capture)
+	if err != nil {
+		source.Close()
+		ws.Close()
+		return PcapCapture{}, err
+	}
+	s.captures[id] = capture
+	go s.run(capture)
+	return capture.info, nil
+}
+
+func (s *pcapStore) runningLocked(udid string) *pcapCapture {
+	for _, capture := range s.captures {
+		if capture.info.UDID == udid && capture.info.Stopped == nil {
+			return capture
+		}
+	}
+	return nil
+}
+
+func (s *pcapStore) run(capture *pcapCapture) {
+	defer close(capture.done)
+	logger := log.WithFields(log.Fields{"udid": capture.info.UDID, "pcap": capture.info.ID})
+	request := capture.info.Request
+	maxSize := int64(request.MaxFileSizeMB) * 1024 * 1024
+	rotateAfter := time.Duration(request.RotateSeconds) * time.Second
+	var err error
+	for {
+		var packet pcap.Packet
+		packet, err = capture.source.Next()
+		if err != nil {
+			break
+		}
+		if !packet.MatchesProcess(request.Pid, request.Process) || !capture.filter.Match(packet.Data) {
+			continue
+		}
+		s.mu.Lock()
+		current := capture.info.Files[len(capture.info.Files)-1]
+		recordSize := int64(16 + len(packet.Data))
+		full := current.Size > pcap.FileHeaderSize && current.Size+recordSize > maxSize
+		if full || (rotateAfter > 0 && time.Since(capture.fileStarted) >= rotateAfter) {
+			err = s.rotateLocked(capture)
+		}
+		s.mu.Unlock()
+		if err != nil {
+			break
+		}
+		n, writeErr := capture.writer.WritePacket(packet)
+		s.mu.Lock()
+		capture.info.Files[len(capture.info.Files)-1].Size += int64(n)
+		capture.info.Packets++
+		s.mu.Unlock()
+		if writeErr != nil {
+			err = writeErr
+			break
+		}
+	}
+	capture.source.Close()
+	capture.file.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	capture.info.Stopped = &now
+	if !capture.stopping {
+		capture.info.Error = err.Error()
+		logger.WithError(err).Warn("network capture stopped")
+	}
+}
+
+// rotateLocked closes the current file of the capture, starts the next one and deletes the oldest file if there
+// are more than MaxFiles
+func (s *pcapStore) rotateLocked(capture *pcapCapture) error {
+	if capture.file != nil {
+		capture.file.Close()
+	}
+	name := fmt.Sprintf("%s-%d.pcap", capture.info.UDID, len(capture.info.Files)+capture.info.Rotated+1)
+	file, err := os.Create(capture.ws.Path(name))
+	if err != nil {
+		return err
+	}
+	writer, err := pcap.NewWriter(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	capture.file, capture.writer, capture.fileStarted = file, writer, time.Now()
+	capture.info.Files = append(capture.info.Files, PcapFile{Name: name, Size: pcap.FileHeaderSize, Started: capture.fileStarted})
+	for len(capture.info.Files) > capture.info.Request.MaxFiles {
+		err := os.Remove(capture.ws.Path(capture.info.Files[0].Name))
+		if err != nil {
+			log.WithError(err).Warn("failed deleting rotated pcap file")
+		}
+		capture.info.Files = capture.info.Files[1:]
+		capture.info.Rotated++
+	}
+	return nil
+}
+
+// stop stops the running capture of the device and waits until its files are complete
+func (s *pcapStore) stop(udid string) (PcapCapture, bool) {
+	s.mu.Lock()
+	capture := s.runningLocked(udid)
+	if capture == nil {
+		s.mu.Unlock()
+		return PcapCapture{}, false
+	}
+	capture.stopping = true
+	capture.source.Close()
+	s.mu.Unlock()
+	<-capture.done
+	return s.get(udid, capture.info.ID)
+}
+
+func (s *pcapStore) get(udid string, id string) (PcapCapture, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	capture, ok := s.captures[id]
+	if !ok || capture.info.UDID != udid {
+		return PcapCapture{}, false
+	}
+	info := capture.info
+	info.Files = append([]PcapFile{}, info.Files...)
+	return info, true
+}
+
+func (s *pcapStore) list(udid string) []PcapCapture {
+	s.mu.Lock()
+	s.pruneLocked(time.Now())
+	var ids []string
+	for id, capture := range s.captures {
+		if capture.info.UDID == udid {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.Unlock()
+	result := []PcapCapture{}
+	for _, id := range ids {
+		if info, ok := s.get(udid, id); ok {
+			result = append(result, info)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Started.Before(result[j].Started) })
+	return result
+}
+
+// open opens the kept files of the capture with their current size. Open files can still be read after rotation
+// deleted them.
+func (s *pcapStore) open(udid string, id string) ([]*os.File, []int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	capture, ok := s.captures[id]
+	if !ok || capture.info.UDID != udid {
+		return nil, nil, false, nil
+	}
+	var files []*os.File
+	var sizes []int64
+	for _, f := range capture.info.Files {
+		file, err := os.Open(capture.ws.Path(f.Name))
+		if err != nil {
+			for _, opened := range files {
+				opened.Close()
+			}
+			return nil, nil, true, err
+		}
+		files = append(files, file)
+		sizes = append(sizes, f.Size)
+	}
+	return files, sizes, true, nil
+}
+
+func (s *pcapStore) pruneLocked(now time.Time) {
+	for id, capture := range s.captures {
+		if capture.info.Stopped != nil && now.Sub(*capture.info.Stopped) > pcapRetention {
+			capture.ws.Close()
+			delete(s.captures, id)
+		}
+	}
+}
+
+// writePcap writes the files as one pcap file, the packet records of all files follow a single file header
+func writePcap(w io.Writer, files []*os.File, sizes []int64) error {
+	for i, file := range files {
+		offset := int64(pcap.FileHeaderSize)
+		if i == 0 {
+			offset = 0
+		}
+		if sizes[i] <= offset {
+			continue
+		}
+		_, err := io.Copy(w, io.NewSectionReader(file, offset, sizes[i]-offset))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartPcap starts a network capture
+// @Summary      Start capturing network traffic
+// @Description  Captures the network traffic of all interfaces of the device with pcapd on the host. Packets can be filtered with a tcpdump style filter, by pid or process name. The capture is split into files of maxFileSizeMb, and optionally every rotateSeconds, of which the newest maxFiles are kept, so long captures don't fill the disk. Only one capture can run per device, stopped captures can be downloaded for an hour.
+// @Tags         pcap
+// @Accept       json
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        request body PcapRequest false "filter and rotation"
+// @Success      200  {object}  PcapCapture
+// @Failure      409  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/pcap/start [post]
+func StartPcap(c *gin.Context) {
+	device := MustGetDevice(c)
+	var request PcapRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+			return
+		}
+	}
+	filter, err := request.normalize()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	capture, err := pcaps.start(device, request, filter)
+	if errors.Is(err, errPcapRunning) {
+		c.JSON(http.StatusConflict, GenericResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	udid := device.Properties.SerialNumber
+	ci.created(udid, "pcap", capture.ID, func() error {
+		pcaps.stop(udid)
+		return nil
+	})
+	c.JSON(http.StatusOK, capture)
+}
+
+// StopPcap stops the network capture
+// @Summary      Stop capturing network traffic
+// @Description  Stops the running capture of the device, its files can be downloaded afterwards.
+// @Tags         pcap
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200  {object}  PcapCapture
+// @Failure      404  {object}  GenericResponse
+// @Router       /device/{udid}/pcap/stop [post]
+func StopPcap(c *gin.Context) {
+	udid := MustGetDevice(c).Properties.SerialNumber
+	capture, ok := pcaps.stop(udid)
+	if !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "no capture is running"})
+		return
+	}
+	ci.removed(udid, "pcap", capture.ID)
+	c.JSON(http.StatusOK, capture)
+}
+
+// ListPcaps lists the network captures
+// @Summary      List the network captures of a device
+// @Description  Lists the running capture and the stopped captures of the last hour.
+// @Tags         pcap
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200  {object}  []PcapCapture
+// @Router       /device/{udid}/pcap [get]
+func ListPcaps(c *gin.Context) {
+	c.JSON(http.StatusOK, pcaps.list(MustGetDevice(c).Properties.SerialNumber))
+}
+
+// DownloadPcap downloads a network capture
+// @Summary      Download a network capture
+// @Description  Returns the kept files of the capture as one pcap file for Wireshark or tcpdump. Running captures can be downloaded, the file has the packets captured until then.
+// @Tags         pcap
+// @Produce      application/vnd.tcpdump.pcap
+// @Param        udid path string true "Device UDID"
+// @Param        id path string true "Capture id"
+// @Success      200
+// @Failure      404  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/pcap/{id}/download [get]
+func DownloadPcap(c *gin.Context) {
+	udid := MustGetDevice(c).Properties.SerialNumber
+	files, sizes, found, err := pcaps.open(udid, c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "capture not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	c.Header("Content-Disposition", `attachment; filename="`+filepath.Base(udid+"-"+c.Param("id"))+`.pcap"`)
+	c.Header("Content-Type", "application/vnd.tcpdump.pcap")
+	c.Status(http.StatusOK)
+	err = writePcap(c.Writer, files, sizes)
+	if err != nil {
+		log.WithError(err).WithField("pcap", c.Param("id")).Warn("failed sending capture")
+	}
+}
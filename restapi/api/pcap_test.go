@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/pcap"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePacketSource struct {
+	packets chan pcap.Packet
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (s *fakePacketSource) Next() (pcap.Packet, error) {
+	select {
+	case p := <-s.packets:
+		return p, nil
+	case <-s.closed:
+		return pcap.Packet{}, errors.New("connection closed")
+	}
+}
+
+func (s *fakePacketSource) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func TestPcap(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := newPacketSource
+	t.Cleanup(func() { newPacketSource = original })
+	source := &fakePacketSource{packets: make(chan pcap.Packet), closed: make(chan struct{})}
+	newPacketSource = func(device ios.DeviceEntry) (packetSource, error) {
+		return source, nil
+	}
+
+	r := gin.New()
+	device := r.Group("/device/:udid", func(c *gin.Context) {
+		c.Set(IOS_KEY, testDevice(c.Param("udid")))
+		c.Next()
+	})
+	simpleDeviceRoutes(device)
+	do := func(method string, path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnprocessableEntity, do(http.MethodPost, "/device/pcap-udid/pcap/start", `{"filter":"port https"}`).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/device/pcap-udid/pcap/stop", "").Code)
+
+	w := do(http.MethodPost, "/device/pcap-udid/pcap/start", `{"process":"Example","maxFileSizeMb":1,"maxFiles":2}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var capture PcapCapture
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &capture))
+	assert.Len(t, capture.Files, 1)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/device/pcap-udid/pcap/start", "").Code)
+
+	// every packet fills more than half of a file, so each one starts a new file
+	big := bytes.Repeat([]byte{0xab}, 600*1024)
+	for i := 0; i < 4; i++ {
+		source.packets <- pcap.Packet{Header: pcap.IOSPacketHeader{ProcName: "SpringBoard"}, Data: []byte{1}}
+		source.packets <- pcap.Packet{Header: pcap.IOSPacketHeader{ProcName: "Example", TsSec: i}, Data: big}
+	}
+	require.Eventually(t, func() bool {
+		info, _ := pcaps.get("pcap-udid", capture.ID)
+		return info.Packets == 4
+	}, 5*time.Second, time.Millisecond)
+
+	w = do(http.MethodPost, "/device/pcap-udid/pcap/stop", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &capture))
+	assert.NotNil(t, capture.Stopped)
+	assert.Empty(t, capture.Error)
+	assert.Len(t, capture.Files, 2)
+	assert.Equal(t, 2, capture.Rotated)
+
+	w = do(http.MethodGet, "/device/pcap-udid/pcap", "")
+	var captures []PcapCapture
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &captures))
+	require.Len(t, captures, 1)
+	assert.Equal(t, capture.ID, captures[0].ID)
+
+	w = do(http.MethodGet, "/device/pcap-udid/pcap/"+capture.ID+"/download", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/vnd.tcpdump.pcap", w.Header().Get("Content-Type"))
+	record := int64(16 + len(big))
+	assert.Equal(t, pcap.FileHeaderSize+2*record, int64(w.Body.Len()), "the kept files are merged below one header")
+	assert.Equal(t, byte(2), w.Body.Bytes()[pcap.FileHeaderSize], "the oldest files were rotated away")
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/device/other-udid/pcap/"+capture.ID+"/download", "").Code)
+
+	pcaps.mu.Lock()
+	pcaps.pruneLocked(time.Now().Add(2 * pcapRetention))
+	pcaps.mu.Unlock()
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/device/pcap-udid/pcap/"+capture.ID+"/download", "").Code)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/instruments/perfmon"
+	"github.com/gin-gonic/gin"
+)
+
+// perfMinInterval is the shortest interval between perf samples, the device samples graphics in tenths of a second
+const perfMinInterval = 100 * time.Millisecond
+
+// perfMonitor is implemented by perfmon.Monitor
+type perfMonitor interface {
+	Next(ctx context.Context) (perfmon.Sample, error)
+	Close() error
+}
+
+// newPerfMonitor starts sampling the performance of a process, tests replace it
+var newPerfMonitor = func(device ios.DeviceEntry, config perfmon.Config) (perfMonitor, error) {
+	return perfmon.New(device, config)
+}
+
+// leakSampler is implemented by perfmon.LeakSampler
+type leakSampler interface {
+	Stop(thresholds perfmon.LeakThresholds) (perfmon.LeakReport, error)
+}
+
+// startLeakSampling samples the memory footprint of an app for leak detection, tests replace it
+var startLeakSampling = func(device ios.DeviceEntry, bundleID string) (leakSampler, error) {
+	return perfmon.StartLeakSampling(device, perfmon.Config{BundleID: bundleID})
+}
+
+// perfConfig reads the bundleId, process, interval and graphics query params
+func perfConfig(c *gin.Context) (perfmon.Config, error) {
+	config := perfmon.Config{
+		BundleID:   c.Query("bundleId"),
+		Process:    c.Query("process"),
+		Interval:   perfmon.DefaultInterval,
+		NoGraphics: c.Query("graphics") == "false",
+	}
+	if config.BundleID == "" && config.Process == "" {
+		return perfmon.Config{}, errors.New("bundleId or process query param is missing")
+	}
+	if interval := c.Query("interval"); interval != "" {
+		var err error
+		config.Interval, err = time.ParseDuration(interval)
+		if err != nil {
+			return perfmon.Config{}, err
+		}
+		if config.Interval < perfMinInterval {
+			return perfmon.Config{}, errors.New("interval must be at least 100ms")
+		}
+	}
+	return config, nil
+}
+
+// StreamPerf streams performance samples of an app
+// @Summary      Stream cpu, memory, fps and gpu samples of an app
+// @Description  Samples the app with instruments every interval and streams the samples as json objects separated by line breaks until the client goes away. Cpu and memory are per process, the pid is 0 while the app is not running. Fps and gpu utilization are system wide. Needs a mounted developer disk image.
+// @Tags         processes
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        bundleId query string false "bundle id of the app to sample"
+// @Param        process query string false "name of the process to sample if bundleId is missing, f.ex. SpringBoard"
+// @Param        interval query string false "time between samples, f.ex. 500ms, 1s by default"
+// @Param        graphics query bool false "sample fps and gpu utilization, true by default"
+// @Success      200  {object}  perfmon.Sample
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/perf [get]
+func StreamPerf(c *gin.Context) {
+	config, err := perfConfig(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	device := MustGetDevice(c)
+	monitor, err := newPerfMonitor(device, config)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer monitor.Close()
+	ctx := c.Request.Context()
+	c.Stream(func(w io.Writer) bool {
+		sample, err := monitor.Next(ctx)
+		if err != nil {
+			return false
+		}
+		_, err = w.Write([]byte(MustMarshal(sample) + "\n"))
+		return err == nil
+	})
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/instruments/perfmon"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePerfMonitor struct {
+	samples []perfmon.Sample
+	closed  bool
+}
+
+func (f *fakePerfMonitor) Next(ctx context.Context) (perfmon.Sample, error) {
+	if len(f.samples) == 0 {
+		return perfmon.Sample{}, io.EOF
+	}
+	sample := f.samples[0]
+	f.samples = f.samples[1:]
+	return sample, nil
+}
+
+func (f *fakePerfMonitor) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestStreamPerf(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	monitor := &fakePerfMonitor{samples: []perfmon.Sample{{Process: "Example"}, {Pid: 312, Process: "Example", CPUUsage: 12.5}}}
+	var config perfmon.Config
+	original := newPerfMonitor
+	defer func() { newPerfMonitor = original }()
+	newPerfMonitor = func(device ios.DeviceEntry, c perfmon.Config) (perfMonitor, error) {
+		config = c
+		return monitor, nil
+	}
+	r := gin.New()
+	r.GET("/device/:udid/perf", func(c *gin.Context) {
+		c.Set(IOS_KEY, testDevice(c.Param("udid")))
+	}, StreamPerf)
+
+	// streaming needs a real connection, the recorder can't tell when the client goes away
+	server := httptest.NewServer(r)
+	defer server.Close()
+	resp, err := server.Client().Get(server.URL + "/device/perf-udid/perf?bundleId=com.example.app&interval=500ms")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, perfmon.Config{BundleID: "com.example.app", Interval: 500 * time.Millisecond}, config)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	require.Len(t, lines, 2)
+	var sample perfmon.Sample
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &sample))
+	assert.Equal(t, uint64(312), sample.Pid)
+	assert.Equal(t, 12.5, sample.CPUUsage)
+	assert.True(t, monitor.closed)
+
+	for _, query := range []string{"", "?bundleId=com.example.app&interval=10ms", "?process=SpringBoard&interval=often"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/perf-udid/perf"+query, nil))
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, query)
+	}
+}
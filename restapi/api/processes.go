@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// processSampleTimeout is how long listing processes waits for sysmontap to sample their memory
+const processSampleTimeout = 5 * time.Second
+
+// processController launches and kills processes on a device
+type processController interface {
+	StartProcess(bundleID string, envVars map[string]interface{}, arguments []interface{}, options map[string]interface{}) (uint64, error)
+	KillProcess(pid uint64) error
+	Close() error
+}
+
+// newProcessControl connects to the process control of instruments, tests replace it
+var newProcessControl = func(device ios.DeviceEntry) (processController, error) {
+	pControl, err := instruments.NewProcessControl(device)
+	if err != nil {
+		return nil, err
+	}
+	return pControl, nil
+}
+
+// listProcesses lists the processes of a device, tests replace it
+var listProcesses = runningProcesses
+
+// Process is a process running on a device
+type Process struct {
+	Pid           uint64    `json:"pid"`
+	Name          string    `json:"name"`
+	RealAppName   string    `json:"realAppName,omitempty"`
+	IsApplication bool      `json:"isApplication"`
+	StartDate     time.Time `json:"startDate,omitempty"`
+	// PhysFootprint is the memory in bytes the process is charged for, what Xcode shows as memory usage.
+	// It is missing if the memory was not sampled.
+	PhysFootprint uint64 `json:"physFootprint,omitempty"`
+	// MemResidentSize is the resident memory in bytes, it is missing if the memory was not sampled
+	MemResidentSize uint64 `json:"memResidentSize,omitempty"`
+}
+
+// LaunchRequest configures how an app is launched
+type LaunchRequest struct {
+	Env  map[string]string `json:"env"`
+	Args []string          `json:"args"`
+	// KillExisting kills the running process of the app first, otherwise the running app is brought to the foreground
+	KillExisting bool `json:"killExisting"`
+	// StartSuspended starts the process suspended, f.ex. to attach a debugger
+	StartSuspended bool `json:"startSuspended"`
+}
+
+// LaunchResponse is the process of a launched app
+type LaunchResponse struct {
+	BundleID string `json:"bundleId"`
+	Pid      uint64 `json:"pid"`
+}
+
+// runningProcesses lists the processes of a device with their memory sampled by sysmontap. Processes are
+// listed without memory if sampling fails, f.ex. because the device does not answer in time.
+func runningProcesses(ctx context.Context, device ios.DeviceEntry, withMemory bool) ([]Process, error) {
+	service, err := instruments.NewDeviceInfoService(device)
+	if err != nil {
+		return nil, err
+	}
+	defer service.Close()
+	processList, err := service.ProcessList()
+	if err != nil {
+		return nil, err
+	}
+	samples := map[uint64]instruments.ProcessSample{}
+	if withMemory {
+		ctx, cancel := context.WithTimeout(ctx, processSampleTimeout)
+		defer cancel()
+		sampled, err := instruments.SampleProcesses(ctx, device)
+		if err != nil {
+			log.WithField("udid", device.Properties.SerialNumber).WithError(err).Warn("failed sampling the memory of processes")
+		}
+		for _, sample := range sampled {
+			samples[sample.Pid] = sample
+		}
+	}
+	result := make([]Process, len(processList))
+	for i, p := range processList {
+		result[i] = Process{
+			Pid:             p.Pid,
+			Name:            p.Name,
+			RealAppName:     p.RealAppName,
+			IsApplication:   p.IsApplication,
+			StartDate:       p.StartDate,
+			PhysFootprint:   samples[p.Pid].PhysFootprint,
+			MemResidentSize: samples[p.Pid].MemResidentSize,
+		}
+	}
+	return result, nil
+}
+
+// List the processes of a device
+// @Summary      List running processes
+// @Description  Lists the processes running on the device with their pid, name and memory. Memory is sampled with instruments, the device needs a mounted developer disk image. Processes are listed without memory if sampling fails.
+// @Tags         processes
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        memory query bool false "sample the memory of the processes, true by default"
+// @Success      200  {object}  []Process
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/processes [get]
+func ListProcesses(c *gin.Context) {
+	device := MustGetDevice(c)
+	processes, err := listProcesses(c.Request.Context(), device, c.Query("memory") != "false")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, processes)
+}
+
+// Kill a process
+// @Summary      Kill a process
+// @Description  Kills the process with the given pid on the device
+// @Tags         processes
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        pid path int true "Process ID"
+// @Success      200  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/processes/{pid}/kill [post]
+func KillProcess(c *gin.Context) {
+	device := MustGetDevice(c)
+	pid, err := strconv.ParseUint(c.Param("pid"), 10, 64)
+	if err != nil || pid == 0 {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "invalid pid " + c.Param("pid")})
+		return
+	}
+	pControl, err := newProcessControl(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer pControl.Close()
+	err = pControl.KillProcess(pid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "process " + c.Param("pid") + " killed"})
+}
+
+// Launch an app with arguments and environment
+// @Summary      Launch an app
+// @Description  Launches the app with the given environment variables and arguments and returns the pid of its process
+// @Tags         processes
+// @Accept       json
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        bundleID path string true "bundle identifier of the app"
+// @Param        options body LaunchRequest false "environment, arguments and launch options"
+// @Success      200  {object}  LaunchResponse
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/apps/{bundleID}/launch [post]
+func LaunchAppWithOptions(c *gin.Context) {
+	device := MustGetDevice(c)
+	bundleID := c.Param("bundleID")
+	var request LaunchRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+			return
+		}
+	}
+	// NSUnbufferedIO makes apps send their logs through instruments like LaunchApp does
+	env := map[string]interface{}{"NSUnbufferedIO": "YES"}
+	for key, value := range request.Env {
+		env[key] = value
+	}
+	args := make([]interface{}, len(request.Args))
+	for i, arg := range request.Args {
+		args[i] = arg
+	}
+	options := map[string]interface{}{
+		"StartSuspendedKey": boolToUint64(request.StartSuspended),
+		"KillExisting":      boolToUint64(request.KillExisting),
+	}
+
+	pControl, err := newProcessControl(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer pControl.Close()
+	pid, err := pControl.StartProcess(bundleID, env, args, options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, LaunchResponse{BundleID: bundleID, Pid: pid})
+}
+
+func boolToUint64(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProcessControl struct {
+	bundleID string
+	env      map[string]interface{}
+	args     []interface{}
+	options  map[string]interface{}
+	killed   []uint64
+	closed   bool
+}
+
+func (f *fakeProcessControl) StartProcess(bundleID string, envVars map[string]interface{}, arguments []interface{}, options map[string]interface{}) (uint64, error) {
+	f.bundleID, f.env, f.args, f.options = bundleID, envVars, arguments, options
+	return 4711, nil
+}
+
+func (f *fakeProcessControl) KillProcess(pid uint64) error {
+	f.killed = append(f.killed, pid)
+	return nil
+}
+
+func (f *fakeProcessControl) Close() error {
+	f.closed = true
+	return nil
+}
+
+func processRouter(t *testing.T) (*gin.Engine, *fakeProcessControl) {
+	gin.SetMode(gin.TestMode)
+	fake := &fakeProcessControl{}
+	original := newProcessControl
+	newProcessControl = func(device ios.DeviceEntry) (processController, error) { return fake, nil }
+	t.Cleanup(func() { newProcessControl = original })
+	r := gin.New()
+	device := r.Group("/device/:udid", func(c *gin.Context) {
+		c.Set(IOS_KEY, testDevice(c.Param("udid")))
+		c.Next()
+	})
+	device.POST("/apps/:bundleID/launch", LaunchAppWithOptions)
+	device.GET("/processes", ListProcesses)
+	device.POST("/processes/:pid/kill", KillProcess)
+	return r, fake
+}
+
+func TestLaunchAppWithOptions(t *testing.T) {
+	r, fake := processRouter(t)
+	w := httptest.NewRecorder()
+	body := `{"env": {"MOCK_SERVER": "http://10.0.0.2"}, "args": ["-reset"], "killExisting": true}`
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/a/apps/com.example.app/launch", strings.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"bundleId": "com.example.app", "pid": 4711}`, w.Body.String())
+	assert.Equal(t, "com.example.app", fake.bundleID)
+	assert.Equal(t, map[string]interface{}{"NSUnbufferedIO": "YES", "MOCK_SERVER": "http://10.0.0.2"}, fake.env)
+	assert.Equal(t, []interface{}{"-reset"}, fake.args)
+	assert.Equal(t, map[string]interface{}{"StartSuspendedKey": uint64(0), "KillExisting": uint64(1)}, fake.options)
+	assert.True(t, fake.closed)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/a/apps/com.example.app/launch", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "options are optional")
+}
+
+func TestKillProcess(t *testing.T) {
+	r, fake := processRouter(t)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/a/processes/312/kill", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []uint64{312}, fake.killed)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/a/processes/springboard/kill", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestListProcesses(t *testing.T) {
+	r, _ := processRouter(t)
+	defer func(list func(context.Context, ios.DeviceEntry, bool) ([]Process, error)) { listProcesses = list }(listProcesses)
+	var sampled []bool
+	listProcesses = func(ctx context.Context, device ios.DeviceEntry, withMemory bool) ([]Process, error) {
+		sampled = append(sampled, withMemory)
+		return []Process{{Pid: 312, Name: "SpringBoard", PhysFootprint: 52428800}}, nil
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/a/processes", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var processes []Process
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &processes))
+	assert.Equal(t, []Process{{Pid: 312, Name: "SpringBoard", PhysFootprint: 52428800}}, processes)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/a/processes?memory=false", nil))
+	assert.Equal(t, []bool{true, false}, sampled)
+}
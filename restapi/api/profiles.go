@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/gin-gonic/gin"
+)
+
+// maxProfileSize is far more than any mobileconfig needs, it keeps uploads from filling the memory
+const maxProfileSize = 4 << 20
+
+// ProfileInstallResult is the response of a profile install
+type ProfileInstallResult struct {
+	// Identifier is the PayloadIdentifier of the profile, it is empty for signed profiles
+	Identifier string `json:"identifier"`
+	Supervised bool   `json:"supervised"`
+	// Message tells whether the user still has to confirm the install on the device
+	Message string `json:"message"`
+}
+
+// profileIdentifier returns the PayloadIdentifier of a mobileconfig. Signed profiles are CMS messages, which are
+// accepted as they are because only the device can verify them.
+func profileIdentifier(profile []byte) (string, error) {
+	if len(profile) == 0 {
+		return "", fmt.Errorf("the profile is empty")
+	}
+	if profile[0] == 0x30 {
+		return "", nil
+	}
+	parsed, err := ios.ParsePlist(profile)
+	if err != nil {
+		return "", fmt.Errorf("the profile is not a plist or a signed profile: %w", err)
+	}
+	if payloadType, _ := parsed["PayloadType"].(string); payloadType != "Configuration" {
+		return "", fmt.Errorf("the PayloadType of the profile is '%s' instead of 'Configuration'", payloadType)
+	}
+	identifier, _ := parsed["PayloadIdentifier"].(string)
+	if identifier == "" {
+		return "", fmt.Errorf("the profile has no PayloadIdentifier")
+	}
+	return identifier, nil
+}
+
+// formFileBytes reads a multipart file, it returns nil without error if the field is missing
+func formFileBytes(c *gin.Context, field string, maxSize int64) ([]byte, error) {
+	header, err := c.FormFile(field)
+	if err == http.ErrMissingFile {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if header.Size > maxSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", field, maxSize)
+	}
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, io.LimitReader(f, maxSize))
+	return buf.Bytes(), err
+}
+
+// InstallProfile installs a configuration profile
+// @Summary      Install a configuration profile
+// @Description  Installs a .mobileconfig uploaded as multipart field "profile". With a p12file and supervision_password, or supervised=true and the supervision identity configured with GO_IOS_SUPERVISION_P12, the profile is installed silently on supervised devices. Otherwise the user has to confirm the install in the settings app.
+// @Tags         general_device_specific
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        profile formData file true "the .mobileconfig"
+// @Param        p12file formData file false "Supervision *.p12 file"
+// @Param        supervision_password formData string false "Supervision password"
+// @Param        supervised formData bool false "install silently with the configured supervision identity"
+// @Success      200 {object} ProfileInstallResult
+// @Failure      412 {object} GenericResponse
+// @Failure      422 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/profiles [post]
+func InstallProfile(c *gin.Context) {
+	device := MustGetDevice(c)
+	profile, err := formFileBytes(c, "profile", maxProfileSize)
+	if err == nil && profile == nil {
+		err = fmt.Errorf("upload the profile as multipart field 'profile'")
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	identifier, err := profileIdentifier(profile)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	p12, err := formFileBytes(c, "p12file", maxProfileSize)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	password := c.PostForm("supervision_password")
+	if p12 == nil && c.PostForm("supervised") == "true" {
+		p12, password, err = supervisionIdentity()
+		if err != nil {
+			c.JSON(http.StatusPreconditionFailed, GenericResponse{Error: err.Error()})
+			return
+		}
+	}
+
+	removeProfile := func() error { return mcinstall.RemoveProfileFromDevice(device, identifier) }
+	if p12 == nil {
+		err = mcinstall.InstallProfile(device, profile)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+			return
+		}
+		ci.created(device.Properties.SerialNumber, "profile", identifier, removeProfile)
+		c.JSON(http.StatusOK, ProfileInstallResult{Identifier: identifier, Message: "confirm the install on the device in Settings > General > VPN & Device Management"})
+		return
+	}
+	err = mcinstall.InstallProfileSilent(device, p12, password, profile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	ci.created(device.Properties.SerialNumber, "profile", identifier, removeProfile)
+	c.JSON(http.StatusOK, ProfileInstallResult{Identifier: identifier, Supervised: true, Message: "profile installed"})
+}
+
+// RemoveProfile removes a configuration profile
+// @Summary      Remove a configuration profile
+// @Description  Removes the configuration profile with the identifier, as listed by GET /device/{udid}/profiles.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        identifier path string true "profile identifier"
+// @Success      200 {object} GenericResponse
+// @Failure      404 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/profiles/{identifier} [delete]
+func RemoveProfile(c *gin.Context) {
+	device := MustGetDevice(c)
+	identifier := c.Param("identifier")
+	_, installed, err := mcinstall.ProfileStatus(device, identifier)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	if !installed {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: fmt.Sprintf("profile %s is not installed", identifier)})
+		return
+	}
+	err = mcinstall.RemoveProfileFromDevice(device, identifier)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	ci.removed(device.Properties.SerialNumber, "profile", identifier)
+	c.JSON(http.StatusOK, GenericResponse{Message: fmt.Sprintf("profile %s removed", identifier)})
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileIdentifier(t *testing.T) {
+	profile := mcinstall.NewConfigurationProfile("com.example.wifi", "Wifi").Bytes()
+	identifier, err := profileIdentifier(profile)
+	require.NoError(t, err)
+	assert.Equal(t, "com.example.wifi", identifier)
+
+	identifier, err = profileIdentifier([]byte{0x30, 0x82, 0x01})
+	require.NoError(t, err, "signed profiles are accepted")
+	assert.Empty(t, identifier)
+
+	_, err = profileIdentifier(nil)
+	assert.Error(t, err)
+	_, err = profileIdentifier([]byte("not a profile"))
+	assert.Error(t, err)
+	_, err = profileIdentifier([]byte(`<?xml version="1.0" encoding="UTF-8"?><plist version="1.0"><dict><key>PayloadType</key><string>com.apple.wifi.managed</string></dict></plist>`))
+	assert.ErrorContains(t, err, "Configuration")
+}
+
+func TestInstallProfileValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("profile-a"))
+	defer devices.Remove("profile-a")
+	t.Setenv(supervisionP12EnvVar, "")
+	r := gin.New()
+	r.POST("/device/:udid/profiles", DeviceMiddleware(), InstallProfile)
+
+	upload := func(fields map[string]string, files map[string][]byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for name, value := range fields {
+			require.NoError(t, writer.WriteField(name, value))
+		}
+		for name, content := range files {
+			part, err := writer.CreateFormFile(name, name)
+			require.NoError(t, err)
+			_, err = part.Write(content)
+			require.NoError(t, err)
+		}
+		require.NoError(t, writer.Close())
+		req := httptest.NewRequest(http.MethodPost, "/device/profile-a/profiles", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := upload(nil, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "multipart field 'profile'")
+
+	w = upload(nil, map[string][]byte{"profile": []byte("not a profile")})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	profile := mcinstall.NewConfigurationProfile("com.example.wifi", "Wifi").Bytes()
+	w = upload(map[string]string{"supervised": "true"}, map[string][]byte{"profile": profile})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Contains(t, w.Body.String(), supervisionP12EnvVar)
+}
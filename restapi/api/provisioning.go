@@ -0,0 +1,489 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// provisioningEnvVar is the path of a yaml or json file with the ProvisioningConfig
+const provisioningEnvVar = "GO_IOS_PROVISIONING"
+
+// the steps of a provisioning
+const (
+	// ProvisionPair pairs the device if the host has no valid pair record for it
+	ProvisionPair = "pair"
+	// ProvisionMountDDI downloads and mounts the developer disk image like POST /device/{udid}/ensure-ddi
+	ProvisionMountDDI = "mount-ddi"
+	// ProvisionInstallApps installs ipas from the artifact store, f.ex. WebDriverAgent
+	ProvisionInstallApps = "install-apps"
+	// ProvisionInstallProfiles installs configuration profiles
+	ProvisionInstallProfiles = "install-profiles"
+	// ProvisionSetLanguage sets the language and locale
+	ProvisionSetLanguage = "set-language"
+	// ProvisionSkipSetup skips the setup assistant with mcinstall and supervises the device if an organization is set
+	ProvisionSkipSetup = "skip-setup"
+)
+
+// the states of a provisioning and its steps
+const (
+	ProvisioningPending   = "pending"
+	ProvisioningRunning   = "running"
+	ProvisioningSucceeded = "succeeded"
+	ProvisioningFailed    = "failed"
+	// ProvisioningSkipped is a step that did not run because an earlier step failed
+	ProvisioningSkipped = "skipped"
+)
+
+// ProvisioningConfig is the sequence of steps run on every device when it is attached
+type ProvisioningConfig struct {
+	Steps []ProvisioningStep `json:"steps" yaml:"steps"`
+}
+
+// ProvisioningStep is a step of the provisioning, only the fields of its type are used
+type ProvisioningStep struct {
+	// Type is pair, mount-ddi, install-apps, install-profiles, set-language or skip-setup
+	Type string `json:"type" yaml:"type"`
+	// Apps are the ids of artifacts or urls of ipas that install-apps installs, installed apps are not installed again
+	Apps []string `json:"apps,omitempty" yaml:"apps,omitempty"`
+	// Profiles are the paths of the configuration profiles that install-profiles installs. They are installed
+	// silently if GO_IOS_SUPERVISION_P12 is configured, otherwise the install has to be confirmed on the device.
+	Profiles []string `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+	// Language and Locale are set by set-language, skip-setup uses them for the prepared device
+	Language string `json:"language,omitempty" yaml:"language,omitempty"`
+	Locale   string `json:"locale,omitempty" yaml:"locale,omitempty"`
+	// Skip are the setup assistant panes skip-setup skips, all of them if it is empty
+	Skip []string `json:"skip,omitempty" yaml:"skip,omitempty"`
+	// Organization makes skip-setup supervise the device with the supervision identity, shown as this name
+	Organization string `json:"organization,omitempty" yaml:"organization,omitempty"`
+	// ContinueOnError runs the next steps even if this one fails
+	ContinueOnError bool `json:"continueOnError,omitempty" yaml:"continueOnError,omitempty"`
+}
+
+func (s ProvisioningStep) validate() error {
+	switch s.Type {
+	case ProvisionPair, ProvisionMountDDI, ProvisionSkipSetup:
+	case ProvisionInstallApps:
+		if len(s.Apps) == 0 {
+			return errors.New("install-apps needs apps")
+		}
+	case ProvisionInstallProfiles:
+		if len(s.Profiles) == 0 {
+			return errors.New("install-profiles needs profiles")
+		}
+	case ProvisionSetLanguage:
+		if s.Language == "" && s.Locale == "" {
+			return errors.New("set-language needs a language or a locale")
+		}
+	default:
+		return fmt.Errorf("unknown step type '%s'", s.Type)
+	}
+	return nil
+}
+
+// ProvisioningStepResult is the outcome of a step
+type ProvisioningStepResult struct {
+	Type     string     `json:"type"`
+	State    string     `json:"state"`
+	Message  string     `json:"message,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// ProvisioningStatus is the latest provisioning of a device
+type ProvisioningStatus struct {
+	UDID     string                   `json:"udid"`
+	State    string                   `json:"state"`
+	Started  time.Time                `json:"started"`
+	Finished *time.Time               `json:"finished,omitempty"`
+	Steps    []ProvisioningStepResult `json:"steps"`
+}
+
+type provisioningRun struct {
+	status ProvisioningStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// provisioningAction runs a step on a device and returns what it did
+type provisioningAction func(ctx context.Context, device ios.DeviceEntry, step ProvisioningStep) (string, error)
+
+// provisioningActions run the steps by type, tests replace them
+var provisioningActions = map[string]provisioningAction{
+	ProvisionPair:            provisionPair,
+	ProvisionMountDDI:        provisionDDI,
+	ProvisionInstallApps:     provisionApps,
+	ProvisionInstallProfiles: provisionProfiles,
+	ProvisionSetLanguage:     provisionLanguage,
+	ProvisionSkipSetup:       provisionSkipSetup,
+}
+
+// provisioner runs the configured steps on devices when they are attached, so new devices are ready for tests
+// without manual setup
+type provisioner struct {
+	mu     sync.Mutex
+	config ProvisioningConfig
+	runs   map[string]*provisioningRun
+	now    func() time.Time
+}
+
+var provisioning = &provisioner{runs: map[string]*provisioningRun{}, now: time.Now}
+
+func (p *provisioner) setConfig(config ProvisioningConfig) error {
+	for i, step := range config.Steps {
+		err := step.validate()
+		if err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = config
+	return nil
+}
+
+// loadFile reads the config from a yaml or json file
+func (p *provisioner) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config ProvisioningConfig
+	err = yaml.Unmarshal(b, &config)
+	if err != nil {
+		return fmt.Errorf("invalid provisioning config in %s: %w", path, err)
+	}
+	return p.setConfig(config)
+}
+
+func (p *provisioner) enabled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.config.Steps) > 0
+}
+
+// run provisions devices when they are added to the registry and the devices that were attached before it
+// started, until ctx is done. Provisionings of removed devices are canceled.
+func (p *provisioner) run(ctx context.Context, registry *DeviceRegistry) {
+	if !p.enabled() {
+		return
+	}
+	changes, unsubscribe := registry.Subscribe()
+	defer unsubscribe()
+	registry.Range(func(device ios.DeviceEntry) bool {
+		if _, ok := p.get(device.Properties.SerialNumber); !ok {
+			p.start(device)
+		}
+		return true
+	})
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			switch change.Type {
+			case DeviceAdded:
+				// a provisioning canceled by a quick reconnect may still be winding down
+				go func(device ios.DeviceEntry) {
+					p.cancel(device.Properties.SerialNumber)
+					p.start(device)
+				}(change.Device)
+			case DeviceRemoved:
+				go p.cancel(change.UDID)
+			}
+		}
+	}
+}
+
+// start provisions the device in the background. It returns false if a provisioning is running on it already.
+func (p *provisioner) start(device ios.DeviceEntry) (ProvisioningStatus, bool) {
+	udid := device.Properties.SerialNumber
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if r, ok := p.runs[udid]; ok && r.status.Finished == nil {
+		return r.status, false
+	}
+	steps := p.config.Steps
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &provisioningRun{
+		status: ProvisioningStatus{UDID: udid, State: ProvisioningRunning, Started: p.now(), Steps: make([]ProvisioningStepResult, len(steps))},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	for i, step := range steps {
+		r.status.Steps[i] = ProvisioningStepResult{Type: step.Type, State: ProvisioningPending}
+	}
+	p.runs[udid] = r
+	go p.provision(ctx, device, steps, r)
+	return copyProvisioningStatus(r.status), true
+}
+
+func (p *provisioner) provision(ctx context.Context, device ios.DeviceEntry, steps []ProvisioningStep, r *provisioningRun) {
+	defer close(r.done)
+	defer r.cancel()
+	udid := device.Properties.SerialNumber
+	logger := log.WithField("udid", udid)
+	var failed []string
+	stop := false
+	for i, step := range steps {
+		if stop || ctx.Err() != nil {
+			p.update(r, func(status *ProvisioningStatus) { status.Steps[i].State = ProvisioningSkipped })
+			continue
+		}
+		p.update(r, func(status *ProvisioningStatus) {
+			now := p.now()
+			status.Steps[i].State = ProvisioningRunning
+			status.Steps[i].Started = &now
+		})
+		message, err := provisioningActions[step.Type](ctx, device, step)
+		p.update(r, func(status *ProvisioningStatus) {
+			now := p.now()
+			result := &status.Steps[i]
+			result.Finished = &now
+			result.Message = message
+			result.State = ProvisioningSucceeded
+			if err != nil {
+				result.State = ProvisioningFailed
+				result.Error = err.Error()
+			}
+		})
+		if err != nil {
+			logger.WithError(err).Warnf("provisioning step %s failed", step.Type)
+			failed = append(failed, fmt.Sprintf("%s: %s", step.Type, err.Error()))
+			stop = !step.ContinueOnError
+		}
+	}
+	p.update(r, func(status *ProvisioningStatus) {
+		now := p.now()
+		status.Finished = &now
+		status.State = ProvisioningSucceeded
+		if len(failed) > 0 || ctx.Err() != nil {
+			status.State = ProvisioningFailed
+		}
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	if len(failed) > 0 {
+		history.record(DeviceEvent{UDID: udid, Type: events.ProvisioningFailed, Message: strings.Join(failed, ", ")})
+		return
+	}
+	history.record(DeviceEvent{UDID: udid, Type: events.Provisioned, Message: fmt.Sprintf("%d steps succeeded", len(steps))})
+}
+
+func (p *provisioner) update(r *provisioningRun, f func(status *ProvisioningStatus)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f(&r.status)
+}
+
+// cancel stops the running provisioning of a device and waits until it ended
+func (p *provisioner) cancel(udid string) {
+	p.mu.Lock()
+	r, ok := p.runs[udid]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	r.cancel()
+	<-r.done
+}
+
+func (p *provisioner) get(udid string) (ProvisioningStatus, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, ok := p.runs[udid]
+	if !ok {
+		return ProvisioningStatus{}, false
+	}
+	return copyProvisioningStatus(r.status), true
+}
+
+// copyProvisioningStatus copies the steps so the status can be read while the provisioning updates them
+func copyProvisioningStatus(status ProvisioningStatus) ProvisioningStatus {
+	status.Steps = append([]ProvisioningStepResult{}, status.Steps...)
+	return status
+}
+
+func provisionPair(ctx context.Context, device ios.DeviceEntry, step ProvisioningStep) (string, error) {
+	before := pairings.get(device.Properties.SerialNumber).Repairs
+	err := pairings.check(device)
+	if err != nil {
+		return "", err
+	}
+	if pairings.get(device.Properties.SerialNumber).Repairs > before {
+		return "paired", nil
+	}
+	return "paired already", nil
+}
+
+func provisionDDI(ctx context.Context, device ios.DeviceEntry, step ProvisioningStep) (string, error) {
+	result, err := ensureImageMounted(device, imageDir())
+	if err != nil {
+		return "", err
+	}
+	if result.AlreadyMounted {
+		return "image mounted already", nil
+	}
+	history.record(DeviceEvent{UDID: device.Properties.SerialNumber, Type: events.ImageMounted, Message: result.Path})
+	return "mounted " + result.Path, nil
+}
+
+func provisionApps(ctx context.Context, device ios.DeviceEntry, step ProvisioningStep) (string, error) {
+	var messages []string
+	for _, app := range step.Apps {
+		var artifact Artifact
+		var err error
+		if strings.HasPrefix(app, "http://") || strings.HasPrefix(app, "https://") {
+			artifact, err = artifacts.download(app)
+		} else {
+			artifact, err = artifacts.get(app)
+		}
+		if err != nil {
+			return strings.Join(messages, ", "), err
+		}
+		message, err := installArtifact(ctx, device, artifact, false, nil)
+		if err != nil {
+			return strings.Join(messages, ", "), fmt.Errorf("installing %s: %w", artifact.Name, err)
+		}
+		messages = append(messages, message)
+	}
+	return strings.Join(messages, ", "), nil
+}
+
+func provisionProfiles(ctx context.Context, device ios.DeviceEntry, step ProvisioningStep) (string, error) {
+	p12, password, identityErr := supervisionIdentity()
+	var messages []string
+	for _, path := range step.Profiles {
+		profile, err := os.ReadFile(path)
+		if err != nil {
+			return strings.Join(messages, ", "), err
+		}
+		identifier, err := profileIdentifier(profile)
+		if err != nil {
+			return strings.Join(messages, ", "), fmt.Errorf("%s: %w", path, err)
+		}
+		if identifier != "" {
+			_, installed, err := mcinstall.ProfileStatus(device, identifier)
+			if err == nil && installed {
+				messages = append(messages, identifier+" is installed already")
+				continue
+			}
+		}
+		message := path + " installed"
+		if identityErr != nil {
+			err = mcinstall.InstallProfile(device, profile)
+			message = path + " needs to be confirmed on the device"
+		} else {
+			err = mcinstall.InstallProfileSilent(device, p12, password, profile)
+		}
+		if err != nil {
+			return strings.Join(messages, ", "), fmt.Errorf("installing %s: %w", path, err)
+		}
+		messages = append(messages, message)
+	}
+	return strings.Join(messages, ", "), nil
+}
+
+func provisionLanguage(ctx context.Context, device ios.DeviceEntry, step ProvisioningStep) (string, error) {
+	err := ios.SetLanguage(device, ios.LanguageConfiguration{Language: step.Language, Locale: step.Locale})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(step.Language + " " + step.Locale), nil
+}
+
+func provisionSkipSetup(ctx context.Context, device ios.DeviceEntry, step ProvisioningStep) (string, error) {
+	skip := step.Skip
+	if len(skip) == 0 {
+		skip = mcinstall.GetAllSetupSkipOptions()
+	}
+	var cert []byte
+	if step.Organization != "" {
+		p12, password, err := supervisionIdentity()
+		if err != nil {
+			return "", fmt.Errorf("supervising needs the supervision identity: %w", err)
+		}
+		cert, err = mcinstall.SupervisionCertificate(p12, password)
+		if err != nil {
+			return "", err
+		}
+	}
+	err := mcinstall.Prepare(device, skip, cert, step.Organization, step.Locale, step.Language)
+	if err != nil {
+		return "", err
+	}
+	if cert != nil {
+		return "setup assistant skipped, supervised by " + step.Organization, nil
+	}
+	return "setup assistant skipped", nil
+}
+
+// loadProvisioning loads the provisioning configured with GO_IOS_PROVISIONING
+func loadProvisioning() {
+	path := os.Getenv(provisioningEnvVar)
+	if path == "" {
+		return
+	}
+	err := provisioning.loadFile(path)
+	if err != nil {
+		log.WithError(err).Errorf("ignoring %s", provisioningEnvVar)
+	}
+}
+
+// GetProvisioning returns the provisioning of the device
+// @Summary      Get the provisioning of a device
+// @Description  Returns the steps and the state of the latest provisioning of the device. The steps configured in the yaml or json file GO_IOS_PROVISIONING points to run whenever a device is attached: pair, mount-ddi, install-apps, install-profiles, set-language and skip-setup. Later steps are skipped if a step fails, unless it has continueOnError.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200  {object}  ProvisioningStatus
+// @Failure      404  {object}  GenericResponse
+// @Router       /device/{udid}/provisioning [get]
+func GetProvisioning(c *gin.Context) {
+	status, ok := provisioning.get(c.Param("udid"))
+	if !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "the device was not provisioned, check " + provisioningEnvVar})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// Provision runs the provisioning on the device again
+// @Summary      Provision a device again
+// @Description  Runs the configured provisioning steps on the device again in the background, f.ex. after a failed step was fixed. Steps that are done already, like installed apps, are not repeated.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      202  {object}  ProvisioningStatus
+// @Failure      409  {object}  GenericResponse
+// @Failure      412  {object}  GenericResponse
+// @Router       /device/{udid}/provisioning [post]
+func Provision(c *gin.Context) {
+	if !provisioning.enabled() {
+		c.JSON(http.StatusPreconditionFailed, GenericResponse{Error: "no provisioning steps configured, set " + provisioningEnvVar})
+		return
+	}
+	status, ok := provisioning.start(MustGetDevice(c))
+	if !ok {
+		c.JSON(http.StatusConflict, GenericResponse{Error: "the device is being provisioned"})
+		return
+	}
+	c.JSON(http.StatusAccepted, status)
+}
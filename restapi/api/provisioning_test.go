@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvisioningConfigFromFile(t *testing.T) {
+	p := &provisioner{runs: map[string]*provisioningRun{}, now: time.Now}
+	path := filepath.Join(t.TempDir(), "provisioning.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+steps:
+  - type: pair
+  - type: install-apps
+    apps: [wda]
+    continueOnError: true
+  - type: set-language
+    locale: en_US
+`), 0o644))
+	require.NoError(t, p.loadFile(path))
+	assert.Equal(t, []ProvisioningStep{
+		{Type: ProvisionPair},
+		{Type: ProvisionInstallApps, Apps: []string{"wda"}, ContinueOnError: true},
+		{Type: ProvisionSetLanguage, Locale: "en_US"},
+	}, p.config.Steps)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"steps": [{"type": "mount-ddi"}]}`), 0o644))
+	require.NoError(t, p.loadFile(path), "json is yaml too")
+	assert.Equal(t, []ProvisioningStep{{Type: ProvisionMountDDI}}, p.config.Steps)
+
+	assert.Error(t, p.setConfig(ProvisioningConfig{Steps: []ProvisioningStep{{Type: "erase"}}}))
+	assert.Error(t, p.setConfig(ProvisioningConfig{Steps: []ProvisioningStep{{Type: ProvisionInstallApps}}}))
+	assert.Error(t, p.setConfig(ProvisioningConfig{Steps: []ProvisioningStep{{Type: ProvisionSetLanguage}}}))
+	assert.Equal(t, []ProvisioningStep{{Type: ProvisionMountDDI}}, p.config.Steps, "invalid configs don't replace the loaded one")
+}
+
+func TestProvisioning(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := provisioningActions
+	t.Cleanup(func() { provisioningActions = original })
+	var ran []string
+	provisioningActions = map[string]provisioningAction{}
+	for _, stepType := range []string{ProvisionPair, ProvisionMountDDI, ProvisionInstallApps, ProvisionSetLanguage} {
+		stepType := stepType
+		provisioningActions[stepType] = func(ctx context.Context, device ios.DeviceEntry, step ProvisioningStep) (string, error) {
+			ran = append(ran, stepType)
+			if stepType == ProvisionMountDDI || (stepType == ProvisionInstallApps && step.Apps[0] == "broken") {
+				return "", errors.New(stepType + " failed")
+			}
+			return stepType + " done", nil
+		}
+	}
+	defer func(p *provisioner) { provisioning = p }(provisioning)
+	provisioning = &provisioner{runs: map[string]*provisioningRun{}, now: time.Now}
+	require.NoError(t, provisioning.setConfig(ProvisioningConfig{Steps: []ProvisioningStep{
+		{Type: ProvisionPair},
+		{Type: ProvisionMountDDI, ContinueOnError: true},
+		{Type: ProvisionInstallApps, Apps: []string{"broken"}},
+		{Type: ProvisionSetLanguage, Language: "de"},
+	}}))
+
+	registry := NewDeviceRegistry()
+	registry.Put(testDevice("provision-a"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go provisioning.run(ctx, registry)
+
+	r := gin.New()
+	device := r.Group("/device/:udid", func(c *gin.Context) {
+		c.Set(IOS_KEY, testDevice(c.Param("udid")))
+		c.Next()
+	})
+	device.GET("/provisioning", GetProvisioning)
+	device.POST("/provisioning", Provision)
+	serve := func(method string, udid string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, "/device/"+udid+"/provisioning", nil))
+		return w
+	}
+
+	var status ProvisioningStatus
+	require.Eventually(t, func() bool {
+		w := serve(http.MethodGet, "provision-a")
+		if w.Code != http.StatusOK {
+			return false
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		return status.Finished != nil
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, ProvisioningFailed, status.State)
+	assert.Equal(t, []string{ProvisionPair, ProvisionMountDDI, ProvisionInstallApps}, ran, "the failed ddi step continues, the failed install stops")
+	states := []string{}
+	for _, step := range status.Steps {
+		states = append(states, step.State)
+	}
+	assert.Equal(t, []string{ProvisioningSucceeded, ProvisioningFailed, ProvisioningFailed, ProvisioningSkipped}, states)
+	assert.Equal(t, "pair done", status.Steps[0].Message)
+	assert.Equal(t, "mount-ddi failed", status.Steps[1].Error)
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "provision-b").Code)
+	registry.Put(testDevice("provision-b"))
+	require.Eventually(t, func() bool {
+		status, ok := provisioning.get("provision-b")
+		return ok && status.Finished != nil
+	}, time.Second, time.Millisecond, "attached devices are provisioned")
+
+	ran = nil
+	require.NoError(t, provisioning.setConfig(ProvisioningConfig{Steps: []ProvisioningStep{{Type: ProvisionPair}}}))
+	w := serve(http.MethodPost, "provision-a")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Eventually(t, func() bool {
+		status, _ := provisioning.get("provision-a")
+		return status.State == ProvisioningSucceeded
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{ProvisionPair}, ran)
+}
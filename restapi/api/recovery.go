@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// IncidentResponse is returned for requests that failed because of a panic.
+// The incident id is logged together with the stack trace.
+type IncidentResponse struct {
+	Error      string `json:"error"`
+	IncidentID string `json:"incidentId"`
+}
+
+// RecoveryMiddleware recovers from panics in handlers, logs them with a stack trace and an incident id
+// and responds with a 500 containing the incident id.
+func RecoveryMiddleware(logger logrus.FieldLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			incidentID := uuid.New().String()
+			logger.WithFields(logrus.Fields{
+				"incidentId": incidentID,
+				"panic":      recovered,
+				"method":     c.Request.Method,
+				"path":       c.Request.URL.Path,
+				"stack":      string(debug.Stack()),
+			}).Error("recovered from panic in handler")
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, IncidentResponse{Error: "internal error", IncidentID: incidentID})
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type s3Export S3Exporter
+
+func (s s3Export) String() string { return "s3 " + s.URL }
+
+// export uploads report.json, junit.xml and the attachments of the run below <url>/<session id>/
+func (s s3Export) export(ctx context.Context, client *http.Client, run exportedRun) error {
+	report, err := json.MarshalIndent(exportedSession{Session: run.session, Report: run.report}, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.put(ctx, client, run.session.ID+"/report.json", bytes.NewReader(report), int64(len(report)), "application/json")
+	if err != nil {
+		return err
+	}
+	err = s.put(ctx, client, run.session.ID+"/junit.xml", bytes.NewReader(run.junit), int64(len(run.junit)), "application/xml")
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(run.attachments))
+	for key := range run.attachments {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err := s.putFile(ctx, client, run.session.ID+"/"+key, run.attachments[key])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s s3Export) putFile(ctx context.Context, client *http.Client, key string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return s.put(ctx, client, key, f, info.Size(), "application/octet-stream")
+}
+
+// put uploads an object, body is read twice to sign its checksum
+func (s s3Export) put(ctx context.Context, client *http.Client, key string, body io.ReadSeeker, size int64, contentType string) error {
+	h := sha256.New()
+	_, err := io.Copy(h, body)
+	if err == nil {
+		_, err = body.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return err
+	}
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(s.URL, "/")+"/"+strings.Join(segments, "/"), io.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	signS3Request(req, hex.EncodeToString(h.Sum(nil)), s.Region, s.AccessKeyID, s.SecretAccessKey, time.Now())
+	return sendExportRequest(client, req)
+}
+
+// signS3Request adds the AWS signature version 4 of the request to its headers
+func signS3Request(req *http.Request, payloadHash string, region string, accessKeyID string, secretAccessKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("content-type:%s\nhost:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n",
+		req.Header.Get("Content-Type"), req.URL.Host, payloadHash, amzDate)
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders, signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
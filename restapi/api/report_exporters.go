@@ -0,0 +1,553 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/secrets"
+	"github.com/danielpaulus/go-ios/ios/testmanagerd"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// reportExportersEnvVar is the path of a json file with a list of ReportExporter every xcuitest session is
+// exported to. Changes made with PUT /config/exporters are written back to it.
+const reportExportersEnvVar = "GO_IOS_REPORT_EXPORTERS"
+
+const (
+	// exportAttempts is how often an export is tried before it fails
+	exportAttempts = 3
+	// exportTimeout limits all exports of a session, so a hanging system doesn't keep the workspace of a session forever
+	exportTimeout = 10 * time.Minute
+)
+
+// exportBackoff is the delay before the first retry of an export, it doubles with every retry. Tests shorten it.
+var exportBackoff = time.Second
+
+// ReportExporter pushes the report and attachments of a finished xcuitest session to an external system.
+// Exactly one system has to be set. Secrets can be sealed with 'ios secrets seal-value'.
+type ReportExporter struct {
+	Webhook  *WebhookExporter  `json:"webhook,omitempty"`
+	S3       *S3Exporter       `json:"s3,omitempty"`
+	TestRail *TestRailExporter `json:"testrail,omitempty"`
+	Allure   *AllureExporter   `json:"allure,omitempty"`
+}
+
+// WebhookExporter posts the session and its TestReport as json. Attachment paths in the report are relative to
+// the session, like the keys the S3Exporter uploads them to.
+type WebhookExporter struct {
+	URL string `json:"url"`
+	// Secret signs the body like the secret of a Webhook
+	Secret string `json:"secret,omitempty"`
+}
+
+// S3Exporter uploads report.json, junit.xml and the attachments to <url>/<session id>/ with AWS signature v4.
+// URL is the bucket with an optional prefix, like https://reports.s3.eu-central-1.amazonaws.com/ci, or the
+// path-style url of S3 compatible stores like http://minio:9000/reports/ci.
+type S3Exporter struct {
+	URL             string `json:"url"`
+	Region          string `json:"region"`
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+}
+
+// TestRailExporter adds the results to a TestRail run. Test methods are matched to TestRail cases by the case id
+// in their name, like testLogin_C1234. Test cases without a case id are not exported.
+type TestRailExporter struct {
+	URL    string `json:"url"`
+	RunID  int    `json:"runId"`
+	User   string `json:"user"`
+	APIKey string `json:"apiKey"`
+}
+
+// AllureExporter sends the results to a project of an allure-docker-service, which generates the Allure report
+type AllureExporter struct {
+	URL       string `json:"url"`
+	ProjectID string `json:"projectId"`
+}
+
+// ExportStatus is the outcome of exporting a session to one system
+type ExportStatus struct {
+	Exporter string `json:"exporter"`
+	Error    string `json:"error,omitempty"`
+}
+
+// exportedRun is what exporters push of a finished session
+type exportedRun struct {
+	session XCUITestSession
+	report  testmanagerd.TestReport
+	junit   []byte
+	// suites and runErr are the results the report was made of, for exporters with their own format
+	suites []testmanagerd.TestSuite
+	runErr error
+	// attachments are the local paths of the attachments by their path in the report
+	attachments map[string]string
+}
+
+// newExportedRun makes the attachment and crash report paths of the report relative to the session
+func newExportedRun(session XCUITestSession, suites []testmanagerd.TestSuite, runErr error, crashes []testmanagerd.TestAttachment) (exportedRun, error) {
+	run := exportedRun{session: session, report: testmanagerd.NewTestReport(suites, runErr), suites: suites, runErr: runErr, attachments: map[string]string{}}
+	relativeTo := func(dir string, attachments []testmanagerd.TestAttachment) []testmanagerd.TestAttachment {
+		result := make([]testmanagerd.TestAttachment, len(attachments))
+		for i, attachment := range attachments {
+			key := dir + "/" + filepath.Base(attachment.Path)
+			run.attachments[key] = attachment.Path
+			attachment.Path = key
+			result[i] = attachment
+		}
+		return result
+	}
+	for i := range run.report.Suites {
+		for j := range run.report.Suites[i].TestCases {
+			testCase := &run.report.Suites[i].TestCases[j]
+			testCase.Attachments = relativeTo("attachments", testCase.Attachments)
+			for k := range testCase.Attempts {
+				testCase.Attempts[k].Attachments = relativeTo("attachments", testCase.Attempts[k].Attachments)
+			}
+		}
+	}
+	if len(crashes) > 0 {
+		run.report.Crashes = relativeTo("crashes", crashes)
+	}
+	run.report.Energy = session.Energy
+	var junit bytes.Buffer
+	err := testmanagerd.NewJUnitReporter(&junit, session.BundleID).Report(suites, runErr)
+	if err != nil {
+		return exportedRun{}, err
+	}
+	run.junit = junit.Bytes()
+	return run, nil
+}
+
+// exportTarget is a ReportExporter with its secrets opened
+type exportTarget interface {
+	// String describes the target without secrets
+	String() string
+	export(ctx context.Context, client *http.Client, run exportedRun) error
+}
+
+func validateExportURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid exporter url '%s'", raw)
+	}
+	return nil
+}
+
+func (e ReportExporter) validate() error {
+	set := 0
+	var err error
+	if e.Webhook != nil {
+		set++
+		err = validateExportURL(e.Webhook.URL)
+	}
+	if e.S3 != nil {
+		set++
+		err = validateExportURL(e.S3.URL)
+		if err == nil && (e.S3.Region == "" || e.S3.AccessKeyID == "" || e.S3.SecretAccessKey == "") {
+			err = errors.New("s3 exporters need a region, accessKeyId and secretAccessKey")
+		}
+	}
+	if e.TestRail != nil {
+		set++
+		err = validateExportURL(e.TestRail.URL)
+		if err == nil && (e.TestRail.RunID <= 0 || e.TestRail.User == "" || e.TestRail.APIKey == "") {
+			err = errors.New("testrail exporters need a runId, user and apiKey")
+		}
+	}
+	if e.Allure != nil {
+		set++
+		err = validateExportURL(e.Allure.URL)
+		if err == nil && e.Allure.ProjectID == "" {
+			err = errors.New("allure exporters need a projectId")
+		}
+	}
+	if set != 1 {
+		return errors.New("an exporter needs exactly one of webhook, s3, testrail or allure")
+	}
+	return err
+}
+
+// redacted hides the secrets, so exporters can be listed without leaking them
+func (e ReportExporter) redacted() ReportExporter {
+	if e.Webhook != nil && e.Webhook.Secret != "" {
+		webhook := *e.Webhook
+		webhook.Secret = "redacted"
+		e.Webhook = &webhook
+	}
+	if e.S3 != nil {
+		s3 := *e.S3
+		s3.SecretAccessKey = "redacted"
+		e.S3 = &s3
+	}
+	if e.TestRail != nil {
+		testRail := *e.TestRail
+		testRail.APIKey = "redacted"
+		e.TestRail = &testRail
+	}
+	return e
+}
+
+// target opens the secrets of the exporter
+func (e ReportExporter) target(keys secrets.KeyProvider) (exportTarget, error) {
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+	switch {
+	case e.Webhook != nil:
+		webhook := *e.Webhook
+		secret, err := secrets.OpenString(keys, webhook.Secret)
+		webhook.Secret = secret
+		return webhookExport(webhook), err
+	case e.S3 != nil:
+		s3 := *e.S3
+		secret, err := secrets.OpenString(keys, s3.SecretAccessKey)
+		s3.SecretAccessKey = secret
+		return s3Export(s3), err
+	case e.TestRail != nil:
+		testRail := *e.TestRail
+		apiKey, err := secrets.OpenString(keys, testRail.APIKey)
+		testRail.APIKey = apiKey
+		return testRailExport(testRail), err
+	default:
+		return allureExport(*e.Allure), nil
+	}
+}
+
+// retryableExportError is returned for network errors, 429 and 5xx responses
+type retryableExportError struct {
+	err error
+}
+
+func (e retryableExportError) Error() string { return e.err.Error() }
+
+func (e retryableExportError) Unwrap() error { return e.err }
+
+// sendExportRequest sends the request and fails for responses that are not 2xx
+func sendExportRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return retryableExportError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("%s %s returned %s", req.Method, req.URL.Redacted(), resp.Status)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return retryableExportError{err}
+	}
+	return err
+}
+
+func postJSON(ctx context.Context, client *http.Client, target string, body interface{}, prepare func(req *http.Request, body []byte)) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if prepare != nil {
+		prepare(req, b)
+	}
+	return sendExportRequest(client, req)
+}
+
+type webhookExport WebhookExporter
+
+func (w webhookExport) String() string { return "webhook " + w.URL }
+
+// exportedSession is the body the WebhookExporter posts
+type exportedSession struct {
+	Session XCUITestSession         `json:"session"`
+	Report  testmanagerd.TestReport `json:"report"`
+}
+
+func (w webhookExport) export(ctx context.Context, client *http.Client, run exportedRun) error {
+	return postJSON(ctx, client, w.URL, exportedSession{Session: run.session, Report: run.report}, func(req *http.Request, body []byte) {
+		req.Header.Set("X-Go-Ios-Event", "test-report")
+		if w.Secret != "" {
+			req.Header.Set(webhookSignatureHeader, sign(w.Secret, body))
+		}
+	})
+}
+
+type testRailExport TestRailExporter
+
+func (t testRailExport) String() string { return fmt.Sprintf("testrail %s run %d", t.URL, t.RunID) }
+
+// testRailCaseID finds case ids like C1234 in test method names, separated by underscores from the rest of the name
+var testRailCaseID = regexp.MustCompile(`(?:^|[^A-Za-z0-9])C([0-9]+)(?:$|[^0-9])`)
+
+// TestRail status ids
+const (
+	testRailPassed = 1
+	testRailRetest = 4
+	testRailFailed = 5
+)
+
+type testRailResult struct {
+	CaseID   int    `json:"case_id"`
+	StatusID int    `json:"status_id"`
+	Comment  string `json:"comment,omitempty"`
+	Elapsed  string `json:"elapsed,omitempty"`
+}
+
+func testRailResults(report testmanagerd.TestReport) []testRailResult {
+	results := []testRailResult{}
+	for _, suite := range report.Suites {
+		for _, testCase := range suite.TestCases {
+			match := testRailCaseID.FindStringSubmatch(testCase.MethodName)
+			if match == nil || testCase.Status == testmanagerd.StatusSkipped {
+				continue
+			}
+			caseID, _ := strconv.Atoi(match[1])
+			result := testRailResult{CaseID: caseID, StatusID: testRailFailed}
+			switch testCase.Status {
+			case testmanagerd.StatusPassed, testmanagerd.StatusExpectedFailure:
+				result.StatusID = testRailPassed
+			case testmanagerd.StatusStalled:
+				result.StatusID = testRailRetest
+			}
+			if testCase.Failure != nil {
+				result.Comment = fmt.Sprintf("%s\n%s:%d", testCase.Failure.Message, testCase.Failure.File, testCase.Failure.Line)
+			}
+			if len(testCase.Attempts) > 0 {
+				result.Comment = strings.TrimSpace(fmt.Sprintf("%s\nretried %d times", result.Comment, len(testCase.Attempts)))
+			}
+			// TestRail rejects elapsed times below one second
+			if seconds := int(testCase.Duration); seconds > 0 {
+				result.Elapsed = fmt.Sprintf("%ds", seconds)
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+func (t testRailExport) export(ctx context.Context, client *http.Client, run exportedRun) error {
+	results := testRailResults(run.report)
+	if len(results) == 0 {
+		log.WithField("session", run.session.ID).Debug("no test cases with TestRail case ids")
+		return nil
+	}
+	target := fmt.Sprintf("%s/index.php?/api/v2/add_results_for_cases/%d", strings.TrimSuffix(t.URL, "/"), t.RunID)
+	return postJSON(ctx, client, target, map[string]interface{}{"results": results}, func(req *http.Request, _ []byte) {
+		req.SetBasicAuth(t.User, t.APIKey)
+	})
+}
+
+type allureExport AllureExporter
+
+func (a allureExport) String() string { return fmt.Sprintf("allure %s project %s", a.URL, a.ProjectID) }
+
+type allureResultFile struct {
+	FileName      string `json:"file_name"`
+	ContentBase64 string `json:"content_base64"`
+}
+
+// export sends Allure result files with the attachments, labeled with the device model and iOS version
+func (a allureExport) export(ctx context.Context, client *http.Client, run exportedRun) error {
+	dir, err := os.MkdirTemp("", "allure-results")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	var labels []testmanagerd.AllureLabel
+	if asset, ok := assets.get(run.session.UDID); ok {
+		labels = testmanagerd.AllureDeviceLabels(asset.ProductType, asset.OSVersion, asset.DeviceName)
+	}
+	err = testmanagerd.NewAllureReporter(dir, labels...).Report(run.suites, run.runErr)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	files := make([]allureResultFile, 0, len(entries))
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		files = append(files, allureResultFile{FileName: entry.Name(), ContentBase64: base64.StdEncoding.EncodeToString(content)})
+	}
+	target := fmt.Sprintf("%s/allure-docker-service/send-results?project_id=%s", strings.TrimSuffix(a.URL, "/"), url.QueryEscape(a.ProjectID))
+	return postJSON(ctx, client, target, map[string]interface{}{"results": files}, nil)
+}
+
+// reportExporterStore keeps the exporters all sessions are exported to
+type reportExporterStore struct {
+	mu        sync.Mutex
+	exporters []ReportExporter
+	path      string
+	client    *http.Client
+}
+
+var reportExporters = &reportExporterStore{exporters: []ReportExporter{}, client: &http.Client{Timeout: 2 * time.Minute}}
+
+func (s *reportExporterStore) list() []ReportExporter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ReportExporter{}, s.exporters...)
+}
+
+// set replaces the exporters, their secrets have to open with the current key provider
+func (s *reportExporterStore) set(exporters []ReportExporter) error {
+	keys, err := sealedSecrets.keys()
+	if err != nil {
+		return err
+	}
+	for _, exporter := range exporters {
+		if _, err := exporter.target(keys); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.exporters = append([]ReportExporter{}, exporters...)
+	path := s.path
+	s.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(exporters, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+func (s *reportExporterStore) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	exporters := []ReportExporter{}
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &exporters)
+		if err != nil {
+			return fmt.Errorf("invalid exporters in %s: %w", path, err)
+		}
+	}
+	err = s.set(exporters)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.path = path
+	s.mu.Unlock()
+	return nil
+}
+
+// loadReportExporters loads the exporters configured with GO_IOS_REPORT_EXPORTERS
+func loadReportExporters() {
+	path := os.Getenv(reportExportersEnvVar)
+	if path == "" {
+		return
+	}
+	err := reportExporters.loadFile(path)
+	if err != nil {
+		log.WithError(err).Errorf("ignoring %s", reportExportersEnvVar)
+	}
+}
+
+// export pushes the run to the global exporters and the exporters of the session. Exports run one after the other
+// and are retried with exponential backoff, their outcome is returned in the order of the exporters.
+func (s *reportExporterStore) export(ctx context.Context, run exportedRun, sessionExporters []ReportExporter) []ExportStatus {
+	exporters := append(s.list(), sessionExporters...)
+	if len(exporters) == 0 {
+		return nil
+	}
+	statuses := make([]ExportStatus, 0, len(exporters))
+	keys, keysErr := sealedSecrets.keys()
+	for _, exporter := range exporters {
+		if keysErr != nil {
+			statuses = append(statuses, ExportStatus{Exporter: "exporter", Error: keysErr.Error()})
+			continue
+		}
+		target, err := exporter.target(keys)
+		if err != nil {
+			statuses = append(statuses, ExportStatus{Exporter: "invalid exporter", Error: err.Error()})
+			continue
+		}
+		status := ExportStatus{Exporter: target.String()}
+		if exportErr := s.exportWithRetries(ctx, target, run); exportErr != nil {
+			status.Error = exportErr.Error()
+			log.WithFields(log.Fields{"session": run.session.ID, "exporter": status.Exporter}).WithError(exportErr).Warn("failed exporting test report")
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (s *reportExporterStore) exportWithRetries(ctx context.Context, target exportTarget, run exportedRun) error {
+	backoff := exportBackoff
+	for attempt := 1; ; attempt++ {
+		err := target.export(ctx, s.client, run)
+		var retryable retryableExportError
+		if err == nil || !errors.As(err, &retryable) || attempt == exportAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// ListReportExporters lists the exporters
+// @Summary      List test report exporters
+// @Description  Lists the exporters the report of every xcuitest session is pushed to, secrets are redacted. Needs the admin token.
+// @Tags         xcuitest
+// @Produce      json
+// @Success      200  {object}  []ReportExporter
+// @Router       /config/exporters [get]
+func ListReportExporters(c *gin.Context) {
+	exporters := reportExporters.list()
+	for i := range exporters {
+		exporters[i] = exporters[i].redacted()
+	}
+	c.JSON(http.StatusOK, exporters)
+}
+
+// SetReportExporters replaces the exporters
+// @Summary      Replace the test report exporters
+// @Description  Replaces the exporters the report and attachments of every xcuitest session are pushed to once it ended, and writes them to the file at GO_IOS_REPORT_EXPORTERS if it is set. Exporters can be a webhook, an S3 bucket, a TestRail run or an allure-docker-service project. Sessions can add their own exporters. Needs the admin token.
+// @Tags         xcuitest
+// @Accept       json
+// @Produce      json
+// @Param        exporters body []ReportExporter true "exporters"
+// @Success      200  {object}  []ReportExporter
+// @Failure      422  {object}  GenericResponse
+// @Router       /config/exporters [put]
+func SetReportExporters(c *gin.Context) {
+	var exporters []ReportExporter
+	err := c.ShouldBindJSON(&exporters)
+	if err == nil {
+		err = reportExporters.set(exporters)
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	ListReportExporters(c)
+}
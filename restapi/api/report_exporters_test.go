@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/testmanagerd"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportReceiver records the requests of all exporters
+type exportReceiver struct {
+	mu       sync.Mutex
+	requests map[string][]byte
+	headers  map[string]http.Header
+	failures int
+}
+
+func (r *exportReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(req.Body)
+	key := req.Method + " " + req.URL.RequestURI()
+	r.requests[key] = body
+	r.headers[key] = req.Header
+}
+
+func (r *exportReceiver) get(key string) ([]byte, http.Header, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	body, ok := r.requests[key]
+	return body, r.headers[key], ok
+}
+
+func TestExportXCUITestSession(t *testing.T) {
+	xcuitestStateFile = filepath.Join(t.TempDir(), "sessions.json")
+	defer func(backoff time.Duration) { exportBackoff = backoff }(exportBackoff)
+	exportBackoff = time.Millisecond
+	receiver := &exportReceiver{requests: map[string][]byte{}, headers: map[string]http.Header{}, failures: 1}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	original := reportExporters.list()
+	defer reportExporters.set(original)
+	require.NoError(t, reportExporters.set([]ReportExporter{{Webhook: &WebhookExporter{URL: server.URL + "/hook", Secret: "hook-secret"}}}))
+
+	assets.put(DeviceAsset{UDID: "export-udid", ProductType: "iPhone14,2", OSVersion: "17.2"})
+	attachment := filepath.Join(t.TempDir(), "5f0c")
+	require.NoError(t, os.WriteFile(attachment, []byte("png"), 0o644))
+	store := newXCUITestStore()
+	store.run = func(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		return []testmanagerd.TestSuite{{Name: "LoginTests", TestCases: []testmanagerd.TestCase{
+			{ClassName: "LoginTests", MethodName: "testLogin_C1234", Status: testmanagerd.StatusFailed, Duration: 3 * time.Second,
+				Err: testmanagerd.TestError{Message: "button missing", File: "LoginTests.swift", Line: 12}, Attachments: []testmanagerd.TestAttachment{{Name: "screenshot", Path: attachment}}},
+			{ClassName: "LoginTests", MethodName: "testLogout", Status: testmanagerd.StatusPassed},
+		}}}, nil
+	}
+	info, err := store.start(testDevice("export-udid"), XCUITestRequest{BundleID: "com.example.app", Exporters: []ReportExporter{
+		{S3: &S3Exporter{URL: server.URL + "/reports/ci", Region: "eu-central-1", AccessKeyID: "AKID", SecretAccessKey: "secret"}},
+		{TestRail: &TestRailExporter{URL: server.URL, RunID: 7, User: "ci@example.com", APIKey: "key"}},
+		{Allure: &AllureExporter{URL: server.URL, ProjectID: "ios"}},
+	}})
+	require.NoError(t, err)
+	session, _ := store.get("export-udid", info.ID)
+	waitForXCUITest(t, session)
+	require.Eventually(t, func() bool { return len(session.snapshot().Exports) == 4 }, 5*time.Second, 10*time.Millisecond)
+	for _, export := range session.snapshot().Exports {
+		assert.Empty(t, export.Error, export.Exporter)
+	}
+
+	body, headers, ok := receiver.get("POST /hook")
+	require.True(t, ok, "the webhook is retried after 503")
+	assert.Equal(t, sign("hook-secret", body), headers.Get(webhookSignatureHeader))
+	var exported exportedSession
+	require.NoError(t, json.Unmarshal(body, &exported))
+	assert.Equal(t, info.ID, exported.Session.ID)
+	assert.Equal(t, 1, exported.Report.Summary.Failed)
+	assert.Equal(t, "attachments/5f0c", exported.Report.Suites[0].TestCases[0].Attachments[0].Path)
+
+	_, headers, ok = receiver.get("PUT /reports/ci/" + info.ID + "/report.json")
+	require.True(t, ok)
+	assert.True(t, strings.HasPrefix(headers.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"), headers.Get("Authorization"))
+	_, _, ok = receiver.get("PUT /reports/ci/" + info.ID + "/junit.xml")
+	assert.True(t, ok)
+	body, _, ok = receiver.get("PUT /reports/ci/" + info.ID + "/attachments/5f0c")
+	require.True(t, ok)
+	assert.Equal(t, "png", string(body))
+
+	body, headers, ok = receiver.get("POST /index.php?/api/v2/add_results_for_cases/7")
+	require.True(t, ok)
+	assert.JSONEq(t, `{"results": [{"case_id": 1234, "status_id": 5, "comment": "button missing\nLoginTests.swift:12", "elapsed": "3s"}]}`, string(body))
+	user, _, _ := (&http.Request{Header: headers}).BasicAuth()
+	assert.Equal(t, "ci@example.com", user)
+
+	body, _, ok = receiver.get("POST /allure-docker-service/send-results?project_id=ios")
+	require.True(t, ok)
+	var allure struct {
+		Results []allureResultFile `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(body, &allure))
+	require.Len(t, allure.Results, 3, "two results and the screenshot")
+	var results []string
+	for _, file := range allure.Results {
+		content, err := base64.StdEncoding.DecodeString(file.ContentBase64)
+		require.NoError(t, err)
+		if strings.HasSuffix(file.FileName, "-result.json") {
+			results = append(results, string(content))
+		}
+	}
+	require.Len(t, results, 2)
+	assert.Contains(t, results[0]+results[1], `"fullName":"LoginTests.testLogin_C1234"`)
+	assert.Contains(t, results[0]+results[1], `{"name":"deviceModel","value":"iPhone14,2"}`)
+}
+
+func TestExportFailuresAreReported(t *testing.T) {
+	defer func(backoff time.Duration) { exportBackoff = backoff }(exportBackoff)
+	exportBackoff = time.Millisecond
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	store := &reportExporterStore{client: server.Client()}
+
+	statuses := store.export(context.Background(), exportedRun{}, []ReportExporter{{Webhook: &WebhookExporter{URL: server.URL}}, {}})
+	require.Len(t, statuses, 2)
+	assert.Contains(t, statuses[0].Error, "403")
+	assert.Equal(t, "invalid exporter", statuses[1].Exporter)
+}
+
+func TestSetReportExporters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := reportExporters.list()
+	defer reportExporters.set(original)
+	r := gin.New()
+	r.PUT("/config/exporters", SetReportExporters)
+	put := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/exporters", strings.NewReader(body)))
+		return w
+	}
+
+	w := put(`[{"testrail": {"url": "https://example.testrail.io", "runId": 7, "user": "ci", "apiKey": "key"}}]`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `[{"testrail": {"url": "https://example.testrail.io", "runId": 7, "user": "ci", "apiKey": "redacted"}}]`, w.Body.String())
+	assert.Equal(t, "key", reportExporters.list()[0].TestRail.APIKey)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`[{"testrail": {"url": "https://example.testrail.io"}}]`).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`[{"allure": {"url": "ftp://allure", "projectId": "ios"}}]`).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`[{"webhook": {"url": "https://a"}, "allure": {"url": "https://b", "projectId": "ios"}}]`).Code)
+}
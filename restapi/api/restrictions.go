@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/gin-gonic/gin"
+)
+
+// restrictionsProfileIdentifier is the identifier of the restrictions profile managed by the REST API
+const restrictionsProfileIdentifier = "Go-iOS.Restrictions.5B1E2C7A-9D3F-4E8B-A6C1-2F7D4B9E0A13"
+
+// Restrictions are the restrictions applied to a device. Restrictions that are false keep the device default.
+type Restrictions struct {
+	DisableCamera           bool `json:"disableCamera"`
+	DisableScreenShots      bool `json:"disableScreenShots"`
+	DisallowAppInstallation bool `json:"disallowAppInstallation"`
+	DisallowAppRemoval      bool `json:"disallowAppRemoval"`
+	ForceWiFiOn             bool `json:"forceWiFiOn"`
+}
+
+func (r Restrictions) payload() mcinstall.RestrictionsPayload {
+	payload := mcinstall.NewRestrictionsPayload()
+	if r.DisableCamera {
+		payload = payload.DisableCamera()
+	}
+	if r.DisableScreenShots {
+		payload = payload.DisableScreenShots()
+	}
+	if r.DisallowAppInstallation {
+		payload = payload.DisallowAppInstallation()
+	}
+	if r.DisallowAppRemoval {
+		payload = payload.DisallowAppRemoval()
+	}
+	if r.ForceWiFiOn {
+		payload = payload.ForceWiFiOn()
+	}
+	return payload
+}
+
+// appliedRestrictions remembers the restrictions applied to each device
+var appliedRestrictions sync.Map
+
+// Get the restrictions of a device
+// @Summary      Get restrictions
+// @Description  Returns the restrictions applied to the device with PUT /device/{udid}/restrictions.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200 {object} Restrictions
+// @Router       /device/{udid}/restrictions [get]
+func GetRestrictions(c *gin.Context) {
+	restrictions, ok := appliedRestrictions.Load(MustGetDevice(c).Properties.SerialNumber)
+	if !ok {
+		restrictions = Restrictions{}
+	}
+	c.JSON(http.StatusOK, restrictions)
+}
+
+// Apply restrictions to a device
+// @Summary      Apply restrictions
+// @Description  Installs a restrictions profile on a supervised device, f.ex. to disable the camera or prevent app removal. The restrictions replace the ones applied before. Needs the supervision identity configured with GO_IOS_SUPERVISION_P12.
+// @Tags         general_device_specific
+// @Accept       json
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        restrictions body Restrictions true "Restrictions"
+// @Success      200 {object} GenericResponse
+// @Failure      412 {object} GenericResponse
+// @Failure      422 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/restrictions [put]
+func SetRestrictions(c *gin.Context) {
+	device := MustGetDevice(c)
+	var restrictions Restrictions
+	err := c.ShouldBindJSON(&restrictions)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	p12, password, err := supervisionIdentity()
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, GenericResponse{Error: err.Error()})
+		return
+	}
+	err = mcinstall.InstallRestrictions(device, restrictionsProfileIdentifier, restrictions.payload(), p12, password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	appliedRestrictions.Store(device.Properties.SerialNumber, restrictions)
+	c.JSON(http.StatusOK, GenericResponse{Message: "restrictions applied"})
+}
+
+// Remove the restrictions of a device
+// @Summary      Remove restrictions
+// @Description  Removes the restrictions profile installed with PUT /device/{udid}/restrictions.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/restrictions [delete]
+func RemoveRestrictions(c *gin.Context) {
+	device := MustGetDevice(c)
+	err := mcinstall.RemoveRestrictions(device, restrictionsProfileIdentifier)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	appliedRestrictions.Delete(device.Properties.SerialNumber)
+	c.JSON(http.StatusOK, GenericResponse{Message: "restrictions removed"})
+}
@@ -0,0 +1,19 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestrictionsPayload(t *testing.T) {
+	payload := Restrictions{DisableCamera: true, ForceWiFiOn: true}.payload()
+	require.NotNil(t, payload.AllowCamera)
+	assert.False(t, *payload.AllowCamera)
+	require.NotNil(t, payload.ForceWiFiPowerOn)
+	assert.True(t, *payload.ForceWiFiPowerOn)
+	assert.Nil(t, payload.AllowAppRemoval)
+	assert.Nil(t, payload.AllowScreenShot)
+	assert.Nil(t, payload.AllowAppInstallation)
+}
@@ -8,35 +8,158 @@ var streamingMiddleWare = StreamingHeaderMiddleware()
 
 func registerRoutes(router *gin.RouterGroup) {
 	router.GET("/list", List)
+	router.GET("/lifecycle", streamingMiddleWare, ListenLifecycle)
+	router.GET("/events", streamingMiddleWare, StreamEvents)
+	router.GET("/devices", ListRegisteredDevices)
+	router.GET("/devices/diff", DiffDevices)
+	router.GET("/devices/drift", ListDrift)
+	router.POST("/devices", RegisterDevice)
+	router.DELETE("/devices/:udid", SoftDeleteDevice)
+	router.PUT("/devices/apps/hidden", SetFleetHiddenApps)
+	router.GET("/inventory/export", ExportInventory)
+	router.GET("/ci/summary", GetCISummary)
+	router.GET("/conditions/presets", ListConditionPresets)
+	router.GET("/config/timeouts", GetTimeoutPolicies)
+	router.GET("/config/bandwidth", GetBandwidthLimits)
+	router.GET("/golden-states", ListGoldenStates)
+	router.GET("/qrcode", GenerateQRCode)
+	router.PUT("/golden-states", AdminMiddleware(), SetGoldenStates)
+	router.GET("/webhooks", AdminMiddleware(), ListWebhooks)
+	router.PUT("/webhooks", AdminMiddleware(), SetWebhooks)
+	router.PUT("/config/timeouts", AdminMiddleware(), SetTimeoutPolicies)
+	router.PUT("/config/bandwidth", AdminMiddleware(), SetBandwidthLimits)
+	router.GET("/config/exporters", AdminMiddleware(), ListReportExporters)
+	router.PUT("/config/exporters", AdminMiddleware(), SetReportExporters)
+	router.GET("/config/hooks", AdminMiddleware(), ListJobHooks)
+	router.PUT("/config/hooks", AdminMiddleware(), SetJobHooks)
+	router.GET("/slos", GetSLOReport)
+	router.GET("/config/slos", GetSLOObjectives)
+	router.PUT("/config/slos", AdminMiddleware(), SetSLOObjectives)
+	router.GET("/monitoring", ListMonitoring)
+	router.GET("/config/monitoring", GetMonitoringConfig)
+	router.PUT("/config/monitoring", AdminMiddleware(), SetMonitoringConfig)
+	router.GET("/config/thumbnails", GetThumbnailConfig)
+	router.PUT("/config/thumbnails", AdminMiddleware(), SetThumbnailConfig)
+	router.GET("/macros", ListMacros)
+	router.GET("/macros/:name", GetMacro)
+	router.PUT("/macros/:name", PutMacro)
+	router.DELETE("/macros/:name", DeleteMacro)
+	router.GET("/simulation", ListSimulatedDevices)
+	router.GET("/tunnels", ListTunnels)
+	router.POST("/simulation/:udid/attach", AttachSimulatedDevice)
+	router.POST("/simulation/:udid/detach", DetachSimulatedDevice)
+	maintenanceRoutes(router)
+	artifactRoutes(router)
+	wallboardRoutes(router)
+	debugRoutes(router)
+	imageRoutes(router)
 
 	device := router.Group("/device/:udid")
 	device.Use(DeviceMiddleware())
-	simpleDeviceRoutes(device)
-	appRoutes(device)
+	device.GET("/health", GetDeviceHealth)
+	device.PUT("/labels", SetDeviceLabels)
+	device.GET("/maintenance", NextMaintenance)
+	device.GET("/monitoring", GetMonitoring)
+	device.GET("/monitoring/screenshot", GetMonitoringScreenshot)
+	device.GET("/status", Status)
+	device.GET("/provisioning", GetProvisioning)
+	device.GET("/story", GetDeviceStory)
+	device.GET("/thumbnail", GetThumbnail)
+
+	reachable := device.Group("", CircuitBreakerMiddleware(), DeviceReachableMiddleware())
+	reachable.POST("/pair", PairDevice)
+	reachable.GET("/pairing", GetPairingStatus)
+	reachable.POST("/provisioning", Provision)
+	reachable.POST("/healthcheck", HealthCheck)
+
+	paired := reachable.Group("", DevicePairedMiddleware())
+	simpleDeviceRoutes(paired)
+	appRoutes(paired)
+	appFileRoutes(paired)
+	davRoutes(paired)
+	wdaRoutes(paired)
 }
 
 func simpleDeviceRoutes(device *gin.RouterGroup) {
+	device.POST("/accessibility/audit", RunAccessibilityAudit)
 	device.POST("/activate", Activate)
+	device.GET("/battery", GetBattery)
+	device.GET("/certificates", ListCertificates)
+	device.POST("/certificates", InstallCertificate)
+	device.DELETE("/certificates/:fingerprint", RemoveCertificate)
+	device.GET("/clock", GetClock)
+	device.GET("/crashes", ListCrashes)
+	device.GET("/crashes/*name", GetCrash)
+
+	device.GET("/drift", GetDeviceDrift)
+	device.POST("/drift/remediate", RemediateDeviceDrift)
 
 	device.GET("/conditions", GetSupportedConditions)
+	device.GET("/active-condition", GetActiveCondition)
 	device.PUT("/enable-condition", EnableDeviceCondition)
 	device.POST("/disable-condition", DisableDeviceCondition)
 
+	device.PUT("/httpproxy", SetHTTPProxy)
+	device.DELETE("/httpproxy", RemoveHTTPProxy)
+
 	device.GET("/image", GetImages)
 	device.PUT("/image", InstallImage)
+	device.POST("/image/unmount", UnmountImage)
+	device.POST("/ensure-ddi", EnsureDDI)
+
+	device.POST("/home", PressHome)
+	device.GET("/iconstate", GetIconState)
+	device.POST("/unlock", Unlock)
+	device.GET("/wallpaper", GetWallpaper)
 
 	device.GET("/notifications", streamingMiddleWare, Notifications)
 
+	device.GET("/export", ExportDevice)
+	device.POST("/export/share", ShareDeviceExport)
 	device.GET("/info", Info)
 	device.GET("/listen", streamingMiddleWare, Listen)
 
-	device.POST("/pair", PairDevice)
+	device.GET("/locale", GetLocale)
+	device.GET("/mobilegestalt", GetMobileGestalt)
+	device.GET("/network-config", GetNetworkConfig)
+	device.PUT("/network-config", SetNetworkConfig)
+	device.DELETE("/network-config", RemoveNetworkConfig)
+	device.PUT("/parental-controls", SetParentalControls)
+	device.DELETE("/parental-controls", RemoveParentalControls)
+	device.GET("/pcap", ListPcaps)
+	device.POST("/pcap/start", StartPcap)
+	device.POST("/pcap/stop", StopPcap)
+	device.GET("/pcap/:id/download", DownloadPcap)
+	device.POST("/photos", PushPhoto)
+	device.GET("/perf", streamingMiddleWare, StreamPerf)
+	device.GET("/processes", ListProcesses)
+	device.POST("/processes/:pid/kill", KillProcess)
 	device.GET("/profiles", GetProfiles)
+	device.POST("/profiles", InstallProfile)
+	device.DELETE("/profiles/:identifier", RemoveProfile)
+	device.GET("/restrictions", GetRestrictions)
+	device.PUT("/restrictions", SetRestrictions)
+	device.DELETE("/restrictions", RemoveRestrictions)
 
 	device.POST("/resetlocation", ResetLocation)
 	device.GET("/screenshot", Screenshot)
+	device.POST("/screenshot/diff", DiffScreenshot)
+	device.GET("/screen/barcodes", GetScreenBarcodes)
+	device.GET("/screen/text", GetScreenText)
+	device.GET("/services", ProbeServices)
 	device.PUT("/setlocation", SetLocation)
+	device.POST("/setlocation/route", StartRoute)
+	device.GET("/setlocation/route", GetRoute)
+	device.DELETE("/setlocation/route", StopRoute)
 	device.GET("/syslog", streamingMiddleWare, Syslog)
+	device.GET("/syslog/stream", StreamSyslog)
+	device.GET("/video", Video)
+
+	device.GET("/xcuitest", ListXCUITests)
+	device.POST("/xcuitest/start", StartXCUITest)
+	device.GET("/xcuitest/:sessionId/status", GetXCUITestStatus)
+	device.POST("/xcuitest/:sessionId/stop", StopXCUITest)
+	device.GET("/xcuitest/:sessionId/output", streamingMiddleWare, StreamXCUITestOutput)
 
 }
 
@@ -44,6 +167,105 @@ func appRoutes(group *gin.RouterGroup) {
 	router := group.Group("/apps")
 	router.Use(LimitNumClientsUDID())
 	router.GET("/", ListApps)
+	router.POST("", InstallAppWithProgress)
+	router.DELETE("/:bundleID", UninstallApp)
+	router.POST("/install", InstallApp)
 	router.POST("/launch", LaunchApp)
+	router.POST("/:bundleID/launch", LaunchAppWithOptions)
+	router.GET("/:bundleID/state", GetAppState)
+	router.GET("/signature", GetAppSignature)
 	router.POST("/kill", KillApp)
+	router.GET("/system", ListSystemApps)
+	router.DELETE("/system/:bundleID", RemoveSystemApp)
+	router.PUT("/hidden", SetHiddenApps)
+}
+
+// appFileRoutes are not limited to one client per device like appRoutes, downloads can take long
+func appFileRoutes(group *gin.RouterGroup) {
+	router := group.Group("/apps/:bundleID/files")
+	router.GET("", ListAppFiles)
+	router.DELETE("", DeleteAppFile)
+	router.GET("/download", DownloadAppFile)
+	router.POST("/upload", UploadAppFile)
+}
+
+// davRoutes serve the media directory and app containers with WebDAV, so they can be mounted with standard tools
+func davRoutes(group *gin.RouterGroup) {
+	for _, method := range davMethods {
+		group.Handle(method, davPath, ServeDeviceDAV)
+		group.Handle(method, "/apps/:bundleID"+davPath, ServeDeviceDAV)
+	}
+}
+
+func wdaRoutes(group *gin.RouterGroup) {
+	router := group.Group("/wda")
+	router.GET("/sessions", ListWdaSessions)
+	router.POST("/session", AcquireWdaSession)
+	router.DELETE("/session/:id", ReleaseWdaSession)
+	router.Any("/session/:id/proxy/*path", ProxyWdaSession)
+	router.GET("/recording/:id", GetSessionRecording)
+	router.POST("/recording/:id/share", ShareSessionRecording)
+	router.POST("/session/:id/macro/start", StartMacroRecording)
+	router.POST("/session/:id/macro/stop", StopMacroRecording)
+	router.POST("/session/:id/macros/:name/replay", ReplayMacro)
+	router.POST("/session/:id/recordings/:recording/replay", ReplaySessionRecording)
+}
+
+func imageRoutes(group *gin.RouterGroup) {
+	router := group.Group("/images")
+	router.GET("/", ListCachedImages)
+	router.POST("/download", DownloadImage)
+	router.DELETE("/:version", RemoveCachedImage)
+}
+
+func maintenanceRoutes(group *gin.RouterGroup) {
+	router := group.Group("/maintenance")
+	router.GET("/windows", ListMaintenanceWindows)
+	router.POST("/windows", CreateMaintenanceWindow)
+	router.DELETE("/windows/:id", DeleteMaintenanceWindow)
+}
+
+func debugRoutes(group *gin.RouterGroup) {
+	router := group.Group("/debug", AdminMiddleware())
+	router.GET("/connections", ListConnections)
+	router.DELETE("/connections/:id", CloseConnection)
+	router.GET("/loglevel", GetLogLevel)
+	router.PUT("/loglevel", SetLogLevel)
+	pprofRoutes(router)
+}
+
+func artifactRoutes(group *gin.RouterGroup) {
+	router := group.Group("/artifacts")
+	router.GET("/", ListArtifacts)
+	router.POST("/", UploadArtifact)
+	router.DELETE("/:id", DeleteArtifact)
+	router.POST("/:id/share", ShareArtifact)
+	router.POST("/uploads", CreateArtifactUpload)
+	router.GET("/uploads/:id", GetArtifactUpload)
+	router.HEAD("/uploads/:id", GetArtifactUpload)
+	router.PATCH("/uploads/:id", WriteArtifactUpload)
+	router.PUT("/uploads/:id", WriteArtifactUpload)
+	router.DELETE("/uploads/:id", CancelArtifactUpload)
+}
+
+func wallboardRoutes(group *gin.RouterGroup) {
+	group.GET("/wallboard", Wallboard)
+	router := group.Group("/wallboard")
+	router.GET("/screens", ListWallboardScreens)
+	router.GET("/screens/:udid", GetWallboardScreen)
+	router.GET("/config", GetWallboardConfig)
+	router.PUT("/config", SetWallboardConfig)
+}
+
+// registerRoutesV2 registers the v2 routes. See the README for what changed compared to v1 and the breaking-change policy.
+func registerRoutesV2(router *gin.RouterGroup) {
+	router.GET("/devices", ListDevicesV2)
+	router.GET("/jobs", ListJobs)
+	router.GET("/jobs/:id", GetJob)
+	router.DELETE("/jobs/:id", CancelJob)
+
+	device := router.Group("/devices/:device", ResolveDeviceMiddleware())
+	device.GET("", GetDeviceV2)
+	device.POST("/apps/install", InstallAppV2)
+	device.POST("/image/mount", MountImageV2)
 }
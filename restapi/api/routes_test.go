@@ -0,0 +1,15 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gin panics on conflicting routes, so registering all of them is enough to catch conflicts
+func TestRegisterRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	registerRoutes(router.Group("/api/v1"))
+	registerRoutesV2(router.Group("/api/v2"))
+}
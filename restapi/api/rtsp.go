@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/screenstream"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// rtspEnvVar is the address of the RTSP gateway, f.ex. :8554. The gateway is off if it is not set.
+const rtspEnvVar = "GO_IOS_RTSP_ADDR"
+
+// rtspGateway publishes the screens of devices at rtsp://<host><GO_IOS_RTSP_ADDR>/<udid>, with the scale, quality
+// and fps query parameters of /device/{udid}/video. Players share the streams of /video, so watching a device in
+// VLC and a browser at the same time captures it once. Players send the api key or jwt as password with basic auth,
+// like rtsp://go-ios:<token>@localhost:8554/<udid>, and need the read scope.
+type rtspGateway struct{}
+
+// serveRTSP runs the RTSP gateway until it fails
+func serveRTSP(addr string) {
+	log.Infof("rtsp gateway listening on %s", addr)
+	err := screenstream.NewRTSPServer(rtspGateway{}).ListenAndServe(addr)
+	log.WithError(err).Error("rtsp gateway stopped")
+}
+
+func (rtspGateway) Authorize(req *http.Request) error {
+	if authn.enabled() {
+		principal, err := authn.authenticate(&gin.Context{Request: req})
+		if err != nil {
+			return &screenstream.RTSPError{Status: http.StatusUnauthorized, Message: "authentication required, send an api key or jwt as password"}
+		}
+		if !principal.Has(ScopeRead) {
+			return &screenstream.RTSPError{Status: http.StatusForbidden, Message: fmt.Sprintf("'%s' needs the %s scope", principal.Name, ScopeRead)}
+		}
+	}
+	_, _, err := rtspStream(req)
+	return err
+}
+
+func (rtspGateway) Open(req *http.Request) (<-chan []byte, func(), error) {
+	device, options, err := rtspStream(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	removeOwner := connectionOwners.add(device.DeviceID, "RTSP "+req.URL.Path)
+	frames, stop, err := videos.watch(device, options)
+	if err != nil {
+		removeOwner()
+		return nil, nil, err
+	}
+	return frames, func() {
+		stop()
+		removeOwner()
+	}, nil
+}
+
+// rtspStream returns the device and the options of the stream a request asks for. The udid is the first segment of
+// the path, players append the track to it.
+func rtspStream(req *http.Request) (ios.DeviceEntry, screenstream.Options, error) {
+	udid, _, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"), "/")
+	if udid == "" {
+		return ios.DeviceEntry{}, screenstream.Options{}, &screenstream.RTSPError{Status: http.StatusNotFound, Message: "udid is missing"}
+	}
+	options, err := videoOptionsFrom(&gin.Context{Request: req})
+	if err != nil {
+		return ios.DeviceEntry{}, screenstream.Options{}, &screenstream.RTSPError{Status: http.StatusBadRequest, Message: err.Error()}
+	}
+	options.MaxSide = screenstream.MaxRTPSide
+	device, found, err := findDevice(udid)
+	if !found {
+		return ios.DeviceEntry{}, screenstream.Options{}, &screenstream.RTSPError{Status: http.StatusNotFound, Message: "device not found on the host"}
+	}
+	if err != nil {
+		return ios.DeviceEntry{}, screenstream.Options{}, err
+	}
+	return device, options, nil
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/screenstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rtspRequest(t *testing.T, rawURL string, token string) *http.Request {
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	req := &http.Request{Method: "DESCRIBE", URL: u, Header: http.Header{}}
+	if token != "" {
+		req.SetBasicAuth("go-ios", token)
+	}
+	return req
+}
+
+func TestRTSPGateway(t *testing.T) {
+	var opened, closed atomic.Int32
+	defer func(source func(ios.DeviceEntry) (screenstream.FrameSource, error)) { videoSource = source }(videoSource)
+	videoSource = func(device ios.DeviceEntry) (screenstream.FrameSource, error) {
+		opened.Add(1)
+		return fakeFrameSource{png: testScreenshot(t), closed: &closed}, nil
+	}
+	devices.Put(testDevice("rtsp-udid"))
+	defer devices.Remove("rtsp-udid")
+	authRouter(t, apiKeyAuthenticator{keys: []APIKey{{Name: "viewer", Key: "view", Scopes: []Scope{ScopeRead}}, {Name: "none", Key: "none"}}})
+	gateway := rtspGateway{}
+
+	statusOf := func(err error) int {
+		var rtspErr *screenstream.RTSPError
+		require.ErrorAs(t, err, &rtspErr)
+		return rtspErr.Status
+	}
+	assert.Equal(t, http.StatusUnauthorized, statusOf(gateway.Authorize(rtspRequest(t, "rtsp://localhost:8554/rtsp-udid", ""))))
+	assert.Equal(t, http.StatusUnauthorized, statusOf(gateway.Authorize(rtspRequest(t, "rtsp://localhost:8554/rtsp-udid", "wrong"))))
+	assert.Equal(t, http.StatusForbidden, statusOf(gateway.Authorize(rtspRequest(t, "rtsp://localhost:8554/rtsp-udid", "none"))))
+	assert.Equal(t, http.StatusBadRequest, statusOf(gateway.Authorize(rtspRequest(t, "rtsp://localhost:8554/rtsp-udid?fps=100", "view"))))
+	assert.NoError(t, gateway.Authorize(rtspRequest(t, "rtsp://localhost:8554/rtsp-udid/trackID=0?scale=0.5", "view")))
+
+	frames, stop, err := gateway.Open(rtspRequest(t, "rtsp://localhost:8554/rtsp-udid/?scale=0.5", "view"))
+	require.NoError(t, err)
+	select {
+	case frame := <-frames:
+		assert.NotEmpty(t, frame)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no frame")
+	}
+	stop()
+	assert.Equal(t, int32(1), opened.Load())
+	assert.Eventually(t, func() bool { return closed.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+}
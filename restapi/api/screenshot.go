@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"strconv"
+
+	"github.com/danielpaulus/go-ios/ios/screenstream"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultJPEGQuality is good enough for dashboards and a fraction of the size of the png
+const defaultJPEGQuality = 80
+
+// ScreenshotOptions are the query params of the screenshot endpoint
+type ScreenshotOptions struct {
+	// Format is png or jpeg
+	Format string
+	// Scale is between 0 and 1, screenshots are only scaled down
+	Scale float64
+	// Quality of jpeg screenshots, from 1 to 100
+	Quality int
+}
+
+func screenshotOptionsFrom(c *gin.Context) (ScreenshotOptions, error) {
+	options := ScreenshotOptions{Format: c.DefaultQuery("format", "png"), Scale: 1, Quality: defaultJPEGQuality}
+	if options.Format == "jpg" {
+		options.Format = "jpeg"
+	}
+	if options.Format != "png" && options.Format != "jpeg" {
+		return options, fmt.Errorf("format must be png or jpeg")
+	}
+	if scale := c.Query("scale"); scale != "" {
+		value, err := strconv.ParseFloat(scale, 64)
+		if err != nil || value <= 0 || value > 1 {
+			return options, fmt.Errorf("scale must be a number greater than 0 and at most 1")
+		}
+		options.Scale = value
+	}
+	if quality := c.Query("quality"); quality != "" {
+		value, err := strconv.Atoi(quality)
+		if err != nil || value < 1 || value > 100 {
+			return options, fmt.Errorf("quality must be a number from 1 to 100")
+		}
+		options.Quality = value
+	}
+	return options, nil
+}
+
+// convertScreenshot scales and encodes a png screenshot like options say and returns it with its content type.
+// Screenshots that don't need to be converted are returned as they are.
+func convertScreenshot(raw []byte, options ScreenshotOptions) ([]byte, string, error) {
+	if options.Format == "png" && options.Scale == 1 {
+		return raw, "image/png", nil
+	}
+	if options.Format == "jpeg" {
+		b, err := screenstream.EncodeJPEG(raw, options.Scale, options.Quality)
+		if err != nil {
+			return nil, "", fmt.Errorf("convertScreenshot: %w", err)
+		}
+		return b, "image/jpeg", nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, "", fmt.Errorf("convertScreenshot: failed decoding screenshot: %w", err)
+	}
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	err = encoder.Encode(&buf, screenstream.Downscale(img, options.Scale))
+	if err != nil {
+		return nil, "", fmt.Errorf("convertScreenshot: failed encoding png: %w", err)
+	}
+	return buf.Bytes(), "image/png", nil
+}
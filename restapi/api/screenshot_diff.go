@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/danielpaulus/go-ios/ios/imagediff"
+	"github.com/gin-gonic/gin"
+)
+
+// maxBaselineSize is the largest baseline image that is accepted
+const maxBaselineSize = 32 << 20
+
+// ScreenshotDiff is the result of comparing a screenshot with a baseline
+type ScreenshotDiff struct {
+	imagediff.Result
+	// Passed is true if the score is at most max_score
+	Passed   bool    `json:"passed"`
+	MaxScore float64 `json:"maxScore"`
+	// DiffImage is the base64 encoded png of the differences, differences are red and ignored regions blue
+	DiffImage string `json:"diffImage,omitempty"`
+}
+
+// diffOptionsFrom parses the algorithm, threshold and ignore query params
+func diffOptionsFrom(c *gin.Context) (imagediff.Options, error) {
+	options := imagediff.DefaultOptions()
+	if algorithm := c.Query("algorithm"); algorithm != "" {
+		options.Algorithm = imagediff.Algorithm(algorithm)
+	}
+	if threshold := c.Query("threshold"); threshold != "" {
+		value, err := strconv.ParseFloat(threshold, 64)
+		if err != nil {
+			return options, fmt.Errorf("threshold must be a number between 0 and 1")
+		}
+		options.Threshold = value
+	}
+	for _, region := range c.QueryArray("ignore") {
+		rect, err := parseRegion(region)
+		if err != nil {
+			return options, err
+		}
+		options.Ignore = append(options.Ignore, rect)
+	}
+	return options, options.Validate()
+}
+
+// parseRegion parses x,y,width,height in pixels
+func parseRegion(region string) (image.Rectangle, error) {
+	parts := strings.Split(region, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid region '%s', use x,y,width,height", region)
+	}
+	values := make([]int, 4)
+	for i, part := range parts {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || value < 0 {
+			return image.Rectangle{}, fmt.Errorf("invalid region '%s', use x,y,width,height", region)
+		}
+		values[i] = value
+	}
+	return image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3]), nil
+}
+
+// diffScreenshot compares the png or jpeg screenshot with the baseline
+func diffScreenshot(screenshot []byte, baseline image.Image, options imagediff.Options) (imagediff.Result, error) {
+	img, _, err := image.Decode(bytes.NewReader(screenshot))
+	if err != nil {
+		return imagediff.Result{}, fmt.Errorf("diffScreenshot: failed decoding screenshot: %w", err)
+	}
+	return imagediff.Compare(baseline, img, options)
+}
+
+// DiffScreenshot compares a screenshot with a baseline
+// @Summary      Compare a screenshot with a baseline
+// @Description  Takes a screenshot and compares it with the png or jpeg uploaded as multipart field "baseline". The pixel algorithm counts pixels whose color differs perceptibly, ssim compares the structure of 8x8 blocks and tolerates compression noise. The score is 0 for equal images and 1 for completely different ones. With output=image the diff png is returned and the score is in the X-Diff-Score header.
+// @Tags         general_device_specific
+// @Accept       multipart/form-data
+// @Produce      json,png
+// @Param        udid path string true "Device UDID"
+// @Param        baseline formData file true "baseline image with the size of the screenshot"
+// @Param        algorithm query string false "pixel or ssim, defaults to pixel"
+// @Param        threshold query number false "between 0 and 1, differences up to it are ignored, defaults to 0.1"
+// @Param        max_score query number false "the check passes if the score is at most this, defaults to 0"
+// @Param        ignore query []string false "regions not to compare as x,y,width,height in pixels" collectionFormat(multi)
+// @Param        output query string false "json or image, defaults to json"
+// @Success      200  {object}  ScreenshotDiff
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/screenshot/diff [post]
+func DiffScreenshot(c *gin.Context) {
+	options, err := diffOptionsFrom(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	maxScore := 0.0
+	if value := c.Query("max_score"); value != "" {
+		maxScore, err = strconv.ParseFloat(value, 64)
+		if err != nil || maxScore < 0 || maxScore > 1 {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "max_score must be a number between 0 and 1"})
+			return
+		}
+	}
+	b, err := formFileBytes(c, "baseline", maxBaselineSize)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	if b == nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "the baseline image is missing"})
+		return
+	}
+	baseline, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "the baseline is not a png or jpeg image: " + err.Error()})
+		return
+	}
+	device := MustGetDevice(c)
+	screenshot, err := captureScreen(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	result, err := diffScreenshot(screenshot, baseline, options)
+	if errors.Is(err, imagediff.ErrSizeMismatch) {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	err = encoder.Encode(&buf, result.Diff)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	diff := ScreenshotDiff{Result: result, Passed: result.Score <= maxScore, MaxScore: maxScore}
+	c.Header("Cache-Control", "no-store")
+	if c.Query("output") == "image" {
+		c.Header("X-Diff-Score", strconv.FormatFloat(result.Score, 'f', -1, 64))
+		c.Header("X-Diff-Passed", strconv.FormatBool(diff.Passed))
+		c.Data(http.StatusOK, "image/png", buf.Bytes())
+		return
+	}
+	diff.DiffImage = base64.StdEncoding.EncodeToString(buf.Bytes())
+	c.JSON(http.StatusOK, diff)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios/imagediff"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	parse := func(query string) (imagediff.Options, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/diff?"+query, nil)
+		return diffOptionsFrom(c)
+	}
+	options, err := parse("")
+	require.NoError(t, err)
+	assert.Equal(t, imagediff.DefaultOptions(), options)
+
+	options, err = parse("algorithm=ssim&threshold=0.2&ignore=0,0,100,50&ignore=1,2,3,4")
+	require.NoError(t, err)
+	assert.Equal(t, imagediff.SSIM, options.Algorithm)
+	assert.Equal(t, 0.2, options.Threshold)
+	assert.Equal(t, []image.Rectangle{image.Rect(0, 0, 100, 50), image.Rect(1, 2, 4, 6)}, options.Ignore)
+
+	for _, query := range []string{"algorithm=blur", "threshold=1.5", "ignore=1,2,3", "ignore=a,b,c,d"} {
+		_, err = parse(query)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestDiffScreenshot(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.White)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+
+	result, err := diffScreenshot(buf.Bytes(), img, imagediff.DefaultOptions())
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.DiffPixels)
+
+	_, err = diffScreenshot(buf.Bytes(), image.NewRGBA(image.Rect(0, 0, 2, 2)), imagediff.DefaultOptions())
+	assert.ErrorIs(t, err, imagediff.ErrSizeMismatch)
+}
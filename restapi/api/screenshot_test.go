@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testScreenshot(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 40; x++ {
+			// alternating black and white columns average to grey
+			if x%2 == 0 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestScreenshotOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	options := func(query string) (ScreenshotOptions, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/screenshot?"+query, nil)
+		return screenshotOptionsFrom(c)
+	}
+	o, err := options("")
+	require.NoError(t, err)
+	assert.Equal(t, ScreenshotOptions{Format: "png", Scale: 1, Quality: defaultJPEGQuality}, o)
+
+	o, err = options("format=jpg&scale=0.5&quality=60")
+	require.NoError(t, err)
+	assert.Equal(t, ScreenshotOptions{Format: "jpeg", Scale: 0.5, Quality: 60}, o)
+
+	for _, query := range []string{"format=gif", "scale=0", "scale=2", "scale=a", "quality=0", "quality=101"} {
+		_, err := options(query)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestConvertScreenshot(t *testing.T) {
+	raw := testScreenshot(t)
+
+	b, contentType, err := convertScreenshot(raw, ScreenshotOptions{Format: "png", Scale: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+	assert.Equal(t, raw, b, "unconverted screenshots are passed through")
+
+	b, contentType, err = convertScreenshot(raw, ScreenshotOptions{Format: "png", Scale: 0.5})
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", contentType)
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 20, 40), img.Bounds())
+	r, _, _, _ := img.At(3, 3).RGBA()
+	assert.InDelta(t, 0x7fff, r, 0x200, "pixels are averaged")
+
+	b, contentType, err = convertScreenshot(raw, ScreenshotOptions{Format: "jpeg", Scale: 0.25, Quality: 80})
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", contentType)
+	img, err = jpeg.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 10, 20), img.Bounds())
+
+	_, _, err = convertScreenshot([]byte("no image"), ScreenshotOptions{Format: "jpeg", Scale: 1, Quality: 80})
+	assert.Error(t, err)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/danielpaulus/go-ios/ios/secrets"
+)
+
+// secretStore opens sealed environment variables and files with the key provider configured in GO_IOS_KMS.
+// Opened values are cached, a KMS round trip on every request to an admin endpoint would be too slow.
+type secretStore struct {
+	mu       sync.Mutex
+	config   string
+	provider secrets.KeyProvider
+	values   map[string]string
+}
+
+var sealedSecrets = &secretStore{}
+
+// keys returns the key provider, it is created again if GO_IOS_KMS changed
+func (s *secretStore) keys() (secrets.KeyProvider, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	config := os.Getenv(secrets.KMSEnvVar)
+	if s.provider != nil && config == s.config {
+		return s.provider, nil
+	}
+	provider, err := secrets.ProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	s.config = config
+	s.provider = provider
+	s.values = map[string]string{}
+	return provider, nil
+}
+
+// env returns the environment variable name, values sealed with 'ios secrets seal-value' are opened
+func (s *secretStore) env(name string) (string, error) {
+	value := os.Getenv(name)
+	keys, err := s.keys()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	opened, ok := s.values[value]
+	s.mu.Unlock()
+	if ok {
+		return opened, nil
+	}
+	opened, err = secrets.OpenString(keys, value)
+	if err != nil {
+		return "", fmt.Errorf("failed opening %s: %w", name, err)
+	}
+	s.mu.Lock()
+	if s.values != nil {
+		s.values[value] = opened
+	}
+	s.mu.Unlock()
+	return opened, nil
+}
+
+// readFile reads a file that can be sealed with 'ios secrets seal'
+func (s *secretStore) readFile(path string) ([]byte, error) {
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+	return secrets.ReadFile(keys, path)
+}
+
+// envNamePattern matches the names of environment variables secrets can be injected as
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// resolveSecret fetches a secret by its reference when a test run is launched, tests replace it
+var resolveSecret = func(ref string) (string, error) {
+	keys, err := sealedSecrets.keys()
+	if err != nil {
+		return "", err
+	}
+	return secrets.NewResolver(keys, os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN"), os.Getenv(secrets.VaultPrefixEnvVar)).Resolve(ref)
+}
+
+// validateSecretRefs checks the environment variable names and the references of secrets without fetching them
+func validateSecretRefs(refs map[string]string) error {
+	for name, ref := range refs {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name '%s' for a secret", name)
+		}
+		err := secrets.ValidReference(ref)
+		if err != nil {
+			return fmt.Errorf("secret %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// injectSecrets resolves the secret references of a test run and adds them to the environment of the test runner.
+// It returns the values as well, so they can be redacted from the output. Only the returned env contains them,
+// they are never stored in the session.
+func injectSecrets(env []string, refs map[string]string) ([]string, []string, error) {
+	if len(refs) == 0 {
+		return env, nil, nil
+	}
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	injected := append([]string{}, env...)
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		value, err := resolveSecret(refs[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed resolving secret %s: %w", name, err)
+		}
+		injected = append(injected, name+"="+value)
+		values = append(values, value)
+	}
+	return injected, values, nil
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios/secrets"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealedSecrets(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "master.key")
+	require.NoError(t, secrets.GenerateKeyFile(keyFile))
+	keys, err := secrets.NewLocalKeyProvider(keyFile)
+	require.NoError(t, err)
+	t.Setenv(secrets.KMSEnvVar, "local:"+keyFile)
+
+	t.Run("sealed admin token", func(t *testing.T) {
+		token, err := secrets.SealString(keys, "secret")
+		require.NoError(t, err)
+		t.Setenv(adminTokenEnvVar, token)
+
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.GET("/admin", AdminMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("sealed supervision identity", func(t *testing.T) {
+		p12File := filepath.Join(dir, "supervision.p12")
+		require.NoError(t, secrets.WriteFile(keys, p12File, []byte("p12"), 0o600))
+		password, err := secrets.SealString(keys, "pass")
+		require.NoError(t, err)
+		t.Setenv(supervisionP12EnvVar, p12File)
+		t.Setenv(supervisionP12PasswordEnvVar, password)
+
+		p12, pass, err := supervisionIdentity()
+		require.NoError(t, err)
+		assert.Equal(t, "p12", string(p12))
+		assert.Equal(t, "pass", pass)
+	})
+
+	t.Run("sealed values fail without key provider", func(t *testing.T) {
+		p12File := filepath.Join(dir, "supervision.p12")
+		t.Setenv(supervisionP12EnvVar, p12File)
+		t.Setenv(secrets.KMSEnvVar, "")
+		_, _, err := supervisionIdentity()
+		assert.ErrorContains(t, err, secrets.KMSEnvVar)
+
+		content, err := os.ReadFile(p12File)
+		require.NoError(t, err)
+		assert.True(t, secrets.IsSealed(content))
+	})
+}
@@ -1,9 +1,12 @@
 package api
 
 import (
+	"context"
 	"io"
 	"os"
+	"path/filepath"
 
+	"github.com/danielpaulus/go-ios/ios/workspace"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
@@ -13,16 +16,101 @@ import (
 func Main() {
 	router := gin.Default()
 	log := logrus.New()
-	myfile, _ := os.Create("go-ios.log")
+	ciMode, err := ciModeFromEnv()
+	if err != nil {
+		log.WithError(err).Fatal("invalid mode")
+	}
+	logFile := "go-ios.log"
+	if ciMode {
+		err = ci.start()
+		if err != nil {
+			log.WithError(err).Fatal("failed starting ci mode")
+		}
+		logFile = filepath.Join(ci.stateDir, logFile)
+	}
+	myfile, _ := os.Create(logFile)
 	gin.DefaultWriter = io.MultiWriter(myfile, os.Stdout)
-	router.Use(MyLogger(log), gin.Recovery())
+	router.Use(MyLogger(log), RecoveryMiddleware(log))
 
-	v1 := router.Group("/api/v1")
+	err = loadAuth()
+	if err != nil {
+		log.WithError(err).Fatalf("failed loading %s", authEnvVar)
+	}
+	v1 := router.Group("/api/v1", v1Deprecation(), AuthMiddleware())
 	registerRoutes(v1)
+	v2 := router.Group("/api/v2", AuthMiddlewareV2())
+	registerRoutesV2(v2)
+	router.GET("/shared/artifacts/:id", DownloadSharedArtifact)
+	// probes of orchestrators like kubernetes and the Prometheus metrics, they need no credentials
+	router.GET("/healthz", Healthz)
+	router.GET("/readyz", Readyz)
+	router.GET("/metrics", Metrics)
+
+	loadTimeoutPolicies()
+	loadBandwidthLimits()
+	loadConditionPresets()
+	loadGoldenStates()
+	loadProvisioning()
+	loadWebhooks()
+	loadReportExporters()
+	loadJobHooks()
+	loadSLOObjectives()
+	loadMonitoringConfig()
+	loadThumbnailConfig()
+	loadMacros()
+	simulation, err = loadSimulation()
+	if err != nil {
+		log.WithError(err).Fatalf("invalid %s", simulationEnvVar)
+	}
+	if simulation != nil {
+		log.Warnf("simulating %d devices instead of the ones usbmuxd reports", simulation.config.Devices)
+		simulation.apply()
+		go simulation.run(context.Background())
+	} else {
+		err = deviceTunnels.start(loadTunnelConfig())
+		if err != nil {
+			log.WithError(err).Fatalf("failed starting tunnels, check %s", tunnelsEnvVar)
+		}
+		go deviceTunnels.run(context.Background(), devices)
+	}
+	_, err = workspace.Default().GC()
+	if err != nil {
+		log.WithError(err).Warn("failed removing abandoned workspaces")
+	}
+
+	go history.recordRegistry(context.Background(), devices)
+	go webhooks.run(context.Background(), history)
+	go devices.syncWithUsbmuxd(context.Background())
+	go clocks.measureRegistry(context.Background(), devices)
+	go pairings.run(context.Background(), devices)
+	// a ci run starts without state and leaves devices as they are, apart from what it creates itself
+	if !ciMode {
+		recoverConditions()
+		go assets.collectFromRegistry(context.Background(), devices)
+		go maintenance.run(context.Background(), devices)
+		go sessionPool.warmUpConnectedDevices()
+		go cleanupXCUITestRunners(killTestRunner)
+		go screens.run(context.Background(), devices)
+		go goldenStates.run(context.Background(), devices)
+		go provisioning.run(context.Background(), devices)
+		go monitors.run(context.Background(), devices)
+		go thumbnails.run(context.Background(), devices)
+	}
+
+	if addr := os.Getenv(rtspEnvVar); addr != "" {
+		go serveRTSP(addr)
+	}
 
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	err := router.Run(":8080")
+	if ciMode {
+		err = serveCI(router, ":8080")
+		if err != nil {
+			log.WithError(err).Fatal("ci run failed")
+		}
+		return
+	}
+	err = router.Run(":8080")
 	if err != nil {
 		log.Error(err)
 	}
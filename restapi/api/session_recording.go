@@ -0,0 +1,410 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/danielpaulus/go-ios/ios/syslog"
+	"github.com/danielpaulus/go-ios/ios/workspace"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	screenRecordingInterval = 500 * time.Millisecond
+	// maxRecordingFramesSize limits the screen frames of a recording, the screen is not recorded anymore afterwards
+	maxRecordingFramesSize = 512 << 20
+	// recordingRetention is how long finished recordings are kept
+	recordingRetention = 7 * 24 * time.Hour
+)
+
+// recordingsDir contains the zipped session recordings as <udid>/<session id>.zip
+var recordingsDir = filepath.Join(os.TempDir(), "go-ios-recordings")
+
+// recordingPath returns where the recording of a session of the device is stored
+func recordingPath(udid string, id string) string {
+	return filepath.Join(recordingsDir, filepath.Base(udid), filepath.Base(id)+".zip")
+}
+
+// findRecording returns the path of the recording with the given id, whatever device it was recorded on
+func findRecording(id string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(recordingsDir, "*", filepath.Base(id)+".zip"))
+	if err != nil {
+		return "", fmt.Errorf("findRecording: %w", err)
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("findRecording: recording %s: %w", id, fs.ErrNotExist)
+	}
+	return matches[0], nil
+}
+
+// pruneRecordings removes recordings that were finished more than recordingRetention ago
+func pruneRecordings(now time.Time) {
+	matches, err := filepath.Glob(filepath.Join(recordingsDir, "*", "*.zip"))
+	if err != nil {
+		return
+	}
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || now.Sub(info.ModTime()) < recordingRetention {
+			continue
+		}
+		log.WithField("recording", path).Info("removing expired session recording")
+		os.Remove(path)
+		// only succeeds if it was the last recording of the device
+		os.Remove(filepath.Dir(path))
+	}
+}
+
+// sessionRecording records screen frames, input events and the syslog of a remote control session.
+// Frames are stored as frames/<milliseconds since start>.png and input events and syslog messages as json lines
+// with the same offset, so the session can be replayed in the order things happened. Syslog timestamps are
+// corrected by the clock offset of the device, so they line up with the events measured with the host clock.
+type sessionRecording struct {
+	id     string
+	udid   string
+	dir    string
+	ws     *workspace.Workspace
+	start  time.Time
+	done   chan struct{}
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	events *os.File
+	// closed is set by finish, input that arrives afterwards is dropped
+	closed bool
+	clock  *ios.ClockMeasurement
+}
+
+func startSessionRecording(device ios.DeviceEntry, id string) (*sessionRecording, error) {
+	ws, err := workspace.Default().New("recording-" + id)
+	if err != nil {
+		return nil, fmt.Errorf("startSessionRecording: %w", err)
+	}
+	dir := ws.Path("recording")
+	err = os.MkdirAll(filepath.Join(dir, "frames"), 0o755)
+	if err != nil {
+		ws.Close()
+		return nil, fmt.Errorf("startSessionRecording: %w", err)
+	}
+	events, err := os.Create(filepath.Join(dir, "events.jsonl"))
+	if err != nil {
+		ws.Close()
+		return nil, fmt.Errorf("startSessionRecording: %w", err)
+	}
+	r := &sessionRecording{id: id, udid: device.Properties.SerialNumber, dir: dir, ws: ws, start: time.Now(), done: make(chan struct{}), events: events}
+	clock, err := clocks.current(device)
+	if err != nil {
+		log.WithError(err).Warn("session recording without clock correction, could not measure device clock")
+	} else {
+		r.clock = &clock
+	}
+
+	screenshots, err := instruments.NewScreenshotService(device)
+	if err != nil {
+		log.WithError(err).Warn("session recording without screen, could not start screenshot service")
+	} else {
+		r.wg.Add(1)
+		go r.recordScreen(screenshots)
+	}
+	syslogConn, err := syslog.New(device)
+	if err != nil {
+		log.WithError(err).Warn("session recording without syslog, could not start syslog")
+	} else {
+		r.wg.Add(1)
+		go r.recordSyslog(syslogConn)
+	}
+	return r, nil
+}
+
+func (r *sessionRecording) offset() int64 {
+	return time.Since(r.start).Milliseconds()
+}
+
+func (r *sessionRecording) recordScreen(screenshots *instruments.ScreenshotService) {
+	defer r.wg.Done()
+	defer screenshots.Close()
+	var size int
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-time.After(screenRecordingInterval):
+		}
+		offset := r.offset()
+		png, err := screenshots.TakeScreenshot()
+		if err != nil {
+			log.WithError(err).Warn("session recording stopped recording the screen")
+			return
+		}
+		size += len(png)
+		if size > maxRecordingFramesSize {
+			log.WithField("id", r.id).Warnf("session recording stopped recording the screen after %d MB of frames", maxRecordingFramesSize>>20)
+			return
+		}
+		err = os.WriteFile(filepath.Join(r.dir, "frames", fmt.Sprintf("%010d.png", offset)), png, 0o644)
+		if err != nil {
+			log.WithError(err).Warn("session recording could not write frame")
+		}
+	}
+}
+
+func (r *sessionRecording) recordSyslog(conn *syslog.Connection) {
+	defer r.wg.Done()
+	file, err := os.Create(filepath.Join(r.dir, "syslog.jsonl"))
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer file.Close()
+	go func() {
+		<-r.done
+		conn.Close()
+	}()
+	for {
+		msg, err := conn.ReadLogMessage()
+		if err != nil {
+			return
+		}
+		file.WriteString(MustMarshal(map[string]interface{}{
+			"offsetMs": r.syslogOffset(msg, time.Now()),
+			"message":  strings.TrimRight(msg, "\x00\n"),
+		}) + "\n")
+	}
+}
+
+// syslogOffset returns the offset of a syslog message from the start of the recording. It uses the timestamp of the
+// message corrected by the clock offset of the device, or the time the message was received if that's not possible.
+func (r *sessionRecording) syslogOffset(msg string, received time.Time) int64 {
+	if r.clock == nil {
+		return received.Sub(r.start).Milliseconds()
+	}
+	deviceTime, ok := syslog.ParseTimestamp(msg, received.Add(r.clock.Offset), r.clock.DeviceLocation())
+	if !ok {
+		return received.Sub(r.start).Milliseconds()
+	}
+	return r.clock.HostTime(deviceTime).Sub(r.start).Milliseconds()
+}
+
+// recordInput stores an input event, like a WDA tap request, that was sent during the session
+func (r *sessionRecording) recordInput(method string, path string, body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.events.WriteString(MustMarshal(map[string]interface{}{
+		"offsetMs": r.offset(),
+		"method":   method,
+		"path":     path,
+		"body":     string(body),
+	}) + "\n")
+}
+
+// finish stops recording and zips the recording. It returns the path to the zip file.
+// Recordings older than recordingRetention are removed.
+func (r *sessionRecording) finish() (string, error) {
+	close(r.done)
+	r.wg.Wait()
+	r.mu.Lock()
+	r.closed = true
+	r.events.Close()
+	r.mu.Unlock()
+	defer r.ws.Close()
+
+	pruneRecordings(time.Now())
+	zipPath := recordingPath(r.udid, r.id)
+	err := os.MkdirAll(filepath.Dir(zipPath), 0o755)
+	if err != nil {
+		return "", fmt.Errorf("finish: %w", err)
+	}
+	err = zipDirectory(r.dir, zipPath)
+	if err != nil {
+		return "", fmt.Errorf("finish: %w", err)
+	}
+	return zipPath, nil
+}
+
+func zipDirectory(dir string, zipPath string) error {
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		entry, err := w.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(entry, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Download a session recording
+// @Summary      Download a session recording
+// @Description  Returns the zipped recording of a WDA session of the device that was acquired with record=true. It contains screen frames, input events and the syslog. Recordings are kept for 7 days.
+// @Tags         wda
+// @Produce      application/zip
+// @Success      200
+// @Failure      404  {object}  GenericResponse
+// @Param        udid path string true "Device UDID"
+// @Param        id path string true "Session id"
+// @Router       /device/{udid}/wda/recording/{id} [get]
+func GetSessionRecording(c *gin.Context) {
+	device := MustGetDevice(c)
+	path := recordingPath(device.Properties.SerialNumber, c.Param("id"))
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "recording not found"})
+		return
+	}
+	c.FileAttachment(path, filepath.Base(path))
+}
+
+// recordedInput is an input event of a session recording
+type recordedInput struct {
+	OffsetMs int64  `json:"offsetMs"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Body     string `json:"body"`
+}
+
+// RecordingReplayResult is the result of a replay job
+type RecordingReplayResult struct {
+	Recording string `json:"recording"`
+	Events    int    `json:"events"`
+}
+
+// wdaSessionPath matches the WebDriver session of a recorded WDA path, it is replaced with the session replayed on
+var wdaSessionPath = regexp.MustCompile(`^/session/[^/]+`)
+
+// readRecordedInputs returns the input events of a zipped session recording
+func readRecordedInputs(zipPath string) ([]recordedInput, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("readRecordedInputs: %w", err)
+	}
+	defer r.Close()
+	f, err := r.Open("events.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("readRecordedInputs: %w", err)
+	}
+	defer f.Close()
+	var inputs []recordedInput
+	decoder := json.NewDecoder(f)
+	for {
+		var input recordedInput
+		err := decoder.Decode(&input)
+		if err == io.EOF {
+			return inputs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("readRecordedInputs: %w", err)
+		}
+		inputs = append(inputs, input)
+	}
+}
+
+// replayRecording sends the recorded input events with their recorded timing divided by speed. The WebDriver session
+// in the paths is replaced with sessionID.
+func replayRecording(ctx context.Context, send func(method string, path string, body []byte) error, sessionID string, inputs []recordedInput, speed float64) (int, error) {
+	var last int64
+	for i, input := range inputs {
+		delay := time.Duration(float64(input.OffsetMs-last) * float64(time.Millisecond) / speed)
+		last = input.OffsetMs
+		if delay > maxMacroDelay {
+			delay = maxMacroDelay
+		}
+		select {
+		case <-ctx.Done():
+			return i, ctx.Err()
+		case <-time.After(delay):
+		}
+		path := wdaSessionPath.ReplaceAllLiteralString(input.Path, "/session/"+sessionID)
+		err := send(input.Method, path, []byte(input.Body))
+		if err != nil {
+			return i, fmt.Errorf("replayRecording: event %d failed: %w", i, err)
+		}
+	}
+	return len(inputs), nil
+}
+
+// Replay a session recording
+// @Summary      Replay a session recording
+// @Description  Starts a job sending the input events of a recorded session to the WDA session with their recorded timing, f.ex. to reproduce a bug report on another device. Coordinates are sent as recorded.
+// @Tags         wda
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        id path string true "Session id"
+// @Param        recording path string true "id of the recorded session"
+// @Param        speed query number false "replay speed, 2 replays twice as fast, defaults to 1"
+// @Success      202  {object}  Job
+// @Failure      404  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /device/{udid}/wda/session/{id}/recordings/{recording}/replay [post]
+func ReplaySessionRecording(c *gin.Context) {
+	device := MustGetDevice(c)
+	session := sessionPool.get(device.Properties.SerialNumber, c.Param("id"))
+	if session == nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "session not found"})
+		return
+	}
+	id := filepath.Base(c.Param("recording"))
+	var inputs []recordedInput
+	path, err := findRecording(id)
+	if err == nil {
+		inputs, err = readRecordedInputs(path)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "recording not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	speed := 1.0
+	if value := c.Query("speed"); value != "" {
+		speed, err = strconv.ParseFloat(value, 64)
+		if err != nil || speed <= 0 {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "speed must be a positive number"})
+			return
+		}
+	}
+	target := newWdaTarget(session)
+	acceptJob(c, jobs.start("replay-recording", device.Properties.SerialNumber, func(ctx context.Context) (interface{}, error) {
+		events, err := replayRecording(ctx, target.send, target.sessionID, inputs, speed)
+		return RecordingReplayResult{Recording: id, Events: events}, err
+	}))
+}
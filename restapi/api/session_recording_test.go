@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/workspace"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionRecordingInputAndReplay(t *testing.T) {
+	defer func(dir string) { recordingsDir = dir }(recordingsDir)
+	recordingsDir = t.TempDir()
+	ws, err := workspace.Default().New("recording-test")
+	require.NoError(t, err)
+	dir := ws.Path("recording")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	events, err := os.Create(filepath.Join(dir, "events.jsonl"))
+	require.NoError(t, err)
+	r := &sessionRecording{id: "rec", udid: "recording-udid", dir: dir, ws: ws, start: time.Now(), done: make(chan struct{}), events: events}
+
+	r.recordInput("POST", "/session/old/wda/tap/0", []byte(`{"x":1,"y":2}`))
+	r.recordInput("POST", "/wda/homescreen", nil)
+
+	// proxied requests can still arrive while the session is released
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.recordInput("POST", "/session/old/wda/keys", []byte(`{}`))
+		}()
+	}
+	zipPath, err := r.finish()
+	require.NoError(t, err)
+	wg.Wait()
+	r.recordInput("POST", "/session/old/wda/keys", []byte(`{}`))
+
+	assert.Equal(t, recordingPath("recording-udid", "rec"), zipPath)
+	found, err := findRecording("rec")
+	require.NoError(t, err)
+	assert.Equal(t, zipPath, found)
+
+	inputs, err := readRecordedInputs(zipPath)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(inputs), 2)
+	assert.LessOrEqual(t, len(inputs), 12)
+	assert.Equal(t, "/session/old/wda/tap/0", inputs[0].Path)
+	assert.Equal(t, `{"x":1,"y":2}`, inputs[0].Body)
+
+	var sent []string
+	n, err := replayRecording(context.Background(), func(method string, path string, body []byte) error {
+		sent = append(sent, method+" "+path+" "+string(body))
+		return nil
+	}, "new", inputs[:2], 100)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{`POST /session/new/wda/tap/0 {"x":1,"y":2}`, "POST /wda/homescreen "}, sent)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err = replayRecording(ctx, func(string, string, []byte) error { return nil }, "new", []recordedInput{{OffsetMs: 1000}}, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, n)
+
+	_, err = readRecordedInputs(filepath.Join(recordingsDir, "missing.zip"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestGetSessionRecordingOfOtherDevice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func(dir string) { recordingsDir = dir }(recordingsDir)
+	recordingsDir = t.TempDir()
+	path := recordingPath("recording-udid", "rec")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte("zip"), 0o644))
+
+	r := gin.New()
+	r.GET("/device/:udid/wda/recording/:id", func(c *gin.Context) {
+		c.Set(IOS_KEY, testDevice(c.Param("udid")))
+		c.Next()
+	}, GetSessionRecording)
+	get := func(udid string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/"+udid+"/wda/recording/rec", nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, get("recording-udid"))
+	assert.Equal(t, http.StatusNotFound, get("other-udid"))
+}
+
+func TestPruneRecordings(t *testing.T) {
+	defer func(dir string) { recordingsDir = dir }(recordingsDir)
+	recordingsDir = t.TempDir()
+	old, recent := recordingPath("old-udid", "old"), recordingPath("recording-udid", "recent")
+	for _, path := range []string{old, recent} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte("zip"), 0o644))
+	}
+	expired := time.Now().Add(-recordingRetention - time.Hour)
+	require.NoError(t, os.Chtimes(old, expired, expired))
+
+	pruneRecordings(time.Now())
+	_, err := os.Stat(filepath.Dir(old))
+	assert.True(t, os.IsNotExist(err), "the expired recording and its empty device directory are removed")
+	_, err = os.Stat(recent)
+	assert.NoError(t, err)
+}
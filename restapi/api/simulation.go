@@ -0,0 +1,558 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/zipconduit"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// simulationEnvVar is the path of a json SimulationConfig. If it is set, the API serves simulated devices instead
+// of the ones usbmuxd reports, so the REST layer, the jobs and the reconcilers can be load and chaos tested
+// without hardware.
+const simulationEnvVar = "GO_IOS_SIMULATION"
+
+const (
+	// simulationDefaultTick is how often random detaches are rolled if the config has no tick
+	simulationDefaultTick = time.Second
+	// simulatedPairRecordLifetime is how long the pair records of simulated devices are valid
+	simulatedPairRecordLifetime = 365 * 24 * time.Hour
+	// simulatedInstallSteps is how many progress updates a simulated installation sends
+	simulatedInstallSteps = 10
+)
+
+// errSimulatedDetach is returned by operations on simulated devices that are detached
+var errSimulatedDetach = errors.New("simulated device is detached")
+
+// SimulatedBehavior scripts how simulated devices behave. Rates are probabilities from 0 to 1.
+type SimulatedBehavior struct {
+	// InstallMillis is how long installing an app takes
+	InstallMillis int `json:"installMillis,omitempty"`
+	// InstallFailureRate is the probability that an installation fails
+	InstallFailureRate float64 `json:"installFailureRate,omitempty"`
+	// PairingFailureRate is the probability that pairing or validating the pair record fails
+	PairingFailureRate float64 `json:"pairingFailureRate,omitempty"`
+	// DetachRate is the probability that an attached device detaches on each tick
+	DetachRate float64 `json:"detachRate,omitempty"`
+	// ReattachMillis is how long a device that detached randomly stays away. It stays detached until it is
+	// attached with POST /simulation/{udid}/attach if it is 0.
+	ReattachMillis int `json:"reattachMillis,omitempty"`
+	// Unpaired devices start without pair record, they have to be paired first
+	Unpaired bool `json:"unpaired,omitempty"`
+}
+
+func (b SimulatedBehavior) validate() error {
+	for name, rate := range map[string]float64{"installFailureRate": b.InstallFailureRate, "pairingFailureRate": b.PairingFailureRate, "detachRate": b.DetachRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	if b.InstallMillis < 0 || b.ReattachMillis < 0 {
+		return errors.New("durations must not be negative")
+	}
+	return nil
+}
+
+// SimulationConfig describes the fake devices of a DeviceSimulation
+type SimulationConfig struct {
+	// Devices is how many devices are simulated, their udids are SIMULATED-0001, SIMULATED-0002 and so on
+	Devices int `json:"devices"`
+	// Seed makes the random failures and detaches reproducible, a random seed is used if it is 0
+	Seed int64 `json:"seed,omitempty"`
+	// TickMillis is how often random detaches are rolled, once a second if it is 0
+	TickMillis int `json:"tickMillis,omitempty"`
+	// Behavior applies to all devices without override
+	Behavior SimulatedBehavior `json:"behavior"`
+	// Overrides replace the behavior of single devices by udid
+	Overrides map[string]SimulatedBehavior `json:"overrides,omitempty"`
+}
+
+func (c SimulationConfig) validate() error {
+	if c.Devices <= 0 {
+		return errors.New("simulate at least one device")
+	}
+	if c.TickMillis < 0 {
+		return errors.New("tickMillis must not be negative")
+	}
+	err := c.Behavior.validate()
+	if err != nil {
+		return err
+	}
+	for udid, behavior := range c.Overrides {
+		err = behavior.validate()
+		if err != nil {
+			return fmt.Errorf("override %s: %w", udid, err)
+		}
+	}
+	return nil
+}
+
+// SimulatedDevice is the state of a simulated device
+type SimulatedDevice struct {
+	UDID     string `json:"udid"`
+	Attached bool   `json:"attached"`
+	Paired   bool   `json:"paired"`
+	// Installs and FailedInstalls count the simulated installations
+	Installs       int               `json:"installs"`
+	FailedInstalls int               `json:"failedInstalls"`
+	Behavior       SimulatedBehavior `json:"behavior"`
+	entry          ios.DeviceEntry
+	reattach       *time.Timer
+}
+
+// DeviceSimulation fabricates devices with scripted behaviors. apply makes the API use them instead of the
+// devices usbmuxd reports, operations on other devices still go to the real services.
+type DeviceSimulation struct {
+	config    SimulationConfig
+	mu        sync.Mutex
+	rand      *rand.Rand
+	devices   map[string]*SimulatedDevice
+	listeners map[int]chan ios.AttachedMessage
+	nextID    int
+}
+
+// simulation is the simulation the API was started with, it is nil without GO_IOS_SIMULATION
+var simulation *DeviceSimulation
+
+// NewDeviceSimulation creates the devices of the config, they start attached
+func NewDeviceSimulation(config SimulationConfig) (*DeviceSimulation, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	s := &DeviceSimulation{
+		config:    config,
+		rand:      rand.New(rand.NewSource(seed)),
+		devices:   map[string]*SimulatedDevice{},
+		listeners: map[int]chan ios.AttachedMessage{},
+	}
+	for i := 1; i <= config.Devices; i++ {
+		udid := fmt.Sprintf("SIMULATED-%04d", i)
+		behavior, ok := config.Overrides[udid]
+		if !ok {
+			behavior = config.Behavior
+		}
+		properties := ios.DeviceProperties{ConnectionType: "USB", DeviceID: i, SerialNumber: udid}
+		s.devices[udid] = &SimulatedDevice{
+			UDID:     udid,
+			Attached: true,
+			Paired:   !behavior.Unpaired,
+			Behavior: behavior,
+			entry:    ios.DeviceEntry{DeviceID: i, MessageType: "Attached", Properties: properties},
+		}
+	}
+	return s, nil
+}
+
+func loadSimulation() (*DeviceSimulation, error) {
+	path := os.Getenv(simulationEnvVar)
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config SimulationConfig
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewDeviceSimulation(config)
+}
+
+// apply replaces the device list, pairing, installation and syslog markers of the API with the simulated ones
+// and returns a function that restores them
+func (s *DeviceSimulation) apply() func() {
+	originalList, originalListen := usbmuxdList, usbmuxdListen
+	originalReachable, originalReadPairRecord := deviceReachable, readPairRecord
+	originalPair, originalPairSupervised, originalValidate := pairWithHost, pairWithHostSupervised, pairingValidate
+	originalSendApp, originalPost := sendApp, postNotification
+
+	usbmuxdList = s.ListDevices
+	usbmuxdListen = s.Listen
+	deviceReachable = func(device ios.DeviceEntry) error {
+		if !s.simulates(device) {
+			return originalReachable(device)
+		}
+		return s.reachable(device.Properties.SerialNumber)
+	}
+	readPairRecord = func(udid string) (ios.PairRecord, error) {
+		if _, ok := s.device(udid); !ok {
+			return originalReadPairRecord(udid)
+		}
+		if !s.paired(udid) {
+			return ios.PairRecord{}, fmt.Errorf("no pair record for simulated device %s", udid)
+		}
+		return ios.PairRecord{HostID: "simulation"}, nil
+	}
+	pairWithHost = func(device ios.DeviceEntry) error {
+		if !s.simulates(device) {
+			return originalPair(device)
+		}
+		return s.pair(device.Properties.SerialNumber)
+	}
+	pairWithHostSupervised = func(device ios.DeviceEntry, p12 []byte, password string) error {
+		if !s.simulates(device) {
+			return originalPairSupervised(device, p12, password)
+		}
+		return s.pair(device.Properties.SerialNumber)
+	}
+	pairingValidate = func(device ios.DeviceEntry) (time.Time, error) {
+		if !s.simulates(device) {
+			return originalValidate(device)
+		}
+		return s.validatePairing(device.Properties.SerialNumber)
+	}
+	sendApp = func(ctx context.Context, device ios.DeviceEntry, path string, progress func(zipconduit.Progress)) error {
+		if !s.simulates(device) {
+			return originalSendApp(ctx, device, path, progress)
+		}
+		return s.install(ctx, device.Properties.SerialNumber, progress)
+	}
+	postNotification = func(device ios.DeviceEntry, name string) error {
+		if !s.simulates(device) {
+			return originalPost(device, name)
+		}
+		return s.reachable(device.Properties.SerialNumber)
+	}
+	return func() {
+		usbmuxdList, usbmuxdListen = originalList, originalListen
+		deviceReachable, readPairRecord = originalReachable, originalReadPairRecord
+		pairWithHost, pairWithHostSupervised, pairingValidate = originalPair, originalPairSupervised, originalValidate
+		sendApp, postNotification = originalSendApp, originalPost
+	}
+}
+
+// run rolls the random detaches of the devices until ctx is done
+func (s *DeviceSimulation) run(ctx context.Context) {
+	tick := simulationDefaultTick
+	if s.config.TickMillis > 0 {
+		tick = time.Duration(s.config.TickMillis) * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.detachRandomly()
+		}
+	}
+}
+
+func (s *DeviceSimulation) detachRandomly() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, udid := range s.sortedUDIDsLocked() {
+		device := s.devices[udid]
+		if !device.Attached || !s.roll(device.Behavior.DetachRate) {
+			continue
+		}
+		log.WithField("udid", udid).Info("simulated device detached")
+		s.setAttachedLocked(device, false)
+		if device.Behavior.ReattachMillis > 0 {
+			device.reattach = time.AfterFunc(time.Duration(device.Behavior.ReattachMillis)*time.Millisecond, func() {
+				s.Attach(udid)
+			})
+		}
+	}
+}
+
+// roll returns true with the probability rate, s.mu has to be held
+func (s *DeviceSimulation) roll(rate float64) bool {
+	return rate > 0 && s.rand.Float64() < rate
+}
+
+func (s *DeviceSimulation) sortedUDIDsLocked() []string {
+	udids := make([]string, 0, len(s.devices))
+	for udid := range s.devices {
+		udids = append(udids, udid)
+	}
+	sort.Strings(udids)
+	return udids
+}
+
+// setAttachedLocked changes the attached state and tells all listeners about it
+func (s *DeviceSimulation) setAttachedLocked(device *SimulatedDevice, attached bool) {
+	if device.reattach != nil {
+		device.reattach.Stop()
+		device.reattach = nil
+	}
+	if device.Attached == attached {
+		return
+	}
+	device.Attached = attached
+	msg := ios.AttachedMessage{MessageType: "Attached", DeviceID: device.entry.DeviceID, Properties: device.entry.Properties}
+	if !attached {
+		msg.MessageType = "Detached"
+	}
+	for _, listener := range s.listeners {
+		select {
+		case listener <- msg:
+		default:
+			log.WithField("udid", device.UDID).Warn("simulated device listener too slow, dropping message")
+		}
+	}
+}
+
+// Attach attaches a simulated device. It returns false if the udid is not simulated.
+func (s *DeviceSimulation) Attach(udid string) bool {
+	return s.setAttached(udid, true)
+}
+
+// Detach detaches a simulated device until it is attached again. It returns false if the udid is not simulated.
+func (s *DeviceSimulation) Detach(udid string) bool {
+	return s.setAttached(udid, false)
+}
+
+func (s *DeviceSimulation) setAttached(udid string, attached bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, ok := s.devices[udid]
+	if ok {
+		s.setAttachedLocked(device, attached)
+	}
+	return ok
+}
+
+// Devices returns the state of all simulated devices sorted by udid
+func (s *DeviceSimulation) Devices() []SimulatedDevice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]SimulatedDevice, 0, len(s.devices))
+	for _, udid := range s.sortedUDIDsLocked() {
+		device := *s.devices[udid]
+		device.reattach = nil
+		result = append(result, device)
+	}
+	return result
+}
+
+func (s *DeviceSimulation) device(udid string) (SimulatedDevice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, ok := s.devices[udid]
+	if !ok {
+		return SimulatedDevice{}, false
+	}
+	return *device, true
+}
+
+func (s *DeviceSimulation) simulates(device ios.DeviceEntry) bool {
+	_, ok := s.device(device.Properties.SerialNumber)
+	return ok
+}
+
+// ListDevices lists the attached simulated devices like usbmuxd does
+func (s *DeviceSimulation) ListDevices() (ios.DeviceList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := ios.DeviceList{DeviceList: []ios.DeviceEntry{}}
+	for _, udid := range s.sortedUDIDsLocked() {
+		if device := s.devices[udid]; device.Attached {
+			list.DeviceList = append(list.DeviceList, device.entry)
+		}
+	}
+	return list, nil
+}
+
+// Listen works like ios.Listen. Like usbmuxd it sends an attached message for every attached device first.
+func (s *DeviceSimulation) Listen() (func() (ios.AttachedMessage, error), func() error, error) {
+	s.mu.Lock()
+	id := s.nextID
+	s.nextID++
+	messages := make(chan ios.AttachedMessage, 2*len(s.devices)+deviceChangeBufferSize)
+	for _, udid := range s.sortedUDIDsLocked() {
+		if device := s.devices[udid]; device.Attached {
+			messages <- ios.AttachedMessage{MessageType: "Attached", DeviceID: device.entry.DeviceID, Properties: device.entry.Properties}
+		}
+	}
+	s.listeners[id] = messages
+	s.mu.Unlock()
+	receive := func() (ios.AttachedMessage, error) {
+		msg, ok := <-messages
+		if !ok {
+			return ios.AttachedMessage{}, errors.New("simulated device listener closed")
+		}
+		return msg, nil
+	}
+	closeListener := func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.listeners[id]; ok {
+			delete(s.listeners, id)
+			close(messages)
+		}
+		return nil
+	}
+	return receive, closeListener, nil
+}
+
+func (s *DeviceSimulation) reachable(udid string) error {
+	device, _ := s.device(udid)
+	if !device.Attached {
+		return errSimulatedDetach
+	}
+	return nil
+}
+
+func (s *DeviceSimulation) paired(udid string) bool {
+	device, _ := s.device(udid)
+	return device.Paired
+}
+
+func (s *DeviceSimulation) pair(udid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device := s.devices[udid]
+	if !device.Attached {
+		return errSimulatedDetach
+	}
+	if s.roll(device.Behavior.PairingFailureRate) {
+		return fmt.Errorf("simulated pairing failure of %s", udid)
+	}
+	device.Paired = true
+	return nil
+}
+
+// validatePairing fails like an invalidated pair record would with the pairing failure rate
+func (s *DeviceSimulation) validatePairing(udid string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device := s.devices[udid]
+	if !device.Attached {
+		return time.Time{}, errSimulatedDetach
+	}
+	if s.roll(device.Behavior.PairingFailureRate) {
+		device.Paired = false
+	}
+	if !device.Paired {
+		return time.Time{}, fmt.Errorf("simulated device %s: %w", udid, ios.ErrPairingInvalid)
+	}
+	return time.Now().Add(simulatedPairRecordLifetime), nil
+}
+
+// install takes InstallMillis and reports progress in steps. It fails if the device detaches meanwhile or with
+// the install failure rate after half of the time.
+func (s *DeviceSimulation) install(ctx context.Context, udid string, progress func(zipconduit.Progress)) error {
+	s.mu.Lock()
+	device := s.devices[udid]
+	step := time.Duration(device.Behavior.InstallMillis) * time.Millisecond / simulatedInstallSteps
+	fail := s.roll(device.Behavior.InstallFailureRate)
+	s.mu.Unlock()
+	err := s.installSteps(ctx, udid, step, fail, progress)
+	s.mu.Lock()
+	if err != nil {
+		device.FailedInstalls++
+	} else {
+		device.Installs++
+	}
+	s.mu.Unlock()
+	return err
+}
+
+func (s *DeviceSimulation) installSteps(ctx context.Context, udid string, step time.Duration, fail bool, progress func(zipconduit.Progress)) error {
+	for i := 1; i <= simulatedInstallSteps; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(step):
+		}
+		err := s.reachable(udid)
+		if err != nil {
+			return err
+		}
+		if fail && i == simulatedInstallSteps/2 {
+			return fmt.Errorf("simulated installation failure on %s", udid)
+		}
+		if progress != nil {
+			progress(zipconduit.Progress{Status: "Installing", PercentComplete: i * 100 / simulatedInstallSteps})
+		}
+	}
+	return nil
+}
+
+// simulationOrAbort returns the running simulation or responds with 404 if the API does not simulate devices
+func simulationOrAbort(c *gin.Context) *DeviceSimulation {
+	if simulation == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, GenericResponse{Error: "no devices are simulated, set " + simulationEnvVar + " to simulate them"})
+		return nil
+	}
+	return simulation
+}
+
+// ListSimulatedDevices lists the simulated devices
+// @Summary      List simulated devices
+// @Description  Lists the devices simulated with GO_IOS_SIMULATION with their attached and paired state and how many simulated installations succeeded and failed. Responds with 404 if no devices are simulated.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  []SimulatedDevice
+// @Failure      404  {object}  GenericResponse
+// @Router       /simulation [get]
+func ListSimulatedDevices(c *gin.Context) {
+	s := simulationOrAbort(c)
+	if s == nil {
+		return
+	}
+	c.JSON(http.StatusOK, s.Devices())
+}
+
+// AttachSimulatedDevice attaches a simulated device
+// @Summary      Attach a simulated device
+// @Description  Attaches a simulated device that was detached, randomly or with /simulation/{udid}/detach.
+// @Tags         general
+// @Produce      json
+// @Param        udid path string true "udid of the simulated device"
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Router       /simulation/{udid}/attach [post]
+func AttachSimulatedDevice(c *gin.Context) {
+	setSimulatedDeviceAttached(c, true)
+}
+
+// DetachSimulatedDevice detaches a simulated device
+// @Summary      Detach a simulated device
+// @Description  Detaches a simulated device until it is attached again, running installations on it fail.
+// @Tags         general
+// @Produce      json
+// @Param        udid path string true "udid of the simulated device"
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Router       /simulation/{udid}/detach [post]
+func DetachSimulatedDevice(c *gin.Context) {
+	setSimulatedDeviceAttached(c, false)
+}
+
+func setSimulatedDeviceAttached(c *gin.Context, attached bool) {
+	s := simulationOrAbort(c)
+	if s == nil {
+		return
+	}
+	udid := c.Param("udid")
+	if !s.setAttached(udid, attached) {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: udid + " is not a simulated device"})
+		return
+	}
+	state := "detached"
+	if attached {
+		state = "attached"
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: udid + " " + state})
+}
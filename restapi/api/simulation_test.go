@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/zipconduit"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulationConfigIsValidated(t *testing.T) {
+	_, err := NewDeviceSimulation(SimulationConfig{})
+	assert.Error(t, err)
+	_, err = NewDeviceSimulation(SimulationConfig{Devices: 1, Behavior: SimulatedBehavior{DetachRate: 2}})
+	assert.Error(t, err)
+	_, err = NewDeviceSimulation(SimulationConfig{Devices: 1, Overrides: map[string]SimulatedBehavior{"SIMULATED-0001": {InstallMillis: -1}}})
+	assert.Error(t, err)
+}
+
+func TestSimulatedDevicesAttachAndDetach(t *testing.T) {
+	s, err := NewDeviceSimulation(SimulationConfig{Devices: 3, Seed: 1})
+	require.NoError(t, err)
+	t.Cleanup(s.apply())
+	registry := NewDeviceRegistry()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go registry.syncWithUsbmuxd(ctx)
+	assert.Eventually(t, func() bool { return len(registry.Snapshot()) == 3 }, time.Second, time.Millisecond)
+
+	assert.True(t, s.Detach("SIMULATED-0002"))
+	assert.False(t, s.Detach("00008030-unknown"))
+	assert.Eventually(t, func() bool {
+		_, ok := registry.Get("SIMULATED-0002")
+		return !ok
+	}, time.Second, time.Millisecond)
+	list, err := usbmuxdList()
+	require.NoError(t, err)
+	assert.Len(t, list.DeviceList, 2)
+	device := s.devices["SIMULATED-0002"].entry
+	assert.ErrorIs(t, deviceReachable(device), errSimulatedDetach)
+
+	assert.True(t, s.Attach("SIMULATED-0002"))
+	assert.Eventually(t, func() bool {
+		_, ok := registry.Get("SIMULATED-0002")
+		return ok
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, deviceReachable(device))
+}
+
+func TestSimulatedDevicesDetachRandomly(t *testing.T) {
+	s, err := NewDeviceSimulation(SimulationConfig{Devices: 2, Seed: 1, Behavior: SimulatedBehavior{DetachRate: 1, ReattachMillis: 10}})
+	require.NoError(t, err)
+	s.detachRandomly()
+	for _, device := range s.Devices() {
+		assert.False(t, device.Attached)
+	}
+	assert.Eventually(t, func() bool {
+		for _, device := range s.Devices() {
+			if !device.Attached {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond, "devices reattach after ReattachMillis")
+}
+
+func TestSimulatedInstallsAndPairings(t *testing.T) {
+	s, err := NewDeviceSimulation(SimulationConfig{
+		Devices:  2,
+		Seed:     1,
+		Behavior: SimulatedBehavior{InstallMillis: 20},
+		Overrides: map[string]SimulatedBehavior{
+			"SIMULATED-0002": {InstallFailureRate: 1, PairingFailureRate: 1, Unpaired: true},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(s.apply())
+	healthy, broken := s.devices["SIMULATED-0001"].entry, s.devices["SIMULATED-0002"].entry
+
+	_, err = readPairRecord(healthy.Properties.SerialNumber)
+	assert.NoError(t, err)
+	_, err = readPairRecord(broken.Properties.SerialNumber)
+	assert.Error(t, err)
+	assert.Error(t, pairWithHost(broken))
+	_, err = pairingValidate(broken)
+	assert.ErrorIs(t, err, ios.ErrPairingInvalid)
+	expires, err := pairingValidate(healthy)
+	assert.NoError(t, err)
+	assert.True(t, expires.After(time.Now()))
+
+	store := newJobStore(NewDeviceRegistry())
+	install := func(device ios.DeviceEntry, progress func(zipconduit.Progress)) Job {
+		job := store.start("install", device.Properties.SerialNumber, func(ctx context.Context) (interface{}, error) {
+			return nil, sendApp(ctx, device, "app.ipa", progress)
+		})
+		return waitForJob(t, store, job.ID)
+	}
+	var percent []int
+	job := install(healthy, func(p zipconduit.Progress) { percent = append(percent, p.PercentComplete) })
+	assert.Equal(t, JobSucceeded, job.State)
+	assert.Len(t, percent, simulatedInstallSteps)
+	assert.Equal(t, 100, percent[len(percent)-1])
+	job = install(broken, nil)
+	assert.Equal(t, JobFailed, job.State)
+
+	s.Detach(healthy.Properties.SerialNumber)
+	err = sendApp(context.Background(), healthy, "app.ipa", nil)
+	assert.True(t, errors.Is(err, errSimulatedDetach))
+
+	devices := s.Devices()
+	assert.Equal(t, 1, devices[0].Installs)
+	assert.Equal(t, 1, devices[0].FailedInstalls)
+	assert.Equal(t, 1, devices[1].FailedInstalls)
+}
+
+func TestSimulationEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := simulation
+	t.Cleanup(func() { simulation = original })
+	r := gin.New()
+	r.GET("/simulation", ListSimulatedDevices)
+	r.POST("/simulation/:udid/detach", DetachSimulatedDevice)
+	do := func(method string, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	simulation = nil
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/simulation").Code)
+
+	var err error
+	simulation, err = NewDeviceSimulation(SimulationConfig{Devices: 2})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/simulation/SIMULATED-0001/detach").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/simulation/unknown/detach").Code)
+	w := do(http.MethodGet, "/simulation")
+	require.Equal(t, http.StatusOK, w.Code)
+	var devices []SimulatedDevice
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &devices))
+	require.Len(t, devices, 2)
+	assert.False(t, devices[0].Attached)
+	assert.True(t, devices[1].Attached)
+}
@@ -0,0 +1,405 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// slosEnvVar sets the objectives on startup with the same JSON that PUT /config/slos accepts,
+// f.ex. [{"operation": "install", "window": "1h", "minSuccessRate": 0.99, "maxLatency": "2m", "percentile": 90}]
+const slosEnvVar = "GO_IOS_SLOS"
+
+const (
+	// sloMaxSamples limits the memory of an operation, older samples are dropped first
+	sloMaxSamples = 10000
+	// sloDefaultPercentile is the latency percentile objectives check if they don't name one
+	sloDefaultPercentile = 99
+)
+
+// operations tracked for SLOs
+const (
+	sloPair       = "pair"
+	sloInstall    = "install"
+	sloTestStart  = "test-start"
+	sloScreenshot = "screenshot"
+)
+
+var sloOperations = []string{sloPair, sloInstall, sloTestStart, sloScreenshot}
+
+// sloWindows are the rolling windows stats are computed over, the longest one is how long samples are kept
+var sloWindows = []struct {
+	name     string
+	duration time.Duration
+}{{"5m", 5 * time.Minute}, {"1h", time.Hour}, {"24h", 24 * time.Hour}}
+
+// SLOStats is the success rate and latency of an operation over a rolling window. Latencies are in seconds.
+type SLOStats struct {
+	Operation string `json:"operation"`
+	Window    string `json:"window"`
+	Count     int    `json:"count"`
+	Failures  int    `json:"failures"`
+	// SuccessRate is between 0 and 1, it is 1 if the operation did not run in the window
+	SuccessRate float64 `json:"successRate"`
+	LatencyP50  float64 `json:"latencyP50"`
+	LatencyP90  float64 `json:"latencyP90"`
+	LatencyP99  float64 `json:"latencyP99"`
+}
+
+// SLOObjective is an alert threshold of an operation. An slo-breached event is sent to the webhooks when the
+// stats of the window miss it and slo-recovered once they meet it again.
+type SLOObjective struct {
+	Operation string `json:"operation"`
+	// Window is 5m, 1h or 24h
+	Window         string  `json:"window"`
+	MinSuccessRate float64 `json:"minSuccessRate,omitempty"`
+	// MaxLatency is the longest the Percentile of the latencies may be, in the Go duration format like "1m30s"
+	MaxLatency string `json:"maxLatency,omitempty"`
+	// Percentile is 50, 90 or 99, defaults to 99
+	Percentile int `json:"percentile,omitempty"`
+	// MinCount is how often the operation must have run in the window before the objective is checked
+	MinCount int `json:"minCount,omitempty"`
+}
+
+// SLOStatus is whether an objective is met
+type SLOStatus struct {
+	Objective SLOObjective `json:"objective"`
+	Breached  bool         `json:"breached"`
+	// Reason is why the objective is breached
+	Reason string `json:"reason,omitempty"`
+}
+
+// SLOReport is the stats of all operations and the status of the objectives
+type SLOReport struct {
+	Stats      []SLOStats  `json:"stats"`
+	Objectives []SLOStatus `json:"objectives"`
+}
+
+type sloSample struct {
+	at      time.Time
+	latency time.Duration
+	ok      bool
+}
+
+// sloTracker keeps the samples of the operations of the last sloWindows and checks the objectives
+type sloTracker struct {
+	mu         sync.Mutex
+	samples    map[string][]sloSample
+	objectives []SLOObjective
+	breached   map[string]string
+	now        func() time.Time
+}
+
+var slos = newSLOTracker()
+
+func newSLOTracker() *sloTracker {
+	return &sloTracker{samples: map[string][]sloSample{}, objectives: []SLOObjective{}, breached: map[string]string{}, now: time.Now}
+}
+
+func (o SLOObjective) key() string {
+	return o.Operation + "/" + o.Window
+}
+
+func (o SLOObjective) percentile() int {
+	if o.Percentile == 0 {
+		return sloDefaultPercentile
+	}
+	return o.Percentile
+}
+
+func (o SLOObjective) validate() error {
+	if !containsString(sloOperations, o.Operation) {
+		return fmt.Errorf("unknown operation '%s', use one of %s", o.Operation, strings.Join(sloOperations, ", "))
+	}
+	if sloWindow(o.Window) == 0 {
+		return fmt.Errorf("unknown window '%s' of %s, use 5m, 1h or 24h", o.Window, o.Operation)
+	}
+	if o.MinSuccessRate < 0 || o.MinSuccessRate > 1 {
+		return fmt.Errorf("minSuccessRate of %s must be between 0 and 1", o.key())
+	}
+	if o.MaxLatency != "" {
+		if _, err := time.ParseDuration(o.MaxLatency); err != nil {
+			return fmt.Errorf("invalid maxLatency of %s: %w", o.key(), err)
+		}
+	}
+	switch o.percentile() {
+	case 50, 90, 99:
+	default:
+		return fmt.Errorf("percentile of %s must be 50, 90 or 99", o.key())
+	}
+	if o.MinSuccessRate == 0 && o.MaxLatency == "" {
+		return fmt.Errorf("objective %s needs minSuccessRate or maxLatency", o.key())
+	}
+	return nil
+}
+
+// check returns why the stats miss the objective, or an empty string if they meet it
+func (o SLOObjective) check(stats SLOStats) string {
+	if stats.Count == 0 || stats.Count < o.MinCount {
+		return ""
+	}
+	if o.MinSuccessRate > 0 && stats.SuccessRate < o.MinSuccessRate {
+		return fmt.Sprintf("%s success rate %.3f over %s is below %.3f", o.Operation, stats.SuccessRate, o.Window, o.MinSuccessRate)
+	}
+	if o.MaxLatency != "" {
+		maxLatency, _ := time.ParseDuration(o.MaxLatency)
+		latency := map[int]float64{50: stats.LatencyP50, 90: stats.LatencyP90, 99: stats.LatencyP99}[o.percentile()]
+		if latency > maxLatency.Seconds() {
+			return fmt.Sprintf("%s p%d latency %.3fs over %s is above %s", o.Operation, o.percentile(), latency, o.Window, o.MaxLatency)
+		}
+	}
+	return ""
+}
+
+func sloWindow(name string) time.Duration {
+	for _, window := range sloWindows {
+		if window.name == name {
+			return window.duration
+		}
+	}
+	return 0
+}
+
+// observe records an operation that started at start and failed if err is set, the pointer allows deferring it
+func (t *sloTracker) observe(operation string, start time.Time, err *error) {
+	now := t.now()
+	t.mu.Lock()
+	samples := append(t.samples[operation], sloSample{at: now, latency: now.Sub(start), ok: err == nil || *err == nil})
+	t.samples[operation] = t.prune(samples, now)
+	transitions := t.checkLocked(operation, now)
+	t.mu.Unlock()
+	for _, event := range transitions {
+		log.WithField("operation", operation).Warn(event.Message)
+		// SLO events belong to no device, they are not kept in the device history
+		history.bus.Publish(event)
+	}
+}
+
+func (t *sloTracker) prune(samples []sloSample, now time.Time) []sloSample {
+	retention := sloWindows[len(sloWindows)-1].duration
+	drop := 0
+	for drop < len(samples) && (now.Sub(samples[drop].at) > retention || len(samples)-drop > sloMaxSamples) {
+		drop++
+	}
+	return samples[drop:]
+}
+
+// checkLocked checks the objectives of an operation and returns the events of objectives that changed state
+func (t *sloTracker) checkLocked(operation string, now time.Time) []DeviceEvent {
+	var transitions []DeviceEvent
+	for _, objective := range t.objectives {
+		if objective.Operation != operation {
+			continue
+		}
+		reason := objective.check(t.statsLocked(operation, objective.Window, now))
+		previous, wasBreached := t.breached[objective.key()]
+		switch {
+		case reason != "" && !wasBreached:
+			t.breached[objective.key()] = reason
+			transitions = append(transitions, DeviceEvent{Time: now, Type: events.SLOBreached, Message: reason})
+		case reason == "" && wasBreached:
+			delete(t.breached, objective.key())
+			transitions = append(transitions, DeviceEvent{Time: now, Type: events.SLORecovered, Message: "recovered: " + previous})
+		case reason != "":
+			t.breached[objective.key()] = reason
+		}
+	}
+	return transitions
+}
+
+func (t *sloTracker) statsLocked(operation string, window string, now time.Time) SLOStats {
+	stats := SLOStats{Operation: operation, Window: window, SuccessRate: 1}
+	var latencies []float64
+	for _, sample := range t.samples[operation] {
+		if now.Sub(sample.at) > sloWindow(window) {
+			continue
+		}
+		stats.Count++
+		if !sample.ok {
+			stats.Failures++
+		}
+		latencies = append(latencies, sample.latency.Seconds())
+	}
+	if stats.Count == 0 {
+		return stats
+	}
+	stats.SuccessRate = float64(stats.Count-stats.Failures) / float64(stats.Count)
+	sort.Float64s(latencies)
+	stats.LatencyP50 = percentile(latencies, 50)
+	stats.LatencyP90 = percentile(latencies, 90)
+	stats.LatencyP99 = percentile(latencies, 99)
+	return stats
+}
+
+// percentile returns the nearest-rank percentile p of sorted values
+func percentile(sorted []float64, p int) float64 {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (t *sloTracker) report() SLOReport {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := SLOReport{Stats: []SLOStats{}, Objectives: []SLOStatus{}}
+	for _, operation := range sloOperations {
+		for _, window := range sloWindows {
+			report.Stats = append(report.Stats, t.statsLocked(operation, window.name, now))
+		}
+	}
+	for _, objective := range t.objectives {
+		reason := objective.check(t.statsLocked(objective.Operation, objective.Window, now))
+		report.Objectives = append(report.Objectives, SLOStatus{Objective: objective, Breached: reason != "", Reason: reason})
+	}
+	return report
+}
+
+func (t *sloTracker) listObjectives() []SLOObjective {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]SLOObjective{}, t.objectives...)
+}
+
+// setObjectives validates all objectives before replacing the current ones, objectives start out met
+func (t *sloTracker) setObjectives(objectives []SLOObjective) error {
+	seen := map[string]bool{}
+	for _, objective := range objectives {
+		if err := objective.validate(); err != nil {
+			return err
+		}
+		if seen[objective.key()] {
+			return fmt.Errorf("duplicate objective %s", objective.key())
+		}
+		seen[objective.key()] = true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.objectives = append([]SLOObjective{}, objectives...)
+	t.breached = map[string]string{}
+	return nil
+}
+
+// writeMetrics writes the stats and objectives in the Prometheus text format
+func (t *sloTracker) writeMetrics(w *strings.Builder) {
+	report := t.report()
+	gauge := func(name string, help string, write func()) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		write()
+	}
+	gauge("go_ios_operation_count", "Operations of the agent in the rolling window", func() {
+		for _, s := range report.Stats {
+			fmt.Fprintf(w, "go_ios_operation_count{operation=%q,window=%q} %d\n", s.Operation, s.Window, s.Count)
+		}
+	})
+	gauge("go_ios_operation_success_ratio", "Success rate of the operations in the rolling window", func() {
+		for _, s := range report.Stats {
+			fmt.Fprintf(w, "go_ios_operation_success_ratio{operation=%q,window=%q} %g\n", s.Operation, s.Window, s.SuccessRate)
+		}
+	})
+	gauge("go_ios_operation_latency_seconds", "Latency percentiles of the operations in the rolling window", func() {
+		for _, s := range report.Stats {
+			if s.Count == 0 {
+				continue
+			}
+			for _, q := range []struct {
+				quantile string
+				value    float64
+			}{{"0.5", s.LatencyP50}, {"0.9", s.LatencyP90}, {"0.99", s.LatencyP99}} {
+				fmt.Fprintf(w, "go_ios_operation_latency_seconds{operation=%q,window=%q,quantile=%q} %g\n", s.Operation, s.Window, q.quantile, q.value)
+			}
+		}
+	})
+	gauge("go_ios_slo_breached", "1 if the objective of the operation is breached", func() {
+		for _, s := range report.Objectives {
+			breached := 0
+			if s.Breached {
+				breached = 1
+			}
+			fmt.Fprintf(w, "go_ios_slo_breached{operation=%q,window=%q} %d\n", s.Objective.Operation, s.Objective.Window, breached)
+		}
+	})
+}
+
+// loadSLOObjectives applies the objectives configured with GO_IOS_SLOS
+func loadSLOObjectives() {
+	config := os.Getenv(slosEnvVar)
+	if config == "" {
+		return
+	}
+	var objectives []SLOObjective
+	err := json.Unmarshal([]byte(config), &objectives)
+	if err == nil {
+		err = slos.setObjectives(objectives)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("ignoring invalid %s", slosEnvVar)
+	}
+}
+
+// Get the SLO report
+// @Summary      Get the SLO report
+// @Description  Returns the success rate and the p50, p90 and p99 latency in seconds of pairing, installing, starting tests and taking screenshots over the last 5 minutes, hour and day, and whether the objectives are met.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  SLOReport
+// @Router       /slos [get]
+func GetSLOReport(c *gin.Context) {
+	c.JSON(http.StatusOK, slos.report())
+}
+
+// Get the SLO objectives
+// @Summary      Get the SLO objectives
+// @Description  Returns the alert thresholds of the operations
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  []SLOObjective
+// @Router       /config/slos [get]
+func GetSLOObjectives(c *gin.Context) {
+	c.JSON(http.StatusOK, slos.listObjectives())
+}
+
+// Change the SLO objectives
+// @Summary      Change the SLO objectives
+// @Description  Replaces the alert thresholds of the operations. The webhooks receive an slo-breached event when an objective is missed and slo-recovered once it is met again. Needs the admin token.
+// @Tags         general
+// @Accept       json
+// @Produce      json
+// @Param        objectives body []SLOObjective true "Objectives"
+// @Success      200  {object}  []SLOObjective
+// @Failure      401  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /config/slos [put]
+func SetSLOObjectives(c *gin.Context) {
+	var objectives []SLOObjective
+	err := c.ShouldBindJSON(&objectives)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	err = slos.setObjectives(objectives)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, slos.listObjectives())
+}
+
+// Metrics exposes the SLO stats for Prometheus
+func Metrics(c *gin.Context) {
+	var metrics strings.Builder
+	slos.writeMetrics(&metrics)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(metrics.String()))
+}
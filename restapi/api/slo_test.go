@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSLOStats(t *testing.T) {
+	tracker := newSLOTracker()
+	now := time.Date(2024, 1, 16, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+	failed := errors.New("failed")
+	for i := 1; i <= 10; i++ {
+		var err error
+		if i == 10 {
+			err = failed
+		}
+		tracker.observe(sloInstall, now.Add(-time.Duration(i)*time.Second), &err)
+	}
+	// an old sample only counts for the longer windows
+	tracker.samples[sloInstall][0].at = now.Add(-2 * time.Hour)
+
+	report := tracker.report()
+	byWindow := map[string]SLOStats{}
+	for _, stats := range report.Stats {
+		if stats.Operation == sloInstall {
+			byWindow[stats.Window] = stats
+		}
+	}
+	assert.Equal(t, SLOStats{Operation: sloInstall, Window: "24h", Count: 10, Failures: 1, SuccessRate: 0.9, LatencyP50: 5, LatencyP90: 9, LatencyP99: 10}, byWindow["24h"])
+	assert.Equal(t, 9, byWindow["1h"].Count)
+	assert.Equal(t, 6.0, byWindow["1h"].LatencyP50)
+	assert.Equal(t, SLOStats{Operation: sloPair, Window: "5m", SuccessRate: 1}, report.Stats[0])
+}
+
+func TestSLOObjectivesAlert(t *testing.T) {
+	tracker := newSLOTracker()
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+	require.NoError(t, tracker.setObjectives([]SLOObjective{{Operation: sloScreenshot, Window: "5m", MinSuccessRate: 0.9, MaxLatency: "2s", Percentile: 50, MinCount: 2}}))
+	subscription := history.bus.Subscribe(events.Filter{Prefixes: []string{"slo-"}}, 10)
+	defer subscription.Close()
+
+	failed := errors.New("failed")
+	tracker.observe(sloScreenshot, now, &failed)
+	assert.False(t, tracker.report().Objectives[0].Breached, "one sample is less than minCount")
+	tracker.observe(sloScreenshot, now, nil)
+	status := tracker.report().Objectives[0]
+	assert.True(t, status.Breached)
+	assert.Equal(t, "screenshot success rate 0.500 over 5m is below 0.900", status.Reason)
+	event := <-subscription.Events
+	assert.Equal(t, events.SLOBreached, event.Type)
+
+	for i := 0; i < 20; i++ {
+		tracker.observe(sloScreenshot, now, nil)
+	}
+	assert.False(t, tracker.report().Objectives[0].Breached)
+	event = <-subscription.Events
+	assert.Equal(t, events.SLORecovered, event.Type)
+
+	tracker.observe(sloScreenshot, now.Add(-time.Minute), nil)
+	tracker.observe(sloScreenshot, now.Add(-time.Minute), nil)
+	assert.False(t, tracker.report().Objectives[0].Breached, "the median latency is still 0")
+}
+
+func TestSetSLOObjectives(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := slos
+	slos = newSLOTracker()
+	defer func() { slos = original }()
+	r := gin.New()
+	r.PUT("/config/slos", SetSLOObjectives)
+	r.GET("/metrics", Metrics)
+	put := func(body string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/slos", strings.NewReader(body)))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, put(`[{"operation": "pair", "window": "1h", "minSuccessRate": 0.99}]`))
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`[{"operation": "reboot", "window": "1h", "minSuccessRate": 0.99}]`))
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`[{"operation": "pair", "window": "2h", "minSuccessRate": 0.99}]`))
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`[{"operation": "pair", "window": "1h"}]`))
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`[{"operation": "pair", "window": "1h", "maxLatency": "1s", "percentile": 75}]`))
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`[{"operation": "pair", "window": "1h", "minSuccessRate": 0.9}, {"operation": "pair", "window": "1h", "maxLatency": "1s"}]`))
+	assert.Len(t, slos.listObjectives(), 1)
+
+	var err error
+	slos.observe(sloPair, time.Now().Add(-1500*time.Millisecond), &err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), "# TYPE go_ios_operation_success_ratio gauge\n")
+	assert.Contains(t, w.Body.String(), `go_ios_operation_count{operation="pair",window="1h"} 1`+"\n")
+	assert.Contains(t, w.Body.String(), `go_ios_operation_success_ratio{operation="install",window="5m"} 1`+"\n")
+	assert.Contains(t, w.Body.String(), `go_ios_operation_latency_seconds{operation="pair",window="5m",quantile="0.99"} 1.5`)
+	assert.Contains(t, w.Body.String(), `go_ios_slo_breached{operation="pair",window="1h"} 0`+"\n")
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/springboard"
+	"github.com/gin-gonic/gin"
+)
+
+// wdaCall posts to a WDA endpoint that needs no WebDriver session and returns its value. WDA presses the
+// hardware buttons with IOHID events, which no lockdown service offers.
+var wdaCall = func(device ios.DeviceEntry, path string) (json.RawMessage, error) {
+	var value json.RawMessage
+	err := sessionPool.borrow(device, func(baseURL string) error {
+		resp, err := http.Post(baseURL+path, "application/json", nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var response struct {
+			Value json.RawMessage `json:"value"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&response)
+		if err != nil {
+			return fmt.Errorf("could not decode WDA response: %w", err)
+		}
+		var failure struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(response.Value, &failure) == nil && failure.Error != "" {
+			return fmt.Errorf("WDA returned %s: %s", failure.Error, failure.Message)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("WDA returned status %d", resp.StatusCode)
+		}
+		value = response.Value
+		return nil
+	})
+	return value, err
+}
+
+var getPasscodeState = ios.GetPasscodeState
+
+var getIconState = func(device ios.DeviceEntry) (springboard.IconState, error) {
+	conn, err := springboard.New(device)
+	if err != nil {
+		return springboard.IconState{}, err
+	}
+	defer conn.Close()
+	return conn.IconState()
+}
+
+var getWallpaper = func(device ios.DeviceEntry, name string) ([]byte, error) {
+	conn, err := springboard.New(device)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if name == springboard.HomeScreen {
+		png, err := conn.HomeScreenWallpaper()
+		if err == nil && len(png) > 0 {
+			return png, nil
+		}
+	}
+	return conn.WallpaperPreview(name)
+}
+
+// Press the home button
+// @Summary      Press the home button
+// @Description  Presses the home button with WebDriverAgent, the running WDA session of the device is used if there is one.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200 {object} GenericResponse
+// @Failure      409 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/home [post]
+func PressHome(c *gin.Context) {
+	device := MustGetDevice(c)
+	if occurrence, ok := maintenance.active(device.Properties.SerialNumber); ok {
+		c.JSON(http.StatusConflict, GenericResponse{Error: "device is in maintenance until " + occurrence.End.Format(time.RFC3339)})
+		return
+	}
+	_, err := wdaCall(device, "/wda/homescreen")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "home button pressed"})
+}
+
+// Unlock the device
+// @Summary      Unlock the device
+// @Description  Wakes the device and unlocks it with WebDriverAgent. Only devices without passcode can be unlocked.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200 {object} GenericResponse
+// @Failure      409 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/unlock [post]
+func Unlock(c *gin.Context) {
+	device := MustGetDevice(c)
+	if occurrence, ok := maintenance.active(device.Properties.SerialNumber); ok {
+		c.JSON(http.StatusConflict, GenericResponse{Error: "device is in maintenance until " + occurrence.End.Format(time.RFC3339)})
+		return
+	}
+	state, err := getPasscodeState(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	if state.PasswordProtected {
+		c.JSON(http.StatusConflict, GenericResponse{Error: "device has a passcode and can't be unlocked remotely"})
+		return
+	}
+	_, err = wdaCall(device, "/wda/unlock")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "device unlocked"})
+}
+
+// Get the wallpaper
+// @Summary      Get the wallpaper
+// @Description  Returns the home screen or lock screen wallpaper as png. Setting the wallpaper is not supported, springboardservices only reads it.
+// @Tags         general_device_specific
+// @Produce      png
+// @Param        udid path string true "Device UDID"
+// @Param        screen query string false "home (default) or lock"
+// @Success      200
+// @Failure      422 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/wallpaper [get]
+func GetWallpaper(c *gin.Context) {
+	device := MustGetDevice(c)
+	var name string
+	switch c.DefaultQuery("screen", "home") {
+	case "home":
+		name = springboard.HomeScreen
+	case "lock":
+		name = springboard.LockScreen
+	default:
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "screen must be home or lock"})
+		return
+	}
+	png, err := getWallpaper(device, name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// Get the home screen layout
+// @Summary      Get the home screen layout
+// @Description  Returns the apps in the dock and on every home screen page in the order they are shown, folders contain their pages of apps.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200 {object} springboard.IconState
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/iconstate [get]
+func GetIconState(c *gin.Context) {
+	device := MustGetDevice(c)
+	state, err := getIconState(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
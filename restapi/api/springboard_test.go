@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/springboard"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func springboardRouter() *gin.Engine {
+	r := gin.New()
+	group := r.Group("/device/:udid", DeviceMiddleware())
+	group.POST("/home", PressHome)
+	group.GET("/iconstate", GetIconState)
+	group.POST("/unlock", Unlock)
+	group.GET("/wallpaper", GetWallpaper)
+	return r
+}
+
+func TestPressHomeAndUnlock(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("springboard-a"))
+	defer devices.Remove("springboard-a")
+	originalCall, originalPasscode := wdaCall, getPasscodeState
+	t.Cleanup(func() { wdaCall, getPasscodeState = originalCall, originalPasscode })
+	var calls []string
+	wdaCall = func(device ios.DeviceEntry, path string) (json.RawMessage, error) {
+		calls = append(calls, path)
+		return nil, nil
+	}
+	protected := false
+	getPasscodeState = func(device ios.DeviceEntry) (ios.PasscodeState, error) {
+		return ios.PasscodeState{PasswordProtected: protected}, nil
+	}
+	r := springboardRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/springboard-a/home", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/springboard-a/unlock", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"/wda/homescreen", "/wda/unlock"}, calls)
+
+	protected = true
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/springboard-a/unlock", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Len(t, calls, 2, "devices with passcode are not unlocked")
+
+	wdaCall = func(device ios.DeviceEntry, path string) (json.RawMessage, error) {
+		return nil, errors.New("WDA did not start")
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/device/springboard-a/home", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestGetWallpaperAndIconState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("springboard-b"))
+	defer devices.Remove("springboard-b")
+	originalWallpaper, originalIconState := getWallpaper, getIconState
+	t.Cleanup(func() { getWallpaper, getIconState = originalWallpaper, originalIconState })
+	getWallpaper = func(device ios.DeviceEntry, name string) ([]byte, error) {
+		return []byte(name), nil
+	}
+	getIconState = func(device ios.DeviceEntry) (springboard.IconState, error) {
+		return springboard.IconState{Dock: []springboard.Icon{{BundleID: "com.apple.mobilesafari", DisplayName: "Safari"}}, Pages: [][]springboard.Icon{}}, nil
+	}
+	r := springboardRouter()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/springboard-b/wallpaper", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, springboard.HomeScreen, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/springboard-b/wallpaper?screen=lock", nil))
+	assert.Equal(t, springboard.LockScreen, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/springboard-b/wallpaper?screen=settings", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/device/springboard-b/iconstate", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var state springboard.IconState
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.Equal(t, "com.apple.mobilesafari", state.Dock[0].BundleID)
+}
@@ -19,7 +19,7 @@ import (
 // @Success      200  {object}  map[string]interface{}
 // @Router       /notifications [get]
 func Notifications(c *gin.Context) {
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 	listenerFunc, closeFunc, err := instruments.ListenAppStateNotifications(device)
 	if err != nil {
 		log.Fatal(err)
@@ -57,7 +57,7 @@ func Notifications(c *gin.Context) {
 func Syslog(c *gin.Context) {
 	// We are streaming current time to clients in the interval 10 seconds
 	log.Info("connect")
-	device := c.MustGet(IOS_KEY).(ios.DeviceEntry)
+	device := MustGetDevice(c)
 	syslogConnection, err := syslog.New(device)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err})
@@ -82,7 +82,7 @@ func Syslog(c *gin.Context) {
 func Listen(c *gin.Context) {
 	// We are streaming current time to clients in the interval 10 seconds
 	log.Info("connect")
-	a, _, _ := ios.Listen()
+	a, _, _ := usbmuxdListen()
 	c.Stream(func(w io.Writer) bool {
 		l, _ := a()
 		// Stream message to client from message channel
@@ -90,3 +90,27 @@ func Listen(c *gin.Context) {
 		return true
 	})
 }
+
+// ListenLifecycle streams device lifecycle events
+// Listen                godoc
+// @Summary      Stream device lifecycle state changes
+// @Description Streams a json object separated by line breaks whenever a device is attached, detached or enters or exits the recovery, restore, update or setup assistant state. Orchestration systems should not schedule jobs on devices that are not in the "normal" state. Devices in recovery or DFU mode are found by enumerating usb devices, which works on Linux and in builds with -tags libusb. Their events have the ECID, only A12 and newer devices have a UDID then.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  ios.LifecycleEvent
+// @Router       /lifecycle [get]
+func ListenLifecycle(c *gin.Context) {
+	events, err := ios.ListenLifecycle(c.Request.Context(), 0)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.Stream(func(w io.Writer) bool {
+		event, ok := <-events
+		if !ok {
+			return false
+		}
+		_, err := w.Write([]byte(MustMarshal(event) + "\n"))
+		return err == nil
+	})
+}
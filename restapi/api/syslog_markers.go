@@ -0,0 +1,25 @@
+package api
+
+import (
+	"github.com/danielpaulus/go-ios/ios/notificationproxy"
+	"github.com/danielpaulus/go-ios/ios/syslog"
+	log "github.com/sirupsen/logrus"
+)
+
+// postNotification posts the marker notifications, tests replace it
+var postNotification = notificationproxy.PostNotification
+
+// markSyslog writes a marker with the id of the job into the device log when a job starts and ends, so the log
+// of a job can be sliced with syslog.Slice no matter which tool collected it. Devices that are not in the registry
+// are skipped and failing to post a marker does not fail the job.
+func markSyslog(registry *DeviceRegistry, udid string, event string, job string, id string) {
+	device, ok := registry.Get(udid)
+	if !ok {
+		return
+	}
+	marker := syslog.Marker{Event: event, Job: job, ID: id}
+	err := postNotification(device, marker.Notification())
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{"udid": udid, "marker": marker.Notification()}).Debug("failed posting syslog marker")
+	}
+}
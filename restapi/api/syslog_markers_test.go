@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobsMarkTheSyslog(t *testing.T) {
+	original := postNotification
+	t.Cleanup(func() { postNotification = original })
+	var mu sync.Mutex
+	var posted []string
+	postNotification = func(device ios.DeviceEntry, notification string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		posted = append(posted, device.Properties.SerialNumber+" "+notification)
+		return errors.New("notification_proxy is not running")
+	}
+	registry := NewDeviceRegistry()
+	registry.Put(testDevice("marker-udid"))
+	store := newJobStore(registry)
+
+	var during []string
+	job := store.start("mount-image", "marker-udid", func(ctx context.Context) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		during = append(during, posted...)
+		return nil, nil
+	})
+	assert.Equal(t, JobSucceeded, waitForJob(t, store, job.ID).State, "failing to post markers does not fail jobs")
+	assert.Equal(t, []string{"marker-udid go-ios.marker.start.mount-image." + job.ID}, during)
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(posted) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "marker-udid go-ios.marker.end.mount-image."+job.ID, posted[1])
+
+	job = store.start("install", "unknown-udid", func(ctx context.Context) (interface{}, error) { return nil, nil })
+	waitForJob(t, store, job.ID)
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, posted, 2, "jobs of devices that are not connected are not marked")
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/danielpaulus/go-ios/ios/syslog"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// SyslogFilter selects the messages a syslog stream sends. Clients can replace the filter of a running stream
+// by sending a new one as a json text message.
+type SyslogFilter struct {
+	// Processes only lets messages of these processes pass, all processes pass if it is empty
+	Processes []string `json:"processes,omitempty"`
+	// Level is the lowest level that passes, like "Notice". All levels pass if it is empty.
+	Level    string `json:"level,omitempty"`
+	minLevel syslog.Level
+}
+
+func (f *SyslogFilter) compile() error {
+	f.minLevel = syslog.LevelDebug
+	if f.Level == "" {
+		return nil
+	}
+	level, err := syslog.ParseLevel(f.Level)
+	if err != nil {
+		return err
+	}
+	f.minLevel = level
+	return nil
+}
+
+func (f *SyslogFilter) empty() bool {
+	return len(f.Processes) == 0 && f.minLevel == syslog.LevelDebug
+}
+
+func (f *SyslogFilter) matches(msg syslog.Message) bool {
+	if msg.Level < f.minLevel {
+		return false
+	}
+	if len(f.Processes) == 0 {
+		return true
+	}
+	for _, process := range f.Processes {
+		if process == msg.Process {
+			return true
+		}
+	}
+	return false
+}
+
+// syslogFilterFromQuery reads the filter from the process and level query params
+func syslogFilterFromQuery(c *gin.Context) (SyslogFilter, error) {
+	filter := SyslogFilter{Level: c.Query("level")}
+	for _, processes := range c.QueryArray("process") {
+		for _, process := range strings.Split(processes, ",") {
+			if process != "" {
+				filter.Processes = append(filter.Processes, process)
+			}
+		}
+	}
+	return filter, filter.compile()
+}
+
+// syslogReader is implemented by syslog.Connection
+type syslogReader interface {
+	ReadLogMessage() (string, error)
+	io.Closer
+}
+
+// StreamSyslog streams the syslog of a device over a WebSocket
+// @Summary      Stream the syslog over a WebSocket
+// @Description  Upgrades to a WebSocket and sends every syslog message of the device as a json text message with timestamp, device, process, library, pid, level and message. Lines that can't be parsed only have message set and are only sent if no filter is set.
+// @Description  Filter by process names and minimum level with the query params or send a SyslogFilter as json text message to replace the filter of the running stream. Levels are Debug, Info, Notice, Warning, Error, Critical, Alert and Emergency.
+// @Tags         general_device_specific
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        process query string false "comma separated process names, f.ex. SpringBoard,kernel"
+// @Param        level query string false "lowest level to send, f.ex. Notice"
+// @Success      101  {object}  syslog.Message
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /device/{udid}/syslog/stream [get]
+func StreamSyslog(c *gin.Context) {
+	filter, err := syslogFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	device := MustGetDevice(c)
+	conn, err := syslog.New(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		streamSyslog(ws, conn, filter)
+	}}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// streamSyslog sends the messages of conn that pass the filter to ws until either of them is closed
+func streamSyslog(ws *websocket.Conn, conn syslogReader, filter SyslogFilter) {
+	defer ws.Close()
+	defer conn.Close()
+	var current atomic.Pointer[SyslogFilter]
+	current.Store(&filter)
+	logger := log.WithField("remote", ws.Request().RemoteAddr)
+
+	go func() {
+		// ends the stream when the client goes away, reads fail once the connection is closed
+		defer conn.Close()
+		for {
+			var update SyslogFilter
+			err := websocket.JSON.Receive(ws, &update)
+			if err != nil {
+				if err != io.EOF {
+					logger.WithError(err).Debug("syslog stream closed")
+				}
+				return
+			}
+			err = update.compile()
+			if err != nil {
+				logger.WithError(err).Warn("ignoring invalid syslog filter")
+				continue
+			}
+			current.Store(&update)
+		}
+	}()
+
+	for {
+		line, err := conn.ReadLogMessage()
+		if err != nil {
+			return
+		}
+		filter := current.Load()
+		msg, ok := syslog.Parse(line)
+		if !ok {
+			if !filter.empty() {
+				continue
+			}
+			msg = syslog.Message{Text: strings.TrimRight(line, "\x00\n")}
+		} else if !filter.matches(msg) {
+			continue
+		}
+		err = websocket.JSON.Send(ws, msg)
+		if err != nil {
+			return
+		}
+	}
+}
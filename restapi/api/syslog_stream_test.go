@@ -0,0 +1,109 @@
+package api
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/syslog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+type fakeSyslog struct {
+	lines  chan string
+	closed chan struct{}
+}
+
+func newFakeSyslog() *fakeSyslog {
+	return &fakeSyslog{lines: make(chan string, 10), closed: make(chan struct{})}
+}
+
+func (f *fakeSyslog) ReadLogMessage() (string, error) {
+	select {
+	case line := <-f.lines:
+		return line, nil
+	case <-f.closed:
+		return "", io.EOF
+	}
+}
+
+func (f *fakeSyslog) Close() error {
+	select {
+	case <-f.closed:
+	default:
+		close(f.closed)
+	}
+	return nil
+}
+
+func dialSyslogStream(t *testing.T, reader *fakeSyslog, filter SyslogFilter) *websocket.Conn {
+	require.NoError(t, filter.compile())
+	server := httptest.NewServer(websocket.Server{Handler: func(ws *websocket.Conn) {
+		streamSyslog(ws, reader, filter)
+	}})
+	t.Cleanup(server.Close)
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", server.URL)
+	require.NoError(t, err)
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func receiveSyslog(t *testing.T, ws *websocket.Conn) syslog.Message {
+	var msg syslog.Message
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	require.NoError(t, websocket.JSON.Receive(ws, &msg))
+	return msg
+}
+
+func TestStreamSyslogFilters(t *testing.T) {
+	reader := newFakeSyslog()
+	ws := dialSyslogStream(t, reader, SyslogFilter{Processes: []string{"SpringBoard"}, Level: "notice"})
+
+	reader.lines <- "Oct 17 12:00:00 iPhone kernel[0] <Error>: other process\x00"
+	reader.lines <- "Oct 17 12:00:01 iPhone SpringBoard[58] <Info>: too verbose\x00"
+	reader.lines <- "garbage\x00"
+	reader.lines <- "Oct 17 12:00:02 iPhone SpringBoard(FrontBoard)[58] <Notice>: passes\x00"
+	msg := receiveSyslog(t, ws)
+	assert.Equal(t, "passes", msg.Text)
+	assert.Equal(t, "FrontBoard", msg.Library)
+	assert.Equal(t, "Notice", msg.LevelName)
+
+	// replace the filter while streaming
+	require.NoError(t, websocket.JSON.Send(ws, SyslogFilter{Processes: []string{"kernel"}}))
+	assert.Eventually(t, func() bool {
+		reader.lines <- "Oct 17 12:00:03 iPhone kernel[0] <Debug>: kernel now\x00"
+		var msg syslog.Message
+		ws.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		return websocket.JSON.Receive(ws, &msg) == nil && msg.Process == "kernel"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestStreamSyslogSendsUnparsedLinesWithoutFilter(t *testing.T) {
+	reader := newFakeSyslog()
+	ws := dialSyslogStream(t, reader, SyslogFilter{})
+	reader.lines <- "garbage\x00"
+	assert.Equal(t, syslog.Message{Text: "garbage"}, receiveSyslog(t, ws))
+}
+
+func TestStreamSyslogClosesSyslogWhenClientLeaves(t *testing.T) {
+	reader := newFakeSyslog()
+	ws := dialSyslogStream(t, reader, SyslogFilter{})
+	ws.Close()
+	select {
+	case <-reader.closed:
+	case <-time.After(time.Second):
+		t.Fatal("syslog connection was not closed")
+	}
+}
+
+func TestSyslogFilterCompile(t *testing.T) {
+	filter := SyslogFilter{Level: "loud"}
+	assert.Error(t, filter.compile())
+	filter = SyslogFilter{}
+	assert.NoError(t, filter.compile())
+	assert.True(t, filter.empty())
+}
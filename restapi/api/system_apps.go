@@ -0,0 +1,282 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/installationproxy"
+	"github.com/danielpaulus/go-ios/ios/mcinstall"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// supervisionP12EnvVar is the path of the p12 file with the supervision identity of the devices.
+	// Management payloads that need supervised devices are disabled if it is not set.
+	supervisionP12EnvVar         = "GO_IOS_SUPERVISION_P12"
+	supervisionP12PasswordEnvVar = "GO_IOS_SUPERVISION_P12_PASSWORD"
+)
+
+// SystemApp is a built-in app of a device
+type SystemApp struct {
+	BundleID  string `json:"bundleId"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Removable bool   `json:"removable"`
+	Hidden    bool   `json:"hidden"`
+}
+
+// HiddenAppsRequest contains the bundle ids of the removable system apps that should be hidden.
+// Apps hidden before that are not in the list are shown again.
+type HiddenAppsRequest struct {
+	BundleIDs []string `json:"bundleIds"`
+}
+
+// hiddenAppsTracker remembers which apps were hidden on which device, the restrictions profile
+// can't be read back from the device
+type hiddenAppsTracker struct {
+	mu   sync.Mutex
+	apps map[string][]string
+}
+
+var hiddenApps = &hiddenAppsTracker{apps: map[string][]string{}}
+
+func (h *hiddenAppsTracker) get(udid string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.apps[udid]
+}
+
+func (h *hiddenAppsTracker) set(udid string, bundleIDs []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(bundleIDs) == 0 {
+		delete(h.apps, udid)
+		return
+	}
+	h.apps[udid] = bundleIDs
+}
+
+func (h *hiddenAppsTracker) isHidden(udid string, bundleID string) bool {
+	for _, hidden := range h.get(udid) {
+		if hidden == bundleID {
+			return true
+		}
+	}
+	return false
+}
+
+// supervisionIdentity reads the p12 file configured with GO_IOS_SUPERVISION_P12. The p12 and the password can be sealed
+// with 'ios secrets seal' and 'ios secrets seal-value'.
+func supervisionIdentity() ([]byte, string, error) {
+	path := os.Getenv(supervisionP12EnvVar)
+	if path == "" {
+		return nil, "", fmt.Errorf("no supervision identity configured, set %s to the p12 file of the supervision identity", supervisionP12EnvVar)
+	}
+	p12, err := sealedSecrets.readFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("supervisionIdentity: failed reading %s: %w", path, err)
+	}
+	password, err := sealedSecrets.env(supervisionP12PasswordEnvVar)
+	if err != nil {
+		return nil, "", fmt.Errorf("supervisionIdentity: %w", err)
+	}
+	return p12, password, nil
+}
+
+// validateHiddenApps dedupes and sorts bundleIDs and makes sure only removable system apps get hidden
+func validateHiddenApps(bundleIDs []string) ([]string, error) {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, bundleID := range bundleIDs {
+		if !installationproxy.IsRemovableSystemApp(bundleID) {
+			return nil, fmt.Errorf("%s is not a removable system app", bundleID)
+		}
+		if !seen[bundleID] {
+			seen[bundleID] = true
+			result = append(result, bundleID)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func hideApps(device ios.DeviceEntry, bundleIDs []string, p12 []byte, password string) error {
+	err := mcinstall.HideApps(device, bundleIDs, p12, password)
+	if err != nil {
+		return err
+	}
+	hiddenApps.set(device.Properties.SerialNumber, bundleIDs)
+	return nil
+}
+
+// List the system apps of a device
+// @Summary      List system apps
+// @Description  Lists the built-in apps of a device, whether they can be removed and whether they were hidden by go-ios.
+// @Tags         apps
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200 {object} []SystemApp
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/apps/system [get]
+func ListSystemApps(c *gin.Context) {
+	device := MustGetDevice(c)
+	svc, err := installationproxy.New(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer svc.Close()
+	apps, err := svc.BrowseSystemApps()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	udid := device.Properties.SerialNumber
+	result := make([]SystemApp, len(apps))
+	for i, app := range apps {
+		name := app.CFBundleDisplayName
+		if name == "" {
+			name = app.CFBundleName
+		}
+		result[i] = SystemApp{
+			BundleID:  app.CFBundleIdentifier,
+			Name:      name,
+			Version:   app.CFBundleShortVersionString,
+			Removable: installationproxy.IsRemovableSystemApp(app.CFBundleIdentifier),
+			Hidden:    hiddenApps.isHidden(udid, app.CFBundleIdentifier),
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].BundleID < result[j].BundleID })
+	c.IndentedJSON(http.StatusOK, result)
+}
+
+// Remove a removable system app
+// @Summary      Remove a system app
+// @Description  Removes a built-in app that users can delete as well, like Stocks or Tips. It can be installed again from the App Store.
+// @Tags         apps
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        bundleID path string true "bundle identifier of the system app"
+// @Success      200 {object} GenericResponse
+// @Failure      422 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/apps/system/{bundleID} [delete]
+func RemoveSystemApp(c *gin.Context) {
+	device := MustGetDevice(c)
+	bundleID := c.Param("bundleID")
+	if !installationproxy.IsRemovableSystemApp(bundleID) {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: bundleID + " is not a removable system app"})
+		return
+	}
+	svc, err := installationproxy.New(device)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer svc.Close()
+	err = svc.Uninstall(bundleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: bundleID + " removed"})
+}
+
+// Hide removable system apps on a device
+// @Summary      Hide system apps
+// @Description  Hides removable system apps on a supervised device with a restrictions payload. The list replaces the apps hidden before, an empty list shows all apps again. Needs the supervision identity configured with GO_IOS_SUPERVISION_P12.
+// @Tags         apps
+// @Accept       json
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        apps body HiddenAppsRequest true "Apps to hide"
+// @Success      200 {object} GenericResponse
+// @Failure      412 {object} GenericResponse
+// @Failure      422 {object} GenericResponse
+// @Failure      500 {object} GenericResponse
+// @Router       /device/{udid}/apps/hidden [put]
+func SetHiddenApps(c *gin.Context) {
+	device := MustGetDevice(c)
+	bundleIDs, ok := bindHiddenApps(c)
+	if !ok {
+		return
+	}
+	p12, password, err := supervisionIdentity()
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, GenericResponse{Error: err.Error()})
+		return
+	}
+	err = hideApps(device, bundleIDs, p12, password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: fmt.Sprintf("%d apps hidden", len(bundleIDs))})
+}
+
+// Hide removable system apps on all devices
+// @Summary      Hide system apps fleet-wide
+// @Description  Hides removable system apps on all devices or all devices with a label, like PUT /device/{udid}/apps/hidden. Returns the error for every device where hiding failed.
+// @Tags         apps
+// @Accept       json
+// @Produce      json
+// @Param        label query string false "only devices with this label"
+// @Param        apps body HiddenAppsRequest true "Apps to hide"
+// @Success      200 {object} map[string]string
+// @Failure      412 {object} GenericResponse
+// @Failure      422 {object} GenericResponse
+// @Router       /devices/apps/hidden [put]
+func SetFleetHiddenApps(c *gin.Context) {
+	bundleIDs, ok := bindHiddenApps(c)
+	if !ok {
+		return
+	}
+	p12, password, err := supervisionIdentity()
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, GenericResponse{Error: err.Error()})
+		return
+	}
+	label := c.Query("label")
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	result := map[string]string{}
+	devices.Range(func(device ios.DeviceEntry) bool {
+		udid := device.Properties.SerialNumber
+		if label != "" && !devices.HasLabel(udid, label) {
+			return true
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			message := "ok"
+			if err := hideApps(device, bundleIDs, p12, password); err != nil {
+				message = err.Error()
+			}
+			mu.Lock()
+			result[udid] = message
+			mu.Unlock()
+		}()
+		return true
+	})
+	wg.Wait()
+	c.JSON(http.StatusOK, result)
+}
+
+func bindHiddenApps(c *gin.Context) ([]string, bool) {
+	var request HiddenAppsRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return nil, false
+	}
+	bundleIDs, err := validateHiddenApps(request.BundleIDs)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return nil, false
+	}
+	return bundleIDs, true
+}
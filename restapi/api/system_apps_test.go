@@ -0,0 +1,30 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateHiddenApps(t *testing.T) {
+	bundleIDs, err := validateHiddenApps([]string{"com.apple.tips", "com.apple.stocks", "com.apple.tips"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"com.apple.stocks", "com.apple.tips"}, bundleIDs)
+
+	_, err = validateHiddenApps([]string{"com.apple.Preferences"})
+	assert.Error(t, err)
+
+	bundleIDs, err = validateHiddenApps(nil)
+	require.NoError(t, err)
+	assert.Empty(t, bundleIDs)
+}
+
+func TestHiddenAppsTracker(t *testing.T) {
+	tracker := &hiddenAppsTracker{apps: map[string][]string{}}
+	tracker.set("udid", []string{"com.apple.stocks"})
+	assert.True(t, tracker.isHidden("udid", "com.apple.stocks"))
+	assert.False(t, tracker.isHidden("udid", "com.apple.tips"))
+	tracker.set("udid", nil)
+	assert.False(t, tracker.isHidden("udid", "com.apple.stocks"))
+}
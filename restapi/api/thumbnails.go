@@ -0,0 +1,316 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/screenstream"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// thumbnailsEnvVar holds the ThumbnailConfig as json
+	thumbnailsEnvVar = "GO_IOS_THUMBNAILS"
+	// thumbnailTick is how often the thumbnailer checks which devices are due for a new thumbnail
+	thumbnailTick = time.Second
+
+	thumbnailDefaultInterval      = 30 * time.Second
+	thumbnailMinInterval          = 2 * time.Second
+	thumbnailDefaultWidth         = 240
+	thumbnailDefaultMaxConcurrent = 4
+)
+
+// ThumbnailConfig sets how often and how large the thumbnails of the devices are captured
+type ThumbnailConfig struct {
+	// Disabled stops capturing thumbnails, the last ones are still served
+	Disabled        bool `json:"disabled,omitempty"`
+	IntervalSeconds int  `json:"intervalSeconds"`
+	// Width of the thumbnails in pixels, the height keeps the aspect ratio of the screen
+	Width int `json:"width"`
+	// Quality of the jpeg, from 1 to 100
+	Quality int `json:"quality"`
+	// MaxConcurrent limits how many screenshots are taken at the same time, so large labs don't get load spikes
+	MaxConcurrent int `json:"maxConcurrent"`
+}
+
+// normalize fills in the defaults and validates the config
+func (c *ThumbnailConfig) normalize() error {
+	if c.IntervalSeconds == 0 {
+		c.IntervalSeconds = int(thumbnailDefaultInterval / time.Second)
+	}
+	if c.Width == 0 {
+		c.Width = thumbnailDefaultWidth
+	}
+	if c.Quality == 0 {
+		c.Quality = defaultJPEGQuality
+	}
+	if c.MaxConcurrent == 0 {
+		c.MaxConcurrent = thumbnailDefaultMaxConcurrent
+	}
+	if c.interval() < thumbnailMinInterval {
+		return fmt.Errorf("intervalSeconds must be at least %d", int(thumbnailMinInterval/time.Second))
+	}
+	if c.Width < 1 {
+		return errors.New("width must be positive")
+	}
+	if c.Quality < 1 || c.Quality > 100 {
+		return errors.New("quality must be a number from 1 to 100")
+	}
+	if c.MaxConcurrent < 1 {
+		return errors.New("maxConcurrent must be positive")
+	}
+	return nil
+}
+
+func (c ThumbnailConfig) interval() time.Duration {
+	return time.Duration(c.IntervalSeconds) * time.Second
+}
+
+// Thumbnail describes the last thumbnail captured of a device
+type Thumbnail struct {
+	UDID       string    `json:"udid"`
+	CapturedAt time.Time `json:"capturedAt"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	// Error is why the last capture failed, the previous thumbnail is kept then
+	Error string `json:"error,omitempty"`
+	jpeg  []byte
+}
+
+type deviceThumbnail struct {
+	thumbnail Thumbnail
+	attempted time.Time
+	capturing bool
+}
+
+// thumbnailer keeps a recent, downscaled screenshot of every device in memory, so dashboards can show all
+// devices on every page load without connecting to them
+type thumbnailer struct {
+	mu        sync.Mutex
+	config    ThumbnailConfig
+	devices   map[string]*deviceThumbnail
+	capturing int
+	now       func() time.Time
+}
+
+// thumbnailScreenshot takes the screenshots for the thumbnails, tests replace it
+var thumbnailScreenshot = captureScreen
+
+var thumbnails = newThumbnailer()
+
+func newThumbnailer() *thumbnailer {
+	config := ThumbnailConfig{}
+	_ = config.normalize()
+	return &thumbnailer{config: config, devices: map[string]*deviceThumbnail{}, now: time.Now}
+}
+
+func (t *thumbnailer) getConfig() ThumbnailConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.config
+}
+
+func (t *thumbnailer) setConfig(config ThumbnailConfig) (ThumbnailConfig, error) {
+	err := config.normalize()
+	if err != nil {
+		return ThumbnailConfig{}, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.config = config
+	return config, nil
+}
+
+func (t *thumbnailer) get(udid string) (Thumbnail, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.devices[udid]
+	if !ok {
+		return Thumbnail{}, false
+	}
+	return d.thumbnail, true
+}
+
+// run starts capturing thumbnails of the devices that are due every thumbnailTick, until ctx is done
+func (t *thumbnailer) run(ctx context.Context, registry *DeviceRegistry) {
+	ticker := time.NewTicker(thumbnailTick)
+	defer ticker.Stop()
+	for {
+		connected := map[string]bool{}
+		registry.Range(func(device ios.DeviceEntry) bool {
+			connected[device.Properties.SerialNumber] = true
+			t.check(device)
+			return true
+		})
+		t.prune(connected)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check starts capturing a thumbnail if the last attempt is older than the interval. Devices wait for the next
+// tick if MaxConcurrent captures are running.
+func (t *thumbnailer) check(device ios.DeviceEntry) {
+	udid := device.Properties.SerialNumber
+	if _, ok := maintenance.active(udid); ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	config := t.config
+	if config.Disabled || t.capturing >= config.MaxConcurrent {
+		return
+	}
+	d, ok := t.devices[udid]
+	if !ok {
+		d = &deviceThumbnail{thumbnail: Thumbnail{UDID: udid}}
+		t.devices[udid] = d
+	}
+	now := t.now()
+	if d.capturing || now.Sub(d.attempted) < config.interval() {
+		return
+	}
+	d.capturing = true
+	d.attempted = now
+	t.capturing++
+	go t.capture(device, d, config)
+}
+
+func (t *thumbnailer) capture(device ios.DeviceEntry, d *deviceThumbnail, config ThumbnailConfig) {
+	var jpeg []byte
+	var width, height int
+	raw, err := thumbnailScreenshot(device)
+	if err == nil {
+		jpeg, width, height, err = encodeThumbnail(raw, config.Width, config.Quality)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d.capturing = false
+	t.capturing--
+	if err != nil {
+		log.WithField("udid", d.thumbnail.UDID).WithError(err).Debug("thumbnail capture failed")
+		d.thumbnail.Error = err.Error()
+		return
+	}
+	d.thumbnail = Thumbnail{UDID: d.thumbnail.UDID, CapturedAt: t.now(), Width: width, Height: height, jpeg: jpeg}
+}
+
+// prune forgets the thumbnails of disconnected devices
+func (t *thumbnailer) prune(connected map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for udid, d := range t.devices {
+		if !connected[udid] && !d.capturing {
+			delete(t.devices, udid)
+		}
+	}
+}
+
+// encodeThumbnail scales a png screenshot down to width and encodes it as jpeg. Screenshots narrower than width
+// are not scaled up.
+func encodeThumbnail(raw []byte, width int, quality int) ([]byte, int, int, error) {
+	screen, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("encodeThumbnail: failed decoding screenshot: %w", err)
+	}
+	scale := math.Min(1, float64(width)/float64(screen.Width))
+	jpeg, err := screenstream.EncodeJPEG(raw, scale, quality)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("encodeThumbnail: %w", err)
+	}
+	scaledWidth := int(math.Max(1, math.Round(float64(screen.Width)*scale)))
+	scaledHeight := int(math.Max(1, math.Round(float64(screen.Height)*scale)))
+	return jpeg, scaledWidth, scaledHeight, nil
+}
+
+func loadThumbnailConfig() {
+	config := os.Getenv(thumbnailsEnvVar)
+	if config == "" {
+		return
+	}
+	var thumbnailConfig ThumbnailConfig
+	err := json.Unmarshal([]byte(config), &thumbnailConfig)
+	if err == nil {
+		_, err = thumbnails.setConfig(thumbnailConfig)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("ignoring invalid %s", thumbnailsEnvVar)
+	}
+}
+
+// Get the thumbnail of a device
+// @Summary      Get the thumbnail of a device
+// @Description  Returns the last downscaled screenshot of the device as jpeg without connecting to it, so dashboards can load it as often as they like. Thumbnails of all connected devices are refreshed in the background every 30 seconds by default. Supports If-Modified-Since, the X-Thumbnail-Error header tells why the last refresh failed.
+// @Tags         general_device_specific
+// @Produce      jpeg
+// @Param        udid path string true "Device UDID"
+// @Success      200  {file}    file
+// @Success      304
+// @Failure      404  {object}  GenericResponse
+// @Router       /device/{udid}/thumbnail [get]
+func GetThumbnail(c *gin.Context) {
+	thumbnail, ok := thumbnails.get(c.Param("udid"))
+	if !ok || thumbnail.jpeg == nil {
+		message := "no thumbnail captured yet"
+		if thumbnail.Error != "" {
+			message += ": " + thumbnail.Error
+		}
+		c.JSON(http.StatusNotFound, GenericResponse{Error: message})
+		return
+	}
+	if thumbnail.Error != "" {
+		c.Header("X-Thumbnail-Error", thumbnail.Error)
+	}
+	c.Header("Cache-Control", "no-cache")
+	http.ServeContent(c.Writer, c.Request, "thumbnail.jpg", thumbnail.CapturedAt, bytes.NewReader(thumbnail.jpeg))
+}
+
+// Get the thumbnail config
+// @Summary      Get the thumbnail config
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  ThumbnailConfig
+// @Router       /config/thumbnails [get]
+func GetThumbnailConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, thumbnails.getConfig())
+}
+
+// Change the thumbnail config
+// @Summary      Change the thumbnail config
+// @Description  Sets how often thumbnails are refreshed, 30 seconds by default and at least 2, their width in pixels, 240 by default, the jpeg quality and how many screenshots may be taken at the same time, 4 by default. Needs the admin token.
+// @Tags         general
+// @Accept       json
+// @Produce      json
+// @Param        config body ThumbnailConfig true "Thumbnail config"
+// @Success      200  {object}  ThumbnailConfig
+// @Failure      401  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /config/thumbnails [put]
+func SetThumbnailConfig(c *gin.Context) {
+	var config ThumbnailConfig
+	err := c.ShouldBindJSON(&config)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	config, err = thumbnails.setConfig(config)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, config)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThumbnailsAreRefreshedInTheInterval(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	screenshot := testScreenshot(t)
+	var captures atomic.Int32
+	var fail atomic.Bool
+	originalScreenshot, originalThumbnails := thumbnailScreenshot, thumbnails
+	t.Cleanup(func() { thumbnailScreenshot, thumbnails = originalScreenshot, originalThumbnails })
+	thumbnailScreenshot = func(device ios.DeviceEntry) ([]byte, error) {
+		captures.Add(1)
+		if fail.Load() {
+			return nil, errors.New("screenshotr is gone")
+		}
+		return screenshot, nil
+	}
+	thumbnails = newThumbnailer()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	thumbnails.now = func() time.Time { return now }
+	_, err := thumbnails.setConfig(ThumbnailConfig{Width: 20})
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.GET("/device/:udid/thumbnail", GetThumbnail)
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/device/thumbnail-udid/thumbnail", nil)
+		for key := range header {
+			req.Header.Set(key, header.Get(key))
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusNotFound, get(nil).Code)
+
+	device := testDevice("thumbnail-udid")
+	capture := func() Thumbnail {
+		thumbnails.check(device)
+		var thumbnail Thumbnail
+		require.Eventually(t, func() bool {
+			thumbnails.mu.Lock()
+			defer thumbnails.mu.Unlock()
+			d := thumbnails.devices["thumbnail-udid"]
+			thumbnail = d.thumbnail
+			return !d.capturing
+		}, time.Second, time.Millisecond)
+		return thumbnail
+	}
+	thumbnail := capture()
+	assert.Equal(t, now, thumbnail.CapturedAt)
+	assert.Equal(t, 20, thumbnail.Width)
+	assert.Equal(t, 40, thumbnail.Height, "the aspect ratio is kept")
+	w := get(nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+	config, format, err := image.DecodeConfig(bytes.NewReader(w.Body.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 20, config.Width)
+	assert.Equal(t, http.StatusNotModified, get(http.Header{"If-Modified-Since": {now.Format(http.TimeFormat)}}).Code)
+
+	capture()
+	assert.Equal(t, int32(1), captures.Load(), "devices are captured at most once per interval")
+
+	now = now.Add(thumbnailDefaultInterval)
+	fail.Store(true)
+	thumbnail = capture()
+	assert.Equal(t, int32(2), captures.Load())
+	assert.Equal(t, "screenshotr is gone", thumbnail.Error)
+	w = get(nil)
+	assert.Equal(t, http.StatusOK, w.Code, "the last thumbnail is kept")
+	assert.Equal(t, "screenshotr is gone", w.Header().Get("X-Thumbnail-Error"))
+
+	thumbnails.prune(map[string]bool{})
+	assert.Equal(t, http.StatusNotFound, get(nil).Code)
+}
+
+func TestThumbnailCapturesAreLimited(t *testing.T) {
+	original := thumbnailScreenshot
+	t.Cleanup(func() { thumbnailScreenshot = original })
+	release := make(chan struct{})
+	thumbnailScreenshot = func(device ios.DeviceEntry) ([]byte, error) {
+		<-release
+		return nil, errors.New("released")
+	}
+	th := newThumbnailer()
+	_, err := th.setConfig(ThumbnailConfig{MaxConcurrent: 2})
+	require.NoError(t, err)
+	for _, udid := range []string{"a", "b", "c"} {
+		th.check(testDevice(udid))
+	}
+	th.mu.Lock()
+	assert.Equal(t, 2, th.capturing)
+	assert.NotContains(t, th.devices, "c", "c waits for the next tick")
+	th.mu.Unlock()
+	close(release)
+	assert.Eventually(t, func() bool {
+		th.mu.Lock()
+		defer th.mu.Unlock()
+		return th.capturing == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestSetThumbnailConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := thumbnails
+	thumbnails = newThumbnailer()
+	defer func() { thumbnails = original }()
+	r := gin.New()
+	r.PUT("/config/thumbnails", SetThumbnailConfig)
+	put := func(body string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/config/thumbnails", strings.NewReader(body)))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`{"intervalSeconds": 1}`))
+	assert.Equal(t, http.StatusUnprocessableEntity, put(`{"quality": 101}`))
+	assert.Equal(t, http.StatusOK, put(`{"intervalSeconds": 5, "width": 120}`))
+	assert.Equal(t, ThumbnailConfig{IntervalSeconds: 5, Width: 120, Quality: defaultJPEGQuality, MaxConcurrent: thumbnailDefaultMaxConcurrent}, thumbnails.getConfig())
+}
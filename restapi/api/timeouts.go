@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// timeoutsEnvVar overrides timeout policies on startup with the same JSON that PUT /config/timeouts accepts,
+// f.ex. {"install": {"timeout": "20m"}, "service-start": {"timeout": "30s", "retries": 3, "backoff": "2s"}}
+const timeoutsEnvVar = "GO_IOS_TIMEOUTS"
+
+// TimeoutPolicy is the timeout and retry policy of an operation class. Durations use the Go duration format like "1m30s".
+type TimeoutPolicy struct {
+	Timeout string `json:"timeout"`
+	Retries int    `json:"retries"`
+	Backoff string `json:"backoff"`
+}
+
+func timeoutPolicies() map[ios.Operation]TimeoutPolicy {
+	result := map[ios.Operation]TimeoutPolicy{}
+	for op, policy := range ios.Policies() {
+		result[op] = TimeoutPolicy{Timeout: policy.Timeout.String(), Retries: policy.Retries, Backoff: policy.Backoff.String()}
+	}
+	return result
+}
+
+// applyTimeoutPolicies validates all policies before applying any of them. Policies of operations that are
+// not contained keep their current value.
+func applyTimeoutPolicies(overrides map[ios.Operation]TimeoutPolicy) error {
+	parsed := map[ios.Operation]ios.Policy{}
+	for op, override := range overrides {
+		policy := ios.PolicyFor(op)
+		policy.Retries = override.Retries
+		var err error
+		if override.Timeout != "" {
+			if policy.Timeout, err = time.ParseDuration(override.Timeout); err != nil {
+				return fmt.Errorf("invalid timeout of '%s': %w", op, err)
+			}
+		}
+		if override.Backoff != "" {
+			if policy.Backoff, err = time.ParseDuration(override.Backoff); err != nil {
+				return fmt.Errorf("invalid backoff of '%s': %w", op, err)
+			}
+		}
+		parsed[op] = policy
+	}
+	current := ios.Policies()
+	for op, policy := range parsed {
+		if err := ios.SetPolicy(op, policy); err != nil {
+			for op, policy := range current {
+				ios.SetPolicy(op, policy)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTimeoutPolicies applies the policies configured with GO_IOS_TIMEOUTS
+func loadTimeoutPolicies() {
+	config := os.Getenv(timeoutsEnvVar)
+	if config == "" {
+		return
+	}
+	var overrides map[ios.Operation]TimeoutPolicy
+	err := json.Unmarshal([]byte(config), &overrides)
+	if err == nil {
+		err = applyTimeoutPolicies(overrides)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("ignoring invalid %s", timeoutsEnvVar)
+	}
+}
+
+// Get the timeout policies
+// @Summary      Get the timeout policies
+// @Description  Returns the timeout and retry policy of every operation class: pairing, install, service-start, dtx-request and screenshot.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  map[string]TimeoutPolicy
+// @Router       /config/timeouts [get]
+func GetTimeoutPolicies(c *gin.Context) {
+	c.JSON(http.StatusOK, timeoutPolicies())
+}
+
+// Change the timeout policies
+// @Summary      Change the timeout policies
+// @Description  Overrides the timeout and retry policies of the given operation classes, f.ex. to allow longer timeouts for devices behind congested USB hubs. Operation classes that are not contained keep their policy. Needs the admin token.
+// @Tags         general
+// @Accept       json
+// @Produce      json
+// @Param        policies body map[string]TimeoutPolicy true "Policies by operation class"
+// @Success      200  {object}  map[string]TimeoutPolicy
+// @Failure      401  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /config/timeouts [put]
+func SetTimeoutPolicies(c *gin.Context) {
+	var overrides map[ios.Operation]TimeoutPolicy
+	err := c.ShouldBindJSON(&overrides)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	err = applyTimeoutPolicies(overrides)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, timeoutPolicies())
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyTimeoutPolicies(t *testing.T) {
+	defer ios.ResetPolicies()
+	err := applyTimeoutPolicies(map[ios.Operation]TimeoutPolicy{
+		ios.OperationInstall:      {Timeout: "20m"},
+		ios.OperationServiceStart: {Timeout: "30s", Retries: 3, Backoff: "2s"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 20*time.Minute, ios.PolicyFor(ios.OperationInstall).Timeout)
+	assert.Equal(t, ios.Policy{Timeout: 30 * time.Second, Retries: 3, Backoff: 2 * time.Second}, ios.PolicyFor(ios.OperationServiceStart))
+	assert.Equal(t, "20m0s", timeoutPolicies()[ios.OperationInstall].Timeout)
+}
+
+func TestApplyTimeoutPoliciesIsAtomic(t *testing.T) {
+	defer ios.ResetPolicies()
+	before := ios.Policies()
+	assert.Error(t, applyTimeoutPolicies(map[ios.Operation]TimeoutPolicy{ios.OperationInstall: {Timeout: "20m"}, "unknown": {}}))
+	assert.Error(t, applyTimeoutPolicies(map[ios.Operation]TimeoutPolicy{ios.OperationInstall: {Timeout: "soon"}}))
+	assert.Error(t, applyTimeoutPolicies(map[ios.Operation]TimeoutPolicy{ios.OperationInstall: {Retries: -1}}))
+	assert.Equal(t, before, ios.Policies())
+}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/tunnel"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// tunnelsEnvVar holds the TunnelConfig as json
+	tunnelsEnvVar = "GO_IOS_TUNNELS"
+	// tunnelTick is how often tunnels are started for new devices, like 'ios tunnel start' does
+	tunnelTick = time.Second
+	// tunnelCheckInterval is how often established tunnels are checked with an RSD handshake
+	tunnelCheckInterval = 30 * time.Second
+)
+
+// the modes of the tunnel manager
+const (
+	// TunnelsAuto uses the go-ios agent if one runs on the host and manages the tunnels itself otherwise
+	TunnelsAuto = "auto"
+	// TunnelsAgent uses the tunnels of the go-ios agent started with 'ios tunnel start'
+	TunnelsAgent = "agent"
+	// TunnelsManaged starts the tunnels in the REST-API process
+	TunnelsManaged = "managed"
+	// TunnelsOff uses no tunnels, iOS 17+ devices can only be used if they are registered with POST /devices
+	TunnelsOff = "off"
+)
+
+// TunnelConfig configures how iOS 17+ devices are reached
+type TunnelConfig struct {
+	// Mode is auto, agent, managed or off, auto by default
+	Mode string `json:"mode"`
+	// KernelTUN uses a TUN device for managed tunnels instead of the userspace network stack, it needs root
+	KernelTUN bool `json:"kernelTun,omitempty"`
+	// PairRecordPath is where managed tunnels keep the pair records of iOS 17.0 to 17.3, the working dir by default
+	PairRecordPath string `json:"pairRecordPath,omitempty"`
+}
+
+// TunnelStatus is the tunnel of a device
+type TunnelStatus struct {
+	UDID             string `json:"udid"`
+	Address          string `json:"address"`
+	RsdPort          int    `json:"rsdPort"`
+	UserspaceTUNPort int    `json:"userspaceTunPort,omitempty"`
+	// Services is the number of services the device offers through the tunnel, 0 if the RSD handshake failed
+	Services int `json:"services"`
+	// Since is when the tunnel was established
+	Since time.Time `json:"since"`
+	// Error is why the last RSD handshake failed, the tunnel is restarted then
+	Error   string `json:"error,omitempty"`
+	checked time.Time
+	rsd     ios.RsdPortProvider
+}
+
+// TunnelsResponse lists the tunnels
+type TunnelsResponse struct {
+	Mode    string         `json:"mode"`
+	Tunnels []TunnelStatus `json:"tunnels"`
+}
+
+// tunnelProvider starts tunnels or lists the ones another process started
+type tunnelProvider interface {
+	UpdateTunnels(ctx context.Context) error
+	ListTunnels() ([]tunnel.Tunnel, error)
+	RemoveTunnel(ctx context.Context, udid string) error
+}
+
+// agentTunnels are the tunnels of the go-ios agent, it restarts them itself after reconnects
+type agentTunnels struct {
+	port int
+}
+
+func (a agentTunnels) UpdateTunnels(ctx context.Context) error {
+	return nil
+}
+
+func (a agentTunnels) ListTunnels() ([]tunnel.Tunnel, error) {
+	return tunnel.ListRunningTunnels(a.port)
+}
+
+func (a agentTunnels) RemoveTunnel(ctx context.Context, udid string) error {
+	return nil
+}
+
+// rsdHandshake connects to remote service discovery through the tunnel, tests replace it
+var rsdHandshake = func(device ios.DeviceEntry, t tunnel.Tunnel) (ios.RsdPortProvider, error) {
+	device.UserspaceTUN = t.UserspaceTUN
+	device.UserspaceTUNPort = t.UserspaceTUNPort
+	rsdService, err := ios.NewWithAddrPortDevice(t.Address, t.RsdPort, device)
+	if err != nil {
+		return nil, err
+	}
+	defer rsdService.Close()
+	return rsdService.Handshake()
+}
+
+// tunnelStore keeps the tunnels of iOS 17+ devices up and adds their RSD services to the devices in the registry,
+// so all endpoints reach these devices through the tunnel like they reach older devices through usbmuxd
+type tunnelStore struct {
+	mu       sync.Mutex
+	mode     string
+	provider tunnelProvider
+	tunnels  map[string]*TunnelStatus
+	now      func() time.Time
+}
+
+var deviceTunnels = &tunnelStore{mode: TunnelsOff, tunnels: map[string]*TunnelStatus{}, now: time.Now}
+
+// start creates the provider for the config
+func (t *tunnelStore) start(config TunnelConfig) error {
+	mode := config.Mode
+	if mode == "" || mode == TunnelsAuto {
+		mode = TunnelsManaged
+		if tunnel.IsAgentRunning() {
+			mode = TunnelsAgent
+		}
+	}
+	var provider tunnelProvider
+	switch mode {
+	case TunnelsOff:
+	case TunnelsAgent:
+		provider = agentTunnels{port: ios.HttpApiPort()}
+	case TunnelsManaged:
+		path := config.PairRecordPath
+		if path == "" {
+			path = "."
+		}
+		keys, err := sealedSecrets.keys()
+		if err != nil {
+			return err
+		}
+		pm, err := tunnel.NewPairRecordManagerWithKeys(path, keys)
+		if err != nil {
+			return err
+		}
+		manager := tunnel.NewTunnelManager(pm, !config.KernelTUN)
+		provider = manager
+		// like the go-ios agent, so ios commands on the host use the tunnels too
+		go func() {
+			err := tunnel.ServeTunnelInfo(manager, ios.HttpApiPort())
+			if err != nil {
+				log.WithError(err).Warn("failed serving the tunnel info for ios commands")
+			}
+		}()
+	default:
+		return fmt.Errorf("unknown mode %q, use auto, agent, managed or off", config.Mode)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.mode = mode
+	t.provider = provider
+	return nil
+}
+
+// run keeps the tunnels of the devices in the registry up every tunnelTick, until ctx is done
+func (t *tunnelStore) run(ctx context.Context, registry *DeviceRegistry) {
+	t.mu.Lock()
+	provider := t.provider
+	t.mu.Unlock()
+	if provider == nil {
+		return
+	}
+	ticker := time.NewTicker(tunnelTick)
+	defer ticker.Stop()
+	for {
+		t.update(ctx, provider, registry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update starts tunnels for new devices, does the RSD handshake for new tunnels and every tunnelCheckInterval,
+// and restarts tunnels whose handshake failed
+func (t *tunnelStore) update(ctx context.Context, provider tunnelProvider, registry *DeviceRegistry) {
+	err := provider.UpdateTunnels(ctx)
+	if err != nil {
+		log.WithError(err).Warn("failed to update tunnels")
+	}
+	list, err := provider.ListTunnels()
+	if err != nil {
+		log.WithError(err).Warn("failed to list tunnels")
+		return
+	}
+	running := map[string]bool{}
+	for _, tun := range list {
+		running[tun.Udid] = true
+		status := t.check(ctx, provider, registry, tun)
+		if status.rsd == nil {
+			continue
+		}
+		// usbmuxd replaces the device in the registry when it reconnects and restarted tunnels have a new address
+		if device, ok := registry.Get(tun.Udid); ok && (!device.SupportsRsd() || device.Address != status.Address) {
+			registry.Update(tun.Udid, func(device *ios.DeviceEntry) {
+				applyTunnel(device, status)
+			})
+		}
+	}
+	t.mu.Lock()
+	var stopped []TunnelStatus
+	for udid, status := range t.tunnels {
+		if !running[udid] {
+			stopped = append(stopped, *status)
+			delete(t.tunnels, udid)
+		}
+	}
+	t.mu.Unlock()
+	for _, status := range stopped {
+		registry.Update(status.UDID, func(device *ios.DeviceEntry) {
+			if device.Address == status.Address {
+				device.Rsd = nil
+				device.Address = ""
+				device.UserspaceTUN = false
+				device.UserspaceTUNPort = 0
+			}
+		})
+	}
+}
+
+// check does the RSD handshake for new tunnels and tunnels that were not checked for tunnelCheckInterval
+func (t *tunnelStore) check(ctx context.Context, provider tunnelProvider, registry *DeviceRegistry, tun tunnel.Tunnel) TunnelStatus {
+	now := t.now()
+	t.mu.Lock()
+	status, ok := t.tunnels[tun.Udid]
+	if !ok || status.Address != tun.Address || status.RsdPort != tun.RsdPort {
+		status = &TunnelStatus{UDID: tun.Udid, Address: tun.Address, RsdPort: tun.RsdPort, UserspaceTUNPort: tun.UserspaceTUNPort, Since: now}
+		t.tunnels[tun.Udid] = status
+	}
+	due := status.checked.IsZero() || now.Sub(status.checked) >= tunnelCheckInterval
+	current := *status
+	t.mu.Unlock()
+	if !due {
+		return current
+	}
+
+	device, ok := registry.Get(tun.Udid)
+	if !ok {
+		device = ios.DeviceEntry{Properties: ios.DeviceProperties{SerialNumber: tun.Udid}}
+	}
+	rsd, err := rsdHandshake(device, tun)
+	t.mu.Lock()
+	status.checked = now
+	if err != nil {
+		status.Error = err.Error()
+		status.Services = 0
+		status.rsd = nil
+	} else {
+		status.Error = ""
+		status.Services = len(rsd.GetServices())
+		status.rsd = rsd
+	}
+	current = *status
+	t.mu.Unlock()
+	if err != nil {
+		log.WithField("udid", tun.Udid).WithError(err).Warn("RSD handshake through the tunnel failed, restarting it")
+		err = provider.RemoveTunnel(ctx, tun.Udid)
+		if err != nil {
+			log.WithField("udid", tun.Udid).WithError(err).Warn("failed to stop tunnel")
+		}
+	}
+	return current
+}
+
+func applyTunnel(device *ios.DeviceEntry, status TunnelStatus) {
+	device.Address = status.Address
+	device.Rsd = status.rsd
+	device.UserspaceTUN = status.UserspaceTUNPort != 0
+	device.UserspaceTUNPort = status.UserspaceTUNPort
+}
+
+// withTunnel adds the RSD services of the tunnel to a device that usbmuxd reported without them
+func (t *tunnelStore) withTunnel(device ios.DeviceEntry) ios.DeviceEntry {
+	if device.SupportsRsd() {
+		return device
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, ok := t.tunnels[device.Properties.SerialNumber]
+	if ok && status.rsd != nil {
+		applyTunnel(&device, *status)
+	}
+	return device
+}
+
+func (t *tunnelStore) list() TunnelsResponse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	result := TunnelsResponse{Mode: t.mode, Tunnels: []TunnelStatus{}}
+	for _, status := range t.tunnels {
+		result.Tunnels = append(result.Tunnels, *status)
+	}
+	sort.Slice(result.Tunnels, func(i, j int) bool { return result.Tunnels[i].UDID < result.Tunnels[j].UDID })
+	return result
+}
+
+func loadTunnelConfig() TunnelConfig {
+	var config TunnelConfig
+	value := os.Getenv(tunnelsEnvVar)
+	if value == "" {
+		return config
+	}
+	err := json.Unmarshal([]byte(value), &config)
+	if err != nil {
+		log.WithError(err).Errorf("ignoring invalid %s", tunnelsEnvVar)
+		return TunnelConfig{}
+	}
+	return config
+}
+
+// List the tunnels
+// @Summary      List the tunnels of iOS 17+ devices
+// @Description  iOS 17+ devices are reached through a tunnel. Depending on GO_IOS_TUNNELS the tunnels are started by the REST-API (managed), by a go-ios agent started with 'ios tunnel start' (agent), or auto picks the agent if one runs. Tunnels are started when a device is attached and restarted when it reconnects or the RSD handshake through the tunnel fails.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  TunnelsResponse
+// @Router       /tunnels [get]
+func ListTunnels(c *gin.Context) {
+	c.JSON(http.StatusOK, deviceTunnels.list())
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/tunnel"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeTunnelProvider struct {
+	mu      sync.Mutex
+	tunnels map[string]tunnel.Tunnel
+	removed []string
+}
+
+func (f *fakeTunnelProvider) UpdateTunnels(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeTunnelProvider) ListTunnels() ([]tunnel.Tunnel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []tunnel.Tunnel
+	for _, t := range f.tunnels {
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+func (f *fakeTunnelProvider) RemoveTunnel(ctx context.Context, udid string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tunnels, udid)
+	f.removed = append(f.removed, udid)
+	return nil
+}
+
+func TestTunnelsAreAddedToTheRegistry(t *testing.T) {
+	original := rsdHandshake
+	t.Cleanup(func() { rsdHandshake = original })
+	handshakeErr := error(nil)
+	handshakes := 0
+	rsdHandshake = func(device ios.DeviceEntry, t tunnel.Tunnel) (ios.RsdPortProvider, error) {
+		handshakes++
+		if handshakeErr != nil {
+			return nil, handshakeErr
+		}
+		return ios.RsdHandshakeResponse{Udid: t.Udid, Services: map[string]ios.RsdServiceEntry{remoteLockdownService: {Port: 1234}}}, nil
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &tunnelStore{mode: TunnelsManaged, tunnels: map[string]*TunnelStatus{}, now: func() time.Time { return now }}
+	provider := &fakeTunnelProvider{tunnels: map[string]tunnel.Tunnel{
+		"ios17": {Udid: "ios17", Address: "fd00::1", RsdPort: 58783, UserspaceTUN: true, UserspaceTUNPort: 28101},
+	}}
+	registry := NewDeviceRegistry()
+	registry.Put(testDevice("ios17"))
+	registry.Put(testDevice("ios16"))
+
+	store.update(context.Background(), provider, registry)
+	device, _ := registry.Get("ios17")
+	require.True(t, device.SupportsRsd())
+	assert.Equal(t, "fd00::1", device.Address)
+	assert.Equal(t, 1234, device.Rsd.GetPort(remoteLockdownService))
+	assert.True(t, device.UserspaceTUN)
+	assert.Equal(t, 28101, device.UserspaceTUNPort)
+	device, _ = registry.Get("ios16")
+	assert.False(t, device.SupportsRsd())
+
+	// a reconnect replaces the device in the registry, the middleware adds the tunnel until the next update
+	registry.Put(testDevice("ios17"))
+	withTunnel := store.withTunnel(testDevice("ios17"))
+	assert.True(t, withTunnel.SupportsRsd())
+	withoutTunnel := store.withTunnel(testDevice("ios16"))
+	assert.False(t, withoutTunnel.SupportsRsd())
+	store.update(context.Background(), provider, registry)
+	device, _ = registry.Get("ios17")
+	assert.True(t, device.SupportsRsd())
+	assert.Equal(t, 1, handshakes, "tunnels are checked every tunnelCheckInterval")
+
+	now = now.Add(tunnelCheckInterval)
+	handshakeErr = errors.New("connection refused")
+	store.update(context.Background(), provider, registry)
+	assert.Equal(t, []string{"ios17"}, provider.removed, "tunnels that fail the handshake are restarted")
+	assert.Equal(t, "connection refused", store.list().Tunnels[0].Error)
+
+	store.update(context.Background(), provider, registry)
+	assert.Empty(t, store.list().Tunnels)
+	device, _ = registry.Get("ios17")
+	assert.False(t, device.SupportsRsd(), "devices without a tunnel are reached through usbmuxd")
+}
+
+func TestListTunnels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	original := deviceTunnels
+	t.Cleanup(func() { deviceTunnels = original })
+	deviceTunnels = &tunnelStore{mode: TunnelsAgent, tunnels: map[string]*TunnelStatus{
+		"b": {UDID: "b", Address: "fd00::2"},
+		"a": {UDID: "a", Address: "fd00::1", Services: 3},
+	}, now: time.Now}
+	r := gin.New()
+	r.GET("/tunnels", ListTunnels)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tunnels", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var response TunnelsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, TunnelsAgent, response.Mode)
+	require.Len(t, response.Tunnels, 2)
+	assert.Equal(t, "a", response.Tunnels[0].UDID)
+	assert.Equal(t, 3, response.Tunnels[0].Services)
+
+	assert.Error(t, deviceTunnels.start(TunnelConfig{Mode: "sometimes"}))
+	assert.NoError(t, deviceTunnels.start(TunnelConfig{Mode: TunnelsOff}))
+	assert.Equal(t, TunnelsOff, deviceTunnels.list().Mode)
+}
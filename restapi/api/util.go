@@ -2,12 +2,14 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/danielpaulus/go-ios/ios"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
@@ -27,6 +29,18 @@ func GetVersion() string {
 	return string(version)
 }
 
+// errorStatus returns the http status code for an error returned by go-ios.
+// Operations that failed because the device is locked return 423 Locked, everything else 500.
+func errorStatus(err error) int {
+	if errors.Is(err, ios.ErrDeviceLocked) {
+		return http.StatusLocked
+	}
+	if errors.Is(err, ios.ErrPairingInvalid) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
 func MustMarshal(v interface{}) string {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrorCode is a stable, machine readable identifier of an API v2 error. Codes are never renamed or
+// reused within a major API version, clients should switch on them instead of on the message.
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidRequest      = ErrorCode("invalid_request")
+	ErrorCodeUnauthorized        = ErrorCode("unauthorized")
+	ErrorCodeForbidden           = ErrorCode("forbidden")
+	ErrorCodeDeviceNotFound      = ErrorCode("device_not_found")
+	ErrorCodeDeviceAmbiguous     = ErrorCode("device_ambiguous")
+	ErrorCodeDeviceLocked        = ErrorCode("device_locked")
+	ErrorCodeArtifactUnavailable = ErrorCode("artifact_unavailable")
+	ErrorCodeJobNotFound         = ErrorCode("job_not_found")
+	ErrorCodeJobFinished         = ErrorCode("job_finished")
+	ErrorCodeCanceled            = ErrorCode("canceled")
+	ErrorCodeHookFailed          = ErrorCode("hook_failed")
+	ErrorCodeInternal            = ErrorCode("internal")
+)
+
+// APIError is the error of API v2 responses and failed jobs
+type APIError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// Details contains additional information for some codes, f.ex. the matching devices of device_ambiguous
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func (e APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// ErrorResponse is the body of every API v2 response with a 4xx or 5xx status
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// abortWithAPIError aborts the request with an ErrorResponse
+func abortWithAPIError(c *gin.Context, status int, apiErr APIError) {
+	c.AbortWithStatusJSON(status, ErrorResponse{Error: apiErr})
+}
+
+// apiErrorFor converts errors returned by go-ios to an APIError and the matching http status
+func apiErrorFor(err error) (int, APIError) {
+	var apiErr APIError
+	if errors.As(err, &apiErr) {
+		return http.StatusInternalServerError, apiErr
+	}
+	var hookErr hookError
+	if errors.As(err, &hookErr) {
+		return http.StatusFailedDependency, APIError{Code: ErrorCodeHookFailed, Message: err.Error()}
+	}
+	if errors.Is(err, ios.ErrDeviceLocked) {
+		return http.StatusLocked, APIError{Code: ErrorCodeDeviceLocked, Message: err.Error()}
+	}
+	return http.StatusInternalServerError, APIError{Code: ErrorCodeInternal, Message: err.Error()}
+}
+
+// resolveDevice finds the device a reference in a v2 path points to. A reference is either a udid, a label that
+// exactly one device has or a prefix of a udid with at least minUDIDPrefix characters. Devices the registry does
+// not know yet are looked up in usbmuxd by their udid.
+func resolveDevice(ref string, registry *DeviceRegistry, lookup func(udid string) (ios.DeviceEntry, error)) (ios.DeviceEntry, *APIError) {
+	if device, ok := registry.Get(ref); ok {
+		return device, nil
+	}
+	var labelled, prefixed []ios.DeviceEntry
+	registry.Range(func(device ios.DeviceEntry) bool {
+		udid := device.Properties.SerialNumber
+		if registry.HasLabel(udid, ref) {
+			labelled = append(labelled, device)
+		}
+		if len(ref) >= minUDIDPrefix && strings.HasPrefix(strings.ToLower(udid), strings.ToLower(ref)) {
+			prefixed = append(prefixed, device)
+		}
+		return true
+	})
+	for _, matches := range [][]ios.DeviceEntry{labelled, prefixed} {
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+		if len(matches) > 1 {
+			udids := make([]string, len(matches))
+			for i, device := range matches {
+				udids[i] = device.Properties.SerialNumber
+			}
+			return ios.DeviceEntry{}, &APIError{
+				Code:    ErrorCodeDeviceAmbiguous,
+				Message: fmt.Sprintf("'%s' matches %d devices, use a udid", ref, len(matches)),
+				Details: map[string]interface{}{"udids": udids},
+			}
+		}
+	}
+	device, err := lookup(ref)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return ios.DeviceEntry{}, &APIError{Code: ErrorCodeDeviceNotFound, Message: fmt.Sprintf("no device matches '%s'", ref)}
+		}
+		_, apiErr := apiErrorFor(err)
+		return ios.DeviceEntry{}, &apiErr
+	}
+	return device, nil
+}
+
+// minUDIDPrefix is the shortest udid prefix that resolves a device
+const minUDIDPrefix = 6
+
+// ResolveDeviceMiddleware is the v2 counterpart of DeviceMiddleware. It resolves the device reference in the
+// device path param with resolveDevice and stores the device for MustGetDevice. Returns 404 with device_not_found
+// if no device matches and 409 with device_ambiguous if several do.
+func ResolveDeviceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		device, apiErr := resolveDevice(c.Param("device"), devices, ios.GetDevice)
+		if apiErr != nil {
+			status := http.StatusInternalServerError
+			switch apiErr.Code {
+			case ErrorCodeDeviceNotFound:
+				status = http.StatusNotFound
+			case ErrorCodeDeviceAmbiguous:
+				status = http.StatusConflict
+			}
+			abortWithAPIError(c, status, *apiErr)
+			return
+		}
+		c.Set(IOS_KEY, device)
+		removeOwner := connectionOwners.add(device.DeviceID, c.Request.Method+" "+c.Request.URL.Path)
+		defer removeOwner()
+		c.Next()
+	}
+}
+
+const (
+	// v1SunsetEnvVar overrides the date after which v1 may be removed, in the format 2006-01-02
+	v1SunsetEnvVar = "GO_IOS_V1_SUNSET"
+	// v1DeprecatedAt is the release date of v2
+	v1DeprecatedAt = "2026-10-17"
+	// v1Sunset gives clients at least six months to migrate to v2
+	v1Sunset = "2027-04-30"
+)
+
+// v1SunsetDate returns the sunset date of v1 from the environment or the default
+func v1SunsetDate() time.Time {
+	if env := os.Getenv(v1SunsetEnvVar); env != "" {
+		sunset, err := time.Parse(time.DateOnly, env)
+		if err == nil {
+			return sunset
+		}
+		log.WithError(err).Warnf("invalid %s, using %s", v1SunsetEnvVar, v1Sunset)
+	}
+	sunset, _ := time.Parse(time.DateOnly, v1Sunset)
+	return sunset
+}
+
+// DeprecationMiddleware marks all responses of a group as deprecated with the Deprecation (RFC 9745) and
+// Sunset (RFC 8594) headers and links to the successor version.
+func DeprecationMiddleware(deprecatedAt time.Time, sunset time.Time, successor string) gin.HandlerFunc {
+	deprecation := fmt.Sprintf("@%d", deprecatedAt.Unix())
+	sunsetHeader := sunset.UTC().Format(http.TimeFormat)
+	link := fmt.Sprintf("<%s>; rel=\"successor-version\"", successor)
+	return func(c *gin.Context) {
+		c.Header("Deprecation", deprecation)
+		c.Header("Sunset", sunsetHeader)
+		c.Header("Link", link)
+		c.Next()
+	}
+}
+
+// v1Deprecation is the DeprecationMiddleware of the v1 routes
+func v1Deprecation() gin.HandlerFunc {
+	deprecatedAt, _ := time.Parse(time.DateOnly, v1DeprecatedAt)
+	return DeprecationMiddleware(deprecatedAt, v1SunsetDate(), "/api/v2")
+}
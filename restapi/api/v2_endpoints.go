@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/danielpaulus/go-ios/ios/imagemounter"
+	"github.com/gin-gonic/gin"
+)
+
+// ListDevicesV2 lists the devices of the device registry
+// @Summary      List devices
+// @Description  Lists the devices known to the API sorted by udid. The filters are combined, fields projects the devices to the given fields. Use limit and offset to page through large farms, X-Total-Count has the number of matching devices and Link the url of the next page.
+// @Tags         devices_v2
+// @Produce      json
+// @Param        label query string false "Only list devices with this label"
+// @Param        os_version query string false "Only list devices with this iOS version, 17 matches all 17.x versions"
+// @Param        model query string false "Only list devices of this device class, like iPad, or whose product type starts with it, like iPhone14"
+// @Param        connection query string false "usb or wifi"
+// @Param        paired query bool false "Only list devices the host is paired with or not"
+// @Param        fields query string false "Comma separated fields to return, f.ex. udid,osVersion"
+// @Param        limit query int false "Page size, up to 500, all devices are listed if not set"
+// @Param        offset query int false "Number of matching devices to skip"
+// @Success      200  {object}  []DeviceV2
+// @Failure      422  {object}  ErrorResponse
+// @Router       /devices [get]
+func ListDevicesV2(c *gin.Context) {
+	query, err := deviceListQueryFrom(c.Request.URL.Query())
+	if err != nil {
+		abortWithAPIError(c, http.StatusUnprocessableEntity, APIError{Code: ErrorCodeInvalidRequest, Message: err.Error()})
+		return
+	}
+	page, total := query.list(devices)
+	setPaginationHeaders(c, query, total)
+	c.JSON(http.StatusOK, page)
+}
+
+// GetDeviceV2 returns a device
+// @Summary      Get a device
+// @Description  Returns the device a reference resolves to. A reference is a udid, a label only one device has or a unique udid prefix of at least 6 characters.
+// @Tags         devices_v2
+// @Produce      json
+// @Param        device path string true "Device reference"
+// @Success      200  {object}  DeviceV2
+// @Failure      404  {object}  ErrorResponse
+// @Failure      409  {object}  ErrorResponse
+// @Router       /devices/{device} [get]
+func GetDeviceV2(c *gin.Context) {
+	c.JSON(http.StatusOK, newDeviceV2(devices, MustGetDevice(c), true))
+}
+
+// InstallAppV2 starts a job installing an app
+// @Summary      Install an app
+// @Description  Starts a job that installs an ipa from the artifact store, a url or an upload like /api/v1/device/{udid}/apps/install does. The artifact is stored before the response is sent, the installation itself runs in the job.
+// @Tags         devices_v2
+// @Produce      json
+// @Param        device path string true "Device reference"
+// @Param        artifact query string false "id of an artifact from the artifact store"
+// @Param        url query string false "url to download the ipa from"
+// @Param        name query string false "file name of a raw body upload, f.ex. app.ipa"
+// @Param        force query bool false "install even if the artifact is installed already"
+// @Success      202  {object}  Job
+// @Failure      404  {object}  ErrorResponse
+// @Failure      422  {object}  ErrorResponse
+// @Router       /devices/{device}/apps/install [post]
+func InstallAppV2(c *gin.Context) {
+	device := MustGetDevice(c)
+	artifact, status, err := artifactFromRequest(c)
+	if err != nil {
+		code := ErrorCodeArtifactUnavailable
+		if status == http.StatusUnprocessableEntity {
+			code = ErrorCodeInvalidRequest
+		}
+		abortWithAPIError(c, status, APIError{Code: code, Message: err.Error()})
+		return
+	}
+	force := c.Query("force") == "true"
+	job := jobs.start("install", device.Properties.SerialNumber, func(ctx context.Context) (interface{}, error) {
+		message, err := installArtifact(ctx, device, artifact, force, nil)
+		if err != nil {
+			return nil, err
+		}
+		return GenericResponse{Message: message}, nil
+	})
+	acceptJob(c, job)
+}
+
+// MountImageV2 starts a job mounting the developer disk image
+// @Summary      Mount the developer disk image
+// @Description  Starts a job that downloads the developer disk image for the device to the image cache, if it is not cached yet, and mounts it.
+// @Tags         devices_v2
+// @Produce      json
+// @Param        device path string true "Device reference"
+// @Success      202  {object}  Job
+// @Failure      404  {object}  ErrorResponse
+// @Router       /devices/{device}/image/mount [post]
+func MountImageV2(c *gin.Context) {
+	device := MustGetDevice(c)
+	dir := imageDir()
+	job := jobs.start("mount-image", device.Properties.SerialNumber, func(ctx context.Context) (interface{}, error) {
+		path, err := imagemounter.DownloadImageFor(device, dir)
+		if err != nil {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		err = imagemounter.MountImage(device, path)
+		if err != nil {
+			return nil, err
+		}
+		return GenericResponse{Message: "image mounted"}, nil
+	})
+	acceptJob(c, job)
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveDevice(t *testing.T) {
+	registry := NewDeviceRegistry()
+	registry.Put(testDevice("00008030-aaaa"))
+	registry.Put(testDevice("00008030-bbbb"))
+	registry.Put(testDevice("00008101-cccc"))
+	registry.SetLabels("00008030-aaaa", []string{"iphone-12", "pool"})
+	registry.SetLabels("00008030-bbbb", []string{"pool"})
+	notFound := func(udid string) (ios.DeviceEntry, error) {
+		return ios.DeviceEntry{}, errors.New("device not found")
+	}
+
+	device, apiErr := resolveDevice("00008030-bbbb", registry, notFound)
+	assert.Nil(t, apiErr)
+	assert.Equal(t, "00008030-bbbb", device.Properties.SerialNumber)
+
+	device, apiErr = resolveDevice("iphone-12", registry, notFound)
+	assert.Nil(t, apiErr)
+	assert.Equal(t, "00008030-aaaa", device.Properties.SerialNumber)
+
+	device, apiErr = resolveDevice("00008101", registry, notFound)
+	assert.Nil(t, apiErr)
+	assert.Equal(t, "00008101-cccc", device.Properties.SerialNumber)
+
+	_, apiErr = resolveDevice("pool", registry, notFound)
+	assert.Equal(t, ErrorCodeDeviceAmbiguous, apiErr.Code)
+	assert.Equal(t, []string{"00008030-aaaa", "00008030-bbbb"}, apiErr.Details["udids"])
+
+	_, apiErr = resolveDevice("00008030", registry, notFound)
+	assert.Equal(t, ErrorCodeDeviceAmbiguous, apiErr.Code)
+
+	_, apiErr = resolveDevice("0000", registry, notFound)
+	assert.Equal(t, ErrorCodeDeviceNotFound, apiErr.Code)
+
+	device, apiErr = resolveDevice("usb-only", registry, func(udid string) (ios.DeviceEntry, error) {
+		return testDevice(udid), nil
+	})
+	assert.Nil(t, apiErr)
+	assert.Equal(t, "usb-only", device.Properties.SerialNumber)
+}
+
+func TestApiErrorFor(t *testing.T) {
+	status, apiErr := apiErrorFor(ios.ErrDeviceLocked)
+	assert.Equal(t, http.StatusLocked, status)
+	assert.Equal(t, ErrorCodeDeviceLocked, apiErr.Code)
+
+	status, apiErr = apiErrorFor(errors.New("broken pipe"))
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, APIError{Code: ErrorCodeInternal, Message: "broken pipe"}, apiErr)
+}
+
+func TestDeprecationMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	deprecatedAt := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC)
+	router.GET("/api/v1/list", DeprecationMiddleware(deprecatedAt, sunset, "/api/v2"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/list", nil))
+
+	assert.Equal(t, "@1792195200", recorder.Header().Get("Deprecation"))
+	assert.Equal(t, "Fri, 30 Apr 2027 00:00:00 GMT", recorder.Header().Get("Sunset"))
+	assert.Equal(t, `</api/v2>; rel="successor-version"`, recorder.Header().Get("Link"))
+}
+
+func TestV1SunsetDate(t *testing.T) {
+	t.Setenv(v1SunsetEnvVar, "2028-01-31")
+	assert.Equal(t, time.Date(2028, 1, 31, 0, 0, 0, 0, time.UTC), v1SunsetDate())
+	t.Setenv(v1SunsetEnvVar, "soon")
+	assert.Equal(t, time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC), v1SunsetDate())
+}
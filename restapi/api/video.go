@@ -0,0 +1,133 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/screenstream"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// videoSource opens the frame source of a device, tests replace it
+var videoSource = screenstream.NewInstrumentsSource
+
+// videoHub shares one screen stream per device and options between all viewers and stops it after the last
+// viewer left, every stream keeps an instruments connection to the device busy
+type videoHub struct {
+	mu      sync.Mutex
+	streams map[string]*videoHubStream
+}
+
+type videoHubStream struct {
+	stream  *screenstream.Stream
+	viewers int
+}
+
+var videos = &videoHub{streams: map[string]*videoHubStream{}}
+
+// watch subscribes to the stream of device with options and starts it if needed. Call the returned func to stop watching.
+func (h *videoHub) watch(device ios.DeviceEntry, options screenstream.Options) (<-chan []byte, func(), error) {
+	key := fmt.Sprintf("%s/%g/%d/%d/%d", device.Properties.SerialNumber, options.Scale, options.Quality, options.MaxFPS, options.MaxSide)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry, ok := h.streams[key]
+	if ok {
+		select {
+		case <-entry.stream.Done():
+			// capturing failed, f.ex. because the device was disconnected, start over
+			ok = false
+		default:
+		}
+	}
+	if !ok {
+		source, err := videoSource(device)
+		if err != nil {
+			return nil, nil, err
+		}
+		entry = &videoHubStream{stream: screenstream.New(source, options)}
+		h.streams[key] = entry
+	}
+	entry.viewers++
+	frames, unsubscribe := entry.stream.Subscribe()
+	return frames, func() {
+		unsubscribe()
+		h.mu.Lock()
+		entry.viewers--
+		last := entry.viewers == 0 && h.streams[key] == entry
+		if last {
+			delete(h.streams, key)
+		}
+		h.mu.Unlock()
+		if last {
+			entry.stream.Close()
+		}
+	}, nil
+}
+
+func videoOptionsFrom(c *gin.Context) (screenstream.Options, error) {
+	options := screenstream.DefaultOptions()
+	var err error
+	if value := c.Query("scale"); value != "" {
+		if options.Scale, err = strconv.ParseFloat(value, 64); err != nil {
+			return options, fmt.Errorf("scale must be a number")
+		}
+	}
+	if value := c.Query("quality"); value != "" {
+		if options.Quality, err = strconv.Atoi(value); err != nil {
+			return options, fmt.Errorf("quality must be a number")
+		}
+	}
+	if value := c.Query("fps"); value != "" {
+		if options.MaxFPS, err = strconv.Atoi(value); err != nil {
+			return options, fmt.Errorf("fps must be a number")
+		}
+	}
+	return options, options.Validate()
+}
+
+// Video streams the screen of a device
+// @Summary      Stream the screen of a device
+// @Description  Streams the screen as MJPEG (multipart/x-mixed-replace), which browsers show in an img tag. All viewers of a device with the same options share one capture. Needs the developer image, frames come from the instruments screenshot service. H.264 is not supported yet and returns 501, players like VLC can watch the device with the RTSP gateway at GO_IOS_RTSP_ADDR.
+// @Tags         general_device_specific
+// @Produce      multipart/x-mixed-replace
+// @Param        udid path string true "Device UDID"
+// @Param        format query string false "mjpeg, the default, or h264"
+// @Param        scale query number false "between 0 and 1, defaults to 1"
+// @Param        quality query int false "jpeg quality from 1 to 100, defaults to 80"
+// @Param        fps query int false "maximum frames per second from 1 to 30, defaults to 10"
+// @Success      200
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Failure      501  {object}  GenericResponse
+// @Router       /device/{udid}/video [get]
+func Video(c *gin.Context) {
+	switch c.DefaultQuery("format", "mjpeg") {
+	case "mjpeg":
+	case "h264":
+		c.JSON(http.StatusNotImplemented, GenericResponse{Error: "h264 is not supported, it needs the AVVideo screen capture over USB and an encoder go-ios does not have yet, use mjpeg or the rtsp gateway"})
+		return
+	default:
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "format must be mjpeg or h264"})
+		return
+	}
+	options, err := videoOptionsFrom(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	device := MustGetDevice(c)
+	frames, stop, err := videos.watch(device, options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	defer stop()
+	err = screenstream.ServeMJPEG(c.Request.Context(), c.Writer, frames)
+	if err != nil {
+		log.WithField("udid", device.Properties.SerialNumber).Debug(err)
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/screenstream"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFrameSource struct {
+	png    []byte
+	closed *atomic.Int32
+}
+
+func (s fakeFrameSource) Capture() ([]byte, error) {
+	return s.png, nil
+}
+
+func (s fakeFrameSource) Close() {
+	s.closed.Add(1)
+}
+
+func TestVideo(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var opened, closed atomic.Int32
+	defer func(source func(ios.DeviceEntry) (screenstream.FrameSource, error)) { videoSource = source }(videoSource)
+	videoSource = func(device ios.DeviceEntry) (screenstream.FrameSource, error) {
+		opened.Add(1)
+		return fakeFrameSource{png: testScreenshot(t), closed: &closed}, nil
+	}
+
+	r := gin.New()
+	r.GET("/device/:udid/video", func(c *gin.Context) {
+		c.Set(IOS_KEY, testDevice("video"))
+		c.Next()
+	}, Video)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	watch := func(ctx context.Context) *bufio.Reader {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/device/video/video?scale=0.5&fps=30", nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "multipart/x-mixed-replace; boundary=go-ios-frame", resp.Header.Get("Content-Type"))
+		reader := bufio.NewReader(resp.Body)
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "--go-ios-frame\r\n", line)
+		return reader
+	}
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	watch(ctx1)
+	watch(ctx2)
+	assert.Equal(t, int32(1), opened.Load(), "viewers share the stream")
+
+	cancel1()
+	cancel2()
+	assert.Eventually(t, func() bool { return closed.Load() == 1 }, 5*time.Second, 10*time.Millisecond, "the stream stops after the last viewer left")
+
+	for query, status := range map[string]int{"format=h264": http.StatusNotImplemented, "format=gif": http.StatusUnprocessableEntity, "fps=100": http.StatusUnprocessableEntity, "scale=x": http.StatusUnprocessableEntity} {
+		resp, err := http.Get(server.URL + "/device/video/video?" + query)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, status, resp.StatusCode, query)
+	}
+}
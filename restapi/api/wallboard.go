@@ -0,0 +1,320 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	wallboardDefaultInterval = 10 * time.Second
+	wallboardMinInterval     = 2 * time.Second
+)
+
+// WallboardConfig selects the devices shown on the wallboard. If UDIDs and Label are empty, all devices are shown.
+type WallboardConfig struct {
+	Enabled         bool     `json:"enabled"`
+	UDIDs           []string `json:"udids,omitempty"`
+	Label           string   `json:"label,omitempty"`
+	IntervalSeconds int      `json:"intervalSeconds"`
+}
+
+// WallboardScreen describes the last screenshot captured of a device
+type WallboardScreen struct {
+	UDID       string    `json:"udid"`
+	Name       string    `json:"name,omitempty"`
+	CapturedAt time.Time `json:"capturedAt"`
+	Error      string    `json:"error,omitempty"`
+	png        []byte
+}
+
+// wallboard periodically captures screenshots of the selected devices, so showing a wall of device screens
+// never needs a connection to a device and many viewers don't cause additional load
+type wallboard struct {
+	mu      sync.Mutex
+	config  WallboardConfig
+	screens map[string]WallboardScreen
+	changed chan struct{}
+}
+
+var screens = newWallboard()
+
+func newWallboard() *wallboard {
+	return &wallboard{
+		config:  WallboardConfig{IntervalSeconds: int(wallboardDefaultInterval / time.Second)},
+		screens: map[string]WallboardScreen{},
+		changed: make(chan struct{}, 1),
+	}
+}
+
+func (w *wallboard) getConfig() WallboardConfig {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.config
+}
+
+func (w *wallboard) setConfig(config WallboardConfig) error {
+	if config.IntervalSeconds == 0 {
+		config.IntervalSeconds = int(wallboardDefaultInterval / time.Second)
+	}
+	if time.Duration(config.IntervalSeconds)*time.Second < wallboardMinInterval {
+		return fmt.Errorf("intervalSeconds must be at least %d", int(wallboardMinInterval/time.Second))
+	}
+	w.mu.Lock()
+	w.config = config
+	w.mu.Unlock()
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (w *wallboard) interval() time.Duration {
+	return time.Duration(w.getConfig().IntervalSeconds) * time.Second
+}
+
+// selected returns the devices of the registry that are shown with the current config
+func (w *wallboard) selected(registry *DeviceRegistry) []ios.DeviceEntry {
+	config := w.getConfig()
+	udids := map[string]bool{}
+	for _, udid := range config.UDIDs {
+		udids[udid] = true
+	}
+	var result []ios.DeviceEntry
+	registry.Range(func(device ios.DeviceEntry) bool {
+		udid := device.Properties.SerialNumber
+		switch {
+		case len(udids) > 0 && !udids[udid]:
+		case config.Label != "" && !registry.HasLabel(udid, config.Label):
+		default:
+			result = append(result, device)
+		}
+		return true
+	})
+	return result
+}
+
+func (w *wallboard) put(screen WallboardScreen) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if screen.png == nil {
+		// keep showing the last screenshot if capturing failed
+		previous := w.screens[screen.UDID]
+		screen.png = previous.png
+		screen.CapturedAt = previous.CapturedAt
+	}
+	w.screens[screen.UDID] = screen
+}
+
+func (w *wallboard) get(udid string) (WallboardScreen, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	screen, ok := w.screens[udid]
+	return screen, ok
+}
+
+// list returns the screens of the currently selected devices sorted by udid
+func (w *wallboard) list(registry *DeviceRegistry) []WallboardScreen {
+	result := []WallboardScreen{}
+	for _, device := range w.selected(registry) {
+		screen, ok := w.get(device.Properties.SerialNumber)
+		if !ok {
+			screen = WallboardScreen{UDID: device.Properties.SerialNumber}
+		}
+		result = append(result, screen)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UDID < result[j].UDID })
+	return result
+}
+
+// run captures the screens of the selected devices every interval while the wallboard is enabled, until ctx is done
+func (w *wallboard) run(ctx context.Context, registry *DeviceRegistry) {
+	for {
+		if w.getConfig().Enabled {
+			w.capture(registry)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.changed:
+		case <-time.After(w.interval()):
+		}
+	}
+}
+
+func (w *wallboard) capture(registry *DeviceRegistry) {
+	var wg sync.WaitGroup
+	for _, device := range w.selected(registry) {
+		if _, ok := maintenance.active(device.Properties.SerialNumber); ok {
+			continue
+		}
+		wg.Add(1)
+		go func(device ios.DeviceEntry) {
+			defer wg.Done()
+			screen := WallboardScreen{UDID: device.Properties.SerialNumber}
+			if asset, ok := assets.get(screen.UDID); ok {
+				screen.Name = asset.DeviceName
+			}
+			png, err := captureScreen(device)
+			if err != nil {
+				log.WithField("udid", screen.UDID).WithError(err).Debug("wallboard screenshot failed")
+				screen.Error = err.Error()
+			} else {
+				screen.png = png
+				screen.CapturedAt = time.Now()
+			}
+			w.put(screen)
+		}(device)
+	}
+	wg.Wait()
+}
+
+func captureScreen(device ios.DeviceEntry) (png []byte, err error) {
+	defer slos.observe(sloScreenshot, time.Now(), &err)
+	conn, err := instruments.NewScreenshotService(device)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.TakeScreenshot()
+}
+
+var wallboardPage = template.Must(template.New("wallboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>go-ios wallboard</title>
+<style>
+body { background: #111; color: #ddd; font-family: sans-serif; margin: 0; }
+#screens { display: flex; flex-wrap: wrap; gap: 16px; padding: 16px; }
+.screen { width: 220px; text-align: center; font-size: 12px; }
+.screen img { width: 100%; border-radius: 12px; background: #222; min-height: 100px; }
+.error { color: #e66; }
+</style>
+</head>
+<body>
+<div id="screens"></div>
+<script>
+function render(screens) {
+  var container = document.getElementById("screens");
+  container.innerHTML = "";
+  screens.forEach(function (screen) {
+    var tile = document.createElement("div");
+    tile.className = "screen";
+    var img = document.createElement("img");
+    if (screen.capturedAt && !screen.capturedAt.startsWith("0001")) {
+      img.src = "wallboard/screens/" + encodeURIComponent(screen.udid) + "?t=" + encodeURIComponent(screen.capturedAt);
+    }
+    var caption = document.createElement("div");
+    caption.textContent = (screen.name || screen.udid);
+    tile.appendChild(img);
+    tile.appendChild(caption);
+    if (screen.error) {
+      var error = document.createElement("div");
+      error.className = "error";
+      error.textContent = screen.error;
+      tile.appendChild(error);
+    }
+    container.appendChild(tile);
+  });
+}
+function refresh() {
+  fetch("wallboard/screens").then(function (r) { return r.json(); }).then(render);
+}
+refresh();
+setInterval(refresh, {{.}});
+</script>
+</body>
+</html>
+`))
+
+// Show the wallboard
+// @Summary      Show the wallboard
+// @Description  A lightweight page showing the last screenshots of the devices selected with PUT /wallboard/config, meant to be displayed on a screen in the lab.
+// @Tags         wallboard
+// @Produce      html
+// @Success      200
+// @Router       /wallboard [get]
+func Wallboard(c *gin.Context) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	err := wallboardPage.Execute(c.Writer, screens.interval().Milliseconds())
+	if err != nil {
+		log.WithError(err).Warn("failed rendering wallboard")
+	}
+}
+
+// List the wallboard screens
+// @Summary      List the wallboard screens
+// @Description  Lists the devices shown on the wallboard with the time of their last screenshot.
+// @Tags         wallboard
+// @Produce      json
+// @Success      200 {object} []WallboardScreen
+// @Router       /wallboard/screens [get]
+func ListWallboardScreens(c *gin.Context) {
+	c.JSON(http.StatusOK, screens.list(devices))
+}
+
+// Get a wallboard screenshot
+// @Summary      Get the last screenshot of a device
+// @Description  Returns the last screenshot captured of the device for the wallboard without connecting to the device.
+// @Tags         wallboard
+// @Produce      png
+// @Param        udid path string true "Device UDID"
+// @Success      200
+// @Failure      404 {object} GenericResponse
+// @Router       /wallboard/screens/{udid} [get]
+func GetWallboardScreen(c *gin.Context) {
+	screen, ok := screens.get(c.Param("udid"))
+	if !ok || screen.png == nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "no screenshot captured yet"})
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "image/png", screen.png)
+}
+
+// Get the wallboard config
+// @Summary      Get the wallboard config
+// @Tags         wallboard
+// @Produce      json
+// @Success      200 {object} WallboardConfig
+// @Router       /wallboard/config [get]
+func GetWallboardConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, screens.getConfig())
+}
+
+// Configure the wallboard
+// @Summary      Configure the wallboard
+// @Description  Enables the wallboard and selects the devices to capture by udid or label, all devices are captured if none are selected. Screenshots are taken every intervalSeconds, 10 by default.
+// @Tags         wallboard
+// @Accept       json
+// @Produce      json
+// @Param        config body WallboardConfig true "Wallboard config"
+// @Success      200 {object} WallboardConfig
+// @Failure      422 {object} GenericResponse
+// @Router       /wallboard/config [put]
+func SetWallboardConfig(c *gin.Context) {
+	var config WallboardConfig
+	err := c.ShouldBindJSON(&config)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	err = screens.setConfig(config)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, screens.getConfig())
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWallboardSelection(t *testing.T) {
+	registry := NewDeviceRegistry()
+	for _, udid := range []string{"c", "a", "b"} {
+		registry.Put(testDevice(udid))
+	}
+	registry.SetLabels("b", []string{"lobby"})
+	board := newWallboard()
+
+	assert.Len(t, board.list(registry), 3)
+
+	require.NoError(t, board.setConfig(WallboardConfig{UDIDs: []string{"a", "c", "missing"}}))
+	list := board.list(registry)
+	require.Len(t, list, 2)
+	assert.Equal(t, "a", list[0].UDID)
+	assert.Equal(t, "c", list[1].UDID)
+
+	require.NoError(t, board.setConfig(WallboardConfig{Label: "lobby"}))
+	list = board.list(registry)
+	require.Len(t, list, 1)
+	assert.Equal(t, "b", list[0].UDID)
+	assert.Equal(t, wallboardDefaultInterval, board.interval())
+
+	assert.Error(t, board.setConfig(WallboardConfig{IntervalSeconds: 1}))
+}
+
+func TestWallboardKeepsLastScreenshot(t *testing.T) {
+	board := newWallboard()
+	captured := time.Now()
+	board.put(WallboardScreen{UDID: "a", CapturedAt: captured, png: []byte{1}})
+	board.put(WallboardScreen{UDID: "a", Error: "device locked"})
+
+	screen, ok := board.get("a")
+	require.True(t, ok)
+	assert.Equal(t, []byte{1}, screen.png)
+	assert.Equal(t, captured, screen.CapturedAt)
+	assert.Equal(t, "device locked", screen.Error)
+}
@@ -0,0 +1,629 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/forward"
+	"github.com/danielpaulus/go-ios/ios/syslog"
+	"github.com/danielpaulus/go-ios/ios/testmanagerd"
+	"github.com/danielpaulus/go-ios/ios/workspace"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	wdaBundleID         = "com.facebook.WebDriverAgentRunner.xctrunner"
+	wdaXctestConfig     = "WebDriverAgentRunner.xctest"
+	wdaStartupTimeout   = 90 * time.Second
+	wdaPoolSizeEnvVar   = "GO_IOS_WDA_POOL_SIZE"
+	wdaDevicePortNumber = 8100
+)
+
+// WdaSession is a running WebDriverAgent with an open WebDriver session that is forwarded to a host port
+type WdaSession struct {
+	ID        string    `json:"id"`
+	UDID      string    `json:"udid"`
+	HostPort  int       `json:"hostPort"`
+	SessionID string    `json:"sessionId"`
+	Created   time.Time `json:"created"`
+	InUse     bool      `json:"inUse"`
+	Recording bool      `json:"recording"`
+	device    ios.DeviceEntry
+	stopWda   context.CancelFunc
+	stopped   <-chan struct{}
+	forwarder *forward.ConnListener
+	recording *sessionRecording
+	macro     *macroRecorder
+}
+
+func (s *WdaSession) close() {
+	s.stopWda()
+	if s.forwarder != nil {
+		s.forwarder.Close()
+	}
+}
+
+// errWdaBusy is returned if the WDA of the device is in use already. Only one WDA can run on a device,
+// a second test runner would fight the first one for the runner and the forwarded port.
+var errWdaBusy = errors.New("WDA of the device is in use")
+
+// wdaPool keeps a pre-started WDA session per idle device, so clients don't have to wait for the test runner
+// to start up when they allocate a device. There is at most one WDA per device, in use, idle or starting.
+type wdaPool struct {
+	size      int
+	mu        sync.Mutex
+	startDone *sync.Cond
+	sessions  map[string][]*WdaSession
+	starting  map[string]int
+}
+
+var sessionPool = newWdaPool(wdaPoolSizeFromEnv())
+
+func newWdaPool(size int) *wdaPool {
+	p := &wdaPool{size: size, sessions: map[string][]*WdaSession{}, starting: map[string]int{}}
+	p.startDone = sync.NewCond(&p.mu)
+	return p
+}
+
+func wdaPoolSizeFromEnv() int {
+	value, ok := os.LookupEnv(wdaPoolSizeEnvVar)
+	if !ok {
+		return 0
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		log.WithField("value", value).Warnf("invalid %s, pre-warming of WDA sessions disabled", wdaPoolSizeEnvVar)
+		return 0
+	}
+	if size > 1 {
+		log.WithField("value", value).Warnf("%s is capped at 1, only one WDA can run on a device", wdaPoolSizeEnvVar)
+		return 1
+	}
+	return size
+}
+
+// warmUpConnectedDevices fills the pool for all devices that are currently connected
+func (p *wdaPool) warmUpConnectedDevices() {
+	if p.size == 0 {
+		return
+	}
+	list, err := usbmuxdList()
+	if err != nil {
+		log.WithError(err).Warn("could not list devices for WDA pool warm up")
+		return
+	}
+	for _, device := range list.DeviceList {
+		go p.refill(device)
+	}
+}
+
+// acquire returns the idle pre-warmed session of the device if there is one, otherwise a new session is started.
+// It returns errWdaBusy if the WDA of the device is in use already. If record is set, the screen, the syslog and
+// all requests proxied to WDA are recorded until the session is released.
+func (p *wdaPool) acquire(device ios.DeviceEntry, record bool) (*WdaSession, error) {
+	udid := device.Properties.SerialNumber
+	session, err := p.reserve(udid)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		s, err := startWda(device, p.evict)
+		if err == nil {
+			s.InUse = true
+		}
+		err = p.finishStart(udid, s, err)
+		if err != nil {
+			return nil, err
+		}
+		session = s
+	}
+
+	if record {
+		recording, err := startSessionRecording(device, session.ID)
+		if err != nil {
+			log.WithError(err).Warn("could not start session recording")
+		} else {
+			p.mu.Lock()
+			session.recording = recording
+			session.Recording = true
+			p.mu.Unlock()
+		}
+	}
+	return session, nil
+}
+
+// borrow calls f with the WDA url of a session of the device. The calls need no WebDriver session, so a session
+// that is in use works as well. If the device has no session, one is started for f and stopped again. It is used
+// for single WDA calls like pressing the home button.
+func (p *wdaPool) borrow(device ios.DeviceEntry, f func(baseURL string) error) error {
+	udid := device.Properties.SerialNumber
+	p.mu.Lock()
+	var session *WdaSession
+	if len(p.sessions[udid]) > 0 {
+		session = p.sessions[udid][0]
+	}
+	p.mu.Unlock()
+	if session == nil {
+		s, err := p.reserve(udid)
+		if errors.Is(err, errWdaBusy) {
+			return p.borrow(device, f)
+		}
+		if err != nil {
+			return err
+		}
+		if s != nil {
+			defer func() {
+				p.mu.Lock()
+				s.InUse = false
+				p.mu.Unlock()
+			}()
+		} else {
+			s, err = startWda(device, p.evict)
+			err = p.finishStart(udid, nil, err)
+			if err != nil {
+				return err
+			}
+			defer s.close()
+		}
+		session = s
+	}
+	return f(fmt.Sprintf("http://127.0.0.1:%d", session.HostPort))
+}
+
+// reserve marks the idle session of the device as in use and returns it. If the device has no session, the start
+// of a new one is reserved and nil is returned, the caller has to report the outcome with finishStart. If a session
+// is starting already, reserve waits for it.
+func (p *wdaPool) reserve(udid string) (*WdaSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		for _, s := range p.sessions[udid] {
+			if !s.InUse {
+				s.InUse = true
+				return s, nil
+			}
+		}
+		if len(p.sessions[udid]) > 0 {
+			return nil, errWdaBusy
+		}
+		if p.starting[udid] == 0 {
+			p.starting[udid]++
+			return nil, nil
+		}
+		p.startDone.Wait()
+	}
+}
+
+// finishStart finishes a start reserved with reserve and adds the session to the pool if it is not nil
+func (p *wdaPool) finishStart(udid string, session *WdaSession, err error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.startDone.Broadcast()
+	p.starting[udid]--
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return nil
+	}
+	select {
+	case <-session.stopped:
+		return fmt.Errorf("finishStart: WDA stopped right after it started")
+	default:
+	}
+	p.sessions[udid] = append(p.sessions[udid], session)
+	return nil
+}
+
+// get returns the session with the given id or nil if it does not exist
+func (p *wdaPool) get(udid string, id string) *WdaSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.sessions[udid] {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// release stops the session with the given id and pre-warms the next one. It returns false if no such session
+// exists. If the session was recorded, the path of the finished recording is returned as well.
+func (p *wdaPool) release(udid string, id string) (string, bool) {
+	session, recordingPath, ok := p.remove(udid, id, "released", "")
+	if ok {
+		go p.refill(session.device)
+	}
+	return recordingPath, ok
+}
+
+// evict removes a session whose WDA stopped on its own, so it is not handed out or listed anymore
+func (p *wdaPool) evict(session *WdaSession) {
+	_, _, ok := p.remove(session.UDID, session.ID, "failed", "WDA stopped")
+	if ok {
+		log.WithFields(log.Fields{"udid": session.UDID, "id": session.ID}).Warn("WDA stopped, removed its session from the pool")
+	}
+}
+
+// remove stops the session with the given id and finishes its recordings, state and errMessage are reported to the
+// job hooks
+func (p *wdaPool) remove(udid string, id string, state string, errMessage string) (*WdaSession, string, bool) {
+	p.mu.Lock()
+	var session *WdaSession
+	sessions := p.sessions[udid]
+	for i, s := range sessions {
+		if s.ID == id {
+			session = s
+			p.sessions[udid] = append(sessions[:i], sessions[i+1:]...)
+			break
+		}
+	}
+	p.mu.Unlock()
+	if session == nil {
+		return nil, "", false
+	}
+	session.close()
+	if session.InUse {
+		defer markSyslog(devices, udid, syslog.MarkerEnd, hookJobSession, id)
+		defer jobHooks.after(HookContext{Job: hookJobSession, ID: id, UDID: udid}, state, errMessage)
+	}
+	if macro := p.macroRecorder(session); macro != nil {
+		err := macros.put(macro.finish())
+		if err != nil {
+			log.WithError(err).Warn("could not save macro of released session")
+		}
+	}
+	recording := p.recording(session)
+	if recording == nil {
+		return session, "", true
+	}
+	recordingPath, err := recording.finish()
+	if err != nil {
+		log.WithError(err).Warn("could not save session recording")
+		return session, recordingPath, true
+	}
+	history.record(DeviceEvent{UDID: udid, Type: "session-recording", Message: "session recording finished", Recording: id})
+	return session, recordingPath, true
+}
+
+// startMacro records the input of the session into a macro. It returns false if the session does not exist or
+// records a macro already.
+func (p *wdaPool) startMacro(udid string, id string, recorder *macroRecorder) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.sessions[udid] {
+		if s.ID == id && s.macro == nil {
+			s.macro = recorder
+			return true
+		}
+	}
+	return false
+}
+
+// recording returns the recording of the session, it is finished already if the session was released meanwhile
+func (p *wdaPool) recording(session *WdaSession) *sessionRecording {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return session.recording
+}
+
+func (p *wdaPool) macroRecorder(session *WdaSession) *macroRecorder {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return session.macro
+}
+
+// stopMacro stops recording a macro, it returns nil if the session does not record one
+func (p *wdaPool) stopMacro(udid string, id string) *macroRecorder {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.sessions[udid] {
+		if s.ID == id {
+			recorder := s.macro
+			s.macro = nil
+			return recorder
+		}
+	}
+	return nil
+}
+
+// releaseIdle stops all sessions of the device that are not in use
+func (p *wdaPool) releaseIdle(udid string) {
+	for _, s := range p.list(udid) {
+		if !s.InUse {
+			p.release(udid, s.ID)
+		}
+	}
+}
+
+func (p *wdaPool) list(udid string) []WdaSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make([]WdaSession, len(p.sessions[udid]))
+	for i, s := range p.sessions[udid] {
+		result[i] = *s
+	}
+	return result
+}
+
+// refill pre-warms a session for the device if pre-warming is enabled and the device has no session yet.
+// Devices in maintenance are not refilled.
+func (p *wdaPool) refill(device ios.DeviceEntry) {
+	udid := device.Properties.SerialNumber
+	if _, ok := maintenance.active(udid); ok {
+		return
+	}
+	p.mu.Lock()
+	if p.size == 0 || len(p.sessions[udid])+p.starting[udid] > 0 {
+		p.mu.Unlock()
+		return
+	}
+	p.starting[udid]++
+	p.mu.Unlock()
+
+	s, err := startWda(device, p.evict)
+	err = p.finishStart(udid, s, err)
+	if err != nil {
+		log.WithFields(log.Fields{"udid": udid, "error": err}).Warn("failed pre-warming WDA session")
+	}
+}
+
+// wdaClient is used for the calls to WDA, so a hung WDA can't block a request or the pool forever
+var wdaClient = &http.Client{Timeout: time.Minute}
+
+// startWda is startWdaSession, tests replace it with a fake that needs no device
+var startWda = startWdaSession
+
+// startWdaSession starts WDA and creates a WebDriver session. onStop is called when the test runner of WDA
+// stopped, on its own or because the session was closed.
+func startWdaSession(device ios.DeviceEntry, onStop func(session *WdaSession)) (*WdaSession, error) {
+	hostPort, err := freePort()
+	if err != nil {
+		return nil, fmt.Errorf("startWdaSession: could not find free port: %w", err)
+	}
+	ctx, stopWda := context.WithCancel(context.Background())
+	session := &WdaSession{
+		ID:       uuid.New().String(),
+		UDID:     device.Properties.SerialNumber,
+		HostPort: hostPort,
+		Created:  time.Now(),
+		device:   device,
+		stopWda:  stopWda,
+		stopped:  ctx.Done(),
+	}
+	ws, err := workspace.Default().New("wda-" + session.ID)
+	if err != nil {
+		stopWda()
+		return nil, fmt.Errorf("startWdaSession: %w", err)
+	}
+	go func() {
+		// attachments are only written while WDA runs
+		defer ws.Close()
+		env := []string{fmt.Sprintf("USE_PORT=%d", wdaDevicePortNumber)}
+		listener := testmanagerd.NewTestListener(io.Discard, io.Discard, ws.Dir)
+		_, err := testmanagerd.RunXCUIWithBundleIdsCtx(ctx, wdaBundleID, wdaBundleID, wdaXctestConfig, device, nil, env, nil, nil, listener, false)
+		if err != nil {
+			log.WithFields(log.Fields{"udid": session.UDID, "error": err}).Info("WDA stopped")
+		}
+		stopWda()
+		onStop(session)
+	}()
+
+	session.forwarder, err = forward.Forward(device, uint16(hostPort), wdaDevicePortNumber)
+	if err != nil {
+		session.close()
+		return nil, fmt.Errorf("startWdaSession: could not forward port: %w", err)
+	}
+
+	baseURL := fmt.Sprintf("http://127.0.0.1:%d", hostPort)
+	err = waitForWda(ctx, baseURL)
+	if err != nil {
+		session.close()
+		return nil, err
+	}
+	session.SessionID, err = createWebDriverSession(ctx, baseURL)
+	if err != nil {
+		session.close()
+		return nil, err
+	}
+	return session, nil
+}
+
+func waitForWda(ctx context.Context, baseURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, wdaStartupTimeout)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("waitForWda: WDA not ready after %s", wdaStartupTimeout)
+			}
+			return fmt.Errorf("waitForWda: WDA stopped before it became ready")
+		case <-time.After(time.Second):
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/status", nil)
+		if err != nil {
+			return fmt.Errorf("waitForWda: %w", err)
+		}
+		resp, err := wdaClient.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+	}
+}
+
+func createWebDriverSession(ctx context.Context, baseURL string) (string, error) {
+	body := bytes.NewBufferString(`{"capabilities":{}}`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/session", body)
+	if err != nil {
+		return "", fmt.Errorf("createWebDriverSession: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wdaClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("createWebDriverSession: %w", err)
+	}
+	defer resp.Body.Close()
+	var response struct {
+		SessionID string `json:"sessionId"`
+		Value     struct {
+			SessionID string `json:"sessionId"`
+		} `json:"value"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return "", fmt.Errorf("createWebDriverSession: could not decode response: %w", err)
+	}
+	if response.Value.SessionID != "" {
+		return response.Value.SessionID, nil
+	}
+	return response.SessionID, nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Acquire a WDA session for a device
+// @Summary      Acquire a WebDriverAgent session
+// @Description  Returns a running WebDriverAgent session forwarded to a host port. Only one session can run on a device, a pre-warmed session is handed out instantly if GO_IOS_WDA_POOL_SIZE is set to 1.
+// @Tags         wda
+// @Produce      json
+// @Success      200  {object}  WdaSession
+// @Failure      409  {object}  GenericResponse
+// @Failure      424  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Param        udid path string true "Device UDID"
+// @Param        record query string false "Record screen, syslog and proxied input events of the session - true/false. At most 512 MB of screen frames are recorded."
+// @Router       /device/{udid}/wda/session [post]
+func AcquireWdaSession(c *gin.Context) {
+	device := MustGetDevice(c)
+	if occurrence, ok := maintenance.active(device.Properties.SerialNumber); ok {
+		c.JSON(http.StatusConflict, GenericResponse{Error: "device is in maintenance until " + occurrence.End.Format(time.RFC3339)})
+		return
+	}
+	session, err := sessionPool.acquire(device, c.Query("record") == "true")
+	if errors.Is(err, errWdaBusy) {
+		c.JSON(http.StatusConflict, GenericResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	udid, id := device.Properties.SerialNumber, session.ID
+	markSyslog(devices, udid, syslog.MarkerStart, hookJobSession, id)
+	err = jobHooks.before(c.Request.Context(), HookContext{Job: hookJobSession, ID: id, UDID: udid})
+	if err != nil {
+		sessionPool.release(udid, id)
+		c.JSON(http.StatusFailedDependency, GenericResponse{Error: err.Error()})
+		return
+	}
+	ci.created(udid, "wda-session", id, func() error {
+		sessionPool.release(udid, id)
+		return nil
+	})
+	c.JSON(http.StatusOK, session)
+}
+
+// Release a WDA session
+// @Summary      Release a WebDriverAgent session
+// @Description  Stops the WebDriverAgent session with the given id. For recorded sessions the recording can be downloaded afterwards.
+// @Tags         wda
+// @Produce      json
+// @Success      200  {object}  GenericResponse
+// @Failure      404  {object}  GenericResponse
+// @Param        udid path string true "Device UDID"
+// @Param        id path string true "Session id"
+// @Router       /device/{udid}/wda/session/{id} [delete]
+func ReleaseWdaSession(c *gin.Context) {
+	device := MustGetDevice(c)
+	recordingPath, found := sessionPool.release(device.Properties.SerialNumber, c.Param("id"))
+	if !found {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "session not found"})
+		return
+	}
+	ci.removed(device.Properties.SerialNumber, "wda-session", c.Param("id"))
+	if recordingPath != "" {
+		c.JSON(http.StatusOK, GenericResponse{Message: "session released, recording available at /device/" + device.Properties.SerialNumber + "/wda/recording/" + c.Param("id")})
+		return
+	}
+	c.JSON(http.StatusOK, GenericResponse{Message: "session released"})
+}
+
+// Proxy requests to WDA
+// @Summary      Send a request to the WebDriverAgent of a session
+// @Description  Forwards the request to the WebDriverAgent of the session. Requests are recorded as input events if the session is recorded.
+// @Tags         wda
+// @Success      200
+// @Failure      404  {object}  GenericResponse
+// @Param        udid path string true "Device UDID"
+// @Param        id path string true "Session id"
+// @Param        path path string true "WDA path, eg. /session/{sessionId}/wda/tap"
+// @Router       /device/{udid}/wda/session/{id}/proxy/{path} [post]
+func ProxyWdaSession(c *gin.Context) {
+	device := MustGetDevice(c)
+	session := sessionPool.get(device.Properties.SerialNumber, c.Param("id"))
+	if session == nil {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "session not found"})
+		return
+	}
+	path := c.Param("path")
+	macro := sessionPool.macroRecorder(session)
+	recording := sessionPool.recording(session)
+	if (recording != nil || macro != nil) && c.Request.Method != http.MethodGet {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, GenericResponse{Error: err.Error()})
+			return
+		}
+		if recording != nil {
+			recording.recordInput(c.Request.Method, path, body)
+		}
+		if macro != nil {
+			macro.record(path, body)
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	target := &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", session.HostPort)}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	c.Request.URL.Path = path
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// List WDA sessions
+// @Summary      List WebDriverAgent sessions
+// @Description  Lists the pre-warmed and in use WebDriverAgent sessions of a device
+// @Tags         wda
+// @Produce      json
+// @Success      200  {object}  []WdaSession
+// @Param        udid path string true "Device UDID"
+// @Router       /device/{udid}/wda/sessions [get]
+func ListWdaSessions(c *gin.Context) {
+	device := MustGetDevice(c)
+	c.JSON(http.StatusOK, sessionPool.list(device.Properties.SerialNumber))
+}
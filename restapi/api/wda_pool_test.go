@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWdaStarter starts sessions without a device, their runner stops when the session is closed
+type fakeWdaStarter struct {
+	mu      sync.Mutex
+	started int
+	release chan struct{}
+}
+
+func (f *fakeWdaStarter) start(device ios.DeviceEntry, onStop func(session *WdaSession)) (*WdaSession, error) {
+	if f.release != nil {
+		<-f.release
+	}
+	f.mu.Lock()
+	f.started++
+	id := f.started
+	f.mu.Unlock()
+	ctx, stopWda := context.WithCancel(context.Background())
+	session := &WdaSession{ID: fmt.Sprintf("wda-%d", id), UDID: device.Properties.SerialNumber, HostPort: 8100 + id,
+		device: device, stopWda: stopWda, stopped: ctx.Done()}
+	go func() {
+		<-ctx.Done()
+		onStop(session)
+	}()
+	return session, nil
+}
+
+func (f *fakeWdaStarter) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.started
+}
+
+func fakeWda(t *testing.T) *fakeWdaStarter {
+	starter := &fakeWdaStarter{}
+	original := startWda
+	t.Cleanup(func() { startWda = original })
+	startWda = starter.start
+	return starter
+}
+
+func TestWdaPoolAcquireAndRelease(t *testing.T) {
+	starter := fakeWda(t)
+	pool := newWdaPool(0)
+	device := testDevice("pool-udid")
+
+	session, err := pool.acquire(device, false)
+	require.NoError(t, err)
+	assert.True(t, session.InUse)
+	assert.Equal(t, 1, starter.count())
+
+	_, err = pool.acquire(device, false)
+	assert.ErrorIs(t, err, errWdaBusy, "only one WDA can run on a device")
+	assert.Equal(t, 1, starter.count())
+
+	_, ok := pool.release("pool-udid", session.ID)
+	require.True(t, ok)
+	assert.Empty(t, pool.list("pool-udid"))
+	<-session.stopped
+	_, ok = pool.release("pool-udid", session.ID)
+	assert.False(t, ok)
+
+	session, err = pool.acquire(device, false)
+	require.NoError(t, err)
+	assert.Equal(t, "wda-2", session.ID)
+	assert.Len(t, pool.list("pool-udid"), 1, "without pre-warming no session is started after a release")
+}
+
+func TestWdaPoolRefill(t *testing.T) {
+	starter := fakeWda(t)
+	pool := newWdaPool(1)
+	device := testDevice("pool-udid")
+
+	pool.refill(device)
+	pool.refill(device)
+	sessions := pool.list("pool-udid")
+	require.Len(t, sessions, 1)
+	assert.False(t, sessions[0].InUse)
+
+	session, err := pool.acquire(device, false)
+	require.NoError(t, err)
+	assert.Equal(t, sessions[0].ID, session.ID, "the pre-warmed session is handed out")
+	pool.refill(device)
+	assert.Equal(t, 1, starter.count(), "no second WDA is started while the session is in use")
+
+	pool.release("pool-udid", session.ID)
+	assert.Eventually(t, func() bool {
+		sessions := pool.list("pool-udid")
+		return len(sessions) == 1 && !sessions[0].InUse && sessions[0].ID != session.ID
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, starter.count())
+}
+
+func TestWdaPoolAcquireWaitsForStartingSession(t *testing.T) {
+	starter := fakeWda(t)
+	starter.release = make(chan struct{})
+	pool := newWdaPool(1)
+	device := testDevice("pool-udid")
+
+	go pool.refill(device)
+	assert.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return pool.starting["pool-udid"] == 1
+	}, time.Second, time.Millisecond)
+	acquired := make(chan *WdaSession)
+	go func() {
+		session, err := pool.acquire(device, false)
+		assert.NoError(t, err)
+		acquired <- session
+	}()
+	close(starter.release)
+	session := <-acquired
+	assert.Equal(t, "wda-1", session.ID)
+	assert.Equal(t, 1, starter.count())
+}
+
+func TestWdaPoolEvictsStoppedSessions(t *testing.T) {
+	starter := fakeWda(t)
+	pool := newWdaPool(0)
+	device := testDevice("pool-udid")
+
+	session, err := pool.acquire(device, false)
+	require.NoError(t, err)
+	// the test runner dies
+	session.stopWda()
+	assert.Eventually(t, func() bool { return len(pool.list("pool-udid")) == 0 }, time.Second, 10*time.Millisecond)
+	assert.Nil(t, pool.get("pool-udid", session.ID))
+
+	session, err = pool.acquire(device, false)
+	require.NoError(t, err)
+	assert.Equal(t, "wda-2", session.ID)
+	assert.Equal(t, 2, starter.count())
+}
+
+func TestWdaPoolBorrowUsesSessionInUse(t *testing.T) {
+	starter := fakeWda(t)
+	pool := newWdaPool(0)
+	device := testDevice("pool-udid")
+
+	var borrowed string
+	err := pool.borrow(device, func(baseURL string) error {
+		borrowed = baseURL
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:8101", borrowed)
+	assert.Empty(t, pool.list("pool-udid"), "the session started for borrow is stopped again")
+
+	session, err := pool.acquire(device, false)
+	require.NoError(t, err)
+	err = pool.borrow(device, func(baseURL string) error {
+		borrowed = baseURL
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:"+strconv.Itoa(session.HostPort), borrowed)
+	assert.Equal(t, 2, starter.count())
+}
+
+func TestCreateWebDriverSessionTimesOut(t *testing.T) {
+	hung := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-hung }))
+	defer server.Close()
+	defer close(hung)
+	original := wdaClient
+	t.Cleanup(func() { wdaClient = original })
+	wdaClient = &http.Client{Timeout: 50 * time.Millisecond}
+
+	_, err := createWebDriverSession(context.Background(), server.URL)
+	var urlErr *url.Error
+	require.ErrorAs(t, err, &urlErr)
+	assert.True(t, urlErr.Timeout())
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/afc"
+	"github.com/danielpaulus/go-ios/ios/house_arrest"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+)
+
+// davPath is the catch all path of the webdav routes
+const davPath = "/dav/*path"
+
+// davMethods are the methods of WebDAV class 1 and 2 clients
+var davMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// deviceFS is a file system of a device served with WebDAV
+type deviceFS interface {
+	webdav.FileSystem
+	Close()
+}
+
+// openDeviceFS opens the media directory of the device with afc, or the container of the app if bundleID is set.
+// Tests replace it.
+var openDeviceFS = func(device ios.DeviceEntry, bundleID string) (deviceFS, error) {
+	var conn *afc.Connection
+	var err error
+	if bundleID == "" {
+		conn, err = afc.New(device)
+	} else {
+		conn, err = house_arrest.NewAFC(device, bundleID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return afcFS{conn: conn}, nil
+}
+
+// davLocks keeps the WebDAV locks of every file system, clients hold locks across requests
+var davLocks = struct {
+	sync.Mutex
+	systems map[string]webdav.LockSystem
+}{systems: map[string]webdav.LockSystem{}}
+
+func davLockSystem(key string) webdav.LockSystem {
+	davLocks.Lock()
+	defer davLocks.Unlock()
+	ls, ok := davLocks.systems[key]
+	if !ok {
+		ls = webdav.NewMemLS()
+		davLocks.systems[key] = ls
+	}
+	return ls
+}
+
+// isDAVRoute reports whether the request goes to a WebDAV route
+func isDAVRoute(c *gin.Context) bool {
+	return strings.HasSuffix(c.FullPath(), davPath)
+}
+
+// ServeDeviceDAV serves a file system of the device with WebDAV
+// @Summary      Mount device files with WebDAV
+// @Description  Serves the media directory of the device (DCIM, Downloads, ...) at /dav and the container of an app signed for development at /apps/{bundleID}/dav. Mount them with Finder, davfs2, rclone or any WebDAV client. If authentication is enabled, clients send an api key or jwt as basic auth password, the user name is ignored.
+// @Tags         files
+// @Param        udid path string true "Device UDID"
+// @Param        path path string true "path in the file system"
+// @Success      200
+// @Failure      422  {object}  GenericResponse
+// @Router       /device/{udid}/dav/{path} [get]
+func ServeDeviceDAV(c *gin.Context) {
+	device := MustGetDevice(c)
+	bundleID := c.Param("bundleID")
+	fileSystem, err := openDeviceFS(device, bundleID)
+	if err != nil {
+		message := fmt.Sprintf("cannot open the file system of the device: %s", err.Error())
+		if bundleID != "" {
+			message = fmt.Sprintf("%s, only the containers of installed apps signed for development can be accessed", err.Error())
+		}
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: message})
+		return
+	}
+	defer fileSystem.Close()
+	handler := &webdav.Handler{
+		Prefix:     strings.TrimSuffix(c.Request.URL.Path, c.Param("path")),
+		FileSystem: fileSystem,
+		LockSystem: davLockSystem(device.Properties.SerialNumber + "/" + bundleID),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.WithError(err).WithFields(log.Fields{"method": r.Method, "path": r.URL.Path}).Debug("webdav request failed")
+			}
+		},
+	}
+	handler.ServeHTTP(c.Writer, c.Request)
+}
+
+// afcFS is the webdav.FileSystem of an afc connection
+type afcFS struct {
+	conn *afc.Connection
+}
+
+// davError turns afc errors into the os errors webdav checks for
+func davError(op string, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, afc.ErrObjectNotFound) {
+		err = os.ErrNotExist
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+func (a afcFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if _, err := a.conn.Stat(name); err == nil {
+		return davError("mkdir", name, os.ErrExist)
+	}
+	// afc creates missing parents, webdav expects mkdir to fail then
+	if _, err := a.conn.Stat(path.Dir(name)); err != nil {
+		return davError("mkdir", name, err)
+	}
+	return davError("mkdir", name, a.conn.MkDir(name))
+}
+
+func (a afcFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	info, err := a.Stat(ctx, name)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !exists && flag&os.O_CREATE == 0 {
+		return nil, err
+	}
+	if exists && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, davError("open", name, os.ErrExist)
+	}
+	if exists && info.IsDir() {
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, davError("open", name, errors.New("is a directory"))
+		}
+		return &afcDir{fs: a, name: name, info: info}, nil
+	}
+	f, err := a.conn.Open(name, afc.OpenMode(flag, exists))
+	if err != nil {
+		return nil, davError("open", name, err)
+	}
+	return &afcFile{File: f, fs: a, name: name}, nil
+}
+
+func (a afcFS) RemoveAll(ctx context.Context, name string) error {
+	if path.Clean(name) == "/" {
+		return davError("remove", name, os.ErrPermission)
+	}
+	return davError("remove", name, a.conn.RemovePathAndContents(name))
+}
+
+func (a afcFS) Rename(ctx context.Context, oldName, newName string) error {
+	return davError("rename", oldName, a.conn.Rename(oldName, newName))
+}
+
+func (a afcFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := a.conn.Stat(name)
+	if err != nil {
+		return nil, davError("stat", name, err)
+	}
+	return afcFileInfo{name: path.Base(name), size: info.Size(), dir: info.IsDir(), modTime: info.ModTime()}, nil
+}
+
+func (a afcFS) Close() {
+	a.conn.Close()
+}
+
+type afcFileInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+}
+
+func (i afcFileInfo) Name() string { return i.name }
+
+func (i afcFileInfo) Size() int64 { return i.size }
+
+func (i afcFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+func (i afcFileInfo) ModTime() time.Time { return i.modTime }
+
+func (i afcFileInfo) IsDir() bool { return i.dir }
+
+func (i afcFileInfo) Sys() interface{} { return nil }
+
+// afcFile is a regular file, it reads and writes on the device
+type afcFile struct {
+	*afc.File
+	fs   afcFS
+	name string
+}
+
+func (f *afcFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, davError("readdir", f.name, errors.New("not a directory"))
+}
+
+func (f *afcFile) Stat() (fs.FileInfo, error) {
+	return f.fs.Stat(context.Background(), f.name)
+}
+
+// afcDir is a directory, it can only be listed
+type afcDir struct {
+	fs     afcFS
+	name   string
+	info   os.FileInfo
+	listed bool
+}
+
+func (d *afcDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if d.listed {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	d.listed = true
+	names, err := d.fs.conn.ReadDir(d.name)
+	if err != nil {
+		return nil, davError("readdir", d.name, err)
+	}
+	infos := make([]fs.FileInfo, 0, len(names))
+	for _, name := range names {
+		info, err := d.fs.Stat(context.Background(), path.Join(d.name, name))
+		if err != nil {
+			// files can be removed by apps while listing
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (d *afcDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+
+func (d *afcDir) Read(p []byte) (int, error) {
+	return 0, davError("read", d.name, errors.New("is a directory"))
+}
+
+func (d *afcDir) Write(p []byte) (int, error) {
+	return 0, davError("write", d.name, errors.New("is a directory"))
+}
+
+func (d *afcDir) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+
+func (d *afcDir) Close() error { return nil }
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+type memDeviceFS struct {
+	webdav.FileSystem
+}
+
+func (memDeviceFS) Close() {}
+
+func davRouter(t *testing.T, list ...Authenticator) (*gin.Engine, map[string]deviceFS) {
+	gin.SetMode(gin.TestMode)
+	devices.Put(testDevice("dav-a"))
+	t.Cleanup(func() { devices.Remove("dav-a") })
+	previous := authn
+	authn = &authenticators{list: list}
+	t.Cleanup(func() { authn = previous })
+	systems := map[string]deviceFS{"": memDeviceFS{webdav.NewMemFS()}, "com.example.debug": memDeviceFS{webdav.NewMemFS()}}
+	open := openDeviceFS
+	t.Cleanup(func() { openDeviceFS = open })
+	openDeviceFS = func(device ios.DeviceEntry, bundleID string) (deviceFS, error) {
+		fileSystem, ok := systems[bundleID]
+		if !ok {
+			return nil, errors.New("InstallationLookupFailed")
+		}
+		return fileSystem, nil
+	}
+	r := gin.New()
+	davRoutes(r.Group("/api/v1/device/:udid", AuthMiddleware(), DeviceMiddleware()))
+	return r, systems
+}
+
+func davRequest(r *gin.Engine, method string, target string, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestDeviceDAV(t *testing.T) {
+	r, systems := davRouter(t)
+	base := "/api/v1/device/dav-a/dav"
+
+	assert.Equal(t, http.StatusCreated, davRequest(r, "MKCOL", base+"/Downloads", "").Code)
+	assert.Equal(t, http.StatusCreated, davRequest(r, http.MethodPut, base+"/Downloads/report.txt", "passed").Code)
+
+	w := davRequest(r, http.MethodGet, base+"/Downloads/report.txt", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "passed", w.Body.String())
+
+	w = davRequest(r, "PROPFIND", base+"/Downloads/", "", "Depth", "1")
+	require.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "<D:href>"+base+"/Downloads/report.txt</D:href>", "hrefs keep the device prefix")
+
+	w = davRequest(r, "MOVE", base+"/Downloads/report.txt", "", "Destination", "http://example.com"+base+"/Downloads/old.txt")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	_, err := systems[""].Stat(context.Background(), "/Downloads/old.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, davRequest(r, http.MethodDelete, base+"/Downloads/old.txt", "").Code)
+	assert.Equal(t, http.StatusNotFound, davRequest(r, http.MethodGet, base+"/Downloads/old.txt", "").Code)
+
+	apps := "/api/v1/device/dav-a/apps/com.example.debug/dav"
+	assert.Equal(t, http.StatusCreated, davRequest(r, http.MethodPut, apps+"/seed.db", "fixture").Code)
+	f, err := systems["com.example.debug"].OpenFile(context.Background(), "/seed.db", 0, 0)
+	require.NoError(t, err)
+	b, _ := io.ReadAll(f)
+	assert.Equal(t, "fixture", string(b))
+	_, err = systems[""].Stat(context.Background(), "/seed.db")
+	assert.Error(t, err, "app containers and the media directory are separate")
+
+	w = davRequest(r, "PROPFIND", "/api/v1/device/dav-a/apps/com.example.release/dav/", "", "Depth", "1")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "signed for development")
+}
+
+func TestDeviceDAVAuth(t *testing.T) {
+	r, _ := davRouter(t, apiKeyAuthenticator{keys: []APIKey{
+		{Name: "dashboard", Key: "read-key", Scopes: []Scope{ScopeRead}},
+		{Name: "ci", Key: "control-key", Scopes: []Scope{ScopeControl}},
+	}})
+	base := "/api/v1/device/dav-a/dav"
+	basic := func(password string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth("anyone", password)
+		return req.Header.Get("Authorization")
+	}
+
+	w := davRequest(r, "PROPFIND", base+"/", "", "Depth", "1")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="go-ios"`, w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, davRequest(r, "PROPFIND", base+"/", "", "Authorization", basic("wrong")).Code)
+
+	assert.Equal(t, http.StatusMultiStatus, davRequest(r, "PROPFIND", base+"/", "", "Authorization", basic("read-key"), "Depth", "1").Code)
+	assert.Equal(t, http.StatusForbidden, davRequest(r, http.MethodPut, base+"/a.txt", "a", "Authorization", basic("read-key")).Code)
+	assert.Equal(t, http.StatusCreated, davRequest(r, http.MethodPut, base+"/a.txt", "a", "Authorization", basic("control-key")).Code)
+	assert.Equal(t, http.StatusCreated, davRequest(r, "MKCOL", base+"/b", "", "Authorization", "Bearer control-key").Code)
+}
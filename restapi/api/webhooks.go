@@ -0,0 +1,333 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/secrets"
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// webhooksEnvVar is the path of a json file with a list of Webhook. Changes made with PUT /webhooks are written
+// back to it.
+const webhooksEnvVar = "GO_IOS_WEBHOOKS"
+
+const (
+	// webhookQueueSize is how many events can wait for delivery per webhook, newer events are dropped
+	webhookQueueSize = 1000
+	// webhookAttempts is how often a delivery is tried before the event is dropped
+	webhookAttempts = 6
+	// webhookSignatureHeader is the hex HMAC-SHA256 of the body with the secret of the webhook
+	webhookSignatureHeader = "X-Go-Ios-Signature"
+)
+
+// webhookBackoff is the delay before the first retry, it doubles with every retry. Tests shorten it.
+var webhookBackoff = time.Second
+
+// Webhook posts device events to a URL
+type Webhook struct {
+	URL string `json:"url"`
+	// Events are the event types to post, like device-added, device-removed, device-paired, image-mounted or
+	// boot-completed. All events are posted if it is empty.
+	Events []string `json:"events,omitempty"`
+	// Secret signs the body, the signature is in the X-Go-Ios-Signature header as sha256=<hex>. It can be
+	// sealed with 'ios secrets seal-value'.
+	Secret string `json:"secret,omitempty"`
+}
+
+// WebhookEvent is the body posted to webhooks
+type WebhookEvent struct {
+	// ID is the same for all attempts of a delivery, receivers can use it to drop duplicates
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	UDID    string    `json:"udid"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message,omitempty"`
+}
+
+func (w Webhook) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook url '%s'", w.URL)
+	}
+	return nil
+}
+
+func (w Webhook) wants(eventType events.Type) bool {
+	return len(w.Events) == 0 || containsString(w.Events, string(eventType))
+}
+
+// redacted hides the secret, so webhooks can be listed without leaking it
+func (w Webhook) redacted() Webhook {
+	if w.Secret != "" {
+		w.Secret = "redacted"
+	}
+	return w
+}
+
+// sign returns the value of the signature header
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookTarget delivers the events of one webhook in order, one at a time
+type webhookTarget struct {
+	webhook Webhook
+	secret  string
+	queue   chan WebhookEvent
+	stop    context.CancelFunc
+}
+
+// webhookDispatcher posts the events of the device history to the configured webhooks
+type webhookDispatcher struct {
+	mu      sync.Mutex
+	targets []*webhookTarget
+	path    string
+	client  *http.Client
+}
+
+var webhooks = &webhookDispatcher{client: &http.Client{Timeout: 10 * time.Second}}
+
+func (d *webhookDispatcher) list() []Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := make([]Webhook, 0, len(d.targets))
+	for _, target := range d.targets {
+		result = append(result, target.webhook)
+	}
+	return result
+}
+
+// set replaces the webhooks, events still queued for the old webhooks are dropped
+func (d *webhookDispatcher) set(hooks []Webhook) error {
+	keys, err := sealedSecrets.keys()
+	if err != nil {
+		return err
+	}
+	targets := make([]*webhookTarget, 0, len(hooks))
+	for _, hook := range hooks {
+		if err := hook.validate(); err != nil {
+			return err
+		}
+		secret, err := secrets.OpenString(keys, hook.Secret)
+		if err != nil {
+			return fmt.Errorf("failed opening secret of webhook %s: %w", hook.URL, err)
+		}
+		targets = append(targets, &webhookTarget{webhook: hook, secret: secret})
+	}
+	d.mu.Lock()
+	old := d.targets
+	d.targets = targets
+	for _, target := range targets {
+		ctx, cancel := context.WithCancel(context.Background())
+		target.queue = make(chan WebhookEvent, webhookQueueSize)
+		target.stop = cancel
+		go d.deliverAll(ctx, target)
+	}
+	path := d.path
+	d.mu.Unlock()
+	for _, target := range old {
+		target.stop()
+	}
+	if path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(hooks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+func (d *webhookDispatcher) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var hooks []Webhook
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &hooks)
+		if err != nil {
+			return fmt.Errorf("invalid webhooks in %s: %w", path, err)
+		}
+	}
+	err = d.set(hooks)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.path = path
+	d.mu.Unlock()
+	return nil
+}
+
+// loadWebhooks loads the webhooks configured with GO_IOS_WEBHOOKS
+func loadWebhooks() {
+	path := os.Getenv(webhooksEnvVar)
+	if path == "" {
+		return
+	}
+	err := webhooks.loadFile(path)
+	if err != nil {
+		log.WithError(err).Errorf("ignoring %s", webhooksEnvVar)
+	}
+}
+
+// dispatch queues the event for all webhooks that want it
+func (d *webhookDispatcher) dispatch(event DeviceEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, target := range d.targets {
+		if !target.webhook.wants(event.Type) {
+			continue
+		}
+		select {
+		case target.queue <- WebhookEvent{ID: uuid.New().String(), Type: string(event.Type), UDID: event.UDID, Time: event.Time, Message: event.Message}:
+		default:
+			log.WithField("webhook", target.webhook.URL).Warn("webhook queue full, dropping event")
+		}
+	}
+}
+
+// run posts the events recorded in h until ctx is done
+func (d *webhookDispatcher) run(ctx context.Context, h *deviceHistory) {
+	subscription := h.bus.Subscribe(events.Filter{}, webhookQueueSize)
+	defer subscription.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return
+			}
+			d.dispatch(event)
+		}
+	}
+}
+
+func (d *webhookDispatcher) deliverAll(ctx context.Context, target *webhookTarget) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-target.queue:
+			d.deliver(ctx, target, event)
+		}
+	}
+}
+
+// deliver posts the event and retries with exponential backoff on network errors, 429 and 5xx responses
+func (d *webhookDispatcher) deliver(ctx context.Context, target *webhookTarget, event WebhookEvent) {
+	logger := log.WithFields(log.Fields{"webhook": target.webhook.URL, "udid": event.UDID, "event": event.Type})
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.WithError(err).Error("failed encoding webhook event")
+		return
+	}
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		retry, err := d.post(ctx, target, event, body, attempt)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			logger.WithError(err).Warnf("dropping webhook event after %d attempts", attempt)
+			return
+		}
+		logger.WithError(err).Debugf("webhook attempt %d failed, retrying in %s", attempt, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (d *webhookDispatcher) post(ctx context.Context, target *webhookTarget, event WebhookEvent, body []byte, attempt int) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Go-Ios-Event", event.Type)
+	req.Header.Set("X-Go-Ios-Delivery", event.ID)
+	req.Header.Set("X-Go-Ios-Attempt", strconv.Itoa(attempt))
+	if target.secret != "" {
+		req.Header.Set(webhookSignatureHeader, sign(target.secret, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// ListWebhooks lists the webhooks
+// @Summary      List webhooks
+// @Description  Lists the webhooks device events are posted to, secrets are redacted. Needs the admin token.
+// @Tags         general
+// @Produce      json
+// @Success      200  {object}  []Webhook
+// @Router       /webhooks [get]
+func ListWebhooks(c *gin.Context) {
+	hooks := webhooks.list()
+	for i := range hooks {
+		hooks[i] = hooks[i].redacted()
+	}
+	c.JSON(http.StatusOK, hooks)
+}
+
+// SetWebhooks replaces the webhooks
+// @Summary      Replace the webhooks
+// @Description  Replaces all webhooks and writes them to the file at GO_IOS_WEBHOOKS, if it is set. Device events like device-added, device-removed, device-paired, image-mounted and boot-completed are posted as json with retries and exponential backoff. If a secret is set, the X-Go-Ios-Signature header has the HMAC-SHA256 of the body as sha256=<hex>. Needs the admin token.
+// @Tags         general
+// @Accept       json
+// @Produce      json
+// @Param        webhooks body []Webhook true "webhooks"
+// @Success      200  {object}  []Webhook
+// @Failure      422  {object}  GenericResponse
+// @Failure      500  {object}  GenericResponse
+// @Router       /webhooks [put]
+func SetWebhooks(c *gin.Context) {
+	var hooks []Webhook
+	err := c.ShouldBindJSON(&hooks)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	for _, hook := range hooks {
+		if err := hook.validate(); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+			return
+		}
+	}
+	err = webhooks.set(hooks)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, GenericResponse{Error: err.Error()})
+		return
+	}
+	ListWebhooks(c)
+}
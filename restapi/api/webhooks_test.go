@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios/secrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	var mu sync.Mutex
+	var received []WebhookEvent
+	var attempts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, r.Header.Get("X-Go-Ios-Attempt"))
+		if len(attempts) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, sign("s3cret", body), r.Header.Get(webhookSignatureHeader))
+		var event WebhookEvent
+		assert.NoError(t, json.Unmarshal(body, &event))
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	dispatcher := &webhookDispatcher{client: server.Client()}
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	require.NoError(t, dispatcher.loadFile(path))
+	require.NoError(t, dispatcher.set([]Webhook{{URL: server.URL, Events: []string{"device-added"}, Secret: "s3cret"}}))
+	defer dispatcher.set(nil)
+	h := newDeviceHistory()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.run(ctx, h)
+	require.Eventually(t, func() bool {
+		return h.bus.Subscribers() == 1
+	}, time.Second, time.Millisecond)
+
+	h.record(DeviceEvent{UDID: "hook-a", Type: "device-updated"})
+	h.record(DeviceEvent{UDID: "hook-a", Type: "device-added"})
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"1", "2"}, attempts, "5xx responses are retried")
+	assert.Equal(t, "device-added", received[0].Type)
+	assert.Equal(t, "hook-a", received[0].UDID)
+	mu.Unlock()
+
+	reloaded := &webhookDispatcher{client: server.Client()}
+	require.NoError(t, reloaded.loadFile(path))
+	assert.Equal(t, []Webhook{{URL: server.URL, Events: []string{"device-added"}, Secret: "s3cret"}}, reloaded.list())
+	assert.Equal(t, "redacted", reloaded.list()[0].redacted().Secret)
+	reloaded.set(nil)
+}
+
+func TestWebhookValidation(t *testing.T) {
+	t.Setenv(secrets.KMSEnvVar, "")
+	dispatcher := &webhookDispatcher{client: http.DefaultClient}
+	assert.Error(t, dispatcher.set([]Webhook{{URL: "ftp://example.com"}}))
+	assert.Error(t, dispatcher.set([]Webhook{{URL: "not a url"}}))
+	assert.Error(t, dispatcher.set([]Webhook{{URL: "https://example.com", Secret: "enc:sealed"}}), "sealed secrets need a key provider")
+	assert.Empty(t, dispatcher.list())
+}
+
+func TestBootCompleted(t *testing.T) {
+	h := newDeviceHistory()
+	assert.False(t, h.bootCompleted("boot-a"))
+	h.record(DeviceEvent{UDID: "boot-a", Type: "reboot-requested"})
+	assert.True(t, h.bootCompleted("boot-a"))
+	assert.False(t, h.bootCompleted("boot-a"), "only the first reconnect completes the boot")
+}
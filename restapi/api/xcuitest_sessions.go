@@ -0,0 +1,697 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/crashreport"
+	"github.com/danielpaulus/go-ios/ios/instruments"
+	"github.com/danielpaulus/go-ios/ios/instruments/perfmon"
+	"github.com/danielpaulus/go-ios/ios/secrets"
+	"github.com/danielpaulus/go-ios/ios/syslog"
+	"github.com/danielpaulus/go-ios/ios/testmanagerd"
+	"github.com/danielpaulus/go-ios/ios/workspace"
+	"github.com/danielpaulus/go-ios/restapi/events"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// xcuitestEventBufferSize is how many output lines and test events of a session are kept for clients
+	// that connect to the output stream late
+	xcuitestEventBufferSize = 5000
+	// xcuitestRetention is how long finished sessions can be queried
+	xcuitestRetention = time.Hour
+	// xcuitestStopTimeout is how long stop waits for the test runner to be killed
+	xcuitestStopTimeout = 30 * time.Second
+)
+
+// xcuitestStateFile keeps the test runners that are running, so they can be killed when the API restarts
+var xcuitestStateFile = filepath.Join(os.TempDir(), "go-ios-xcuitest-sessions.json")
+
+// XCUITestSessionState is the lifecycle state of a XCUITestSession
+type XCUITestSessionState string
+
+const (
+	XCUITestRunning  = XCUITestSessionState("running")
+	XCUITestFinished = XCUITestSessionState("finished")
+	XCUITestFailed   = XCUITestSessionState("failed")
+	XCUITestStopped  = XCUITestSessionState("stopped")
+)
+
+// XCUITestRequest configures the test run of a XCUITestSession. Only bundleId is required, the test runner
+// and xctest config are derived from it like Xcode names them. Use it to run WebDriverAgent as well.
+type XCUITestRequest struct {
+	BundleID           string   `json:"bundleId"`
+	TestRunnerBundleID string   `json:"testRunnerBundleId,omitempty"`
+	XCTestConfig       string   `json:"xctestConfig,omitempty"`
+	Args               []string `json:"args,omitempty"`
+	Env                []string `json:"env,omitempty"`
+	TestsToRun         []string `json:"testsToRun,omitempty"`
+	TestsToSkip        []string `json:"testsToSkip,omitempty"`
+	XCTest             bool     `json:"xctest,omitempty"`
+	// Shard runs only a part of testsToRun, start the same request with every index on its own device to split a suite
+	Shard *testmanagerd.Shard `json:"shard,omitempty"`
+	// Retries runs failed test cases again up to this many times, the summary counts those that passed then as flaky
+	Retries int `json:"retries,omitempty"`
+	// Exporters push the report of this session to external systems once it ended, in addition to the exporters
+	// configured with PUT /config/exporters
+	Exporters []ReportExporter `json:"exporters,omitempty"`
+	// CollectCrashes downloads the crash reports of the app under test written during the run and adds them to the
+	// exported report
+	CollectCrashes bool `json:"collectCrashes,omitempty"`
+	// EnergyProfiling samples the energy impact of the app and the test runner and the battery drain during the run
+	EnergyProfiling bool `json:"energyProfiling,omitempty"`
+	// LeakCheck samples the memory footprint of the app under test during the run, the leaks of the session report its
+	// growth per minute and whether it exceeds the thresholds. Empty thresholds use the defaults.
+	LeakCheck *perfmon.LeakThresholds `json:"leakCheck,omitempty"`
+	// CrashLoop fails the run as soon as the app under test crashed repeatedly within the window of the thresholds,
+	// crashLoop of the session has the verdict and the crash reports. Empty thresholds use the defaults.
+	CrashLoop *crashreport.LoopThresholds `json:"crashLoop,omitempty"`
+	// Secrets maps environment variables of the test runner to references of secrets, like
+	// vault:secret/ci/account#password. They are fetched when the test runner is launched and redacted from the output,
+	// the session never contains them.
+	Secrets map[string]string `json:"secrets,omitempty"`
+}
+
+// testOptions selects the tests of the request
+func (r XCUITestRequest) testOptions() []testmanagerd.TestOption {
+	opts := []testmanagerd.TestOption{testmanagerd.WithTestsToRun(r.TestsToRun), testmanagerd.WithTestsToSkip(r.TestsToSkip)}
+	if r.Shard != nil {
+		opts = append(opts, testmanagerd.WithShard(r.Shard.Index, r.Shard.Total))
+	}
+	if r.Retries > 0 {
+		opts = append(opts, testmanagerd.WithRetries(r.Retries))
+	}
+	if r.EnergyProfiling {
+		opts = append(opts, testmanagerd.WithEnergyProfiling())
+	}
+	return opts
+}
+
+// resolveTemplates replaces the template variables like {{udid}} in args, env and xctestConfig of the request
+func (r XCUITestRequest) resolveTemplates(vars testmanagerd.TemplateVars) XCUITestRequest {
+	r.Args = vars.Resolve(r.Args)
+	r.Env = vars.Resolve(r.Env)
+	r.XCTestConfig = vars.Resolve([]string{r.XCTestConfig})[0]
+	return r
+}
+
+// XCUITestSession is a XCUITest or WebDriverAgent run the API manages
+type XCUITestSession struct {
+	ID       string                          `json:"id"`
+	UDID     string                          `json:"udid"`
+	BundleID string                          `json:"bundleId"`
+	PID      uint64                          `json:"pid,omitempty"`
+	Started  time.Time                       `json:"started"`
+	Finished *time.Time                      `json:"finished,omitempty"`
+	State    XCUITestSessionState            `json:"state"`
+	Error    string                          `json:"error,omitempty"`
+	Summary  *testmanagerd.TestReportSummary `json:"summary,omitempty"`
+	// Crashes are the names of the crash reports collected after the run, download them from /crashes/{name}
+	Crashes []string `json:"crashes,omitempty"`
+	// Energy is the energy profile of runs with energyProfiling
+	Energy *testmanagerd.EnergyReport `json:"energy,omitempty"`
+	// Leaks is the memory trend of the app under test of runs with leakCheck
+	Leaks *perfmon.LeakReport `json:"leaks,omitempty"`
+	// CrashLoop is set if the run failed because the app under test crashed in a loop
+	CrashLoop *crashreport.CrashLoop `json:"crashLoop,omitempty"`
+	// Exports are the outcomes of pushing the report to external systems, they are set after the session ended
+	Exports []ExportStatus `json:"exports,omitempty"`
+}
+
+// xcuitestEvent is sent to clients of the output stream, name is the SSE event name
+type xcuitestEvent struct {
+	name string
+	data interface{}
+}
+
+type xcuitestSession struct {
+	mu      sync.Mutex
+	info    XCUITestSession
+	stop    context.CancelFunc
+	done    chan struct{}
+	events  []xcuitestEvent
+	dropped int
+	changed chan struct{}
+	partial bytes.Buffer
+	// redact are the values of the secrets injected into the test runner
+	redact []string
+}
+
+func (s *xcuitestSession) snapshot() XCUITestSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info
+}
+
+func (s *xcuitestSession) publish(name string, data interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publishLocked(name, data)
+}
+
+func (s *xcuitestSession) publishLocked(name string, data interface{}) {
+	s.events = append(s.events, xcuitestEvent{name: name, data: data})
+	if len(s.events) > xcuitestEventBufferSize {
+		drop := len(s.events) - xcuitestEventBufferSize
+		s.events = s.events[drop:]
+		s.dropped += drop
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Write publishes the test output line by line
+func (s *xcuitestSession) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partial.Write(p)
+	for {
+		line, err := s.partial.ReadString('\n')
+		if err != nil {
+			// keep the incomplete line until the rest of it is written
+			s.partial.Reset()
+			s.partial.WriteString(line)
+			return len(p), nil
+		}
+		s.publishLocked("log", secrets.Redact(line[:len(line)-1], s.redact))
+	}
+}
+
+// publishTestCase publishes a test case event without the injected secrets in its failure messages
+func (s *xcuitestSession) publishTestCase(name string, testCase testmanagerd.TestCase) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publishLocked(name, redactTestCase(testCase, s.redact))
+}
+
+func redactTestCase(testCase testmanagerd.TestCase, values []string) testmanagerd.TestCase {
+	if len(values) == 0 {
+		return testCase
+	}
+	testCase.Err.Message = secrets.Redact(testCase.Err.Message, values)
+	if len(testCase.Attempts) > 0 {
+		attempts := make([]testmanagerd.TestAttempt, len(testCase.Attempts))
+		for i, attempt := range testCase.Attempts {
+			attempt.Err.Message = secrets.Redact(attempt.Err.Message, values)
+			attempts[i] = attempt
+		}
+		testCase.Attempts = attempts
+	}
+	return testCase
+}
+
+// redactSuites removes the injected secrets from the failure messages of the test results before they are
+// reported and exported
+func redactSuites(suites []testmanagerd.TestSuite, values []string) []testmanagerd.TestSuite {
+	if len(values) == 0 {
+		return suites
+	}
+	redacted := make([]testmanagerd.TestSuite, len(suites))
+	for i, suite := range suites {
+		testCases := make([]testmanagerd.TestCase, len(suite.TestCases))
+		for j, testCase := range suite.TestCases {
+			testCases[j] = redactTestCase(testCase, values)
+		}
+		suite.TestCases = testCases
+		redacted[i] = suite
+	}
+	return redacted
+}
+
+// eventsFrom returns the events starting at the absolute index next, the index to continue with and a channel
+// that is closed when new events are published
+func (s *xcuitestSession) eventsFrom(next int) ([]xcuitestEvent, int, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if next < s.dropped {
+		next = s.dropped
+	}
+	events := append([]xcuitestEvent{}, s.events[next-s.dropped:]...)
+	return events, s.dropped + len(s.events), s.changed
+}
+
+type xcuitestStore struct {
+	mu       sync.Mutex
+	sessions map[string]*xcuitestSession
+	run      func(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error)
+}
+
+var xcuitests = newXCUITestStore()
+
+func newXCUITestStore() *xcuitestStore {
+	return &xcuitestStore{sessions: map[string]*xcuitestSession{}, run: runXCUITest}
+}
+
+func runXCUITest(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+	return testmanagerd.RunXCUITestWithOptions(ctx, request.BundleID, request.TestRunnerBundleID, request.XCTestConfig, device, request.Args, request.Env, listener, request.XCTest, request.testOptions()...)
+}
+
+// start runs the tests in the background. Only one session can run per device.
+func (s *xcuitestStore) start(device ios.DeviceEntry, request XCUITestRequest) (XCUITestSession, error) {
+	udid := device.Properties.SerialNumber
+	s.mu.Lock()
+	s.pruneLocked(time.Now())
+	for _, session := range s.sessions {
+		if info := session.snapshot(); info.UDID == udid && info.State == XCUITestRunning {
+			s.mu.Unlock()
+			return XCUITestSession{}, fmt.Errorf("session %s is running on the device already", info.ID)
+		}
+	}
+	ws, err := workspace.Default().New("xcuitest-" + udid)
+	if err != nil {
+		s.mu.Unlock()
+		return XCUITestSession{}, err
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	started := time.Now()
+	session := &xcuitestSession{
+		info:    XCUITestSession{ID: uuid.New().String(), UDID: udid, BundleID: request.BundleID, Started: started, State: XCUITestRunning},
+		stop:    stop,
+		done:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+	s.sessions[session.info.ID] = session
+	s.mu.Unlock()
+
+	listener := testmanagerd.NewTestListener(session, session, ws.Dir)
+	listener.Events = testmanagerd.TestEvents{
+		TestRunnerStarted: func(pid uint64) {
+			slos.observe(sloTestStart, started, nil)
+			session.mu.Lock()
+			session.info.PID = pid
+			session.mu.Unlock()
+			s.persist()
+		},
+		TestCaseStarted:  func(testCase testmanagerd.TestCase) { session.publish("testCaseStarted", testCase) },
+		TestCaseFinished: func(testCase testmanagerd.TestCase) { session.publishTestCase("testCaseFinished", testCase) },
+	}
+	go func() {
+		defer ws.Close()
+		endMonitoring := monitors.begin(udid, "xcuitest "+session.info.ID)
+		defer endMonitoring()
+		hook := HookContext{Job: hookJobTest, ID: session.info.ID, UDID: udid}
+		markSyslog(devices, udid, syslog.MarkerStart, hookJobTest, session.info.ID)
+		var suites []testmanagerd.TestSuite
+		var leaks *perfmon.LeakReport
+		var crashLoop *crashreport.CrashLoop
+		err := jobHooks.before(ctx, hook)
+		runRequest := request.resolveTemplates(testmanagerd.TemplateVars{
+			UDID:        udid,
+			WdaPort:     testmanagerd.WdaPortFromEnv(request.Env),
+			ArtifactDir: ws.Dir,
+			JobID:       session.info.ID,
+		})
+		var secretValues []string
+		if err == nil {
+			runRequest.Env, secretValues, err = injectSecrets(runRequest.Env, request.Secrets)
+			session.mu.Lock()
+			session.redact = secretValues
+			session.mu.Unlock()
+		}
+		if err == nil {
+			sampler := startLeakCheck(device, request)
+			runCtx, loopCheck := startCrashLoopCheck(ctx, device, request)
+			suites, err = s.run(runCtx, device, runRequest, listener)
+			crashLoop = loopCheck.stop()
+			if len(secretValues) > 0 {
+				suites = redactSuites(suites, secretValues)
+				if err != nil {
+					err = errors.New(secrets.Redact(err.Error(), secretValues))
+				}
+			}
+			if err != nil && ctx.Err() == nil && crashLoop == nil && session.snapshot().PID == 0 {
+				// the test runner did not start
+				slos.observe(sloTestStart, started, &err)
+			}
+			leaks = stopLeakCheck(udid, sampler, request)
+		}
+		var crashes []testmanagerd.TestAttachment
+		if request.CollectCrashes || crashLoop != nil {
+			crashes = collectCrashes(device, request.BundleID, started, ws.Path("crashes"))
+		}
+		summary := testmanagerd.NewTestReport(suites, err).Summary
+		now := time.Now()
+		session.mu.Lock()
+		session.info.Finished = &now
+		session.info.Summary = &summary
+		session.info.Energy = listener.Energy
+		session.info.Leaks = leaks
+		session.info.CrashLoop = crashLoop
+		for _, crash := range crashes {
+			session.info.Crashes = append(session.info.Crashes, crash.Name)
+		}
+		switch {
+		case ctx.Err() != nil:
+			session.info.State = XCUITestStopped
+		case crashLoop != nil:
+			session.info.State = XCUITestFailed
+			session.info.Error = crashLoop.Verdict
+		case err != nil:
+			session.info.State = XCUITestFailed
+			session.info.Error = err.Error()
+		default:
+			session.info.State = XCUITestFinished
+		}
+		info := session.info
+		session.publishLocked("finished", info)
+		session.mu.Unlock()
+		stop()
+		s.persist()
+		log.WithFields(log.Fields{"udid": udid, "session": info.ID, "state": info.State}).Info("xcuitest session ended")
+		if crashLoop != nil {
+			history.record(DeviceEvent{UDID: udid, Type: events.CrashLoop, Message: crashLoop.Verdict})
+		}
+		history.record(DeviceEvent{UDID: udid, Type: events.TestFinished, Message: info.BundleID, Test: &events.Test{
+			SessionID: info.ID,
+			BundleID:  info.BundleID,
+			State:     string(info.State),
+			Passed:    summary.Passed,
+			Failed:    summary.Failed,
+		}})
+		jobHooks.after(hook, string(info.State), info.Error)
+		markSyslog(devices, udid, syslog.MarkerEnd, hookJobTest, info.ID)
+		// stopping the session does not wait for the exports, they only need the workspace to stay
+		endMonitoring()
+		close(session.done)
+		s.export(info, suites, err, crashes, request.Exporters, session)
+	}()
+	return session.snapshot(), nil
+}
+
+// startLeakCheck starts sampling the app under test if the request has a leakCheck. A leak check that can't be
+// started does not fail the run, the session has no leak report then.
+func startLeakCheck(device ios.DeviceEntry, request XCUITestRequest) leakSampler {
+	if request.LeakCheck == nil {
+		return nil
+	}
+	sampler, err := startLeakSampling(device, request.BundleID)
+	if err != nil {
+		log.WithField("udid", device.Properties.SerialNumber).WithError(err).Warn("failed starting the leak check")
+		return nil
+	}
+	return sampler
+}
+
+// stopLeakCheck analyzes the memory trend of the app under test once the run ended
+func stopLeakCheck(udid string, sampler leakSampler, request XCUITestRequest) *perfmon.LeakReport {
+	if sampler == nil {
+		return nil
+	}
+	report, err := sampler.Stop(*request.LeakCheck)
+	logger := log.WithFields(log.Fields{"udid": udid, "bundleId": request.BundleID})
+	if err != nil {
+		logger.WithError(err).Warn("leak check sampling ended early")
+	}
+	if report.Leaking {
+		logger.Warn(report.Verdict)
+	}
+	return &report
+}
+
+// export pushes the report of an ended session to the exporters while its attachments still exist
+func (s *xcuitestStore) export(info XCUITestSession, suites []testmanagerd.TestSuite, runErr error, crashes []testmanagerd.TestAttachment, sessionExporters []ReportExporter, session *xcuitestSession) {
+	run, err := newExportedRun(info, suites, runErr, crashes)
+	if err != nil {
+		log.WithField("session", info.ID).WithError(err).Warn("failed preparing the export of the test report")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	exports := reportExporters.export(ctx, run, sessionExporters)
+	if exports == nil {
+		return
+	}
+	session.mu.Lock()
+	session.info.Exports = exports
+	session.mu.Unlock()
+}
+
+func (s *xcuitestStore) get(udid string, id string) (*xcuitestSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.snapshot().UDID != udid {
+		return nil, false
+	}
+	return session, true
+}
+
+func (s *xcuitestStore) list(udid string) []XCUITestSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked(time.Now())
+	result := []XCUITestSession{}
+	for _, session := range s.sessions {
+		if info := session.snapshot(); info.UDID == udid {
+			result = append(result, info)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Started.Before(result[j].Started) })
+	return result
+}
+
+func (s *xcuitestStore) pruneLocked(now time.Time) {
+	for id, session := range s.sessions {
+		info := session.snapshot()
+		if info.Finished != nil && now.Sub(*info.Finished) > xcuitestRetention {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// xcuitestRunner is a test runner process that was started by the API
+type xcuitestRunner struct {
+	UDID    string `json:"udid"`
+	Session string `json:"session"`
+	PID     uint64 `json:"pid"`
+}
+
+// persist writes the test runners of the running sessions to xcuitestStateFile
+func (s *xcuitestStore) persist() {
+	s.mu.Lock()
+	runners := []xcuitestRunner{}
+	for _, session := range s.sessions {
+		if info := session.snapshot(); info.State == XCUITestRunning && info.PID != 0 {
+			runners = append(runners, xcuitestRunner{UDID: info.UDID, Session: info.ID, PID: info.PID})
+		}
+	}
+	s.mu.Unlock()
+	b, err := json.Marshal(runners)
+	if err == nil {
+		err = os.WriteFile(xcuitestStateFile, b, 0o644)
+	}
+	if err != nil {
+		log.WithError(err).Warn("could not save running xcuitest sessions")
+	}
+}
+
+// cleanupXCUITestRunners kills the test runners of sessions that were still running when the API stopped.
+// Their test plans can't be controlled anymore and would keep the devices busy.
+func cleanupXCUITestRunners(kill func(udid string, pid uint64) error) {
+	b, err := os.ReadFile(xcuitestStateFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.WithError(err).Warn("could not read running xcuitest sessions")
+		}
+		return
+	}
+	defer os.Remove(xcuitestStateFile)
+	var runners []xcuitestRunner
+	err = json.Unmarshal(b, &runners)
+	if err != nil {
+		log.WithError(err).Warn("could not read running xcuitest sessions")
+		return
+	}
+	for _, runner := range runners {
+		logger := log.WithFields(log.Fields{"udid": runner.UDID, "session": runner.Session, "pid": runner.PID})
+		err := kill(runner.UDID, runner.PID)
+		if err != nil {
+			logger.WithError(err).Warn("could not kill test runner of previous run")
+			continue
+		}
+		logger.Info("killed test runner of previous run")
+	}
+}
+
+func killTestRunner(udid string, pid uint64) error {
+	device, err := ios.GetDevice(udid)
+	if err != nil {
+		return err
+	}
+	processControl, err := instruments.NewProcessControl(device)
+	if err != nil {
+		return err
+	}
+	defer processControl.Close()
+	return processControl.KillProcess(pid)
+}
+
+// StartXCUITest starts a XCUITest session
+// @Summary      Start a XCUITest or WebDriverAgent
+// @Description  Runs the tests of an installed test runner in the background, f.ex. WebDriverAgent. Only one session can run per device, test runners that are still running when the API restarts are killed on startup.
+// @Tags         xcuitest
+// @Accept       json
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        request body XCUITestRequest true "What to run, f.ex. bundleId com.facebook.WebDriverAgentRunner.xctrunner with xctestConfig WebDriverAgentRunner.xctest for WebDriverAgent. args, env and xctestConfig can use the template variables {{udid}}, {{wda_port}}, {{artifact_dir}} (the workspace of the session) and {{job_id}} (the session id)."
+// @Success      200  {object}  XCUITestSession
+// @Failure      409  {object}  GenericResponse
+// @Failure      422  {object}  GenericResponse
+// @Router       /device/{udid}/xcuitest/start [post]
+func StartXCUITest(c *gin.Context) {
+	device := MustGetDevice(c)
+	var request XCUITestRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil || request.BundleID == "" {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: "bundleId is required"})
+		return
+	}
+	if _, _, err := testmanagerd.NewTestSelection(request.testOptions()...).Resolve(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	for _, exporter := range request.Exporters {
+		if err := exporter.validate(); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+			return
+		}
+	}
+	if err := validateSecretRefs(request.Secrets); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, GenericResponse{Error: err.Error()})
+		return
+	}
+	session, err := xcuitests.start(device, request)
+	if err != nil {
+		c.JSON(http.StatusConflict, GenericResponse{Error: err.Error()})
+		return
+	}
+	udid := device.Properties.SerialNumber
+	ci.created(udid, "xcuitest", session.ID, func() error {
+		running, ok := xcuitests.get(udid, session.ID)
+		if !ok {
+			return nil
+		}
+		running.stop()
+		select {
+		case <-running.done:
+			return nil
+		case <-time.After(xcuitestStopTimeout):
+			return errors.New("test runner did not stop in time")
+		}
+	})
+	c.JSON(http.StatusOK, session)
+}
+
+// ListXCUITests lists the XCUITest sessions of a device
+// @Summary      List XCUITest sessions
+// @Description  Lists the running sessions and the sessions that finished within the last hour
+// @Tags         xcuitest
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Success      200  {object}  []XCUITestSession
+// @Router       /device/{udid}/xcuitest [get]
+func ListXCUITests(c *gin.Context) {
+	device := MustGetDevice(c)
+	c.JSON(http.StatusOK, xcuitests.list(device.Properties.SerialNumber))
+}
+
+// GetXCUITestStatus returns a XCUITest session
+// @Summary      Get the status of a XCUITest session
+// @Description  Returns the state, the pid of the test runner and, once the session ended, the summary of the test results
+// @Tags         xcuitest
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        sessionId path string true "Session id"
+// @Success      200  {object}  XCUITestSession
+// @Failure      404  {object}  GenericResponse
+// @Router       /device/{udid}/xcuitest/{sessionId}/status [get]
+func GetXCUITestStatus(c *gin.Context) {
+	device := MustGetDevice(c)
+	session, ok := xcuitests.get(device.Properties.SerialNumber, c.Param("sessionId"))
+	if !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "session not found"})
+		return
+	}
+	c.JSON(http.StatusOK, session.snapshot())
+}
+
+// StopXCUITest stops a XCUITest session
+// @Summary      Stop a XCUITest session
+// @Description  Kills the test runner and waits until the session stopped
+// @Tags         xcuitest
+// @Produce      json
+// @Param        udid path string true "Device UDID"
+// @Param        sessionId path string true "Session id"
+// @Success      200  {object}  XCUITestSession
+// @Failure      404  {object}  GenericResponse
+// @Failure      504  {object}  GenericResponse
+// @Router       /device/{udid}/xcuitest/{sessionId}/stop [post]
+func StopXCUITest(c *gin.Context) {
+	device := MustGetDevice(c)
+	session, ok := xcuitests.get(device.Properties.SerialNumber, c.Param("sessionId"))
+	if !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "session not found"})
+		return
+	}
+	session.stop()
+	select {
+	case <-session.done:
+		ci.removed(device.Properties.SerialNumber, "xcuitest", c.Param("sessionId"))
+		c.JSON(http.StatusOK, session.snapshot())
+	case <-time.After(xcuitestStopTimeout):
+		c.JSON(http.StatusGatewayTimeout, GenericResponse{Error: "test runner did not stop in time"})
+	}
+}
+
+// StreamXCUITestOutput streams the output of a XCUITest session
+// @Summary      Stream the output of a XCUITest session
+// @Description  Sends the output of the test runner as log events and the test results as testCaseStarted and testCaseFinished events. The last event is finished with the session. Output that was written before the client connected is sent first, up to the last 5000 events.
+// @Tags         xcuitest
+// @Produce      text/event-stream
+// @Param        udid path string true "Device UDID"
+// @Param        sessionId path string true "Session id"
+// @Success      200
+// @Failure      404  {object}  GenericResponse
+// @Router       /device/{udid}/xcuitest/{sessionId}/output [get]
+func StreamXCUITestOutput(c *gin.Context) {
+	device := MustGetDevice(c)
+	session, ok := xcuitests.get(device.Properties.SerialNumber, c.Param("sessionId"))
+	if !ok {
+		c.JSON(http.StatusNotFound, GenericResponse{Error: "session not found"})
+		return
+	}
+	next := 0
+	c.Stream(func(w io.Writer) bool {
+		events, following, changed := session.eventsFrom(next)
+		next = following
+		for _, event := range events {
+			c.SSEvent(event.name, event.data)
+			if event.name == "finished" {
+				return false
+			}
+		}
+		if len(events) > 0 {
+			return true
+		}
+		select {
+		case <-changed:
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielpaulus/go-ios/ios"
+	"github.com/danielpaulus/go-ios/ios/crashreport"
+	"github.com/danielpaulus/go-ios/ios/instruments/perfmon"
+	"github.com/danielpaulus/go-ios/ios/testmanagerd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitForXCUITest(t *testing.T, session *xcuitestSession) XCUITestSession {
+	select {
+	case <-session.done:
+	case <-time.After(time.Second):
+		t.Fatal("session did not end")
+	}
+	return session.snapshot()
+}
+
+func TestXCUITestSessionLifecycle(t *testing.T) {
+	xcuitestStateFile = filepath.Join(t.TempDir(), "sessions.json")
+	store := newXCUITestStore()
+	release := make(chan struct{})
+	store.run = func(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		listener.Events.TestRunnerStarted(42)
+		listener.LogMessage("Test Suite 'All tests' started\npartial ")
+		listener.LogMessage("line\n")
+		testCase := testmanagerd.TestCase{ClassName: "LoginTests", MethodName: "testLogin", Status: testmanagerd.StatusPassed}
+		listener.Events.TestCaseFinished(testCase)
+		<-release
+		return []testmanagerd.TestSuite{{Name: "LoginTests", TestCases: []testmanagerd.TestCase{testCase}}}, nil
+	}
+	device := testDevice("xcuitest-udid")
+
+	info, err := store.start(device, XCUITestRequest{BundleID: "com.example.app"})
+	require.NoError(t, err)
+	assert.Equal(t, XCUITestRunning, info.State)
+	_, err = store.start(device, XCUITestRequest{BundleID: "com.example.app"})
+	assert.Error(t, err, "only one session may run per device")
+
+	session, ok := store.get("xcuitest-udid", info.ID)
+	require.True(t, ok)
+	assert.Eventually(t, func() bool {
+		events, _, _ := session.eventsFrom(0)
+		return len(events) == 3
+	}, time.Second, time.Millisecond)
+	events, next, _ := session.eventsFrom(0)
+	assert.Equal(t, "Test Suite 'All tests' started", events[0].data)
+	assert.Equal(t, "partial line", events[1].data)
+	assert.Equal(t, "testCaseFinished", events[2].name)
+	assert.Equal(t, uint64(42), session.snapshot().PID)
+
+	var runners []xcuitestRunner
+	b, err := os.ReadFile(xcuitestStateFile)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &runners))
+	assert.Equal(t, []xcuitestRunner{{UDID: "xcuitest-udid", Session: info.ID, PID: 42}}, runners)
+
+	close(release)
+	info = waitForXCUITest(t, session)
+	assert.Equal(t, XCUITestFinished, info.State)
+	assert.Equal(t, 1, info.Summary.Passed)
+	events, _, _ = session.eventsFrom(next)
+	assert.Equal(t, "finished", events[len(events)-1].name)
+	assert.Len(t, store.list("xcuitest-udid"), 1)
+
+	b, err = os.ReadFile(xcuitestStateFile)
+	require.NoError(t, err)
+	assert.JSONEq(t, "[]", string(b), "finished runners must not be killed on restart")
+}
+
+func TestXCUITestSessionStop(t *testing.T) {
+	xcuitestStateFile = filepath.Join(t.TempDir(), "sessions.json")
+	store := newXCUITestStore()
+	store.run = func(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		<-ctx.Done()
+		return nil, errors.New("lost connection to testmanagerd")
+	}
+	info, err := store.start(testDevice("xcuitest-stop-udid"), XCUITestRequest{BundleID: "com.example.app"})
+	require.NoError(t, err)
+	session, _ := store.get("xcuitest-stop-udid", info.ID)
+	session.stop()
+	info = waitForXCUITest(t, session)
+	assert.Equal(t, XCUITestStopped, info.State)
+	_, ok := store.get("other-udid", info.ID)
+	assert.False(t, ok)
+}
+
+func TestCleanupXCUITestRunners(t *testing.T) {
+	xcuitestStateFile = filepath.Join(t.TempDir(), "sessions.json")
+	b, _ := json.Marshal([]xcuitestRunner{{UDID: "a", Session: "1", PID: 10}, {UDID: "b", Session: "2", PID: 20}})
+	require.NoError(t, os.WriteFile(xcuitestStateFile, b, 0o644))
+
+	killed := map[string]uint64{}
+	cleanupXCUITestRunners(func(udid string, pid uint64) error {
+		killed[udid] = pid
+		if udid == "b" {
+			return errors.New("device not connected")
+		}
+		return nil
+	})
+	assert.Equal(t, map[string]uint64{"a": 10, "b": 20}, killed)
+	_, err := os.Stat(xcuitestStateFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestXCUITestEnergyProfiling(t *testing.T) {
+	xcuitestStateFile = filepath.Join(t.TempDir(), "sessions.json")
+	request := XCUITestRequest{BundleID: "com.example.app", EnergyProfiling: true}
+	assert.True(t, testmanagerd.NewTestSelection(request.testOptions()...).EnergyProfiling)
+	store := newXCUITestStore()
+	energy := &testmanagerd.EnergyReport{BatteryStart: 90, BatteryEnd: 88, BatteryDrain: 2}
+	store.run = func(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		listener.Energy = energy
+		return nil, nil
+	}
+
+	info, err := store.start(testDevice("energy-udid"), request)
+	require.NoError(t, err)
+	session, _ := store.get("energy-udid", info.ID)
+	ended := waitForXCUITest(t, session)
+	assert.Equal(t, energy, ended.Energy)
+	run, err := newExportedRun(ended, nil, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, energy, run.report.Energy)
+}
+
+type fakeLeakSampler struct {
+	thresholds perfmon.LeakThresholds
+}
+
+func (f *fakeLeakSampler) Stop(thresholds perfmon.LeakThresholds) (perfmon.LeakReport, error) {
+	f.thresholds = thresholds
+	return perfmon.LeakReport{Process: "Example", Leaking: true, GrowthPerMinute: 4 << 20, Thresholds: thresholds}, nil
+}
+
+func TestXCUITestLeakCheck(t *testing.T) {
+	xcuitestStateFile = filepath.Join(t.TempDir(), "sessions.json")
+	original := startLeakSampling
+	t.Cleanup(func() { startLeakSampling = original })
+	sampler := &fakeLeakSampler{}
+	startLeakSampling = func(device ios.DeviceEntry, bundleID string) (leakSampler, error) {
+		if bundleID != "com.example.app" {
+			return nil, errors.New("app is not installed")
+		}
+		return sampler, nil
+	}
+	store := newXCUITestStore()
+	store.run = func(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		return nil, nil
+	}
+
+	info, err := store.start(testDevice("leak-udid"), XCUITestRequest{BundleID: "com.example.app", LeakCheck: &perfmon.LeakThresholds{MaxGrowthPerMinute: 1 << 20}})
+	require.NoError(t, err)
+	session, _ := store.get("leak-udid", info.ID)
+	ended := waitForXCUITest(t, session)
+	require.NotNil(t, ended.Leaks)
+	assert.True(t, ended.Leaks.Leaking)
+	assert.Equal(t, perfmon.LeakThresholds{MaxGrowthPerMinute: 1 << 20}, sampler.thresholds)
+
+	info, err = store.start(testDevice("leak-udid"), XCUITestRequest{BundleID: "com.example.missing", LeakCheck: &perfmon.LeakThresholds{}})
+	require.NoError(t, err)
+	session, _ = store.get("leak-udid", info.ID)
+	ended = waitForXCUITest(t, session)
+	assert.Equal(t, XCUITestFinished, ended.State, "a leak check that can't start does not fail the run")
+	assert.Nil(t, ended.Leaks)
+
+	info, err = store.start(testDevice("leak-udid"), XCUITestRequest{BundleID: "com.example.app"})
+	require.NoError(t, err)
+	session, _ = store.get("leak-udid", info.ID)
+	assert.Nil(t, waitForXCUITest(t, session).Leaks)
+}
+
+func TestXCUITestCrashLoop(t *testing.T) {
+	xcuitestStateFile = filepath.Join(t.TempDir(), "sessions.json")
+	originalWatch, originalList, originalRead := watchCrashLoop, listCrashReports, readCrashReport
+	t.Cleanup(func() { watchCrashLoop, listCrashReports, readCrashReport = originalWatch, originalList, originalRead })
+	report := crashreport.Report{Name: "Example-2024-01-16-153643.ips", BundleID: "com.example.app", Modified: time.Now()}
+	watchCrashLoop = func(ctx context.Context, device ios.DeviceEntry, bundleID string, thresholds crashreport.LoopThresholds) (*crashreport.CrashLoop, error) {
+		if bundleID == "com.example.stable" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &crashreport.CrashLoop{BundleID: bundleID, Reports: []crashreport.Report{report}, Verdict: "crash loop", Thresholds: thresholds}, nil
+	}
+	listCrashReports = func(device ios.DeviceEntry, filter crashreport.Filter) ([]crashreport.Report, error) {
+		return []crashreport.Report{report}, nil
+	}
+	readCrashReport = func(device ios.DeviceEntry, name string, w io.Writer) error {
+		_, err := w.Write([]byte("crash"))
+		return err
+	}
+	store := newXCUITestStore()
+	store.run = func(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		if request.BundleID == "com.example.stable" {
+			return nil, nil
+		}
+		// the tests wait for the app until they time out
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	info, err := store.start(testDevice("loop-udid"), XCUITestRequest{BundleID: "com.example.app", CrashLoop: &crashreport.LoopThresholds{Crashes: 2}})
+	require.NoError(t, err)
+	session, _ := store.get("loop-udid", info.ID)
+	ended := waitForXCUITest(t, session)
+	assert.Equal(t, XCUITestFailed, ended.State)
+	assert.Equal(t, "crash loop", ended.Error)
+	require.NotNil(t, ended.CrashLoop)
+	assert.Equal(t, 2, ended.CrashLoop.Thresholds.Crashes)
+	assert.Equal(t, []string{report.Name}, ended.Crashes, "the crash reports are collected")
+
+	info, err = store.start(testDevice("loop-udid"), XCUITestRequest{BundleID: "com.example.stable", CrashLoop: &crashreport.LoopThresholds{}})
+	require.NoError(t, err)
+	session, _ = store.get("loop-udid", info.ID)
+	ended = waitForXCUITest(t, session)
+	assert.Equal(t, XCUITestFinished, ended.State)
+	assert.Nil(t, ended.CrashLoop)
+	assert.Empty(t, ended.Crashes)
+}
+
+func TestXCUITestSecrets(t *testing.T) {
+	xcuitestStateFile = filepath.Join(t.TempDir(), "sessions.json")
+	original := resolveSecret
+	t.Cleanup(func() { resolveSecret = original })
+	resolveSecret = func(ref string) (string, error) {
+		if ref == "vault:secret/ci/account#password" {
+			return "hunter2=", nil
+		}
+		return "", errors.New("permission denied")
+	}
+	store := newXCUITestStore()
+	var env []string
+	store.run = func(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		env = request.Env
+		listener.LogMessage("typing hunter2= into the password field\n")
+		testCase := testmanagerd.TestCase{ClassName: "LoginTests", MethodName: "testLogin", Status: testmanagerd.StatusFailed,
+			Err: testmanagerd.TestError{Message: "wrong password hunter2="}}
+		listener.Events.TestCaseFinished(testCase)
+		return []testmanagerd.TestSuite{{Name: "LoginTests", TestCases: []testmanagerd.TestCase{testCase}}}, errors.New("login with hunter2= failed")
+	}
+	request := XCUITestRequest{BundleID: "com.example.app", Env: []string{"LANG=de"}, Secrets: map[string]string{"TEST_PASSWORD": "vault:secret/ci/account#password"}}
+
+	info, err := store.start(testDevice("secrets-udid"), request)
+	require.NoError(t, err)
+	session, _ := store.get("secrets-udid", info.ID)
+	ended := waitForXCUITest(t, session)
+	assert.Equal(t, []string{"LANG=de", "TEST_PASSWORD=hunter2="}, env)
+	assert.Equal(t, []string{"LANG=de"}, request.Env, "the request keeps no secrets")
+	assert.Equal(t, "login with *** failed", ended.Error)
+	events, _, _ := session.eventsFrom(0)
+	b, err := json.Marshal(events[0].data)
+	require.NoError(t, err)
+	assert.Equal(t, `"typing *** into the password field"`, string(b))
+	testCase := events[1].data.(testmanagerd.TestCase)
+	assert.Equal(t, "wrong password ***", testCase.Err.Message)
+	b, err = json.Marshal(ended)
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "hunter2")
+
+	info, err = store.start(testDevice("secrets-udid"), XCUITestRequest{BundleID: "com.example.app", Secrets: map[string]string{"TOKEN": "vault:secret/ci/other#token"}})
+	require.NoError(t, err)
+	session, _ = store.get("secrets-udid", info.ID)
+	ended = waitForXCUITest(t, session)
+	assert.Equal(t, XCUITestFailed, ended.State)
+	assert.Equal(t, "failed resolving secret TOKEN: permission denied", ended.Error)
+}
+
+func TestXCUITestTemplates(t *testing.T) {
+	xcuitestStateFile = filepath.Join(t.TempDir(), "sessions.json")
+	store := newXCUITestStore()
+	var ran XCUITestRequest
+	store.run = func(ctx context.Context, device ios.DeviceEntry, request XCUITestRequest, listener *testmanagerd.TestListener) ([]testmanagerd.TestSuite, error) {
+		ran = request
+		return nil, nil
+	}
+	request := XCUITestRequest{
+		BundleID:     "com.example.app",
+		XCTestConfig: "{{udid}}.xctest",
+		Args:         []string{"--out={{artifact_dir}}/{{job_id}}"},
+		Env:          []string{"USE_PORT=8200", "WDA={{wda_port}}", "KEEP={{unknown}}"},
+	}
+
+	info, err := store.start(testDevice("template-udid"), request)
+	require.NoError(t, err)
+	session, _ := store.get("template-udid", info.ID)
+	waitForXCUITest(t, session)
+	assert.Equal(t, "template-udid.xctest", ran.XCTestConfig)
+	require.Len(t, ran.Args, 1)
+	assert.Regexp(t, "^--out=.+/"+info.ID+"$", ran.Args[0])
+	assert.NotContains(t, ran.Args[0], "{{artifact_dir}}")
+	assert.Equal(t, []string{"USE_PORT=8200", "WDA=8200", "KEEP={{unknown}}"}, ran.Env)
+	assert.Equal(t, "{{udid}}.xctest", request.XCTestConfig, "the request keeps its templates")
+}
+
+func TestValidateSecretRefs(t *testing.T) {
+	assert.NoError(t, validateSecretRefs(nil))
+	assert.NoError(t, validateSecretRefs(map[string]string{"TEST_PASSWORD": "vault:secret/ci/account#password", "API_TOKEN": "env:GO_IOS_SECRET_CI_API_TOKEN"}))
+	assert.Error(t, validateSecretRefs(map[string]string{"TEST PASSWORD": "env:GO_IOS_SECRET_CI_API_TOKEN"}))
+	assert.Error(t, validateSecretRefs(map[string]string{"API_TOKEN": "env:VAULT_TOKEN"}), "only GO_IOS_SECRET_ variables can be read")
+	assert.Error(t, validateSecretRefs(map[string]string{"TEST_PASSWORD": "hunter2"}))
+}
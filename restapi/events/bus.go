@@ -0,0 +1,90 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBufferSize is how many events a subscriber can fall behind before it misses events
+const DefaultBufferSize = 256
+
+// Bus delivers published events to all subscribers whose filter matches. Publishing never blocks, slow
+// subscribers miss events instead.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[int]*subscriber
+	nextID      int
+}
+
+type subscriber struct {
+	filter  Filter
+	events  chan Event
+	dropped atomic.Int64
+}
+
+// NewBus creates a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subscribers: map[int]*subscriber{}}
+}
+
+// Subscription receives the events of a filter
+type Subscription struct {
+	// Events is closed when the subscription is closed
+	Events <-chan Event
+	bus    *Bus
+	id     int
+	sub    *subscriber
+}
+
+// Subscribe returns a subscription receiving the events published from now on that match filter.
+// bufferSize is how many events it can fall behind, use DefaultBufferSize if unsure.
+func (b *Bus) Subscribe(filter Filter, bufferSize int) *Subscription {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	sub := &subscriber{filter: filter, events: make(chan Event, bufferSize)}
+	b.subscribers[id] = sub
+	return &Subscription{Events: sub.events, bus: b, id: id, sub: sub}
+}
+
+// Subscribers is the number of open subscriptions
+func (b *Bus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// Dropped is how many events the subscription missed because it fell behind
+func (s *Subscription) Dropped() int64 {
+	return s.sub.dropped.Load()
+}
+
+// Close unsubscribes and closes Events, it can be called more than once
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subscribers[s.id]; ok {
+		delete(s.bus.subscribers, s.id)
+		close(s.sub.events)
+	}
+}
+
+// Publish delivers the event to the matching subscribers
+func (b *Bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sub := range b.subscribers {
+		if !sub.filter.Match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Package events is the event bus of the go-ios agent. The REST API publishes every device event on it, like
+// devices being attached, jobs finishing or xcuitest sessions ending. Programs embedding the agent can subscribe
+// to it in-process instead of polling the REST API or receiving webhooks.
+package events
+
+import (
+	"strings"
+	"time"
+)
+
+// Type is the type of an event
+type Type string
+
+const (
+	DeviceAdded   = Type("device-added")
+	DeviceRemoved = Type("device-removed")
+	DeviceUpdated = Type("device-updated")
+	DevicePaired  = Type("device-paired")
+	// PairingInvalid means the device does not accept the pair record anymore, it is paired again
+	PairingInvalid = Type("pairing-invalid")
+	Repaired       = Type("repaired")
+	RepairFailed   = Type("repair-failed")
+
+	ImageMounted    = Type("image-mounted")
+	RebootRequested = Type("reboot-requested")
+	// BootCompleted means a device came back after a reboot was requested
+	BootCompleted = Type("boot-completed")
+
+	JobRunning   = Type("job-running")
+	JobSucceeded = Type("job-succeeded")
+	JobFailed    = Type("job-failed")
+	JobCanceled  = Type("job-canceled")
+	// HookFailed means a hook configured to run before or after a job failed, Job is the job it ran for
+	HookFailed = Type("hook-failed")
+
+	TestFinished = Type("xcuitest-finished")
+	// CrashLoop means the app under test of an xcuitest session crashed repeatedly and the session was failed
+	CrashLoop = Type("crash-loop")
+
+	SessionRecording = Type("session-recording")
+	DriftDetected    = Type("drift-detected")
+	// OSUpdateRequired means the os version of a device is outside of its golden state, go-ios can't install os
+	// updates, they have to be installed with an MDM or Apple Configurator
+	OSUpdateRequired = Type("os-update-required")
+	HealthCheck      = Type("healthcheck")
+	// Provisioned and ProvisioningFailed are sent when the provisioning steps run on an attached device finished
+	Provisioned        = Type("provisioned")
+	ProvisioningFailed = Type("provisioning-failed")
+
+	// SLOBreached and SLORecovered are sent when an objective of an agent operation is missed or met again,
+	// they belong to no device
+	SLOBreached  = Type("slo-breached")
+	SLORecovered = Type("slo-recovered")
+)
+
+// Event is something that happened to a device
+type Event struct {
+	Time    time.Time `json:"time"`
+	UDID    string    `json:"udid"`
+	Type    Type      `json:"type"`
+	Message string    `json:"message,omitempty"`
+	// Recording is the id of a session recording that finished with this event
+	Recording string `json:"recording,omitempty"`
+	// Job is set for the job events
+	Job *Job `json:"job,omitempty"`
+	// Test is set for TestFinished
+	Test *Test `json:"test,omitempty"`
+}
+
+// Job identifies the job of a job event
+type Job struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	State string `json:"state"`
+	// Error is why the job failed or was canceled
+	Error string `json:"error,omitempty"`
+}
+
+// Test is the outcome of a xcuitest session
+type Test struct {
+	SessionID string `json:"sessionId"`
+	BundleID  string `json:"bundleId"`
+	// State is finished, failed or stopped
+	State  string `json:"state"`
+	Passed int    `json:"passed"`
+	Failed int    `json:"failed"`
+}
+
+// Filter selects events, empty fields match all events
+type Filter struct {
+	UDIDs []string
+	Types []Type
+	// Prefixes match types by prefix, f.ex. "job-" for all job events
+	Prefixes []string
+}
+
+// Match reports whether the filter selects the event
+func (f Filter) Match(event Event) bool {
+	if len(f.UDIDs) > 0 && !contains(f.UDIDs, event.UDID) {
+		return false
+	}
+	if len(f.Types) == 0 && len(f.Prefixes) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == event.Type {
+			return true
+		}
+	}
+	for _, prefix := range f.Prefixes {
+		if strings.HasPrefix(string(event.Type), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
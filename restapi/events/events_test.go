@@ -0,0 +1,43 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	added := Event{UDID: "a", Type: DeviceAdded}
+	job := Event{UDID: "b", Type: JobFailed}
+	assert.True(t, Filter{}.Match(added))
+	assert.True(t, Filter{UDIDs: []string{"a"}}.Match(added))
+	assert.False(t, Filter{UDIDs: []string{"a"}}.Match(job))
+	assert.True(t, Filter{Types: []Type{DeviceAdded, DeviceRemoved}}.Match(added))
+	assert.False(t, Filter{Types: []Type{DeviceRemoved}}.Match(added))
+	assert.True(t, Filter{Prefixes: []string{"job-"}}.Match(job))
+	assert.False(t, Filter{Prefixes: []string{"job-"}}.Match(added))
+}
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	all := bus.Subscribe(Filter{}, DefaultBufferSize)
+	jobs := bus.Subscribe(Filter{Prefixes: []string{"job-"}}, 1)
+
+	bus.Publish(Event{UDID: "a", Type: DeviceAdded})
+	bus.Publish(Event{UDID: "a", Type: JobRunning})
+	bus.Publish(Event{UDID: "a", Type: JobSucceeded})
+
+	assert.Equal(t, DeviceAdded, (<-all.Events).Type)
+	assert.Equal(t, JobRunning, (<-all.Events).Type)
+	assert.Equal(t, JobRunning, (<-jobs.Events).Type)
+	assert.Equal(t, int64(1), jobs.Dropped(), "the job subscription has room for one event")
+	assert.Equal(t, int64(0), all.Dropped())
+
+	all.Close()
+	all.Close()
+	_, ok := <-all.Events
+	assert.True(t, ok, "buffered events can still be read")
+	_, ok = <-all.Events
+	assert.False(t, ok)
+	bus.Publish(Event{UDID: "a", Type: DeviceRemoved})
+}
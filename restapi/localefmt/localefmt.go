@@ -0,0 +1,246 @@
+// Package localefmt formats numbers, dates and times the way a device with the given locale settings shows them,
+// so localization tests can compute the expected strings on the host instead of keeping fixtures per locale.
+// Numbers use the CLDR data of golang.org/x/text, dates the CLDR short date patterns of common locales.
+package localefmt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// ErrUnsupported is returned for calendars and locales this package can not format dates for
+var ErrUnsupported = errors.New("not supported")
+
+// Settings are the effective locale settings of a device
+type Settings struct {
+	// Locale is the locale identifier of the device, f.ex. de_DE or th_TH@calendar=buddhist;numbers=thai
+	Locale   string `json:"locale"`
+	Language string `json:"language"`
+	// Tag is the BCP 47 tag of the locale, f.ex. th-TH-u-ca-buddhist-nu-thai
+	Tag    string `json:"tag"`
+	Region string `json:"region,omitempty"`
+	// Calendar is gregorian unless the locale selects another one
+	Calendar string `json:"calendar"`
+	// Numbers is the numbering system, latn unless the locale selects another one
+	Numbers           string `json:"numbers"`
+	DecimalSeparator  string `json:"decimalSeparator"`
+	GroupingSeparator string `json:"groupingSeparator"`
+	// ShortDate is the short date pattern with the symbols of Unicode TR35, f.ex. dd.MM.yy. It is empty if the
+	// locale is not supported.
+	ShortDate       string `json:"shortDate,omitempty"`
+	ShortTime       string `json:"shortTime,omitempty"`
+	Uses24HourClock bool   `json:"uses24HourClock"`
+
+	tag language.Tag
+}
+
+// keywords maps the keywords of iOS locale identifiers to BCP 47 unicode extension keys
+var keywords = map[string]string{"calendar": "ca", "numbers": "nu"}
+
+// zeroDigits are the zero digits of the supported numbering systems, the other digits follow it
+var zeroDigits = map[string]rune{
+	"latn": '0', "arab": '٠', "arabext": '۰', "beng": '০', "deva": '०', "fullwide": '０', "gujr": '૦', "guru": '੦',
+	"khmr": '០', "knda": '೦', "laoo": '໐', "mlym": '൦', "mymr": '၀', "orya": '୦', "tamldec": '௦', "telu": '౦', "thai": '๐',
+	"tibt": '༠',
+}
+
+// shortDates are the CLDR short date patterns by language and region, falling back to the language
+var shortDates = map[string]string{
+	"en": "M/d/yy", "en-GB": "dd/MM/y", "en-AU": "d/M/yy", "en-CA": "y-MM-dd", "en-IE": "dd/MM/y", "en-IN": "dd/MM/yy",
+	"de": "dd.MM.yy", "fr": "dd/MM/y", "fr-CA": "y-MM-dd", "es": "d/M/yy", "it": "dd/MM/yy", "nl": "dd-MM-y",
+	"pt": "dd/MM/y", "pt-PT": "dd/MM/yy", "sv": "y-MM-dd", "da": "dd.MM.y", "nb": "dd.MM.y", "fi": "d.M.y",
+	"pl": "d.MM.y", "ru": "dd.MM.y", "tr": "d.MM.y", "cs": "dd.MM.yy", "hi": "d/M/yy", "th": "d/M/yy",
+	"ja": "y/MM/dd", "zh": "y/M/d", "ko": "yy. M. d.",
+}
+
+// New returns the settings for an iOS locale identifier and language, f.ex. de_DE and de-DE
+func New(locale string, lang string, uses24HourClock bool) (Settings, error) {
+	settings := Settings{Locale: locale, Language: lang, Calendar: "gregorian", Numbers: "latn", Uses24HourClock: uses24HourClock}
+	base, params, _ := strings.Cut(locale, "@")
+	tag := strings.ReplaceAll(base, "_", "-")
+	var extensions []string
+	for _, param := range strings.Split(params, ";") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || keywords[key] == "" {
+			continue
+		}
+		extensions = append(extensions, keywords[key]+"-"+value)
+		switch key {
+		case "calendar":
+			settings.Calendar = value
+		case "numbers":
+			settings.Numbers = value
+		}
+	}
+	if len(extensions) > 0 {
+		tag += "-u-" + strings.Join(extensions, "-")
+	}
+	parsed, err := parseTag(tag)
+	if err != nil {
+		return Settings{}, fmt.Errorf("New: invalid locale '%s': %w", locale, err)
+	}
+	if _, ok := zeroDigits[settings.Numbers]; !ok {
+		return Settings{}, fmt.Errorf("New: numbering system '%s' is %w", settings.Numbers, ErrUnsupported)
+	}
+	settings.tag = parsed
+	settings.Tag = parsed.String()
+	if region, confidence := parsed.Region(); confidence == language.Exact {
+		settings.Region = region.String()
+	}
+	settings.GroupingSeparator, settings.DecimalSeparator = settings.separators()
+	settings.ShortDate = settings.shortDatePattern()
+	settings.ShortTime = "HH:mm"
+	if !uses24HourClock {
+		settings.ShortTime = ""
+		if base, _ := parsed.Base(); base.String() == "en" {
+			settings.ShortTime = "h:mm a"
+		}
+	}
+	return settings, nil
+}
+
+func parseTag(tag string) (language.Tag, error) {
+	if tag == "" {
+		return language.Und, errors.New("the locale is empty")
+	}
+	return language.Parse(tag)
+}
+
+// separators formats a number to find the separators the locale uses
+func (s Settings) separators() (string, string) {
+	if s.Numbers == "arab" || s.Numbers == "arabext" {
+		return "٬", "٫"
+	}
+	formatted := message.NewPrinter(s.tag).Sprint(number.Decimal(1234567.5, number.MinFractionDigits(1)))
+	group, decimal := ",", "."
+	if i, j := strings.Index(formatted, "1"), strings.Index(formatted, "234"); i >= 0 && j > i {
+		group = formatted[i+1 : j]
+	}
+	if i, j := strings.Index(formatted, "567"), strings.LastIndex(formatted, "5"); i >= 0 && j > i+3 {
+		decimal = formatted[i+3 : j]
+	}
+	return group, decimal
+}
+
+func (s Settings) shortDatePattern() string {
+	base, _ := s.tag.Base()
+	if s.Region != "" {
+		if pattern, ok := shortDates[base.String()+"-"+s.Region]; ok {
+			return pattern
+		}
+	}
+	return shortDates[base.String()]
+}
+
+// FormatNumber formats the number with the given number of fraction digits
+func (s Settings) FormatNumber(value float64, decimals int) string {
+	formatted := message.NewPrinter(s.tag).Sprint(number.Decimal(value, number.MinFractionDigits(decimals), number.MaxFractionDigits(decimals)))
+	return s.localDigits(formatted)
+}
+
+// FormatPercent formats the ratio as percentage without fraction digits, 0.25 is 25 %
+func (s Settings) FormatPercent(value float64) string {
+	return s.localDigits(message.NewPrinter(s.tag).Sprint(number.Percent(value)))
+}
+
+// localDigits replaces the latin digits and separators with the ones of the numbering system
+func (s Settings) localDigits(formatted string) string {
+	zero := zeroDigits[s.Numbers]
+	if zero == '0' || zero == 0 {
+		return formatted
+	}
+	var b strings.Builder
+	for _, r := range formatted {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(zero + r - '0')
+		case r == '.' && (s.Numbers == "arab" || s.Numbers == "arabext"):
+			b.WriteString("٫")
+		case r == ',' && (s.Numbers == "arab" || s.Numbers == "arabext"):
+			b.WriteString("٬")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// FormatDate formats the date with the short date pattern of the locale, in the location of t
+func (s Settings) FormatDate(t time.Time) (string, error) {
+	if s.ShortDate == "" {
+		return "", fmt.Errorf("FormatDate: dates of locale '%s' are %w", s.Locale, ErrUnsupported)
+	}
+	year := t.Year()
+	switch s.Calendar {
+	case "gregorian", "iso8601":
+	case "buddhist":
+		year += 543
+	default:
+		return "", fmt.Errorf("FormatDate: calendar '%s' is %w", s.Calendar, ErrUnsupported)
+	}
+	return s.localDigits(formatPattern(s.ShortDate, year, int(t.Month()), t.Day(), t.Hour(), t.Minute())), nil
+}
+
+// FormatTime formats the hours and minutes of t with the short time pattern of the locale
+func (s Settings) FormatTime(t time.Time) (string, error) {
+	if s.ShortTime == "" {
+		return "", fmt.Errorf("FormatTime: 12 hour times of locale '%s' are %w", s.Locale, ErrUnsupported)
+	}
+	return s.localDigits(formatPattern(s.ShortTime, t.Year(), int(t.Month()), t.Day(), t.Hour(), t.Minute())), nil
+}
+
+// formatPattern formats a TR35 pattern with the fields y, M, d, H, h, m and a, text in single quotes is literal
+func formatPattern(pattern string, year, month, day, hour, minute int) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); {
+		r, size := utf8.DecodeRuneInString(pattern[i:])
+		if r == '\'' {
+			end := strings.IndexByte(pattern[i+1:], '\'')
+			if end < 0 {
+				end = len(pattern) - i - 1
+			}
+			b.WriteString(pattern[i+1 : i+1+end])
+			i += end + 2
+			continue
+		}
+		count := 1
+		for i+count < len(pattern) && pattern[i+count] == pattern[i] && r < utf8.RuneSelf {
+			count++
+		}
+		switch r {
+		case 'y':
+			if count == 2 {
+				fmt.Fprintf(&b, "%02d", year%100)
+			} else {
+				fmt.Fprintf(&b, "%0*d", count, year)
+			}
+		case 'M':
+			fmt.Fprintf(&b, "%0*d", count, month)
+		case 'd':
+			fmt.Fprintf(&b, "%0*d", count, day)
+		case 'H':
+			fmt.Fprintf(&b, "%0*d", count, hour)
+		case 'h':
+			fmt.Fprintf(&b, "%0*d", count, (hour+11)%12+1)
+		case 'm':
+			fmt.Fprintf(&b, "%0*d", count, minute)
+		case 'a':
+			if hour < 12 {
+				b.WriteString("AM")
+			} else {
+				b.WriteString("PM")
+			}
+		default:
+			b.WriteString(pattern[i : i+count*size])
+		}
+		i += count * size
+	}
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package localefmt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	settings, err := New("th_TH@calendar=buddhist;numbers=thai", "th", true)
+	require.NoError(t, err)
+	assert.Equal(t, "th-TH-u-ca-buddhist-nu-thai", settings.Tag)
+	assert.Equal(t, "TH", settings.Region)
+	assert.Equal(t, "buddhist", settings.Calendar)
+	assert.Equal(t, "thai", settings.Numbers)
+	assert.Equal(t, "d/M/yy", settings.ShortDate)
+
+	_, err = New("", "en", true)
+	assert.Error(t, err)
+	_, err = New("en_US@numbers=roman", "en", true)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestFormatNumber(t *testing.T) {
+	for locale, expected := range map[string]string{
+		"en_US":              "1,234,567.89",
+		"de_DE":              "1.234.567,89",
+		"fr_FR":              "1\u00a0234\u00a0567,89",
+		"en_IN":              "12,34,567.89",
+		"ar_SA@numbers=arab": "١٬٢٣٤٬٥٦٧٫٨٩",
+	} {
+		settings, err := New(locale, "en", true)
+		require.NoError(t, err)
+		assert.Equal(t, expected, settings.FormatNumber(1234567.891, 2), locale)
+	}
+	settings, err := New("de_DE", "de", true)
+	require.NoError(t, err)
+	assert.Equal(t, ",", settings.DecimalSeparator)
+	assert.Equal(t, ".", settings.GroupingSeparator)
+	assert.Equal(t, "25\u00a0%", settings.FormatPercent(0.25))
+}
+
+func TestFormatDate(t *testing.T) {
+	date := time.Date(2024, time.March, 5, 14, 7, 0, 0, time.UTC)
+	for locale, expected := range map[string]string{
+		"en_US":                   "3/5/24",
+		"en_GB":                   "05/03/2024",
+		"de_DE":                   "05.03.24",
+		"ja_JP":                   "2024/03/05",
+		"ko_KR":                   "24. 3. 5.",
+		"th_TH@calendar=buddhist": "5/3/67",
+	} {
+		settings, err := New(locale, "en", true)
+		require.NoError(t, err)
+		formatted, err := settings.FormatDate(date)
+		require.NoError(t, err)
+		assert.Equal(t, expected, formatted, locale)
+	}
+
+	settings, err := New("ja_JP@calendar=japanese", "ja", true)
+	require.NoError(t, err)
+	_, err = settings.FormatDate(date)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestFormatTime(t *testing.T) {
+	date := time.Date(2024, time.March, 5, 14, 7, 0, 0, time.UTC)
+	settings, err := New("en_US", "en", false)
+	require.NoError(t, err)
+	formatted, err := settings.FormatTime(date)
+	require.NoError(t, err)
+	assert.Equal(t, "2:07 PM", formatted)
+
+	settings, err = New("de_DE", "de", true)
+	require.NoError(t, err)
+	formatted, err = settings.FormatTime(date)
+	require.NoError(t, err)
+	assert.Equal(t, "14:07", formatted)
+
+	settings, err = New("de_DE", "de", false)
+	require.NoError(t, err)
+	_, err = settings.FormatTime(date)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}